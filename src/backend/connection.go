@@ -37,6 +37,7 @@ type Connection interface {
 	LastErr() error
 	UseDB(string) error
 	Kill(string) error
+	KillQuery(string) error
 	Recycle()
 	Address() string
 	SetTimestamp(int64)
@@ -237,6 +238,23 @@ func (c *connection) Kill(reason string) error {
 	return nil
 }
 
+// KillQuery used to kill the query running on the connection, the connection is kept.
+func (c *connection) KillQuery(reason string) error {
+	kill, err := c.pool.Get()
+	if err != nil {
+		return err
+	}
+	defer kill.Recycle()
+
+	c.log.Debug("conn[%s, ID:%v].query.be.killed.by[%v].reason[%s]", c.address, c.ID(), kill.ID(), reason)
+	query := fmt.Sprintf("KILL QUERY %d", c.connectionID)
+	if _, err = kill.Execute(query); err != nil {
+		c.log.Warning("conn[%s, ID:%v].kill.query.error:%+v", c.address, c.ID(), err)
+		return err
+	}
+	return nil
+}
+
 // Recycle used to put current to pool.
 func (c *connection) Recycle() {
 	defer mysqlStats.Record("conn.recycle", time.Now())
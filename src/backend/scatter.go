@@ -212,6 +212,11 @@ func (scatter *Scatter) BackendConfigsClone() []*config.BackendConfig {
 	return beConfigs
 }
 
+// TxnManager returns the txn manager.
+func (scatter *Scatter) TxnManager() *TxnManager {
+	return scatter.txnMgr
+}
+
 // CreateTransaction used to create a transaction.
 func (scatter *Scatter) CreateTransaction() (*Txn, error) {
 	return scatter.txnMgr.CreateTxn(scatter.PoolClone())
@@ -95,12 +95,19 @@ type Transaction interface {
 	CommitScatter() error
 	RollbackScatter() error
 	SetMultiStmtTxn()
+	IsTwoPC() bool
+	IsScatterXA() bool
 
 	SetTimeout(timeout int)
 	SetMaxResult(max int)
+	MaxResult() int
+	SetSpill(dir string, size int)
+	Spill() (string, int)
+	OnFinish(fn func())
 
 	Execute(req *xcontext.RequestContext) (*sqltypes.Result, error)
 	ExecuteRaw(database string, query string) (*sqltypes.Result, error)
+	ExecuteStreamCursors(req *xcontext.RequestContext) ([]driver.Rows, error)
 }
 
 // Txn tuple.
@@ -114,13 +121,17 @@ type Txn struct {
 	txnd              *TxnDetail
 	twopc             bool
 	isMultiStmtTxn    bool
+	isScatterXA       bool
 	start             time.Time
 	state             sync2.AtomicInt32
 	xaState           sync2.AtomicInt32
 	backends          map[string]*Pool
 	timeout           int
 	maxResult         int
+	spillDir          string
+	spillSize         int
 	errors            int
+	finishes          []func()
 	twopcConnections  map[string]Connection
 	normalConnections []Connection
 	twopcConnMu       sync.RWMutex
@@ -156,6 +167,44 @@ func (txn *Txn) SetMaxResult(max int) {
 	txn.maxResult = max
 }
 
+// MaxResult returns the txn max result, 0 means no limit.
+func (txn *Txn) MaxResult() int {
+	return txn.maxResult
+}
+
+// SetSpill used to set the dir and the memory size of the rows spilled to disk.
+func (txn *Txn) SetSpill(dir string, size int) {
+	txn.spillDir = dir
+	txn.spillSize = size
+}
+
+// Spill returns the spill dir and memory size, 0 size means the rows aren't spilled.
+func (txn *Txn) Spill() (string, int) {
+	return txn.spillDir, txn.spillSize
+}
+
+// OnFinish used to register the fn called when the txn is finished or aborted,
+// the fn is called at once if the txn is already done.
+func (txn *Txn) OnFinish(fn func()) {
+	txn.mu.Lock()
+	switch txn.state.Get() {
+	case int32(txnStateFinshing), int32(txnStateAborting):
+		txn.mu.Unlock()
+		fn()
+		return
+	}
+	txn.finishes = append(txn.finishes, fn)
+	txn.mu.Unlock()
+}
+
+// runFinishes calls the fns registered by OnFinish, the caller must hold txn.mu.
+func (txn *Txn) runFinishes() {
+	for _, fn := range txn.finishes {
+		fn()
+	}
+	txn.finishes = nil
+}
+
 // TxID returns txn id.
 func (txn *Txn) TxID() uint64 {
 	return txn.id
@@ -346,6 +395,10 @@ func (txn *Txn) Begin() error {
 // 2. XA PREPARE
 // 3. XA COMMIT
 func (txn *Txn) Commit() error {
+	// The XA is started on all the backends by BeginScatter.
+	if txn.isScatterXA {
+		return txn.CommitScatter()
+	}
 	txn.state.Set(int32(txnStateCommitting))
 
 	// Here, we only handle the write-txn.
@@ -371,6 +424,10 @@ func (txn *Txn) Commit() error {
 // Rollback used to rollback a XA transaction.
 // 1. XA ROLLBACK
 func (txn *Txn) Rollback() error {
+	// The XA is started on all the backends by BeginScatter.
+	if txn.isScatterXA {
+		return txn.RollbackScatter()
+	}
 	log := txn.log
 	txn.state.Set(int32(txnStateRollbacking))
 
@@ -395,20 +452,27 @@ func (txn *Txn) Rollback() error {
 	return nil
 }
 
-// BeginScatter used to start a XA transaction in the multiple-statement transaction
+// BeginScatter used to start a XA transaction on all the backends, in the multiple-statement
+// transaction or the single statement whose writes depend on its reads, such as the shard key
+// update. The statements are executed in the XA until it's committed or rolled back.
 func (txn *Txn) BeginScatter() error {
 	txnCounters.Add(txnCounterTxnBegin, 1)
 	txn.twopc = true
 
 	txn.req = xcontext.NewRequestContext()
 	txn.req.Mode = xcontext.ReqScatter
-	return txn.xaStart()
+	if err := txn.xaStart(); err != nil {
+		return err
+	}
+	txn.isScatterXA = true
+	return nil
 }
 
 // CommitScatter is used in the multiple-statement transaction
 func (txn *Txn) CommitScatter() error {
 	txn.state.Set(int32(txnStateCommitting))
 	txn.twopc = true
+	txn.isScatterXA = false
 	txn.req = xcontext.NewRequestContext()
 	txn.req.Mode = xcontext.ReqScatter
 
@@ -432,6 +496,7 @@ func (txn *Txn) RollbackScatter() error {
 	log := txn.log
 	txn.state.Set(int32(txnStateRollbacking))
 	txn.twopc = true
+	txn.isScatterXA = false
 	txn.req = xcontext.NewRequestContext()
 	txn.req.Mode = xcontext.ReqScatter
 
@@ -456,6 +521,17 @@ func (txn *Txn) SetMultiStmtTxn() {
 	txn.isMultiStmtTxn = true
 }
 
+// IsScatterXA returns true if the XA is started on all the backends by BeginScatter.
+func (txn *Txn) IsScatterXA() bool {
+	return txn.isScatterXA
+}
+
+// IsTwoPC returns true if the txn is in twopc mode, the connection of one backend
+// is shared by the executions, they must not run concurrently.
+func (txn *Txn) IsTwoPC() bool {
+	return txn.twopc
+}
+
 // ExecuteRaw used to execute raw query, txn not implemented.
 func (txn *Txn) ExecuteRaw(database string, query string) (*sqltypes.Result, error) {
 	return nil, fmt.Errorf("txn.ExecuteRaw.not.implemented")
@@ -473,7 +549,7 @@ func (txn *Txn) Execute(req *xcontext.RequestContext) (*sqltypes.Result, error)
 			defer txn.mgr.CommitRUnlock()
 		case xcontext.TxnWrite:
 			// write-txn xa starts to the single statement.
-			if !txn.isMultiStmtTxn {
+			if !txn.isMultiStmtTxn && !txn.isScatterXA {
 				if err := txn.xaStart(); err != nil {
 					return nil, err
 				}
@@ -713,23 +789,58 @@ func (txn *Txn) executeXA(req *xcontext.RequestContext, state txnXAState) error
 	return err
 }
 
-// ExecuteStreamFetch used to execute stream fetch query.
-func (txn *Txn) ExecuteStreamFetch(req *xcontext.RequestContext, callback func(*sqltypes.Result) error, streamBufferSize int) error {
-	var err error
+// streamRows is the stream cursor of the query on the conn. If the cursor is closed before
+// all the rows are read, the query is killed instead of reading the rest rows.
+type streamRows struct {
+	driver.Rows
+	conn  Connection
+	end   bool
+	timer *time.Timer
+}
+
+// Next implements the driver.Rows interface.
+func (r *streamRows) Next() bool {
+	if r.Rows.Next() {
+		return true
+	}
+	r.end = true
+	return false
+}
+
+// Close implements the driver.Rows interface.
+func (r *streamRows) Close() error {
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	if r.end {
+		return r.Rows.Close()
+	}
+	r.conn.KillQuery("stream.cursor.closed")
+	// The rows sent before the kill are drained, the error of the killed query is ignored.
+	r.Rows.Close()
+	return nil
+}
+
+// ExecuteStreamCursors used to execute the querys and returns the stream cursors,
+// the cursor i is the result of the req.Querys[i]. The cursors must be closed by
+// the caller, they're closed here if any query fails. The query of a cursor closed
+// before the end is killed, so the backend stops sending the rows, and the connection
+// is killed if the cursor isn't closed in the txn timeout.
+func (txn *Txn) ExecuteStreamCursors(req *xcontext.RequestContext) ([]driver.Rows, error) {
 	var mu sync.Mutex
 	var wg sync.WaitGroup
 
-	log := txn.log
-	cursors := make([]driver.Rows, 0, 8)
+	cursors := make([]driver.Rows, len(req.Querys))
 	allErrors := make([]error, 0, 8)
-
-	defer func() {
+	closeAll := func() {
 		for _, cursor := range cursors {
-			cursor.Close()
+			if cursor != nil {
+				cursor.Close()
+			}
 		}
-	}()
+	}
 
-	oneShard := func(c Connection, query string) {
+	oneShard := func(i int, c Connection, query string) {
 		defer wg.Done()
 		cursor, x := c.ExecuteStreamFetch(query)
 		if x != nil {
@@ -738,23 +849,50 @@ func (txn *Txn) ExecuteStreamFetch(req *xcontext.RequestContext, callback func(*
 			mu.Unlock()
 			return
 		}
-		mu.Lock()
-		cursors = append(cursors, cursor)
-		mu.Unlock()
+		rows := &streamRows{Rows: cursor, conn: c}
+		if txn.timeout > 0 {
+			rows.timer = time.AfterFunc(time.Duration(txn.timeout)*time.Millisecond, func() {
+				c.Kill("stream.cursor.timeout")
+			})
+		}
+		cursors[i] = rows
 	}
 
-	for _, qt := range req.Querys {
-		var conn Connection
-		if conn, err = txn.fetchOneConnection(qt.Backend); err != nil {
-			return err
+	for i, qt := range req.Querys {
+		conn, err := txn.fetchOneConnection(qt.Backend)
+		if err != nil {
+			wg.Wait()
+			closeAll()
+			return nil, err
 		}
 		wg.Add(1)
-		go oneShard(conn, qt.Query)
+		go oneShard(i, conn, qt.Query)
 	}
 	wg.Wait()
 	if len(allErrors) > 0 {
-		return allErrors[0]
+		closeAll()
+		return nil, allErrors[0]
+	}
+	return cursors, nil
+}
+
+// ExecuteStreamFetch used to execute stream fetch query.
+func (txn *Txn) ExecuteStreamFetch(req *xcontext.RequestContext, callback func(*sqltypes.Result) error, streamBufferSize int) error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	log := txn.log
+	allErrors := make([]error, 0, 8)
+
+	cursors, err := txn.ExecuteStreamCursors(req)
+	if err != nil {
+		return err
 	}
+	defer func() {
+		for _, cursor := range cursors {
+			cursor.Close()
+		}
+	}()
 
 	// Send Fields.
 	fields := cursors[0].Fields()
@@ -905,7 +1043,9 @@ func (txn *Txn) Finish() error {
 	defer func() {
 		txn.twopc = false
 		txn.isMultiStmtTxn = false
+		txn.isScatterXA = false
 	}()
+	defer txn.runFinishes()
 
 	// If the txn has aborted, we won't do finish.
 	if txn.state.Get() == int32(txnStateAborting) {
@@ -948,7 +1088,9 @@ func (txn *Txn) Abort() error {
 	defer func() {
 		txn.twopc = false
 		txn.isMultiStmtTxn = false
+		txn.isScatterXA = false
 	}()
+	defer txn.runFinishes()
 
 	// If the txn has finished, we won't do abort.
 	if txn.state.Get() == int32(txnStateFinshing) {
@@ -203,6 +203,60 @@ func TestTxnExecuteStreamFetch(t *testing.T) {
 	}
 }
 
+func TestTxnExecuteStreamCursors(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	fakedb, txnMgr, backends, addrs, cleanup := MockTxnMgr(log, 2)
+	defer cleanup()
+
+	querys := []xcontext.QueryTuple{
+		xcontext.QueryTuple{Query: "select * from node1", Backend: addrs[0]},
+		xcontext.QueryTuple{Query: "select * from node2", Backend: addrs[1]},
+	}
+	result := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "id",
+				Type: querypb.Type_INT32,
+			},
+		},
+	}
+	for i := 0; i < 10; i++ {
+		result.Rows = append(result.Rows, []sqltypes.Value{sqltypes.MakeTrusted(querypb.Type_INT32, []byte(fmt.Sprintf("%d", i)))})
+	}
+	fakedb.AddQueryStream(querys[0].Query, result)
+	fakedb.AddQueryStream(querys[1].Query, result)
+
+	txn, err := txnMgr.CreateTxn(backends)
+	assert.Nil(t, err)
+	defer txn.Finish()
+	txn.SetTimeout(10000)
+
+	rctx := &xcontext.RequestContext{
+		Querys: querys,
+	}
+	cursors, err := txn.ExecuteStreamCursors(rctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(cursors))
+
+	// The cursor read to the end isn't killed.
+	count := 0
+	for cursors[0].Next() {
+		count++
+	}
+	assert.Equal(t, 10, count)
+	err = cursors[0].Close()
+	assert.Nil(t, err)
+	kill := fmt.Sprintf("kill query %d", cursors[0].(*streamRows).conn.ID())
+	assert.Equal(t, 0, fakedb.GetQueryCalledNum(kill))
+
+	// The query of the cursor closed before the end is killed.
+	assert.True(t, cursors[1].Next())
+	err = cursors[1].Close()
+	assert.Nil(t, err)
+	kill = fmt.Sprintf("kill query %d", cursors[1].(*streamRows).conn.ID())
+	assert.Equal(t, 1, fakedb.GetQueryCalledNum(kill))
+}
+
 func TestTxnNormalError(t *testing.T) {
 	defer leaktest.Check(t)()
 	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
@@ -368,6 +422,41 @@ func TestTxnSetting(t *testing.T) {
 	}
 }
 
+func TestTxnOnFinish(t *testing.T) {
+	defer leaktest.Check(t)()
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	_, txnMgr, backends, _, cleanup := MockTxnMgr(log, 2)
+	defer cleanup()
+
+	// Finish.
+	{
+		var called int
+		txn, err := txnMgr.CreateTxn(backends)
+		assert.Nil(t, err)
+		txn.OnFinish(func() { called++ })
+		txn.OnFinish(func() { called++ })
+		assert.Equal(t, 0, called)
+		txn.Finish()
+		assert.Equal(t, 2, called)
+
+		// The fn is called at once after the txn finished.
+		txn.OnFinish(func() { called++ })
+		assert.Equal(t, 3, called)
+	}
+
+	// Abort.
+	{
+		var called int
+		txn, err := txnMgr.CreateTxn(backends)
+		assert.Nil(t, err)
+		txn.OnFinish(func() { called++ })
+		txn.Abort()
+		assert.Equal(t, 1, called)
+		txn.Finish()
+		assert.Equal(t, 1, called)
+	}
+}
+
 /*****************************************************************/
 /************************XA TESTS START***************************/
 /*****************************************************************/
@@ -1030,6 +1119,56 @@ func TestTxnBeginScatter(t *testing.T) {
 	}
 }
 
+func TestTxnBeginScatterSingleStmt(t *testing.T) {
+	defer leaktest.Check(t)()
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	fakedb, txnMgr, backends, addrs, cleanup := MockTxnMgr(log, 2)
+	defer cleanup()
+
+	querys := []xcontext.QueryTuple{
+		xcontext.QueryTuple{Query: "select for update", Backend: addrs[0]},
+		xcontext.QueryTuple{Query: "insert", Backend: addrs[1]},
+	}
+	fakedb.AddQuery(querys[0].Query, result1)
+	fakedb.AddQuery(querys[1].Query, result2)
+	fakedb.AddQueryPattern("XA .*", result1)
+
+	// The statements are executed in the XA started on all the backends.
+	for _, commit := range []bool{true, false} {
+		txn, err := txnMgr.CreateTxn(backends)
+		assert.Nil(t, err)
+		defer txn.Finish()
+
+		err = txn.Begin()
+		assert.Nil(t, err)
+		err = txn.BeginScatter()
+		assert.Nil(t, err)
+		xid := txn.XID()
+		assert.True(t, txn.IsScatterXA())
+		for i, mode := range []xcontext.TxnMode{xcontext.TxnRead, xcontext.TxnWrite} {
+			rctx := &xcontext.RequestContext{
+				Mode:    xcontext.ReqNormal,
+				TxnMode: mode,
+				Querys:  querys[i : i+1],
+			}
+			_, err = txn.Execute(rctx)
+			assert.Nil(t, err)
+		}
+		assert.Equal(t, xid, txn.XID())
+
+		if commit {
+			err = txn.Commit()
+			assert.Nil(t, err)
+			assert.Equal(t, int32(txnXAStateCommitFinished), txn.XaState())
+		} else {
+			err = txn.Rollback()
+			assert.Nil(t, err)
+			assert.Equal(t, int32(txnXAStateRollbackFinished), txn.XaState())
+		}
+		assert.False(t, txn.IsScatterXA())
+	}
+}
+
 func TestTxnCheckXidPrefix(t *testing.T) {
 	defer leaktest.Check(t)()
 	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
@@ -32,6 +32,11 @@ type ProxyConfig struct {
 	LongQueryTime    int    `json:"long-query-time"`
 	StreamBufferSize int    `json:"stream-buffer-size"`
 	IdleTxnTimeout   uint32 `json:"kill-idle-transaction"` //is consistent with the official 8.0 kill_idle_transaction
+	SpillDir         string `json:"spill-dir"`
+	SpillMemorySize  int    `json:"spill-memory-size"` // 0 means the rows aren't spilled to disk
+	Optimizer        string `json:"optimizer"`         // simple or cost
+	StatsTTL         int    `json:"stats-ttl"`         // seconds to cache the table statistics of the cost optimizer
+	PlanCacheSize    int    `json:"plan-cache-size"`   // 0 means the plans aren't cached
 }
 
 // DefaultProxyConfig returns default proxy config.
@@ -47,6 +52,11 @@ func DefaultProxyConfig() *ProxyConfig {
 		LongQueryTime:    5,                // 5 seconds
 		StreamBufferSize: 1024 * 1024 * 32, // 32MB
 		IdleTxnTimeout:   60,               // 60 seconds
+		SpillDir:         "/tmp/radon-spill",
+		SpillMemorySize:  0,
+		Optimizer:        "simple",
+		StatsTTL:         600, // 10 minutes
+		PlanCacheSize:    0,
 	}
 }
 
@@ -57,10 +67,22 @@ func (c *ProxyConfig) UnmarshalJSON(b []byte) error {
 	if err := json.Unmarshal(b, conf); err != nil {
 		return err
 	}
+	if err := CheckOptimizer(conf.Optimizer); err != nil {
+		return err
+	}
 	*c = ProxyConfig(*conf)
 	return nil
 }
 
+// CheckOptimizer used to check the optimizer is supported, simple or cost.
+func CheckOptimizer(optimizer string) error {
+	switch optimizer {
+	case "simple", "cost":
+		return nil
+	}
+	return errors.Errorf("unsupported: optimizer[%s].must.be.simple.or.cost", optimizer)
+}
+
 // AuditConfig tuple.
 type AuditConfig struct {
 	Mode        string `json:"mode"`
@@ -157,6 +179,8 @@ type PartitionConfig struct {
 	Table   string `json:"table"`
 	Segment string `json:"segment"`
 	Backend string `json:"backend"`
+	// Values is the shard key value set of the LIST partition.
+	Values []string `json:"values,omitempty"`
 }
 
 // AutoIncrement tuple.
@@ -173,6 +197,19 @@ type TableConfig struct {
 	ShardKey      string             `json:"shardkey"`
 	Partitions    []*PartitionConfig `json:"partitions"`
 	AutoIncrement *AutoIncrement     `json:"auto-increment,omitempty"`
+	// Interval is the period of the TIME partition, MONTH or DAY.
+	Interval string `json:"interval,omitempty"`
+	// TableGroup is the group of the co-located HASH tables.
+	TableGroup string `json:"table-group,omitempty"`
+	// HashFunc is the hash function of the HASH table, default is jump.
+	HashFunc string `json:"hash-func,omitempty"`
+	// ShardKeyTypes is the column types of the shard key columns, such as int or varchar,
+	// it's empty for the tables created before.
+	ShardKeyTypes []string `json:"shardkey-types,omitempty"`
+	// CanonicalDate is true if the DATE/DATETIME string keys of the HASH table are hashed in the
+	// canonical form, such as '2019-1-1' as '2019-01-01'. It's set for the tables created since,
+	// the tables created before keep the slots of their rows.
+	CanonicalDate bool `json:"canonical-date,omitempty"`
 }
 
 // SchemaConfig tuple.
@@ -286,3 +286,9 @@ func TestRouterConfigUnmarshalJSON(t *testing.T) {
 		assert.Equal(t, want, got)
 	}
 }
+
+func TestCheckOptimizer(t *testing.T) {
+	assert.Nil(t, CheckOptimizer("simple"))
+	assert.Nil(t, CheckOptimizer("cost"))
+	assert.EqualError(t, CheckOptimizer("rule"), "unsupported: optimizer[rule].must.be.simple.or.cost")
+}
@@ -43,6 +43,12 @@ func (admin *Admin) NewRouter() (rest.App, error) {
 		rest.Get("/v1/shard/globals", v1.GlobalsHandler(log, proxy)),
 		rest.Get("/v1/shard/balanceadvice", v1.ShardBalanceAdviceHandler(log, proxy)),
 		rest.Post("/v1/shard/shift", v1.ShardRuleShiftHandler(log, proxy)),
+		rest.Post("/v1/shard/split", v1.ShardSplitHandler(log, proxy)),
+		rest.Post("/v1/shard/merge", v1.ShardMergeHandler(log, proxy)),
+		rest.Post("/v1/shard/migrate", v1.ShardMigrateHandler(log, proxy)),
+		rest.Get("/v1/shard/jobs", v1.ShardJobsHandler(log, proxy)),
+		rest.Get("/v1/shard/jobs/:id", v1.ShardJobHandler(log, proxy)),
+		rest.Get("/v1/shard/verify", v1.ShardVerifyHandler(log, proxy)),
 		rest.Post("/v1/shard/reload", v1.ShardReLoadHandler(log, proxy)),
 
 		// meta
@@ -26,6 +26,7 @@ type radonParams struct {
 	AllowIP          []string `json:"allowip,omitempty"`
 	AuditMode        *string  `json:"audit-mode"`
 	StreamBufferSize *int     `json:"stream-buffer-size"`
+	Optimizer        *string  `json:"optimizer"`
 }
 
 // RadonConfigHandler impl.
@@ -46,6 +47,14 @@ func radonConfigHandler(log *xlog.Log, proxy *proxy.Proxy, w rest.ResponseWriter
 	}
 
 	log.Warning("api.v1.radon[from:%v].body:%+v", r.RemoteAddr, p)
+	// The optimizer is checked first, the config isn't changed if it's invalid.
+	if p.Optimizer != nil {
+		if err := proxy.SetOptimizer(*p.Optimizer); err != nil {
+			log.Error("api.v1.radon.config.error:%+v", err)
+			rest.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 	if p.MaxConnections != nil {
 		proxy.SetMaxConnections(*p.MaxConnections)
 	}
@@ -43,6 +43,7 @@ func TestCtlV1RadonConfig(t *testing.T) {
 			AllowIP          []string `json:"allowip,omitempty"`
 			AuditMode        string   `json:"audit-mode"`
 			StreamBufferSize int      `json:"stream-buffer-size"`
+			Optimizer        string   `json:"optimizer,omitempty"`
 		}
 
 		// 200.
@@ -55,6 +56,7 @@ func TestCtlV1RadonConfig(t *testing.T) {
 				AllowIP:          []string{"127.0.0.1", "127.0.0.2"},
 				AuditMode:        "A",
 				StreamBufferSize: 16777216,
+				Optimizer:        "cost",
 			}
 			recorded := test.RunRequest(t, handler, test.MakeSimpleRequest("PUT", "http://localhost/v1/radon/config", p))
 			recorded.CodeIs(200)
@@ -68,6 +70,7 @@ func TestCtlV1RadonConfig(t *testing.T) {
 			assert.Equal(t, []string{"127.0.0.1", "127.0.0.2"}, radonConf.Proxy.IPS)
 			assert.Equal(t, "A", radonConf.Audit.Mode)
 			assert.Equal(t, 16777216, radonConf.Proxy.StreamBufferSize)
+			assert.Equal(t, "cost", radonConf.Proxy.Optimizer)
 		}
 
 		// Unset AllowIP.
@@ -92,6 +95,7 @@ func TestCtlV1RadonConfig(t *testing.T) {
 			assert.Nil(t, radonConf.Proxy.IPS)
 			assert.Equal(t, "A", radonConf.Audit.Mode)
 			assert.Equal(t, 67108864, radonConf.Proxy.StreamBufferSize)
+			assert.Equal(t, "cost", radonConf.Proxy.Optimizer)
 		}
 	}
 }
@@ -122,6 +126,17 @@ func TestCtlV1RadonConfigError(t *testing.T) {
 			recorded := test.RunRequest(t, handler, test.MakeSimpleRequest("PUT", "http://localhost/v1/radon/config", nil))
 			recorded.CodeIs(500)
 		}
+
+		// 400, the invalid optimizer doesn't change the config.
+		{
+			p := map[string]interface{}{"max-connections": 1000, "optimizer": "rule"}
+			recorded := test.RunRequest(t, handler, test.MakeSimpleRequest("PUT", "http://localhost/v1/radon/config", p))
+			recorded.CodeIs(400)
+
+			radonConf := proxy.Config()
+			assert.Equal(t, 1024, radonConf.Proxy.MaxConnections)
+			assert.Equal(t, "simple", radonConf.Proxy.Optimizer)
+		}
 	}
 }
 
@@ -14,6 +14,7 @@ import (
 	"strconv"
 	"strings"
 
+	"backend"
 	"proxy"
 
 	"github.com/ant0ine/go-json-rest/rest"
@@ -242,6 +243,7 @@ type ruleParams struct {
 }
 
 // ShardRuleShiftHandler used to shift a partition rule to another backend.
+// Only the rule is changed, the table must be copied to the backend first, see ShardMigrateHandler.
 func ShardRuleShiftHandler(log *xlog.Log, proxy *proxy.Proxy) rest.HandlerFunc {
 	f := func(w rest.ResponseWriter, r *rest.Request) {
 		shardRuleShiftHandler(log, proxy, w, r)
@@ -276,27 +278,242 @@ func shardRuleShiftHandler(log *xlog.Log, proxy *proxy.Proxy, w rest.ResponseWri
 		}
 	}
 
+	fromBackend, toBackend := backendNames(scatter, p.FromAddress, p.ToAddress)
+	if fromBackend == "" || toBackend == "" {
+		log.Error("api.v1.shard.rule.fromBackend[%s].or.toBackend[%s].is.NULL", fromBackend, toBackend)
+		rest.Error(w, "api.v1.shard.rule.backend.NULL", http.StatusInternalServerError)
+		return
+	}
+
+	if err := router.PartitionRuleShift(fromBackend, toBackend, p.Database, p.Table); err != nil {
+		log.Error("api.v1.shard.rule.PartitionRuleShift.error:%+v", err)
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// backendNames returns the backend names of the from and to addresses.
+func backendNames(scatter *backend.Scatter, fromAddress string, toAddress string) (string, string) {
 	var fromBackend, toBackend string
 	backends := scatter.BackendConfigsClone()
 	for _, backend := range backends {
-		if backend.Address == p.FromAddress {
+		if backend.Address == fromAddress {
 			fromBackend = backend.Name
-		} else if backend.Address == p.ToAddress {
+		} else if backend.Address == toAddress {
 			toBackend = backend.Name
 		}
 	}
+	return fromBackend, toBackend
+}
+
+// ShardMigrateHandler used to start a job which moves the partition table to another backend,
+// the rows are copied and verified before the rule is shifted.
+func ShardMigrateHandler(log *xlog.Log, proxy *proxy.Proxy) rest.HandlerFunc {
+	f := func(w rest.ResponseWriter, r *rest.Request) {
+		shardMigrateHandler(log, proxy, w, r)
+	}
+	return f
+}
+
+func shardMigrateHandler(log *xlog.Log, proxy *proxy.Proxy, w rest.ResponseWriter, r *rest.Request) {
+	scatter := proxy.Scatter()
+	spanner := proxy.Spanner()
+	p := ruleParams{}
+	err := r.DecodeJsonPayload(&p)
+	if err != nil {
+		log.Error("api.v1.shard.migrate.parse.json.error:%+v", err)
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Warning("api.v1.shard.migrate[from:%v].request:%+v", r.RemoteAddr, p)
+
+	if p.Database == "" || p.Table == "" {
+		rest.Error(w, "api.v1.shard.migrate.request.database.or.table.is.null", http.StatusInternalServerError)
+		return
+	}
+
+	for _, sysDB := range sysDBs {
+		if sysDB == strings.ToLower(p.Database) {
+			log.Error("api.v1.shard.migrate.database[%s].is.system", p.Database)
+			rest.Error(w, "api.v1.shard.migrate.database.can't.be.system.database", http.StatusInternalServerError)
+			return
+		}
+	}
 
+	fromBackend, toBackend := backendNames(scatter, p.FromAddress, p.ToAddress)
 	if fromBackend == "" || toBackend == "" {
-		log.Error("api.v1.shard.rule.fromBackend[%s].or.toBackend[%s].is.NULL", fromBackend, toBackend)
-		rest.Error(w, "api.v1.shard.rule.backend.NULL", http.StatusInternalServerError)
+		log.Error("api.v1.shard.migrate.fromBackend[%s].or.toBackend[%s].is.NULL", fromBackend, toBackend)
+		rest.Error(w, "api.v1.shard.migrate.backend.NULL", http.StatusInternalServerError)
 		return
 	}
 
-	if err := router.PartitionRuleShift(fromBackend, toBackend, p.Database, p.Table); err != nil {
-		log.Error("api.v1.shard.rule.PartitionRuleShift.error:%+v", err)
+	id, err := spanner.ShiftJobs().Start(p.Database, p.Table, fromBackend, toBackend)
+	if err != nil {
+		log.Error("api.v1.shard.migrate.start.error:%+v", err)
 		rest.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	type job struct {
+		ID uint64 `json:"id"`
+	}
+	w.WriteJson(&job{ID: id})
+}
+
+// ShardJobsHandler used to get the migrate, split and merge jobs.
+func ShardJobsHandler(log *xlog.Log, proxy *proxy.Proxy) rest.HandlerFunc {
+	f := func(w rest.ResponseWriter, r *rest.Request) {
+		shardJobsHandler(log, proxy, w, r)
+	}
+	return f
+}
+
+func shardJobsHandler(log *xlog.Log, proxy *proxy.Proxy, w rest.ResponseWriter, r *rest.Request) {
+	w.WriteJson(proxy.Spanner().ShiftJobs().Jobs())
+}
+
+// ShardJobHandler used to get the job by id.
+func ShardJobHandler(log *xlog.Log, proxy *proxy.Proxy) rest.HandlerFunc {
+	f := func(w rest.ResponseWriter, r *rest.Request) {
+		shardJobHandler(log, proxy, w, r)
+	}
+	return f
+}
+
+func shardJobHandler(log *xlog.Log, proxy *proxy.Proxy, w rest.ResponseWriter, r *rest.Request) {
+	id, err := strconv.ParseUint(r.PathParam("id"), 10, 64)
+	if err != nil {
+		rest.Error(w, "api.v1.shard.job.id.invalid", http.StatusBadRequest)
+		return
+	}
+	job, ok := proxy.Spanner().ShiftJobs().Job(id)
+	if !ok {
+		rest.Error(w, fmt.Sprintf("api.v1.shard.job[%d].not.found", id), http.StatusNotFound)
+		return
+	}
+	w.WriteJson(job)
+}
+
+// ShardVerifyHandler used to verify the router against the backends.
+func ShardVerifyHandler(log *xlog.Log, proxy *proxy.Proxy) rest.HandlerFunc {
+	f := func(w rest.ResponseWriter, r *rest.Request) {
+		shardVerifyHandler(log, proxy, w, r)
+	}
+	return f
+}
+
+func shardVerifyHandler(log *xlog.Log, proxy *proxy.Proxy, w rest.ResponseWriter, r *rest.Request) {
+	database := r.URL.Query().Get("database")
+	log.Warning("api.v1.shard.verify[from:%v].database[%s]", r.RemoteAddr, database)
+
+	report, err := proxy.Spanner().Verify(database)
+	if err != nil {
+		log.Error("api.v1.shard.verify.error:%+v", err)
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteJson(report)
+}
+
+type splitParams struct {
+	Database string `json:"database"`
+	Table    string `json:"table"`
+}
+
+// ShardSplitHandler used to start a job which splits a hash partition into two new partitions online.
+func ShardSplitHandler(log *xlog.Log, proxy *proxy.Proxy) rest.HandlerFunc {
+	f := func(w rest.ResponseWriter, r *rest.Request) {
+		shardSplitHandler(log, proxy, w, r)
+	}
+	return f
+}
+
+func shardSplitHandler(log *xlog.Log, proxy *proxy.Proxy, w rest.ResponseWriter, r *rest.Request) {
+	spanner := proxy.Spanner()
+	p := splitParams{}
+	err := r.DecodeJsonPayload(&p)
+	if err != nil {
+		log.Error("api.v1.shard.split.parse.json.error:%+v", err)
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Warning("api.v1.shard.split[from:%v].request:%+v", r.RemoteAddr, p)
+
+	if p.Database == "" || p.Table == "" {
+		rest.Error(w, "api.v1.shard.split.request.database.or.table.is.null", http.StatusInternalServerError)
+		return
+	}
+
+	for _, sysDB := range sysDBs {
+		if sysDB == strings.ToLower(p.Database) {
+			log.Error("api.v1.shard.split.database[%s].is.system", p.Database)
+			rest.Error(w, "api.v1.shard.split.database.can't.be.system.database", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	id, err := spanner.PartitionSplit(p.Database, p.Table)
+	if err != nil {
+		log.Error("api.v1.shard.split.error:%+v", err)
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	type job struct {
+		ID uint64 `json:"id"`
+	}
+	w.WriteJson(&job{ID: id})
+}
+
+type mergeParams struct {
+	Database string `json:"database"`
+	Left     string `json:"left"`
+	Right    string `json:"right"`
+}
+
+// ShardMergeHandler used to start a job which merges two adjacent hash partitions into one new partition online.
+func ShardMergeHandler(log *xlog.Log, proxy *proxy.Proxy) rest.HandlerFunc {
+	f := func(w rest.ResponseWriter, r *rest.Request) {
+		shardMergeHandler(log, proxy, w, r)
+	}
+	return f
+}
+
+func shardMergeHandler(log *xlog.Log, proxy *proxy.Proxy, w rest.ResponseWriter, r *rest.Request) {
+	spanner := proxy.Spanner()
+	p := mergeParams{}
+	err := r.DecodeJsonPayload(&p)
+	if err != nil {
+		log.Error("api.v1.shard.merge.parse.json.error:%+v", err)
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Warning("api.v1.shard.merge[from:%v].request:%+v", r.RemoteAddr, p)
+
+	if p.Database == "" || p.Left == "" || p.Right == "" {
+		rest.Error(w, "api.v1.shard.merge.request.database.or.table.is.null", http.StatusInternalServerError)
+		return
+	}
+
+	for _, sysDB := range sysDBs {
+		if sysDB == strings.ToLower(p.Database) {
+			log.Error("api.v1.shard.merge.database[%s].is.system", p.Database)
+			rest.Error(w, "api.v1.shard.merge.database.can't.be.system.database", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	id, err := spanner.PartitionMerge(p.Database, p.Left, p.Right)
+	if err != nil {
+		log.Error("api.v1.shard.merge.error:%+v", err)
+		rest.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	type job struct {
+		ID uint64 `json:"id"`
+	}
+	w.WriteJson(&job{ID: id})
 }
 
 // ShardReLoadHandler impl.
@@ -342,7 +559,7 @@ func globalsHandler(log *xlog.Log, proxy *proxy.Proxy, w rest.ResponseWriter, r
 	for _, schema := range router.Schemas {
 		var tables []string
 		for _, tb := range schema.Tables {
-			if tb.ShardKey == "" {
+			if tb.TableConfig.ShardType == "GLOBAL" {
 				tables = append(tables, tb.Name)
 			}
 		}
@@ -9,9 +9,11 @@
 package v1
 
 import (
+	"errors"
 	"router"
 	"strings"
 	"testing"
+	"time"
 
 	"proxy"
 
@@ -1044,6 +1046,350 @@ func TestCtlV1ShardRuleShiftError(t *testing.T) {
 	}
 }
 
+func TestCtlV1ShardSplitMerge(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	fakedbs, proxy, cleanup := proxy.MockProxy(log)
+	defer cleanup()
+	address := proxy.Address()
+
+	r1 := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "Table",
+				Type: querypb.Type_VARCHAR,
+			},
+			{
+				Name: "Create Table",
+				Type: querypb.Type_VARCHAR,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("t1_0000")),
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("CREATE TABLE `t1_0000` (`id` int, `b` int) ENGINE=InnoDB")),
+			},
+		},
+	}
+	r2 := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "id",
+				Type: querypb.Type_INT32,
+			},
+			{
+				Name: "b",
+				Type: querypb.Type_INT32,
+			},
+		},
+	}
+
+	// fakedbs.
+	{
+		fakedbs.AddQueryPattern("create .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("SHOW CREATE TABLE .*", r1)
+		fakedbs.AddQueryPattern("CREATE TABLE `test`.*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("DROP TABLE IF EXISTS .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("SHOW KEYS FROM .*", &sqltypes.Result{
+			Fields: []*querypb.Field{{Name: "Column_name", Type: querypb.Type_VARCHAR}},
+			Rows:   [][]sqltypes.Value{{sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("id"))}},
+		})
+		fakedbs.AddQueryPattern("SHOW COLUMNS FROM .*", &sqltypes.Result{
+			Fields: []*querypb.Field{{Name: "Field", Type: querypb.Type_VARCHAR}},
+			Rows:   [][]sqltypes.Value{{sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("id"))}},
+		})
+		fakedbs.AddQueryPattern("SELECT `id` FROM .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("SELECT COUNT\\(\\*\\), COALESCE.*", &sqltypes.Result{
+			Fields: []*querypb.Field{{Name: "count", Type: querypb.Type_INT64}, {Name: "checksum", Type: querypb.Type_INT64}},
+			Rows:   [][]sqltypes.Value{{sqltypes.MakeTrusted(querypb.Type_INT64, []byte("0")), sqltypes.MakeTrusted(querypb.Type_INT64, []byte("0"))}},
+		})
+		fakedbs.AddQueryStream("select * from test.t1_0000", r2)
+		fakedbs.AddQueryStream("select * from test.t1_0030", r2)
+		fakedbs.AddQueryStream("select * from test.t1_0031", r2)
+	}
+
+	// create database.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "create database test"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	// create test table.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "create table test.t1(id int, b int) partition by hash(id)"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	api := rest.NewApi()
+	router, _ := rest.MakeRouter(
+		rest.Post("/v1/shard/split", ShardSplitHandler(log, proxy)),
+		rest.Post("/v1/shard/merge", ShardMergeHandler(log, proxy)),
+		rest.Get("/v1/shard/jobs/:id", ShardJobHandler(log, proxy)),
+	)
+	api.SetApp(router)
+	handler := api.MakeHandler()
+
+	// Split.
+	{
+		p := &splitParams{
+			Database: "test",
+			Table:    "t1_0000",
+		}
+		recorded := test.RunRequest(t, handler, test.MakeSimpleRequest("POST", "http://localhost/v1/shard/split", p))
+		recorded.CodeIs(200)
+		assert.Equal(t, "{\"id\":1}", recorded.Recorder.Body.String())
+		waitJob(proxy, 1)
+
+		recorded = test.RunRequest(t, handler, test.MakeSimpleRequest("GET", "http://localhost/v1/shard/jobs/1", nil))
+		recorded.CodeIs(200)
+		assert.Contains(t, recorded.Recorder.Body.String(), "\"type\":\"split\",\"database\":\"test\",\"table\":\"t1\",\"from\":\"t1_0000\",\"to\":\"t1_0030,t1_0031\",\"state\":\"done\"")
+	}
+
+	// Merge.
+	{
+		p := &mergeParams{
+			Database: "test",
+			Left:     "t1_0030",
+			Right:    "t1_0031",
+		}
+		recorded := test.RunRequest(t, handler, test.MakeSimpleRequest("POST", "http://localhost/v1/shard/merge", p))
+		recorded.CodeIs(200)
+		assert.Equal(t, "{\"id\":2}", recorded.Recorder.Body.String())
+		waitJob(proxy, 2)
+
+		recorded = test.RunRequest(t, handler, test.MakeSimpleRequest("GET", "http://localhost/v1/shard/jobs/2", nil))
+		recorded.CodeIs(200)
+		assert.Contains(t, recorded.Recorder.Body.String(), "\"type\":\"merge\"")
+		assert.Contains(t, recorded.Recorder.Body.String(), "\"state\":\"done\"")
+	}
+
+	tconf, err := proxy.Router().TableConfig("test", "t1")
+	assert.Nil(t, err)
+	assert.Equal(t, "t1_0032", tconf.Partitions[len(tconf.Partitions)-1].Table)
+}
+
+// waitJob used to wait for the job to be done or failed.
+func waitJob(proxy *proxy.Proxy, id uint64) {
+	for i := 0; i < 500; i++ {
+		if job, _ := proxy.Spanner().ShiftJobs().Job(id); job.State != "running" {
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func TestCtlV1ShardSplitMergeError(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	_, proxy, cleanup := proxy.MockProxy(log)
+	defer cleanup()
+
+	api := rest.NewApi()
+	router, _ := rest.MakeRouter(
+		rest.Post("/v1/shard/split", ShardSplitHandler(log, proxy)),
+		rest.Post("/v1/shard/merge", ShardMergeHandler(log, proxy)),
+	)
+	api.SetApp(router)
+	handler := api.MakeHandler()
+
+	// Split.
+	{
+		tests := []struct {
+			p    *splitParams
+			want string
+		}{
+			{&splitParams{Database: "", Table: "t1_0000"}, "{\"Error\":\"api.v1.shard.split.request.database.or.table.is.null\"}"},
+			{&splitParams{Database: "mysql", Table: "t1_0000"}, "{\"Error\":\"api.v1.shard.split.database.can't.be.system.database\"}"},
+			{&splitParams{Database: "test", Table: "t1_0000"}, "{\"Error\":\"router.rule.cant.found.database:test\"}"},
+		}
+		for _, tt := range tests {
+			recorded := test.RunRequest(t, handler, test.MakeSimpleRequest("POST", "http://localhost/v1/shard/split", tt.p))
+			recorded.CodeIs(500)
+			assert.Equal(t, tt.want, recorded.Recorder.Body.String())
+		}
+	}
+
+	// Merge.
+	{
+		tests := []struct {
+			p    *mergeParams
+			want string
+		}{
+			{&mergeParams{Database: "test", Left: "t1_0000"}, "{\"Error\":\"api.v1.shard.merge.request.database.or.table.is.null\"}"},
+			{&mergeParams{Database: "sys", Left: "t1_0000", Right: "t1_0001"}, "{\"Error\":\"api.v1.shard.merge.database.can't.be.system.database\"}"},
+			{&mergeParams{Database: "test", Left: "t1_0000", Right: "t1_0001"}, "{\"Error\":\"router.rule.cant.found.database:test\"}"},
+		}
+		for _, tt := range tests {
+			recorded := test.RunRequest(t, handler, test.MakeSimpleRequest("POST", "http://localhost/v1/shard/merge", tt.p))
+			recorded.CodeIs(500)
+			assert.Equal(t, tt.want, recorded.Recorder.Body.String())
+		}
+	}
+}
+
+func TestCtlV1ShardMigrate(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	fakedbs, proxy, cleanup := proxy.MockProxy(log)
+	defer cleanup()
+	address := proxy.Address()
+
+	// fakedbs.
+	{
+		fakedbs.AddQueryPattern("create .*", &sqltypes.Result{})
+		fakedbs.AddQueryErrorPattern("show create table .*", errors.New("mock.show.create.table.error"))
+	}
+
+	// create database.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "create database test"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	// create test table.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "create table test.t1(id int, b int) partition by hash(id)"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	api := rest.NewApi()
+	router, _ := rest.MakeRouter(
+		rest.Post("/v1/shard/migrate", ShardMigrateHandler(log, proxy)),
+		rest.Get("/v1/shard/jobs", ShardJobsHandler(log, proxy)),
+		rest.Get("/v1/shard/jobs/:id", ShardJobHandler(log, proxy)),
+	)
+	api.SetApp(router)
+	handler := api.MakeHandler()
+
+	tconf, err := proxy.Router().TableConfig("test", "t1")
+	assert.Nil(t, err)
+	var from, to string
+	for _, bconf := range proxy.Scatter().BackendConfigsClone() {
+		if bconf.Name == tconf.Partitions[0].Backend {
+			from = bconf.Address
+		} else {
+			to = bconf.Address
+		}
+	}
+
+	// Errors.
+	{
+		tests := []struct {
+			p    *ruleParams
+			want string
+		}{
+			{&ruleParams{Database: "", Table: "t1_0000"}, "{\"Error\":\"api.v1.shard.migrate.request.database.or.table.is.null\"}"},
+			{&ruleParams{Database: "mysql", Table: "t1_0000"}, "{\"Error\":\"api.v1.shard.migrate.database.can't.be.system.database\"}"},
+			{&ruleParams{Database: "test", Table: "t1_0000", FromAddress: from}, "{\"Error\":\"api.v1.shard.migrate.backend.NULL\"}"},
+			{&ruleParams{Database: "test", Table: "t1_000x", FromAddress: from, ToAddress: to}, "{\"Error\":\"router.rule.cant.found.backend[" + tconf.Partitions[0].Backend + "]+table:[t1_000x]\"}"},
+		}
+		for _, tt := range tests {
+			recorded := test.RunRequest(t, handler, test.MakeSimpleRequest("POST", "http://localhost/v1/shard/migrate", tt.p))
+			recorded.CodeIs(500)
+			assert.Equal(t, tt.want, recorded.Recorder.Body.String())
+		}
+	}
+
+	// Start the job, it fails at the create step.
+	{
+		p := &ruleParams{Database: "test", Table: "t1_0000", FromAddress: from, ToAddress: to}
+		recorded := test.RunRequest(t, handler, test.MakeSimpleRequest("POST", "http://localhost/v1/shard/migrate", p))
+		recorded.CodeIs(200)
+		assert.Equal(t, "{\"id\":1}", recorded.Recorder.Body.String())
+
+		for i := 0; i < 100; i++ {
+			if job, _ := proxy.Spanner().ShiftJobs().Job(1); job.State != "running" {
+				break
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+		recorded = test.RunRequest(t, handler, test.MakeSimpleRequest("GET", "http://localhost/v1/shard/jobs/1", nil))
+		recorded.CodeIs(200)
+		assert.Contains(t, recorded.Recorder.Body.String(), "\"state\":\"failed\",\"step\":\"create\"")
+
+		recorded = test.RunRequest(t, handler, test.MakeSimpleRequest("GET", "http://localhost/v1/shard/jobs", nil))
+		recorded.CodeIs(200)
+		assert.Contains(t, recorded.Recorder.Body.String(), "\"id\":1")
+	}
+
+	// Job not found.
+	{
+		recorded := test.RunRequest(t, handler, test.MakeSimpleRequest("GET", "http://localhost/v1/shard/jobs/2", nil))
+		recorded.CodeIs(404)
+		recorded = test.RunRequest(t, handler, test.MakeSimpleRequest("GET", "http://localhost/v1/shard/jobs/x", nil))
+		recorded.CodeIs(400)
+	}
+}
+
+func TestCtlV1ShardVerify(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	fakedbs, proxy, cleanup := proxy.MockProxy(log)
+	defer cleanup()
+	address := proxy.Address()
+
+	// fakedbs.
+	{
+		fakedbs.AddQueryPattern("create .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("select .* from information_schema.columns .*", &sqltypes.Result{})
+	}
+
+	// create database.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "create database test"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	// create test table.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "create table test.t1(id int, b int) partition by hash(id)"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	api := rest.NewApi()
+	router, _ := rest.MakeRouter(
+		rest.Get("/v1/shard/verify", ShardVerifyHandler(log, proxy)),
+	)
+	api.SetApp(router)
+	handler := api.MakeHandler()
+
+	// All the partitions are missing.
+	{
+		recorded := test.RunRequest(t, handler, test.MakeSimpleRequest("GET", "http://localhost/v1/shard/verify?database=test", nil))
+		recorded.CodeIs(200)
+		got := recorded.Recorder.Body.String()
+		assert.True(t, strings.Contains(got, "\"tables\":1,\"partitions\":30,"))
+		assert.Equal(t, 30, strings.Count(got, "\"type\":\"missing\""))
+	}
+
+	// Errors.
+	{
+		recorded := test.RunRequest(t, handler, test.MakeSimpleRequest("GET", "http://localhost/v1/shard/verify?database=xx", nil))
+		recorded.CodeIs(500)
+		assert.Equal(t, "{\"Error\":\"verify.database[xx].cant.found\"}", recorded.Recorder.Body.String())
+
+		fakedbs.ResetAll()
+		fakedbs.AddQueryErrorPattern("select .* from information_schema.columns .*", errors.New("mock.verify.columns.error"))
+		recorded = test.RunRequest(t, handler, test.MakeSimpleRequest("GET", "http://localhost/v1/shard/verify", nil))
+		recorded.CodeIs(500)
+		assert.True(t, strings.Contains(recorded.Recorder.Body.String(), "mock.verify.columns.error"))
+	}
+}
+
 func TestCtlV1ShardReLoad(t *testing.T) {
 	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
 	fakedbs, proxy, cleanup := proxy.MockProxy(log)
@@ -1153,7 +1499,7 @@ func TestCtlV1Globals(t *testing.T) {
 	}
 
 	{
-		err := proxy.Router().AddForTest("sbtest", router.MockTableGConfig())
+		err := proxy.Router().AddForTest("sbtest", router.MockTableGConfig(), router.MockTableSConfig())
 		assert.Nil(t, err)
 		api := rest.NewApi()
 		router, _ := rest.MakeRouter(
@@ -0,0 +1,506 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package evaluation
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+)
+
+// Evaluation is the compiled expression, which is evaluated over the row in the proxy.
+type Evaluation interface {
+	Eval(row []sqltypes.Value) (sqltypes.Value, error)
+}
+
+// Resolver returns the index of the expr in the row, false if the expr isn't a column of the row.
+type Resolver func(expr sqlparser.Expr) (int, bool)
+
+// evalFunc is the Evaluation of a node.
+type evalFunc func(row []sqltypes.Value) (sqltypes.Value, error)
+
+// Eval used to evaluate the expression over the row.
+func (f evalFunc) Eval(row []sqltypes.Value) (sqltypes.Value, error) {
+	return f(row)
+}
+
+// Build used to compile the expr to the Evaluation. The resolver is asked first for every
+// node of the expr, so the columns, the aggregate functions and the other exprs computed by
+// the backends are read from the row. The evaluation follows the MySQL type coercion: the
+// integers are computed exactly, the decimals are computed as float64 with the scale, and
+// the strings are converted to the numbers by their numeric prefix.
+func Build(expr sqlparser.Expr, resolve Resolver) (Evaluation, error) {
+	fn, err := build(expr, resolve)
+	if err != nil {
+		return nil, err
+	}
+	return fn, nil
+}
+
+func build(expr sqlparser.Expr, resolve Resolver) (evalFunc, error) {
+	if idx, ok := resolve(expr); ok {
+		return func(row []sqltypes.Value) (sqltypes.Value, error) {
+			return row[idx], nil
+		}, nil
+	}
+
+	switch expr := expr.(type) {
+	case *sqlparser.SQLVal:
+		v, err := literal(expr)
+		if err != nil {
+			return nil, err
+		}
+		return constant(v), nil
+	case *sqlparser.NullVal:
+		return constant(sqltypes.NULL), nil
+	case sqlparser.BoolVal:
+		return constant(boolValue(bool(expr))), nil
+	case *sqlparser.ParenExpr:
+		return build(expr.Expr, resolve)
+	case *sqlparser.ColName:
+		return nil, errors.Errorf("unsupported: unknown.column.'%s'.in.evaluation", sqlparser.String(expr))
+	case *sqlparser.UnaryExpr:
+		return buildUnary(expr, resolve)
+	case *sqlparser.BinaryExpr:
+		return buildBinary(expr, resolve)
+	case *sqlparser.ComparisonExpr:
+		return buildComparison(expr, resolve)
+	case *sqlparser.AndExpr:
+		return buildLogic(expr.Left, expr.Right, and, resolve)
+	case *sqlparser.OrExpr:
+		return buildLogic(expr.Left, expr.Right, or, resolve)
+	case *sqlparser.NotExpr:
+		return buildNot(expr.Expr, resolve)
+	case *sqlparser.IsExpr:
+		return buildIs(expr, resolve)
+	case *sqlparser.RangeCond:
+		return buildRange(expr, resolve)
+	case *sqlparser.CaseExpr:
+		return buildCase(expr, resolve)
+	case *sqlparser.FuncExpr:
+		return buildFunc(expr, resolve)
+	}
+	return nil, errors.Errorf("unsupported: expr.'%s'.in.evaluation", sqlparser.String(expr))
+}
+
+// buildAll used to compile the exprs.
+func buildAll(exprs []sqlparser.Expr, resolve Resolver) ([]evalFunc, error) {
+	fns := make([]evalFunc, len(exprs))
+	for i, expr := range exprs {
+		fn, err := build(expr, resolve)
+		if err != nil {
+			return nil, err
+		}
+		fns[i] = fn
+	}
+	return fns, nil
+}
+
+// evalAll used to evaluate the exprs over the row.
+func evalAll(fns []evalFunc, row []sqltypes.Value) ([]sqltypes.Value, error) {
+	vals := make([]sqltypes.Value, len(fns))
+	for i, fn := range fns {
+		v, err := fn(row)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = v
+	}
+	return vals, nil
+}
+
+func constant(v sqltypes.Value) evalFunc {
+	return func(row []sqltypes.Value) (sqltypes.Value, error) {
+		return v, nil
+	}
+}
+
+// literal converts the SQLVal to the value, the number with the exponent is the double,
+// the other number with the decimal point is the decimal.
+func literal(val *sqlparser.SQLVal) (sqltypes.Value, error) {
+	switch val.Type {
+	case sqlparser.StrVal:
+		return sqltypes.MakeTrusted(sqltypes.VarChar, val.Val), nil
+	case sqlparser.IntVal, sqlparser.HexNum:
+		return sqltypes.NewIntegral(string(val.Val))
+	case sqlparser.FloatVal:
+		if strings.ContainsAny(string(val.Val), "eE") {
+			return sqltypes.NewValue(sqltypes.Float64, val.Val)
+		}
+		return sqltypes.NewValue(sqltypes.Decimal, val.Val)
+	case sqlparser.HexVal:
+		b, err := val.HexDecode()
+		if err != nil {
+			return sqltypes.NULL, err
+		}
+		return sqltypes.MakeTrusted(sqltypes.VarBinary, b), nil
+	}
+	return sqltypes.NULL, errors.Errorf("unsupported: expr.'%s'.in.evaluation", sqlparser.String(val))
+}
+
+func buildUnary(expr *sqlparser.UnaryExpr, resolve Resolver) (evalFunc, error) {
+	if expr.Operator == sqlparser.BangStr {
+		return buildNot(expr.Expr, resolve)
+	}
+	fn, err := build(expr.Expr, resolve)
+	if err != nil {
+		return nil, err
+	}
+	return func(row []sqltypes.Value) (sqltypes.Value, error) {
+		v, err := fn(row)
+		if err != nil || v.IsNull() {
+			return v, err
+		}
+		switch expr.Operator {
+		case sqlparser.UMinusStr:
+			return negate(toNumber(v)).value(), nil
+		case sqlparser.TildaStr:
+			return sqltypes.NewUint64(^toNumber(v).bits()), nil
+		}
+		return v, nil
+	}, nil
+}
+
+func buildBinary(expr *sqlparser.BinaryExpr, resolve Resolver) (evalFunc, error) {
+	left, err := build(expr.Left, resolve)
+	if err != nil {
+		return nil, err
+	}
+	right, err := build(expr.Right, resolve)
+	if err != nil {
+		return nil, err
+	}
+	return func(row []sqltypes.Value) (sqltypes.Value, error) {
+		v1, err := left(row)
+		if err != nil {
+			return sqltypes.NULL, err
+		}
+		v2, err := right(row)
+		if err != nil {
+			return sqltypes.NULL, err
+		}
+		if v1.IsNull() || v2.IsNull() {
+			return sqltypes.NULL, nil
+		}
+		return arithmetic(expr.Operator, toNumber(v1), toNumber(v2))
+	}, nil
+}
+
+func buildComparison(expr *sqlparser.ComparisonExpr, resolve Resolver) (evalFunc, error) {
+	left, err := build(expr.Left, resolve)
+	if err != nil {
+		return nil, err
+	}
+
+	switch expr.Operator {
+	case sqlparser.InStr, sqlparser.NotInStr:
+		tuple, ok := expr.Right.(sqlparser.ValTuple)
+		if !ok {
+			return nil, errors.Errorf("unsupported: expr.'%s'.in.evaluation", sqlparser.String(expr))
+		}
+		items, err := buildAll(tuple, resolve)
+		if err != nil {
+			return nil, err
+		}
+		return func(row []sqltypes.Value) (sqltypes.Value, error) {
+			v, err := left(row)
+			if err != nil || v.IsNull() {
+				return sqltypes.NULL, err
+			}
+			vals, err := evalAll(items, row)
+			if err != nil {
+				return sqltypes.NULL, err
+			}
+			// The result is NULL if there's no match but a NULL in the list.
+			res := False
+			for _, val := range vals {
+				if val.IsNull() {
+					res = Unknown
+					continue
+				}
+				if sqltypes.NullsafeCompare(v, val) == 0 {
+					res = True
+					break
+				}
+			}
+			if expr.Operator == sqlparser.NotInStr {
+				res = res.not()
+			}
+			return res.value(), nil
+		}, nil
+	case sqlparser.LikeStr, sqlparser.NotLikeStr, sqlparser.RegexpStr, sqlparser.NotRegexpStr:
+		return buildMatch(expr, left, resolve)
+	case sqlparser.JSONExtractOp, sqlparser.JSONUnquoteExtractOp:
+		return nil, errors.Errorf("unsupported: expr.'%s'.in.evaluation", sqlparser.String(expr))
+	}
+
+	right, err := build(expr.Right, resolve)
+	if err != nil {
+		return nil, err
+	}
+	return func(row []sqltypes.Value) (sqltypes.Value, error) {
+		v1, err := left(row)
+		if err != nil {
+			return sqltypes.NULL, err
+		}
+		v2, err := right(row)
+		if err != nil {
+			return sqltypes.NULL, err
+		}
+		if expr.Operator == sqlparser.NullSafeEqualStr {
+			return boolValue(sqltypes.NullsafeCompare(v1, v2) == 0), nil
+		}
+		if v1.IsNull() || v2.IsNull() {
+			return sqltypes.NULL, nil
+		}
+		cmp := sqltypes.NullsafeCompare(v1, v2)
+		switch expr.Operator {
+		case sqlparser.EqualStr:
+			return boolValue(cmp == 0), nil
+		case sqlparser.NotEqualStr:
+			return boolValue(cmp != 0), nil
+		case sqlparser.LessThanStr:
+			return boolValue(cmp < 0), nil
+		case sqlparser.LessEqualStr:
+			return boolValue(cmp <= 0), nil
+		case sqlparser.GreaterThanStr:
+			return boolValue(cmp > 0), nil
+		}
+		return boolValue(cmp >= 0), nil
+	}, nil
+}
+
+// buildMatch used to compile the like and regexp, the pattern is compiled once if it's a constant.
+func buildMatch(expr *sqlparser.ComparisonExpr, left evalFunc, resolve Resolver) (evalFunc, error) {
+	right, err := build(expr.Right, resolve)
+	if err != nil {
+		return nil, err
+	}
+	escape := byte('\\')
+	if expr.Escape != nil {
+		val, ok := expr.Escape.(*sqlparser.SQLVal)
+		if !ok || val.Type != sqlparser.StrVal || len(val.Val) != 1 {
+			return nil, errors.Errorf("unsupported: expr.'%s'.in.evaluation", sqlparser.String(expr))
+		}
+		escape = val.Val[0]
+	}
+	like := expr.Operator == sqlparser.LikeStr || expr.Operator == sqlparser.NotLikeStr
+	compile := func(pattern string) (*regexp.Regexp, error) {
+		if like {
+			pattern = likeToRegexp(pattern, escape)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.Errorf("invalid.regular.expression.'%s'", pattern)
+		}
+		return re, nil
+	}
+
+	var re *regexp.Regexp
+	if val, ok := expr.Right.(*sqlparser.SQLVal); ok && val.Type == sqlparser.StrVal {
+		if re, err = compile(string(val.Val)); err != nil {
+			return nil, err
+		}
+	}
+	return func(row []sqltypes.Value) (sqltypes.Value, error) {
+		v1, err := left(row)
+		if err != nil {
+			return sqltypes.NULL, err
+		}
+		v2, err := right(row)
+		if err != nil {
+			return sqltypes.NULL, err
+		}
+		if v1.IsNull() || v2.IsNull() {
+			return sqltypes.NULL, nil
+		}
+		matcher := re
+		if matcher == nil {
+			if matcher, err = compile(v2.ToString()); err != nil {
+				return sqltypes.NULL, err
+			}
+		}
+		matched := matcher.MatchString(v1.ToString())
+		if expr.Operator == sqlparser.NotLikeStr || expr.Operator == sqlparser.NotRegexpStr {
+			matched = !matched
+		}
+		return boolValue(matched), nil
+	}, nil
+}
+
+// likeToRegexp converts the like pattern to the regular expression.
+func likeToRegexp(pattern string, escape byte) string {
+	var buf strings.Builder
+	buf.WriteString("(?s)^")
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == escape && i+1 < len(pattern):
+			i++
+			buf.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case c == '%':
+			buf.WriteString(".*")
+		case c == '_':
+			buf.WriteString(".")
+		default:
+			buf.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	buf.WriteString("$")
+	return buf.String()
+}
+
+func buildLogic(l, r sqlparser.Expr, op func(x, y Truth) Truth, resolve Resolver) (evalFunc, error) {
+	left, err := build(l, resolve)
+	if err != nil {
+		return nil, err
+	}
+	right, err := build(r, resolve)
+	if err != nil {
+		return nil, err
+	}
+	return func(row []sqltypes.Value) (sqltypes.Value, error) {
+		v1, err := left(row)
+		if err != nil {
+			return sqltypes.NULL, err
+		}
+		v2, err := right(row)
+		if err != nil {
+			return sqltypes.NULL, err
+		}
+		return op(ToTruth(v1), ToTruth(v2)).value(), nil
+	}, nil
+}
+
+func buildNot(expr sqlparser.Expr, resolve Resolver) (evalFunc, error) {
+	fn, err := build(expr, resolve)
+	if err != nil {
+		return nil, err
+	}
+	return func(row []sqltypes.Value) (sqltypes.Value, error) {
+		v, err := fn(row)
+		if err != nil {
+			return sqltypes.NULL, err
+		}
+		return ToTruth(v).not().value(), nil
+	}, nil
+}
+
+func buildIs(expr *sqlparser.IsExpr, resolve Resolver) (evalFunc, error) {
+	fn, err := build(expr.Expr, resolve)
+	if err != nil {
+		return nil, err
+	}
+	return func(row []sqltypes.Value) (sqltypes.Value, error) {
+		v, err := fn(row)
+		if err != nil {
+			return sqltypes.NULL, err
+		}
+		truth := ToTruth(v)
+		switch expr.Operator {
+		case sqlparser.IsNullStr:
+			return boolValue(truth == Unknown), nil
+		case sqlparser.IsNotNullStr:
+			return boolValue(truth != Unknown), nil
+		case sqlparser.IsTrueStr:
+			return boolValue(truth == True), nil
+		case sqlparser.IsNotTrueStr:
+			return boolValue(truth != True), nil
+		case sqlparser.IsFalseStr:
+			return boolValue(truth == False), nil
+		}
+		return boolValue(truth != False), nil
+	}, nil
+}
+
+func buildRange(expr *sqlparser.RangeCond, resolve Resolver) (evalFunc, error) {
+	fns, err := buildAll([]sqlparser.Expr{expr.Left, expr.From, expr.To}, resolve)
+	if err != nil {
+		return nil, err
+	}
+	return func(row []sqltypes.Value) (sqltypes.Value, error) {
+		vals, err := evalAll(fns, row)
+		if err != nil {
+			return sqltypes.NULL, err
+		}
+		res := and(compareTruth(vals[0], vals[1], func(cmp int) bool { return cmp >= 0 }),
+			compareTruth(vals[0], vals[2], func(cmp int) bool { return cmp <= 0 }))
+		if expr.Operator == sqlparser.NotBetweenStr {
+			res = res.not()
+		}
+		return res.value(), nil
+	}, nil
+}
+
+func buildCase(expr *sqlparser.CaseExpr, resolve Resolver) (evalFunc, error) {
+	var base, els evalFunc
+	var err error
+	if expr.Expr != nil {
+		if base, err = build(expr.Expr, resolve); err != nil {
+			return nil, err
+		}
+	}
+	if expr.Else != nil {
+		if els, err = build(expr.Else, resolve); err != nil {
+			return nil, err
+		}
+	}
+	conds := make([]evalFunc, len(expr.Whens))
+	vals := make([]evalFunc, len(expr.Whens))
+	for i, when := range expr.Whens {
+		if conds[i], err = build(when.Cond, resolve); err != nil {
+			return nil, err
+		}
+		if vals[i], err = build(when.Val, resolve); err != nil {
+			return nil, err
+		}
+	}
+	return func(row []sqltypes.Value) (sqltypes.Value, error) {
+		var bv sqltypes.Value
+		var err error
+		if base != nil {
+			if bv, err = base(row); err != nil {
+				return sqltypes.NULL, err
+			}
+		}
+		for i, cond := range conds {
+			cv, err := cond(row)
+			if err != nil {
+				return sqltypes.NULL, err
+			}
+			matched := false
+			if base != nil {
+				matched = compareTruth(bv, cv, func(cmp int) bool { return cmp == 0 }) == True
+			} else {
+				matched = ToTruth(cv) == True
+			}
+			if matched {
+				return vals[i](row)
+			}
+		}
+		if els != nil {
+			return els(row)
+		}
+		return sqltypes.NULL, nil
+	}, nil
+}
+
+// compareTruth returns the result of the comparison, Unknown if any value is NULL.
+func compareTruth(v1, v2 sqltypes.Value, fn func(cmp int) bool) Truth {
+	if v1.IsNull() || v2.IsNull() {
+		return Unknown
+	}
+	if fn(sqltypes.NullsafeCompare(v1, v2)) {
+		return True
+	}
+	return False
+}
@@ -0,0 +1,203 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package evaluation
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
+)
+
+func parseExpr(t *testing.T, expr string) sqlparser.Expr {
+	node, err := sqlparser.Parse("select " + expr + " from t")
+	assert.Nil(t, err)
+	return node.(*sqlparser.Select).SelectExprs[0].(*sqlparser.AliasedExpr).Expr
+}
+
+// testResolver resolves the columns a, b, c, s, n and the 'sum(a)' of the testRow.
+func testResolver(expr sqlparser.Expr) (int, bool) {
+	switch expr := expr.(type) {
+	case *sqlparser.ColName:
+		switch expr.Name.String() {
+		case "a":
+			return 0, true
+		case "b":
+			return 1, true
+		case "c":
+			return 2, true
+		case "s":
+			return 3, true
+		case "n":
+			return 4, true
+		}
+	case *sqlparser.FuncExpr:
+		if sqlparser.String(expr) == "sum(a)" {
+			return 5, true
+		}
+	}
+	return -1, false
+}
+
+var testRow = []sqltypes.Value{
+	sqltypes.NewInt64(7),
+	sqltypes.NewInt64(2),
+	sqltypes.MakeTrusted(sqltypes.Decimal, []byte("1.50")),
+	sqltypes.NewVarChar("Radon"),
+	sqltypes.NULL,
+	sqltypes.MakeTrusted(sqltypes.Decimal, []byte("15")),
+}
+
+func TestEvaluation(t *testing.T) {
+	tcases := []struct {
+		expr string
+		want string
+		typ  querypb.Type
+	}{
+		// Arithmetic.
+		{"a + b", "9", sqltypes.Int64},
+		{"a - b * 3", "1", sqltypes.Int64},
+		{"a / b", "3.5000", sqltypes.Decimal},
+		{"a div b", "3", sqltypes.Int64},
+		{"a % b", "1", sqltypes.Int64},
+		{"-a % b", "-1", sqltypes.Int64},
+		{"a / 0", "", sqltypes.Null},
+		{"a + c", "8.50", sqltypes.Decimal},
+		{"c * c", "2.2500", sqltypes.Decimal},
+		{"a + 1.5e0", "8.5", sqltypes.Float64},
+		{"a + '3abc'", "10", sqltypes.Float64},
+		{"sum(a) / b", "7.5000", sqltypes.Decimal},
+		// The decimal is exact.
+		{"12345678901234567890.12 + 0.01", "12345678901234567890.13", sqltypes.Decimal},
+		{"0.1 * 3 - 0.3", "0.0", sqltypes.Decimal},
+		{"1 / 3", "0.3333", sqltypes.Decimal},
+		{"-10.5 % 3", "-1.5", sqltypes.Decimal},
+		{"10.5 div 3", "3", sqltypes.Int64},
+		{"-a", "-7", sqltypes.Int64},
+		{"a + n", "", sqltypes.Null},
+		{"a & 3", "3", sqltypes.Uint64},
+		{"a | 8", "15", sqltypes.Uint64},
+		{"a ^ b", "5", sqltypes.Uint64},
+		{"1 << 4", "16", sqltypes.Uint64},
+		{"~0", "18446744073709551615", sqltypes.Uint64},
+		// Comparison and logic.
+		{"a > b", "1", sqltypes.Int64},
+		{"a = 7.0", "1", sqltypes.Int64},
+		{"a <> 7", "0", sqltypes.Int64},
+		{"a = n", "", sqltypes.Null},
+		{"n <=> null", "1", sqltypes.Int64},
+		{"s = 'Radon'", "1", sqltypes.Int64},
+		{"a in (1, 7)", "1", sqltypes.Int64},
+		{"a in (1, null)", "", sqltypes.Null},
+		{"a not in (1, 2)", "1", sqltypes.Int64},
+		{"s like 'Ra%'", "1", sqltypes.Int64},
+		{"s like 'R_d'", "0", sqltypes.Int64},
+		{"s not like '%x%'", "1", sqltypes.Int64},
+		{"s regexp '^R.*n$'", "1", sqltypes.Int64},
+		{"a between 1 and 7", "1", sqltypes.Int64},
+		{"a not between b and 5", "1", sqltypes.Int64},
+		{"a > 1 and n > 1", "", sqltypes.Null},
+		{"a > 10 and n > 1", "0", sqltypes.Int64},
+		{"a > 1 or n > 1", "1", sqltypes.Int64},
+		{"not a > 1", "0", sqltypes.Int64},
+		{"n is null", "1", sqltypes.Int64},
+		{"a is not true", "0", sqltypes.Int64},
+		// Functions and case.
+		{"if(a > b, s, n)", "Radon", sqltypes.VarChar},
+		{"ifnull(n, a)", "7", sqltypes.Int64},
+		{"nullif(a, 7)", "", sqltypes.Null},
+		{"coalesce(n, null, c)", "1.50", sqltypes.Decimal},
+		{"abs(b - a)", "5", sqltypes.Int64},
+		{"ceil(c)", "2", sqltypes.Int64},
+		{"floor(-c)", "-2", sqltypes.Int64},
+		{"round(c)", "2", sqltypes.Decimal},
+		{"round(sum(a) / b, 1)", "7.5", sqltypes.Decimal},
+		{"round(1234, -2)", "1200", sqltypes.Int64},
+		{"round(1.005, 2)", "1.01", sqltypes.Decimal},
+		{"round(-2.5)", "-3", sqltypes.Decimal},
+		{"round(1250.5, -2)", "1300", sqltypes.Decimal},
+		{"ceil(-1.5)", "-1", sqltypes.Int64},
+		{"floor(12345678901234567890.5)", "12345678901234567890", sqltypes.Uint64},
+		{"mod(a, 4)", "3", sqltypes.Int64},
+		{"concat(s, '-', a)", "Radon-7", sqltypes.VarChar},
+		{"concat(s, n)", "", sqltypes.Null},
+		{"upper(s)", "RADON", sqltypes.VarChar},
+		{"length(s)", "5", sqltypes.Int64},
+		{"greatest(a, b, 3)", "7", sqltypes.Int64},
+		{"least(a, b, 3)", "2", sqltypes.Int64},
+		{"case when a > 10 then 'big' when a > 5 then 'mid' else 'small' end", "mid", sqltypes.VarChar},
+		{"case b when 1 then 'one' when 2 then 'two' end", "two", sqltypes.VarChar},
+		{"case b when 3 then 'three' end", "", sqltypes.Null},
+	}
+	for _, tcase := range tcases {
+		eval, err := Build(parseExpr(t, tcase.expr), testResolver)
+		assert.Nil(t, err, tcase.expr)
+		got, err := eval.Eval(testRow)
+		assert.Nil(t, err, tcase.expr)
+		assert.Equal(t, tcase.want, got.ToString(), tcase.expr)
+		assert.Equal(t, tcase.typ, got.Type(), tcase.expr)
+	}
+}
+
+func TestEvaluationError(t *testing.T) {
+	tcases := []struct {
+		expr string
+		want string
+	}{
+		{"x + 1", "unsupported: unknown.column.'x'.in.evaluation"},
+		{"count(a) + 1", "unsupported: expr.'count(a)'.in.evaluation"},
+		{"rand()", "unsupported: expr.'rand()'.in.evaluation"},
+		{"a in (select 1)", "unsupported: expr.'a in (select 1 from dual)'.in.evaluation"},
+		{"if(a, b)", "incorrect.parameter.count.in.the.call.to.native.function.'if'"},
+	}
+	for _, tcase := range tcases {
+		_, err := Build(parseExpr(t, tcase.expr), testResolver)
+		assert.NotNil(t, err, tcase.expr)
+		if err != nil {
+			assert.Equal(t, tcase.want, err.Error(), tcase.expr)
+		}
+	}
+
+	// Overflow.
+	eval, err := Build(parseExpr(t, "9223372036854775807 + a"), testResolver)
+	assert.Nil(t, err)
+	_, err = eval.Eval(testRow)
+	assert.Equal(t, "BIGINT.value.is.out.of.range.in.'+'", err.Error())
+}
+
+func TestEvaluationConvert(t *testing.T) {
+	tcases := []struct {
+		val   sqltypes.Value
+		field *querypb.Field
+		want  string
+	}{
+		{sqltypes.MakeTrusted(sqltypes.Decimal, []byte("7.5")), &querypb.Field{Type: sqltypes.Decimal, Decimals: 4}, "7.5000"},
+		{sqltypes.NewInt64(7), &querypb.Field{Type: sqltypes.Decimal, Decimals: 2}, "7.00"},
+		{sqltypes.MakeTrusted(sqltypes.Decimal, []byte("12345678901234567890.125")), &querypb.Field{Type: sqltypes.Decimal, Decimals: 2}, "12345678901234567890.13"},
+		{sqltypes.MakeTrusted(sqltypes.Decimal, []byte("0.10")), &querypb.Field{Type: sqltypes.Decimal, Decimals: 31}, "0.10"},
+		{sqltypes.MakeTrusted(sqltypes.Decimal, []byte("7.5")), &querypb.Field{Type: sqltypes.Int64}, "8"},
+		{sqltypes.NewInt64(-1), &querypb.Field{Type: sqltypes.Float64}, "-1"},
+		{sqltypes.NewInt64(7), &querypb.Field{Type: sqltypes.VarChar}, "7"},
+		{sqltypes.NULL, &querypb.Field{Type: sqltypes.Int64}, ""},
+	}
+	for _, tcase := range tcases {
+		got := Convert(tcase.val, tcase.field)
+		assert.Equal(t, tcase.want, got.ToString())
+		if !tcase.val.IsNull() {
+			assert.Equal(t, tcase.field.Type, got.Type())
+		}
+	}
+
+	assert.True(t, IsTrue(sqltypes.NewVarChar("1abc")))
+	assert.False(t, IsTrue(sqltypes.NewVarChar("abc")))
+	assert.False(t, IsTrue(sqltypes.NULL))
+}
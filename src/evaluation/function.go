@@ -0,0 +1,235 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package evaluation
+
+import (
+	"math"
+	"math/big"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+)
+
+// function is the scalar function evaluated in the proxy, the args number is in [min, max], -1 means no limit.
+type function struct {
+	min, max int
+	fn       func(args []sqltypes.Value) (sqltypes.Value, error)
+}
+
+var functions = map[string]function{
+	"if":               {3, 3, ifFunc},
+	"ifnull":           {2, 2, ifnullFunc},
+	"nullif":           {2, 2, nullifFunc},
+	"coalesce":         {1, -1, coalesceFunc},
+	"abs":              {1, 1, nullable(absFunc)},
+	"ceil":             {1, 1, nullable(ceilFunc)},
+	"ceiling":          {1, 1, nullable(ceilFunc)},
+	"floor":            {1, 1, nullable(floorFunc)},
+	"round":            {1, 2, nullable(roundFunc)},
+	"mod":              {2, 2, nullable(modFunc)},
+	"concat":           {1, -1, nullable(concatFunc)},
+	"lower":            {1, 1, nullable(lowerFunc)},
+	"lcase":            {1, 1, nullable(lowerFunc)},
+	"upper":            {1, 1, nullable(upperFunc)},
+	"ucase":            {1, 1, nullable(upperFunc)},
+	"length":           {1, 1, nullable(lengthFunc)},
+	"char_length":      {1, 1, nullable(charLengthFunc)},
+	"character_length": {1, 1, nullable(charLengthFunc)},
+	"greatest":         {2, -1, nullable(greatestFunc)},
+	"least":            {2, -1, nullable(leastFunc)},
+}
+
+func buildFunc(expr *sqlparser.FuncExpr, resolve Resolver) (evalFunc, error) {
+	name := expr.Name.Lowered()
+	f, ok := functions[name]
+	if !ok || expr.Distinct || !expr.Qualifier.IsEmpty() {
+		return nil, errors.Errorf("unsupported: expr.'%s'.in.evaluation", sqlparser.String(expr))
+	}
+	if len(expr.Exprs) < f.min || (f.max != -1 && len(expr.Exprs) > f.max) {
+		return nil, errors.Errorf("incorrect.parameter.count.in.the.call.to.native.function.'%s'", name)
+	}
+
+	var exprs []sqlparser.Expr
+	for _, arg := range expr.Exprs {
+		aliased, ok := arg.(*sqlparser.AliasedExpr)
+		if !ok {
+			return nil, errors.Errorf("unsupported: expr.'%s'.in.evaluation", sqlparser.String(expr))
+		}
+		exprs = append(exprs, aliased.Expr)
+	}
+	args, err := buildAll(exprs, resolve)
+	if err != nil {
+		return nil, err
+	}
+	return func(row []sqltypes.Value) (sqltypes.Value, error) {
+		vals, err := evalAll(args, row)
+		if err != nil {
+			return sqltypes.NULL, err
+		}
+		return f.fn(vals)
+	}, nil
+}
+
+// nullable returns NULL if any arg is NULL, otherwise calls the fn.
+func nullable(fn func(args []sqltypes.Value) (sqltypes.Value, error)) func(args []sqltypes.Value) (sqltypes.Value, error) {
+	return func(args []sqltypes.Value) (sqltypes.Value, error) {
+		for _, arg := range args {
+			if arg.IsNull() {
+				return sqltypes.NULL, nil
+			}
+		}
+		return fn(args)
+	}
+}
+
+func ifFunc(args []sqltypes.Value) (sqltypes.Value, error) {
+	if IsTrue(args[0]) {
+		return args[1], nil
+	}
+	return args[2], nil
+}
+
+func ifnullFunc(args []sqltypes.Value) (sqltypes.Value, error) {
+	if args[0].IsNull() {
+		return args[1], nil
+	}
+	return args[0], nil
+}
+
+func nullifFunc(args []sqltypes.Value) (sqltypes.Value, error) {
+	if compareTruth(args[0], args[1], func(cmp int) bool { return cmp == 0 }) == True {
+		return sqltypes.NULL, nil
+	}
+	return args[0], nil
+}
+
+func coalesceFunc(args []sqltypes.Value) (sqltypes.Value, error) {
+	for _, arg := range args {
+		if !arg.IsNull() {
+			return arg, nil
+		}
+	}
+	return sqltypes.NULL, nil
+}
+
+func absFunc(args []sqltypes.Value) (sqltypes.Value, error) {
+	n := toNumber(args[0])
+	switch n.kind {
+	case intKind:
+		if n.ival == math.MinInt64 {
+			return sqltypes.NULL, errors.New("BIGINT.value.is.out.of.range.in.'abs'")
+		}
+		if n.ival < 0 {
+			n.ival = -n.ival
+		}
+	case decimalKind:
+		n.dval = new(big.Rat).Abs(n.dval)
+	case floatKind:
+		n.fval = math.Abs(n.fval)
+	}
+	return n.value(), nil
+}
+
+func ceilFunc(args []sqltypes.Value) (sqltypes.Value, error) {
+	return integral(toNumber(args[0]), math.Ceil, ceilInt), nil
+}
+
+func floorFunc(args []sqltypes.Value) (sqltypes.Value, error) {
+	return integral(toNumber(args[0]), math.Floor, floorInt), nil
+}
+
+// integral returns the integral value by the fn, the decimal is rounded exactly by the ratFn,
+// the double is still the double.
+func integral(n number, fn func(float64) float64, ratFn func(*big.Rat) *big.Int) sqltypes.Value {
+	switch n.kind {
+	case decimalKind:
+		return clampInteger(ratFn(n.dval)).value()
+	case floatKind:
+		n.fval = fn(n.fval)
+	}
+	return n.value()
+}
+
+// roundFunc rounds the exact number half away from zero, and the double half to even.
+func roundFunc(args []sqltypes.Value) (sqltypes.Value, error) {
+	n := toNumber(args[0])
+	d := 0
+	if len(args) > 1 {
+		d = int(toInteger(toNumber(args[1])).ival)
+	}
+	switch n.kind {
+	case intKind, uintKind:
+		if d >= 0 {
+			return n.value(), nil
+		}
+		if d < -19 {
+			return sqltypes.NewInt64(0), nil
+		}
+		return clampInteger(roundInt(roundRat(n.rat(), d))).value(), nil
+	case decimalKind:
+		scale := d
+		if scale < 0 {
+			scale = 0
+		}
+		return decimal(roundRat(n.dval, d), scale).value(), nil
+	}
+	p := math.Pow10(d)
+	return number{kind: floatKind, fval: math.RoundToEven(n.fval*p) / p}.value(), nil
+}
+
+func modFunc(args []sqltypes.Value) (sqltypes.Value, error) {
+	return arithmetic(sqlparser.ModStr, toNumber(args[0]), toNumber(args[1]))
+}
+
+func concatFunc(args []sqltypes.Value) (sqltypes.Value, error) {
+	var buf strings.Builder
+	for _, arg := range args {
+		buf.WriteString(arg.ToString())
+	}
+	return sqltypes.NewVarChar(buf.String()), nil
+}
+
+func lowerFunc(args []sqltypes.Value) (sqltypes.Value, error) {
+	return sqltypes.NewVarChar(strings.ToLower(args[0].ToString())), nil
+}
+
+func upperFunc(args []sqltypes.Value) (sqltypes.Value, error) {
+	return sqltypes.NewVarChar(strings.ToUpper(args[0].ToString())), nil
+}
+
+func lengthFunc(args []sqltypes.Value) (sqltypes.Value, error) {
+	return sqltypes.NewInt64(int64(len(args[0].Raw()))), nil
+}
+
+func charLengthFunc(args []sqltypes.Value) (sqltypes.Value, error) {
+	return sqltypes.NewInt64(int64(utf8.RuneCount(args[0].Raw()))), nil
+}
+
+func greatestFunc(args []sqltypes.Value) (sqltypes.Value, error) {
+	res := args[0]
+	for _, arg := range args[1:] {
+		if sqltypes.NullsafeCompare(arg, res) > 0 {
+			res = arg
+		}
+	}
+	return res, nil
+}
+
+func leastFunc(args []sqltypes.Value) (sqltypes.Value, error) {
+	res := args[0]
+	for _, arg := range args[1:] {
+		if sqltypes.NullsafeCompare(arg, res) < 0 {
+			res = arg
+		}
+	}
+	return res, nil
+}
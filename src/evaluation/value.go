@@ -0,0 +1,524 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package evaluation
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
+)
+
+// Truth is the result of the three-valued logic.
+type Truth int
+
+const (
+	// False enum.
+	False Truth = iota
+	// True enum.
+	True
+	// Unknown is the result of NULL.
+	Unknown
+)
+
+// ToTruth returns the truth of the value as the condition, the number is true if it isn't zero.
+func ToTruth(v sqltypes.Value) Truth {
+	if v.IsNull() {
+		return Unknown
+	}
+	if !toNumber(v).isZero() {
+		return True
+	}
+	return False
+}
+
+// IsTrue returns true if the value is true as the condition, NULL isn't true.
+func IsTrue(v sqltypes.Value) bool {
+	return ToTruth(v) == True
+}
+
+func (t Truth) not() Truth {
+	switch t {
+	case True:
+		return False
+	case False:
+		return True
+	}
+	return Unknown
+}
+
+func (t Truth) value() sqltypes.Value {
+	if t == Unknown {
+		return sqltypes.NULL
+	}
+	return boolValue(t == True)
+}
+
+func and(x, y Truth) Truth {
+	if x == False || y == False {
+		return False
+	}
+	if x == Unknown || y == Unknown {
+		return Unknown
+	}
+	return True
+}
+
+func or(x, y Truth) Truth {
+	if x == True || y == True {
+		return True
+	}
+	if x == Unknown || y == Unknown {
+		return Unknown
+	}
+	return False
+}
+
+func boolValue(b bool) sqltypes.Value {
+	if b {
+		return sqltypes.NewInt64(1)
+	}
+	return sqltypes.NewInt64(0)
+}
+
+// maxScale is the max digits after the decimal point of the decimal.
+const maxScale = 30
+
+// divScale is the digits added to the scale of the division result, the div_precision_increment of MySQL.
+const divScale = 4
+
+// kind is the kind of the number.
+type kind int
+
+const (
+	intKind kind = iota
+	uintKind
+	decimalKind
+	floatKind
+)
+
+// number is the numeric value of the arithmetic, the decimal is kept exactly in dval with its scale.
+type number struct {
+	kind  kind
+	ival  int64
+	uval  uint64
+	fval  float64
+	dval  *big.Rat
+	scale int
+}
+
+// toNumber converts the value to the number, the string is converted to the double by its numeric prefix.
+func toNumber(v sqltypes.Value) number {
+	switch {
+	case v.IsSigned():
+		if i, err := v.ParseInt64(); err == nil {
+			return number{kind: intKind, ival: i}
+		}
+	case v.IsUnsigned():
+		if u, err := v.ParseUint64(); err == nil {
+			return number{kind: uintKind, uval: u}
+		}
+	case v.IsFloat():
+		if f, err := v.ParseFloat64(); err == nil {
+			return number{kind: floatKind, fval: f}
+		}
+	case v.Type() == sqltypes.Decimal:
+		str := v.ToString()
+		if r, ok := new(big.Rat).SetString(str); ok {
+			scale := 0
+			if i := strings.IndexByte(str, '.'); i >= 0 {
+				scale = len(str) - i - 1
+			}
+			return number{kind: decimalKind, dval: r, scale: scale}
+		}
+	}
+	return number{kind: floatKind, fval: parseFloatPrefix(v.ToString())}
+}
+
+// parseFloatPrefix parses the longest numeric prefix of the string, 0 if there's none.
+func parseFloatPrefix(str string) float64 {
+	str = strings.TrimSpace(str)
+	end, digits := 0, false
+	if end < len(str) && (str[end] == '+' || str[end] == '-') {
+		end++
+	}
+	for end < len(str) && str[end] >= '0' && str[end] <= '9' {
+		end, digits = end+1, true
+	}
+	if end < len(str) && str[end] == '.' {
+		end++
+		for end < len(str) && str[end] >= '0' && str[end] <= '9' {
+			end, digits = end+1, true
+		}
+	}
+	if !digits {
+		return 0
+	}
+	if end < len(str) && (str[end] == 'e' || str[end] == 'E') {
+		exp := end + 1
+		if exp < len(str) && (str[exp] == '+' || str[exp] == '-') {
+			exp++
+		}
+		if exp < len(str) && str[exp] >= '0' && str[exp] <= '9' {
+			for exp < len(str) && str[exp] >= '0' && str[exp] <= '9' {
+				exp++
+			}
+			end = exp
+		}
+	}
+	f, _ := strconv.ParseFloat(str[:end], 64)
+	return f
+}
+
+func (n number) float() float64 {
+	switch n.kind {
+	case intKind:
+		return float64(n.ival)
+	case uintKind:
+		return float64(n.uval)
+	case decimalKind:
+		f, _ := n.dval.Float64()
+		return f
+	}
+	return n.fval
+}
+
+// rat returns the exact value of the integer or the decimal.
+func (n number) rat() *big.Rat {
+	switch n.kind {
+	case intKind:
+		return new(big.Rat).SetInt64(n.ival)
+	case uintKind:
+		return new(big.Rat).SetUint64(n.uval)
+	}
+	return n.dval
+}
+
+func (n number) bigInt() *big.Int {
+	if n.kind == uintKind {
+		return new(big.Int).SetUint64(n.uval)
+	}
+	return big.NewInt(n.ival)
+}
+
+// bits returns the uint64 value of the bit operations.
+func (n number) bits() uint64 {
+	switch n.kind {
+	case intKind:
+		return uint64(n.ival)
+	case uintKind:
+		return n.uval
+	case decimalKind:
+		return toInteger(n).bits()
+	}
+	f := math.Round(n.fval)
+	if f < 0 {
+		return uint64(int64(f))
+	}
+	return uint64(f)
+}
+
+func (n number) isZero() bool {
+	if n.kind == decimalKind {
+		return n.dval.Sign() == 0
+	}
+	return n.float() == 0
+}
+
+func (n number) value() sqltypes.Value {
+	switch n.kind {
+	case intKind:
+		return sqltypes.NewInt64(n.ival)
+	case uintKind:
+		return sqltypes.NewUint64(n.uval)
+	case decimalKind:
+		return sqltypes.MakeTrusted(sqltypes.Decimal, []byte(n.dval.FloatString(n.scale)))
+	}
+	if math.IsInf(n.fval, 0) || math.IsNaN(n.fval) {
+		return sqltypes.NULL
+	}
+	return sqltypes.NewFloat64(n.fval)
+}
+
+// decimal returns the decimal number rounded to the scale, the scale is limited to maxScale.
+func decimal(r *big.Rat, scale int) number {
+	if scale > maxScale {
+		scale = maxScale
+	}
+	return number{kind: decimalKind, dval: roundRat(r, scale), scale: scale}
+}
+
+// pow10 returns the rational of 10^n.
+func pow10(n int) *big.Rat {
+	return new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil))
+}
+
+// roundInt rounds the rational to the integer, half away from zero.
+func roundInt(r *big.Rat) *big.Int {
+	v := new(big.Int).Abs(r.Num())
+	v.Lsh(v, 1).Add(v, r.Denom())
+	v.Quo(v, new(big.Int).Lsh(r.Denom(), 1))
+	if r.Sign() < 0 {
+		v.Neg(v)
+	}
+	return v
+}
+
+// roundRat rounds the rational to the digits after the decimal point, half away from zero.
+// The negative digits round the digits before the decimal point.
+func roundRat(r *big.Rat, digits int) *big.Rat {
+	if digits >= 0 {
+		p := pow10(digits)
+		v := new(big.Rat).SetInt(roundInt(new(big.Rat).Mul(r, p)))
+		return v.Quo(v, p)
+	}
+	p := pow10(-digits)
+	v := new(big.Rat).SetInt(roundInt(new(big.Rat).Quo(r, p)))
+	return v.Mul(v, p)
+}
+
+// floorInt returns the largest integer not greater than the rational.
+func floorInt(r *big.Rat) *big.Int {
+	// The denominator is positive, the euclidean quotient is the floor.
+	q, _ := new(big.Int).DivMod(r.Num(), r.Denom(), new(big.Int))
+	return q
+}
+
+// ceilInt returns the least integer not less than the rational.
+func ceilInt(r *big.Rat) *big.Int {
+	q := floorInt(r)
+	if !r.IsInt() {
+		q.Add(q, big.NewInt(1))
+	}
+	return q
+}
+
+// truncInt returns the integer part of the rational.
+func truncInt(r *big.Rat) *big.Int {
+	return new(big.Int).Quo(r.Num(), r.Denom())
+}
+
+// integer returns the integer number of the exact result, error if it's out of the range.
+// The result is unsigned if any operand is unsigned.
+func integer(v *big.Int, unsigned bool, op string) (number, error) {
+	if unsigned {
+		if v.Sign() >= 0 && v.IsUint64() {
+			return number{kind: uintKind, uval: v.Uint64()}, nil
+		}
+		return number{}, errors.Errorf("BIGINT.UNSIGNED.value.is.out.of.range.in.'%s'", op)
+	}
+	if v.IsInt64() {
+		return number{kind: intKind, ival: v.Int64()}, nil
+	}
+	return number{}, errors.Errorf("BIGINT.value.is.out.of.range.in.'%s'", op)
+}
+
+// clampInteger returns the integer number, the value out of the range is limited to the bound.
+func clampInteger(v *big.Int) number {
+	switch {
+	case v.IsInt64():
+		return number{kind: intKind, ival: v.Int64()}
+	case v.IsUint64():
+		return number{kind: uintKind, uval: v.Uint64()}
+	case v.Sign() > 0:
+		return number{kind: uintKind, uval: math.MaxUint64}
+	}
+	return number{kind: intKind, ival: math.MinInt64}
+}
+
+// toInteger rounds the number to the integer, the value out of the range is limited to the bound.
+func toInteger(n number) number {
+	switch n.kind {
+	case intKind, uintKind:
+		return n
+	case decimalKind:
+		return clampInteger(roundInt(n.dval))
+	}
+	f := math.Round(n.fval)
+	switch {
+	case f >= math.MaxUint64:
+		return number{kind: uintKind, uval: math.MaxUint64}
+	case f > math.MaxInt64:
+		return number{kind: uintKind, uval: uint64(f)}
+	case f <= math.MinInt64:
+		return number{kind: intKind, ival: math.MinInt64}
+	}
+	return number{kind: intKind, ival: int64(f)}
+}
+
+func negate(n number) number {
+	switch n.kind {
+	case intKind, uintKind:
+		v := new(big.Int).Neg(n.bigInt())
+		if v.IsInt64() {
+			return number{kind: intKind, ival: v.Int64()}
+		}
+		return decimal(new(big.Rat).SetInt(v), 0)
+	case decimalKind:
+		n.dval = new(big.Rat).Neg(n.dval)
+		return n
+	}
+	n.fval = -n.fval
+	return n
+}
+
+// arithmetic returns the result of the binary operator, NULL if it's divided by zero.
+func arithmetic(op string, x, y number) (sqltypes.Value, error) {
+	exact := x.kind <= uintKind && y.kind <= uintKind
+	unsigned := x.kind == uintKind || y.kind == uintKind
+	scale := x.scale
+	if y.scale > scale {
+		scale = y.scale
+	}
+	isFloat := x.kind == floatKind || y.kind == floatKind
+
+	switch op {
+	case sqlparser.PlusStr, sqlparser.MinusStr, sqlparser.MultStr:
+		if exact {
+			v := new(big.Int)
+			switch op {
+			case sqlparser.PlusStr:
+				v.Add(x.bigInt(), y.bigInt())
+			case sqlparser.MinusStr:
+				v.Sub(x.bigInt(), y.bigInt())
+			default:
+				v.Mul(x.bigInt(), y.bigInt())
+			}
+			n, err := integer(v, unsigned, op)
+			return n.value(), err
+		}
+		if isFloat {
+			var f float64
+			switch op {
+			case sqlparser.PlusStr:
+				f = x.float() + y.float()
+			case sqlparser.MinusStr:
+				f = x.float() - y.float()
+			default:
+				f = x.float() * y.float()
+			}
+			return number{kind: floatKind, fval: f}.value(), nil
+		}
+		v := new(big.Rat)
+		switch op {
+		case sqlparser.PlusStr:
+			v.Add(x.rat(), y.rat())
+		case sqlparser.MinusStr:
+			v.Sub(x.rat(), y.rat())
+		default:
+			v.Mul(x.rat(), y.rat())
+			scale = x.scale + y.scale
+		}
+		return decimal(v, scale).value(), nil
+	case sqlparser.DivStr:
+		if y.isZero() {
+			return sqltypes.NULL, nil
+		}
+		if isFloat {
+			return number{kind: floatKind, fval: x.float() / y.float()}.value(), nil
+		}
+		return decimal(new(big.Rat).Quo(x.rat(), y.rat()), x.scale+divScale).value(), nil
+	case sqlparser.IntDivStr:
+		if y.isZero() {
+			return sqltypes.NULL, nil
+		}
+		if exact {
+			n, err := integer(new(big.Int).Quo(x.bigInt(), y.bigInt()), unsigned, op)
+			return n.value(), err
+		}
+		if !isFloat {
+			n, err := integer(truncInt(new(big.Rat).Quo(x.rat(), y.rat())), unsigned, op)
+			return n.value(), err
+		}
+		q := math.Trunc(x.float() / y.float())
+		if math.IsInf(q, 0) || math.IsNaN(q) {
+			return sqltypes.NULL, errors.Errorf("BIGINT.value.is.out.of.range.in.'%s'", op)
+		}
+		v, _ := big.NewFloat(q).Int(nil)
+		n, err := integer(v, unsigned, op)
+		return n.value(), err
+	case sqlparser.ModStr:
+		if y.isZero() {
+			return sqltypes.NULL, nil
+		}
+		if exact {
+			// The sign of the result is the sign of the dividend.
+			n, err := integer(new(big.Int).Rem(x.bigInt(), y.bigInt()), x.kind == uintKind, op)
+			return n.value(), err
+		}
+		if isFloat {
+			return number{kind: floatKind, fval: math.Mod(x.float(), y.float())}.value(), nil
+		}
+		// x - y * trunc(x / y), the sign of the result is the sign of the dividend.
+		q := new(big.Rat).SetInt(truncInt(new(big.Rat).Quo(x.rat(), y.rat())))
+		v := new(big.Rat).Sub(x.rat(), q.Mul(q, y.rat()))
+		return decimal(v, scale).value(), nil
+	case sqlparser.BitAndStr:
+		return sqltypes.NewUint64(x.bits() & y.bits()), nil
+	case sqlparser.BitOrStr:
+		return sqltypes.NewUint64(x.bits() | y.bits()), nil
+	case sqlparser.BitXorStr:
+		return sqltypes.NewUint64(x.bits() ^ y.bits()), nil
+	case sqlparser.ShiftLeftStr, sqlparser.ShiftRightStr:
+		shift := y.bits()
+		if shift >= 64 {
+			return sqltypes.NewUint64(0), nil
+		}
+		if op == sqlparser.ShiftLeftStr {
+			return sqltypes.NewUint64(x.bits() << shift), nil
+		}
+		return sqltypes.NewUint64(x.bits() >> shift), nil
+	}
+	return sqltypes.NULL, errors.Errorf("unsupported: operator.'%s'.in.evaluation", op)
+}
+
+// Convert used to convert the evaluated value to the type of the field, so the values of
+// the column have the same type, the decimal is formatted by the decimals of the field.
+func Convert(v sqltypes.Value, field *querypb.Field) sqltypes.Value {
+	if v.IsNull() || field == nil || field.Type == v.Type() && field.Type != sqltypes.Decimal {
+		return v
+	}
+
+	typ := field.Type
+	switch {
+	case sqltypes.IsSigned(typ):
+		n := toInteger(toNumber(v))
+		if n.kind == uintKind {
+			return sqltypes.MakeTrusted(typ, strconv.AppendUint(nil, n.uval, 10))
+		}
+		return sqltypes.MakeTrusted(typ, strconv.AppendInt(nil, n.ival, 10))
+	case sqltypes.IsUnsigned(typ):
+		return sqltypes.MakeTrusted(typ, strconv.AppendUint(nil, toInteger(toNumber(v)).bits(), 10))
+	case sqltypes.IsFloat(typ):
+		return sqltypes.MakeTrusted(typ, strconv.AppendFloat(nil, toNumber(v).float(), 'g', -1, 64))
+	case typ == sqltypes.Decimal:
+		// The decimals bigger than maxScale means the scale isn't fixed.
+		scale := int(field.Decimals)
+		if scale > maxScale {
+			scale = -1
+		}
+		n := toNumber(v)
+		if n.kind == floatKind {
+			return sqltypes.MakeTrusted(typ, strconv.AppendFloat(nil, n.fval, 'f', scale, 64))
+		}
+		if scale < 0 {
+			scale = n.scale
+		}
+		return sqltypes.MakeTrusted(typ, []byte(roundRat(n.rat(), scale).FloatString(scale)))
+	case typ == sqltypes.Null:
+		return v
+	}
+	return sqltypes.MakeTrusted(typ, v.Raw())
+}
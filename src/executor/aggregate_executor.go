@@ -9,12 +9,17 @@
 package executor
 
 import (
+	"evaluation"
+	"math"
 	"planner"
+	"strings"
 	"xcontext"
 
 	"github.com/xelabs/go-mysqlstack/sqlparser/depends/common"
 	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
 	"github.com/xelabs/go-mysqlstack/xlog"
+
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
 )
 
 var (
@@ -22,7 +27,7 @@ var (
 )
 
 // AggregateExecutor represents aggregate executor.
-// Including: COUNT/MAX/MIN/SUM/AVG/GROUPBY.
+// Including: COUNT/MAX/MIN/SUM/AVG/GROUP_CONCAT/BIT_AND/BIT_OR/BIT_XOR/STD/VARIANCE/GROUPBY.
 type AggregateExecutor struct {
 	log  *xlog.Log
 	plan planner.Plan
@@ -39,22 +44,38 @@ func NewAggregateExecutor(log *xlog.Log, plan planner.Plan) *AggregateExecutor {
 // Execute used to execute the executor.
 func (executor *AggregateExecutor) Execute(ctx *xcontext.ResultContext) error {
 	rs := ctx.Results
-	executor.aggregate(rs)
-	return nil
+	return executor.aggregate(rs)
 }
 
-// Aggregate used to do rows-aggregator(COUNT/SUM/MIN/MAX/AVG) and grouped them into group-by fields.
-func (executor *AggregateExecutor) aggregate(result *sqltypes.Result) {
+// Aggregate used to do rows-aggregator(COUNT/SUM/MIN/MAX/AVG/GROUP_CONCAT/BIT/STD/VARIANCE) and grouped them into group-by fields.
+// The expressions of the aggregated values are computed and the groups are filtered by the havings.
+func (executor *AggregateExecutor) aggregate(result *sqltypes.Result) error {
 	var deIdxs []int
 	plan := executor.plan.(*planner.AggregatePlan)
 	if plan.Empty() {
-		return
+		return nil
 	}
 	aggrs := plan.NormalAggregators()
 	aggrLen := len(aggrs)
 	groupAggrs := plan.GroupAggregators()
 
+	// The aggregation without group by returns one row on the empty input.
+	if len(result.Rows) == 0 && len(groupAggrs) == 0 {
+		result.Rows = append(result.Rows, emptyAggregateRow(aggrs, len(result.Fields)))
+	}
+
+	// The index of the distinct values in the row.
+	distinctIdx := -1
+	for _, aggr := range aggrs {
+		if isDistinctAggregator(aggr) {
+			distinctIdx = aggr.Index
+			break
+		}
+	}
+
+	var keys []string
 	groups := make(map[string][]sqltypes.Value)
+	distincts := make(map[string]*distinctValues)
 	for _, row1 := range result.Rows {
 		keySlice := []byte{0x01}
 		for _, v := range groupAggrs {
@@ -64,70 +85,301 @@ func (executor *AggregateExecutor) aggregate(result *sqltypes.Result) {
 		key := common.BytesToString(keySlice)
 		if row2, ok := groups[key]; !ok {
 			groups[key] = row1
+			keys = append(keys, key)
+			if distinctIdx != -1 {
+				distincts[key] = &distinctValues{seen: make(map[string]bool)}
+			}
 		} else {
 			if aggrLen > 0 {
 				groups[key] = operator(aggrs, row1)(row2)
 			}
 		}
+		if distinctIdx != -1 {
+			distincts[key].add(row1[distinctIdx])
+		}
 	}
 
 	// Handle the avg operator and rebuild the results.
-	i := 0
-	result.Rows = make([][]sqltypes.Value, len(groups))
-	for _, v := range groups {
+	result.Rows = make([][]sqltypes.Value, 0, len(groups))
+	for _, key := range keys {
+		v := groups[key]
 		for _, aggr := range aggrs {
 			switch aggr.Type {
 			case planner.AggrTypeAvg:
 				v1, v2 := v[aggr.Index], v[aggr.Index+1]
-				v[aggr.Index] = sqltypes.Operator(v1, v2, sqltypes.DivFn)
+				// The avg of all NULL values is NULL.
+				if v1.IsNull() {
+					v[aggr.Index] = sqltypes.NULL
+				} else {
+					v[aggr.Index] = sqltypes.Operator(v1, v2, sqltypes.DivFn)
+				}
 				deIdxs = append(deIdxs, aggr.Index+1)
+			case planner.AggrTypeStddevPop, planner.AggrTypeStddevSamp, planner.AggrTypeVarPop, planner.AggrTypeVarSamp:
+				v[aggr.Index] = statistic(aggr.Type, v[aggr.Index:aggr.Index+3])
+				deIdxs = append(deIdxs, aggr.Index+1, aggr.Index+2)
+			case planner.AggrTypeBitAnd, planner.AggrTypeBitOr, planner.AggrTypeBitXor:
+				v[aggr.Index] = sqltypes.NewUint64(bitValue(aggr.Type, v[aggr.Index]))
+			case planner.AggrTypeGroupConcat:
+				if !v[aggr.Index].IsNull() {
+					v[aggr.Index] = sqltypes.MakeTrusted(sqltypes.VarChar, v[aggr.Index].Raw())
+				}
+			case planner.AggrTypeCountDistinct, planner.AggrTypeSumDistinct, planner.AggrTypeAvgDistinct, planner.AggrTypeGroupConcatDistinct:
+				v[aggr.Index] = distincts[key].aggregate(aggr)
+			case planner.AggrTypeExpr:
+				val, err := aggr.Evaluation().Eval(v)
+				if err != nil {
+					return err
+				}
+				v[aggr.Index] = evaluation.Convert(val, result.Fields[aggr.Index])
 			}
 		}
-		result.Rows[i] = v
-		i++
+		ok, err := filterHavings(plan.Havings(), v)
+		if err != nil {
+			return err
+		}
+		if ok {
+			result.Rows = append(result.Rows, v)
+		}
+	}
+	if len(groups) > 0 {
+		aggregateFields(aggrs, result)
 	}
 
-	// Remove avg decompose columns.
+	// Remove avg decompose columns and the hidden columns.
+	if hidden := plan.Hidden(); hidden != -1 {
+		for i := hidden; i < len(result.Fields); i++ {
+			deIdxs = append(deIdxs, i)
+		}
+	}
 	result.RemoveColumns(deIdxs...)
+	return nil
 }
 
-// aggregate supported type: SUM/COUNT/MIN/MAX/AVG.
+// filterHavings returns true if the row satisfies all the havings.
+func filterHavings(havings []evaluation.Evaluation, row []sqltypes.Value) (bool, error) {
+	for _, having := range havings {
+		val, err := having.Eval(row)
+		if err != nil {
+			return false, err
+		}
+		if !evaluation.IsTrue(val) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// aggregate supported type: SUM/COUNT/MIN/MAX/AVG/GROUP_CONCAT/BIT_AND/BIT_OR/BIT_XOR.
 func operator(aggrs []planner.Aggregator, x []sqltypes.Value) func([]sqltypes.Value) []sqltypes.Value {
 	return func(y []sqltypes.Value) []sqltypes.Value {
 		ret := sqltypes.Row(x).Copy()
 		for _, aggr := range aggrs {
+			v1, v2 := x[aggr.Index], y[aggr.Index]
+			if aggr.Type == planner.AggrTypeAvg || aggr.Type == planner.AggrTypeExpr || isDistinctAggregator(aggr) {
+				// nop
+				continue
+			}
+			if isStatAggregator(aggr) {
+				copy(ret[aggr.Index:aggr.Index+3], mergeStat(x[aggr.Index:aggr.Index+3], y[aggr.Index:aggr.Index+3]))
+				continue
+			}
+			if v1.Type() == sqltypes.Null {
+				ret[aggr.Index] = v2
+				continue
+			} else if v2.Type() == sqltypes.Null {
+				ret[aggr.Index] = v1
+				continue
+			}
 			switch aggr.Type {
 			case planner.AggrTypeSum, planner.AggrTypeCount:
-				v1, v2 := x[aggr.Index], y[aggr.Index]
-				if v1.Type() == sqltypes.Null {
-					ret[aggr.Index] = v2
-				} else if v2.Type() == sqltypes.Null {
-					ret[aggr.Index] = v1
-				} else {
-					ret[aggr.Index] = sqltypes.Operator(v1, v2, sqltypes.SumFn)
-				}
+				ret[aggr.Index] = sqltypes.Operator(v1, v2, sqltypes.SumFn)
 			case planner.AggrTypeMin:
-				v1, v2 := x[aggr.Index], y[aggr.Index]
-				if v1.Type() == sqltypes.Null {
-					ret[aggr.Index] = v2
-				} else if v2.Type() == sqltypes.Null {
-					ret[aggr.Index] = v1
-				} else {
-					ret[aggr.Index] = sqltypes.Operator(v1, v2, sqltypes.MinFn)
-				}
+				ret[aggr.Index] = sqltypes.Operator(v1, v2, sqltypes.MinFn)
 			case planner.AggrTypeMax:
-				v1, v2 := x[aggr.Index], y[aggr.Index]
-				if v1.Type() == sqltypes.Null {
-					ret[aggr.Index] = v2
-				} else if v2.Type() == sqltypes.Null {
-					ret[aggr.Index] = v1
-				} else {
-					ret[aggr.Index] = sqltypes.Operator(v1, v2, sqltypes.MaxFn)
-				}
-			case planner.AggrTypeAvg:
-				// nop
+				ret[aggr.Index] = sqltypes.Operator(v1, v2, sqltypes.MaxFn)
+			case planner.AggrTypeGroupConcat:
+				// The values of the former rows are in y.
+				ret[aggr.Index] = sqltypes.MakeTrusted(sqltypes.VarChar, []byte(v2.ToString()+aggr.Separator+v1.ToString()))
+			case planner.AggrTypeBitAnd:
+				ret[aggr.Index] = sqltypes.NewUint64(bitValue(aggr.Type, v1) & bitValue(aggr.Type, v2))
+			case planner.AggrTypeBitOr:
+				ret[aggr.Index] = sqltypes.NewUint64(bitValue(aggr.Type, v1) | bitValue(aggr.Type, v2))
+			case planner.AggrTypeBitXor:
+				ret[aggr.Index] = sqltypes.NewUint64(bitValue(aggr.Type, v1) ^ bitValue(aggr.Type, v2))
 			}
 		}
 		return ret
 	}
 }
+
+// isDistinctAggregator returns true if the aggregator is merged by the distinct values.
+func isDistinctAggregator(aggr planner.Aggregator) bool {
+	switch aggr.Type {
+	case planner.AggrTypeCountDistinct, planner.AggrTypeSumDistinct, planner.AggrTypeAvgDistinct, planner.AggrTypeGroupConcatDistinct:
+		return true
+	}
+	return false
+}
+
+// isStatAggregator returns true if the aggregator is the std or variance.
+func isStatAggregator(aggr planner.Aggregator) bool {
+	switch aggr.Type {
+	case planner.AggrTypeStddevPop, planner.AggrTypeStddevSamp, planner.AggrTypeVarPop, planner.AggrTypeVarSamp:
+		return true
+	}
+	return false
+}
+
+// emptyAggregateRow returns the row of the aggregation on the empty input,
+// the count is 0 and the others are NULL.
+func emptyAggregateRow(aggrs []planner.Aggregator, size int) []sqltypes.Value {
+	row := make([]sqltypes.Value, size)
+	for _, aggr := range aggrs {
+		if aggr.Type == planner.AggrTypeCount {
+			row[aggr.Index] = sqltypes.NewInt64(0)
+		}
+	}
+	return row
+}
+
+// aggregateFields used to set the types of the fields whose values are computed in the proxy.
+func aggregateFields(aggrs []planner.Aggregator, result *sqltypes.Result) {
+	for _, aggr := range aggrs {
+		var typ querypb.Type
+		switch aggr.Type {
+		case planner.AggrTypeCountDistinct:
+			typ = sqltypes.Int64
+		case planner.AggrTypeSumDistinct, planner.AggrTypeAvgDistinct:
+			typ = sqltypes.Decimal
+		case planner.AggrTypeGroupConcat, planner.AggrTypeGroupConcatDistinct:
+			typ = sqltypes.VarChar
+		case planner.AggrTypeBitAnd, planner.AggrTypeBitOr, planner.AggrTypeBitXor:
+			typ = sqltypes.Uint64
+		case planner.AggrTypeStddevPop, planner.AggrTypeStddevSamp, planner.AggrTypeVarPop, planner.AggrTypeVarSamp:
+			typ = sqltypes.Float64
+		default:
+			continue
+		}
+		if result.Fields[aggr.Index].Type != typ {
+			field := *result.Fields[aggr.Index]
+			field.Type = typ
+			result.Fields[aggr.Index] = &field
+		}
+	}
+}
+
+// bitValue returns the uint64 value of the bit function, NULL is the value of the empty set.
+func bitValue(typ planner.AggrType, v sqltypes.Value) uint64 {
+	if v.IsNull() {
+		if typ == planner.AggrTypeBitAnd {
+			return math.MaxUint64
+		}
+		return 0
+	}
+	if u, err := v.ParseUint64(); err == nil {
+		return u
+	}
+	if i, err := v.ParseInt64(); err == nil {
+		return uint64(i)
+	}
+	f, _ := v.ParseFloat64()
+	return uint64(int64(math.Round(f)))
+}
+
+// statState returns the count, mean and the sum of the squared differences from the mean
+// of the var_pop, count and avg values.
+func statState(vals []sqltypes.Value) (n, mean, m2 float64) {
+	n, _ = vals[1].ParseFloat64()
+	if n == 0 {
+		return 0, 0, 0
+	}
+	variance, _ := vals[0].ParseFloat64()
+	mean, _ = vals[2].ParseFloat64()
+	return n, mean, variance * n
+}
+
+// mergeStat merges the var_pop, count and avg values of the two sets by the parallel
+// algorithm of Chan et al., the sums of squares aren't used to avoid the overflow and
+// the cancellation.
+func mergeStat(x, y []sqltypes.Value) []sqltypes.Value {
+	n1, mean1, m21 := statState(x)
+	n2, mean2, m22 := statState(y)
+	switch {
+	case n1 == 0:
+		return y
+	case n2 == 0:
+		return x
+	}
+	n := n1 + n2
+	delta := mean2 - mean1
+	mean := mean1 + delta*n2/n
+	m2 := m21 + m22 + delta*delta*n1*n2/n
+	return []sqltypes.Value{
+		sqltypes.NewFloat64(m2 / n),
+		sqltypes.NewInt64(int64(n)),
+		sqltypes.NewFloat64(mean),
+	}
+}
+
+// statistic returns the std or variance computed by the var_pop, count and avg values.
+func statistic(typ planner.AggrType, vals []sqltypes.Value) sqltypes.Value {
+	n, _, m2 := statState(vals)
+	if n == 0 || (n == 1 && (typ == planner.AggrTypeStddevSamp || typ == planner.AggrTypeVarSamp)) {
+		return sqltypes.NULL
+	}
+	switch typ {
+	case planner.AggrTypeStddevPop:
+		return sqltypes.NewFloat64(math.Sqrt(m2 / n))
+	case planner.AggrTypeStddevSamp:
+		return sqltypes.NewFloat64(math.Sqrt(m2 / (n - 1)))
+	case planner.AggrTypeVarPop:
+		return sqltypes.NewFloat64(m2 / n)
+	}
+	return sqltypes.NewFloat64(m2 / (n - 1))
+}
+
+// distinctValues is the distinct non-NULL values of a group.
+type distinctValues struct {
+	seen   map[string]bool
+	values []sqltypes.Value
+}
+
+// add used to add the value if it isn't NULL and not seen.
+func (d *distinctValues) add(v sqltypes.Value) {
+	if v.IsNull() {
+		return
+	}
+	key := v.ToString()
+	if d.seen[key] {
+		return
+	}
+	d.seen[key] = true
+	d.values = append(d.values, v)
+}
+
+// aggregate returns the result of the distinct aggregator on the values.
+func (d *distinctValues) aggregate(aggr planner.Aggregator) sqltypes.Value {
+	if aggr.Type == planner.AggrTypeCountDistinct {
+		return sqltypes.NewInt64(int64(len(d.values)))
+	}
+	if len(d.values) == 0 {
+		return sqltypes.NULL
+	}
+
+	switch aggr.Type {
+	case planner.AggrTypeGroupConcatDistinct:
+		strs := make([]string, len(d.values))
+		for i, v := range d.values {
+			strs[i] = v.ToString()
+		}
+		return sqltypes.MakeTrusted(sqltypes.VarChar, []byte(strings.Join(strs, aggr.Separator)))
+	}
+	sum := d.values[0]
+	for _, v := range d.values[1:] {
+		sum = sqltypes.Operator(sum, v, sqltypes.SumFn)
+	}
+	if aggr.Type == planner.AggrTypeAvgDistinct {
+		return sqltypes.Operator(sum, sqltypes.NewInt64(int64(len(d.values))), sqltypes.DivFn)
+	}
+	return sum
+}
@@ -314,3 +314,217 @@ func TestAggregateGroup(t *testing.T) {
 		}
 	}
 }
+
+func TestAggregateDistinctExecutor(t *testing.T) {
+	fields := []*querypb.Field{
+		{Name: "name", Type: querypb.Type_VARCHAR},
+		{Name: "c", Type: querypb.Type_INT32},
+		{Name: "s", Type: querypb.Type_INT32},
+		{Name: "a", Type: querypb.Type_INT32},
+		{Name: "g", Type: querypb.Type_INT32},
+	}
+	result := func(rows ...[]string) *sqltypes.Result {
+		r := &sqltypes.Result{Fields: fields}
+		for _, row := range rows {
+			id := sqltypes.NULL
+			if row[1] != "" {
+				id = sqltypes.MakeTrusted(querypb.Type_INT32, []byte(row[1]))
+			}
+			r.Rows = append(r.Rows, []sqltypes.Value{sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte(row[0])), id, id, id, id})
+		}
+		return r
+	}
+	r3 := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "c", Type: querypb.Type_INT32},
+			{Name: "max(id)", Type: querypb.Type_INT32},
+		},
+	}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableBConfig())
+	assert.Nil(t, err)
+
+	// Create scatter and query handler.
+	scatter, fakedbs, cleanup := backend.MockScatter(log, 10)
+	defer cleanup()
+	fakedbs.AddQuery("select name, id as c, id as s, id as a, id as g from sbtest.B0 as B group by name, id order by name asc",
+		result([]string{"x", "1"}, []string{"x", "2"}, []string{"y", "3"}))
+	fakedbs.AddQuery("select name, id as c, id as s, id as a, id as g from sbtest.B1 as B group by name, id order by name asc",
+		result([]string{"x", "1"}, []string{"x", "2"}, []string{"x", "4"}, []string{"y", "3"}, []string{"z", ""}))
+	fakedbs.AddQuery("select id as c, max(id) from sbtest.B0 as B group by id", r3)
+	fakedbs.AddQuery("select id as c, max(id) from sbtest.B1 as B group by id", r3)
+
+	querys := []string{
+		"select name, count(distinct id) as c, sum(distinct id) as s, avg(distinct id) as a, group_concat(distinct id separator '|') as g from B group by name",
+		"select count(distinct id) as c, max(id) from B",
+	}
+	results := []string{
+		"[[x 3 7 2.3333333333333335 1|2|4] [y 1 3 3 3] [z 0   ]]",
+		"[[0 ]]",
+	}
+
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+
+		plan := planner.NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		txn, err := scatter.CreateTransaction()
+		assert.Nil(t, err)
+		defer txn.Finish()
+		executor := NewSelectExecutor(log, plan, txn)
+		{
+			ctx := xcontext.NewResultContext()
+			err := executor.Execute(ctx)
+			assert.Nil(t, err)
+			want := results[i]
+			got := fmt.Sprintf("%v", ctx.Results.Rows)
+			assert.Equal(t, want, got)
+		}
+	}
+}
+
+func TestAggregateStatExecutor(t *testing.T) {
+	fields := []*querypb.Field{
+		{Name: "sd", Type: querypb.Type_FLOAT64},
+		{Name: "count(id)", Type: querypb.Type_INT64},
+		{Name: "avg(id)", Type: querypb.Type_DECIMAL},
+		{Name: "vs", Type: querypb.Type_FLOAT64},
+		{Name: "count(id)", Type: querypb.Type_INT64},
+		{Name: "avg(id)", Type: querypb.Type_DECIMAL},
+		{Name: "bo", Type: querypb.Type_UINT64},
+		{Name: "ba", Type: querypb.Type_UINT64},
+		{Name: "g", Type: querypb.Type_VARCHAR},
+	}
+	result := func(vals ...string) *sqltypes.Result {
+		r := &sqltypes.Result{Fields: fields}
+		var row []sqltypes.Value
+		for i, val := range vals {
+			if val == "" {
+				row = append(row, sqltypes.NULL)
+				continue
+			}
+			row = append(row, sqltypes.MakeTrusted(fields[i].Type, []byte(val)))
+		}
+		r.Rows = append(r.Rows, row)
+		return r
+	}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableBConfig())
+	assert.Nil(t, err)
+
+	// Create scatter and query handler.
+	scatter, fakedbs, cleanup := backend.MockScatter(log, 10)
+	defer cleanup()
+	fakedbs.AddQuery("select var_pop(id) as sd, count(id), avg(id), var_pop(id) as vs, count(id), avg(id), bit_or(id) as bo, bit_and(id) as ba, group_concat(name) as g from sbtest.B0 as B",
+		result("0.6666666666666666", "3", "2.0000", "0.6666666666666666", "3", "2.0000", "3", "0", "a,b"))
+	fakedbs.AddQuery("select var_pop(id) as sd, count(id), avg(id), var_pop(id) as vs, count(id), avg(id), bit_or(id) as bo, bit_and(id) as ba, group_concat(name) as g from sbtest.B1 as B",
+		result("0", "1", "4.0000", "0", "1", "4.0000", "4", "4", ""))
+	// The sum of the squares overflows the BIGINT, the variances are merged by the means.
+	fakedbs.AddQuery("select var_pop(id) as sd, count(id), avg(id), var_pop(id) as vs, count(id), avg(id), bit_or(id) as bo, bit_and(id) as ba, group_concat(name) as g from sbtest.B0 as B where id > 3000000000",
+		result("0.6666666666666666", "3", "3000000002.0000", "0.6666666666666666", "3", "3000000002.0000", "3", "0", "a,b"))
+	fakedbs.AddQuery("select var_pop(id) as sd, count(id), avg(id), var_pop(id) as vs, count(id), avg(id), bit_or(id) as bo, bit_and(id) as ba, group_concat(name) as g from sbtest.B1 as B where id > 3000000000",
+		result("0", "1", "3000000004.0000", "0", "1", "3000000004.0000", "4", "4", ""))
+
+	querys := []string{
+		"select stddev_pop(id) as sd, var_samp(id) as vs, bit_or(id) as bo, bit_and(id) as ba, group_concat(name) as g from B",
+		"select stddev_pop(id) as sd, var_samp(id) as vs, bit_or(id) as bo, bit_and(id) as ba, group_concat(name) as g from B where id > 3000000000",
+	}
+	for _, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+
+		plan := planner.NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		txn, err := scatter.CreateTransaction()
+		assert.Nil(t, err)
+		defer txn.Finish()
+		executor := NewSelectExecutor(log, plan, txn)
+		ctx := xcontext.NewResultContext()
+		err = executor.Execute(ctx)
+		assert.Nil(t, err)
+		want := "[[1.118033988749895 1.6666666666666667 7 0 a,b]]"
+		got := fmt.Sprintf("%v", ctx.Results.Rows)
+		assert.Equal(t, want, got, query)
+		assert.Equal(t, 5, len(ctx.Results.Fields))
+		assert.Equal(t, querypb.Type_FLOAT64, ctx.Results.Fields[0].Type)
+	}
+}
+
+func TestAggregateExprExecutor(t *testing.T) {
+	fields := []*querypb.Field{
+		{Name: "name", Type: querypb.Type_VARCHAR},
+		{Name: "r", Type: querypb.Type_DECIMAL, Decimals: 4},
+		{Name: "m", Type: querypb.Type_VARCHAR},
+		{Name: "tmpa_0", Type: querypb.Type_DECIMAL},
+		{Name: "tmpa_1", Type: querypb.Type_INT64},
+		{Name: "tmpa_2", Type: querypb.Type_INT32},
+		{Name: "tmpa_3", Type: querypb.Type_INT64},
+	}
+	result := func(rows ...[]string) *sqltypes.Result {
+		r := &sqltypes.Result{Fields: fields}
+		for _, vals := range rows {
+			var row []sqltypes.Value
+			for i, val := range vals {
+				row = append(row, sqltypes.MakeTrusted(fields[i].Type, []byte(val)))
+			}
+			r.Rows = append(r.Rows, row)
+		}
+		return r
+	}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableBConfig())
+	assert.Nil(t, err)
+
+	// Create scatter and query handler.
+	scatter, fakedbs, cleanup := backend.MockScatter(log, 10)
+	defer cleanup()
+	fakedbs.AddQuery("select name, sum(id) / count(id) as r, if(max(id) > 3, 'y', 'n') as m, sum(id) as tmpa_0, count(id) as tmpa_1, max(id) as tmpa_2, count(*) as tmpa_3 from sbtest.B0 as B group by name order by name asc",
+		result([]string{"a", "1.0000", "n", "1", "1", "1", "1"}, []string{"b", "2.0000", "n", "4", "2", "3", "2"}))
+	fakedbs.AddQuery("select name, sum(id) / count(id) as r, if(max(id) > 3, 'y', 'n') as m, sum(id) as tmpa_0, count(id) as tmpa_1, max(id) as tmpa_2, count(*) as tmpa_3 from sbtest.B1 as B group by name order by name asc",
+		result([]string{"b", "5.0000", "y", "5", "1", "5", "1"}, []string{"c", "2.0000", "n", "2", "1", "2", "1"}))
+
+	query := "select name, sum(id)/count(id) as r, if(max(id) > 3, 'y', 'n') as m from B group by name having r > 1 or count(*) > 1"
+	node, err := sqlparser.Parse(query)
+	assert.Nil(t, err)
+
+	plan := planner.NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+	err = plan.Build()
+	assert.Nil(t, err)
+
+	txn, err := scatter.CreateTransaction()
+	assert.Nil(t, err)
+	defer txn.Finish()
+	executor := NewSelectExecutor(log, plan, txn)
+	{
+		ctx := xcontext.NewResultContext()
+		err := executor.Execute(ctx)
+		assert.Nil(t, err)
+		want := "[[b 3.0000 y] [c 2.0000 n]]"
+		got := fmt.Sprintf("%v", ctx.Results.Rows)
+		assert.Equal(t, want, got)
+		assert.Equal(t, 3, len(ctx.Results.Fields))
+	}
+}
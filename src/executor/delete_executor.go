@@ -39,6 +39,26 @@ func NewDeleteExecutor(log *xlog.Log, plan planner.Plan, txn backend.Transaction
 // Execute used to execute the executor.
 func (executor *DeleteExecutor) Execute(ctx *xcontext.ResultContext) error {
 	plan := executor.plan.(*planner.DeletePlan)
+	if err := Materialize(executor.log, plan, executor.txn); err != nil {
+		return err
+	}
+	// The table may be frozen after the plan is built, the write is in-flight
+	// until the txn is committed or rolled back.
+	if err := plan.BeginWrite(); err != nil {
+		return err
+	}
+	executor.txn.OnFinish(plan.EndWrite)
+
+	// The multi-table delete isn't pushed down, the rows are written by the joined rows.
+	if plan.Select != nil {
+		rs, err := writeRows(executor.log, executor.txn, plan.Select, plan.ReqMode, plan.RawQuery, plan.RowQuerys)
+		if err != nil {
+			return err
+		}
+		ctx.Results = rs
+		return nil
+	}
+
 	reqCtx := xcontext.NewRequestContext()
 	reqCtx.Mode = plan.ReqMode
 	reqCtx.TxnMode = xcontext.TxnWrite
@@ -19,6 +19,9 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/xelabs/go-mysqlstack/sqlparser"
 	"github.com/xelabs/go-mysqlstack/xlog"
+
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
 )
 
 func TestDeleteExecutor(t *testing.T) {
@@ -64,3 +67,55 @@ func TestDeleteExecutor(t *testing.T) {
 		}
 	}
 }
+
+func TestDeleteJoinExecutor(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	// Create scatter and query handler.
+	scatter, fakedbs, cleanup := backend.MockScatter(log, 10)
+	defer cleanup()
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableAConfig(), router.MockTableBConfig(), router.MockTableGConfig())
+	assert.Nil(t, err)
+
+	ids := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "id", Type: querypb.Type_INT32},
+		},
+		Rows: [][]sqltypes.Value{
+			{sqltypes.MakeTrusted(querypb.Type_INT32, []byte("1"))},
+		},
+	}
+	fakedbs.AddQueryPattern("select A.id from sbtest.A.*", ids)
+	fakedbs.AddQueryPattern("select B.id from sbtest.B.*", ids)
+	fakedbs.AddQueryPattern("delete from sbtest.[AB][0-9] where id = 1( and d = 1)?", &sqltypes.Result{RowsAffected: 1})
+	fakedbs.AddQueryPattern("delete A from sbtest.A[0-9] as A join sbtest.G .*", &sqltypes.Result{RowsAffected: 1})
+
+	querys := []string{
+		// A and B haven't the same partitions, the rows are deleted by the joined rows.
+		"delete A, B from A join B on A.id = B.id where B.d = 1",
+		// Pushed down per partition.
+		"delete A from A join G on A.b = G.b where G.c = 1",
+	}
+	wants := []uint64{2, 4}
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := planner.NewDeletePlan(log, database, query, node.(*sqlparser.Delete), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		txn, err := scatter.CreateTransaction()
+		assert.Nil(t, err)
+		defer txn.Finish()
+		executor := NewDeleteExecutor(log, plan, txn)
+		ctx := xcontext.NewResultContext()
+		err = executor.Execute(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, wants[i], ctx.Results.RowsAffected, query)
+	}
+}
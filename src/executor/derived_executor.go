@@ -0,0 +1,135 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package executor
+
+import (
+	"backend"
+	"evaluation"
+	"planner"
+	"xcontext"
+
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+var (
+	_ PlanExecutor = &DerivedExecutor{}
+)
+
+// DerivedExecutor represents derived table executor.
+type DerivedExecutor struct {
+	log  *xlog.Log
+	node *planner.DerivedNode
+	txn  backend.Transaction
+}
+
+// NewDerivedExecutor creates the new derived executor.
+func NewDerivedExecutor(log *xlog.Log, node *planner.DerivedNode, txn backend.Transaction) *DerivedExecutor {
+	return &DerivedExecutor{
+		log:  log,
+		node: node,
+		txn:  txn,
+	}
+}
+
+// execute used to execute the executor.
+// The inner plan is executed first, then the rows are projected to the returned fields.
+func (d *DerivedExecutor) execute(reqCtx *xcontext.RequestContext, ctx *xcontext.ResultContext) error {
+	plan := d.node.Plan
+	if err := Materialize(d.log, plan, d.txn); err != nil {
+		return err
+	}
+
+	req := xcontext.NewRequestContext()
+	req.Mode = reqCtx.Mode
+	req.TxnMode = reqCtx.TxnMode
+	req.RawQuery = plan.RawQuery
+	inner := xcontext.NewResultContext()
+	if err := buildExecutor(d.log, plan.Root, d.txn).execute(req, inner); err != nil {
+		return err
+	}
+	// The inner rows of all the shards are held in the proxy.
+	if err := checkMaxResult(d.txn, inner.Results); err != nil {
+		return err
+	}
+	if err := filterRows(d.node.Filters, inner.Results); err != nil {
+		return err
+	}
+
+	ctx.Results = project(inner.Results, d.node)
+	return execSubPlan(d.log, d.node, ctx)
+}
+
+// filterRows used to remove the rows which don't satisfy the filters.
+func filterRows(filters []evaluation.Evaluation, res *sqltypes.Result) error {
+	if len(filters) == 0 {
+		return nil
+	}
+	rows := res.Rows[:0]
+	for _, row := range res.Rows {
+		ok, err := filterHavings(filters, row)
+		if err != nil {
+			return err
+		}
+		if ok {
+			rows = append(rows, row)
+		}
+	}
+	res.Rows = rows
+	res.RowsAffected = uint64(len(rows))
+	return nil
+}
+
+// project computes the returned fields from the inner result, the aggregate field
+// is the partial aggregation of one row, such as 'count(a)' is 1 if a isn't null else 0.
+func project(inner *sqltypes.Result, node *planner.DerivedNode) *sqltypes.Result {
+	res := &sqltypes.Result{Fields: make([]*querypb.Field, len(node.Fields))}
+	for i, f := range node.Fields {
+		field := &querypb.Field{Name: f.Name, Table: f.Table, Type: querypb.Type_INT64}
+		if f.Aggr != planner.AggrTypeCount {
+			field.Type = inner.Fields[f.Index].Type
+			field.Charset = inner.Fields[f.Index].Charset
+			field.Decimals = inner.Fields[f.Index].Decimals
+			field.Flags = inner.Fields[f.Index].Flags
+		}
+		res.Fields[i] = field
+	}
+
+	one, zero := sqltypes.NewInt64(1), sqltypes.NewInt64(0)
+	for _, row := range inner.Rows {
+		out := make([]sqltypes.Value, len(node.Fields))
+		for i, f := range node.Fields {
+			switch {
+			case f.Aggr == planner.AggrTypeCount && f.Index == -1:
+				out[i] = one
+			case f.Aggr == planner.AggrTypeCount && row[f.Index].IsNull():
+				out[i] = zero
+			case f.Aggr == planner.AggrTypeCount:
+				out[i] = one
+			default:
+				out[i] = row[f.Index]
+			}
+		}
+		res.Rows = append(res.Rows, out)
+	}
+
+	// The aggregation without group by returns one row on the empty input.
+	if len(res.Rows) == 0 && node.Scalar {
+		out := make([]sqltypes.Value, len(node.Fields))
+		for i, f := range node.Fields {
+			if f.Aggr == planner.AggrTypeCount {
+				out[i] = zero
+			}
+		}
+		res.Rows = append(res.Rows, out)
+	}
+	res.RowsAffected = uint64(len(res.Rows))
+	return res
+}
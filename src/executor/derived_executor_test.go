@@ -0,0 +1,163 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package executor
+
+import (
+	"fmt"
+	"testing"
+
+	"backend"
+	"planner"
+	"router"
+	"xcontext"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/xlog"
+
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+)
+
+func TestDerivedExecutor(t *testing.T) {
+	inner := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "a",
+				Type: querypb.Type_INT32,
+			},
+			{
+				Name: "b",
+				Type: querypb.Type_INT32,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("1")),
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("10")),
+			},
+			{
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("2")),
+				sqltypes.NULL,
+			},
+			{
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("1")),
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("30")),
+			},
+		},
+	}
+	names := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name:  "name",
+				Type:  querypb.Type_VARCHAR,
+				Table: "A",
+			},
+			{
+				Name:  "id",
+				Type:  querypb.Type_INT32,
+				Table: "A",
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("go")),
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("1")),
+			},
+		},
+	}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+	err := route.AddForTest(database, router.MockTableAConfig())
+	assert.Nil(t, err)
+
+	// Create scatter and query handler.
+	scatter, fakedbs, cleanup := backend.MockScatter(log, 10)
+	defer cleanup()
+	fakedbs.AddQueryPattern("select a, b from sbtest.A[0-9] as A where id = 1", inner)
+	fakedbs.AddQueryPattern("select a, b from sbtest.A[0-9] as A where id = 2", &sqltypes.Result{Fields: inner.Fields})
+	fakedbs.AddQueryPattern("select a, b from sbtest.A[0-9] as A where id = 1 and a = 1", inner)
+	fakedbs.AddQueryPattern("select a, b from sbtest.A[0-9] as A where id = 1 limit 5", inner)
+	fakedbs.AddQueryPattern("select A.name, A.id from sbtest.A[0-9] as A where A.id = 1 order by A.id asc", names)
+
+	querys := []string{
+		"select t.a, count(*), count(t.b), sum(t.b), avg(t.b) from (select a, b from A where id=1) t group by t.a",
+		"select count(*), max(t.b) from (select a, b from A where id=2) t",
+		"select * from (select a, b from A where id=1) t order by t.b desc limit 2",
+		"select A.name, t.b from A join (select a, b from A where id=1) t on A.id=t.a where A.id=1",
+		"select t.a, count(distinct t.b), bit_or(t.b), group_concat(t.b) from (select a, b from A where id=1) t group by t.a",
+		// The filters are evaluated in the proxy.
+		"select * from (select a, b from A where id=1 limit 5) t where t.b>10",
+		"select t.a, count(*) from (select a, b from A where id=1) t group by t.a having count(*)>1",
+	}
+	results := []string{
+		"[[1 2 2 40 20] [2 1 0  ]]",
+		"[[0 ]]",
+		"[[1 30] [1 10]]",
+		"[[go 10] [go 30]]",
+		"[[1 2 30 10,30] [2 0 0 ]]",
+		"[[1 30]]",
+		"[[1 2]]",
+	}
+	fields := [][]string{
+		{"t.a", ".count(*)", ".count(t.b)", ".sum(t.b)", ".avg(t.b)"},
+		{".count(*)", ".max(t.b)"},
+		{"t.a", "t.b"},
+		{"A.name", "t.b"},
+		{"t.a", ".count(distinct t.b)", ".bit_or(t.b)", ".group_concat(t.b)"},
+		{"t.a", "t.b"},
+		{"t.a", ".count(*)"},
+	}
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := planner.NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		txn, err := scatter.CreateTransaction()
+		assert.Nil(t, err)
+		defer txn.Finish()
+		executor := NewSelectExecutor(log, plan, txn)
+		ctx := xcontext.NewResultContext()
+		err = executor.Execute(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, results[i], fmt.Sprintf("%v", ctx.Results.Rows))
+		var got []string
+		for _, field := range ctx.Results.Fields {
+			got = append(got, field.Table+"."+field.Name)
+		}
+		assert.Equal(t, fields[i], got)
+	}
+
+	// The inner rows of all the shards are larger than the max result size.
+	{
+		fakedbs.AddQueryPattern("select a from sbtest.A[0-9]+ as A", &sqltypes.Result{Fields: inner.Fields[:1], Rows: [][]sqltypes.Value{inner.Rows[0][:1]}})
+		query := "select * from (select a from A) t"
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := planner.NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		txn, err := scatter.CreateTransaction()
+		assert.Nil(t, err)
+		defer txn.Finish()
+		txn.SetMaxResult(3)
+		executor := NewSelectExecutor(log, plan, txn)
+		ctx := xcontext.NewResultContext()
+		err = executor.Execute(ctx)
+		assert.NotNil(t, err)
+		assert.Equal(t, "Query execution was interrupted, max memory usage[3 bytes] exceeded", err.Error())
+	}
+}
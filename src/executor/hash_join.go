@@ -0,0 +1,151 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package executor
+
+import (
+	"planner"
+	"strconv"
+	"strings"
+
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+)
+
+// hashJoin used to join `lres` and `rres` to `res`, the hash table is built on the smaller side
+// and the larger side probes it. The left rows are joined in the order they're read, the rows
+// with the same key are joined with the matched right rows by concatLeftAndRight.
+func hashJoin(lres, rres, res *sqltypes.Result, node *planner.JoinNode) error {
+	if len(lres.Rows) < len(rres.Rows) {
+		return hashJoinBuildLeft(lres, rres, res, node)
+	}
+	return hashJoinBuildRight(lres, rres, res, node)
+}
+
+// hashJoinBuildRight builds the hash table on the right rows, then probes it by the left rows.
+func hashJoinBuildRight(lres, rres, res *sqltypes.Result, node *planner.JoinNode) error {
+	table := make(map[string][][]sqltypes.Value, len(rres.Rows))
+	for _, row := range rres.Rows {
+		// The NULL key can't match.
+		if key, ok := hashJoinKey(row, node.RightKeys); ok {
+			table[key] = append(table[key], row)
+		}
+	}
+
+	for _, row := range lres.Rows {
+		lrows := [][]sqltypes.Value{row}
+		key, ok := hashJoinKey(row, node.LeftKeys)
+		if !ok {
+			if err := concatLeftAndNil(lrows, node, res); err != nil {
+				return err
+			}
+			continue
+		}
+		rrows, ok := table[key]
+		if !ok {
+			if err := concatLeftAndNil(lrows, node, res); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := concatLeftAndRight(lrows, rrows, node, res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hashJoinBuildLeft builds the hash table on the left rows, then the right rows probe it and are
+// collected by the matched key. The left rows are joined by the groups of the same key.
+func hashJoinBuildLeft(lres, rres, res *sqltypes.Result, node *planner.JoinNode) error {
+	type group struct {
+		lrows [][]sqltypes.Value
+		rrows [][]sqltypes.Value
+	}
+	var groups []*group
+	table := make(map[string]*group, len(lres.Rows))
+	for _, row := range lres.Rows {
+		key, ok := hashJoinKey(row, node.LeftKeys)
+		if !ok {
+			groups = append(groups, &group{lrows: [][]sqltypes.Value{row}})
+			continue
+		}
+		g, ok := table[key]
+		if !ok {
+			g = &group{}
+			table[key] = g
+			groups = append(groups, g)
+		}
+		g.lrows = append(g.lrows, row)
+	}
+
+	for _, row := range rres.Rows {
+		if key, ok := hashJoinKey(row, node.RightKeys); ok {
+			if g, ok := table[key]; ok {
+				g.rrows = append(g.rrows, row)
+			}
+		}
+	}
+
+	for _, g := range groups {
+		if len(g.rrows) == 0 {
+			if err := concatLeftAndNil(g.lrows, node, res); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := concatLeftAndRight(g.lrows, g.rrows, node, res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hashJoinKey returns the hash key of the join columns, false if any of them is NULL.
+// The numeric value is canonicalized, so 1, 1.0 and 1.00 have the same key as they
+// are equal in the sort merge join.
+func hashJoinKey(row []sqltypes.Value, keys []planner.JoinKey) (string, bool) {
+	var buf strings.Builder
+	for _, key := range keys {
+		v := row[key.Index]
+		if v.IsNull() {
+			return "", false
+		}
+		buf.WriteByte(0x01)
+		switch {
+		case v.IsIntegral():
+			buf.Write(v.Raw())
+		case v.IsFloat(), v.Type() == querypb.Type_DECIMAL:
+			buf.WriteString(canonicalNumber(v.String()))
+		default:
+			buf.Write(v.Raw())
+		}
+		buf.WriteByte(0x02)
+	}
+	return buf.String(), true
+}
+
+// canonicalNumber trims the trailing zeros of the fraction, '1.500' is '1.5' and '1.0' is '1'.
+// The exponent form is formatted to the decimal.
+func canonicalNumber(s string) string {
+	if strings.ContainsAny(s, "eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return s
+		}
+		s = strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(s, "0")
+		s = strings.TrimSuffix(s, ".")
+	}
+	if s == "-0" {
+		s = "0"
+	}
+	return s
+}
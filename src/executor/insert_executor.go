@@ -39,6 +39,32 @@ func NewInsertExecutor(log *xlog.Log, plan planner.Plan, txn backend.Transaction
 // Execute used to execute the executor.
 func (executor *InsertExecutor) Execute(ctx *xcontext.ResultContext) error {
 	plan := executor.plan.(*planner.InsertPlan)
+	if plan.Select != nil {
+		if err := Materialize(executor.log, plan.Select, executor.txn); err != nil {
+			return err
+		}
+	}
+	// The table may be frozen after the plan is built, the write is in-flight
+	// until the txn is committed or rolled back.
+	if err := plan.BeginWrite(); err != nil {
+		return err
+	}
+	executor.txn.OnFinish(plan.EndWrite)
+
+	// The shard key is updated, the rows are moved to the new partitions.
+	if plan.MoveShardKey {
+		rs, err := moveRows(executor.log, executor.txn, plan, plan.ReqMode, plan.RawQuery)
+		if err != nil {
+			return err
+		}
+		ctx.Results = rs
+		return nil
+	}
+
+	if plan.Select != nil {
+		return executor.insertSelect(plan, ctx)
+	}
+
 	reqCtx := xcontext.NewRequestContext()
 	reqCtx.Mode = plan.ReqMode
 	reqCtx.TxnMode = xcontext.TxnWrite
@@ -52,3 +78,14 @@ func (executor *InsertExecutor) Execute(ctx *xcontext.ResultContext) error {
 	ctx.Results = rs
 	return nil
 }
+
+// insertSelect executes the select in the txn, then inserts the rows in batches,
+// every batch is routed by the shard key of the table inserted into.
+func (executor *InsertExecutor) insertSelect(plan *planner.InsertPlan, ctx *xcontext.ResultContext) error {
+	rs, err := writeRows(executor.log, executor.txn, plan.Select, plan.ReqMode, plan.RawQuery, plan.RowQuerys)
+	if err != nil {
+		return err
+	}
+	ctx.Results = rs
+	return nil
+}
@@ -9,7 +9,9 @@
 package executor
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"backend"
 	"fakedb"
@@ -20,6 +22,9 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/xelabs/go-mysqlstack/sqlparser"
 	"github.com/xelabs/go-mysqlstack/xlog"
+
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
 )
 
 func TestInsertExecutor(t *testing.T) {
@@ -63,3 +68,217 @@ func TestInsertExecutor(t *testing.T) {
 		}
 	}
 }
+
+func TestInsertExecutorFrozen(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	// Create scatter and query handler.
+	scatter, fakedbs, cleanup := backend.MockScatter(log, 10)
+	defer cleanup()
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableAConfig())
+	assert.Nil(t, err)
+	fakedbs.AddQueryPattern("insert into sbtest.A.*", fakedb.Result3)
+
+	query := "insert into A(id, b, c) values(1,2,3)"
+	node, err := sqlparser.Parse(query)
+	assert.Nil(t, err)
+	plan := planner.NewInsertPlan(log, database, query, node.(*sqlparser.Insert), route)
+	err = plan.Build()
+	assert.Nil(t, err)
+
+	// The table is frozen after the plan is built.
+	err = route.FreezeTable(database, "A")
+	assert.Nil(t, err)
+
+	txn, err := scatter.CreateTransaction()
+	assert.Nil(t, err)
+	executor := NewInsertExecutor(log, plan, txn)
+	ctx := xcontext.NewResultContext()
+	err = executor.Execute(ctx)
+	assert.Equal(t, "router.table[sbtest.A].is.frozen.for.resharding,please.retry.later", err.Error())
+
+	// The write is in-flight until the txn is finished.
+	route.UnfreezeTable(database, "A")
+	err = executor.Execute(ctx)
+	assert.Nil(t, err)
+	err = route.WaitWrites(database, "A", time.Millisecond*10)
+	assert.NotNil(t, err)
+	txn.Finish()
+	err = route.WaitWrites(database, "A", time.Millisecond*10)
+	assert.Nil(t, err)
+}
+
+func TestInsertSelectExecutor(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	// Create scatter and query handler.
+	scatter, fakedbs, cleanup := backend.MockScatter(log, 10)
+	defer cleanup()
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableAConfig(), router.MockTableGConfig())
+	assert.Nil(t, err)
+
+	// The select returns more rows than one batch.
+	rs := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "id", Type: querypb.Type_INT32},
+			{Name: "b", Type: querypb.Type_INT32},
+		},
+	}
+	for i := 0; i < writeRowsBatch*2+1; i++ {
+		rs.Rows = append(rs.Rows, []sqltypes.Value{
+			sqltypes.MakeTrusted(querypb.Type_INT32, []byte("1")),
+			sqltypes.MakeTrusted(querypb.Type_INT32, []byte(fmt.Sprintf("%d", i))),
+		})
+	}
+	fakedbs.AddQueryPattern("select id, b from sbtest.G", rs)
+	fakedbs.AddQueryPattern("select id, b from sbtest.A.* as A", &sqltypes.Result{Fields: rs.Fields})
+	fakedbs.AddQueryPattern("insert into sbtest.A.*", &sqltypes.Result{RowsAffected: 1})
+	fakedbs.AddQueryPattern("insert into sbtest.G.*", &sqltypes.Result{RowsAffected: 1})
+
+	querys := []string{
+		"insert into A(id, b) select id, b from G",
+		"insert into G(id, b) select id, b from A",
+	}
+	// The rows with the same shard key are written into one partition in 3 batches.
+	// The select from A returns no rows.
+	wants := []uint64{3, 0}
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+
+		plan := planner.NewInsertPlan(log, database, query, node.(*sqlparser.Insert), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+		assert.NotNil(t, plan.Select)
+
+		txn, err := scatter.CreateTransaction()
+		assert.Nil(t, err)
+		defer txn.Finish()
+		executor := NewInsertExecutor(log, plan, txn)
+		ctx := xcontext.NewResultContext()
+		err = executor.Execute(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, wants[i], ctx.Results.RowsAffected, query)
+	}
+
+	// The select returns the wrong column count.
+	{
+		query := "insert into A(id, b, c) select id, b from G"
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := planner.NewInsertPlan(log, database, query, node.(*sqlparser.Insert), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		txn, err := scatter.CreateTransaction()
+		assert.Nil(t, err)
+		defer txn.Finish()
+		executor := NewInsertExecutor(log, plan, txn)
+		err = executor.Execute(xcontext.NewResultContext())
+		assert.Equal(t, "column.count[3].doesn't.match.value.count[2]", err.Error())
+	}
+}
+
+func TestInsertMoveShardKeyExecutor(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	// Create scatter and query handler.
+	scatter, fakedbs, cleanup := backend.MockScatter(log, 10)
+	defer cleanup()
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableAConfig())
+	assert.Nil(t, err)
+
+	index := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "index_name", Type: querypb.Type_VARCHAR},
+			{Name: "column_name", Type: querypb.Type_VARCHAR},
+		},
+		Rows: [][]sqltypes.Value{
+			{sqltypes.NewVarChar("PRIMARY"), sqltypes.NewVarChar("id")},
+		},
+	}
+	rs := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "0", Type: querypb.Type_INT64},
+			{Name: "id", Type: querypb.Type_INT32},
+			{Name: "b", Type: querypb.Type_INT32},
+			{Name: "99999999", Type: querypb.Type_INT64},
+		},
+		Rows: [][]sqltypes.Value{
+			{sqltypes.NewInt64(0), sqltypes.NewInt32(1), sqltypes.NewInt32(2), sqltypes.NewInt64(99999999)},
+		},
+	}
+	fakedbs.AddQueryPattern("XA .*", &sqltypes.Result{})
+	fakedbs.AddQueryPattern("select index_name, column_name from information_schema.statistics .*", index)
+	fakedbs.AddQueryPattern("select 0, \\*, 99999999 from sbtest.A.* where id = 1 limit 1 for update", rs)
+	fakedbs.AddQueryPattern("select 1, \\*, 99999999 from sbtest.A.* where id = 2 limit 1 for update", &sqltypes.Result{Fields: rs.Fields})
+	fakedbs.AddQueryPattern("delete from sbtest.A.* where id = 1", &sqltypes.Result{RowsAffected: 1})
+	fakedbs.AddQueryPattern("insert into sbtest.A.*", &sqltypes.Result{RowsAffected: 1})
+
+	// The row 1 is moved, the row 2 is inserted.
+	query := "insert into sbtest.A(id, b) values(1, 3), (2, 4) on duplicate key update id = 99999999"
+	node, err := sqlparser.Parse(query)
+	assert.Nil(t, err)
+	plan := planner.NewInsertPlan(log, database, query, node.(*sqlparser.Insert), route)
+	err = plan.Build()
+	assert.Nil(t, err)
+
+	txn, err := scatter.CreateTransaction()
+	assert.Nil(t, err)
+	defer txn.Finish()
+	err = txn.Begin()
+	assert.Nil(t, err)
+	executor := NewInsertExecutor(log, plan, txn)
+	ctx := xcontext.NewResultContext()
+	err = executor.Execute(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(3), ctx.Results.RowsAffected)
+	err = txn.Commit()
+	assert.Nil(t, err)
+
+	// The twopc is required.
+	{
+		plan := planner.NewInsertPlan(log, database, query, node.(*sqlparser.Insert), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		txn, err := scatter.CreateTransaction()
+		assert.Nil(t, err)
+		defer txn.Finish()
+		executor := NewInsertExecutor(log, plan, txn)
+		err = executor.Execute(xcontext.NewResultContext())
+		assert.NotNil(t, err)
+	}
+
+	// The read error.
+	{
+		fakedbs.AddQueryErrorPattern("select index_name, column_name from information_schema.statistics .*", fmt.Errorf("mock.index.error"))
+		plan := planner.NewInsertPlan(log, database, query, node.(*sqlparser.Insert), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		txn, err := scatter.CreateTransaction()
+		assert.Nil(t, err)
+		defer txn.Finish()
+		err = txn.Begin()
+		assert.Nil(t, err)
+		executor := NewInsertExecutor(log, plan, txn)
+		err = executor.Execute(xcontext.NewResultContext())
+		assert.NotNil(t, err)
+	}
+}
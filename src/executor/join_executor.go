@@ -10,7 +10,9 @@ package executor
 
 import (
 	"backend"
+	"evaluation"
 	"planner"
+	"strings"
 	"sync"
 	"xcontext"
 
@@ -42,6 +44,13 @@ func NewJoinExecutor(log *xlog.Log, node *planner.JoinNode, txn backend.Transact
 
 // execute used to execute the executor.
 func (j *JoinExecutor) execute(reqCtx *xcontext.RequestContext, ctx *xcontext.ResultContext) error {
+	if j.node.Strategy == planner.NestedLoop {
+		return j.lookupJoin(reqCtx, ctx)
+	}
+	if dir, size := j.txn.Spill(); size > 0 && j.node.Strategy == planner.SortMerge {
+		return j.spillJoin(reqCtx, ctx, dir, size)
+	}
+
 	var mu sync.Mutex
 	var wg sync.WaitGroup
 	allErrors := make([]error, 0, 8)
@@ -61,70 +70,149 @@ func (j *JoinExecutor) execute(reqCtx *xcontext.RequestContext, ctx *xcontext.Re
 
 	lctx := xcontext.NewResultContext()
 	rctx := xcontext.NewResultContext()
-	wg.Add(1)
-	go oneExec(j.left, lctx)
-	wg.Add(1)
-	go oneExec(j.right, rctx)
-	wg.Wait()
-	if len(allErrors) > 0 {
-		return allErrors[0]
+	if j.txn.IsTwoPC() {
+		// The twopc txn shares the backend connections, the arms are executed one by one.
+		wg.Add(1)
+		oneExec(j.left, lctx)
+		if len(allErrors) > 0 {
+			return allErrors[0]
+		}
+		wg.Add(1)
+		oneExec(j.right, rctx)
+		if len(allErrors) > 0 {
+			return allErrors[0]
+		}
+	} else {
+		wg.Add(1)
+		go oneExec(j.left, lctx)
+		wg.Add(1)
+		go oneExec(j.right, rctx)
+		wg.Wait()
+		if len(allErrors) > 0 {
+			return allErrors[0]
+		}
 	}
 
 	ctx.Results = &sqltypes.Result{}
-	ctx.Results.Fields = joinFields(lctx.Results.Fields, rctx.Results.Fields, j.node.Cols)
+	ctx.Results.Fields = joinFields(lctx.Results.Fields, rctx.Results.Fields, j.node)
 	if len(lctx.Results.Rows) == 0 {
 		return nil
 	}
 
+	var err error
 	if len(rctx.Results.Rows) == 0 {
-		concatLeftAndNil(lctx.Results.Rows, j.node, ctx.Results)
+		err = concatLeftAndNil(lctx.Results.Rows, j.node, ctx.Results)
 	} else {
 		switch j.node.Strategy {
 		case planner.SortMerge:
-			sortMergeJoin(lctx.Results, rctx.Results, ctx.Results, j.node)
+			err = sortMergeJoin(lctx.Results, rctx.Results, ctx.Results, j.node)
+		case planner.HashJoin:
+			err = hashJoin(lctx.Results, rctx.Results, ctx.Results, j.node)
 		case planner.Cartesian:
-			cartesianProduct(lctx.Results, rctx.Results, ctx.Results, j.node)
+			err = cartesianProduct(lctx.Results, rctx.Results, ctx.Results, j.node)
 		}
 	}
+	if err != nil {
+		return err
+	}
 
+	joinExprFields(ctx.Results, j.node)
 	return execSubPlan(j.log, j.node, ctx)
 }
 
-// joinFields used to join two fields.
-func joinFields(lfields, rfields []*querypb.Field, cols []int) []*querypb.Field {
-	fields := make([]*querypb.Field, len(cols))
-	for i, index := range cols {
-		if index < 0 {
+// joinFields used to join two fields, the type of the expression field is set by joinExprFields.
+func joinFields(lfields, rfields []*querypb.Field, node *planner.JoinNode) []*querypb.Field {
+	fields := make([]*querypb.Field, len(node.Cols))
+	for i, index := range node.Cols {
+		switch {
+		case index < 0:
 			fields[i] = lfields[-index-1]
-			continue
+		case index > 0:
+			fields[i] = rfields[index-1]
+		default:
+			fields[i] = &querypb.Field{Name: node.Exprs[i].Field, Type: sqltypes.Null}
 		}
-		fields[i] = rfields[index-1]
 	}
 	return fields
 }
 
-// joinRows used to join two rows.
-func joinRows(lrow, rrow []sqltypes.Value, cols []int) []sqltypes.Value {
-	row := make([]sqltypes.Value, len(cols))
-	for i, index := range cols {
-		if index < 0 {
-			row[i] = lrow[-index-1]
-			continue
+// joinExprFields used to set the types of the expression fields by the values, the values
+// of different types are converted to the same type.
+func joinExprFields(res *sqltypes.Result, node *planner.JoinNode) {
+	for i := range node.Exprs {
+		field := res.Fields[i]
+		for _, row := range res.Rows {
+			v := row[i]
+			if v.IsNull() {
+				continue
+			}
+			typ := v.Type()
+			switch {
+			case field.Type == sqltypes.Null, field.Type == typ:
+				field.Type = typ
+			case isNumeric(field.Type) && isNumeric(typ):
+				if sqltypes.IsFloat(field.Type) || sqltypes.IsFloat(typ) {
+					field.Type = sqltypes.Float64
+				} else {
+					field.Type = sqltypes.Decimal
+				}
+			default:
+				field.Type = sqltypes.VarChar
+			}
+			// The decimals is the max scale of the values.
+			if str := v.ToString(); typ == sqltypes.Decimal && strings.Contains(str, ".") {
+				if scale := uint32(len(str) - strings.IndexByte(str, '.') - 1); scale > field.Decimals {
+					field.Decimals = scale
+				}
+			}
+		}
+		for _, row := range res.Rows {
+			if !row[i].IsNull() && (row[i].Type() != field.Type || field.Type == sqltypes.Decimal) {
+				row[i] = evaluation.Convert(row[i], field)
+			}
 		}
-		// rrow can be nil on left joins
-		if rrow != nil {
-			row[i] = rrow[index-1]
+	}
+}
+
+// isNumeric returns true if the type is the integral, float or decimal.
+func isNumeric(typ querypb.Type) bool {
+	return sqltypes.IsIntegral(typ) || sqltypes.IsFloat(typ) || typ == sqltypes.Decimal
+}
+
+// joinRows used to join two rows, the expressions are computed by the rows.
+func joinRows(lrow, rrow []sqltypes.Value, node *planner.JoinNode) ([]sqltypes.Value, error) {
+	row := make([]sqltypes.Value, len(node.Cols))
+	for i, index := range node.Cols {
+		switch {
+		case index < 0:
+			row[i] = lrow[-index-1]
+		case index > 0:
+			// rrow can be nil on left joins
+			if rrow != nil {
+				row[i] = rrow[index-1]
+			}
+		default:
+			val, err := node.Exprs[i].Eval(lrow, rrow)
+			if err != nil {
+				return nil, err
+			}
+			row[i] = val
 		}
 	}
-	return row
+	return row, nil
 }
 
 // cartesianProduct used to produce cartesian product.
-func cartesianProduct(lres, rres, res *sqltypes.Result, node *planner.JoinNode) {
+func cartesianProduct(lres, rres, res *sqltypes.Result, node *planner.JoinNode) error {
 	for _, lrow := range lres.Rows {
 		for _, rrow := range rres.Rows {
-			res.Rows = append(res.Rows, joinRows(lrow, rrow, node.Cols))
+			row, err := joinRows(lrow, rrow, node)
+			if err != nil {
+				return err
+			}
+			res.Rows = append(res.Rows, row)
 			res.RowsAffected++
 		}
 	}
+	return nil
 }
@@ -0,0 +1,114 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package executor
+
+import (
+	"planner"
+	"xcontext"
+
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+)
+
+const (
+	// lookupBatch is the max count of the key values in one 'IN' filter.
+	lookupBatch = 1000
+
+	// lookupMaxKeys is the max count of the key values looked up, if the left side
+	// returns more, the right side is read fully and joined by the hash join.
+	lookupMaxKeys = 16 * lookupBatch
+)
+
+// lookupJoin used to execute the NestedLoop join. The left side is read first, the distinct
+// values of its LookupKey are pushed into the right side's querys as 'IN' filter in batches,
+// so the right side only returns the rows which may match, and is pruned by the shard key.
+func (j *JoinExecutor) lookupJoin(reqCtx *xcontext.RequestContext, ctx *xcontext.ResultContext) error {
+	newReq := func() *xcontext.RequestContext {
+		req := xcontext.NewRequestContext()
+		req.Mode = reqCtx.Mode
+		req.TxnMode = reqCtx.TxnMode
+		req.RawQuery = reqCtx.RawQuery
+		return req
+	}
+
+	lctx := xcontext.NewResultContext()
+	if err := j.left.execute(newReq(), lctx); err != nil {
+		return err
+	}
+	lres := lctx.Results
+
+	rres := &sqltypes.Result{}
+	vals := lookupValues(lres.Rows, j.node.LeftKeys[j.node.LookupKey])
+	if len(vals) > lookupMaxKeys {
+		rctx := xcontext.NewResultContext()
+		if err := j.right.execute(newReq(), rctx); err != nil {
+			return err
+		}
+		rres = rctx.Results
+	} else {
+		// The empty vals still query once to get the fields.
+		for begin := 0; begin == 0 || begin < len(vals); begin += lookupBatch {
+			end := begin + lookupBatch
+			if end > len(vals) {
+				end = len(vals)
+			}
+			querys, err := j.node.LookupQuerys(vals[begin:end])
+			if err != nil {
+				return err
+			}
+			req := newReq()
+			req.Querys = querys
+			res, err := j.txn.Execute(req)
+			if err != nil {
+				return err
+			}
+			if rres.Fields == nil {
+				rres.Fields = res.Fields
+			}
+			rres.Rows = append(rres.Rows, res.Rows...)
+			rres.RowsAffected += res.RowsAffected
+		}
+	}
+
+	ctx.Results = &sqltypes.Result{}
+	ctx.Results.Fields = joinFields(lres.Fields, rres.Fields, j.node)
+	if len(lres.Rows) == 0 {
+		return nil
+	}
+
+	var err error
+	if len(rres.Rows) == 0 {
+		err = concatLeftAndNil(lres.Rows, j.node, ctx.Results)
+	} else {
+		err = hashJoin(lres, rres, ctx.Results, j.node)
+	}
+	if err != nil {
+		return err
+	}
+	joinExprFields(ctx.Results, j.node)
+	return execSubPlan(j.log, j.node, ctx)
+}
+
+// lookupValues returns the distinct values of the key in the rows, the NULL is skipped as it can't match.
+func lookupValues(rows [][]sqltypes.Value, key planner.JoinKey) []sqltypes.Value {
+	var vals []sqltypes.Value
+	seen := make(map[string]struct{}, len(rows))
+	keys := []planner.JoinKey{key}
+	for _, row := range rows {
+		hash, ok := hashJoinKey(row, keys)
+		if !ok {
+			continue
+		}
+		if _, ok := seen[hash]; ok {
+			continue
+		}
+		seen[hash] = struct{}{}
+		vals = append(vals, row[key.Index])
+	}
+	return vals
+}
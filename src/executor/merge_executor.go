@@ -13,6 +13,7 @@ import (
 	"planner"
 	"xcontext"
 
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
 	"github.com/xelabs/go-mysqlstack/xlog"
 )
 
@@ -38,6 +39,13 @@ func NewMergeExecutor(log *xlog.Log, node *planner.MergeNode, txn backend.Transa
 
 // execute used to execute the executor.
 func (m *MergeExecutor) execute(reqCtx *xcontext.RequestContext, ctx *xcontext.ResultContext) error {
+	if orderBy, limit, ok := mergePlans(m.node); ok && isMergeable(m.node, m.txn, orderBy) {
+		return m.mergeExecute(reqCtx, ctx, orderBy, limit)
+	}
+	if dir, size := m.txn.Spill(); size > 0 && isSpillable(m.node) {
+		return m.spillExecute(reqCtx, ctx, dir, size)
+	}
+
 	var err error
 	reqCtx.Querys = m.node.Querys
 	if ctx.Results, err = m.txn.Execute(reqCtx); err != nil {
@@ -46,3 +54,44 @@ func (m *MergeExecutor) execute(reqCtx *xcontext.RequestContext, ctx *xcontext.R
 
 	return execSubPlan(m.log, m.node, ctx)
 }
+
+// isMergeable returns true if the ordered rows of the querys can be merged by the k-way merge.
+// The twopc txn holds one connection per backend, which can't read the querys of the same
+// backend at the same time.
+func isMergeable(node *planner.MergeNode, txn backend.Transaction, orderBy *planner.OrderByPlan) bool {
+	if len(node.Querys) < 2 || txn.IsTwoPC() {
+		return false
+	}
+	return orderBy == nil || !orderBy.HasExpr()
+}
+
+// mergeExecute used to execute the MergeNode whose children are only the order by and limit.
+// The ordered rows of the querys are merged by the k-way merge, and the reads of the backends
+// stop once the limit is satisfied.
+func (m *MergeExecutor) mergeExecute(reqCtx *xcontext.RequestContext, ctx *xcontext.ResultContext, orderBy *planner.OrderByPlan, limit *planner.LimitPlan) error {
+	reqCtx.Querys = m.node.Querys
+	cursors, err := m.txn.ExecuteStreamCursors(reqCtx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, cursor := range cursors {
+			cursor.Close()
+		}
+	}()
+
+	size := newResultSize(m.txn)
+	ctx.Results = &sqltypes.Result{Fields: cursors[0].Fields()}
+	add := func(row []sqltypes.Value) error {
+		if err := size.add(row); err != nil {
+			return err
+		}
+		ctx.Results.Rows = append(ctx.Results.Rows, row)
+		return nil
+	}
+	if err := mergeCursors(m.log, cursors, orderBy, limit, add); err != nil {
+		return err
+	}
+	ctx.Results.RowsAffected = uint64(len(ctx.Results.Rows))
+	return nil
+}
@@ -9,6 +9,7 @@
 package executor
 
 import (
+	"evaluation"
 	"planner"
 	"sync"
 
@@ -18,7 +19,7 @@ import (
 )
 
 // sortMergeJoin used to join `lres` and `rres` to `res`.
-func sortMergeJoin(lres, rres, res *sqltypes.Result, node *planner.JoinNode) {
+func sortMergeJoin(lres, rres, res *sqltypes.Result, node *planner.JoinNode) error {
 	var wg sync.WaitGroup
 	sort := func(keys []planner.JoinKey, res *sqltypes.Result) {
 		defer wg.Done()
@@ -33,17 +34,16 @@ func sortMergeJoin(lres, rres, res *sqltypes.Result, node *planner.JoinNode) {
 	go sort(node.RightKeys, rres)
 	wg.Wait()
 
-	mergeJoin(lres, rres, res, node)
+	return mergeJoin(lres, rres, res, node)
 }
 
 // mergeJoin used to join the sorted results.
-func mergeJoin(lres, rres, res *sqltypes.Result, node *planner.JoinNode) {
+func mergeJoin(lres, rres, res *sqltypes.Result, node *planner.JoinNode) error {
 	lrows, lidx, lstr := fetchSameKeyRows(lres.Rows, node.LeftKeys, 0, "", node.LeftUnique)
 	rrows, ridx, rstr := fetchSameKeyRows(rres.Rows, node.RightKeys, 0, "", node.RightUnique)
 	for lrows != nil {
 		if rrows == nil {
-			concatLeftAndNil(lres.Rows[lidx-len(lrows):], node, res)
-			break
+			return concatLeftAndNil(lres.Rows[lidx-len(lrows):], node, res)
 		}
 
 		cmp := 0
@@ -60,20 +60,27 @@ func mergeJoin(lres, rres, res *sqltypes.Result, node *planner.JoinNode) {
 		}
 
 		if cmp == 0 {
+			var err error
 			if isNull {
-				concatLeftAndNil(lrows, node, res)
+				err = concatLeftAndNil(lrows, node, res)
 			} else {
-				concatLeftAndRight(lrows, rrows, node, res)
+				err = concatLeftAndRight(lrows, rrows, node, res)
+			}
+			if err != nil {
+				return err
 			}
 			lrows, lidx, lstr = fetchSameKeyRows(lres.Rows, node.LeftKeys, lidx, lstr, node.LeftUnique)
 			rrows, ridx, rstr = fetchSameKeyRows(rres.Rows, node.RightKeys, ridx, rstr, node.RightUnique)
 		} else if cmp > 0 {
 			rrows, ridx, rstr = fetchSameKeyRows(rres.Rows, node.RightKeys, ridx, rstr, node.RightUnique)
 		} else {
-			concatLeftAndNil(lrows, node, res)
+			if err := concatLeftAndNil(lrows, node, res); err != nil {
+				return err
+			}
 			lrows, lidx, lstr = fetchSameKeyRows(lres.Rows, node.LeftKeys, lidx, lstr, node.LeftUnique)
 		}
 	}
+	return nil
 }
 
 // fetchSameKeyRows used to fetch the same joinkey values' rows.
@@ -121,13 +128,12 @@ func fetchSameKeyRows(rows [][]sqltypes.Value, joins []planner.JoinKey, index in
 }
 
 // concatLeftAndRight used to concat thle left and right results, handle otherJoinOn|rightNull|OtherFilter.
-func concatLeftAndRight(lrows, rrows [][]sqltypes.Value, node *planner.JoinNode, res *sqltypes.Result) {
+func concatLeftAndRight(lrows, rrows [][]sqltypes.Value, node *planner.JoinNode, res *sqltypes.Result) error {
 	for _, lrow := range lrows {
 		blend := true
 		matchCnt := 0
 		for _, idx := range node.LeftTmpCols {
-			vn := lrow[idx].ToNative()
-			if vn.(int64) == 0 {
+			if !evaluation.IsTrue(lrow[idx]) {
 				blend = false
 				break
 			}
@@ -181,6 +187,18 @@ func concatLeftAndRight(lrows, rrows [][]sqltypes.Value, node *planner.JoinNode,
 						break
 					}
 				}
+				if match {
+					for _, filter := range node.Filters {
+						v, err := filter.Eval(lrow, rrow)
+						if err != nil {
+							return err
+						}
+						if !evaluation.IsTrue(v) {
+							match = false
+							break
+						}
+					}
+				}
 				if match {
 					matchCnt++
 					ok := true
@@ -191,7 +209,11 @@ func concatLeftAndRight(lrows, rrows [][]sqltypes.Value, node *planner.JoinNode,
 						}
 					}
 					if ok {
-						res.Rows = append(res.Rows, joinRows(lrow, rrow, node.Cols))
+						row, err := joinRows(lrow, rrow, node)
+						if err != nil {
+							return err
+						}
+						res.Rows = append(res.Rows, row)
 						res.RowsAffected++
 					}
 				}
@@ -199,16 +221,24 @@ func concatLeftAndRight(lrows, rrows [][]sqltypes.Value, node *planner.JoinNode,
 		}
 
 		if matchCnt == 0 {
-			concatLeftAndNil([][]sqltypes.Value{lrow}, node, res)
+			if err := concatLeftAndNil([][]sqltypes.Value{lrow}, node, res); err != nil {
+				return err
+			}
 		}
 	}
+	return nil
 }
 
-func concatLeftAndNil(lrows [][]sqltypes.Value, node *planner.JoinNode, res *sqltypes.Result) {
+func concatLeftAndNil(lrows [][]sqltypes.Value, node *planner.JoinNode, res *sqltypes.Result) error {
 	if node.IsLeftJoin && !node.HasRightFilter {
-		for _, row := range lrows {
-			res.Rows = append(res.Rows, joinRows(row, nil, node.Cols))
+		for _, lrow := range lrows {
+			row, err := joinRows(lrow, nil, node)
+			if err != nil {
+				return err
+			}
+			res.Rows = append(res.Rows, row)
 			res.RowsAffected++
 		}
 	}
+	return nil
 }
@@ -10,9 +10,11 @@ package executor
 
 import (
 	"planner"
+	"sort"
 	"xcontext"
 
 	"github.com/pkg/errors"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
 	"github.com/xelabs/go-mysqlstack/xlog"
 )
 
@@ -38,6 +40,9 @@ func NewOrderByExecutor(log *xlog.Log, plan planner.Plan) *OrderByExecutor {
 func (executor *OrderByExecutor) Execute(ctx *xcontext.ResultContext) error {
 	rs := ctx.Results
 	plan := executor.plan.(*planner.OrderByPlan)
+	if plan.HasExpr() {
+		return sortByExprs(rs, plan)
+	}
 
 	for _, orderby := range plan.OrderBys {
 		switch orderby.Direction {
@@ -54,3 +59,58 @@ func (executor *OrderByExecutor) Execute(ctx *xcontext.ResultContext) error {
 	rs.Sort()
 	return nil
 }
+
+// sortByExprs used to sort the rows by the order by columns and expressions,
+// the keys of every row are computed once.
+func sortByExprs(rs *sqltypes.Result, plan *planner.OrderByPlan) error {
+	type sortRow struct {
+		keys []sqltypes.Value
+		row  []sqltypes.Value
+	}
+
+	indexes := make([]int, len(plan.OrderBys))
+	for i, by := range plan.OrderBys {
+		indexes[i] = -1
+		if by.Evaluation() == nil {
+			index, err := orderByIndex(rs.Fields, by)
+			if err != nil {
+				return err
+			}
+			indexes[i] = index
+		}
+	}
+
+	rows := make([]sortRow, len(rs.Rows))
+	for i, row := range rs.Rows {
+		keys := make([]sqltypes.Value, len(plan.OrderBys))
+		for j, by := range plan.OrderBys {
+			if indexes[j] != -1 {
+				keys[j] = row[indexes[j]]
+				continue
+			}
+			val, err := by.Evaluation().Eval(row)
+			if err != nil {
+				return err
+			}
+			keys[j] = val
+		}
+		rows[i] = sortRow{keys: keys, row: row}
+	}
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		for k, by := range plan.OrderBys {
+			cmp := sqltypes.NullsafeCompare(rows[i].keys[k], rows[j].keys[k])
+			if by.Direction == planner.DESC {
+				cmp = -cmp
+			}
+			if cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return false
+	})
+	for i := range rows {
+		rs.Rows[i] = rows[i].row
+	}
+	return nil
+}
@@ -38,6 +38,11 @@ func TestOrderByExecutor(t *testing.T) {
 			},
 		},
 		Rows: [][]sqltypes.Value{
+			// The rows of the backend are sorted by the order by.
+			{
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("5")),
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("g")),
+			},
 			{
 				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("3")),
 				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("z")),
@@ -46,10 +51,6 @@ func TestOrderByExecutor(t *testing.T) {
 				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("1")),
 				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("x")),
 			},
-			{
-				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("5")),
-				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("g")),
-			},
 		},
 	}
 	r2 := &sqltypes.Result{
@@ -64,14 +65,14 @@ func TestOrderByExecutor(t *testing.T) {
 			},
 		},
 		Rows: [][]sqltypes.Value{
-			{
-				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("3")),
-				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("go")),
-			},
 			{
 				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("51")),
 				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("lang")),
 			},
+			{
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("3")),
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("go")),
+			},
 		},
 	}
 	r3 := &sqltypes.Result{}
@@ -93,12 +94,19 @@ func TestOrderByExecutor(t *testing.T) {
 	fakedbs.AddQuery("select id, name from sbtest.A2 as A where id > 8 order by id desc, name asc", r2)
 	fakedbs.AddQuery("select id, name from sbtest.A4 as A where id > 8 order by id desc, name asc", r3)
 	fakedbs.AddQuery("select id, name from sbtest.A8 as A where id > 8 order by id desc, name asc", r3)
+	// expression
+	fakedbs.AddQuery("select id, name from sbtest.A0 as A where id > 8 order by id % 5 desc, name asc", r1)
+	fakedbs.AddQuery("select id, name from sbtest.A2 as A where id > 8 order by id % 5 desc, name asc", r2)
+	fakedbs.AddQuery("select id, name from sbtest.A4 as A where id > 8 order by id % 5 desc, name asc", r3)
+	fakedbs.AddQuery("select id, name from sbtest.A8 as A where id > 8 order by id % 5 desc, name asc", r3)
 
 	querys := []string{
 		"select id, name from A where id>8 order by id desc, name asc",
+		"select id, name from A where id>8 order by id%5 desc, name",
 	}
 	results := []string{
 		"[[51 lang] [5 g] [3 go] [3 z] [1 x]]",
+		"[[3 go] [3 z] [51 lang] [1 x] [5 g]]",
 	}
 
 	for i, query := range querys {
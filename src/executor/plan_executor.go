@@ -13,9 +13,16 @@ import (
 	"planner"
 	"xcontext"
 
+	"github.com/pkg/errors"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
 	"github.com/xelabs/go-mysqlstack/xlog"
 )
 
+const (
+	// writeRowsBatch is the max rows written in one batch by writeRows.
+	writeRowsBatch = 1000
+)
+
 // PlanExecutor interface.
 type PlanExecutor interface {
 	execute(reqCtx *xcontext.RequestContext, ctx *xcontext.ResultContext) error
@@ -32,36 +39,194 @@ func buildExecutor(log *xlog.Log, plan planner.PlanNode, txn backend.Transaction
 		joinExec.left = buildExecutor(log, node.Left, txn)
 		joinExec.right = buildExecutor(log, node.Right, txn)
 		exec = joinExec
+	case *planner.DerivedNode:
+		exec = NewDerivedExecutor(log, node, txn)
+	case *planner.UnionNode:
+		unionExec := NewUnionExecutor(log, node, txn)
+		unionExec.left = buildExecutor(log, node.Left, txn)
+		unionExec.right = buildExecutor(log, node.Right, txn)
+		exec = unionExec
 	}
 	return exec
 }
 
+// writeRows executes the select in the txn, then writes the selected rows in batches,
+// the querys of every batch are built by the rowQuerys of the DML plan.
+func writeRows(log *xlog.Log, txn backend.Transaction, sel *planner.SelectPlan, mode xcontext.RequestMode, rawQuery string,
+	rowQuerys func(rows [][]sqltypes.Value) ([]xcontext.QueryTuple, error)) (*sqltypes.Result, error) {
+	reqCtx := xcontext.NewRequestContext()
+	reqCtx.Mode = sel.ReqMode
+	reqCtx.TxnMode = xcontext.TxnRead
+	reqCtx.RawQuery = sel.RawQuery
+
+	rsCtx := xcontext.NewResultContext()
+	if err := buildExecutor(log, sel.Root, txn).execute(reqCtx, rsCtx); err != nil {
+		return nil, err
+	}
+
+	qr := &sqltypes.Result{}
+	rows := rsCtx.Results.Rows
+	for begin := 0; begin < len(rows); begin += writeRowsBatch {
+		end := begin + writeRowsBatch
+		if end > len(rows) {
+			end = len(rows)
+		}
+		querys, err := rowQuerys(rows[begin:end])
+		if err != nil {
+			return nil, err
+		}
+		if len(querys) == 0 {
+			continue
+		}
+
+		reqCtx := xcontext.NewRequestContext()
+		reqCtx.Mode = mode
+		reqCtx.TxnMode = xcontext.TxnWrite
+		reqCtx.Querys = querys
+		reqCtx.RawQuery = rawQuery
+
+		rs, err := txn.Execute(reqCtx)
+		if err != nil {
+			return nil, err
+		}
+		qr.RowsAffected += rs.RowsAffected
+	}
+	return qr, nil
+}
+
+// moveRows executes the reads of the shard key update in the txn, then executes the querys
+// which move the rows. The XA is started on all the backends before the reads, the rows read
+// by 'for update' are locked until they're deleted and inserted in the same XA transaction.
+func moveRows(log *xlog.Log, txn backend.Transaction, move planner.ShardKeyMove, mode xcontext.RequestMode, rawQuery string) (*sqltypes.Result, error) {
+	if !txn.IsTwoPC() {
+		return nil, errors.New("unsupported: cannot.update.shard.key.without.twopc")
+	}
+	// The XA of the multiple-statement txn is already started on all the backends.
+	if !txn.IsScatterXA() {
+		if err := txn.BeginScatter(); err != nil {
+			return nil, err
+		}
+	}
+
+	var results []*sqltypes.Result
+	for {
+		reads, err := move.MoveReads(results)
+		if err != nil {
+			return nil, err
+		}
+		if len(reads) == 0 {
+			break
+		}
+
+		reqCtx := xcontext.NewRequestContext()
+		reqCtx.Mode = mode
+		reqCtx.TxnMode = xcontext.TxnWrite
+		reqCtx.Querys = reads
+		reqCtx.RawQuery = rawQuery
+
+		rs, err := txn.Execute(reqCtx)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, rs)
+	}
+
+	querys, affected, err := move.MoveQuerys(results)
+	if err != nil {
+		return nil, err
+	}
+	if len(querys) > 0 {
+		reqCtx := xcontext.NewRequestContext()
+		reqCtx.Mode = mode
+		reqCtx.TxnMode = xcontext.TxnWrite
+		reqCtx.Querys = querys
+		reqCtx.RawQuery = rawQuery
+
+		if _, err := txn.Execute(reqCtx); err != nil {
+			return nil, err
+		}
+	}
+	return &sqltypes.Result{RowsAffected: affected}, nil
+}
+
+// checkMaxResult returns error if the rows held in the proxy are larger than the
+// max result size of the txn, the backends only check the result of each query.
+func checkMaxResult(txn backend.Transaction, res *sqltypes.Result) error {
+	if res == nil {
+		return nil
+	}
+	return newResultSize(txn).add(res.Rows...)
+}
+
+// resultSize used to check the size of the rows held in the proxy as they're added.
+type resultSize struct {
+	max  int
+	size int
+}
+
+// newResultSize creates the resultSize under the max result size of the txn.
+func newResultSize(txn backend.Transaction) *resultSize {
+	return &resultSize{max: txn.MaxResult()}
+}
+
+// add used to add the rows, returns error if the size is larger than the max result size.
+func (r *resultSize) add(rows ...[]sqltypes.Value) error {
+	if r.max <= 0 {
+		return nil
+	}
+	for _, row := range rows {
+		for _, v := range row {
+			r.size += len(v.Raw())
+		}
+		if r.size > r.max {
+			return errors.Errorf("Query execution was interrupted, max memory usage[%d bytes] exceeded", r.max)
+		}
+	}
+	return nil
+}
+
 // execSubPlan used to execute all the children plan.
 func execSubPlan(log *xlog.Log, node planner.PlanNode, ctx *xcontext.ResultContext) error {
-	if len(ctx.Results.Rows) == 0 {
+	subPlanTree := node.Children()
+	if subPlanTree == nil {
 		return nil
 	}
-	subPlanTree := node.Children()
-	if subPlanTree != nil {
-		for _, subPlan := range subPlanTree.Plans() {
-			switch subPlan.Type() {
-			case planner.PlanTypeAggregate:
-				aggrExecutor := NewAggregateExecutor(log, subPlan)
-				if err := aggrExecutor.Execute(ctx); err != nil {
-					return err
-				}
-			case planner.PlanTypeOrderby:
-				orderByExecutor := NewOrderByExecutor(log, subPlan)
-				if err := orderByExecutor.Execute(ctx); err != nil {
-					return err
-				}
-			case planner.PlanTypeLimit:
-				limitExecutor := NewLimitExecutor(log, subPlan)
-				if err := limitExecutor.Execute(ctx); err != nil {
-					return err
-				}
+	return execPlans(log, subPlanTree.Plans(), ctx)
+}
+
+// execPlans used to execute the plans on the result.
+func execPlans(log *xlog.Log, plans []planner.Plan, ctx *xcontext.ResultContext) error {
+	if len(ctx.Results.Rows) == 0 && !isScalarAggregate(plans) {
+		return nil
+	}
+	for _, subPlan := range plans {
+		switch subPlan.Type() {
+		case planner.PlanTypeAggregate:
+			aggrExecutor := NewAggregateExecutor(log, subPlan)
+			if err := aggrExecutor.Execute(ctx); err != nil {
+				return err
+			}
+		case planner.PlanTypeOrderby:
+			orderByExecutor := NewOrderByExecutor(log, subPlan)
+			if err := orderByExecutor.Execute(ctx); err != nil {
+				return err
+			}
+		case planner.PlanTypeLimit:
+			limitExecutor := NewLimitExecutor(log, subPlan)
+			if err := limitExecutor.Execute(ctx); err != nil {
+				return err
 			}
 		}
 	}
 	return nil
 }
+
+// isScalarAggregate returns true if the first plan is the aggregation without group by,
+// which returns one row on the empty input.
+func isScalarAggregate(plans []planner.Plan) bool {
+	if len(plans) == 0 {
+		return false
+	}
+	plan, ok := plans[0].(*planner.AggregatePlan)
+	return ok && !plan.Empty() && len(plan.GroupAggregators()) == 0
+}
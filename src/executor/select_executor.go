@@ -45,6 +45,9 @@ func (executor *SelectExecutor) Execute(ctx *xcontext.ResultContext) error {
 	reqCtx.TxnMode = xcontext.TxnRead
 	reqCtx.RawQuery = plan.RawQuery
 
+	if err := Materialize(log, plan, executor.txn); err != nil {
+		return err
+	}
 	planExec := buildExecutor(log, plan.Root, executor.txn)
 	if err := planExec.execute(reqCtx, ctx); err != nil {
 		return err
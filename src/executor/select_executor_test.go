@@ -10,6 +10,7 @@ package executor
 
 import (
 	"fmt"
+	"sort"
 	"testing"
 
 	"backend"
@@ -38,6 +39,11 @@ func TestMergeExecutor(t *testing.T) {
 			},
 		},
 		Rows: [][]sqltypes.Value{
+			// The rows of the backend are sorted by the order by.
+			{
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("5")),
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("g")),
+			},
 			{
 				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("3")),
 				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("z")),
@@ -46,10 +52,6 @@ func TestMergeExecutor(t *testing.T) {
 				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("1")),
 				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("x")),
 			},
-			{
-				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("5")),
-				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("g")),
-			},
 		},
 	}
 	r2 := &sqltypes.Result{
@@ -64,14 +66,14 @@ func TestMergeExecutor(t *testing.T) {
 			},
 		},
 		Rows: [][]sqltypes.Value{
-			{
-				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("3")),
-				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("go")),
-			},
 			{
 				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("51")),
 				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("lang")),
 			},
+			{
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("3")),
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("go")),
+			},
 		},
 	}
 	r3 := &sqltypes.Result{}
@@ -93,12 +95,18 @@ func TestMergeExecutor(t *testing.T) {
 	fakedbs.AddQuery("select id, name from sbtest.A2 as A where id > 8 order by id desc, name asc", r2)
 	fakedbs.AddQuery("select id, name from sbtest.A4 as A where id > 8 order by id desc, name asc", r3)
 	fakedbs.AddQuery("select id, name from sbtest.A8 as A where id > 8 order by id desc, name asc", r3)
+	// limit
+	fakedbs.AddQueryPattern("select id, name from sbtest.A0 as A where id > 8 order by id desc, name asc limit 3", r1)
+	fakedbs.AddQueryPattern("select id, name from sbtest.A2 as A where id > 8 order by id desc, name asc limit 3", r2)
+	fakedbs.AddQueryPattern("select id, name from sbtest.A[48] as A where id > 8 order by id desc, name asc limit 3", r3)
 
 	querys := []string{
 		"select id, name from A where id>8 order by id desc, name asc",
+		"select id, name from A where id>8 order by id desc, name asc limit 1, 2",
 	}
 	results := []string{
 		"[[51 lang] [5 g] [3 go] [3 z] [1 x]]",
+		"[[5 g] [3 go]]",
 	}
 
 	for i, query := range querys {
@@ -124,6 +132,25 @@ func TestMergeExecutor(t *testing.T) {
 			log.Debug("%+v", ctx.Results)
 		}
 	}
+
+	// The merged rows are limited by the max result size.
+	{
+		query := querys[0]
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := planner.NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		txn, err := scatter.CreateTransaction()
+		assert.Nil(t, err)
+		defer txn.Finish()
+		txn.SetMaxResult(8)
+		executor := NewSelectExecutor(log, plan, txn)
+		ctx := xcontext.NewResultContext()
+		err = executor.Execute(ctx)
+		assert.Equal(t, "Query execution was interrupted, max memory usage[8 bytes] exceeded", err.Error())
+	}
 }
 
 func TestJoinExecutor(t *testing.T) {
@@ -360,6 +387,8 @@ func TestJoinExecutor(t *testing.T) {
 	fakedbs.AddQuery("select B.name, B.id from sbtest.B1 as B where B.name = 's' and B.id > 2 order by B.id asc", r21)
 	fakedbs.AddQuery("select B.name, B.id from sbtest.B0 as B where B.id > 2 order by B.id asc", r21)
 	fakedbs.AddQuery("select B.name, B.id from sbtest.B1 as B where B.id > 2 order by B.id asc", r2)
+	fakedbs.AddQuery("select B.name, B.id from sbtest.B0 as B where B.name in ('go', 'lang', 'niu')", r21)
+	fakedbs.AddQuery("select B.name, B.id from sbtest.B1 as B where B.name in ('go', 'lang', 'niu')", r2)
 	querys := []string{
 		"select A.id, B.name from A right join B on A.id=B.id where A.id > 2 group by A.id",
 		"select A.id, B.name from A join B on A.id=B.id where A.id > 2 group by A.id limit 1",
@@ -376,6 +405,7 @@ func TestJoinExecutor(t *testing.T) {
 		"select A.id, B.name, B.id, A.name from A join B on A.id<=B.id and A.name<=>B.name and A.id=2 and B.id=0 group by A.id",
 		"select A.id, B.name, B.id, A.name from A join B on A.id < B.id and A.name<=>B.name and A.id=2 and B.id=0 group by A.id",
 		"select A.id, B.name, B.id, A.name, A.id > 2 as tmpc_0 from A join B on A.name=B.name and A.id>=B.id and A.id > B.id group by A.id",
+		"select A.id, B.name, A.id + B.id as s from A join B on A.name=B.name where A.id = 2 and A.id * B.id > 10 group by A.id",
 	}
 	results := []string{
 		"[[3 go] [5 lang]]",
@@ -393,6 +423,7 @@ func TestJoinExecutor(t *testing.T) {
 		"[[4 lang 5 lang]]",
 		"[[4 lang 5 lang]]",
 		"[[6 lang 5 lang 1]]",
+		"[[4 lang 9]]",
 	}
 
 	for i, query := range querys {
@@ -419,3 +450,149 @@ func TestJoinExecutor(t *testing.T) {
 		}
 	}
 }
+
+func TestHashJoin(t *testing.T) {
+	intVal := func(v string) sqltypes.Value {
+		return sqltypes.MakeTrusted(querypb.Type_INT32, []byte(v))
+	}
+	decVal := func(v string) sqltypes.Value {
+		return sqltypes.MakeTrusted(querypb.Type_DECIMAL, []byte(v))
+	}
+	left := [][]sqltypes.Value{
+		{intVal("1")}, {intVal("2")}, {sqltypes.NULL}, {intVal("2")}, {intVal("3")},
+	}
+	right := [][]sqltypes.Value{
+		{decVal("2.0")}, {decVal("1.0")}, {sqltypes.NULL}, {decVal("4.0")}, {decVal("2.0")},
+	}
+	lfields := []*querypb.Field{{Name: "id", Type: querypb.Type_INT32, Table: "A"}}
+	rfields := []*querypb.Field{{Name: "id", Type: querypb.Type_DECIMAL, Table: "B"}}
+	rowsOf := func(res *sqltypes.Result) []string {
+		var rows []string
+		for _, row := range res.Rows {
+			rows = append(rows, fmt.Sprintf("%v", row))
+		}
+		sort.Strings(rows)
+		return rows
+	}
+
+	for _, isLeftJoin := range []bool{false, true} {
+		node := &planner.JoinNode{
+			LeftKeys:   []planner.JoinKey{{Field: "id", Table: "A", Index: 0}},
+			RightKeys:  []planner.JoinKey{{Field: "id", Table: "B", Index: 0}},
+			Cols:       []int{-1, 1},
+			IsLeftJoin: isLeftJoin,
+		}
+		// The hash table is built on the right, then on the left.
+		for _, rrows := range [][][]sqltypes.Value{right[:3], right} {
+			for _, lrows := range [][][]sqltypes.Value{left, left[:2]} {
+				want := &sqltypes.Result{}
+				sortMergeJoin(&sqltypes.Result{Fields: lfields, Rows: append([][]sqltypes.Value{}, lrows...)},
+					&sqltypes.Result{Fields: rfields, Rows: append([][]sqltypes.Value{}, rrows...)}, want, node)
+				got := &sqltypes.Result{}
+				hashJoin(&sqltypes.Result{Fields: lfields, Rows: lrows}, &sqltypes.Result{Fields: rfields, Rows: rrows}, got, node)
+				assert.Equal(t, rowsOf(want), rowsOf(got))
+				assert.Equal(t, want.RowsAffected, got.RowsAffected)
+			}
+		}
+	}
+
+	// The left rows keep their order.
+	{
+		node := &planner.JoinNode{
+			LeftKeys:  []planner.JoinKey{{Field: "id", Table: "A", Index: 0}},
+			RightKeys: []planner.JoinKey{{Field: "id", Table: "B", Index: 0}},
+			Cols:      []int{-1, 1},
+		}
+		got := &sqltypes.Result{}
+		hashJoin(&sqltypes.Result{Rows: left}, &sqltypes.Result{Rows: right[:2]}, got, node)
+		want := []string{"[1 1.0]", "[2 2.0]", "[2 2.0]"}
+		assert.Equal(t, len(want), len(got.Rows))
+		for i, row := range got.Rows {
+			assert.Equal(t, want[i], fmt.Sprintf("%v", row))
+		}
+	}
+}
+
+func TestLookupJoinExecutor(t *testing.T) {
+	fields := func(table string, names ...string) []*querypb.Field {
+		var fields []*querypb.Field
+		for _, name := range names {
+			fields = append(fields, &querypb.Field{Name: name, Type: querypb.Type_INT32, Table: table})
+		}
+		return fields
+	}
+	intVal := func(v string) sqltypes.Value {
+		return sqltypes.MakeTrusted(querypb.Type_INT32, []byte(v))
+	}
+	null := sqltypes.MakeTrusted(querypb.Type_NULL_TYPE, nil)
+	r1 := &sqltypes.Result{
+		Fields: fields("A", "id", "a"),
+		Rows: [][]sqltypes.Value{
+			{intVal("1"), intVal("3")},
+			{intVal("1"), intVal("5")},
+			{intVal("1"), intVal("3")},
+			{intVal("1"), null},
+		},
+	}
+	r2 := &sqltypes.Result{
+		Fields: fields("B", "b", "id"),
+		Rows: [][]sqltypes.Value{
+			{intVal("30"), intVal("3")},
+			{intVal("31"), intVal("3")},
+		},
+	}
+	r3 := &sqltypes.Result{
+		Fields: fields("A", "id", "a"),
+		Rows: [][]sqltypes.Value{
+			{intVal("2"), null},
+		},
+	}
+	r4 := &sqltypes.Result{
+		Fields: fields("B", "b", "id"),
+	}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableMConfig(), router.MockTableBConfig())
+	assert.Nil(t, err)
+
+	scatter, fakedbs, cleanup := backend.MockScatter(log, 10)
+	defer cleanup()
+	fakedbs.AddQueryPattern("select A.id, A.a from sbtest.A[0-9] as A where A.id = 1 .*", r1)
+	fakedbs.AddQueryPattern("select A.id, A.a from sbtest.A[0-9] as A where A.id = 2 .*", r3)
+	fakedbs.AddQuery("select B.b, B.id from sbtest.B1 as B where B.id in (3, 5)", r2)
+	fakedbs.AddQuery("select B.b, B.id from sbtest.B0 as B where B.id in (null)", r4)
+
+	querys := []string{
+		"select A.id, A.a, B.b from A join B on A.a = B.id where A.id = 1",
+		"select A.id, A.a, B.b from A left join B on A.a = B.id where A.id = 1",
+		"select A.id, A.a, B.b from A left join B on A.a = B.id where A.id = 2",
+	}
+	results := []string{
+		"[[1 3 30] [1 3 31] [1 3 30] [1 3 31]]",
+		"[[1 3 30] [1 3 31] [1 5 ] [1 3 30] [1 3 31] [1  ]]",
+		"[[2  ]]",
+	}
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+
+		plan := planner.NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+		assert.Equal(t, planner.NestedLoop, plan.Root.(*planner.JoinNode).Strategy)
+
+		txn, err := scatter.CreateTransaction()
+		assert.Nil(t, err)
+		defer txn.Finish()
+		executor := NewSelectExecutor(log, plan, txn)
+		ctx := xcontext.NewResultContext()
+		err = executor.Execute(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, results[i], fmt.Sprintf("%v", ctx.Results.Rows), query)
+	}
+}
@@ -0,0 +1,305 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package executor
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"backend"
+	"xcontext"
+
+	"github.com/xelabs/go-mysqlstack/driver"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+	"github.com/xelabs/go-mysqlstack/xlog"
+
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
+)
+
+// spillRows is the rows container under the memory budget. If the rows held exceed the
+// budget, they're sorted by the keys and written as a sorted run to a temp file in the
+// dir, the rows are read back in order by the k-way merge of the runs.
+type spillRows struct {
+	dir    string
+	budget int
+	keys   []streamKey
+	size   int
+	rows   [][]sqltypes.Value
+	runs   []*os.File
+}
+
+// newSpillRows creates the spillRows.
+func newSpillRows(dir string, budget int, keys []streamKey) *spillRows {
+	return &spillRows{
+		dir:    dir,
+		budget: budget,
+		keys:   keys,
+	}
+}
+
+// add used to add the row, the rows are spilled if the budget is exceeded.
+func (s *spillRows) add(row []sqltypes.Value) error {
+	s.rows = append(s.rows, row)
+	s.size += rowSize(row)
+	if s.size >= s.budget {
+		return s.spill()
+	}
+	return nil
+}
+
+// sortRows used to sort the rows in memory, the rows with the same keys keep the order they're added.
+func (s *spillRows) sortRows() {
+	sort.SliceStable(s.rows, func(i, j int) bool {
+		return compareRows(s.rows[i], s.rows[j], s.keys) < 0
+	})
+}
+
+// spill used to write the sorted rows as a run. The temp file is removed once it's
+// created, so it's deleted by the system when it's closed or the process exits.
+func (s *spillRows) spill() error {
+	if err := os.MkdirAll(s.dir, 0744); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(s.dir, "radon-spill-")
+	if err != nil {
+		return err
+	}
+	os.Remove(file.Name())
+	s.runs = append(s.runs, file)
+
+	s.sortRows()
+	w := bufio.NewWriter(file)
+	for _, row := range s.rows {
+		if err := writeRow(w, row); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	s.rows = nil
+	s.size = 0
+	return nil
+}
+
+// merger returns the rowMerger of the rows in memory and the runs.
+func (s *spillRows) merger() (*rowMerger, error) {
+	s.sortRows()
+	rows := s.rows
+	sources := []*rowSource{
+		{
+			index: len(s.runs),
+			next: func() ([]sqltypes.Value, error) {
+				if len(rows) == 0 {
+					return nil, nil
+				}
+				row := rows[0]
+				rows = rows[1:]
+				return row, nil
+			},
+		},
+	}
+	for i, file := range s.runs {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		r := bufio.NewReader(file)
+		sources = append(sources, &rowSource{
+			index: i,
+			next: func() ([]sqltypes.Value, error) {
+				return readRow(r)
+			},
+		})
+	}
+	return newRowMerger(sources, s.keys)
+}
+
+// close used to close the runs.
+func (s *spillRows) close() {
+	for _, file := range s.runs {
+		file.Close()
+	}
+	s.runs = nil
+	s.rows = nil
+}
+
+// rowSize returns the bytes of the values in the row.
+func rowSize(row []sqltypes.Value) int {
+	size := 0
+	for _, v := range row {
+		size += len(v.Raw())
+	}
+	return size
+}
+
+// writeRow used to write the row as: count of the values, then type, length and bytes of each value.
+func writeRow(w *bufio.Writer, row []sqltypes.Value) error {
+	var buf [binary.MaxVarintLen64]byte
+	write := func(x uint64) error {
+		n := binary.PutUvarint(buf[:], x)
+		_, err := w.Write(buf[:n])
+		return err
+	}
+	if err := write(uint64(len(row))); err != nil {
+		return err
+	}
+	for _, v := range row {
+		if err := write(uint64(v.Type())); err != nil {
+			return err
+		}
+		if err := write(uint64(len(v.Raw()))); err != nil {
+			return err
+		}
+		if _, err := w.Write(v.Raw()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readRow used to read the row written by writeRow, returns nil at the end.
+func readRow(r *bufio.Reader) ([]sqltypes.Value, error) {
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	row := make([]sqltypes.Value, count)
+	for i := range row {
+		typ, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		var raw []byte
+		if size > 0 {
+			raw = make([]byte, size)
+			if _, err := io.ReadFull(r, raw); err != nil {
+				return nil, err
+			}
+		}
+		row[i] = sqltypes.MakeTrusted(querypb.Type(typ), raw)
+	}
+	return row, nil
+}
+
+// spillFetch used to read the rows of the cursors into the spillRows. The rows aren't limited
+// by the max result of the txn, they're spilled to disk beyond the budget.
+func spillFetch(log *xlog.Log, cursors []driver.Rows, rows *spillRows) error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var allErrors []error
+
+	ch := make(chan []sqltypes.Value, streamPrefetch)
+	stop := make(chan struct{})
+	oneFetch := func(cursor driver.Rows) {
+		defer wg.Done()
+		for cursor.Next() {
+			row, err := cursor.RowValues()
+			if err != nil {
+				log.Error("spill.fetch.cursor.RowValues.error:%+v", err)
+				mu.Lock()
+				allErrors = append(allErrors, err)
+				mu.Unlock()
+				return
+			}
+			select {
+			case <-stop:
+				return
+			case ch <- row:
+			}
+		}
+		if err := cursor.LastError(); err != nil {
+			log.Error("spill.fetch.cursor.error:%+v", err)
+			mu.Lock()
+			allErrors = append(allErrors, err)
+			mu.Unlock()
+		}
+	}
+	for _, cursor := range cursors {
+		wg.Add(1)
+		go oneFetch(cursor)
+	}
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+
+	for row := range ch {
+		if err := rows.add(row); err != nil {
+			close(stop)
+			for range ch {
+			}
+			return err
+		}
+	}
+	if len(allErrors) > 0 {
+		return allErrors[0]
+	}
+	return nil
+}
+
+// spillStream used to read the rows of the querys by the stream cursors into the spillRows
+// created by the init with the fields. A connection runs one stream at a time, the twopc
+// txn holds one connection per backend, so its querys are read in rounds of one query
+// per backend.
+func spillStream(log *xlog.Log, txn backend.Transaction, reqCtx *xcontext.RequestContext, querys []xcontext.QueryTuple, init func([]*querypb.Field) (*spillRows, error)) ([]*querypb.Field, *spillRows, error) {
+	var fields []*querypb.Field
+	var rows *spillRows
+	for _, round := range streamRounds(txn, querys) {
+		reqCtx.Querys = round
+		cursors, err := txn.ExecuteStreamCursors(reqCtx)
+		if err == nil && rows == nil {
+			fields = cursors[0].Fields()
+			rows, err = init(fields)
+		}
+		if err == nil {
+			err = spillFetch(log, cursors, rows)
+		}
+		for _, cursor := range cursors {
+			cursor.Close()
+		}
+		if err != nil {
+			if rows != nil {
+				rows.close()
+			}
+			return nil, nil, err
+		}
+	}
+	return fields, rows, nil
+}
+
+// streamRounds used to split the querys into the rounds read one after another, each round
+// of the twopc txn has one query per backend at most.
+func streamRounds(txn backend.Transaction, querys []xcontext.QueryTuple) [][]xcontext.QueryTuple {
+	if !txn.IsTwoPC() {
+		return [][]xcontext.QueryTuple{querys}
+	}
+	var rounds [][]xcontext.QueryTuple
+	next := make(map[string]int)
+	for _, qt := range querys {
+		i := next[qt.Backend]
+		if i == len(rounds) {
+			rounds = append(rounds, nil)
+		}
+		rounds[i] = append(rounds[i], qt)
+		next[qt.Backend] = i + 1
+	}
+	return rounds
+}
@@ -0,0 +1,341 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package executor
+
+import (
+	"planner"
+	"xcontext"
+
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+	"github.com/xelabs/go-mysqlstack/xlog"
+
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
+)
+
+// isSpillable returns true if the rows of the MergeNode can be spilled, the first
+// child of the node is the aggregation or the order by.
+func isSpillable(node *planner.MergeNode) bool {
+	plans := node.Children().Plans()
+	if len(plans) == 0 {
+		return false
+	}
+	switch plan := plans[0].(type) {
+	case *planner.AggregatePlan:
+		return true
+	case *planner.OrderByPlan:
+		// The order by expressions are sorted in memory.
+		return !plan.HasExpr()
+	}
+	return false
+}
+
+// spillExecute used to execute the MergeNode whose rows may be larger than the memory.
+// The rows are read by the stream cursors, sorted by the group by or order by keys, and
+// spilled to disk beyond the budget. Then the sorted rows are aggregated in chunks, or
+// read in order until the limit is satisfied, the remaining plans run in memory.
+func (m *MergeExecutor) spillExecute(reqCtx *xcontext.RequestContext, ctx *xcontext.ResultContext, dir string, budget int) error {
+	var keys []streamKey
+	plans := m.node.Children().Plans()
+	init := func(fields []*querypb.Field) (*spillRows, error) {
+		switch plan := plans[0].(type) {
+		case *planner.AggregatePlan:
+			for _, aggr := range plan.GroupAggregators() {
+				keys = append(keys, streamKey{index: aggr.Index})
+			}
+		case *planner.OrderByPlan:
+			var err error
+			if keys, err = orderByKeys(fields, plan); err != nil {
+				return nil, err
+			}
+		}
+		return newSpillRows(dir, budget, keys), nil
+	}
+	fields, rows, err := spillStream(m.log, m.txn, reqCtx, m.node.Querys, init)
+	if err != nil {
+		return err
+	}
+	defer rows.close()
+	merger, err := rows.merger()
+	if err != nil {
+		return err
+	}
+
+	// The rows read by the stream cursors aren't limited, but the output held in memory is.
+	size := newResultSize(m.txn)
+	ctx.Results = &sqltypes.Result{Fields: fields}
+	switch plan := plans[0].(type) {
+	case *planner.AggregatePlan:
+		if err := spillAggregate(m.log, plan, merger, keys, budget, size, ctx.Results); err != nil {
+			return err
+		}
+		plans = plans[1:]
+	case *planner.OrderByPlan:
+		offset, count := 0, -1
+		plans = plans[1:]
+		if len(plans) > 0 {
+			if limit, ok := plans[0].(*planner.LimitPlan); ok {
+				offset, count = limit.Offset, limit.Limit
+				plans = plans[1:]
+			}
+		}
+		for count != 0 {
+			row, err := merger.next()
+			if err != nil {
+				return err
+			}
+			if row == nil {
+				break
+			}
+			if offset > 0 {
+				offset--
+				continue
+			}
+			if count > 0 {
+				count--
+			}
+			if err := size.add(row); err != nil {
+				return err
+			}
+			ctx.Results.Rows = append(ctx.Results.Rows, row)
+		}
+	}
+	ctx.Results.RowsAffected = uint64(len(ctx.Results.Rows))
+	return execPlans(m.log, plans, ctx)
+}
+
+// spillAggregate used to aggregate the rows sorted by the group by keys. The rows are
+// aggregated in chunks of the budget size, a chunk is only cut between the groups.
+func spillAggregate(log *xlog.Log, plan *planner.AggregatePlan, merger *rowMerger, keys []streamKey, budget int, output *resultSize, res *sqltypes.Result) error {
+	var chunk [][]sqltypes.Value
+	size := 0
+	fields := res.Fields
+	executor := NewAggregateExecutor(log, plan)
+	flush := func() error {
+		qr := &sqltypes.Result{Fields: fields, Rows: chunk}
+		if err := executor.aggregate(qr); err != nil {
+			return err
+		}
+		if err := output.add(qr.Rows...); err != nil {
+			return err
+		}
+		res.Fields = qr.Fields
+		res.Rows = append(res.Rows, qr.Rows...)
+		chunk = nil
+		size = 0
+		return nil
+	}
+
+	for {
+		row, err := merger.next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		if size >= budget && compareRows(chunk[len(chunk)-1], row, keys) != 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		chunk = append(chunk, row)
+		size += rowSize(row)
+	}
+	// The aggregation without group by returns one row on the empty input.
+	if len(chunk) > 0 || len(keys) == 0 {
+		return flush()
+	}
+	return nil
+}
+
+// spillJoin used to execute the sort merge join whose sides may be larger than the memory.
+// Each side is sorted by the join keys and spilled to disk beyond the budget, then the
+// rows of the same keys are read from the sides in order and joined.
+func (j *JoinExecutor) spillJoin(reqCtx *xcontext.RequestContext, ctx *xcontext.ResultContext, dir string, budget int) error {
+	left, err := j.spillSide(reqCtx, j.left, j.node.LeftKeys, dir, budget)
+	if err != nil {
+		return err
+	}
+	defer left.rows.close()
+	right, err := j.spillSide(reqCtx, j.right, j.node.RightKeys, dir, budget)
+	if err != nil {
+		return err
+	}
+	defer right.rows.close()
+
+	// The sides aren't limited, but the joined rows held in memory are.
+	size := newResultSize(j.txn)
+	ctx.Results = &sqltypes.Result{}
+	ctx.Results.Fields = joinFields(left.fields, right.fields, j.node)
+	lgroups, err := newRowGroups(left.rows, left.keys)
+	if err != nil {
+		return err
+	}
+	rgroups, err := newRowGroups(right.rows, right.keys)
+	if err != nil {
+		return err
+	}
+
+	lrows, err := lgroups.next()
+	if err != nil {
+		return err
+	}
+	rrows, err := rgroups.next()
+	if err != nil {
+		return err
+	}
+	added := 0
+	for lrows != nil {
+		if err := size.add(ctx.Results.Rows[added:]...); err != nil {
+			return err
+		}
+		added = len(ctx.Results.Rows)
+		if rrows == nil {
+			if err := concatLeftAndNil(lrows, j.node, ctx.Results); err != nil {
+				return err
+			}
+			if lrows, err = lgroups.next(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		cmp := 0
+		isNull := false
+		for k, key := range j.node.LeftKeys {
+			cmp = sqltypes.NullsafeCompare(lrows[0][key.Index], rrows[0][j.node.RightKeys[k].Index])
+			if cmp != 0 {
+				break
+			}
+			if lrows[0][key.Index].IsNull() {
+				isNull = true
+				break
+			}
+		}
+
+		switch {
+		case cmp == 0:
+			if isNull {
+				err = concatLeftAndNil(lrows, j.node, ctx.Results)
+			} else {
+				err = concatLeftAndRight(lrows, rrows, j.node, ctx.Results)
+			}
+			if err != nil {
+				return err
+			}
+			if lrows, err = lgroups.next(); err != nil {
+				return err
+			}
+			if rrows, err = rgroups.next(); err != nil {
+				return err
+			}
+		case cmp > 0:
+			if rrows, err = rgroups.next(); err != nil {
+				return err
+			}
+		default:
+			if err := concatLeftAndNil(lrows, j.node, ctx.Results); err != nil {
+				return err
+			}
+			if lrows, err = lgroups.next(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := size.add(ctx.Results.Rows[added:]...); err != nil {
+		return err
+	}
+	joinExprFields(ctx.Results, j.node)
+	return execSubPlan(j.log, j.node, ctx)
+}
+
+// spillInput is the rows of the join side in the spillRows.
+type spillInput struct {
+	fields []*querypb.Field
+	keys   []streamKey
+	rows   *spillRows
+}
+
+// spillSide used to read the rows of the join side into the spillRows. The MergeNode without
+// children is read by the stream cursors, the others are executed and their results are added.
+func (j *JoinExecutor) spillSide(reqCtx *xcontext.RequestContext, exec PlanExecutor, joinKeys []planner.JoinKey, dir string, budget int) (*spillInput, error) {
+	input := &spillInput{}
+	for _, key := range joinKeys {
+		input.keys = append(input.keys, streamKey{index: key.Index})
+	}
+
+	req := xcontext.NewRequestContext()
+	req.Mode = reqCtx.Mode
+	req.TxnMode = reqCtx.TxnMode
+	req.RawQuery = reqCtx.RawQuery
+	if m, ok := exec.(*MergeExecutor); ok && len(m.node.Children().Plans()) == 0 {
+		init := func([]*querypb.Field) (*spillRows, error) {
+			return newSpillRows(dir, budget, input.keys), nil
+		}
+		fields, rows, err := spillStream(j.log, j.txn, req, m.node.Querys, init)
+		if err != nil {
+			return nil, err
+		}
+		input.fields, input.rows = fields, rows
+		return input, nil
+	}
+
+	res := xcontext.NewResultContext()
+	if err := exec.execute(req, res); err != nil {
+		return nil, err
+	}
+	input.rows = newSpillRows(dir, budget, input.keys)
+	input.fields = res.Results.Fields
+	for _, row := range res.Results.Rows {
+		if err := input.rows.add(row); err != nil {
+			input.rows.close()
+			return nil, err
+		}
+	}
+	return input, nil
+}
+
+// rowGroups used to read the sorted rows by the groups of the same keys.
+type rowGroups struct {
+	merger *rowMerger
+	keys   []streamKey
+	row    []sqltypes.Value
+}
+
+// newRowGroups creates the rowGroups of the rows.
+func newRowGroups(rows *spillRows, keys []streamKey) (*rowGroups, error) {
+	merger, err := rows.merger()
+	if err != nil {
+		return nil, err
+	}
+	row, err := merger.next()
+	if err != nil {
+		return nil, err
+	}
+	return &rowGroups{merger: merger, keys: keys, row: row}, nil
+}
+
+// next returns the rows of the next keys, nil if there's no more rows.
+func (g *rowGroups) next() ([][]sqltypes.Value, error) {
+	if g.row == nil {
+		return nil, nil
+	}
+	rows := [][]sqltypes.Value{g.row}
+	for {
+		row, err := g.merger.next()
+		if err != nil {
+			return nil, err
+		}
+		if row == nil || compareRows(rows[0], row, g.keys) != 0 {
+			g.row = row
+			return rows, nil
+		}
+		rows = append(rows, row)
+	}
+}
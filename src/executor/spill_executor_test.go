@@ -0,0 +1,224 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package executor
+
+import (
+	"backend"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"planner"
+	"router"
+	"testing"
+	"xcontext"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/driver"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+	"github.com/xelabs/go-mysqlstack/xlog"
+
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
+)
+
+func TestSpillRows(t *testing.T) {
+	dir, err := ioutil.TempDir("", "radon-spill-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	rows := newSpillRows(dir, 8, []streamKey{{index: 0, desc: true}})
+	defer rows.close()
+	for _, val := range []string{"3", "1", "", "7", "5", "3", "2"} {
+		v := sqltypes.NULL
+		if val != "" {
+			v = sqltypes.MakeTrusted(querypb.Type_INT32, []byte(val))
+		}
+		err := rows.add([]sqltypes.Value{v, sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("row"+val))})
+		assert.Nil(t, err)
+	}
+	assert.True(t, len(rows.runs) > 1)
+
+	merger, err := rows.merger()
+	assert.Nil(t, err)
+	var got [][]sqltypes.Value
+	for {
+		row, err := merger.next()
+		assert.Nil(t, err)
+		if row == nil {
+			break
+		}
+		got = append(got, row)
+	}
+	assert.Equal(t, "[[7 row7] [5 row5] [3 row3] [3 row3] [2 row2] [1 row1] [ row]]", fmt.Sprintf("%v", got))
+	assert.True(t, got[6][0].IsNull())
+}
+
+func TestSpillExecutor(t *testing.T) {
+	result := func(fields []*querypb.Field, rows ...[]string) *sqltypes.Result {
+		r := &sqltypes.Result{Fields: fields}
+		for _, row := range rows {
+			var vals []sqltypes.Value
+			for i, val := range row {
+				vals = append(vals, sqltypes.MakeTrusted(fields[i].Type, []byte(val)))
+			}
+			r.Rows = append(r.Rows, vals)
+		}
+		return r
+	}
+	idName := []*querypb.Field{
+		{Name: "id", Type: querypb.Type_INT32, Table: "B"},
+		{Name: "name", Type: querypb.Type_VARCHAR, Table: "B"},
+	}
+	nameCnt := []*querypb.Field{
+		{Name: "name", Type: querypb.Type_VARCHAR, Table: "B"},
+		{Name: "cnt", Type: querypb.Type_INT64},
+	}
+	aID := []*querypb.Field{
+		{Name: "id", Type: querypb.Type_INT32, Table: "A"},
+	}
+	nameID := []*querypb.Field{
+		{Name: "name", Type: querypb.Type_VARCHAR, Table: "B"},
+		{Name: "id", Type: querypb.Type_INT32, Table: "B"},
+	}
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	dir, err := ioutil.TempDir("", "radon-spill-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+	err = route.AddForTest(database, router.MockTableMConfig(), router.MockTableBConfig())
+	assert.Nil(t, err)
+
+	scatter, fakedbs, cleanup := backend.MockScatter(log, 10)
+	defer cleanup()
+	fakedbs.AddQueryPattern("select id, name from sbtest.B0 as B order by id asc", result(idName, []string{"1", "n1"}, []string{"4", "n4"}, []string{"5", "n5"}, []string{"9", "n9"}, []string{"41", "n4"}))
+	fakedbs.AddQueryPattern("select id, name from sbtest.B1 as B order by id asc", result(idName, []string{"2", "n2"}, []string{"3", "n3"}, []string{"5", "n5"}, []string{"8", "n8"}, []string{"42", "n4"}))
+	fakedbs.AddQueryPattern("select id, name from sbtest.B0 as B order by id desc limit 5", result(idName, []string{"41", "n4"}, []string{"9", "n9"}, []string{"5", "n5"}, []string{"4", "n4"}, []string{"1", "n1"}))
+	fakedbs.AddQueryPattern("select id, name from sbtest.B1 as B order by id desc limit 5", result(idName, []string{"42", "n4"}, []string{"8", "n8"}, []string{"5", "n5"}, []string{"3", "n3"}, []string{"2", "n2"}))
+	fakedbs.AddQueryPattern("select name, count\\(id\\) as cnt from sbtest.B0 as B group by name order by name asc", result(nameCnt, []string{"n1", "2"}, []string{"n4", "3"}, []string{"n9", "1"}))
+	fakedbs.AddQueryPattern("select name, count\\(id\\) as cnt from sbtest.B1 as B group by name order by name asc", result(nameCnt, []string{"n2", "1"}, []string{"n4", "5"}, []string{"n8", "1"}))
+	fakedbs.AddQueryPattern("select A.id from sbtest.A1 as A order by A.id asc", result(aID, []string{"1"}, []string{"3"}))
+	fakedbs.AddQueryPattern("select A.id from sbtest.A2 as A order by A.id asc", result(aID, []string{"5"}, []string{"7"}))
+	fakedbs.AddQueryPattern("select A.id from sbtest.A[3-6] as A order by A.id asc", result(aID))
+	fakedbs.AddQueryPattern("select B.name, B.id from sbtest.B0 as B order by B.id asc", result(nameID, []string{"n1", "1"}, []string{"n4", "4"}, []string{"n5", "5"}))
+	fakedbs.AddQueryPattern("select B.name, B.id from sbtest.B1 as B order by B.id asc", result(nameID, []string{"n3", "3"}, []string{"n5", "5"}))
+
+	querys := []string{
+		"select id, name from B order by id asc",
+		"select id, name from B order by id desc limit 2, 3",
+		"select name, count(id) as cnt from B group by name",
+		"select A.id, B.name from A left join B on A.id = B.id",
+	}
+	results := []string{
+		"[[1 n1] [2 n2] [3 n3] [4 n4] [5 n5] [5 n5] [8 n8] [9 n9] [41 n4] [42 n4]]",
+		"[[9 n9] [8 n8] [5 n5]]",
+		"[[n1 2] [n2 1] [n4 8] [n8 1] [n9 1]]",
+		"[[1 n1] [3 n3] [5 n5] [5 n5] [7 ]]",
+	}
+	for _, twopc := range []bool{false, true} {
+		for i, query := range querys {
+			node, err := sqlparser.Parse(query)
+			assert.Nil(t, err)
+			plan := planner.NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+			err = plan.Build()
+			assert.Nil(t, err)
+
+			txn, err := scatter.CreateTransaction()
+			assert.Nil(t, err)
+			defer txn.Finish()
+			if twopc {
+				// The querys of the same backend are read in rounds.
+				err = txn.Begin()
+				assert.Nil(t, err)
+			}
+			txn.SetSpill(dir, 8)
+
+			executor := NewSelectExecutor(log, plan, txn)
+			ctx := xcontext.NewResultContext()
+			err = executor.Execute(ctx)
+			assert.Nil(t, err)
+			assert.Equal(t, results[i], fmt.Sprintf("%v", ctx.Results.Rows), query)
+		}
+	}
+
+	// The spilled output is limited by the max result size.
+	for _, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := planner.NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		txn, err := scatter.CreateTransaction()
+		assert.Nil(t, err)
+		defer txn.Finish()
+		txn.SetSpill(dir, 8)
+		txn.SetMaxResult(4)
+
+		executor := NewSelectExecutor(log, plan, txn)
+		ctx := xcontext.NewResultContext()
+		err = executor.Execute(ctx)
+		assert.Equal(t, "Query execution was interrupted, max memory usage[4 bytes] exceeded", err.Error(), query)
+	}
+}
+
+func TestSpillStreamRounds(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	scatter, _, cleanup := backend.MockScatter(log, 10)
+	defer cleanup()
+
+	querys := []xcontext.QueryTuple{
+		{Query: "q1", Backend: "backend1"},
+		{Query: "q2", Backend: "backend1"},
+		{Query: "q3", Backend: "backend2"},
+		{Query: "q4", Backend: "backend1"},
+	}
+	txn, err := scatter.CreateTransaction()
+	assert.Nil(t, err)
+	defer txn.Finish()
+	assert.Equal(t, [][]xcontext.QueryTuple{querys}, streamRounds(txn, querys))
+
+	// One query per backend in a round.
+	err = txn.Begin()
+	assert.Nil(t, err)
+	want := [][]xcontext.QueryTuple{
+		{querys[0], querys[2]},
+		{querys[1]},
+		{querys[3]},
+	}
+	assert.Equal(t, want, streamRounds(txn, querys))
+}
+
+func TestSpillFetchCursorError(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	dir, err := ioutil.TempDir("", "radon-spill-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	rows := newSpillRows(dir, 8, []streamKey{{index: 0}})
+	defer rows.close()
+	// The second cursor is broken in the middle of the stream.
+	cursors := []driver.Rows{
+		newMockRows(nil, "1", "3", "5"),
+		newMockRows(errors.New("mock.stream.broken"), "2", "4"),
+	}
+	err = spillFetch(log, cursors, rows)
+	assert.EqualError(t, err, "mock.stream.broken")
+
+	cursors = []driver.Rows{
+		newMockRows(nil, "1", "3", "5"),
+		newMockRows(nil, "2", "4"),
+	}
+	err = spillFetch(log, cursors, rows)
+	assert.Nil(t, err)
+}
@@ -0,0 +1,359 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package executor
+
+import (
+	"backend"
+	"container/heap"
+	"planner"
+	"sync"
+	"xcontext"
+
+	"github.com/pkg/errors"
+	"github.com/xelabs/go-mysqlstack/driver"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+	"github.com/xelabs/go-mysqlstack/xlog"
+
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
+)
+
+const (
+	// streamPrefetch is the count of the rows prefetched from one cursor.
+	streamPrefetch = 256
+)
+
+// StreamExecutor represents the executor which streams the rows of the MergeNode to the client.
+type StreamExecutor struct {
+	log  *xlog.Log
+	plan *planner.SelectPlan
+	txn  *backend.Txn
+}
+
+// NewStreamExecutor creates the new stream executor.
+func NewStreamExecutor(log *xlog.Log, plan *planner.SelectPlan, txn *backend.Txn) *StreamExecutor {
+	return &StreamExecutor{
+		log:  log,
+		plan: plan,
+		txn:  txn,
+	}
+}
+
+// Execute used to execute the executor. If the children of the MergeNode are only the
+// order by and limit, the ordered rows of the partitions are merged by the k-way merge
+// and sent as they arrive, the fetch stops once the limit is satisfied. Otherwise the
+// rows are streamed in the order they're read.
+func (executor *StreamExecutor) Execute(callback func(*sqltypes.Result) error, streamBufferSize int) error {
+	node, ok := executor.plan.Root.(*planner.MergeNode)
+	if !ok {
+		return errors.New("unsupported: stream.fetch.root.must.be.merge.node")
+	}
+	reqCtx := xcontext.NewRequestContext()
+	reqCtx.Mode = executor.plan.ReqMode
+	reqCtx.Querys = node.GetQuery()
+	reqCtx.RawQuery = executor.plan.RawQuery
+
+	orderBy, limit, ok := mergePlans(node)
+	if !ok {
+		return executor.txn.ExecuteStreamFetch(reqCtx, callback, streamBufferSize)
+	}
+
+	cursors, err := executor.txn.ExecuteStreamCursors(reqCtx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, cursor := range cursors {
+			cursor.Close()
+		}
+	}()
+	return streamMerge(executor.log, cursors, orderBy, limit, callback, streamBufferSize)
+}
+
+// mergePlans returns the order by and limit plans if they're the only children of the node.
+func mergePlans(node *planner.MergeNode) (*planner.OrderByPlan, *planner.LimitPlan, bool) {
+	var orderBy *planner.OrderByPlan
+	var limit *planner.LimitPlan
+	for _, plan := range node.Children().Plans() {
+		switch plan := plan.(type) {
+		case *planner.OrderByPlan:
+			orderBy = plan
+		case *planner.LimitPlan:
+			limit = plan
+		default:
+			return nil, nil, false
+		}
+	}
+	return orderBy, limit, orderBy != nil || limit != nil
+}
+
+// streamKey is the order by column in the fields.
+type streamKey struct {
+	index int
+	desc  bool
+}
+
+// rowSource is the sorted rows of a cursor or a run, row is the current row.
+type rowSource struct {
+	index int
+	row   []sqltypes.Value
+	next  func() ([]sqltypes.Value, error)
+}
+
+// rowHeap is the min-heap of the sources, ordered by the current row.
+type rowHeap struct {
+	sources []*rowSource
+	keys    []streamKey
+}
+
+// Len is part of heap.Interface.
+func (h *rowHeap) Len() int {
+	return len(h.sources)
+}
+
+// Less is part of heap.Interface. The rows with the same keys are ordered by
+// the source, so the merge is stable.
+func (h *rowHeap) Less(i, j int) bool {
+	a, b := h.sources[i], h.sources[j]
+	if cmp := compareRows(a.row, b.row, h.keys); cmp != 0 {
+		return cmp < 0
+	}
+	return a.index < b.index
+}
+
+// Swap is part of heap.Interface.
+func (h *rowHeap) Swap(i, j int) {
+	h.sources[i], h.sources[j] = h.sources[j], h.sources[i]
+}
+
+// Push is part of heap.Interface.
+func (h *rowHeap) Push(x interface{}) {
+	h.sources = append(h.sources, x.(*rowSource))
+}
+
+// Pop is part of heap.Interface.
+func (h *rowHeap) Pop() interface{} {
+	n := len(h.sources)
+	source := h.sources[n-1]
+	h.sources = h.sources[:n-1]
+	return source
+}
+
+// rowMerger used to merge the sorted sources by the k-way merge.
+type rowMerger struct {
+	h *rowHeap
+}
+
+// newRowMerger creates the rowMerger, the first row of every source is read.
+func newRowMerger(sources []*rowSource, keys []streamKey) (*rowMerger, error) {
+	h := &rowHeap{keys: keys}
+	for _, source := range sources {
+		row, err := source.next()
+		if err != nil {
+			return nil, err
+		}
+		if row != nil {
+			source.row = row
+			h.sources = append(h.sources, source)
+		}
+	}
+	heap.Init(h)
+	return &rowMerger{h: h}, nil
+}
+
+// next returns the smallest row of the sources, nil if all the sources are finished.
+func (m *rowMerger) next() ([]sqltypes.Value, error) {
+	if m.h.Len() == 0 {
+		return nil, nil
+	}
+	source := m.h.sources[0]
+	row := source.row
+	next, err := source.next()
+	if err != nil {
+		return nil, err
+	}
+	if next != nil {
+		source.row = next
+		heap.Fix(m.h, 0)
+	} else {
+		heap.Pop(m.h)
+	}
+	return row, nil
+}
+
+// compareRows compares the rows by the keys, returns 0 if a==b, -1 if a<b, and 1 if a>b.
+func compareRows(a, b []sqltypes.Value, keys []streamKey) int {
+	for _, key := range keys {
+		cmp := sqltypes.NullsafeCompare(a[key.index], b[key.index])
+		if key.desc {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
+
+// orderByKeys returns the keys of the order by columns in the fields.
+func orderByKeys(fields []*querypb.Field, orderBy *planner.OrderByPlan) ([]streamKey, error) {
+	var keys []streamKey
+	for _, by := range orderBy.OrderBys {
+		if by.Evaluation() != nil {
+			return nil, errors.Errorf("unsupported: orderby[%s].in.stream.fetch", by.Field)
+		}
+		index, err := orderByIndex(fields, by)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, streamKey{index: index, desc: by.Direction == planner.DESC})
+	}
+	return keys, nil
+}
+
+// orderByIndex returns the index of the order by column in the fields.
+func orderByIndex(fields []*querypb.Field, by planner.OrderBy) (int, error) {
+	for i, field := range fields {
+		if field.Name == by.Field && (by.Table == "" || by.Table == field.Table) {
+			return i, nil
+		}
+	}
+	return -1, errors.Errorf("can.not.find.the.orderby.field[%s]", by.Field)
+}
+
+// streamMerge used to merge the ordered rows of the cursors, and sends the rows to the
+// client in batches of streamBufferSize bytes.
+func streamMerge(log *xlog.Log, cursors []driver.Rows, orderBy *planner.OrderByPlan, limit *planner.LimitPlan,
+	callback func(*sqltypes.Result) error, streamBufferSize int) error {
+	fields := cursors[0].Fields()
+	if err := callback(&sqltypes.Result{Fields: fields, State: sqltypes.RStateFields}); err != nil {
+		return err
+	}
+
+	var allRowCount uint64
+	byteCount := 0
+	qr := &sqltypes.Result{Fields: fields, Rows: make([][]sqltypes.Value, 0, 256), State: sqltypes.RStateRows}
+	send := func(row []sqltypes.Value) error {
+		allRowCount++
+		byteCount += sqltypes.Values(row).Len()
+		qr.Rows = append(qr.Rows, row)
+		if byteCount >= streamBufferSize {
+			if err := callback(qr); err != nil {
+				return err
+			}
+			qr.Rows = qr.Rows[:0]
+			byteCount = 0
+		}
+		return nil
+	}
+	if err := mergeCursors(log, cursors, orderBy, limit, send); err != nil {
+		return err
+	}
+	if len(qr.Rows) > 0 {
+		if err := callback(qr); err != nil {
+			return err
+		}
+	}
+	return callback(&sqltypes.Result{Fields: fields, RowsAffected: allRowCount, State: sqltypes.RStateFinished})
+}
+
+// mergeCursors used to merge the ordered rows of the cursors by the k-way merge, the rows
+// in the limit are passed to the fn in order. Every cursor is read lazily by its producer,
+// the consumer pops the smallest row from the heap. The producers are stopped once the
+// limit is satisfied, the queries of the cursors are killed when they're closed. If a
+// cursor fails, the merge fails once its rows are consumed.
+func mergeCursors(log *xlog.Log, cursors []driver.Rows, orderBy *planner.OrderByPlan, limit *planner.LimitPlan, fn func([]sqltypes.Value) error) error {
+	var wg sync.WaitGroup
+
+	var keys []streamKey
+	if orderBy != nil {
+		var err error
+		if keys, err = orderByKeys(cursors[0].Fields(), orderBy); err != nil {
+			return err
+		}
+	}
+	offset, count := 0, -1
+	if limit != nil {
+		offset, count = limit.Offset, limit.Limit
+	}
+
+	// producer.
+	stop := make(chan struct{})
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+	// The error of the cursor i is set before its rows are closed.
+	allErrors := make([]error, len(cursors))
+	oneFetch := func(i int, cursor driver.Rows, rows chan []sqltypes.Value) {
+		defer func() {
+			close(rows)
+			wg.Done()
+		}()
+		for cursor.Next() {
+			row, err := cursor.RowValues()
+			if err != nil {
+				log.Error("stream.merge.cursor.RowValues.error:%+v", err)
+				allErrors[i] = err
+				return
+			}
+			select {
+			case <-stop:
+				return
+			case rows <- row:
+			}
+		}
+		if err := cursor.LastError(); err != nil {
+			log.Error("stream.merge.cursor.error:%+v", err)
+			allErrors[i] = err
+		}
+	}
+	sources := make([]*rowSource, len(cursors))
+	for i, cursor := range cursors {
+		i := i
+		rows := make(chan []sqltypes.Value, streamPrefetch)
+		sources[i] = &rowSource{
+			index: i,
+			next: func() ([]sqltypes.Value, error) {
+				row, ok := <-rows
+				if !ok {
+					return nil, allErrors[i]
+				}
+				return row, nil
+			},
+		}
+		wg.Add(1)
+		go oneFetch(i, cursor, rows)
+	}
+
+	// consumer.
+	merger, err := newRowMerger(sources, keys)
+	if err != nil {
+		return err
+	}
+	for count != 0 {
+		row, err := merger.next()
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			break
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if count > 0 {
+			count--
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package executor
+
+import (
+	"backend"
+	"errors"
+	"fmt"
+	"planner"
+	"router"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/driver"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+	"github.com/xelabs/go-mysqlstack/xlog"
+
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
+)
+
+func TestStreamExecutor(t *testing.T) {
+	result := func(vals ...string) *sqltypes.Result {
+		r := &sqltypes.Result{
+			Fields: []*querypb.Field{
+				{Name: "id", Type: querypb.Type_INT32, Table: "B"},
+				{Name: "name", Type: querypb.Type_VARCHAR, Table: "B"},
+			},
+		}
+		for _, val := range vals {
+			r.Rows = append(r.Rows, []sqltypes.Value{
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte(val)),
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("n"+val)),
+			})
+		}
+		return r
+	}
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableBConfig())
+	assert.Nil(t, err)
+
+	scatter, fakedbs, cleanup := backend.MockScatter(log, 10)
+	defer cleanup()
+	fakedbs.AddQueryPattern("select id, name from sbtest.B0 as B order by id asc.*", result("1", "4", "5", "9"))
+	fakedbs.AddQueryPattern("select id, name from sbtest.B1 as B order by id asc.*", result("2", "3", "5", "8"))
+	fakedbs.AddQueryPattern("select id, name from sbtest.B0 as B order by id desc.*", result("9", "5", "4", "1"))
+	fakedbs.AddQueryPattern("select id, name from sbtest.B1 as B order by id desc.*", result("8", "5", "3", "2"))
+	fakedbs.AddQueryPattern("select id, name from sbtest.B0 as B limit.*", result("1", "4"))
+	fakedbs.AddQueryPattern("select id, name from sbtest.B1 as B limit.*", result("2", "3"))
+
+	querys := []string{
+		"select id, name from B order by id asc",
+		"select id, name from B order by id desc limit 2, 3",
+		"select id, name from B order by id asc limit 100",
+		"select id, name from B limit 1, 2",
+	}
+	results := []string{
+		"[[1 n1] [2 n2] [3 n3] [4 n4] [5 n5] [5 n5] [8 n8] [9 n9]]",
+		"[[5 n5] [5 n5] [4 n4]]",
+		"[[1 n1] [2 n2] [3 n3] [4 n4] [5 n5] [5 n5] [8 n8] [9 n9]]",
+		"[[4 n4] [2 n2]]",
+	}
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := planner.NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		txn, err := scatter.CreateTransaction()
+		assert.Nil(t, err)
+		defer txn.Finish()
+
+		var rows [][]sqltypes.Value
+		var finished *sqltypes.Result
+		executor := NewStreamExecutor(log, plan, txn)
+		err = executor.Execute(func(qr *sqltypes.Result) error {
+			switch qr.State {
+			case sqltypes.RStateRows:
+				rows = append(rows, qr.Rows...)
+			case sqltypes.RStateFinished:
+				finished = qr
+			}
+			return nil
+		}, 16)
+		assert.Nil(t, err)
+		assert.Equal(t, results[i], fmt.Sprintf("%v", rows), query)
+		assert.Equal(t, uint64(len(rows)), finished.RowsAffected, query)
+	}
+}
+
+// mockRows is the cursor of the backend which fails after sending the rows.
+type mockRows struct {
+	fields []*querypb.Field
+	rows   [][]sqltypes.Value
+	err    error
+	index  int
+}
+
+func newMockRows(err error, vals ...string) *mockRows {
+	r := &mockRows{
+		fields: []*querypb.Field{{Name: "id", Type: querypb.Type_INT32, Table: "B"}},
+		err:    err,
+	}
+	for _, val := range vals {
+		r.rows = append(r.rows, []sqltypes.Value{sqltypes.MakeTrusted(querypb.Type_INT32, []byte(val))})
+	}
+	return r
+}
+
+func (r *mockRows) Next() bool {
+	if r.index < len(r.rows) {
+		r.index++
+		return true
+	}
+	return false
+}
+
+func (r *mockRows) RowValues() ([]sqltypes.Value, error) {
+	return r.rows[r.index-1], nil
+}
+
+func (r *mockRows) LastError() error {
+	if r.index < len(r.rows) {
+		return nil
+	}
+	return r.err
+}
+
+func (r *mockRows) Close() error             { return nil }
+func (r *mockRows) Datas() []byte            { return nil }
+func (r *mockRows) Bytes() int               { return 0 }
+func (r *mockRows) RowsAffected() uint64     { return 0 }
+func (r *mockRows) LastInsertID() uint64     { return 0 }
+func (r *mockRows) Fields() []*querypb.Field { return r.fields }
+
+func TestStreamMergeCursorError(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	orderBy := &planner.OrderByPlan{OrderBys: []planner.OrderBy{{Field: "id", Direction: planner.ASC}}}
+	callback := func(qr *sqltypes.Result) error {
+		return nil
+	}
+
+	// The second cursor is broken in the middle of the stream.
+	cursors := []driver.Rows{
+		newMockRows(nil, "1", "3", "5"),
+		newMockRows(errors.New("mock.stream.broken"), "2", "4"),
+	}
+	err := streamMerge(log, cursors, orderBy, nil, callback, 16)
+	assert.EqualError(t, err, "mock.stream.broken")
+
+	// The error is reported by the merge without the order by.
+	cursors = []driver.Rows{
+		newMockRows(errors.New("mock.stream.broken"), "1"),
+	}
+	err = streamMerge(log, cursors, nil, &planner.LimitPlan{Limit: 10}, callback, 16)
+	assert.EqualError(t, err, "mock.stream.broken")
+
+	// The limit is satisfied before the broken rows.
+	cursors = []driver.Rows{
+		newMockRows(nil, "1", "3", "5"),
+		newMockRows(errors.New("mock.stream.broken"), "2", "4"),
+	}
+	err = streamMerge(log, cursors, orderBy, &planner.LimitPlan{Limit: 1}, callback, 16)
+	assert.Nil(t, err)
+}
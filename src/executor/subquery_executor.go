@@ -0,0 +1,45 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package executor
+
+import (
+	"backend"
+	"planner"
+	"xcontext"
+
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+// Materialize executes the subqueries of the plan in the txn, the inner ones first,
+// then substitutes the results into the plan and builds it.
+func Materialize(log *xlog.Log, plan planner.SubqueryPlan, txn backend.Transaction) error {
+	subs := plan.Subqueries()
+	if len(subs) == 0 {
+		return nil
+	}
+
+	results := make([]*sqltypes.Result, 0, len(subs))
+	for _, sub := range subs {
+		if err := Materialize(log, sub.Plan, txn); err != nil {
+			return err
+		}
+		reqCtx := xcontext.NewRequestContext()
+		reqCtx.Mode = sub.Plan.ReqMode
+		reqCtx.TxnMode = xcontext.TxnRead
+		reqCtx.RawQuery = sub.Plan.RawQuery
+
+		rsCtx := xcontext.NewResultContext()
+		if err := buildExecutor(log, sub.Plan.Root, txn).execute(reqCtx, rsCtx); err != nil {
+			return err
+		}
+		results = append(results, rsCtx.Results)
+	}
+	return plan.Materialize(results)
+}
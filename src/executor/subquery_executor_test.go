@@ -0,0 +1,170 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package executor
+
+import (
+	"fmt"
+	"testing"
+
+	"backend"
+	"fakedb"
+	"planner"
+	"router"
+	"xcontext"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/xlog"
+
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+)
+
+func TestSubqueryExecutor(t *testing.T) {
+	ids := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "id",
+				Type: querypb.Type_INT32,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("3")),
+			},
+		},
+	}
+	names := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "name",
+				Type: querypb.Type_VARCHAR,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("go")),
+			},
+		},
+	}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+	err := route.AddForTest(database, router.MockTableAConfig())
+	assert.Nil(t, err)
+
+	// Create scatter and query handler.
+	scatter, fakedbs, cleanup := backend.MockScatter(log, 10)
+	defer cleanup()
+	// The inner query returns the same id from all the partitions.
+	fakedbs.AddQueryPattern("select id from sbtest.A[0-9] as A where a = 1", ids)
+	fakedbs.AddQueryPattern("select id from sbtest.A[0-9] as A where a = 2( limit 1)?", &sqltypes.Result{Fields: ids.Fields})
+	fakedbs.AddQueryPattern(`select name from sbtest.A[0-9] as A where id in \(3\)`, names)
+	fakedbs.AddQueryPattern(`select name from sbtest.A[0-9] as A where false`, &sqltypes.Result{Fields: names.Fields})
+	fakedbs.AddQueryPattern(`update sbtest.A[0-9] set name = 'x' where id in \(3\)`, fakedb.Result3)
+
+	// select.
+	{
+		querys := []string{
+			"select name from A where id in (select id from A where a=1)",
+			"select name from A where id in (select id from A where a=2)",
+			"select name from A where exists (select id from A where a=2)",
+		}
+		results := []string{
+			"[[go]]",
+			"[]",
+			"[]",
+		}
+		for i, query := range querys {
+			node, err := sqlparser.Parse(query)
+			assert.Nil(t, err)
+			plan := planner.NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+			err = plan.Build()
+			assert.Nil(t, err)
+
+			txn, err := scatter.CreateTransaction()
+			assert.Nil(t, err)
+			defer txn.Finish()
+			executor := NewSelectExecutor(log, plan, txn)
+			ctx := xcontext.NewResultContext()
+			err = executor.Execute(ctx)
+			assert.Nil(t, err)
+			assert.Equal(t, results[i], fmt.Sprintf("%v", ctx.Results.Rows))
+		}
+	}
+
+	// update.
+	{
+		query := "update A set name='x' where id in (select id from A where a=1)"
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := planner.NewUpdatePlan(log, database, query, node.(*sqlparser.Update), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		txn, err := scatter.CreateTransaction()
+		assert.Nil(t, err)
+		defer txn.Finish()
+		executor := NewUpdateExecutor(log, plan, txn)
+		ctx := xcontext.NewResultContext()
+		err = executor.Execute(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(plan.Querys))
+	}
+
+	// The join arms of the subquery are executed one by one in the twopc txn.
+	{
+		err := route.AddForTest(database, router.MockTableBConfig())
+		assert.Nil(t, err)
+		fakedbs.AddQueryPattern("select A.id from sbtest.A[0-9] as A where A.a = 4 order by A.id asc", ids)
+		fakedbs.AddQueryPattern("select B.id from sbtest.B[0-9] as B order by B.id asc", ids)
+
+		query := "update A set name='x' where id in (select A.id from A join B on A.id=B.id where A.a=4)"
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := planner.NewUpdatePlan(log, database, query, node.(*sqlparser.Update), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		txn, err := scatter.CreateTransaction()
+		assert.Nil(t, err)
+		defer txn.Finish()
+		err = txn.Begin()
+		assert.Nil(t, err)
+		assert.True(t, txn.IsTwoPC())
+		executor := NewUpdateExecutor(log, plan, txn)
+		ctx := xcontext.NewResultContext()
+		err = executor.Execute(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(plan.Querys))
+	}
+
+	// Error.
+	{
+		fakedbs.AddQueryErrorPattern("select id from sbtest.A[0-9] as A where a = 3", fmt.Errorf("mock.subquery.error"))
+		query := "delete from A where id in (select id from A where a=3)"
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := planner.NewDeletePlan(log, database, query, node.(*sqlparser.Delete), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		txn, err := scatter.CreateTransaction()
+		assert.Nil(t, err)
+		defer txn.Finish()
+		executor := NewDeleteExecutor(log, plan, txn)
+		ctx := xcontext.NewResultContext()
+		err = executor.Execute(ctx)
+		assert.NotNil(t, err)
+		assert.Equal(t, 0, len(plan.Querys))
+	}
+}
@@ -0,0 +1,238 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package executor
+
+import (
+	"backend"
+	"planner"
+	"strconv"
+	"strings"
+	"sync"
+	"xcontext"
+
+	"github.com/pkg/errors"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/common"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+	"github.com/xelabs/go-mysqlstack/xlog"
+
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
+)
+
+var (
+	_ PlanExecutor = &UnionExecutor{}
+)
+
+// UnionExecutor represents union executor.
+type UnionExecutor struct {
+	log         *xlog.Log
+	node        *planner.UnionNode
+	left, right PlanExecutor
+	txn         backend.Transaction
+}
+
+// NewUnionExecutor creates the new union executor.
+func NewUnionExecutor(log *xlog.Log, node *planner.UnionNode, txn backend.Transaction) *UnionExecutor {
+	return &UnionExecutor{
+		log:  log,
+		node: node,
+		txn:  txn,
+	}
+}
+
+// execute used to execute the executor.
+// The arms are executed concurrently except in the twopc txn, the rows are concatenated in order and
+// the duplicate rows are removed if the union is distinct.
+func (u *UnionExecutor) execute(reqCtx *xcontext.RequestContext, ctx *xcontext.ResultContext) error {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	allErrors := make([]error, 0, 8)
+	oneExec := func(exec PlanExecutor, ctx *xcontext.ResultContext) {
+		defer wg.Done()
+		req := xcontext.NewRequestContext()
+		req.Mode = reqCtx.Mode
+		req.TxnMode = reqCtx.TxnMode
+		req.RawQuery = reqCtx.RawQuery
+
+		if err := exec.execute(req, ctx); err != nil {
+			mu.Lock()
+			allErrors = append(allErrors, err)
+			mu.Unlock()
+		}
+	}
+
+	lctx := xcontext.NewResultContext()
+	rctx := xcontext.NewResultContext()
+	if u.txn.IsTwoPC() {
+		// The twopc txn shares the backend connections, the arms are executed one by one.
+		wg.Add(1)
+		oneExec(u.left, lctx)
+		if len(allErrors) > 0 {
+			return allErrors[0]
+		}
+		wg.Add(1)
+		oneExec(u.right, rctx)
+	} else {
+		wg.Add(1)
+		go oneExec(u.left, lctx)
+		wg.Add(1)
+		go oneExec(u.right, rctx)
+		wg.Wait()
+	}
+	if len(allErrors) > 0 {
+		return allErrors[0]
+	}
+
+	lres, rres := lctx.Results, rctx.Results
+	if len(lres.Fields) != len(rres.Fields) {
+		return errors.New("the.used.select.statements.have.a.different.number.of.columns")
+	}
+
+	fields, numerics := unionFields(lres.Fields, rres.Fields)
+	ctx.Results = &sqltypes.Result{Fields: fields}
+	rows := make([][]sqltypes.Value, 0, len(lres.Rows)+len(rres.Rows))
+	rows = append(rows, coerceRows(lres.Rows, lres.Fields, fields)...)
+	rows = append(rows, coerceRows(rres.Rows, rres.Fields, fields)...)
+	if u.node.Distinct {
+		rows = distinctRows(rows, numerics)
+	}
+	ctx.Results.Rows = rows
+	ctx.Results.RowsAffected = uint64(len(rows))
+	return execSubPlan(u.log, u.node, ctx)
+}
+
+// unionFields returns the fields with the unified types of the arms, and
+// whether the columns are compared as numbers.
+// The numbers are unified to the widest numeric type, the others are VARCHAR.
+func unionFields(lfields, rfields []*querypb.Field) ([]*querypb.Field, []bool) {
+	fields := make([]*querypb.Field, len(lfields))
+	numerics := make([]bool, len(lfields))
+	for i, lfield := range lfields {
+		field := *lfield
+		ltyp, rtyp := lfield.Type, rfields[i].Type
+		switch {
+		case ltyp == rtyp || rtyp == querypb.Type_NULL_TYPE:
+		case ltyp == querypb.Type_NULL_TYPE:
+			field.Type = rtyp
+		case isNumberType(ltyp) && isNumberType(rtyp):
+			switch {
+			case sqltypes.IsFloat(ltyp) || sqltypes.IsFloat(rtyp):
+				field.Type = querypb.Type_FLOAT64
+			case ltyp == querypb.Type_DECIMAL || rtyp == querypb.Type_DECIMAL:
+				field.Type = querypb.Type_DECIMAL
+			default:
+				field.Type = querypb.Type_INT64
+			}
+		default:
+			field.Type = querypb.Type_VARCHAR
+		}
+		fields[i] = &field
+		numerics[i] = isNumberType(field.Type)
+	}
+	return fields, numerics
+}
+
+// coerceRows converts the values to the unified types if the types of the arm are different.
+func coerceRows(rows [][]sqltypes.Value, from, to []*querypb.Field) [][]sqltypes.Value {
+	for i := range from {
+		if from[i].Type == to[i].Type {
+			continue
+		}
+		for _, row := range rows {
+			if !row[i].IsNull() {
+				row[i] = sqltypes.MakeTrusted(to[i].Type, row[i].Raw())
+			}
+		}
+	}
+	return rows
+}
+
+// distinctRows removes the duplicate rows, keeps the first one.
+// The numbers are compared by value, such as 1 and 1.0.
+func distinctRows(rows [][]sqltypes.Value, numerics []bool) [][]sqltypes.Value {
+	seen := make(map[string]struct{}, len(rows))
+	res := rows[:0]
+	for _, row := range rows {
+		keySlice := []byte{0x01}
+		for i, v := range row {
+			if v.IsNull() {
+				keySlice = append(keySlice, 0x00)
+			} else {
+				keySlice = append(keySlice, 0x03)
+				if numerics[i] {
+					keySlice = append(keySlice, numberKey(v)...)
+				} else {
+					keySlice = append(keySlice, v.Raw()...)
+				}
+			}
+			keySlice = append(keySlice, 0x02)
+		}
+		key := common.BytesToString(keySlice)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		res = append(res, row)
+	}
+	return res
+}
+
+// numberKey returns the canonical text of the number value, the numbers are
+// formatted to the same bytes if they are equal, such as 1, 1.00 and 1e0.
+// The decimal is normalized as text to keep all its digits.
+func numberKey(val sqltypes.Value) []byte {
+	if val.IsIntegral() {
+		return val.Raw()
+	}
+	text := val.ToString()
+	if val.IsFloat() {
+		f, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return val.Raw()
+		}
+		text = strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return []byte(decimalText(text))
+}
+
+// decimalText trims the sign '+', the leading zeros of the integer part and
+// the trailing zeros of the fraction part, such as '+01.50' --> '1.5', '-0.00' --> '0'.
+func decimalText(s string) string {
+	s = strings.TrimSpace(s)
+	neg := false
+	switch {
+	case strings.HasPrefix(s, "-"):
+		neg = true
+		s = s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+	intPart, fracPart := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+	}
+	intPart = strings.TrimLeft(intPart, "0")
+	fracPart = strings.TrimRight(fracPart, "0")
+	if intPart == "" {
+		intPart = "0"
+	}
+
+	text := intPart
+	if fracPart != "" {
+		text += "." + fracPart
+	}
+	if neg && text != "0" {
+		text = "-" + text
+	}
+	return text
+}
+
+// isNumberType returns true if the values of the type are compared as numbers.
+func isNumberType(typ querypb.Type) bool {
+	return sqltypes.IsIntegral(typ) || sqltypes.IsFloat(typ) || typ == sqltypes.Decimal
+}
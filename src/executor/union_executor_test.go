@@ -0,0 +1,224 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package executor
+
+import (
+	"fmt"
+	"testing"
+
+	"backend"
+	"planner"
+	"router"
+	"xcontext"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/xlog"
+
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+)
+
+func TestUnionExecutor(t *testing.T) {
+	r1 := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "a",
+				Type: querypb.Type_INT32,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("3")),
+			},
+		},
+	}
+	r2 := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "a",
+				Type: querypb.Type_INT32,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("1")),
+			},
+			{
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("3")),
+			},
+			{
+				sqltypes.NULL,
+			},
+		},
+	}
+	r3 := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "id",
+				Type: querypb.Type_INT32,
+			},
+			{
+				Name: "a",
+				Type: querypb.Type_INT32,
+			},
+		},
+	}
+	r4 := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "b",
+				Type: querypb.Type_DECIMAL,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_DECIMAL, []byte("3.00")),
+			},
+			{
+				sqltypes.MakeTrusted(querypb.Type_DECIMAL, []byte("2.50")),
+			},
+		},
+	}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+	err := route.AddForTest(database, router.MockTableAConfig())
+	assert.Nil(t, err)
+
+	// Create scatter and query handler.
+	scatter, fakedbs, cleanup := backend.MockScatter(log, 10)
+	defer cleanup()
+	fakedbs.AddQueryPattern("select a from sbtest.A[0-9]+ as A limit 1", r1)
+	fakedbs.AddQueryPattern("select a from sbtest.A[0-9]+ as A where id = 1", r2)
+	fakedbs.AddQueryPattern("select \\* from sbtest.A[0-9]+ as A limit 1", r3)
+	fakedbs.AddQueryPattern("select b from sbtest.A[0-9]+ as A where id = 2", r4)
+
+	querys := []string{
+		"(select a from A limit 1) union all select a from A where id=1",
+		"(select a from A limit 1) union select a from A where id=1",
+		"(select a from A limit 1) union distinct select a from A where id=1 order by a desc limit 1",
+	}
+	results := []string{
+		"[[3] [1] [3] []]",
+		"[[3] [1] []]",
+		"[[3]]",
+	}
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := planner.NewSelectPlan(log, database, query, node.(*sqlparser.Union), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+		_, ok := plan.Root.(*planner.UnionNode)
+		assert.True(t, ok)
+
+		txn, err := scatter.CreateTransaction()
+		assert.Nil(t, err)
+		defer txn.Finish()
+		executor := NewSelectExecutor(log, plan, txn)
+		ctx := xcontext.NewResultContext()
+		err = executor.Execute(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, results[i], fmt.Sprintf("%v", ctx.Results.Rows))
+	}
+
+	// The numbers of the different types are compared by value.
+	{
+		query := "(select a from A limit 1) union select b from A where id=2"
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := planner.NewSelectPlan(log, database, query, node.(*sqlparser.Union), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		txn, err := scatter.CreateTransaction()
+		assert.Nil(t, err)
+		defer txn.Finish()
+		executor := NewSelectExecutor(log, plan, txn)
+		ctx := xcontext.NewResultContext()
+		err = executor.Execute(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, "[[3] [2.50]]", fmt.Sprintf("%v", ctx.Results.Rows))
+		assert.Equal(t, querypb.Type_DECIMAL, ctx.Results.Fields[0].Type)
+		assert.Equal(t, querypb.Type_DECIMAL, ctx.Results.Rows[0][0].Type())
+	}
+
+	// The arms are executed one by one in the twopc txn.
+	{
+		query := "(select a from A limit 1) union select a from A where id=1"
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := planner.NewSelectPlan(log, database, query, node.(*sqlparser.Union), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		txn, err := scatter.CreateTransaction()
+		assert.Nil(t, err)
+		defer txn.Finish()
+		err = txn.Begin()
+		assert.Nil(t, err)
+		assert.True(t, txn.IsTwoPC())
+		executor := NewSelectExecutor(log, plan, txn)
+		ctx := xcontext.NewResultContext()
+		err = executor.Execute(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, "[[3] [1] []]", fmt.Sprintf("%v", ctx.Results.Rows))
+	}
+
+	// The number of columns is unknown until the '*' is executed.
+	{
+		query := "(select * from A limit 1) union select a from A where id=1"
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := planner.NewSelectPlan(log, database, query, node.(*sqlparser.Union), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		txn, err := scatter.CreateTransaction()
+		assert.Nil(t, err)
+		defer txn.Finish()
+		executor := NewSelectExecutor(log, plan, txn)
+		ctx := xcontext.NewResultContext()
+		err = executor.Execute(ctx)
+		assert.NotNil(t, err)
+		assert.Equal(t, "the.used.select.statements.have.a.different.number.of.columns", err.Error())
+	}
+}
+
+func TestUnionExecutorDistinctRows(t *testing.T) {
+	decimal := func(s string) sqltypes.Value {
+		return sqltypes.MakeTrusted(sqltypes.Decimal, []byte(s))
+	}
+	rows := [][]sqltypes.Value{
+		{sqltypes.NewInt64(1)},
+		{decimal("1.00")},
+		{sqltypes.NewFloat64(1)},
+		{decimal("-0.00")},
+		{sqltypes.NewInt64(0)},
+		// The decimals beyond the float64 precision are kept apart.
+		{decimal("12345678901234567890.10")},
+		{decimal("12345678901234567890.1")},
+		{decimal("12345678901234567890.2")},
+		{sqltypes.NULL},
+		{sqltypes.NULL},
+	}
+	got := distinctRows(rows, []bool{true})
+	want := [][]sqltypes.Value{
+		{sqltypes.NewInt64(1)},
+		{decimal("-0.00")},
+		{decimal("12345678901234567890.10")},
+		{decimal("12345678901234567890.2")},
+		{sqltypes.NULL},
+	}
+	assert.Equal(t, want, got)
+}
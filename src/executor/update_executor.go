@@ -39,6 +39,36 @@ func NewUpdateExecutor(log *xlog.Log, plan planner.Plan, txn backend.Transaction
 // Execute used to execute the executor.
 func (executor *UpdateExecutor) Execute(ctx *xcontext.ResultContext) error {
 	plan := executor.plan.(*planner.UpdatePlan)
+	if err := Materialize(executor.log, plan, executor.txn); err != nil {
+		return err
+	}
+	// The table may be frozen after the plan is built, the write is in-flight
+	// until the txn is committed or rolled back.
+	if err := plan.BeginWrite(); err != nil {
+		return err
+	}
+	executor.txn.OnFinish(plan.EndWrite)
+
+	// The shard key is updated, the rows are moved to the new partitions.
+	if plan.MoveShardKey {
+		rs, err := moveRows(executor.log, executor.txn, plan, plan.ReqMode, plan.RawQuery)
+		if err != nil {
+			return err
+		}
+		ctx.Results = rs
+		return nil
+	}
+
+	// The multi-table update isn't pushed down, the rows are written by the joined rows.
+	if plan.Select != nil {
+		rs, err := writeRows(executor.log, executor.txn, plan.Select, plan.ReqMode, plan.RawQuery, plan.RowQuerys)
+		if err != nil {
+			return err
+		}
+		ctx.Results = rs
+		return nil
+	}
+
 	reqCtx := xcontext.NewRequestContext()
 	reqCtx.Mode = plan.ReqMode
 	reqCtx.TxnMode = xcontext.TxnWrite
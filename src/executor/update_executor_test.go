@@ -9,6 +9,7 @@
 package executor
 
 import (
+	"fmt"
 	"testing"
 
 	"backend"
@@ -20,6 +21,9 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/xelabs/go-mysqlstack/sqlparser"
 	"github.com/xelabs/go-mysqlstack/xlog"
+
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
 )
 
 func TestUpdateExecutor(t *testing.T) {
@@ -64,3 +68,171 @@ func TestUpdateExecutor(t *testing.T) {
 		}
 	}
 }
+
+func TestUpdateJoinExecutor(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	// Create scatter and query handler.
+	scatter, fakedbs, cleanup := backend.MockScatter(log, 10)
+	defer cleanup()
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableAConfig(), router.MockTableBConfig())
+	assert.Nil(t, err)
+
+	rsA := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "b", Type: querypb.Type_INT32},
+		},
+		Rows: [][]sqltypes.Value{
+			{sqltypes.MakeTrusted(querypb.Type_INT32, []byte("1"))},
+		},
+	}
+	rsB := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "c", Type: querypb.Type_INT32},
+			{Name: "b", Type: querypb.Type_INT32},
+		},
+		Rows: [][]sqltypes.Value{
+			{sqltypes.MakeTrusted(querypb.Type_INT32, []byte("7")), sqltypes.MakeTrusted(querypb.Type_INT32, []byte("1"))},
+		},
+	}
+	fakedbs.AddQueryPattern("select A.b from sbtest.A.*", rsA)
+	fakedbs.AddQueryPattern("select B.c, B.b from sbtest.B.*", rsB)
+	fakedbs.AddQueryPattern("update sbtest.A[0-9] set c = 7 where b = 1", &sqltypes.Result{RowsAffected: 1})
+
+	// The join isn't co-located, A is updated by the joined rows on every partition.
+	query := "update A join B on A.b = B.b set A.c = B.c where B.d = 1"
+	node, err := sqlparser.Parse(query)
+	assert.Nil(t, err)
+	plan := planner.NewUpdatePlan(log, database, query, node.(*sqlparser.Update), route)
+	err = plan.Build()
+	assert.Nil(t, err)
+	assert.NotNil(t, plan.Select)
+
+	txn, err := scatter.CreateTransaction()
+	assert.Nil(t, err)
+	defer txn.Finish()
+	executor := NewUpdateExecutor(log, plan, txn)
+	ctx := xcontext.NewResultContext()
+	err = executor.Execute(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), ctx.Results.RowsAffected)
+
+	// The select error.
+	{
+		fakedbs.AddQueryErrorPattern("select B.c, B.b from sbtest.B.*", fmt.Errorf("mock.join.error"))
+		plan := planner.NewUpdatePlan(log, database, query, node.(*sqlparser.Update), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		txn, err := scatter.CreateTransaction()
+		assert.Nil(t, err)
+		defer txn.Finish()
+		executor := NewUpdateExecutor(log, plan, txn)
+		err = executor.Execute(xcontext.NewResultContext())
+		assert.NotNil(t, err)
+	}
+}
+
+func TestUpdateMoveShardKeyExecutor(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	// Create scatter and query handler.
+	scatter, fakedbs, cleanup := backend.MockScatter(log, 10)
+	defer cleanup()
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableAConfig())
+	assert.Nil(t, err)
+
+	index := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "index_name", Type: querypb.Type_VARCHAR},
+			{Name: "column_name", Type: querypb.Type_VARCHAR},
+		},
+		Rows: [][]sqltypes.Value{
+			{sqltypes.NewVarChar("PRIMARY"), sqltypes.NewVarChar("id")},
+		},
+	}
+	rs := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "0", Type: querypb.Type_INT64},
+			{Name: "id", Type: querypb.Type_INT32},
+			{Name: "b", Type: querypb.Type_INT32},
+			{Name: "99999999", Type: querypb.Type_INT32},
+		},
+		Rows: [][]sqltypes.Value{
+			{sqltypes.NewInt64(0), sqltypes.NewInt32(1), sqltypes.NewInt32(2), sqltypes.NewInt32(99999999)},
+		},
+	}
+	fakedbs.AddQueryPattern("XA .*", &sqltypes.Result{})
+	fakedbs.AddQueryPattern("select index_name, column_name from information_schema.statistics .*", index)
+	fakedbs.AddQueryPattern("select 0, \\*, 99999999 from sbtest.A.* where id = 1 for update", rs)
+	fakedbs.AddQueryPattern("delete from sbtest.A.* where id = 1", &sqltypes.Result{RowsAffected: 1})
+	fakedbs.AddQueryPattern("insert into sbtest.A.*\\(id, b\\) values \\(99999999, 2\\)", &sqltypes.Result{RowsAffected: 1})
+
+	query := "update sbtest.A set id = 99999999 where id = 1"
+	node, err := sqlparser.Parse(query)
+	assert.Nil(t, err)
+
+	// The rows are moved in the twopc txn.
+	{
+		plan := planner.NewUpdatePlan(log, database, query, node.(*sqlparser.Update), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		txn, err := scatter.CreateTransaction()
+		assert.Nil(t, err)
+		defer txn.Finish()
+		err = txn.Begin()
+		assert.Nil(t, err)
+		executor := NewUpdateExecutor(log, plan, txn)
+		ctx := xcontext.NewResultContext()
+		err = executor.Execute(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(1), ctx.Results.RowsAffected)
+		err = txn.Commit()
+		assert.Nil(t, err)
+	}
+
+	// The twopc is required.
+	{
+		plan := planner.NewUpdatePlan(log, database, query, node.(*sqlparser.Update), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		txn, err := scatter.CreateTransaction()
+		assert.Nil(t, err)
+		defer txn.Finish()
+		executor := NewUpdateExecutor(log, plan, txn)
+		err = executor.Execute(xcontext.NewResultContext())
+		assert.NotNil(t, err)
+		assert.Equal(t, "unsupported: cannot.update.shard.key.without.twopc", err.Error())
+	}
+
+	// The insert error.
+	{
+		fakedbs.AddQueryErrorPattern("insert into sbtest.A.*", fmt.Errorf("mock.insert.error"))
+		plan := planner.NewUpdatePlan(log, database, query, node.(*sqlparser.Update), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		txn, err := scatter.CreateTransaction()
+		assert.Nil(t, err)
+		defer txn.Finish()
+		err = txn.Begin()
+		assert.Nil(t, err)
+		executor := NewUpdateExecutor(log, plan, txn)
+		err = executor.Execute(xcontext.NewResultContext())
+		assert.NotNil(t, err)
+		err = txn.Rollback()
+		assert.Nil(t, err)
+	}
+}
@@ -0,0 +1,446 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package optimizer
+
+import (
+	"strconv"
+	"strings"
+
+	"planner"
+	"router"
+
+	"github.com/pkg/errors"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+var (
+	_ Optimizer = &CachedOptimizer{}
+)
+
+// CachedOptimizer is the optimizer who reuses the plans of the cache. The single-table select,
+// update and delete are normalized, the literals of the where are replaced by the bind variables.
+// The literals of the shard key equalities are replaced too, the route of them is computed when
+// the query is bound and the plans of the different routes are cached apart. The other literals
+// compared with the shard key are kept, since they decide the partitions routed. On a miss, the
+// plan is built once by the normalized query and cached if it can be rebound.
+type CachedOptimizer struct {
+	log      *xlog.Log
+	database string
+	query    string
+	node     sqlparser.Statement
+	router   *router.Router
+	cache    *PlanCache
+	// name of the optimizer, the plans of the different optimizers are cached apart.
+	name         string
+	newOptimizer func(query string, node sqlparser.Statement) Optimizer
+}
+
+// NewCachedOptimizer creates the new cached optimizer, the plans are built by the optimizer
+// created by the newOptimizer.
+func NewCachedOptimizer(log *xlog.Log, database string, query string, node sqlparser.Statement, router *router.Router, cache *PlanCache, name string, newOptimizer func(query string, node sqlparser.Statement) Optimizer) *CachedOptimizer {
+	return &CachedOptimizer{
+		log:          log,
+		database:     database,
+		query:        query,
+		node:         node,
+		router:       router,
+		cache:        cache,
+		name:         name,
+		newOptimizer: newOptimizer,
+	}
+}
+
+// BuildPlanTree used to build plan trees for the query.
+func (co *CachedOptimizer) BuildPlanTree() (*planner.PlanTree, error) {
+	stmt, ok := cacheParams(co.router, co.database, co.node)
+	if !ok || strings.Contains(co.query, paramPrefix) {
+		return co.newOptimizer(co.query, co.node).BuildPlanTree()
+	}
+	// The version is read before the route is computed and the plan is built, the route
+	// and the plan computed by the stale rules are never used by the new version.
+	version := co.router.Version()
+	route, err := co.route(stmt)
+	if err != nil {
+		return co.newOptimizer(co.query, co.node).BuildPlanTree()
+	}
+
+	text := normalize(co.node, stmt.params)
+	key := strings.Join([]string{co.name, co.database, strconv.FormatUint(version, 10), route, text}, "\x00")
+	values := make([]string, len(stmt.params))
+	for i, param := range stmt.params {
+		values[i] = sqlparser.String(param)
+	}
+	if entry, ok := co.cache.get(key); ok {
+		if entry.plan == nil {
+			return co.newOptimizer(co.query, co.node).BuildPlanTree()
+		}
+		return co.bind(entry, values), nil
+	}
+
+	entry, err := co.buildEntry(stmt, values)
+	if err != nil {
+		co.log.Debug("optimizer.plan.cache[%s].uncached:%v", text, err)
+		plans, err := co.newOptimizer(co.query, co.node).BuildPlanTree()
+		if err != nil {
+			return nil, err
+		}
+		co.cache.put(&cacheEntry{key: key})
+		return plans, nil
+	}
+	entry.key = key
+	co.cache.put(entry)
+	return co.bind(entry, values), nil
+}
+
+// bind returns the plan tree of the entry whose parameters are bound to the values.
+func (co *CachedOptimizer) bind(entry *cacheEntry, values []string) *planner.PlanTree {
+	plans := planner.NewPlanTree()
+	plans.Add(entry.bind(co.query, values))
+	return plans
+}
+
+// route returns the partitions routed by the shard key params, the plans of the query are
+// same if the params route to the same partitions. The select is routed by the segments of
+// the index, the update and delete are routed by the segments looked up.
+func (co *CachedOptimizer) route(stmt *cacheStmt) (string, error) {
+	if stmt.keys == nil {
+		return "", nil
+	}
+	types, err := co.router.ShardKeyTypes(stmt.database, stmt.table)
+	if err != nil {
+		return "", err
+	}
+	vals := make([]*sqlparser.SQLVal, len(stmt.keys))
+	for i, idx := range stmt.keys {
+		vals[i] = stmt.params[idx]
+	}
+	val, err := router.CompositeKey(vals, types)
+	if err != nil {
+		return "", err
+	}
+	var segments []router.Segment
+	if stmt.dml {
+		segments, err = co.router.Lookup(stmt.database, stmt.table, val, val)
+	} else {
+		var idx int
+		if idx, err = co.router.GetIndex(stmt.database, stmt.table, val); err == nil {
+			segments, err = co.router.GetSegments(stmt.database, stmt.table, idx)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	routes := make([]string, len(segments))
+	for i, segment := range segments {
+		routes[i] = segment.Backend + "." + segment.Table
+	}
+	return strings.Join(routes, ","), nil
+}
+
+// buildEntry used to build the plan of the query whose params are replaced by the bind variables,
+// the shard key literals are kept to route the plan and replaced in the querys after it's built.
+// The querys bound to the values must be same as the querys of the query.
+func (co *CachedOptimizer) buildEntry(stmt *cacheStmt, values []string) (*cacheEntry, error) {
+	params := make([]*sqlparser.SQLVal, len(stmt.params))
+	copy(params, stmt.params)
+	for _, idx := range stmt.keys {
+		params[idx] = nil
+	}
+	text := normalize(co.node, params)
+	node, err := sqlparser.Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	plans, err := co.newOptimizer(text, node).BuildPlanTree()
+	if err != nil {
+		return nil, err
+	}
+	if len(plans.Plans()) != 1 {
+		return nil, errors.Errorf("plans.count[%d]", len(plans.Plans()))
+	}
+	plan := plans.Plans()[0]
+	querys, ok := planner.RebindQuerys(plan)
+	if !ok {
+		return nil, errors.Errorf("plan.type[%T].can't.be.rebound", plan)
+	}
+
+	entry := &cacheEntry{plan: plan, querys: make([]paramQuery, len(querys))}
+	for i, query := range querys {
+		want, ok := newParamQuery(query, len(values))
+		if !ok {
+			return nil, errors.Errorf("query[%s].can't.be.bound", query.Query)
+		}
+		if stmt.keys == nil {
+			entry.querys[i] = want
+			continue
+		}
+		// The shard key literals are replaced by the bind variables, the query bound
+		// to the values must be same.
+		if query.Query, ok = bindShardKeys(query.Query, stmt); !ok {
+			return nil, errors.Errorf("query[%s].shardkey.can't.be.bound", want.tuple.Query)
+		}
+		pq, ok := newParamQuery(query, len(values))
+		if !ok || pq.bind(values) != want.bind(values) {
+			return nil, errors.Errorf("query[%s].is.different", query.Query)
+		}
+		entry.querys[i] = pq
+	}
+	return entry, nil
+}
+
+// bindShardKeys returns the query whose shard key equalities are replaced by the bind variables,
+// false if any equality isn't found.
+func bindShardKeys(query string, stmt *cacheStmt) (string, bool) {
+	node, err := sqlparser.Parse(query)
+	if err != nil {
+		return "", false
+	}
+	var where *sqlparser.Where
+	switch node := node.(type) {
+	case *sqlparser.Select:
+		where = node.Where
+	case *sqlparser.Delete:
+		where = node.Where
+	case *sqlparser.Update:
+		where = node.Where
+	}
+	if where == nil {
+		return "", false
+	}
+	bound := 0
+	for _, filter := range splitAnd(nil, where.Expr) {
+		if i, ok := stmt.shardKeyEqual(filter); ok {
+			filter.(*sqlparser.ComparisonExpr).Right = sqlparser.NewValArg([]byte(paramPrefix + strconv.Itoa(stmt.keys[i]+1)))
+			bound++
+		}
+	}
+	return sqlparser.String(node), bound == len(stmt.keys)
+}
+
+// cacheStmt is the statement whose plans can be cached.
+type cacheStmt struct {
+	database string
+	table    string
+	// dml is true if the statement is an update or delete.
+	dml       bool
+	shardKeys []string
+	// params are the literals which can be replaced by the bind variables.
+	params []*sqlparser.SQLVal
+	// keys are the indexes of the params which are the values of the shard key columns,
+	// nil if the shard key isn't bound by the params.
+	keys []int
+}
+
+// shardKeyEqual returns the index of the shard key column if the filter is the 'col = literal'
+// equality of the column.
+func (stmt *cacheStmt) shardKeyEqual(filter sqlparser.Expr) (int, bool) {
+	comparison, ok := skipParenthesis(filter).(*sqlparser.ComparisonExpr)
+	if !ok || comparison.Operator != sqlparser.EqualStr {
+		return -1, false
+	}
+	col, ok := comparison.Left.(*sqlparser.ColName)
+	if !ok {
+		return -1, false
+	}
+	if val, ok := comparison.Right.(*sqlparser.SQLVal); !ok || val.Type == sqlparser.ValArg {
+		return -1, false
+	}
+	for i, key := range stmt.shardKeys {
+		if col.Name.EqualString(key) {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// cacheParams returns the statement whose literals of the where can be replaced by the bind
+// variables, false if the plans of the statement can't be cached. The statement must be a
+// select, update or delete of one table without subqueries. The shard key is bound by the
+// params only if it's bound by the top-level 'col = literal' equalities, one for each column,
+// and the shard key isn't compared otherwise. Or else the literals compared with the shard
+// key are kept, since they decide the partitions routed.
+func cacheParams(route *router.Router, database string, node sqlparser.Statement) (*cacheStmt, bool) {
+	var table sqlparser.TableName
+	var where *sqlparser.Where
+	stmt := &cacheStmt{}
+	switch node := node.(type) {
+	case *sqlparser.Select:
+		if len(node.From) != 1 {
+			return nil, false
+		}
+		expr, ok := node.From[0].(*sqlparser.AliasedTableExpr)
+		if !ok {
+			return nil, false
+		}
+		if table, ok = expr.Expr.(sqlparser.TableName); !ok {
+			return nil, false
+		}
+		where = node.Where
+	case *sqlparser.Delete:
+		if len(node.TableExprs) > 0 {
+			return nil, false
+		}
+		table, where = node.Table, node.Where
+		stmt.dml = true
+	case *sqlparser.Update:
+		if len(node.TableExprs) > 0 {
+			return nil, false
+		}
+		table, where = node.Table, node.Where
+		stmt.dml = true
+	default:
+		return nil, false
+	}
+
+	if !table.Qualifier.IsEmpty() {
+		database = table.Qualifier.String()
+	}
+	conf, err := route.TableConfig(database, table.Name.String())
+	if err != nil {
+		return nil, false
+	}
+	// The select of the global table is sent to a random backend, the plan can't be reused.
+	if _, ok := node.(*sqlparser.Select); ok && conf.ShardType == "GLOBAL" {
+		return nil, false
+	}
+	stmt.database, stmt.table = database, table.Name.String()
+	stmt.shardKeys = router.ShardKeys(conf.ShardKey)
+	isShardKey := func(expr sqlparser.Expr) bool {
+		if col, ok := skipParenthesis(expr).(*sqlparser.ColName); ok {
+			for _, key := range stmt.shardKeys {
+				if col.Name.EqualString(key) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	// The update of the shard key moves the rows, the plan can't be rebound.
+	if update, ok := node.(*sqlparser.Update); ok && conf.ShardKey != "" {
+		for _, expr := range update.Exprs {
+			if isShardKey(expr.Name) {
+				return nil, false
+			}
+		}
+	}
+
+	// The subqueries are executed before the plan is built, and the bind variables
+	// of the statement can't be told from the parameters.
+	ok := true
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch node := node.(type) {
+		case *sqlparser.Subquery, sqlparser.ListArg:
+			ok = false
+		case *sqlparser.SQLVal:
+			if node.Type == sqlparser.ValArg {
+				ok = false
+			}
+		}
+		return ok, nil
+	}, node)
+	if !ok || where == nil {
+		return stmt, ok
+	}
+
+	// The shard key equalities, whose values are bound as the params.
+	keys := make(map[*sqlparser.ComparisonExpr]int)
+	counts := make([]int, len(stmt.shardKeys))
+	for _, filter := range splitAnd(nil, where.Expr) {
+		if i, ok := stmt.shardKeyEqual(filter); ok {
+			keys[skipParenthesis(filter).(*sqlparser.ComparisonExpr)] = i
+			counts[i]++
+		}
+	}
+	bound := len(stmt.shardKeys) > 0
+	for _, count := range counts {
+		bound = bound && count == 1
+	}
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch node := node.(type) {
+		case *sqlparser.ComparisonExpr:
+			if _, ok := keys[node]; !ok && (isShardKey(node.Left) || isShardKey(node.Right)) {
+				bound = false
+			}
+		case *sqlparser.RangeCond:
+			if isShardKey(node.Left) {
+				bound = false
+			}
+		}
+		return true, nil
+	}, where)
+	if bound {
+		stmt.keys = make([]int, len(stmt.shardKeys))
+	}
+
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch node := node.(type) {
+		case *sqlparser.ComparisonExpr:
+			if i, ok := keys[node]; ok && bound {
+				stmt.keys[i] = len(stmt.params)
+				stmt.params = append(stmt.params, node.Right.(*sqlparser.SQLVal))
+				return false, nil
+			}
+			if isShardKey(node.Left) || isShardKey(node.Right) {
+				return false, nil
+			}
+		case *sqlparser.RangeCond:
+			if isShardKey(node.Left) {
+				return false, nil
+			}
+		case *sqlparser.SQLVal:
+			stmt.params = append(stmt.params, node)
+		}
+		return true, nil
+	}, where)
+	return stmt, true
+}
+
+// splitAnd used to split the filters joined by the 'and'.
+func splitAnd(filters []sqlparser.Expr, node sqlparser.Expr) []sqlparser.Expr {
+	switch node := node.(type) {
+	case *sqlparser.AndExpr:
+		filters = splitAnd(filters, node.Left)
+		return splitAnd(filters, node.Right)
+	case *sqlparser.ParenExpr:
+		if node, ok := node.Expr.(*sqlparser.AndExpr); ok {
+			return splitAnd(filters, node)
+		}
+	}
+	return append(filters, node)
+}
+
+// skipParenthesis returns the innermost expression of the parenthesis.
+func skipParenthesis(node sqlparser.Expr) sqlparser.Expr {
+	if node, ok := node.(*sqlparser.ParenExpr); ok {
+		return skipParenthesis(node.Expr)
+	}
+	return node
+}
+
+// normalize returns the statement whose params are replaced by the bind variables, the nil
+// params are kept. The params are restored after the statement is formatted.
+func normalize(node sqlparser.Statement, params []*sqlparser.SQLVal) string {
+	origins := make([]sqlparser.SQLVal, len(params))
+	for i, param := range params {
+		if param == nil {
+			continue
+		}
+		origins[i] = *param
+		param.Type = sqlparser.ValArg
+		param.Val = []byte(paramPrefix + strconv.Itoa(i+1))
+	}
+	text := sqlparser.String(node)
+	for i, param := range params {
+		if param != nil {
+			*param = origins[i]
+		}
+	}
+	return text
+}
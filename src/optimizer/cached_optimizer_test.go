@@ -0,0 +1,192 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package optimizer
+
+import (
+	"testing"
+
+	"config"
+	"router"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+func TestCachedOptimizer(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+	err := route.AddForTest(database, router.MockTableMConfig(), router.MockTableBConfig(), router.MockTableGConfig())
+	assert.Nil(t, err)
+
+	tcases := []struct {
+		query string
+		// builds is the count of the plans built, 0 if the plan is reused.
+		builds int
+		size   int
+	}{
+		{"select id, b from A where a=1 and b='x'", 1, 1},
+		{"select id, b from A where a = 2 and b = 'it''s'", 0, 1},
+		{"select id, b from A where a=0x10 and b=1.5", 0, 1},
+		{"select b, count(*) from A where a>10 group by b order by b limit 5", 1, 2},
+		{"select b, count(*) from A where a>20 group by b order by b limit 5", 0, 2},
+		// The limit is kept.
+		{"select b, count(*) from A where a>20 group by b order by b limit 6", 1, 3},
+		// The shard key routes the query, the plans of the partitions are cached apart.
+		{"select id from A where id=1 and a=2", 1, 4},
+		{"select id from A where id=1 and a in (3, 4)", 1, 5},
+		{"select id from A where id=1 and a in (5, 6)", 0, 5},
+		// The id 1 and 2 are routed to A6, 39 is routed to A1.
+		{"select id from A where id=2 and a in (5, 6)", 0, 5},
+		{"select id from A where id=39 and a in (5, 6)", 1, 6},
+		{"select id from A where id in (1, 2) and a like 'x%'", 1, 7},
+		{"select id from A where (id) between 1 and 10 and a like 'y%'", 1, 8},
+		{"select id from A where (id) between 1 and 10 and a like 'z%'", 0, 8},
+		{"delete from A where a=1", 1, 9},
+		{"delete from A where a=2", 0, 9},
+		{"update A set b=1 where a=1", 1, 10},
+		{"update A set b=1 where a=2", 0, 10},
+		{"update A set b=2 where a=2", 1, 11},
+		{"delete from G where a=1", 1, 12},
+		{"delete from sbtest.G where a=2", 1, 13},
+		{"delete from A where id=1", 1, 14},
+		{"delete from A where id=2", 0, 14},
+		{"delete from A where id=39", 1, 15},
+		{"update A set b=1 where id=39 and a=1", 1, 16},
+		{"update A set b=1 where id=39 and a=2", 0, 16},
+		// Not cached.
+		{"select A.id from A join B on A.id=B.id where A.a=1", 1, 16},
+		{"select id from A where a in (select a from B)", 1, 16},
+		{"insert into A(id) values(1)", 1, 16},
+		{"select id from A where a=':_p1'", 1, 16},
+		{"select id from A where a=1 union select id from B where a=1", 1, 16},
+		// The update of the shard key moves the rows, the plan can't be rebound.
+		{"update A set id=1 where a=1", 1, 16},
+		{"update A set id=1 where a=2", 1, 16},
+	}
+
+	builds := 0
+	newOptimizer := func(query string, node sqlparser.Statement) Optimizer {
+		builds++
+		return NewSimpleOptimizer(log, database, query, node, route)
+	}
+	cache := NewPlanCache(100)
+	for _, tcase := range tcases {
+		node, err := sqlparser.Parse(tcase.query)
+		assert.Nil(t, err)
+		builds = 0
+		plans, err := NewCachedOptimizer(log, database, tcase.query, node, route, cache, "simple", newOptimizer).BuildPlanTree()
+		assert.Nil(t, err, tcase.query)
+		assert.Equal(t, tcase.builds, builds, tcase.query)
+		assert.Equal(t, tcase.size, cache.Len(), tcase.query)
+
+		// The plan is same as the one built without the cache.
+		node, err = sqlparser.Parse(tcase.query)
+		assert.Nil(t, err)
+		want, err := NewSimpleOptimizer(log, database, tcase.query, node, route).BuildPlanTree()
+		assert.Nil(t, err)
+		assert.Equal(t, want.Plans()[0].JSON(), plans.Plans()[0].JSON(), tcase.query)
+	}
+
+	// The plans of the other optimizer are cached apart.
+	query := "select id, b from A where a=3 and b='y'"
+	build := func() {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		builds = 0
+		_, err = NewCachedOptimizer(log, database, query, node, route, cache, "cost", newOptimizer).BuildPlanTree()
+		assert.Nil(t, err)
+	}
+	build()
+	assert.Equal(t, 1, builds)
+	build()
+	assert.Equal(t, 0, builds)
+
+	// The routes are changed.
+	err = route.AddForTest(database, &config.TableConfig{Name: "X", ShardType: "GLOBAL", Partitions: []*config.PartitionConfig{{Table: "X", Backend: "backend1"}}})
+	assert.Nil(t, err)
+	build()
+	assert.Equal(t, 1, builds)
+
+	cache.Clear()
+	assert.Equal(t, 0, cache.Len())
+	build()
+	assert.Equal(t, 1, builds)
+
+	// The error isn't cached, it's reported by the plan built by the query.
+	query = "select id from A where a=1 group by b"
+	build = func() {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		builds = 0
+		_, err = NewCachedOptimizer(log, database, query, node, route, cache, "simple", newOptimizer).BuildPlanTree()
+		assert.NotNil(t, err)
+	}
+	build()
+	build()
+	assert.Equal(t, 2, builds)
+	assert.Equal(t, 1, cache.Len())
+}
+
+func TestCachedOptimizerCacheParams(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+	err := route.AddForTest(database, router.MockTableMConfig(), router.MockTableBConfig(), router.MockTableGConfig())
+	assert.Nil(t, err)
+
+	tcases := []struct {
+		query string
+		text  string
+		// keys are the indexes of the params bound to the shard key.
+		keys []int
+		ok   bool
+	}{
+		{"select id from A", "select id from A", nil, true},
+		{"select id, 'x' from A where a=1 and b in (1, 'x') or c is null limit 1", "select id, 'x' from A where a = :_p1 and b in (:_p2, :_p3) or c is null limit 1", nil, true},
+		{"select id from A where id=1 and a=1 and 2=A.id and A.id in (1, 2) and id between 1 and 2", "select id from A where id = 1 and a = :_p1 and 2 = A.id and A.id in (1, 2) and id between 1 and 2", nil, true},
+		{"select id from A where a=1 or (id=2 and b=3)", "select id from A where a = :_p1 or (id = 2 and b = :_p2)", nil, true},
+		{"select id from A where a=1 and (A.id='x')", "select id from A where a = :_p1 and (A.id = :_p2)", []int{1}, true},
+		{"select id from A where id=1 and id=2", "select id from A where id = 1 and id = 2", nil, true},
+		{"select id from A where 1=id", "select id from A where 1 = id", nil, true},
+		{"delete from sbtest.A where a=1", "delete from sbtest.A where a = :_p1", nil, true},
+		{"delete from A where id=1 and a=2", "delete from A where id = :_p1 and a = :_p2", []int{0}, true},
+		{"update A set a=1 where a=2", "update A set a = 1 where a = :_p1", nil, true},
+		{"update A set a=1 where id=2", "update A set a = 1 where id = :_p1", []int{0}, true},
+		{"select id from A as t where t.a=1", "select id from A as t where t.a = :_p1", nil, true},
+		{"select id from A where a=:a", "", nil, false},
+		{"update A set id=1 where a=2", "", nil, false},
+		{"select id from A, B", "", nil, false},
+		{"select id from (select id from A) as t", "", nil, false},
+		{"select id from X", "", nil, false},
+		{"select id from G where a=1", "", nil, false},
+		{"insert into A(id) values(1)", "", nil, false},
+		{"delete A from A join B on A.id=B.id", "", nil, false},
+		{"update A join B on A.id=B.id set A.a=1", "", nil, false},
+	}
+	for _, tcase := range tcases {
+		node, err := sqlparser.Parse(tcase.query)
+		assert.Nil(t, err)
+		stmt, ok := cacheParams(route, database, node)
+		assert.Equal(t, tcase.ok, ok, tcase.query)
+		if !ok {
+			continue
+		}
+		origin := sqlparser.String(node)
+		assert.Equal(t, tcase.text, normalize(node, stmt.params), tcase.query)
+		assert.Equal(t, tcase.keys, stmt.keys, tcase.query)
+		// Restored.
+		assert.Equal(t, origin, sqlparser.String(node))
+	}
+}
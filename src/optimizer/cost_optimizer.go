@@ -0,0 +1,194 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package optimizer
+
+import (
+	"planner"
+	"router"
+
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+var (
+	_ Optimizer = &CostOptimizer{}
+)
+
+// maxReorderTables is the max count of the joined tables whose orders are enumerated.
+const maxReorderTables = 4
+
+// CostOptimizer is the optimizer who builds the select by the estimated cost with the table
+// statistics. The orders of the inner joined tables are enumerated, the cheapest plan is chosen,
+// so the tables which can be joined in the backends are pushed down together. The join strategies
+// are chosen by the cost too. The other statements are built as the SimpleOptimizer.
+type CostOptimizer struct {
+	log      *xlog.Log
+	database string
+	query    string
+	node     sqlparser.Statement
+	router   *router.Router
+	stats    planner.Statistics
+}
+
+// NewCostOptimizer creates the new cost optimizer.
+func NewCostOptimizer(log *xlog.Log, database string, query string, node sqlparser.Statement, router *router.Router, stats planner.Statistics) *CostOptimizer {
+	return &CostOptimizer{
+		log:      log,
+		database: database,
+		query:    query,
+		node:     node,
+		router:   router,
+		stats:    stats,
+	}
+}
+
+// BuildPlanTree used to build plan trees for the query.
+func (co *CostOptimizer) BuildPlanTree() (*planner.PlanTree, error) {
+	node, ok := co.node.(*sqlparser.Select)
+	if !ok {
+		return NewSimpleOptimizer(co.log, co.database, co.query, co.node, co.router).BuildPlanTree()
+	}
+
+	// The build rewrites the ast, the reordered selects are parsed from the text.
+	text := sqlparser.String(node)
+	best := co.newPlan(node)
+	if err := best.Build(); err != nil {
+		return nil, err
+	}
+	if _, ok := best.Root.(*planner.MergeNode); !ok && len(best.Subqueries()) == 0 {
+		min := best.Cost()
+		for _, order := range co.joinOrders(text) {
+			plan := co.newPlan(order)
+			if err := plan.Build(); err != nil || len(plan.Subqueries()) > 0 {
+				continue
+			}
+			if cost := plan.Cost(); cost < min {
+				best, min = plan, cost
+			}
+		}
+	}
+
+	plans := planner.NewPlanTree()
+	plans.Add(best)
+	return plans, nil
+}
+
+// newPlan creates the select plan with the statistics.
+func (co *CostOptimizer) newPlan(node *sqlparser.Select) *planner.SelectPlan {
+	plan := planner.NewSelectPlan(co.log, co.database, co.query, node, co.router)
+	plan.SetStatistics(co.stats)
+	return plan
+}
+
+// joinOrders returns the selects whose tables are joined in the other orders. The inner joins
+// are rewritten as the table list, the on conditions are moved into the where. Nothing returns
+// if the tables can't be reordered, or the '*' decides the columns by the order.
+func (co *CostOptimizer) joinOrders(text string) []*sqlparser.Select {
+	parse := func() *sqlparser.Select {
+		node, err := sqlparser.Parse(text)
+		if err != nil {
+			return nil
+		}
+		sel, _ := node.(*sqlparser.Select)
+		return sel
+	}
+
+	sel := parse()
+	if sel == nil {
+		return nil
+	}
+	for _, expr := range sel.SelectExprs {
+		if star, ok := expr.(*sqlparser.StarExpr); ok && star.TableName.IsEmpty() {
+			return nil
+		}
+	}
+	tables, _, ok := flattenJoins(sel.From)
+	if !ok || len(tables) < 2 || len(tables) > maxReorderTables {
+		return nil
+	}
+
+	var orders []*sqlparser.Select
+	for _, perm := range permutations(len(tables)) {
+		if sel = parse(); sel == nil {
+			return nil
+		}
+		tables, ons, _ := flattenJoins(sel.From)
+		from := make(sqlparser.TableExprs, 0, len(tables))
+		for _, i := range perm {
+			from = append(from, tables[i])
+		}
+		where := sel.Where
+		sel.From, sel.Where = from, nil
+		for _, on := range ons {
+			sel.AddWhere(on)
+		}
+		if where != nil {
+			sel.AddWhere(where.Expr)
+		}
+		orders = append(orders, sel)
+	}
+	return orders
+}
+
+// flattenJoins returns the tables and the on conditions of the inner joins, false if the
+// table exprs can't be reordered, such as the outer join, straight_join or derived table.
+func flattenJoins(exprs sqlparser.TableExprs) ([]sqlparser.TableExpr, []sqlparser.Expr, bool) {
+	var tables []sqlparser.TableExpr
+	var ons []sqlparser.Expr
+	for _, expr := range exprs {
+		switch expr := expr.(type) {
+		case *sqlparser.AliasedTableExpr:
+			if _, ok := expr.Expr.(sqlparser.TableName); !ok {
+				return nil, nil, false
+			}
+			tables = append(tables, expr)
+		case *sqlparser.JoinTableExpr:
+			if expr.Join != sqlparser.JoinStr {
+				return nil, nil, false
+			}
+			subTables, subOns, ok := flattenJoins(sqlparser.TableExprs{expr.LeftExpr, expr.RightExpr})
+			if !ok {
+				return nil, nil, false
+			}
+			tables = append(tables, subTables...)
+			ons = append(ons, subOns...)
+			if expr.On != nil {
+				ons = append(ons, expr.On)
+			}
+		case *sqlparser.ParenTableExpr:
+			subTables, subOns, ok := flattenJoins(expr.Exprs)
+			if !ok {
+				return nil, nil, false
+			}
+			tables = append(tables, subTables...)
+			ons = append(ons, subOns...)
+		default:
+			return nil, nil, false
+		}
+	}
+	return tables, ons, true
+}
+
+// permutations returns all the orders of the n indexes, the first is the original order.
+func permutations(n int) [][]int {
+	if n == 0 {
+		return [][]int{{}}
+	}
+	var perms [][]int
+	for _, perm := range permutations(n - 1) {
+		for i := len(perm); i >= 0; i-- {
+			p := make([]int, 0, n)
+			p = append(p, perm[:i]...)
+			p = append(p, n-1)
+			p = append(p, perm[i:]...)
+			perms = append(perms, p)
+		}
+	}
+	return perms
+}
@@ -0,0 +1,102 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package optimizer
+
+import (
+	"testing"
+
+	"planner"
+	"router"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+// mockStats is the statistics by the table name.
+type mockStats map[string]*planner.TableStats
+
+func (m mockStats) TableStats(database, table string) *planner.TableStats {
+	return m[table]
+}
+
+func TestCostOptimizer(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+	err := route.AddForTest(database, router.MockTableMConfig(), router.MockTableBConfig(), router.MockTableGConfig())
+	assert.Nil(t, err)
+
+	stats := mockStats{
+		"A": {Rows: 60000, Partitions: 6, Cardinality: map[string]uint64{"id": 60000}},
+		"B": {Rows: 20000, Partitions: 2, Cardinality: map[string]uint64{"id": 20000}},
+	}
+	tcases := []struct {
+		query  string
+		simple int
+		cost   int
+	}{
+		// A and C are joined by the shard key in the backends.
+		{"select A.id, B.a, C.a from A join B on A.id=B.id join A as C on C.id=A.id", 14, 8},
+		{"select A.id, B.a, C.a from A, B, A as C where A.id=B.id and C.id=A.id and C.a=1", 14, 8},
+		// The outer join keeps the order.
+		{"select A.id from A join B on A.id=B.id left join A as C on C.id=A.id", 14, 14},
+		// Pushed down.
+		{"select A.id from A join A as C on C.id=A.id", 6, 6},
+		{"insert into A(id) values(1)", 1, 1},
+	}
+	querys := func(plans *planner.PlanTree) int {
+		switch plan := plans.Plans()[0].(type) {
+		case *planner.SelectPlan:
+			return len(plan.Root.GetQuery())
+		case *planner.InsertPlan:
+			return len(plan.Querys)
+		}
+		return 0
+	}
+	for _, tcase := range tcases {
+		node, err := sqlparser.Parse(tcase.query)
+		assert.Nil(t, err)
+		simple, err := NewSimpleOptimizer(log, database, tcase.query, node, route).BuildPlanTree()
+		assert.Nil(t, err)
+		assert.Equal(t, tcase.simple, querys(simple), tcase.query)
+
+		node, err = sqlparser.Parse(tcase.query)
+		assert.Nil(t, err)
+		cost, err := NewCostOptimizer(log, database, tcase.query, node, route, stats).BuildPlanTree()
+		assert.Nil(t, err)
+		assert.Equal(t, tcase.cost, querys(cost), tcase.query)
+	}
+}
+
+func TestCostOptimizerJoinOrders(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	co := NewCostOptimizer(log, "sbtest", "", nil, nil, mockStats{})
+	tcases := []struct {
+		query  string
+		orders int
+	}{
+		{"select A.id from A, B", 2},
+		{"select A.id from A join (B, C) on A.id=B.id join D where A.a=1", 24},
+		{"select * from A, B", 0},
+		{"select A.id from A left join B on A.id=B.id", 0},
+		{"select A.id from A straight_join B", 0},
+		{"select A.id from A, (select id from B) as t", 0},
+		{"select A.id from A, B, C, D, E", 0},
+	}
+	for _, tcase := range tcases {
+		assert.Equal(t, tcase.orders, len(co.joinOrders(tcase.query)), tcase.query)
+	}
+
+	orders := co.joinOrders("select A.id from A join B on A.id=B.id where A.a=1 or B.a=1")
+	assert.Equal(t, "select A.id from A, B where A.id = B.id and (A.a = 1 or B.a = 1)", sqlparser.String(orders[0]))
+	assert.Equal(t, "select A.id from B, A where A.id = B.id and (A.a = 1 or B.a = 1)", sqlparser.String(orders[1]))
+}
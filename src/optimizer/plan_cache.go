@@ -0,0 +1,154 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package optimizer
+
+import (
+	"container/list"
+	"strconv"
+	"strings"
+	"sync"
+
+	"planner"
+	"xcontext"
+)
+
+// paramPrefix is the prefix of the bind variables which the literals are normalized to.
+const paramPrefix = ":_p"
+
+// cacheEntry is the cached plan of the normalized query.
+type cacheEntry struct {
+	key string
+	// plan is built by the normalized query, nil if the plans of the query can't be cached.
+	plan planner.Plan
+	// querys are the querys of the plan, the parameters are bound into them.
+	querys []paramQuery
+}
+
+// paramQuery is the query split by the bind variables of the parameters.
+type paramQuery struct {
+	tuple xcontext.QueryTuple
+	// parts are the texts around the parameters, one more than the params.
+	parts []string
+	// params are the indexes of the parameters.
+	params []int
+}
+
+// newParamQuery used to split the query by the bind variables, false if the index of a
+// bind variable is out of the parameters.
+func newParamQuery(tuple xcontext.QueryTuple, count int) (paramQuery, bool) {
+	query := tuple.Query
+	pq := paramQuery{tuple: tuple}
+	for {
+		i := strings.Index(query, paramPrefix)
+		if i < 0 {
+			break
+		}
+		j := i + len(paramPrefix)
+		for j < len(query) && query[j] >= '0' && query[j] <= '9' {
+			j++
+		}
+		idx, err := strconv.Atoi(query[i+len(paramPrefix) : j])
+		if err != nil || idx < 1 || idx > count {
+			return pq, false
+		}
+		pq.parts = append(pq.parts, query[:i])
+		pq.params = append(pq.params, idx-1)
+		query = query[j:]
+	}
+	pq.parts = append(pq.parts, query)
+	return pq, true
+}
+
+// bind returns the query tuple whose bind variables are substituted by the params.
+func (pq *paramQuery) bind(params []string) xcontext.QueryTuple {
+	if len(pq.params) == 0 {
+		return pq.tuple
+	}
+	var buf strings.Builder
+	for i, idx := range pq.params {
+		buf.WriteString(pq.parts[i])
+		buf.WriteString(params[idx])
+	}
+	buf.WriteString(pq.parts[len(pq.params)])
+	tuple := pq.tuple
+	tuple.Query = buf.String()
+	return tuple
+}
+
+// bind returns the plan of the query whose parameters are bound to the params.
+func (e *cacheEntry) bind(query string, params []string) planner.Plan {
+	querys := make([]xcontext.QueryTuple, len(e.querys))
+	for i := range e.querys {
+		querys[i] = e.querys[i].bind(params)
+	}
+	return planner.Rebind(e.plan, query, querys)
+}
+
+// PlanCache is the bounded LRU cache of the plans, keyed by the normalized query and the
+// version of the router. The plans are built by the normalized querys whose literals are
+// replaced by the bind variables, the literals are bound into the querys when it's used.
+type PlanCache struct {
+	mu      sync.Mutex
+	size    int
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+// NewPlanCache creates the new plan cache which holds size plans at most.
+func NewPlanCache(size int) *PlanCache {
+	return &PlanCache{
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get returns the entry of the key, and moves it to the front.
+func (c *PlanCache) get(key string) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*cacheEntry), true
+}
+
+// put used to add the entry, the least recently used entries are evicted if the cache is full.
+func (c *PlanCache) put(entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[entry.key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		elem := c.lru.Back()
+		c.lru.Remove(elem)
+		delete(c.entries, elem.Value.(*cacheEntry).key)
+	}
+}
+
+// Len returns the count of the cached plans.
+func (c *PlanCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// Clear used to remove all the cached plans, such as the tables are changed by the DDL.
+func (c *PlanCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+}
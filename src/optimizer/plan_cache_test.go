@@ -0,0 +1,72 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package optimizer
+
+import (
+	"fmt"
+	"testing"
+
+	"xcontext"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanCache(t *testing.T) {
+	cache := NewPlanCache(2)
+	for i := 0; i < 3; i++ {
+		cache.put(&cacheEntry{key: fmt.Sprintf("k%d", i)})
+		if i == 1 {
+			// k0 is used, k1 is evicted.
+			_, ok := cache.get("k0")
+			assert.True(t, ok)
+		}
+	}
+	assert.Equal(t, 2, cache.Len())
+	_, ok := cache.get("k1")
+	assert.False(t, ok)
+	_, ok = cache.get("k0")
+	assert.True(t, ok)
+	_, ok = cache.get("k2")
+	assert.True(t, ok)
+
+	// Replaced.
+	cache.put(&cacheEntry{key: "k2", querys: []paramQuery{{}}})
+	entry, ok := cache.get("k2")
+	assert.True(t, ok)
+	assert.Equal(t, 1, len(entry.querys))
+	assert.Equal(t, 2, cache.Len())
+
+	cache.Clear()
+	assert.Equal(t, 0, cache.Len())
+	_, ok = cache.get("k0")
+	assert.False(t, ok)
+}
+
+func TestPlanCacheParamQuery(t *testing.T) {
+	tcases := []struct {
+		query string
+		ok    bool
+		want  string
+	}{
+		{"select a from t", true, "select a from t"},
+		{"select a from t where a = :_p1 and b in (:_p2, :_p1)", true, "select a from t where a = 1 and b in ('x', 1)"},
+		{"select a from t where a = :_p3", false, ""},
+		{"select a from t where a = :_p", false, ""},
+	}
+	for _, tcase := range tcases {
+		tuple := xcontext.QueryTuple{Query: tcase.query, Backend: "backend1", Range: "[0-8)"}
+		pq, ok := newParamQuery(tuple, 2)
+		assert.Equal(t, tcase.ok, ok, tcase.query)
+		if !ok {
+			continue
+		}
+		got := pq.bind([]string{"1", "'x'"})
+		assert.Equal(t, xcontext.QueryTuple{Query: tcase.want, Backend: "backend1", Range: "[0-8)"}, got)
+	}
+}
@@ -0,0 +1,191 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package optimizer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"planner"
+	"router"
+
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+var (
+	_ planner.Statistics = &Statistics{}
+)
+
+// Fetcher used to execute the query on the backend.
+type Fetcher func(backend string, query string) (*sqltypes.Result, error)
+
+// statsEntry is the cached statistics of a table, nil stats means the table is unknown.
+// The loading is closed when the running load is done, nil if the entry isn't loading.
+type statsEntry struct {
+	stats   *planner.TableStats
+	expire  time.Time
+	loading chan struct{}
+}
+
+// Statistics is the cache of the table statistics collected from the backends.
+// The row counts and the index cardinality are read from the information_schema
+// of the partitions, and reloaded after the ttl.
+type Statistics struct {
+	log    *xlog.Log
+	router *router.Router
+	fetch  Fetcher
+	ttl    time.Duration
+	mu     sync.RWMutex
+	tables map[string]*statsEntry
+}
+
+// NewStatistics creates the new statistics cache.
+func NewStatistics(log *xlog.Log, router *router.Router, fetch Fetcher, ttl time.Duration) *Statistics {
+	return &Statistics{
+		log:    log,
+		router: router,
+		fetch:  fetch,
+		ttl:    ttl,
+		tables: make(map[string]*statsEntry),
+	}
+}
+
+// TableStats returns the statistics of the table. The missing stats are loaded from the
+// backends once, the concurrent callers wait for the same load. The expired stats are
+// served until they are reloaded in the background. If the loading fails, nil is cached
+// until the ttl.
+func (s *Statistics) TableStats(database, table string) *planner.TableStats {
+	key := database + "." + table
+	s.mu.RLock()
+	entry, ok := s.tables[key]
+	if ok && time.Now().Before(entry.expire) {
+		s.mu.RUnlock()
+		return entry.stats
+	}
+	s.mu.RUnlock()
+
+	s.mu.Lock()
+	entry, ok = s.tables[key]
+	switch {
+	case !ok:
+		entry = &statsEntry{loading: make(chan struct{})}
+		s.tables[key] = entry
+		s.mu.Unlock()
+		s.reload(database, table, entry)
+	case entry.expire.IsZero():
+		// The first load is running.
+		loading := entry.loading
+		s.mu.Unlock()
+		<-loading
+	case entry.loading == nil && !time.Now().Before(entry.expire):
+		entry.loading = make(chan struct{})
+		stats := entry.stats
+		s.mu.Unlock()
+		go s.reload(database, table, entry)
+		return stats
+	default:
+		s.mu.Unlock()
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return entry.stats
+}
+
+// reload used to load the statistics of the table into the entry and wake up the waiters.
+func (s *Statistics) reload(database, table string, entry *statsEntry) {
+	stats, err := s.load(database, table)
+	if err != nil {
+		s.log.Warning("optimizer.statistics.load[%s.%s].error:%v", database, table, err)
+	}
+	s.mu.Lock()
+	entry.stats = stats
+	entry.expire = time.Now().Add(s.ttl)
+	close(entry.loading)
+	entry.loading = nil
+	s.mu.Unlock()
+}
+
+// load used to collect the statistics of the table from the partitions. The GLOBAL table
+// has the same rows on every backend, only the first one is read. The cardinality of the
+// shard key is summed up, the others take the max of the partitions.
+func (s *Statistics) load(database, table string) (*planner.TableStats, error) {
+	conf, err := s.router.TableConfig(database, table)
+	if err != nil {
+		return nil, err
+	}
+	segments, err := s.router.Lookup(database, table, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if conf.ShardType == "GLOBAL" && len(segments) > 1 {
+		segments = segments[:1]
+	}
+
+	var backends []string
+	partitions := make(map[string][]string)
+	for _, segment := range segments {
+		if _, ok := partitions[segment.Backend]; !ok {
+			backends = append(backends, segment.Backend)
+		}
+		partitions[segment.Backend] = append(partitions[segment.Backend], quote(segment.Table))
+	}
+
+	stats := &planner.TableStats{
+		Partitions:  len(segments),
+		Cardinality: make(map[string]uint64),
+	}
+	shardKey := strings.ToLower(conf.ShardKey)
+	for _, backend := range backends {
+		in := strings.Join(partitions[backend], ", ")
+		query := fmt.Sprintf("SELECT TABLE_ROWS FROM information_schema.TABLES WHERE TABLE_SCHEMA = %s AND TABLE_NAME IN (%s)", quote(database), in)
+		qr, err := s.fetch(backend, query)
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range qr.Rows {
+			stats.Rows += toUint(row[0])
+		}
+
+		query = fmt.Sprintf("SELECT COLUMN_NAME, CARDINALITY FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = %s AND TABLE_NAME IN (%s) AND SEQ_IN_INDEX = 1", quote(database), in)
+		if qr, err = s.fetch(backend, query); err != nil {
+			return nil, err
+		}
+		for _, row := range qr.Rows {
+			column := strings.ToLower(row[0].ToString())
+			card := toUint(row[1])
+			switch {
+			case column == shardKey:
+				stats.Cardinality[column] += card
+			case card > stats.Cardinality[column]:
+				stats.Cardinality[column] = card
+			}
+		}
+	}
+	return stats, nil
+}
+
+// quote returns the quoted string literal.
+func quote(s string) string {
+	return sqlparser.String(sqlparser.NewStrVal([]byte(s)))
+}
+
+// toUint returns the uint value, 0 if the value is NULL or invalid.
+func toUint(v sqltypes.Value) uint64 {
+	n, err := strconv.ParseUint(v.ToString(), 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,138 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package optimizer
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"router"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+// mockFetcher returns the rows of the backend by the query prefix, and counts the querys.
+type mockFetcher struct {
+	mu     sync.Mutex
+	rows   map[string][][]sqltypes.Value
+	querys []string
+}
+
+func (m *mockFetcher) fetch(backend string, query string) (*sqltypes.Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.querys = append(m.querys, backend+":"+query)
+	for prefix, rows := range m.rows {
+		if strings.HasPrefix(backend+":"+query, prefix) {
+			return &sqltypes.Result{Rows: rows}, nil
+		}
+	}
+	return nil, errors.New("mock.fetch.error")
+}
+
+func TestStatistics(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+	err := route.AddForTest(database, router.MockTableBConfig(), router.MockTableGConfig())
+	assert.Nil(t, err)
+
+	fetcher := &mockFetcher{rows: map[string][][]sqltypes.Value{
+		"backend1:SELECT TABLE_ROWS FROM information_schema.TABLES WHERE TABLE_SCHEMA = 'sbtest' AND TABLE_NAME IN ('B0')": {
+			{sqltypes.NewUint64(100)},
+		},
+		"backend2:SELECT TABLE_ROWS FROM information_schema.TABLES WHERE TABLE_SCHEMA = 'sbtest' AND TABLE_NAME IN ('B1')": {
+			{sqltypes.NewUint64(300)},
+		},
+		"backend1:SELECT TABLE_ROWS FROM information_schema.TABLES WHERE TABLE_SCHEMA = 'sbtest' AND TABLE_NAME IN ('G')": {
+			{sqltypes.NewUint64(50)},
+		},
+		"backend1:SELECT COLUMN_NAME, CARDINALITY FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = 'sbtest' AND TABLE_NAME IN ('B0')": {
+			{sqltypes.NewVarChar("ID"), sqltypes.NewUint64(100)},
+			{sqltypes.NewVarChar("name"), sqltypes.NewUint64(20)},
+		},
+		"backend2:SELECT COLUMN_NAME, CARDINALITY FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = 'sbtest' AND TABLE_NAME IN ('B1')": {
+			{sqltypes.NewVarChar("id"), sqltypes.NewUint64(300)},
+			{sqltypes.NewVarChar("name"), sqltypes.NewUint64(30)},
+			{sqltypes.NewVarChar("str"), sqltypes.NULL},
+		},
+		"backend1:SELECT COLUMN_NAME, CARDINALITY FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = 'sbtest' AND TABLE_NAME IN ('G')": {
+			{sqltypes.NewVarChar("id"), sqltypes.NewUint64(50)},
+		},
+	}}
+
+	stats := NewStatistics(log, route, fetcher.fetch, time.Hour)
+	// The shard key is summed up, the others take the max.
+	b := stats.TableStats(database, "B")
+	assert.Equal(t, uint64(400), b.Rows)
+	assert.Equal(t, 2, b.Partitions)
+	assert.Equal(t, map[string]uint64{"id": 400, "name": 30}, b.Cardinality)
+	assert.Equal(t, 4, len(fetcher.querys))
+
+	// Cached.
+	assert.Equal(t, b, stats.TableStats(database, "B"))
+	assert.Equal(t, 4, len(fetcher.querys))
+
+	// The global table is read from one backend.
+	g := stats.TableStats(database, "G")
+	assert.Equal(t, uint64(50), g.Rows)
+	assert.Equal(t, 1, g.Partitions)
+	assert.Equal(t, map[string]uint64{"id": 50}, g.Cardinality)
+	assert.Equal(t, 6, len(fetcher.querys))
+
+	// Unknown table.
+	assert.Nil(t, stats.TableStats(database, "X"))
+	assert.Equal(t, 6, len(fetcher.querys))
+
+	// The concurrent callers share one load.
+	stats = NewStatistics(log, route, fetcher.fetch, time.Hour)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NotNil(t, stats.TableStats(database, "B"))
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 10, len(fetcher.querys))
+
+	// The expired stats are served and reloaded in the background.
+	stats = NewStatistics(log, route, fetcher.fetch, 0)
+	b = stats.TableStats(database, "B")
+	assert.NotNil(t, b)
+	assert.Equal(t, 14, len(fetcher.querys))
+	fetcher.rows = nil
+	assert.Equal(t, b, stats.TableStats(database, "B"))
+	waitStats(stats, database+".B")
+	assert.Equal(t, 15, len(fetcher.querys))
+
+	// The error caches nil.
+	stats = NewStatistics(log, route, fetcher.fetch, time.Hour)
+	assert.Nil(t, stats.TableStats(database, "B"))
+	assert.Nil(t, stats.TableStats(database, "B"))
+	assert.Equal(t, 16, len(fetcher.querys))
+}
+
+// waitStats used to wait for the running load of the table.
+func waitStats(s *Statistics, key string) {
+	s.mu.RLock()
+	loading := s.tables[key].loading
+	s.mu.RUnlock()
+	if loading != nil {
+		<-loading
+	}
+}
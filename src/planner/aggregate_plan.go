@@ -10,6 +10,7 @@ package planner
 
 import (
 	"encoding/json"
+	"evaluation"
 	"fmt"
 	"strings"
 
@@ -46,6 +47,66 @@ const (
 
 	// AggrTypeGroupBy enum.
 	AggrTypeGroupBy AggrType = "GROUP BY"
+
+	// AggrTypeCountDistinct enum.
+	AggrTypeCountDistinct AggrType = "COUNT DISTINCT"
+
+	// AggrTypeSumDistinct enum.
+	AggrTypeSumDistinct AggrType = "SUM DISTINCT"
+
+	// AggrTypeAvgDistinct enum.
+	AggrTypeAvgDistinct AggrType = "AVG DISTINCT"
+
+	// AggrTypeGroupConcat enum.
+	AggrTypeGroupConcat AggrType = "GROUP_CONCAT"
+
+	// AggrTypeGroupConcatDistinct enum.
+	AggrTypeGroupConcatDistinct AggrType = "GROUP_CONCAT DISTINCT"
+
+	// AggrTypeBitAnd enum.
+	AggrTypeBitAnd AggrType = "BIT_AND"
+
+	// AggrTypeBitOr enum.
+	AggrTypeBitOr AggrType = "BIT_OR"
+
+	// AggrTypeBitXor enum.
+	AggrTypeBitXor AggrType = "BIT_XOR"
+
+	// AggrTypeStddevPop enum.
+	AggrTypeStddevPop AggrType = "STDDEV_POP"
+
+	// AggrTypeStddevSamp enum.
+	AggrTypeStddevSamp AggrType = "STDDEV_SAMP"
+
+	// AggrTypeVarPop enum.
+	AggrTypeVarPop AggrType = "VAR_POP"
+
+	// AggrTypeVarSamp enum.
+	AggrTypeVarSamp AggrType = "VAR_SAMP"
+
+	// AggrTypeExpr enum, the expression computed by the aggregated values.
+	AggrTypeExpr AggrType = "EXPR"
+)
+
+var (
+	// distinctAggrTypes is the aggregate functions merged by the distinct values.
+	distinctAggrTypes = map[string]AggrType{
+		"count":        AggrTypeCountDistinct,
+		"sum":          AggrTypeSumDistinct,
+		"avg":          AggrTypeAvgDistinct,
+		"group_concat": AggrTypeGroupConcatDistinct,
+	}
+
+	// statAggrTypes is the statistical aggregate functions, decomposed to sum, count and sum of squares.
+	statAggrTypes = map[string]AggrType{
+		"std":         AggrTypeStddevPop,
+		"stddev":      AggrTypeStddevPop,
+		"stddev_pop":  AggrTypeStddevPop,
+		"stddev_samp": AggrTypeStddevSamp,
+		"variance":    AggrTypeVarPop,
+		"var_pop":     AggrTypeVarPop,
+		"var_samp":    AggrTypeVarSamp,
+	}
 )
 
 // Aggregator tuple.
@@ -53,6 +114,15 @@ type Aggregator struct {
 	Field string
 	Index int
 	Type  AggrType
+	// Separator of the group_concat.
+	Separator string `json:",omitempty"`
+	// eval is the evaluation of the expression aggregator.
+	eval evaluation.Evaluation
+}
+
+// Evaluation returns the evaluation of the expression aggregator.
+func (aggr Aggregator) Evaluation() evaluation.Evaluation {
+	return aggr.eval
 }
 
 // AggregatePlan represents order-by plan.
@@ -65,6 +135,21 @@ type AggregatePlan struct {
 	normalAggrs []Aggregator
 	groupAggrs  []Aggregator
 
+	// distinct is the argument of the distinct aggregate functions,
+	// the shards group by it and the distinct values are merged.
+	distinct sqlparser.Expr
+
+	// havings is the having filters contain the aggregate functions, filtered after aggregating.
+	havings     []sqlparser.Expr
+	havingEvals []evaluation.Evaluation
+
+	// indexes is the index of every tuple in the aggregated row.
+	indexes []int
+	// refs maps the aggregate functions and columns in the expressions to the tuples.
+	refs map[string]int
+	// hidden is the number of the tuples in the select list, the others are hidden.
+	hidden int
+
 	// type
 	typ PlanType
 }
@@ -75,14 +160,104 @@ func NewAggregatePlan(log *xlog.Log, exprs []sqlparser.SelectExpr, tuples, group
 		log:       log,
 		tuples:    tuples,
 		groups:    groups,
-		rewritten: exprs,
+		rewritten: append(sqlparser.SelectExprs{}, exprs...),
+		hidden:    len(tuples),
 		typ:       PlanTypeAggregate,
 	}
 }
 
+// expand used to add the aggregate functions and columns referred by the expressions
+// and the havings as the hidden tuples, if they aren't in the select list.
+// For example:
+// select round(avg(a)) as x, b from t group by b having sum(c) > 10
+// the hidden tuples: avg(a) as tmpa_0, sum(c) as tmpa_1.
+func (p *AggregatePlan) expand() error {
+	p.tuples = append([]selectTuple{}, p.tuples[:p.hidden]...)
+	p.refs = make(map[string]int)
+	for i := 0; i < p.hidden; i++ {
+		if p.tuples[i].aggrExpr {
+			if err := p.expandExpr(p.tuples[i].expr.(*sqlparser.AliasedExpr).Expr, false); err != nil {
+				return err
+			}
+		}
+	}
+	for _, having := range p.havings {
+		if err := p.expandExpr(having, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandExpr used to refer the aggregate functions and columns of the expr to the tuples,
+// the aliases of the select list are only referred by the havings.
+func (p *AggregatePlan) expandExpr(expr sqlparser.Expr, having bool) error {
+	return sqlparser.Walk(func(node sqlparser.SQLNode) (kontinue bool, err error) {
+		switch node := node.(type) {
+		case *sqlparser.FuncExpr, *sqlparser.GroupConcatExpr:
+			if fn, ok := node.(*sqlparser.FuncExpr); ok && !fn.IsAggregate() {
+				return true, nil
+			}
+			key := sqlparser.String(node)
+			if _, ok := p.refs[key]; ok {
+				return false, nil
+			}
+			for i, tuple := range p.tuples {
+				if tuple.aggrFuc != "" && sqlparser.String(tuple.expr.(*sqlparser.AliasedExpr).Expr) == key {
+					p.refs[key] = i
+					return false, nil
+				}
+			}
+			field := fmt.Sprintf("tmpa_%d", len(p.tuples)-p.hidden)
+			funcName, aggrField, distinct, err := aggregateInfo(node.(sqlparser.Expr), field)
+			if err != nil {
+				return false, err
+			}
+			p.addTuple(key, selectTuple{
+				expr:      &sqlparser.AliasedExpr{Expr: node.(sqlparser.Expr), As: sqlparser.NewColIdent(field)},
+				field:     field,
+				aggrFuc:   funcName,
+				aggrField: aggrField,
+				distinct:  distinct,
+			})
+			return false, nil
+		case *sqlparser.ColName:
+			key := sqlparser.String(node)
+			if _, ok := p.refs[key]; ok {
+				return false, nil
+			}
+			table := node.Qualifier.Name.String()
+			for i, tuple := range p.tuples[:p.hidden] {
+				if (tuple.aggrExpr && !having) || !strings.EqualFold(tuple.field, node.Name.String()) {
+					continue
+				}
+				if table == "" || (len(tuple.referTables) == 1 && tuple.referTables[0] == table) {
+					p.refs[key] = i
+					return false, nil
+				}
+			}
+			p.addTuple(key, selectTuple{
+				expr:  &sqlparser.AliasedExpr{Expr: node},
+				field: node.Name.String(),
+			})
+			return false, nil
+		}
+		return true, nil
+	}, expr)
+}
+
+// addTuple used to add the hidden tuple referred by the key.
+func (p *AggregatePlan) addTuple(key string, tuple selectTuple) {
+	p.refs[key] = len(p.tuples)
+	p.tuples = append(p.tuples, tuple)
+	p.rewritten = append(p.rewritten, tuple.expr)
+}
+
 // analyze used to check the aggregator is at the support level.
 // Supports:
-// SUM/COUNT/MIN/MAX/AVG/GROUPBY
+// SUM/COUNT/MIN/MAX/AVG/GROUP_CONCAT/BIT_AND/BIT_OR/BIT_XOR/STD/VARIANCE/GROUPBY
+// COUNT/SUM/AVG/GROUP_CONCAT with DISTINCT, all the distinct functions must have the same argument.
+// The expressions of the aggregate functions are computed after aggregating.
 // Notes:
 // group by fields must be in the select list, for example:
 // select count(a), a from t group by a --[OK]
@@ -94,13 +269,30 @@ func (p *AggregatePlan) analyze() error {
 	// aggregators.
 	k := 0
 	for _, tuple := range tuples {
-		if tuple.distinct {
+		p.indexes = append(p.indexes, k)
+		aggrType := strings.ToLower(tuple.aggrFuc)
+		if typ, ok := distinctAggrTypes[aggrType]; ok && tuple.distinct {
+			if err := p.pushDistinct(&tuple, k); err != nil {
+				return err
+			}
+			aggr := Aggregator{Field: tuple.field, Index: k, Type: typ}
+			if typ == AggrTypeGroupConcatDistinct {
+				aggr.Separator = groupConcatSeparator(&tuple)
+			}
+			p.normalAggrs = append(p.normalAggrs, aggr)
+			k++
+			continue
+		}
+		if tuple.distinct && aggrType != "min" && aggrType != "max" {
 			return errors.Errorf("unsupported: distinct.in.function:%+v", tuple.aggrFuc)
 		}
 
-		aggrType := strings.ToLower(tuple.aggrFuc)
 		switch aggrType {
 		case "":
+			// the expression of the aggregate functions.
+			if tuple.aggrExpr {
+				break
+			}
 			// non-func
 			if tuple.field == "*" {
 				return errors.Errorf("unsupported: exists.aggregate.and.'*'.select.exprs")
@@ -118,15 +310,25 @@ func (p *AggregatePlan) analyze() error {
 			p.normalAggrs = append(p.normalAggrs, Aggregator{Field: tuple.field, Index: k, Type: AggrTypeAvg})
 			p.normalAggrs = append(p.normalAggrs, Aggregator{Field: fmt.Sprintf("sum(%s)", tuple.aggrField), Index: k, Type: AggrTypeSum})
 			p.normalAggrs = append(p.normalAggrs, Aggregator{Field: fmt.Sprintf("count(%s)", tuple.aggrField), Index: k + 1, Type: AggrTypeCount})
-
-			avgs := decomposeAvg(&tuple)
-			p.rewritten = append(p.rewritten, &sqlparser.AliasedExpr{})
-			copy(p.rewritten[(k+2):], p.rewritten[k+1:])
-			p.rewritten[k] = avgs[0]
-			p.rewritten[(k + 1)] = avgs[1]
+			p.replaceExpr(k, decomposeAvg(&tuple))
 			k++
+		case "group_concat":
+			p.normalAggrs = append(p.normalAggrs, Aggregator{Field: tuple.field, Index: k, Type: AggrTypeGroupConcat, Separator: groupConcatSeparator(&tuple)})
+		case "bit_and":
+			p.normalAggrs = append(p.normalAggrs, Aggregator{Field: tuple.field, Index: k, Type: AggrTypeBitAnd})
+		case "bit_or":
+			p.normalAggrs = append(p.normalAggrs, Aggregator{Field: tuple.field, Index: k, Type: AggrTypeBitOr})
+		case "bit_xor":
+			p.normalAggrs = append(p.normalAggrs, Aggregator{Field: tuple.field, Index: k, Type: AggrTypeBitXor})
 		default:
-			return errors.Errorf("unsupported: function:%+v", tuple.aggrFuc)
+			typ, ok := statAggrTypes[aggrType]
+			if !ok {
+				return errors.Errorf("unsupported: function:%+v", tuple.aggrFuc)
+			}
+			// The var_pop, count and avg are merged together by the stat aggregator.
+			p.normalAggrs = append(p.normalAggrs, Aggregator{Field: tuple.field, Index: k, Type: typ})
+			p.replaceExpr(k, decomposeStat(&tuple))
+			k += 2
 		}
 		k++
 	}
@@ -149,9 +351,93 @@ func (p *AggregatePlan) analyze() error {
 	return nil
 }
 
+// replaceExpr used to replace the k-th rewritten expr with the decomposed exprs.
+func (p *AggregatePlan) replaceExpr(k int, exprs []*sqlparser.AliasedExpr) {
+	rewritten := append(sqlparser.SelectExprs{}, p.rewritten[:k]...)
+	for _, expr := range exprs {
+		rewritten = append(rewritten, expr)
+	}
+	p.rewritten = append(rewritten, p.rewritten[k+1:]...)
+}
+
+// pushDistinct used to rewrite the distinct aggregate function to its argument,
+// the shards return the distinct values of the argument in every group.
+func (p *AggregatePlan) pushDistinct(tuple *selectTuple, k int) error {
+	args := aggregateArgs(tuple)
+	if len(args) != 1 {
+		return errors.Errorf("unsupported: invalid.use.of.group.function[%s]", tuple.aggrFuc)
+	}
+	arg, ok := args[0].(*sqlparser.AliasedExpr)
+	if !ok {
+		return errors.Errorf("unsupported: syntax.error.at.'%s'", tuple.field)
+	}
+	if p.distinct != nil && sqlparser.String(p.distinct) != sqlparser.String(arg.Expr) {
+		return errors.Errorf("unsupported: distinct.functions.with.different.arguments")
+	}
+	p.distinct = arg.Expr
+	p.replaceExpr(k, []*sqlparser.AliasedExpr{{Expr: arg.Expr, As: sqlparser.NewColIdent(tuple.field)}})
+	return nil
+}
+
+// compile used to build the evaluations of the expressions and the havings,
+// the aggregate functions and columns are read from the aggregated row.
+func (p *AggregatePlan) compile() error {
+	resolve := func(expr sqlparser.Expr) (int, bool) {
+		if i, ok := p.refs[sqlparser.String(expr)]; ok {
+			return p.indexes[i], true
+		}
+		return -1, false
+	}
+	for i, tuple := range p.tuples {
+		if !tuple.aggrExpr {
+			continue
+		}
+		eval, err := evaluation.Build(tuple.expr.(*sqlparser.AliasedExpr).Expr, resolve)
+		if err != nil {
+			return err
+		}
+		p.normalAggrs = append(p.normalAggrs, Aggregator{Field: tuple.field, Index: p.indexes[i], Type: AggrTypeExpr, eval: eval})
+	}
+	for _, having := range p.havings {
+		eval, err := evaluation.Build(having, resolve)
+		if err != nil {
+			return err
+		}
+		p.havingEvals = append(p.havingEvals, eval)
+	}
+	return nil
+}
+
+// aggregated returns true if the expr contains the aggregate functions or refers to
+// the aggregated fields of the select list, it must be computed after aggregating.
+func (p *AggregatePlan) aggregated(expr sqlparser.Expr) bool {
+	if hasAggregate(expr) {
+		return true
+	}
+	has := false
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (kontinue bool, err error) {
+		if col, ok := node.(*sqlparser.ColName); ok && col.Qualifier.IsEmpty() {
+			for _, tuple := range p.tuples[:p.hidden] {
+				if (tuple.aggrFuc != "" || tuple.aggrExpr) && strings.EqualFold(tuple.field, col.Name.String()) {
+					has = true
+					return false, errors.New("dummy")
+				}
+			}
+		}
+		return true, nil
+	}, expr)
+	return has
+}
+
 // Build used to build distributed querys.
 func (p *AggregatePlan) Build() error {
-	return p.analyze()
+	if err := p.expand(); err != nil {
+		return err
+	}
+	if err := p.analyze(); err != nil {
+		return err
+	}
+	return p.compile()
 }
 
 // Type returns the type of the plan.
@@ -163,11 +449,15 @@ func (p *AggregatePlan) Type() PlanType {
 func (p *AggregatePlan) JSON() string {
 	type aggrs struct {
 		Aggrs     []Aggregator
+		Havings   []string `json:",omitempty"`
 		ReWritten string
 	}
 	a := &aggrs{}
 	a.Aggrs = append(a.Aggrs, p.normalAggrs...)
 	a.Aggrs = append(a.Aggrs, p.groupAggrs...)
+	for _, having := range p.havings {
+		a.Havings = append(a.Havings, sqlparser.String(having))
+	}
 
 	buf := sqlparser.NewTrackedBuffer(nil)
 	buf.Myprintf("%v", p.rewritten)
@@ -200,6 +490,24 @@ func (p *AggregatePlan) ReWritten() sqlparser.SelectExprs {
 	return p.rewritten
 }
 
+// Distinct returns the argument of the distinct aggregate functions, nil if there's none.
+func (p *AggregatePlan) Distinct() sqlparser.Expr {
+	return p.distinct
+}
+
+// Havings returns the evaluations of the having filters.
+func (p *AggregatePlan) Havings() []evaluation.Evaluation {
+	return p.havingEvals
+}
+
+// Hidden returns the index of the first hidden column in the aggregated row, -1 if there's none.
+func (p *AggregatePlan) Hidden() int {
+	if p.hidden == len(p.tuples) {
+		return -1
+	}
+	return p.indexes[p.hidden]
+}
+
 // Empty returns the aggregator number more than zero.
 func (p *AggregatePlan) Empty() bool {
 	return (len(p.normalAggrs) == 0 && len(p.groupAggrs) == 0)
@@ -330,12 +330,14 @@ func TestAggregatePlanUnsupported(t *testing.T) {
 	querys := []string{
 		"select sum(a)  from A group by d",
 		"select sum(a),d  from A group by db.t.d",
-		"select count(distinct b) from A",
+		"select count(distinct b), sum(distinct c) from A",
+		"select bit_and(distinct b) from A",
 	}
 	results := []string{
 		"unsupported: group.by.field[d].should.be.in.select.list",
 		"unsupported: unknow.table.in.group.by.field[t.d]",
-		"unsupported: distinct.in.function:count",
+		"unsupported: distinct.functions.with.different.arguments",
+		"unsupported: distinct.in.function:bit_and",
 	}
 
 	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
@@ -368,6 +370,8 @@ func TestAggregatePlanUnsupported(t *testing.T) {
 func TestAggregatePlans(t *testing.T) {
 	querys := []string{
 		"select avg(a) as b1, avg(c*100)  from A",
+		"select a, count(distinct b), group_concat(distinct b separator ';') as s, max(distinct b) from A group by a",
+		"select stddev(a), var_samp(a+1), bit_xor(b), group_concat(c, b) from A",
 	}
 	results := []string{
 		`{
@@ -404,6 +408,58 @@ func TestAggregatePlans(t *testing.T) {
 		}
 	],
 	"ReWritten": "sum(a) as b1, count(a), sum(c * 100) as ` + "`avg(c * 100)`" + `, count(c * 100)"
+}`,
+		`{
+	"Aggrs": [
+		{
+			"Field": "count(distinct b)",
+			"Index": 1,
+			"Type": "COUNT DISTINCT"
+		},
+		{
+			"Field": "s",
+			"Index": 2,
+			"Type": "GROUP_CONCAT DISTINCT",
+			"Separator": ";"
+		},
+		{
+			"Field": "max(distinct b)",
+			"Index": 3,
+			"Type": "MAX"
+		},
+		{
+			"Field": "a",
+			"Index": 0,
+			"Type": "GROUP BY"
+		}
+	],
+	"ReWritten": "a, b as ` + "`count(distinct b)`" + `, b as s, max(distinct b)"
+}`,
+		`{
+	"Aggrs": [
+		{
+			"Field": "stddev(a)",
+			"Index": 0,
+			"Type": "STDDEV_POP"
+		},
+		{
+			"Field": "var_samp(a + 1)",
+			"Index": 3,
+			"Type": "VAR_SAMP"
+		},
+		{
+			"Field": "bit_xor(b)",
+			"Index": 6,
+			"Type": "BIT_XOR"
+		},
+		{
+			"Field": "group_concat(c, b)",
+			"Index": 7,
+			"Type": "GROUP_CONCAT",
+			"Separator": ","
+		}
+	],
+	"ReWritten": "var_pop(a) as ` + "`stddev(a)`" + `, count(a), avg(a), var_pop(a + 1) as ` + "`var_samp(a + 1)`" + `, count(a + 1), avg(a + 1), bit_xor(b), group_concat(c, b)"
 }`,
 	}
 
@@ -438,3 +494,99 @@ func TestAggregatePlans(t *testing.T) {
 		}
 	}
 }
+
+func TestAggregatePlanExprs(t *testing.T) {
+	querys := []string{
+		"select a, round(sum(b)/count(c), 2) as r, if(max(b) > 1, 'y', 'n') from A group by a having sum(b) > 10 and (avg(b) < r or a > 1)",
+	}
+	results := []string{
+		`{
+	"Aggrs": [
+		{
+			"Field": "tmpa_0",
+			"Index": 3,
+			"Type": "SUM"
+		},
+		{
+			"Field": "tmpa_1",
+			"Index": 4,
+			"Type": "COUNT"
+		},
+		{
+			"Field": "tmpa_2",
+			"Index": 5,
+			"Type": "MAX"
+		},
+		{
+			"Field": "tmpa_3",
+			"Index": 6,
+			"Type": "AVG"
+		},
+		{
+			"Field": "sum(b)",
+			"Index": 6,
+			"Type": "SUM"
+		},
+		{
+			"Field": "count(b)",
+			"Index": 7,
+			"Type": "COUNT"
+		},
+		{
+			"Field": "r",
+			"Index": 1,
+			"Type": "EXPR"
+		},
+		{
+			"Field": "if(max(b) \u003e 1, 'y', 'n')",
+			"Index": 2,
+			"Type": "EXPR"
+		},
+		{
+			"Field": "a",
+			"Index": 0,
+			"Type": "GROUP BY"
+		}
+	],
+	"Havings": [
+		"sum(b) \u003e 10",
+		"avg(b) \u003c r or a \u003e 1"
+	],
+	"ReWritten": "a, round(sum(b) / count(c), 2) as r, if(max(b) \u003e 1, 'y', 'n'), sum(b) as tmpa_0, count(c) as tmpa_1, max(b) as tmpa_2, sum(b) as tmpa_3, count(b)"
+}`,
+	}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+	err := route.AddForTest("sbtest", router.MockTableMConfig())
+	assert.Nil(t, err)
+	for i, query := range querys {
+		tree, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		node := tree.(*sqlparser.Select)
+		p, err := scanTableExprs(log, route, "sbtest", node.From)
+		assert.Nil(t, err)
+		tuples, hasAgg, err := parserSelectExprs(node.SelectExprs, p)
+		assert.Nil(t, err)
+		assert.True(t, hasAgg)
+		groups, err := checkGroupBy(node.GroupBy, tuples, route, p.getReferredTables())
+		assert.Nil(t, err)
+		plan := NewAggregatePlan(log, node.SelectExprs, tuples, groups)
+		for _, having := range splitAndExpression(nil, node.Having.Expr) {
+			assert.True(t, plan.aggregated(having))
+			plan.havings = append(plan.havings, skipParenthesis(having))
+		}
+		// plan build
+		{
+			err := plan.Build()
+			assert.Nil(t, err)
+			want := results[i]
+			got := plan.JSON()
+			log.Debug(got)
+			assert.Equal(t, want, got)
+			assert.Equal(t, 2, len(plan.Havings()))
+			assert.Equal(t, 3, plan.Hidden())
+		}
+	}
+}
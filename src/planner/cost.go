@@ -0,0 +1,321 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package planner
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+)
+
+const (
+	// rowCost is the cost to read a row from the backends.
+	rowCost = 1.0
+	// cpuCost is the cost to process a row in the proxy.
+	cpuCost = 0.2
+	// queryCost is the cost of a query sent to the backend.
+	queryCost = 50.0
+	// defaultRows is the row count of the table without statistics.
+	defaultRows = 1000
+	// hashMaxRows is the max rows of the smaller side to build the hash table,
+	// the larger joins are sorted and merged.
+	hashMaxRows = 1 << 20
+	// lookupBatch and lookupMaxKeys are same as the NestedLoop executor.
+	lookupBatch   = 1000
+	lookupMaxKeys = 16 * lookupBatch
+	// rangeSelectivity is the selectivity of the range, like and other filters.
+	rangeSelectivity = 1.0 / 3
+	// eqSelectivity is the selectivity of the equality filter without index cardinality.
+	eqSelectivity = 0.1
+)
+
+// TableStats is the statistics of the table, summed over the partitions.
+type TableStats struct {
+	// Rows is the estimated row count.
+	Rows uint64
+	// Partitions is the partition count of the table.
+	Partitions int
+	// Cardinality is the index cardinality of the column which is the first column of an index,
+	// the key is the lower case column name.
+	Cardinality map[string]uint64
+}
+
+// Statistics provides the table statistics to estimate the cost of the plan.
+type Statistics interface {
+	// TableStats returns the statistics of the table, nil if unknown.
+	TableStats(database, table string) *TableStats
+}
+
+// costModel used to estimate the rows and the cost of the plan nodes by the statistics.
+type costModel struct {
+	stats Statistics
+}
+
+// tableRows returns the estimated rows read from the routed partitions of the table.
+func (c *costModel) tableRows(tbInfo *TableInfo) float64 {
+	stats := c.stats.TableStats(tbInfo.database, tbInfo.tableName)
+	if stats == nil {
+		return defaultRows
+	}
+	rows := float64(stats.Rows)
+	if tbInfo.shardType != "GLOBAL" && tbInfo.shardType != "SINGLE" && stats.Partitions > 0 &&
+		len(tbInfo.Segments) > 0 && len(tbInfo.Segments) < stats.Partitions {
+		rows = rows * float64(len(tbInfo.Segments)) / float64(stats.Partitions)
+	}
+	return math.Max(rows, 1)
+}
+
+// cardinality returns the distinct values of the column, limited by the rows. The rows are
+// returned if the column has no index cardinality, ok is false.
+func (c *costModel) cardinality(tbInfo *TableInfo, column string, rows float64) (float64, bool) {
+	if tbInfo != nil && tbInfo.derived == nil {
+		if stats := c.stats.TableStats(tbInfo.database, tbInfo.tableName); stats != nil {
+			if card, ok := stats.Cardinality[strings.ToLower(column)]; ok && card > 0 {
+				return math.Max(math.Min(float64(card), rows), 1), true
+			}
+		}
+	}
+	return math.Max(rows, 1), false
+}
+
+// selectivity returns the estimated fraction of the rows which satisfy the filter.
+func (c *costModel) selectivity(expr sqlparser.Expr, tbInfos map[string]*TableInfo, rows map[string]float64) float64 {
+	colCard := func(expr sqlparser.Expr) (float64, bool) {
+		col, ok := skipParenthesis(expr).(*sqlparser.ColName)
+		if !ok {
+			return 0, false
+		}
+		table := col.Qualifier.Name.String()
+		tbInfo, ok := tbInfos[table]
+		if !ok {
+			if table != "" || len(tbInfos) != 1 {
+				return 0, false
+			}
+			table, tbInfo = getOneTableInfo(tbInfos)
+		}
+		return c.cardinality(tbInfo, col.Name.String(), rows[table])
+	}
+
+	switch expr := skipParenthesis(expr).(type) {
+	case *sqlparser.AndExpr:
+		return c.selectivity(expr.Left, tbInfos, rows) * c.selectivity(expr.Right, tbInfos, rows)
+	case *sqlparser.OrExpr:
+		l := c.selectivity(expr.Left, tbInfos, rows)
+		r := c.selectivity(expr.Right, tbInfos, rows)
+		return l + r - l*r
+	case *sqlparser.NotExpr:
+		return 1 - c.selectivity(expr.Expr, tbInfos, rows)
+	case *sqlparser.IsExpr:
+		switch expr.Operator {
+		case sqlparser.IsNullStr:
+			return eqSelectivity
+		case sqlparser.IsNotNullStr:
+			return 1 - eqSelectivity
+		}
+	case *sqlparser.ComparisonExpr:
+		switch expr.Operator {
+		case sqlparser.EqualStr, sqlparser.NullSafeEqualStr:
+			lcard, lok := colCard(expr.Left)
+			rcard, rok := colCard(expr.Right)
+			if lcard > 0 && rcard > 0 {
+				// The join of two columns.
+				return 1 / math.Max(lcard, rcard)
+			}
+			if lok {
+				return 1 / lcard
+			}
+			if rok {
+				return 1 / rcard
+			}
+			return eqSelectivity
+		case sqlparser.NotEqualStr:
+			return 1 - eqSelectivity
+		case sqlparser.InStr:
+			tuple, ok := expr.Right.(sqlparser.ValTuple)
+			if !ok {
+				return rangeSelectivity
+			}
+			sel := eqSelectivity
+			if card, ok := colCard(expr.Left); ok {
+				sel = 1 / card
+			}
+			return math.Min(1, sel*float64(len(tuple)))
+		case sqlparser.NotInStr:
+			return 1 - eqSelectivity
+		}
+	}
+	return rangeSelectivity
+}
+
+// rows returns the estimated rows returned by the node.
+func (c *costModel) rows(node PlanNode) float64 {
+	switch node := node.(type) {
+	case *MergeNode:
+		return c.mergeRows(node)
+	case *JoinNode:
+		return c.joinRows(node)
+	case *UnionNode:
+		return c.rows(node.Left) + c.rows(node.Right)
+	case *DerivedNode:
+		if node.Plan != nil && node.Plan.Root != nil {
+			return c.rows(node.Plan.Root)
+		}
+	}
+	return defaultRows
+}
+
+// mergeRows returns the estimated rows returned by the MergeNode's querys.
+func (c *costModel) mergeRows(m *MergeNode) float64 {
+	rows := 1.0
+	tableRows := make(map[string]float64, len(m.referredTables))
+	for table, tbInfo := range m.referredTables {
+		tableRows[table] = c.tableRows(tbInfo)
+		rows *= tableRows[table]
+	}
+	if m.sel.Where != nil {
+		rows *= c.selectivity(m.sel.Where.Expr, m.referredTables, tableRows)
+	}
+	if limit := m.sel.Limit; limit != nil {
+		if count, ok := intVal(limit.Rowcount); ok {
+			offset, _ := intVal(limit.Offset)
+			rows = math.Min(rows, float64((offset+count)*m.routeLen))
+		}
+	}
+	return math.Max(rows, 1)
+}
+
+// joinRows returns the estimated rows of the join, the rows of a key are the rows of
+// the side divided by the distinct values of the key.
+func (c *costModel) joinRows(j *JoinNode) float64 {
+	lrows := c.rows(j.Left)
+	rrows := c.rows(j.Right)
+	rows := lrows * rrows
+	for i, lkey := range j.LeftKeys {
+		rkey := j.RightKeys[i]
+		lcard, _ := c.cardinality(j.referredTables[lkey.Table], lkey.Field, lrows)
+		rcard, _ := c.cardinality(j.referredTables[rkey.Table], rkey.Field, rrows)
+		rows /= math.Max(lcard, rcard)
+	}
+	rows *= math.Pow(rangeSelectivity, float64(len(j.CmpFilter)+len(j.Filters)))
+	if j.IsLeftJoin {
+		rows = math.Max(rows, lrows)
+	}
+	return math.Max(rows, 1)
+}
+
+// cost returns the estimated cost of the node.
+func (c *costModel) cost(node PlanNode) float64 {
+	switch node := node.(type) {
+	case *MergeNode:
+		return float64(node.routeLen)*queryCost + c.mergeRows(node)*rowCost
+	case *JoinNode:
+		return c.joinCost(node, node.Strategy)
+	case *UnionNode:
+		return c.cost(node.Left) + c.cost(node.Right)
+	case *DerivedNode:
+		if node.Plan != nil && node.Plan.Root != nil {
+			return c.cost(node.Plan.Root) + c.rows(node.Plan.Root)*cpuCost
+		}
+	}
+	return queryCost + defaultRows*rowCost
+}
+
+// joinCost returns the estimated cost of the join by the strategy.
+func (c *costModel) joinCost(j *JoinNode, strategy JoinStrategy) float64 {
+	lrows := c.rows(j.Left)
+	rrows := c.rows(j.Right)
+	output := c.joinRows(j) * cpuCost
+	hash := c.cost(j.Left) + c.cost(j.Right) + (lrows+rrows)*cpuCost + output
+
+	switch strategy {
+	case Cartesian:
+		return c.cost(j.Left) + c.cost(j.Right) + lrows*rrows*cpuCost
+	case SortMerge:
+		return c.cost(j.Left) + c.cost(j.Right) + (lrows*math.Log2(lrows+1)+rrows*math.Log2(rrows+1))*cpuCost + output
+	case HashJoin:
+		if math.Min(lrows, rrows) > hashMaxRows {
+			return math.Inf(1)
+		}
+		return hash
+	case NestedLoop:
+		// Too many keys, the executor reads the right fully and joins by hash.
+		key := j.LeftKeys[j.lookupKey()]
+		keys, _ := c.cardinality(j.referredTables[key.Table], key.Field, lrows)
+		if keys > lookupMaxKeys {
+			return c.joinCost(j, HashJoin)
+		}
+		rkey := j.RightKeys[j.lookupKey()]
+		rcard, _ := c.cardinality(j.referredTables[rkey.Table], rkey.Field, rrows)
+		fetched := math.Min(rrows, keys*rrows/rcard)
+
+		// The key values are sent to all the routes, unless the shard key prunes the
+		// partitions, then one partition per key at most.
+		routes := 1.0
+		sent := keys
+		if m, ok := j.Right.(*MergeNode); ok {
+			routes = float64(m.routeLen)
+			if tbInfo := j.referredTables[rkey.Table]; tbInfo != nil && tbInfo.shardKey == rkey.Field {
+				routes = math.Min(routes, keys)
+			} else {
+				sent = keys * routes
+			}
+		}
+		batches := math.Max(math.Ceil(keys/lookupBatch), 1)
+		return c.cost(j.Left) + batches*routes*queryCost + (sent+fetched)*rowCost + (lrows+fetched)*cpuCost + output
+	}
+	return hash
+}
+
+// chooseStrategy used to choose the join strategies of the plan tree by the estimated cost,
+// the children are chosen first.
+func (c *costModel) chooseStrategy(node PlanNode) {
+	switch node := node.(type) {
+	case *JoinNode:
+		c.chooseStrategy(node.Left)
+		c.chooseStrategy(node.Right)
+		// The join without keys is merged by the CmpFilter.
+		if node.Strategy == Cartesian || len(node.LeftKeys) == 0 {
+			return
+		}
+		strategies := []JoinStrategy{HashJoin, SortMerge}
+		if isLookupNode(node.Right) {
+			strategies = append(strategies, NestedLoop)
+		}
+		best, min := node.Strategy, math.Inf(1)
+		for _, strategy := range strategies {
+			if cost := c.joinCost(node, strategy); cost < min {
+				best, min = strategy, cost
+			}
+		}
+		node.Strategy = best
+		node.LookupKey = 0
+		if best == NestedLoop {
+			node.LookupKey = node.lookupKey()
+		}
+	case *UnionNode:
+		c.chooseStrategy(node.Left)
+		c.chooseStrategy(node.Right)
+	case *DerivedNode:
+		if node.Plan != nil && node.Plan.Root != nil {
+			c.chooseStrategy(node.Plan.Root)
+		}
+	}
+}
+
+// intVal returns the int value of the limit expr.
+func intVal(expr sqlparser.Expr) (int, bool) {
+	val, ok := expr.(*sqlparser.SQLVal)
+	if !ok || val.Type != sqlparser.IntVal {
+		return 0, false
+	}
+	n, err := strconv.Atoi(string(val.Val))
+	return n, err == nil
+}
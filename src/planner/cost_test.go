@@ -0,0 +1,128 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package planner
+
+import (
+	"testing"
+
+	"router"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+// mockStats is the statistics by the table name.
+type mockStats map[string]*TableStats
+
+func (m mockStats) TableStats(database, table string) *TableStats {
+	return m[table]
+}
+
+func TestCostChooseStrategy(t *testing.T) {
+	big := mockStats{
+		"A": {Rows: 10000000, Partitions: 6, Cardinality: map[string]uint64{"id": 10000000, "a": 10000000}},
+		"B": {Rows: 10000000, Partitions: 2, Cardinality: map[string]uint64{"id": 10000000, "a": 10000000}},
+	}
+	small := mockStats{
+		"A": {Rows: 100, Partitions: 6},
+		"B": {Rows: 100, Partitions: 2},
+	}
+	tcases := []struct {
+		query    string
+		stats    Statistics
+		simple   JoinStrategy
+		strategy JoinStrategy
+	}{
+		// The left is one row by the unique index, the right is looked up.
+		{"select A.id from A join B on A.a=B.a where A.id=1", big, NestedLoop, NestedLoop},
+		// Too many keys to look up, the smaller side is hashed.
+		{"select A.id from A join B on A.a=B.a where A.str='x'", big, SortMerge, HashJoin},
+		// Both sides are too large to hash.
+		{"select A.id from A join B on A.a=B.a", big, SortMerge, SortMerge},
+		// The small tables without filters are hashed.
+		{"select A.id from A join B on A.a=B.a", small, SortMerge, HashJoin},
+		// Unknown tables keep the default rows.
+		{"select A.id from A join B on A.a=B.a where B.str='x'", mockStats{}, SortMerge, HashJoin},
+		// The join without keys isn't changed.
+		{"select A.id from A join B on A.a>B.a", big, SortMerge, SortMerge},
+	}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableMConfig(), router.MockTableBConfig())
+	assert.Nil(t, err)
+	for _, tcase := range tcases {
+		for _, stats := range []Statistics{nil, tcase.stats} {
+			node, err := sqlparser.Parse(tcase.query)
+			assert.Nil(t, err)
+			plan := NewSelectPlan(log, database, tcase.query, node.(*sqlparser.Select), route)
+			plan.SetStatistics(stats)
+			err = plan.Build()
+			assert.Nil(t, err)
+
+			want := tcase.simple
+			if stats != nil {
+				want = tcase.strategy
+				assert.True(t, plan.Cost() > 0)
+			} else {
+				assert.Equal(t, float64(0), plan.Cost())
+			}
+			join := plan.Root.(*JoinNode)
+			assert.Equal(t, want, join.Strategy, tcase.query)
+		}
+	}
+}
+
+func TestCostEstimateRows(t *testing.T) {
+	stats := mockStats{
+		"A": {Rows: 6000, Partitions: 6, Cardinality: map[string]uint64{"id": 6000, "a": 10}},
+		"B": {Rows: 2000, Partitions: 2, Cardinality: map[string]uint64{"id": 2000}},
+	}
+	tcases := []struct {
+		query string
+		rows  float64
+	}{
+		{"select A.id from A", 6000},
+		// One partition by the shard key.
+		{"select A.id from A where A.id=1", 1},
+		{"select A.id from A where A.a=1", 600},
+		{"select A.id from A where A.a in (1, 2)", 1200},
+		{"select A.id from A where A.a=1 or A.a=2", 1140},
+		{"select A.id from A where A.b=1", 600},
+		{"select A.id from A where A.b>1", 2000},
+		{"select A.id from A limit 10", 60},
+		// The join rows are divided by the max cardinality of the keys.
+		{"select A.id from A join B on A.a=B.id", 6000},
+		{"select A.id from A join B on A.a=B.b", 6000},
+		{"select A.id from A left join B on A.a=B.id and B.b=1", 6000},
+	}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableMConfig(), router.MockTableBConfig())
+	assert.Nil(t, err)
+	model := &costModel{stats: stats}
+	for _, tcase := range tcases {
+		node, err := sqlparser.Parse(tcase.query)
+		assert.Nil(t, err)
+		plan := NewSelectPlan(log, database, tcase.query, node.(*sqlparser.Select), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+		assert.InDelta(t, tcase.rows, model.rows(plan.Root), 1, tcase.query)
+	}
+}
@@ -91,11 +91,11 @@ func (p *DDLPlan) Build() error {
 		if shardKey != "" {
 			switch node.Action {
 			case sqlparser.AlterDropColumnStr:
-				if shardKey == node.DropColumnName {
+				if isShardKeyColumn(node.DropColumnName, shardKey) {
 					return errors.New("unsupported: cannot.drop.the.column.on.shard.key")
 				}
 			case sqlparser.AlterModifyColumnStr:
-				if shardKey == node.ModifyColumnDef.Name.String() {
+				if isShardKeyColumn(node.ModifyColumnDef.Name.String(), shardKey) {
 					return errors.New("unsupported: cannot.modify.the.column.on.shard.key")
 				}
 				// constraint check in column definition
@@ -146,6 +146,33 @@ func TestDDLAlterError(t *testing.T) {
 	}
 }
 
+func TestDDLAlterCompositeKeyError(t *testing.T) {
+	querys := []string{
+		"alter table C modify column a int",
+		"alter table C drop column b",
+	}
+	results := []string{
+		"unsupported: cannot.modify.the.column.on.shard.key",
+		"unsupported: cannot.drop.the.column.on.shard.key",
+	}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableCConfig())
+	assert.Nil(t, err)
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewDDLPlan(log, database, query, node.(*sqlparser.DDL), route)
+		err = plan.Build()
+		assert.Equal(t, results[i], err.Error())
+	}
+}
+
 func TestDDLPlanScatter(t *testing.T) {
 	results := []string{
 		`{
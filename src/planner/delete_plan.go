@@ -17,11 +17,13 @@ import (
 	"github.com/pkg/errors"
 	"github.com/xelabs/go-mysqlstack/sqlparser"
 	"github.com/xelabs/go-mysqlstack/sqlparser/depends/common"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
 	"github.com/xelabs/go-mysqlstack/xlog"
 )
 
 var (
-	_ Plan = &DeletePlan{}
+	_ Plan         = &DeletePlan{}
+	_ SubqueryPlan = &DeletePlan{}
 )
 
 // DeletePlan represents delete plan
@@ -48,6 +50,18 @@ type DeletePlan struct {
 
 	// query and backend tuple
 	Querys []xcontext.QueryTuple
+
+	// subqueries to materialize before building the querys.
+	subqueries []*Subquery
+
+	// Select is the plan of the join if the multi-table delete isn't pushed down.
+	Select *SelectPlan
+
+	// join is the multi-table delete.
+	join *joinDML
+
+	// tables written by the plan.
+	writeTables
 }
 
 // NewDeletePlan used to create DeletePlan
@@ -65,15 +79,16 @@ func NewDeletePlan(log *xlog.Log, database string, query string, node *sqlparser
 
 // analyze used to analyze the 'delete' is at the support level.
 func (p *DeletePlan) analyze() error {
+	var err error
 	node := p.node
-	// analyze subquery.
-	if hasSubquery(node) {
-		return errors.New("unsupported: subqueries.in.delete")
-	}
 	if node.Where == nil {
 		return errors.New("unsupported: missing.where.clause.in.DML")
 	}
-	return nil
+	// analyze subquery.
+	if hasSubquery(node) {
+		p.subqueries, err = buildSubqueries(p.log, p.router, p.database, node)
+	}
+	return err
 }
 
 // Build used to build distributed querys.
@@ -81,8 +96,33 @@ func (p *DeletePlan) Build() error {
 	if err := p.analyze(); err != nil {
 		return err
 	}
+	if len(p.subqueries) > 0 {
+		return nil
+	}
+	return p.build()
+}
+
+// Subqueries returns the subqueries not yet materialized.
+func (p *DeletePlan) Subqueries() []*Subquery {
+	return p.subqueries
+}
+
+// Materialize substitutes the results of the subqueries, then builds the querys.
+func (p *DeletePlan) Materialize(results []*sqltypes.Result) error {
+	if err := substituteSubqueries(p.node, p.subqueries, results); err != nil {
+		return err
+	}
+	p.subqueries = nil
+	return p.build()
+}
 
+// build used to build the querys.
+func (p *DeletePlan) build() error {
 	node := p.node
+	if len(node.TableExprs) > 0 {
+		return p.buildJoin()
+	}
+
 	// Database.
 	database := p.database
 	if !node.Table.Qualifier.IsEmpty() {
@@ -95,15 +135,87 @@ func (p *DeletePlan) Build() error {
 	if err != nil {
 		return err
 	}
+	if err := p.checkWritable(p.router, database, table); err != nil {
+		return err
+	}
 
-	// Get the routing segments info.
-	segments, err := getDMLRouting(database, table, shardkey, node.Where, p.router)
+	querys, err := buildDeleteQuerys(node, database, table, shardkey, p.router)
 	if err != nil {
 		return err
 	}
+	p.Querys = append(p.Querys, querys...)
+	return nil
+}
+
+// buildJoin used to build the multi-table delete. The delete is pushed down per partition
+// if the tables are co-located, otherwise the join is evaluated by the Select plan and
+// the deletes are built by RowQuerys.
+func (p *DeletePlan) buildJoin() error {
+	node := p.node
+	j, err := newJoinDML(p.log, p.router, p.database, node.TableExprs, node.Where)
+	if err != nil {
+		return err
+	}
+	for _, table := range node.Targets {
+		target, err := j.addTarget(table.Name.String())
+		if err != nil {
+			return err
+		}
+		if err := p.checkWritable(p.router, target.table.database, target.table.tableName); err != nil {
+			return err
+		}
+	}
+
+	targets := j.targetRefs()
+	querys, ok, err := j.pushDown(func(buf *sqlparser.TrackedBuffer, from sqlparser.TableExprs, where *sqlparser.Where) {
+		buf.Myprintf("delete %v%v from %v%v", node.Comments, targets, from, where)
+	})
+	if err != nil {
+		return err
+	}
+	if ok {
+		p.Querys = append(p.Querys, querys...)
+		return nil
+	}
+
+	p.join = j
+	p.Select, err = j.buildSelect(nil)
+	return err
+}
+
+// RowQuerys used to build the querys which delete the target rows of the joined rows,
+// the rows are produced by the Select plan.
+func (p *DeletePlan) RowQuerys(rows [][]sqltypes.Value) ([]xcontext.QueryTuple, error) {
+	var querys []xcontext.QueryTuple
+	for _, target := range p.join.targets {
+		for _, group := range p.join.groupRows(target, rows, nil) {
+			del := &sqlparser.Delete{
+				Comments: p.node.Comments,
+				Table:    sqlparser.TableName{Name: sqlparser.NewTableIdent(target.table.tableName), Qualifier: sqlparser.NewTableIdent(target.table.database)},
+				Where:    group.where,
+			}
+			qs, err := buildDeleteQuerys(del, target.table.database, target.table.tableName, target.table.shardKey, p.router)
+			if err != nil {
+				return nil, err
+			}
+			querys = append(querys, qs...)
+		}
+	}
+	return querys, nil
+}
+
+// buildDeleteQuerys used to build the querys of the single table delete, routed by the where clause.
+func buildDeleteQuerys(node *sqlparser.Delete, database, table, shardkey string, route *router.Router) ([]xcontext.QueryTuple, error) {
+	// Get the routing segments info.
+	segments, in, err := getDMLRouting(database, table, shardkey, node.Where, route)
+	if err != nil {
+		return nil, err
+	}
 
 	// Rewritten the query.
+	querys := make([]xcontext.QueryTuple, 0, len(segments))
 	for _, segment := range segments {
+		in.rewrite(segment.Table)
 		buf := sqlparser.NewTrackedBuffer(nil)
 		buf.Myprintf("delete %vfrom %s.%s%v%v%v", node.Comments, database, segment.Table, node.Where, node.OrderBy, node.Limit)
 		tuple := xcontext.QueryTuple{
@@ -111,9 +223,10 @@ func (p *DeletePlan) Build() error {
 			Backend: segment.Backend,
 			Range:   segment.Range.String(),
 		}
-		p.Querys = append(p.Querys, tuple)
+		querys = append(querys, tuple)
 	}
-	return nil
+	in.restore()
+	return querys, nil
 }
 
 // Type returns the type of the plan.
@@ -126,6 +239,8 @@ func (p *DeletePlan) JSON() string {
 	type explain struct {
 		RawQuery   string                `json:",omitempty"`
 		Partitions []xcontext.QueryTuple `json:",omitempty"`
+		Subqueries []json.RawMessage     `json:",omitempty"`
+		Select     json.RawMessage       `json:",omitempty"`
 	}
 
 	// Partitions.
@@ -134,6 +249,10 @@ func (p *DeletePlan) JSON() string {
 	exp := &explain{
 		RawQuery:   p.RawQuery,
 		Partitions: parts,
+		Subqueries: subqueriesJSON(p.subqueries),
+	}
+	if p.Select != nil {
+		exp.Select = json.RawMessage(p.Select.JSON())
 	}
 	bout, err := json.MarshalIndent(exp, "", "\t")
 	if err != nil {
@@ -9,12 +9,14 @@
 package planner
 
 import (
+	"fmt"
 	"testing"
 
 	"router"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
 	"github.com/xelabs/go-mysqlstack/xlog"
 )
 
@@ -78,31 +80,6 @@ func TestDeletePlan(t *testing.T) {
 		`{
 	"RawQuery": "delete from sbtest.A where id in (1, 2,3)",
 	"Partitions": [
-		{
-			"Query": "delete from sbtest.A1 where id in (1, 2, 3)",
-			"Backend": "backend1",
-			"Range": "[0-32)"
-		},
-		{
-			"Query": "delete from sbtest.A2 where id in (1, 2, 3)",
-			"Backend": "backend2",
-			"Range": "[32-64)"
-		},
-		{
-			"Query": "delete from sbtest.A3 where id in (1, 2, 3)",
-			"Backend": "backend3",
-			"Range": "[64-96)"
-		},
-		{
-			"Query": "delete from sbtest.A4 where id in (1, 2, 3)",
-			"Backend": "backend4",
-			"Range": "[96-256)"
-		},
-		{
-			"Query": "delete from sbtest.A5 where id in (1, 2, 3)",
-			"Backend": "backend5",
-			"Range": "[256-512)"
-		},
 		{
 			"Query": "delete from sbtest.A6 where id in (1, 2, 3)",
 			"Backend": "backend6",
@@ -153,12 +130,12 @@ func TestDeletePlan(t *testing.T) {
 func TestDeleteUnsupportedPlan(t *testing.T) {
 	querys := []string{
 		"delete from sbtest.A",
-		"delete from sbtest.A where id in (select id from t1)",
+		"delete from sbtest.A where id in (select id from t1 where t1.a=A.a)",
 	}
 
 	results := []string{
 		"unsupported: missing.where.clause.in.DML",
-		"unsupported: subqueries.in.delete",
+		"unsupported: correlated.subquery.column[A.a]",
 	}
 
 	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
@@ -214,3 +191,135 @@ func TestDeleteErrorPlan(t *testing.T) {
 		}
 	}
 }
+
+func TestDeleteJoinPlan(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	// B has the same partitions as A, D hasn't.
+	confB := router.MockTableMConfig()
+	confB.Name = "B"
+	for i, part := range confB.Partitions {
+		part.Table = fmt.Sprintf("B%d", i+1)
+	}
+	confD := router.MockTableBConfig()
+	confD.Name = "D"
+	for i, part := range confD.Partitions {
+		part.Table = fmt.Sprintf("D%d", i)
+	}
+	err := route.AddForTest(database, router.MockTableMConfig(), confB, confD, router.MockTableGConfig(), router.MockTableG1Config())
+	assert.Nil(t, err)
+
+	// Pushed down per partition.
+	{
+		querys := []string{
+			"delete A from A join B on A.id = B.id where B.id = 1",
+			"delete from A, B using A join B on A.id = B.id where A.id = 1",
+			"delete G from G join G1 on G.id = G1.id where G1.b = 1",
+		}
+		wants := [][]string{
+			{"delete A from sbtest.A6 as A join sbtest.B6 as B on A.id = B.id where B.id = 1"},
+			{"delete A, B from sbtest.A6 as A join sbtest.B6 as B on A.id = B.id where A.id = 1"},
+			{
+				"delete sbtest.G from sbtest.G join sbtest.G1 on G.id = G1.id where G1.b = 1",
+				"delete sbtest.G from sbtest.G join sbtest.G1 on G.id = G1.id where G1.b = 1",
+			},
+		}
+		for i, query := range querys {
+			node, err := sqlparser.Parse(query)
+			assert.Nil(t, err)
+			plan := NewDeletePlan(log, database, query, node.(*sqlparser.Delete), route)
+			err = plan.Build()
+			assert.Nil(t, err)
+			assert.Nil(t, plan.Select, query)
+			var got []string
+			for _, q := range plan.Querys {
+				got = append(got, q.Query)
+			}
+			assert.Equal(t, wants[i], got)
+		}
+	}
+
+	// The join is evaluated by the proxy.
+	{
+		querys := []string{
+			"delete A, D from A join D on A.id = D.id where D.c = 1",
+			"delete A from A left join D on A.b = D.b and A.c = D.c where D.d = 1 and A.d = 2",
+		}
+		selects := []string{
+			"select A.id, D.id from A join D on A.id = D.id where D.c = 1",
+			"select A.b, A.c from A left join D on A.b = D.b and A.c = D.c where D.d = 1 and A.d = 2",
+		}
+		rows := [][][]sqltypes.Value{
+			{
+				{sqltypes.NewInt64(1), sqltypes.NewInt64(1)},
+				{sqltypes.NewInt64(2), sqltypes.NewInt64(2)},
+			},
+			{
+				{sqltypes.NewInt64(1), sqltypes.NULL},
+				{sqltypes.NULL, sqltypes.NULL},
+			},
+		}
+		wants := [][]string{
+			{
+				"delete from sbtest.A6 where id in (1, 2)",
+				"delete from sbtest.D1 where id in (1, 2) and c = 1",
+			},
+			{
+				"delete from sbtest.A1 where b = 1 and c is null and d = 2",
+				"delete from sbtest.A2 where b = 1 and c is null and d = 2",
+				"delete from sbtest.A3 where b = 1 and c is null and d = 2",
+				"delete from sbtest.A4 where b = 1 and c is null and d = 2",
+				"delete from sbtest.A5 where b = 1 and c is null and d = 2",
+				"delete from sbtest.A6 where b = 1 and c is null and d = 2",
+			},
+		}
+		for i, query := range querys {
+			node, err := sqlparser.Parse(query)
+			assert.Nil(t, err)
+			plan := NewDeletePlan(log, database, query, node.(*sqlparser.Delete), route)
+			err = plan.Build()
+			assert.Nil(t, err)
+			assert.Equal(t, 0, len(plan.Querys))
+			assert.NotNil(t, plan.Select)
+			assert.Equal(t, selects[i], plan.Select.RawQuery)
+
+			querys, err := plan.RowQuerys(rows[i])
+			assert.Nil(t, err)
+			var got []string
+			for _, q := range querys {
+				got = append(got, q.Query)
+			}
+			assert.Equal(t, wants[i], got)
+		}
+	}
+
+	// Unsupported.
+	{
+		querys := []string{
+			"delete A from A join D on A.b = D.b",
+			"delete X from A join D on A.b = D.b where D.c = 1",
+			"delete t from A join (select b from D) as t on A.b = t.b where A.c = 1",
+			"delete A from A join D on A.b = D.b where c = 1",
+		}
+		wants := []string{
+			"unsupported: missing.where.clause.in.DML",
+			"unsupported: unknown.table.'X'.in.multi-table.DML",
+			"unsupported: the.target.table.'t'.is.not.updatable",
+			"unsupported: unknown.column.'c'.in.clause",
+		}
+		for i, query := range querys {
+			node, err := sqlparser.Parse(query)
+			assert.Nil(t, err)
+			plan := NewDeletePlan(log, database, query, node.(*sqlparser.Delete), route)
+			err = plan.Build()
+			assert.NotNil(t, err, query)
+			if err != nil {
+				assert.Equal(t, wants[i], err.Error(), query)
+			}
+		}
+	}
+}
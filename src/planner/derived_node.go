@@ -0,0 +1,534 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package planner
+
+import (
+	"evaluation"
+	"fmt"
+	"router"
+	"strings"
+	"xcontext"
+
+	"github.com/pkg/errors"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+// DerivedField is the field returned by the DerivedNode, computed from the row of the inner result.
+// If Aggr isn't empty, the field is the partial aggregation of the row, which is merged by the AggregatePlan.
+type DerivedField struct {
+	// field name.
+	Name string
+	// table name, the alias of the derived table for the non-aggregate field.
+	Table string
+	// index of the column in the inner result, -1 means 'count(*)'.
+	Index int
+	// aggregate function of the field.
+	Aggr AggrType
+}
+
+// derivedColumn is the column of the derived table.
+type derivedColumn struct {
+	name string
+	expr sqlparser.Expr
+}
+
+// DerivedNode is the derived table in the FROM clause, such as 'select ... from (select ...) as t'.
+// The inner select is executed as its own plan, the outer operations are done in the proxy.
+type DerivedNode struct {
+	log *xlog.Log
+	// router.
+	router *router.Router
+	// database.
+	database string
+	// alias of the derived table.
+	alias string
+	// the inner select ast.
+	sel *sqlparser.Select
+	// columns of the derived table, in the order of the inner select list.
+	columns []derivedColumn
+	// whether the inner select aggregates without group by.
+	aggregated bool
+	// referred tables' tableInfo map, only the derived table itself.
+	referredTables map[string]*TableInfo
+	// whether has parenthese in FROM clause.
+	hasParen bool
+	// parent node in the plan tree.
+	parent PlanNode
+	// children plans in select(such as: orderby, limit..).
+	children *PlanTree
+	// the returned result fields, used in the Multiple Plan Tree.
+	fields []selectTuple
+	// the filters rewritten on the inner columns.
+	filters []sqlparser.Expr
+	// the first error of the filters which can't be pushed into the inner select.
+	err error
+	// Plan is the plan of the inner select, built in buildQuery.
+	Plan *SelectPlan
+	// Fields defines how the returned fields computed from the inner result.
+	Fields []DerivedField
+	// Scalar is true if the outer query aggregates without group by,
+	// one row is returned even if the inner result is empty.
+	Scalar bool
+	// Filters are the filters which can't be pushed into the inner select,
+	// they're evaluated on the inner rows in the proxy.
+	Filters []evaluation.Evaluation
+}
+
+// newDerivedNode used to create DerivedNode.
+func newDerivedNode(log *xlog.Log, database string, router *router.Router, tableExpr *sqlparser.AliasedTableExpr) (*DerivedNode, error) {
+	// The parser makes sure the derived table has alias.
+	alias := tableExpr.As.String()
+	stmt := tableExpr.Expr.(*sqlparser.Subquery).Select
+	for {
+		paren, ok := stmt.(*sqlparser.ParenSelect)
+		if !ok {
+			break
+		}
+		stmt = paren.Select
+	}
+	sel, ok := stmt.(*sqlparser.Select)
+	if !ok {
+		return nil, errors.New("unsupported: union.in.derived.table")
+	}
+	if err := checkCorrelated(sel); err != nil {
+		return nil, err
+	}
+
+	d := &DerivedNode{
+		log:            log,
+		router:         router,
+		database:       database,
+		alias:          alias,
+		sel:            sel,
+		referredTables: make(map[string]*TableInfo),
+		children:       NewPlanTree(),
+	}
+	for _, expr := range sel.SelectExprs {
+		aliased, ok := expr.(*sqlparser.AliasedExpr)
+		if !ok {
+			return nil, errors.Errorf("unsupported: '%s'.expression.in.derived.table", sqlparser.String(expr))
+		}
+		name := aliased.As.String()
+		if name == "" {
+			if col, ok := aliased.Expr.(*sqlparser.ColName); ok {
+				name = col.Name.String()
+			} else {
+				name = sqlparser.String(aliased.Expr)
+			}
+		}
+		if _, ok := d.column(name); ok {
+			return nil, errors.Errorf("duplicate.column.name.'%s'.in.derived.table", name)
+		}
+		d.columns = append(d.columns, derivedColumn{name: name, expr: aliased.Expr})
+		if len(sel.GroupBy) == 0 && hasAggregate(aliased.Expr) {
+			d.aggregated = true
+		}
+	}
+
+	d.referredTables[alias] = &TableInfo{
+		database:  database,
+		tableName: alias,
+		alias:     alias,
+		tableExpr: tableExpr,
+		derived:   d,
+	}
+	return d, nil
+}
+
+// column returns the index of the column by name.
+func (d *DerivedNode) column(name string) (int, bool) {
+	for i, col := range d.columns {
+		if col.name == name {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// pushable returns true if the filter on the column can be pushed into the inner select.
+// The filter can't be pushed if the inner select has limit or aggregates without group by,
+// and the column must be one of the group by fields if the inner select has group by.
+func (d *DerivedNode) pushable(col *derivedColumn) bool {
+	if d.sel.Limit != nil || d.aggregated {
+		return false
+	}
+	if col == nil {
+		return true
+	}
+	if hasAggregate(col.expr) || hasSubquery(col.expr) {
+		return false
+	}
+	if len(d.sel.GroupBy) == 0 {
+		return true
+	}
+	expr := sqlparser.String(col.expr)
+	for _, by := range d.sel.GroupBy {
+		if sqlparser.String(by) == expr {
+			return true
+		}
+		if c, ok := by.(*sqlparser.ColName); ok && c.Qualifier.IsEmpty() && c.Name.String() == col.name {
+			return true
+		}
+	}
+	return false
+}
+
+// rewrite rewrites the outer expr on the inner columns, the result is a new expr.
+// eg: 'select * from (select a+1 as b from t) as d where d.b>1', 'd.b>1' is rewritten to '(a + 1) > 1'.
+func (d *DerivedNode) rewrite(expr sqlparser.Expr, check func(col *derivedColumn) bool) (sqlparser.Expr, error) {
+	var err error
+	var cols []*sqlparser.ColName
+	expr = sqlparser.CloneExpr(expr)
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (kontinue bool, e error) {
+		col, ok := node.(*sqlparser.ColName)
+		if !ok {
+			return true, nil
+		}
+		table := col.Qualifier.Name.String()
+		idx, ok := d.column(col.Name.String())
+		if !ok || (table != "" && table != d.alias) {
+			err = errors.Errorf("unsupported: unknown.column.'%s'.in.derived.table", sqlparser.String(col))
+			return false, err
+		}
+		if !check(&d.columns[idx]) {
+			err = errors.Errorf("unsupported: '%s'.cant.be.pushed.into.derived.table.'%s'", sqlparser.String(expr), d.alias)
+			return false, err
+		}
+		cols = append(cols, col)
+		return true, nil
+	}, expr)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, col := range cols {
+		idx, _ := d.column(col.Name.String())
+		inner := sqlparser.CloneExpr(d.columns[idx].expr)
+		switch inner.(type) {
+		case *sqlparser.ColName, *sqlparser.SQLVal:
+		default:
+			inner = &sqlparser.ParenExpr{Expr: inner}
+		}
+		if col == expr {
+			expr = inner
+		} else if !sqlparser.ReplaceExpr(expr, col, inner) {
+			return nil, errors.Errorf("unsupported: '%s'.cant.be.pushed.into.derived.table.'%s'", sqlparser.String(col), d.alias)
+		}
+	}
+	return expr, nil
+}
+
+// getReferredTables get the referredTables.
+func (d *DerivedNode) getReferredTables() map[string]*TableInfo {
+	return d.referredTables
+}
+
+// getFields get the fields.
+func (d *DerivedNode) getFields() []selectTuple {
+	return d.fields
+}
+
+// setParenthese set hasParen.
+func (d *DerivedNode) setParenthese(hasParen bool) {
+	d.hasParen = hasParen
+}
+
+// pushFilter used to push the filters.
+func (d *DerivedNode) pushFilter(filters []filterTuple) error {
+	for _, filter := range filters {
+		d.setWhereFilter(filter.expr)
+	}
+	return d.err
+}
+
+// setParent set the parent node.
+func (d *DerivedNode) setParent(p PlanNode) {
+	d.parent = p
+}
+
+// setWhereFilter used to push the where filters into the inner select, the filter which can't be
+// pushed is evaluated in the proxy, such as the filter of the aggregate column or the inner select
+// has limit. The error is returned by buildQuery if the filter can't be evaluated.
+func (d *DerivedNode) setWhereFilter(filter sqlparser.Expr) {
+	if d.pushable(nil) {
+		if expr, err := d.rewrite(filter, d.pushable); err == nil {
+			d.filters = append(d.filters, expr)
+			return
+		}
+	}
+	if _, err := d.rewrite(filter, func(col *derivedColumn) bool { return true }); err != nil {
+		if d.err == nil {
+			d.err = err
+		}
+		return
+	}
+	eval, err := evaluation.Build(filter, d.resolve)
+	if err != nil {
+		if d.err == nil {
+			d.err = err
+		}
+		return
+	}
+	d.Filters = append(d.Filters, eval)
+}
+
+// resolve returns the index of the column in the inner result.
+func (d *DerivedNode) resolve(expr sqlparser.Expr) (int, bool) {
+	col, ok := expr.(*sqlparser.ColName)
+	if !ok {
+		return -1, false
+	}
+	if table := col.Qualifier.Name.String(); table != "" && table != d.alias {
+		return -1, false
+	}
+	return d.column(col.Name.String())
+}
+
+// setNoTableFilter used to push the no table filters.
+func (d *DerivedNode) setNoTableFilter(exprs []sqlparser.Expr) {
+	for _, expr := range exprs {
+		d.setWhereFilter(expr)
+	}
+}
+
+// pushEqualCmpr used to push the 'join' type filters, the derived table
+// has only one table, so the joins are always empty.
+func (d *DerivedNode) pushEqualCmpr(joins []joinTuple) PlanNode {
+	return d
+}
+
+// calcRoute used to calc the route, the route of the inner select is calculated in buildQuery.
+func (d *DerivedNode) calcRoute() (PlanNode, error) {
+	return d, d.err
+}
+
+// pushSelectExprs used to push the select fields.
+func (d *DerivedNode) pushSelectExprs(fields, groups []selectTuple, sel *sqlparser.Select, hasAggregates bool) error {
+	d.fields = fields
+	var havings []sqlparser.Expr
+	if sel.Having != nil {
+		havings = splitAndExpression(nil, sel.Having.Expr)
+		hasAggregates = hasAggregates || hasAggregate(sel.Having.Expr)
+	}
+	if hasAggregates || len(groups) > 0 {
+		aggrPlan := NewAggregatePlan(d.log, sel.SelectExprs, fields, groups)
+		// The havings of the aggregated values are filtered after aggregating.
+		for _, having := range havings {
+			if having = skipParenthesis(having); aggrPlan.aggregated(having) {
+				aggrPlan.havings = append(aggrPlan.havings, having)
+			}
+		}
+		if err := aggrPlan.Build(); err != nil {
+			return err
+		}
+		d.children.Add(aggrPlan)
+		d.Scalar = len(groups) == 0
+	}
+
+	for _, tuple := range fields {
+		if tuple.field == "*" {
+			for i, col := range d.columns {
+				d.Fields = append(d.Fields, DerivedField{Name: col.name, Table: d.alias, Index: i})
+			}
+			continue
+		}
+		if tuple.aggrExpr {
+			return errors.Errorf("unsupported: '%s'.in.derived.table", tuple.field)
+		}
+		if tuple.aggrFuc == "" {
+			if _, err := d.pushField(tuple); err != nil {
+				return err
+			}
+			continue
+		}
+
+		aggrFuc := strings.ToLower(tuple.aggrFuc)
+		if _, ok := statAggrTypes[aggrFuc]; ok {
+			return errors.Errorf("unsupported: '%s'.in.derived.table", tuple.field)
+		}
+		args := aggregateArgs(&tuple)
+		if len(args) != 1 {
+			return errors.Errorf("unsupported: invalid.use.of.group.function[%s]", tuple.aggrFuc)
+		}
+		idx := -1
+		if arg, ok := args[0].(*sqlparser.AliasedExpr); ok {
+			var err error
+			if idx, err = d.pushColumn(arg.Expr); err != nil {
+				return err
+			}
+		}
+		// The partial aggregation of the distinct function is the value itself.
+		if typ, ok := distinctAggrTypes[aggrFuc]; ok && tuple.distinct {
+			d.Fields = append(d.Fields, DerivedField{Name: tuple.field, Index: idx, Aggr: typ})
+			continue
+		}
+		switch aggr := AggrType(strings.ToUpper(tuple.aggrFuc)); aggr {
+		case AggrTypeAvg:
+			d.Fields = append(d.Fields, DerivedField{Name: tuple.field, Index: idx, Aggr: AggrTypeSum})
+			d.Fields = append(d.Fields, DerivedField{Name: fmt.Sprintf("count(%s)", tuple.aggrField), Index: idx, Aggr: AggrTypeCount})
+		default:
+			d.Fields = append(d.Fields, DerivedField{Name: tuple.field, Index: idx, Aggr: aggr})
+		}
+	}
+	return nil
+}
+
+// pushSelectExpr used to push the select field, called by JoinNode.pushSelectExpr.
+func (d *DerivedNode) pushSelectExpr(field selectTuple) (int, error) {
+	if field.aggrFuc != "" {
+		return -1, errors.Errorf("unsupported: expr.'%s'.in.cross-shard.join", sqlparser.String(field.expr))
+	}
+	if _, err := d.pushField(field); err != nil {
+		return -1, err
+	}
+	d.fields = append(d.fields, field)
+	return len(d.fields) - 1, nil
+}
+
+// pushField pushes the non-aggregate field to the returned fields.
+func (d *DerivedNode) pushField(field selectTuple) (int, error) {
+	idx, err := d.pushColumn(field.expr.(*sqlparser.AliasedExpr).Expr)
+	if err != nil {
+		return -1, err
+	}
+	d.Fields = append(d.Fields, DerivedField{Name: field.field, Table: d.alias, Index: idx})
+	return len(d.Fields) - 1, nil
+}
+
+// pushColumn returns the index of the expr in the inner result, the column of the
+// derived table is used directly, the other expr is rewritten and appended to the inner select.
+func (d *DerivedNode) pushColumn(expr sqlparser.Expr) (int, error) {
+	if col, ok := expr.(*sqlparser.ColName); ok {
+		table := col.Qualifier.Name.String()
+		if idx, ok := d.column(col.Name.String()); ok && (table == "" || table == d.alias) {
+			return idx, nil
+		}
+		return -1, errors.Errorf("unsupported: unknown.column.'%s'.in.derived.table", sqlparser.String(col))
+	}
+
+	// The new column changes the result of the distinct.
+	if d.sel.Distinct != "" {
+		return -1, errors.Errorf("unsupported: '%s'.cant.be.pushed.into.derived.table.'%s'", sqlparser.String(expr), d.alias)
+	}
+	rewritten, err := d.rewrite(expr, func(col *derivedColumn) bool { return true })
+	if err != nil {
+		return -1, err
+	}
+	d.sel.SelectExprs = append(d.sel.SelectExprs, &sqlparser.AliasedExpr{
+		Expr: rewritten,
+		As:   sqlparser.NewColIdent(fmt.Sprintf("tmpd_%d", len(d.sel.SelectExprs)-len(d.columns))),
+	})
+	return len(d.sel.SelectExprs) - 1, nil
+}
+
+// pushHaving used to push having exprs, the havings of the aggregated values are filtered
+// by the AggregatePlan, the others filter the rows of the derived table as the where filters.
+func (d *DerivedNode) pushHaving(havings []filterTuple) error {
+	aggrPlan := d.aggregatePlan()
+	for _, filter := range havings {
+		if aggrPlan != nil && aggrPlan.aggregated(filter.expr) {
+			continue
+		}
+		d.setWhereFilter(filter.expr)
+	}
+	return d.err
+}
+
+// aggregatePlan returns the AggregatePlan of the children, nil if there's none.
+func (d *DerivedNode) aggregatePlan() *AggregatePlan {
+	for _, plan := range d.children.Plans() {
+		if aggrPlan, ok := plan.(*AggregatePlan); ok {
+			return aggrPlan
+		}
+	}
+	return nil
+}
+
+// pushOrderBy used to push the order by exprs.
+func (d *DerivedNode) pushOrderBy(sel *sqlparser.Select, fields []selectTuple) error {
+	if len(sel.OrderBy) == 0 {
+		for _, by := range sel.GroupBy {
+			sel.OrderBy = append(sel.OrderBy, &sqlparser.Order{
+				Expr:      by,
+				Direction: sqlparser.AscScr,
+			})
+		}
+	}
+
+	if len(sel.OrderBy) > 0 {
+		orderPlan := NewOrderByPlan(d.log, sel, fields, d.referredTables)
+		if err := orderPlan.Build(); err != nil {
+			return err
+		}
+		d.children.Add(orderPlan)
+	}
+	return nil
+}
+
+// pushLimit used to push limit.
+func (d *DerivedNode) pushLimit(sel *sqlparser.Select) error {
+	limitPlan := NewLimitPlan(d.log, sel)
+	if err := limitPlan.Build(); err != nil {
+		return err
+	}
+	d.children.Add(limitPlan)
+	return nil
+}
+
+// pushMisc used tp push miscelleaneous constructs.
+func (d *DerivedNode) pushMisc(sel *sqlparser.Select) {
+	d.sel.Lock = sel.Lock
+}
+
+// Children returns the children of the plan.
+func (d *DerivedNode) Children() *PlanTree {
+	return d.children
+}
+
+// buildQuery used to build the plan of the inner select.
+func (d *DerivedNode) buildQuery() error {
+	if d.err != nil {
+		return d.err
+	}
+	for _, filter := range d.filters {
+		d.sel.AddWhere(filter)
+	}
+	d.Plan = NewSelectPlan(d.log, d.database, sqlparser.String(d.sel), d.sel, d.router)
+	return d.Plan.Build()
+}
+
+// GetQuery used to get the Querys.
+func (d *DerivedNode) GetQuery() []xcontext.QueryTuple {
+	if d.Plan == nil || d.Plan.Root == nil {
+		return nil
+	}
+	return d.Plan.Root.GetQuery()
+}
+
+// hasAggregate returns true if the expr contains the aggregate function.
+func hasAggregate(expr sqlparser.Expr) bool {
+	has := false
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (kontinue bool, err error) {
+		switch node := node.(type) {
+		case *sqlparser.FuncExpr:
+			if node.IsAggregate() {
+				has = true
+				return false, errors.New("dummy")
+			}
+		case *sqlparser.GroupConcatExpr:
+			has = true
+			return false, errors.New("dummy")
+		case *sqlparser.Subquery:
+			return false, nil
+		}
+		return true, nil
+	}, expr)
+	return has
+}
@@ -0,0 +1,207 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package planner
+
+import (
+	"testing"
+
+	"router"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+func TestDerivedNodePlan(t *testing.T) {
+	querys := []string{
+		"select t.a, t.b from (select a, b from A where id=1) as t",
+		"select * from (select id, a+1 as b from A) t where t.id=1 and t.b>2",
+		"select t.a, t.cnt from (select a, count(*) as cnt from A group by a) t where t.a=3",
+		"select t.a+1, sum(t.b*2) from (select a, b from A where id=1) t",
+		"select t.a, count(*), avg(t.b) from (select a, b from A where id=1) t group by t.a order by t.a limit 1",
+	}
+	wants := []string{
+		"select a, b from sbtest.A6 as A where id = 1",
+		"select id, a + 1 as b from sbtest.A6 as A where id = 1 and (a + 1) > 2",
+		"select a, count(*) as cnt from sbtest.A1 as A where a = 3 group by a order by a asc",
+		"select a, b, a + 1 as tmpd_0, b * 2 as tmpd_1 from sbtest.A6 as A where id = 1",
+		"select a, b from sbtest.A6 as A where id = 1",
+	}
+	fields := [][]DerivedField{
+		{{Name: "a", Table: "t", Index: 0}, {Name: "b", Table: "t", Index: 1}},
+		{{Name: "id", Table: "t", Index: 0}, {Name: "b", Table: "t", Index: 1}},
+		{{Name: "a", Table: "t", Index: 0}, {Name: "cnt", Table: "t", Index: 1}},
+		{{Name: "t.a + 1", Table: "t", Index: 2}, {Name: "sum(t.b * 2)", Index: 3, Aggr: AggrTypeSum}},
+		{
+			{Name: "a", Table: "t", Index: 0},
+			{Name: "count(*)", Index: -1, Aggr: AggrTypeCount},
+			{Name: "avg(t.b)", Index: 1, Aggr: AggrTypeSum},
+			{Name: "count(t.b)", Index: 1, Aggr: AggrTypeCount},
+		},
+	}
+	scalars := []bool{false, false, false, true, false}
+	children := []int{0, 0, 0, 1, 3}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+	err := route.AddForTest(database, router.MockTableMConfig(), router.MockTableBConfig(), router.MockTableGConfig())
+	assert.Nil(t, err)
+
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		d, ok := plan.Root.(*DerivedNode)
+		assert.True(t, ok)
+		assert.Equal(t, wants[i], plan.Root.GetQuery()[0].Query)
+		assert.Equal(t, fields[i], d.Fields)
+		assert.Equal(t, scalars[i], d.Scalar)
+		assert.Equal(t, children[i], len(d.Children().Plans()))
+	}
+
+	// The outer exprs aren't changed by the rewriting.
+	{
+		query := "select t.id from (select id, a+1 as b from A) t where t.id=1 and t.b>2"
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		sel := node.(*sqlparser.Select)
+		plan := NewSelectPlan(log, database, query, sel, route)
+		err = plan.Build()
+		assert.Nil(t, err)
+		assert.Equal(t, " where t.id = 1 and t.b > 2", sqlparser.String(sel.Where))
+		assert.Equal(t, "select id, a + 1 as b from sbtest.A6 as A where id = 1 and (a + 1) > 2", plan.Root.GetQuery()[0].Query)
+	}
+}
+
+func TestDerivedNodeFilter(t *testing.T) {
+	querys := []string{
+		"select * from (select a from A limit 10) t where t.a=1",
+		"select * from (select a, count(*) as c from A group by a) t where t.a=1 and t.c>1",
+		"select * from (select count(*) as c from A) t where 1=0",
+		"select t.a from (select a from A) t group by t.a having t.a>1",
+		"select t.a, count(*) from (select a from A limit 10) t group by t.a having count(*)>1 and t.a>2",
+	}
+	wants := []string{
+		"select a from sbtest.A1 as A limit 10",
+		"select a, count(*) as c from sbtest.A1 as A where a = 1 group by a order by a asc",
+		"select count(*) as c from sbtest.A1 as A",
+		"select a from sbtest.A1 as A where a > 1",
+		"select a from sbtest.A1 as A limit 10",
+	}
+	// filters is the count of the filters evaluated in the proxy.
+	filters := []int{1, 1, 1, 0, 1}
+	havings := []int{0, 0, 0, 0, 1}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+	err := route.AddForTest(database, router.MockTableMConfig(), router.MockTableBConfig(), router.MockTableGConfig())
+	assert.Nil(t, err)
+
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+		err = plan.Build()
+		assert.Nil(t, err, query)
+
+		d, ok := plan.Root.(*DerivedNode)
+		assert.True(t, ok)
+		assert.Equal(t, wants[i], plan.Root.GetQuery()[0].Query, query)
+		assert.Equal(t, filters[i], len(d.Filters), query)
+		n := 0
+		if aggrPlan := d.aggregatePlan(); aggrPlan != nil {
+			n = len(aggrPlan.Havings())
+		}
+		assert.Equal(t, havings[i], n, query)
+	}
+}
+
+func TestDerivedNodeJoin(t *testing.T) {
+	querys := []string{
+		"select A.id, t.b from A join (select id, b from B) t on A.id=t.id where t.b=1",
+		"select A.id, t.b from A left join (select id, b from B) t on A.id=t.id and t.b=2 where A.id=1",
+		"select A.id from A join (select a from B) t on A.id=t.a where A.a=t.a+1",
+	}
+	wants := [][]string{
+		{"select A.id from sbtest.A1 as A order by A.id asc", "select id, b from sbtest.B1 as B where b = 1"},
+		{"select A.id from sbtest.A6 as A where A.id = 1 order by A.id asc", "select id, b from sbtest.B1 as B where b = 2 and id = 1"},
+		{"select A.id, A.a as tmpo_0 from sbtest.A1 as A order by A.id asc", "select a, a + 1 as tmpd_0 from sbtest.B1 as B"},
+	}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+	err := route.AddForTest(database, router.MockTableMConfig(), router.MockTableBConfig(), router.MockTableGConfig())
+	assert.Nil(t, err)
+
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		j, ok := plan.Root.(*JoinNode)
+		assert.True(t, ok)
+		_, ok = j.Right.(*DerivedNode)
+		assert.True(t, ok)
+		querys := plan.Root.GetQuery()
+		assert.Equal(t, wants[i][0], querys[0].Query)
+		assert.Equal(t, wants[i][1], querys[len(querys)-1].Query)
+	}
+}
+
+func TestDerivedNodeError(t *testing.T) {
+	querys := []string{
+		"select * from (select a from A union select a from B) t",
+		"select * from (select * from A) t",
+		"select * from (select a, b as a from A) t",
+		"select t.x from (select a from A) t",
+		"select * from (select a from A limit 10) t where t.x=1",
+		"select * from (select a from A where A.id=B.id) t",
+		"select t.a+1 from (select distinct a from A) t",
+		"select count(t.a) from A join (select a from B) t on A.id=t.a",
+		"select stddev(t.a) from (select a from A) t",
+	}
+	wants := []string{
+		"unsupported: union.in.derived.table",
+		"unsupported: '*'.expression.in.derived.table",
+		"duplicate.column.name.'a'.in.derived.table",
+		"unsupported: unknown.column.'t.x'.in.derived.table",
+		"unsupported: unknown.column.'t.x'.in.derived.table",
+		"unsupported: correlated.subquery.column[B.id]",
+		"unsupported: 't.a + 1'.cant.be.pushed.into.derived.table.'t'",
+		"unsupported: cross-shard.query.with.aggregates",
+		"unsupported: 'stddev(t.a)'.in.derived.table",
+	}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+	err := route.AddForTest(database, router.MockTableMConfig(), router.MockTableBConfig(), router.MockTableGConfig())
+	assert.Nil(t, err)
+
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+		err = plan.Build()
+		assert.NotNil(t, err)
+		assert.Equal(t, wants[i], err.Error())
+	}
+}
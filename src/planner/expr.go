@@ -10,35 +10,151 @@ package planner
 
 import (
 	"router"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/xelabs/go-mysqlstack/sqlparser"
 )
 
 // getDMLRouting used to get the routing from the where clause.
-func getDMLRouting(database, table, shardkey string, where *sqlparser.Where, router *router.Router) ([]router.Segment, error) {
+// The composite shardkey routes only if all the columns are bound by the equal filters.
+// If the routing comes from the 'IN' list, the shardKeyIn is returned to rewrite the list.
+func getDMLRouting(database, table, shardkey string, where *sqlparser.Where, route *router.Router) ([]router.Segment, *shardKeyIn, error) {
+	var err error
+	var start, end *sqlparser.SQLVal
+	var inExpr *sqlparser.ComparisonExpr
+	var inVals []*sqlparser.SQLVal
 	if shardkey != "" && where != nil {
+		keys := router.ShardKeys(shardkey)
+		vals := make([]*sqlparser.SQLVal, len(keys))
+		bound := 0
 		filters := splitAndExpression(nil, where.Expr)
 		for _, filter := range filters {
 			filter = skipParenthesis(filter)
 			comparison, ok := filter.(*sqlparser.ComparisonExpr)
-			if !ok {
-				continue
+			if ok {
+				// Equal statement.
+				switch comparison.Operator {
+				case sqlparser.EqualStr:
+					for i, key := range keys {
+						if vals[i] == nil && nameMatch(comparison.Left, table, key) {
+							if sqlval, ok := comparison.Right.(*sqlparser.SQLVal); ok {
+								vals[i] = sqlval
+								bound++
+							}
+						}
+					}
+				}
 			}
 
-			// Only deal with Equal statement.
-			switch comparison.Operator {
-			case sqlparser.EqualStr:
-				if nameMatch(comparison.Left, table, shardkey) {
-					sqlval, ok := comparison.Right.(*sqlparser.SQLVal)
-					if ok {
-						return router.Lookup(database, table, sqlval, sqlval)
-					}
+			// In or Or statement.
+			if inVals == nil {
+				inExpr, inVals = getShardKeyValues(filter, table, shardkey)
+				if inExpr != nil && !replaceShardKeyOr(where, filter, inExpr) {
+					inExpr = nil
 				}
 			}
+
+			// Range statement.
+			s, e := getShardKeyBound(filter, table, shardkey)
+			if start, err = tighterBound(route, database, table, start, s, true); err != nil {
+				return nil, nil, err
+			}
+			if end, err = tighterBound(route, database, table, end, e, false); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		if bound == len(keys) {
+			types, err := route.ShardKeyTypes(database, table)
+			if err != nil {
+				return nil, nil, err
+			}
+			sqlval, err := router.CompositeKey(vals, types)
+			if err != nil {
+				return nil, nil, err
+			}
+			segments, err := route.Lookup(database, table, sqlval, sqlval)
+			return segments, nil, err
+		}
+		if inVals != nil {
+			in, err := newShardKeyIn(database, table, inExpr, inVals, route)
+			if err != nil {
+				return nil, nil, err
+			}
+			return in.segments, in, nil
 		}
 	}
-	return router.Lookup(database, table, nil, nil)
+	if start != nil && end != nil && start.Type != end.Type {
+		start, end = nil, nil
+	}
+	segments, err := route.Lookup(database, table, start, end)
+	return segments, nil, err
+}
+
+// getShardKeyBound used to get the shard key interval [start, end] from the filter,
+// such as: 'id>1', '10>=id', 'id between 1 and 10', nil means unbounded.
+func getShardKeyBound(filter sqlparser.Expr, table, shardkey string) (*sqlparser.SQLVal, *sqlparser.SQLVal) {
+	switch filter := filter.(type) {
+	case *sqlparser.ComparisonExpr:
+		left, right, op := filter.Left, filter.Right, filter.Operator
+		if !nameMatch(left, table, shardkey) {
+			// Reverse the '10>=id' to 'id<=10'.
+			left, right = right, left
+			switch op {
+			case sqlparser.LessThanStr:
+				op = sqlparser.GreaterThanStr
+			case sqlparser.LessEqualStr:
+				op = sqlparser.GreaterEqualStr
+			case sqlparser.GreaterThanStr:
+				op = sqlparser.LessThanStr
+			case sqlparser.GreaterEqualStr:
+				op = sqlparser.LessEqualStr
+			}
+		}
+		if !nameMatch(left, table, shardkey) {
+			return nil, nil
+		}
+		sqlval, ok := right.(*sqlparser.SQLVal)
+		if !ok {
+			return nil, nil
+		}
+		switch op {
+		case sqlparser.GreaterThanStr, sqlparser.GreaterEqualStr:
+			return sqlval, nil
+		case sqlparser.LessThanStr, sqlparser.LessEqualStr:
+			return nil, sqlval
+		}
+	case *sqlparser.RangeCond:
+		if filter.Operator != sqlparser.BetweenStr || !nameMatch(filter.Left, table, shardkey) {
+			return nil, nil
+		}
+		from, fok := filter.From.(*sqlparser.SQLVal)
+		to, tok := filter.To.(*sqlparser.SQLVal)
+		if fok && tok && from.Type == to.Type {
+			return from, to
+		}
+	}
+	return nil, nil
+}
+
+// tighterBound used to pick the tighter one of the shard key bounds, the greater start or
+// the less end. The bounds of the partitions whose keys aren't ordered are not compared.
+func tighterBound(route *router.Router, database, table string, old, new *sqlparser.SQLVal, isStart bool) (*sqlparser.SQLVal, error) {
+	if new == nil {
+		return old, nil
+	}
+	if old == nil {
+		return new, nil
+	}
+	cmp, ok, err := route.CompareKey(database, table, old, new)
+	if err != nil || !ok {
+		return new, err
+	}
+	if (isStart && cmp >= 0) || (!isStart && cmp <= 0) {
+		return old, nil
+	}
+	return new, nil
 }
 
 func hasSubquery(node sqlparser.SQLNode) bool {
@@ -62,7 +178,17 @@ func nameMatch(node sqlparser.Expr, table, shardkey string) bool {
 // expressions modify a shardkey column.
 func isShardKeyChanging(exprs sqlparser.UpdateExprs, shardkey string) bool {
 	for _, assignment := range exprs {
-		if shardkey == assignment.Name.Name.String() {
+		if isShardKeyColumn(assignment.Name.Name.String(), shardkey) {
+			return true
+		}
+	}
+	return false
+}
+
+// isShardKeyColumn returns true if the col is one column of the shardkey.
+func isShardKeyColumn(col, shardkey string) bool {
+	for _, key := range router.ShardKeys(shardkey) {
+		if key == col {
 			return true
 		}
 	}
@@ -114,12 +240,13 @@ func checkComparison(expr sqlparser.Expr) error {
 	return nil
 }
 
-// For example: select count(*), count(distinct x.a) as cstar, max(x.a) as mb, t.a as a1, x.b from t,x group by a1,b
+// For example: select count(*), count(distinct x.a) as cstar, max(x.a) as mb, t.a as a1, x.b, sum(x.a)/count(x.b) from t,x group by a1,b
 // {field:count(*) referTables:{}   aggrFuc:count  aggrField:*   distinct:false}
 // {field:cstar    referTables:{x}  aggrFuc:count  aggrField:*   distinct:true}
 // {field:mb       referTables:{x}  aggrFuc:max    aggrField:x.a distinct:false}
 // {field:a1       referTables:{t}  aggrFuc:}
 // {field:b      referTables:{x}  aggrFuc:}
+// {field:sum(x.a) / count(x.b) referTables:{x}  aggrFuc:  aggrExpr:true}
 type selectTuple struct {
 	//select expression
 	expr sqlparser.SelectExpr
@@ -132,6 +259,8 @@ type selectTuple struct {
 	//field in the aggregate function
 	aggrField string
 	distinct  bool
+	//the expression contains the aggregate functions, computed after merging
+	aggrExpr bool
 }
 
 // parserSelectExpr parses the AliasedExpr to select tuple.
@@ -139,6 +268,7 @@ func parserSelectExpr(expr *sqlparser.AliasedExpr, tbInfos map[string]*TableInfo
 	funcName := ""
 	aggrField := ""
 	distinct := false
+	aggrExpr := false
 	hasAggregates := false
 	referTables := make([]string, 0, 4)
 
@@ -174,26 +304,21 @@ func parserSelectExpr(expr *sqlparser.AliasedExpr, tbInfos map[string]*TableInfo
 				}
 			}
 			referTables = append(referTables, tableName)
-		case *sqlparser.FuncExpr:
-			distinct = node.Distinct
-			if node.IsAggregate() {
-				hasAggregates = true
-				if node != expr.Expr {
-					return false, errors.Errorf("unsupported: '%s'.contain.aggregate.in.select.exprs", field)
-				}
-				funcName = node.Name.String()
-				if len(node.Exprs) != 1 {
-					return false, errors.Errorf("unsupported: invalid.use.of.group.function[%s]", funcName)
-				}
-				buf := sqlparser.NewTrackedBuffer(nil)
-				node.Exprs.Format(buf)
-				aggrField = buf.String()
-				if aggrField == "*" && node.Name.String() != "count" {
-					return false, errors.Errorf("unsupported: syntax.error.at.'%s'", field)
-				}
+		case *sqlparser.FuncExpr, *sqlparser.GroupConcatExpr:
+			if fn, ok := node.(*sqlparser.FuncExpr); ok && !fn.IsAggregate() {
+				return true, nil
 			}
-		case *sqlparser.GroupConcatExpr:
-			return false, errors.Errorf("unsupported: group_concat.in.select.exprs")
+			hasAggregates = true
+			name, arg, dist, err := aggregateInfo(node.(sqlparser.Expr), field)
+			if err != nil {
+				return false, err
+			}
+			// The aggregate functions in the expression are computed by the AggregatePlan.
+			if node != expr.Expr {
+				aggrExpr = true
+				return true, nil
+			}
+			funcName, aggrField, distinct = name, arg, dist
 		case *sqlparser.Subquery:
 			return false, errors.Errorf("unsupported: subqueries.in.select.exprs")
 		}
@@ -203,7 +328,43 @@ func parserSelectExpr(expr *sqlparser.AliasedExpr, tbInfos map[string]*TableInfo
 		return nil, hasAggregates, err
 	}
 
-	return &selectTuple{expr, field, referTables, funcName, aggrField, distinct}, hasAggregates, nil
+	return &selectTuple{expr, field, referTables, funcName, aggrField, distinct, aggrExpr}, hasAggregates, nil
+}
+
+// aggregateInfo returns the function name, the argument and the distinct of the aggregate function.
+func aggregateInfo(node sqlparser.Expr, field string) (string, string, bool, error) {
+	var funcName string
+	var distinct bool
+	var args sqlparser.SelectExprs
+	switch node := node.(type) {
+	case *sqlparser.FuncExpr:
+		funcName = node.Name.String()
+		distinct = node.Distinct
+		args = node.Exprs
+		if len(args) != 1 {
+			return "", "", false, errors.Errorf("unsupported: invalid.use.of.group.function[%s]", funcName)
+		}
+	case *sqlparser.GroupConcatExpr:
+		if len(node.OrderBy) > 0 {
+			return "", "", false, errors.Errorf("unsupported: order.by.in.group_concat")
+		}
+		funcName = "group_concat"
+		distinct = node.Distinct != ""
+		args = node.Exprs
+	}
+	for _, arg := range args {
+		if aliased, ok := arg.(*sqlparser.AliasedExpr); ok && hasAggregate(aliased.Expr) {
+			return "", "", false, errors.Errorf("unsupported: invalid.use.of.group.function[%s]", funcName)
+		}
+	}
+
+	buf := sqlparser.NewTrackedBuffer(nil)
+	args.Format(buf)
+	aggrField := buf.String()
+	if aggrField == "*" && funcName != "count" {
+		return "", "", false, errors.Errorf("unsupported: syntax.error.at.'%s'", field)
+	}
+	return funcName, aggrField, distinct, nil
 }
 
 func parserSelectExprs(exprs sqlparser.SelectExprs, root PlanNode) ([]selectTuple, bool, error) {
@@ -222,7 +383,7 @@ func parserSelectExprs(exprs sqlparser.SelectExprs, root PlanNode) ([]selectTupl
 			}
 			tuples = append(tuples, *tuple)
 		case *sqlparser.StarExpr:
-			if _, ok := root.(*MergeNode); !ok {
+			if _, ok := root.(*JoinNode); ok {
 				return nil, false, errors.New("unsupported: '*'.expression.in.cross-shard.query")
 			}
 			tuple := selectTuple{expr: exp, field: "*"}
@@ -306,6 +467,41 @@ func decomposeAvg(tuple *selectTuple) []*sqlparser.AliasedExpr {
 	return ret
 }
 
+// decomposeStat decomposes the std(a), variance(a) and the like to var_pop(a), count(a) and avg(a),
+// the variances of the backends are merged by the count and the mean in the proxy.
+func decomposeStat(tuple *selectTuple) []*sqlparser.AliasedExpr {
+	newFunc := func(name string) *sqlparser.FuncExpr {
+		return &sqlparser.FuncExpr{
+			Name:  sqlparser.NewColIdent(name),
+			Exprs: []sqlparser.SelectExpr{&sqlparser.AliasedExpr{Expr: sqlparser.NewValArg([]byte(tuple.aggrField))}},
+		}
+	}
+	variance := &sqlparser.AliasedExpr{Expr: newFunc("var_pop"), As: sqlparser.NewColIdent(tuple.field)}
+	count := &sqlparser.AliasedExpr{Expr: newFunc("count")}
+	avg := &sqlparser.AliasedExpr{Expr: newFunc("avg")}
+	return []*sqlparser.AliasedExpr{variance, count, avg}
+}
+
+// aggregateArgs returns the arguments of the aggregate function.
+func aggregateArgs(tuple *selectTuple) sqlparser.SelectExprs {
+	switch fn := tuple.expr.(*sqlparser.AliasedExpr).Expr.(type) {
+	case *sqlparser.FuncExpr:
+		return fn.Exprs
+	case *sqlparser.GroupConcatExpr:
+		return fn.Exprs
+	}
+	return nil
+}
+
+// groupConcatSeparator returns the separator of the group_concat, default is ','.
+func groupConcatSeparator(tuple *selectTuple) string {
+	if fn, ok := tuple.expr.(*sqlparser.AliasedExpr).Expr.(*sqlparser.GroupConcatExpr); ok && fn.Separator != "" {
+		sep := strings.TrimPrefix(fn.Separator, " separator '")
+		return strings.TrimSuffix(sep, "'")
+	}
+	return ","
+}
+
 // convertToLeftJoin converts a right join into a left join.
 func convertToLeftJoin(joinExpr *sqlparser.JoinTableExpr) {
 	newExpr := joinExpr.LeftExpr
@@ -534,6 +730,10 @@ func checkShard(table, col string, tbInfos map[string]*TableInfo, router *router
 	if !ok {
 		return false, errors.Errorf("unsupported: unknown.column.'%s.%s'.in.field.list", table, col)
 	}
+	// The derived table has no shardkey.
+	if tbInfo.derived != nil {
+		return false, nil
+	}
 
 	shardkey, err := router.ShardKey(tbInfo.database, tbInfo.tableName)
 	if err != nil {
@@ -574,12 +774,6 @@ func parserHaving(exprs sqlparser.Expr, tbInfos map[string]*TableInfo) ([]filter
 					}
 				}
 				referTables = append(referTables, tableName)
-			case *sqlparser.FuncExpr:
-				if node.IsAggregate() {
-					buf := sqlparser.NewTrackedBuffer(nil)
-					node.Format(buf)
-					return false, errors.Errorf("unsupported: expr[%s].in.having.clause", buf.String())
-				}
 			}
 			return true, nil
 		}, filter)
@@ -594,6 +788,22 @@ func parserHaving(exprs sqlparser.Expr, tbInfos map[string]*TableInfo) ([]filter
 	return tuples, nil
 }
 
+// checkHavingAggregate used to check the having filter can be pushed down,
+// the aggregate functions only be computed by the AggregatePlan.
+func checkHavingAggregate(filter sqlparser.Expr) error {
+	return sqlparser.Walk(func(node sqlparser.SQLNode) (kontinue bool, err error) {
+		switch node := node.(type) {
+		case *sqlparser.FuncExpr:
+			if node.IsAggregate() {
+				return false, errors.Errorf("unsupported: expr[%s].in.having.clause", sqlparser.String(node))
+			}
+		case *sqlparser.GroupConcatExpr:
+			return false, errors.Errorf("unsupported: expr[%s].in.having.clause", sqlparser.String(node))
+		}
+		return true, nil
+	}, filter)
+}
+
 type nullExpr struct {
 	expr sqlparser.Expr
 	// referred tables.
@@ -42,7 +42,161 @@ func TestGetDMLRouting(t *testing.T) {
 		node, err := sqlparser.Parse(query)
 		n := node.(*sqlparser.Select)
 		assert.Nil(t, err)
-		got, err := getDMLRouting(database, "B", "id", n.Where, route)
+		got, _, err := getDMLRouting(database, "B", "id", n.Where, route)
+		assert.Nil(t, err)
+		assert.Equal(t, want[i], len(got))
+	}
+}
+
+func TestGetDMLRoutingRange(t *testing.T) {
+	querys := []string{
+		"select * from R where id = 150",
+		"select * from R where id > 150",
+		"select * from R where 99 >= id",
+		"select * from R where id between 50 and 150",
+		"select * from R where id >= 50 and id < 150",
+		"select * from R where id > 50 and id < 'x'",
+		"select * from R where b > 10",
+		// The tightest bounds are kept.
+		"select * from R where id > 150 and id > 10",
+		"select * from R where id < 90 and 250 >= id",
+		"select * from R where id >= 120 and id < 150 and id between 10 and 250",
+	}
+
+	want := []int{
+		1,
+		2,
+		1,
+		2,
+		2,
+		3,
+		3,
+		2,
+		1,
+		1,
+	}
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableRConfig())
+	assert.Nil(t, err)
+
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		n := node.(*sqlparser.Select)
+		assert.Nil(t, err)
+		got, _, err := getDMLRouting(database, "R", "id", n.Where, route)
+		assert.Nil(t, err)
+		assert.Equal(t, want[i], len(got))
+	}
+}
+
+func TestGetDMLRoutingList(t *testing.T) {
+	querys := []string{
+		"select * from L where id = 'cn'",
+		"select * from L where id = 'uk'",
+		"select * from L where id > 1",
+	}
+
+	want := []int{
+		1,
+		1,
+		3,
+	}
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableLConfig())
+	assert.Nil(t, err)
+
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		n := node.(*sqlparser.Select)
+		assert.Nil(t, err)
+		got, _, err := getDMLRouting(database, "L", "id", n.Where, route)
+		assert.Nil(t, err)
+		assert.Equal(t, want[i], len(got))
+	}
+}
+
+func TestGetDMLRoutingTime(t *testing.T) {
+	querys := []string{
+		"select * from T where dt = '2019-02-10 10:00:00'",
+		"select * from T where dt >= '2019-02-01' and dt < '2019-03-01'",
+		"select * from T where dt between '2019-01-15' and '2019-04-15'",
+		"select * from T where dt > '2019-02-28'",
+		"select * from T where dt in ('2019-01-01', '2019-04-02')",
+		"select * from T where id = 1",
+		// The keys out of the periods get the empty route.
+		"select * from T where dt = '2019-03-10'",
+		"select * from T where dt in ('2018-12-01', '2019-03-02', '2019-06-01')",
+		"select * from T where dt > '2019-02-28' and dt > '2019-01-10'",
+	}
+
+	want := []int{
+		1,
+		1,
+		3,
+		2,
+		2,
+		3,
+		1,
+		2,
+		2,
+	}
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableTConfig())
+	assert.Nil(t, err)
+
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		n := node.(*sqlparser.Select)
+		assert.Nil(t, err)
+		got, _, err := getDMLRouting(database, "T", "dt", n.Where, route)
+		assert.Nil(t, err)
+		assert.Equal(t, want[i], len(got), query)
+	}
+}
+
+func TestGetDMLRoutingCompositeKey(t *testing.T) {
+	querys := []string{
+		"select * from C where a = 1 and b = 'x'",
+		"select * from C where C.b = 'x' and (C.a = 1)",
+		"select * from C where a = 1",
+		"select * from C where a = 1 or b = 'x'",
+	}
+
+	want := []int{
+		1,
+		1,
+		2,
+		2,
+	}
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableCConfig())
+	assert.Nil(t, err)
+
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		n := node.(*sqlparser.Select)
+		assert.Nil(t, err)
+		got, _, err := getDMLRouting(database, "C", "a,b", n.Where, route)
 		assert.Nil(t, err)
 		assert.Equal(t, want[i], len(got))
 	}
@@ -142,15 +296,11 @@ func TestWhereFilters(t *testing.T) {
 	}
 }
 
-func TestWhereFiltersError(t *testing.T) {
+func TestWhereFiltersCrossJoin(t *testing.T) {
 	querys := []string{
 		"select * from G,A,B where A.id=B.id and A.a + B.a > 0",
 		"select * from A join B on A.id=B.id join G on G.id=A.id where A.a + B.a > 0",
 	}
-	wants := []string{
-		"unsupported: clause.'A.a + B.a > 0'.in.cross-shard.join",
-		"unsupported: clause.'A.a + B.a > 0'.in.cross-shard.join",
-	}
 	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
 	database := "sbtest"
 
@@ -160,7 +310,7 @@ func TestWhereFiltersError(t *testing.T) {
 	err := route.AddForTest(database, router.MockTableMConfig(), router.MockTableBConfig(), router.MockTableGConfig())
 	assert.Nil(t, err)
 
-	for i, query := range querys {
+	for _, query := range querys {
 		node, err := sqlparser.Parse(query)
 		assert.Nil(t, err)
 		sel := node.(*sqlparser.Select)
@@ -179,8 +329,18 @@ func TestWhereFiltersError(t *testing.T) {
 		assert.Nil(t, err)
 
 		err = p.(*JoinNode).handleOthers()
-		got := err.Error()
-		assert.Equal(t, wants[i], got)
+		assert.Nil(t, err)
+
+		// The filter is computed in the join node of A and B.
+		jn := p.(*JoinNode)
+		if left, ok := jn.Left.(*JoinNode); ok {
+			jn = left
+		} else if right, ok := jn.Right.(*JoinNode); ok {
+			jn = right
+		}
+		assert.Equal(t, 1, len(jn.Filters))
+		assert.Equal(t, "A.a + B.a > 0", jn.Filters[0].Field)
+		assert.Equal(t, 2, len(jn.Filters[0].Cols))
 	}
 }
 
@@ -379,13 +539,13 @@ func TestSelectExprs(t *testing.T) {
 func TestSelectExprsError(t *testing.T) {
 	querys := []string{
 		"select sum(A.id) as s, G.a as a from A,G group by s",
-		"select A.id,G.a as a, concat(B.str,G.str), 1 from A,B, A as G group by a",
-		"select A.id,G.a as a, concat(A.str,B.str) from A join B on A.id=B.id join G on A.a=G.a",
+		"select A.id,G.a as a, rand(B.str)+G.str, 1 from A,B, A as G group by a",
+		"select A.id,G.a as a, md5(concat(A.str,B.str)) from A join B on A.id=B.id join G on A.a=G.a",
 	}
 	wants := []string{
 		"unsupported: group.by.field[s].should.be.in.noaggregate.select.list",
-		"unsupported: expr.'concat(B.str, G.str)'.in.cross-shard.join",
-		"unsupported: expr.'concat(A.str, B.str)'.in.cross-shard.join",
+		"unsupported: expr.'rand(B.str)'.in.evaluation",
+		"unsupported: expr.'md5(concat(A.str, B.str))'.in.evaluation",
 	}
 	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
 	database := "sbtest"
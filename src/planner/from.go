@@ -11,6 +11,7 @@ package planner
 import (
 	"config"
 	"router"
+	"strings"
 
 	"github.com/pkg/errors"
 	"github.com/xelabs/go-mysqlstack/sqlparser"
@@ -27,6 +28,10 @@ type TableInfo struct {
 	alias string
 	// table's shard key.
 	shardKey string
+	// columns of the shard key, more than one if the shard key is composite.
+	shardKeys []string
+	// values of the shard key columns bound by the equal filters.
+	keyVals []*sqlparser.SQLVal
 	// table's shard type.
 	shardType string
 	// table's config.
@@ -35,8 +40,22 @@ type TableInfo struct {
 	tableExpr *sqlparser.AliasedTableExpr
 	// table's route.
 	Segments []router.Segment `json:",omitempty"`
-	// table's parent node, the type always a MergeNode.
+	// shard key interval [start, end] from the range filters, nil means unbounded.
+	start, end *sqlparser.SQLVal
+	// shard key values from the 'IN' or 'OR' filter.
+	keyIn *shardKeyIn
+	// table's parent node, the type always a MergeNode, nil if the table is derived.
 	parent *MergeNode
+	// the derived table node.
+	derived *DerivedNode
+}
+
+// node returns the plan node which the table belongs to.
+func (t *TableInfo) node() PlanNode {
+	if t.derived != nil {
+		return t.derived
+	}
+	return t.parent
 }
 
 /* scanTableExprs analyzes the 'FROM' clause, build a plannode tree.
@@ -83,8 +102,12 @@ func scanTableExpr(log *xlog.Log, router *router.Router, database string, tableE
 }
 
 // scanAliasedTableExpr produces the table's TableInfo by the AliasedTableExpr, and build a MergeNode subtree.
+// The derived table builds a DerivedNode.
 func scanAliasedTableExpr(log *xlog.Log, r *router.Router, database string, tableExpr *sqlparser.AliasedTableExpr) (PlanNode, error) {
 	var err error
+	if _, ok := tableExpr.Expr.(*sqlparser.Subquery); ok {
+		return newDerivedNode(log, database, r, tableExpr)
+	}
 	mn := newMergeNode(log, database, r)
 	switch expr := tableExpr.Expr.(type) {
 	case sqlparser.TableName:
@@ -105,11 +128,12 @@ func scanAliasedTableExpr(log *xlog.Log, r *router.Router, database string, tabl
 			return nil, err
 		}
 		tn.shardKey = tn.tableConfig.ShardKey
+		tn.shardKeys = router.ShardKeys(tn.shardKey)
 		tn.shardType = tn.tableConfig.ShardType
 		tn.tableExpr = tableExpr
 
-		// if a shard table hasn't alias, create one in order to push.
-		if tn.tableConfig.ShardKey != "" {
+		// if a shard or single table hasn't alias, create one in order to push.
+		if tn.tableConfig.ShardKey != "" || tn.shardType == "SINGLE" {
 			if tableExpr.As.String() == "" {
 				tableExpr.As = sqlparser.NewTableIdent(tn.tableName)
 			}
@@ -123,8 +147,6 @@ func scanAliasedTableExpr(log *xlog.Log, r *router.Router, database string, tabl
 		} else {
 			mn.referredTables[tn.tableName] = tn
 		}
-	case *sqlparser.Subquery:
-		err = errors.New("unsupported: subquery.in.select")
 	}
 	mn.sel = &sqlparser.Select{From: sqlparser.TableExprs([]sqlparser.TableExpr{tableExpr})}
 	return mn, err
@@ -207,10 +229,12 @@ func join(log *xlog.Log, lpn, rpn PlanNode, joinExpr *sqlparser.JoinTableExpr, r
 				return mergeRoutes(lmn, rmn, joinExpr, otherJoinOn)
 			}
 			// if join on condition's cols are both shardkey, and the tables have same shards.
-			for _, jt := range joinOn {
-				if isSameShard(lmn.referredTables, rmn.referredTables, jt.left, jt.right) {
-					return mergeRoutes(lmn, rmn, joinExpr, otherJoinOn)
-				}
+			if isSameShard(lmn.referredTables, rmn.referredTables, joinOn) {
+				return mergeRoutes(lmn, rmn, joinExpr, otherJoinOn)
+			}
+			// if the tables are all single tables on the same backend.
+			if isSameSingle(lmn.referredTables, rmn.referredTables) {
+				return mergeRoutes(lmn, rmn, joinExpr, otherJoinOn)
 			}
 		}
 	}
@@ -255,19 +279,72 @@ func mergeRoutes(lmn, rmn *MergeNode, joinExpr *sqlparser.JoinTableExpr, otherJo
 	return lmn, err
 }
 
-// isSameShard used to judge lcn|rcn contain shardkey and have same shards.
-func isSameShard(ltb, rtb map[string]*TableInfo, lcn, rcn *sqlparser.ColName) bool {
-	lt := ltb[lcn.Qualifier.Name.String()]
-	if lt.shardKey != lcn.Name.String() {
-		return false
+// isSameShard used to judge the joinOn contain the shardkeys of two tables and
+// they have same shards. The columns of the composite shardkey must be all joined in order.
+func isSameShard(ltb, rtb map[string]*TableInfo, joinOn []joinTuple) bool {
+	for _, jt := range joinOn {
+		ltn, rtn := jt.left.Qualifier.Name.String(), jt.right.Qualifier.Name.String()
+		lt, rt := ltb[ltn], rtb[rtn]
+		if len(lt.shardKeys) == 0 || len(lt.shardKeys) != len(rt.shardKeys) {
+			continue
+		}
+		if isShardKeyJoined(ltn, rtn, lt.shardKeys, rt.shardKeys, joinOn) && isSamePartitions(lt.tableConfig, rt.tableConfig) {
+			return true
+		}
 	}
-	ltp := lt.tableConfig.Partitions
-	rt := rtb[rcn.Qualifier.Name.String()]
-	if rt.shardKey != rcn.Name.String() {
-		return false
+	return false
+}
+
+// isSameSingle returns true if all the non-global tables are single tables on the same backend.
+func isSameSingle(ltb, rtb map[string]*TableInfo) bool {
+	backend := ""
+	for _, tbs := range []map[string]*TableInfo{ltb, rtb} {
+		for _, tb := range tbs {
+			switch tb.shardType {
+			case "GLOBAL":
+				continue
+			case "SINGLE":
+				part := tb.tableConfig.Partitions[0]
+				if backend != "" && backend != part.Backend {
+					return false
+				}
+				backend = part.Backend
+			default:
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// isShardKeyJoined returns true if every shardkey column of the left table is
+// joined with the shardkey column of the right table in the same position.
+func isShardKeyJoined(ltn, rtn string, lkeys, rkeys []string, joinOn []joinTuple) bool {
+	for i, lkey := range lkeys {
+		joined := false
+		for _, jt := range joinOn {
+			if jt.left.Qualifier.Name.String() != ltn || jt.right.Qualifier.Name.String() != rtn {
+				continue
+			}
+			if jt.left.Name.String() == lkey && jt.right.Name.String() == rkeys[i] {
+				joined = true
+				break
+			}
+		}
+		if !joined {
+			return false
+		}
 	}
-	rtp := rt.tableConfig.Partitions
+	return true
+}
 
+// isSamePartitions returns true if the two tables have the same shards, and the same
+// shard key values are routed to the same shard.
+func isSamePartitions(lconf, rconf *config.TableConfig) bool {
+	if !router.SameRouting(lconf, rconf) {
+		return false
+	}
+	ltp, rtp := lconf.Partitions, rconf.Partitions
 	if len(ltp) != len(rtp) {
 		return false
 	}
@@ -275,6 +352,34 @@ func isSameShard(ltb, rtb map[string]*TableInfo, lcn, rcn *sqlparser.ColName) bo
 		if lpart.Segment != rtp[i].Segment || lpart.Backend != rtp[i].Backend {
 			return false
 		}
+		if strings.Join(lpart.Values, ",") != strings.Join(rtp[i].Values, ",") {
+			return false
+		}
 	}
 	return true
 }
+
+// bindShardKey binds the value to the shardkey column, returns the value to route
+// if all the columns of the shardkey are bound, otherwise returns nil.
+func (t *TableInfo) bindShardKey(col string, val *sqlparser.SQLVal) (*sqlparser.SQLVal, error) {
+	for i, key := range t.shardKeys {
+		if key != col {
+			continue
+		}
+		if t.keyVals == nil {
+			t.keyVals = make([]*sqlparser.SQLVal, len(t.shardKeys))
+		}
+		t.keyVals[i] = val
+		for _, v := range t.keyVals {
+			if v == nil {
+				return nil, nil
+			}
+		}
+		var types []string
+		if t.tableConfig != nil {
+			types = t.tableConfig.ShardKeyTypes
+		}
+		return router.CompositeKey(t.keyVals, types)
+	}
+	return nil, nil
+}
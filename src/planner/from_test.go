@@ -102,8 +102,9 @@ func TestScanTableExprs(t *testing.T) {
 
 		i := 0
 		err = j.pushOtherJoin(&i)
-		got := err.Error()
-		assert.Equal(t, "unsupported: clause.'A.b + B.b > 0'.in.cross-shard.join", got)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(j.Filters))
+		assert.Equal(t, "A.b + B.b > 0", j.Filters[0].Field)
 	}
 	// right join1.
 	{
@@ -351,7 +352,7 @@ func TestScanTableExprsError(t *testing.T) {
 	}
 	wants := []string{
 		"Table 'C' doesn't exist (errno 1146) (sqlstate 42S02)",
-		"unsupported: subquery.in.select",
+		"unsupported: '*'.expression.in.derived.table",
 		"unsupported: join.type:natural join",
 		"unsupported: unknown.column.'id'.in.clause",
 		"unsupported: unknown.table.'C'.in.clause",
@@ -379,3 +380,45 @@ func TestScanTableExprsError(t *testing.T) {
 		assert.Equal(t, wants[i], got)
 	}
 }
+
+func TestScanTableExprsTableGroup(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.CreateDatabase(database)
+	assert.Nil(t, err)
+	extra := &router.Extra{TableGroup: "g1", ShardKeyTypes: []string{"int"}}
+	err = route.CreateTable(database, "GA", "id", []string{"backend1", "backend2"}, extra)
+	assert.Nil(t, err)
+	// The int and bigint keys are routed in the same way.
+	extra = &router.Extra{TableGroup: "g1", ShardKeyTypes: []string{"bigint"}}
+	err = route.CreateTable(database, "GB", "uid", nil, extra)
+	assert.Nil(t, err)
+	err = route.CreateTable(database, "GC", "id", []string{"backend2", "backend3"}, nil)
+	assert.Nil(t, err)
+
+	check := func(query string, merged bool) {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		planNode, err := scanTableExprs(log, route, database, node.(*sqlparser.Select).From)
+		assert.Nil(t, err)
+		_, ok := planNode.(*MergeNode)
+		assert.Equal(t, merged, ok, query)
+	}
+
+	check("select * from GA join GB on GA.id=GB.uid", true)
+	check("select * from GA join GB on GA.id=GB.b", false)
+	check("select * from GA join GC on GA.id=GC.id", false)
+
+	// The group is still co-located after the split.
+	tconf, err := route.TableConfig(database, "GB")
+	assert.Nil(t, err)
+	rules, err := route.PartitionRuleSplit(database, tconf.Partitions[0].Table)
+	assert.Nil(t, err)
+	err = route.PartitionRuleReplace(database, rules)
+	assert.Nil(t, err)
+	check("select * from GA join GB on GA.id=GB.uid", true)
+}
@@ -11,6 +11,7 @@ package planner
 import (
 	"encoding/json"
 	"sort"
+	"strconv"
 
 	"router"
 	"xcontext"
@@ -18,11 +19,15 @@ import (
 	"github.com/pkg/errors"
 	"github.com/xelabs/go-mysqlstack/sqlparser"
 	"github.com/xelabs/go-mysqlstack/sqlparser/depends/common"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
 	"github.com/xelabs/go-mysqlstack/xlog"
+
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
 )
 
 var (
-	_ Plan = &InsertPlan{}
+	_ Plan         = &InsertPlan{}
+	_ ShardKeyMove = &InsertPlan{}
 )
 
 // InsertPlan represents insertion plan
@@ -49,6 +54,32 @@ type InsertPlan struct {
 
 	// query and backend tuple
 	Querys []xcontext.QueryTuple
+
+	// Select is the plan of the 'insert ... select' rows if the select isn't pushed down.
+	Select *SelectPlan
+
+	// table inserted into.
+	table string
+
+	// shard key of the table, the key indexes in the columns and the key types.
+	shardKey string
+	keyIdxs  []int
+	keyTypes []string
+
+	// MoveShardKey is true if the OnDup changes the shard key, the rows are
+	// written by the MoveReads and MoveQuerys instead of the Querys.
+	MoveShardKey bool
+
+	// moveIndex reads the unique keys of the table, the rows which conflict
+	// with the rows inserted are found by the unique keys.
+	moveIndex xcontext.QueryTuple
+
+	// rows inserted and their partitions.
+	moveRows     sqlparser.Values
+	moveSegments []router.Segment
+
+	// tables written by the plan.
+	writeTables
 }
 
 // NewInsertPlan used to create InsertPlan
@@ -65,6 +96,8 @@ func NewInsertPlan(log *xlog.Log, database string, query string, node *sqlparser
 }
 
 // Build used to build distributed querys.
+// The rows of the 'insert ... select' are produced by the select plan, and routed by
+// RowQuerys when the select is executed, unless the select is pushed down per partition.
 func (p *InsertPlan) Build() error {
 	node := p.node
 
@@ -80,48 +113,93 @@ func (p *InsertPlan) Build() error {
 	if err != nil {
 		return err
 	}
+	if err := p.checkWritable(p.router, database, table); err != nil {
+		return err
+	}
+	p.table = table
+	p.shardKey = shardKey
 
-	rows, ok := node.Rows.(sqlparser.Values)
-	if !ok {
-		return errors.Errorf("unsupported: rows.can.not.be.subquery[%T]", node.Rows)
+	// Global table has no shard key to route the rows.
+	if shardKey != "" {
+		if err := p.analyzeShardKey(database, table); err != nil {
+			return err
+		}
+		// The rows updated by the OnDup are moved if the shard key is changed.
+		if isShardKeyChanging(sqlparser.UpdateExprs(node.OnDup), shardKey) {
+			rows, ok := node.Rows.(sqlparser.Values)
+			if !ok {
+				return errors.New("unsupported: cannot.update.shard.key")
+			}
+			return p.buildMove(database, rows)
+		}
 	}
-	// Table is global table.
-	if shardKey == "" {
-		segments, err := p.router.Lookup(database, table, nil, nil)
+
+	switch rows := node.Rows.(type) {
+	case sqlparser.Values:
+		querys, err := p.buildRows(rows)
 		if err != nil {
 			return err
 		}
+		p.Querys = append(p.Querys, querys...)
+		return nil
+	case sqlparser.SelectStatement:
+		return p.buildSelect(rows)
+	}
+	return errors.Errorf("unsupported: rows.type[%T]", node.Rows)
+}
+
+// analyzeShardKey used to find the indexes of the shard key in the columns.
+func (p *InsertPlan) analyzeShardKey(database, table string) error {
+	var err error
+	node := p.node
+	shardKey := p.shardKey
+
+	// Find the shard key indexes, the composite shard key has more than one column.
+	keys := router.ShardKeys(shardKey)
+	idxs := make([]int, len(keys))
+	for k, key := range keys {
+		idxs[k] = -1
+		for i, column := range node.Columns {
+			if column.String() == key {
+				idxs[k] = i
+				break
+			}
+		}
+		if idxs[k] == -1 {
+			return errors.Errorf("unsupported: shardkey.column[%v].missing", key)
+		}
+	}
+	if p.keyTypes, err = p.router.ShardKeyTypes(database, table); err != nil {
+		return err
+	}
+	p.keyIdxs = idxs
+	return nil
+}
+
+// buildRows used to route the rows by the shard key and build the querys,
+// the rows are inserted into every partition if the table is global.
+func (p *InsertPlan) buildRows(rows sqlparser.Values) ([]xcontext.QueryTuple, error) {
+	node := p.node
+	database := p.targetDatabase()
+	querys := make([]xcontext.QueryTuple, 0, 16)
+
+	// Table is global table.
+	if p.shardKey == "" {
+		segments, err := p.router.Lookup(database, p.table, nil, nil)
+		if err != nil {
+			return nil, err
+		}
 		for _, segment := range segments {
 			buf := sqlparser.NewTrackedBuffer(nil)
-			buf.Myprintf("%s %v%sinto %s.%s%v %v%v", node.Action, node.Comments, node.Ignore, database, segment.Table, node.Columns, node.Rows, node.OnDup)
+			buf.Myprintf("%s %v%sinto %s.%s%v %v%v", node.Action, node.Comments, node.Ignore, database, segment.Table, node.Columns, rows, node.OnDup)
 			tuple := xcontext.QueryTuple{
 				Query:   buf.String(),
 				Backend: segment.Backend,
 				Range:   segment.Range.String(),
 			}
-			p.Querys = append(p.Querys, tuple)
-		}
-		return nil
-	}
-
-	// Check the OnDup.
-	if len(node.OnDup) > 0 {
-		// analyze shardkey changing.
-		if isShardKeyChanging(sqlparser.UpdateExprs(node.OnDup), shardKey) {
-			return errors.New("unsupported: cannot.update.shard.key")
-		}
-	}
-
-	// Find the shard key index.
-	idx := -1
-	for i, column := range node.Columns {
-		if column.String() == shardKey {
-			idx = i
-			break
+			querys = append(querys, tuple)
 		}
-	}
-	if idx == -1 {
-		return errors.Errorf("unsupported: shardkey.column[%v].missing", shardKey)
+		return querys, nil
 	}
 
 	// Rebuild distributed querys.
@@ -134,21 +212,13 @@ func (p *InsertPlan) Build() error {
 	vals := make(map[string]*valTuple)
 
 	for _, row := range rows {
-		if idx >= len(row) {
-			return errors.Errorf("unsupported: shardkey[%v].out.of.index:[%v]", shardKey, idx)
-		}
-		shardVal, ok := row[idx].(*sqlparser.SQLVal)
-		if !ok {
-			return errors.Errorf("unsupported: shardkey[%v].type.canot.be[%T]", shardKey, row[idx])
-		}
-
-		segments, err := p.router.Lookup(database, table, shardVal, shardVal)
+		segment, err := p.locateRow(database, row)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		rewrittenTable := segments[0].Table
-		backend := segments[0].Backend
-		rangi := segments[0].Range.String()
+		rewrittenTable := segment.Table
+		backend := segment.Backend
+		rangi := segment.Range.String()
 		val, ok := vals[rewrittenTable]
 		if !ok {
 			val = &valTuple{
@@ -171,11 +241,344 @@ func (p *InsertPlan) Build() error {
 			Backend: v.backend,
 			Range:   v.rangi,
 		}
-		p.Querys = append(p.Querys, tuple)
+		querys = append(querys, tuple)
+	}
+	return querys, nil
+}
+
+// locateRow used to find the partition of the row by the shard key.
+func (p *InsertPlan) locateRow(database string, row sqlparser.ValTuple) (router.Segment, error) {
+	keys := router.ShardKeys(p.shardKey)
+	keyVals := make([]*sqlparser.SQLVal, len(p.keyIdxs))
+	for k, idx := range p.keyIdxs {
+		if idx >= len(row) {
+			return router.Segment{}, errors.Errorf("unsupported: shardkey[%v].out.of.index:[%v]", keys[k], idx)
+		}
+		val, ok := row[idx].(*sqlparser.SQLVal)
+		if !ok {
+			return router.Segment{}, errors.Errorf("unsupported: shardkey[%v].type.canot.be[%T]", keys[k], row[idx])
+		}
+		keyVals[k] = val
+	}
+	shardVal, err := router.CompositeKey(keyVals, p.keyTypes)
+	if err != nil {
+		return router.Segment{}, err
+	}
+	return p.router.LocateRow(database, p.table, shardVal)
+}
+
+// buildSelect used to build the plan of the select rows. The select is pushed down
+// with the insert per partition if possible, otherwise the rows are routed by RowQuerys.
+func (p *InsertPlan) buildSelect(sel sqlparser.SelectStatement) error {
+	plan := NewSelectPlan(p.log, p.database, sqlparser.String(sel), sel, p.router)
+	if err := plan.Build(); err != nil {
+		return err
+	}
+	querys, err := p.pushDown(plan)
+	if err != nil {
+		return err
+	}
+	if querys != nil {
+		p.Querys = append(p.Querys, querys...)
+		return nil
+	}
+	p.Select = plan
+	return nil
+}
+
+// pushDown returns the 'insert ... select' querys executed by the backends, nil if the select
+// can't be pushed down. The select must be done in one MergeNode without the merge in the proxy, and:
+//  1. the target is sharded, the select reads one sharded table which has the same partitions,
+//     and the shard key columns are selected in the same positions as the target's shard key,
+//     so the rows of each partition are inserted into the partition with the same range.
+//  2. the target isn't sharded, the select reads only the global tables.
+func (p *InsertPlan) pushDown(plan *SelectPlan) ([]xcontext.QueryTuple, error) {
+	mn, ok := plan.Root.(*MergeNode)
+	if !ok || len(mn.Children().Plans()) > 0 {
+		return nil, nil
+	}
+
+	node := p.node
+	database := p.targetDatabase()
+	segments, err := p.router.Lookup(database, p.table, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	build := func(segment router.Segment, sel string) xcontext.QueryTuple {
+		buf := sqlparser.NewTrackedBuffer(nil)
+		buf.Myprintf("%s %v%sinto %s.%s%v %s%v", node.Action, node.Comments, node.Ignore, database, segment.Table, node.Columns, sel, node.OnDup)
+		return xcontext.QueryTuple{
+			Query:   buf.String(),
+			Backend: segment.Backend,
+			Range:   segment.Range.String(),
+		}
+	}
+
+	var querys []xcontext.QueryTuple
+	if p.shardKey == "" {
+		if mn.shardCount > 0 {
+			return nil, nil
+		}
+		for _, segment := range segments {
+			querys = append(querys, build(segment, mn.Querys[0].Query))
+		}
+		return querys, nil
+	}
+
+	if mn.shardCount != 1 {
+		return nil, nil
+	}
+	var source *TableInfo
+	for _, tbInfo := range mn.referredTables {
+		if tbInfo.shardType != "GLOBAL" {
+			source = tbInfo
+		}
+	}
+	conf, err := p.router.TableConfig(database, p.table)
+	if err != nil {
+		return nil, err
+	}
+	if len(source.shardKeys) != len(p.keyIdxs) || !isSamePartitions(source.tableConfig, conf) {
+		return nil, nil
+	}
+	for k, idx := range p.keyIdxs {
+		if idx >= len(mn.sel.SelectExprs) {
+			return nil, nil
+		}
+		expr, ok := mn.sel.SelectExprs[idx].(*sqlparser.AliasedExpr)
+		if !ok || !nameMatch(expr.Expr, source.alias, source.shardKeys[k]) {
+			return nil, nil
+		}
+	}
+
+	ranges := make(map[string]router.Segment, len(segments))
+	for _, segment := range segments {
+		ranges[segment.Range.String()] = segment
+	}
+	for _, query := range mn.Querys {
+		segment, ok := ranges[query.Range]
+		if !ok {
+			return nil, nil
+		}
+		querys = append(querys, build(segment, query.Query))
+	}
+	return querys, nil
+}
+
+// RowQuerys used to build the querys which insert the rows produced by the select,
+// the values are routed by the shard key in the same way as the 'insert ... values'.
+func (p *InsertPlan) RowQuerys(rows [][]sqltypes.Value) ([]xcontext.QueryTuple, error) {
+	columns := len(p.node.Columns)
+	vals := make(sqlparser.Values, 0, len(rows))
+	for _, row := range rows {
+		if columns > 0 && len(row) != columns {
+			return nil, errors.Errorf("column.count[%d].doesn't.match.value.count[%d]", columns, len(row))
+		}
+		tuple := make(sqlparser.ValTuple, 0, len(row))
+		for _, v := range row {
+			tuple = append(tuple, valueToExpr(v))
+		}
+		vals = append(vals, tuple)
+	}
+	return p.buildRows(vals)
+}
+
+// buildMove used to build the insert whose OnDup changes the shard key. The row which
+// conflicts with the row inserted is found by the unique keys of the table, it's read with
+// the new values of the OnDup, then deleted and inserted into the new partition. The rows
+// without conflict are inserted as they are.
+func (p *InsertPlan) buildMove(database string, rows sqlparser.Values) error {
+	node := p.node
+	for _, row := range rows {
+		if len(row) != len(node.Columns) {
+			return errors.Errorf("column.count[%d].doesn't.match.value.count[%d]", len(node.Columns), len(row))
+		}
+		segment, err := p.locateRow(database, row)
+		if err != nil {
+			return err
+		}
+		p.moveSegments = append(p.moveSegments, segment)
+	}
+	// The values() of the OnDup are replaced by the values of the row.
+	err := sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if fn, ok := node.(*sqlparser.ValuesFuncExpr); ok && p.columnIndex(fn.Name.String()) == -1 {
+			return false, errors.Errorf("unsupported: values(%s).column.missing", fn.Name.String())
+		}
+		return true, nil
+	}, sqlparser.UpdateExprs(node.OnDup))
+	if err != nil {
+		return err
 	}
+
+	if p.moveIndex, err = uniqueKeysRead(p.router, database, p.table); err != nil {
+		return err
+	}
+	p.moveRows = rows
+	p.MoveShardKey = true
 	return nil
 }
 
+// columnIndex returns the index of the column inserted, -1 if not found.
+func (p *InsertPlan) columnIndex(name string) int {
+	for i, column := range p.node.Columns {
+		if column.EqualString(name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// MoveReads returns the unique keys read first, then the reads of the rows which conflict
+// with the rows inserted. The rows are read with the index of the row inserted and the new
+// values of the OnDup, such as: 'select 0, *, <ondup values> from t where <unique key> = <row values>'.
+func (p *InsertPlan) MoveReads(results []*sqltypes.Result) ([]xcontext.QueryTuple, error) {
+	switch len(results) {
+	case 0:
+		return []xcontext.QueryTuple{p.moveIndex}, nil
+	case 1:
+		node := p.node
+		keys := uniqueKeys(results[0])
+		var querys []xcontext.QueryTuple
+		for i, row := range p.moveRows {
+			var where sqlparser.Expr
+			for _, key := range keys {
+				cond := p.keyEquals(key, row)
+				if cond == nil {
+					continue
+				}
+				if where == nil {
+					where = cond
+					continue
+				}
+				where = &sqlparser.OrExpr{Left: where, Right: cond}
+			}
+			// The row can't conflict without the unique key values.
+			if where == nil {
+				continue
+			}
+
+			exprs := make(sqlparser.UpdateExprs, 0, len(node.OnDup))
+			for _, expr := range node.OnDup {
+				exprs = append(exprs, &sqlparser.UpdateExpr{Name: expr.Name, Expr: p.resolveValues(expr.Expr, row)})
+			}
+			segment := p.moveSegments[i]
+			buf := sqlparser.NewTrackedBuffer(nil)
+			buf.Myprintf("select %s, *, %v from %s.%s where %v limit 1 for update", strconv.Itoa(i), moveValues(exprs), p.targetDatabase(), segment.Table, where)
+			querys = append(querys, xcontext.QueryTuple{
+				Query:   buf.String(),
+				Backend: segment.Backend,
+				Range:   segment.Range.String(),
+			})
+		}
+		return querys, nil
+	}
+	return nil, nil
+}
+
+// keyEquals used to build the where of the unique key by the row values, nil if the
+// key has the columns not inserted or the NULL values.
+func (p *InsertPlan) keyEquals(key []string, row sqlparser.ValTuple) sqlparser.Expr {
+	vals := make([]sqlparser.Expr, 0, len(key))
+	for _, column := range key {
+		idx := p.columnIndex(column)
+		if idx == -1 {
+			return nil
+		}
+		if _, ok := row[idx].(*sqlparser.NullVal); ok {
+			return nil
+		}
+		vals = append(vals, row[idx])
+	}
+	return keyEquals(key, vals)
+}
+
+// resolveValues returns the copy of the expr whose values() are resolved by the row.
+func (p *InsertPlan) resolveValues(expr sqlparser.Expr, row sqlparser.ValTuple) sqlparser.Expr {
+	expr = sqlparser.CloneExpr(expr)
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if fn, ok := node.(*sqlparser.ValuesFuncExpr); ok {
+			fn.Resolved = row[p.columnIndex(fn.Name.String())]
+		}
+		return true, nil
+	}, expr)
+	return expr
+}
+
+// MoveQuerys returns the querys which delete the rows conflicted and insert them into the new
+// partitions, and the inserts of the rows without conflict. The rows affected is 2 per row
+// updated and 1 per row inserted, the same as the 'insert ... on duplicate key update'.
+func (p *InsertPlan) MoveQuerys(results []*sqltypes.Result) ([]xcontext.QueryTuple, uint64, error) {
+	node := p.node
+	database := p.targetDatabase()
+	found := make(map[int][]sqltypes.Value)
+	var fields []*querypb.Field
+	if len(results) > 1 {
+		fields = results[1].Fields[1:]
+		for _, row := range results[1].Rows {
+			i, err := strconv.Atoi(row[0].String())
+			if err != nil {
+				return nil, 0, err
+			}
+			found[i] = row[1:]
+		}
+	}
+
+	var keys [][]string
+	if len(results) > 0 {
+		keys = uniqueKeys(results[0])
+	}
+	var querys []xcontext.QueryTuple
+	var updated [][]sqltypes.Value
+	var inserted sqlparser.Values
+	for i, row := range p.moveRows {
+		old, ok := found[i]
+		if !ok {
+			inserted = append(inserted, row)
+			continue
+		}
+		// Delete the row conflicted by its unique key.
+		where, err := fieldsEquals(keys, fields, old)
+		if err != nil {
+			return nil, 0, err
+		}
+		segment := p.moveSegments[i]
+		buf := sqlparser.NewTrackedBuffer(nil)
+		buf.Myprintf("delete %vfrom %s.%s where %v", node.Comments, database, segment.Table, where)
+		querys = append(querys, xcontext.QueryTuple{
+			Query:   buf.String(),
+			Backend: segment.Backend,
+			Range:   segment.Range.String(),
+		})
+		updated = append(updated, old)
+	}
+
+	if len(updated) > 0 {
+		columns, rows, err := movedRows(fields, updated, sqlparser.UpdateExprs(node.OnDup))
+		if err != nil {
+			return nil, 0, err
+		}
+		inserts, err := moveInserts(p.log, p.router, database, p.table, node.Comments, columns, rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		querys = append(querys, inserts...)
+	}
+	inserts, err := moveInserts(p.log, p.router, database, p.table, node.Comments, node.Columns, inserted)
+	if err != nil {
+		return nil, 0, err
+	}
+	querys = append(querys, inserts...)
+	return querys, uint64(2*len(updated) + len(inserted)), nil
+}
+
+// targetDatabase returns the database of the table inserted into.
+func (p *InsertPlan) targetDatabase() string {
+	if !p.node.Table.Qualifier.IsEmpty() {
+		return p.node.Table.Qualifier.String()
+	}
+	return p.database
+}
+
 // Type returns the type of the plan.
 func (p *InsertPlan) Type() PlanType {
 	return p.Typ
@@ -186,6 +589,8 @@ func (p *InsertPlan) JSON() string {
 	type explain struct {
 		RawQuery   string                `json:",omitempty"`
 		Partitions []xcontext.QueryTuple `json:",omitempty"`
+		Select     json.RawMessage       `json:",omitempty"`
+		Reads      []xcontext.QueryTuple `json:",omitempty"`
 	}
 
 	var parts []xcontext.QueryTuple
@@ -196,6 +601,12 @@ func (p *InsertPlan) JSON() string {
 		RawQuery:   p.RawQuery,
 		Partitions: parts,
 	}
+	if p.Select != nil {
+		exp.Select = json.RawMessage(p.Select.JSON())
+	}
+	if p.MoveShardKey {
+		exp.Reads = []xcontext.QueryTuple{p.moveIndex}
+	}
 	bout, err := json.MarshalIndent(exp, "", "\t")
 	if err != nil {
 		return err.Error()
@@ -10,14 +10,19 @@ package planner
 
 import (
 	"fmt"
+	"sort"
 	"testing"
 	"time"
 
 	"router"
+	"xcontext"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
 	"github.com/xelabs/go-mysqlstack/xlog"
+
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
 )
 
 func TestInsertPlan(t *testing.T) {
@@ -100,12 +105,11 @@ func TestInsertUnsupportedPlan(t *testing.T) {
 	querys := []string{
 		"insert into sbtest.A(b, c, id) values(1,2)",
 		"insert into sbtest.A(b, c, d) values(1,2, 3)",
-		"insert into sbtest.A(b, c, id) values(1,2,3) on duplicate key update id=1",
+		"insert into sbtest.A(b, c, id) select b, c, id from sbtest.A on duplicate key update id=1",
 		"insert into sbtest.A(b, c, id) values(1, floor(3), floor(3))",
-		"insert into sbtest.A select * from sbtest.B",
-		"insert into sbtest.A(b,c,id) select id,b,c from sbtest.A",
-		"insert into sbtest.G(b, c, id) select * from sbtest.A",
-		"insert into sbtest.G select * from sbtest.A",
+		"insert into sbtest.A select * from sbtest.A",
+		"insert into sbtest.A(b,c,id) select id,b,c from sbtest.B",
+		"insert into sbtest.G(b, c, id) select * from sbtest.A where a in (select a from sbtest.A where A.id = G.id)",
 	}
 
 	results := []string{
@@ -113,10 +117,9 @@ func TestInsertUnsupportedPlan(t *testing.T) {
 		"unsupported: shardkey.column[id].missing",
 		"unsupported: cannot.update.shard.key",
 		"unsupported: shardkey[id].type.canot.be[*sqlparser.FuncExpr]",
-		"unsupported: rows.can.not.be.subquery[*sqlparser.Select]",
-		"unsupported: rows.can.not.be.subquery[*sqlparser.Select]",
-		"unsupported: rows.can.not.be.subquery[*sqlparser.Select]",
-		"unsupported: rows.can.not.be.subquery[*sqlparser.Select]",
+		"unsupported: shardkey.column[id].missing",
+		"Table 'B' doesn't exist (errno 1146) (sqlstate 42S02)",
+		"unsupported: correlated.subquery.column[G.id]",
 	}
 
 	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
@@ -237,19 +240,17 @@ func TestReplaceUnsupportedPlan(t *testing.T) {
 	querys := []string{
 		"replace into sbtest.A(b, c, id) values(1,2)",
 		"replace into sbtest.A(b, c, d) values(1,2, 3)",
-		"replace into sbtest.A select * from sbtest.B",
-		"replace into sbtest.A(b,c,id) select id,b,c from sbtest.A",
-		"replace into sbtest.G(b, c, id) select * from sbtest.A",
-		"replace into sbtest.G select * from sbtest.A",
+		"replace into sbtest.A select * from sbtest.A",
+		"replace into sbtest.A(b,c,id) select id,b,c from sbtest.B",
+		"replace into sbtest.G(b, c, id) select * from sbtest.A where a in (select a from sbtest.A where A.id = G.id)",
 	}
 
 	results := []string{
 		"unsupported: shardkey[id].out.of.index:[2]",
 		"unsupported: shardkey.column[id].missing",
-		"unsupported: rows.can.not.be.subquery[*sqlparser.Select]",
-		"unsupported: rows.can.not.be.subquery[*sqlparser.Select]",
-		"unsupported: rows.can.not.be.subquery[*sqlparser.Select]",
-		"unsupported: rows.can.not.be.subquery[*sqlparser.Select]",
+		"unsupported: shardkey.column[id].missing",
+		"Table 'B' doesn't exist (errno 1146) (sqlstate 42S02)",
+		"unsupported: correlated.subquery.column[G.id]",
 	}
 
 	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
@@ -500,3 +501,352 @@ func TestReplacePlanGlobal(t *testing.T) {
 		}
 	}
 }
+
+func TestInsertPlanCompositeKey(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableCConfig())
+	assert.Nil(t, err)
+
+	// The rows with the same (a, b) must be routed to the same partition.
+	{
+		query := "insert into sbtest.C(a, b, c) values(1, 'x', 1), (1, 'x', 2), (1, 'x', 3)"
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewInsertPlan(log, database, query, node.(*sqlparser.Insert), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(plan.Querys))
+	}
+
+	querys := []string{
+		"insert into sbtest.C(a, c) values(1, 2)",
+		"insert into sbtest.C(a, b) values(1, floor(2))",
+		"insert into sbtest.C(a, b) select a, b from sbtest.C on duplicate key update b=1",
+	}
+	results := []string{
+		"unsupported: shardkey.column[b].missing",
+		"unsupported: shardkey[b].type.canot.be[*sqlparser.FuncExpr]",
+		"unsupported: cannot.update.shard.key",
+	}
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewInsertPlan(log, database, query, node.(*sqlparser.Insert), route)
+		err = plan.Build()
+		assert.Equal(t, results[i], err.Error())
+	}
+}
+
+func TestInsertPlanCompositeKeyTypes(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	conf := router.MockTableCConfig()
+	conf.ShardKeyTypes = []string{"int", "varchar"}
+	err := route.AddForTest(database, conf)
+	assert.Nil(t, err)
+
+	// The numeric column is coerced, 1, 1.0 and '01' are the same key.
+	query := "insert into sbtest.C(a, b, c) values(1, 'x', 1), (1.0, 'x', 2), ('01', 'x', 3), (1.00, 'x', 4)"
+	node, err := sqlparser.Parse(query)
+	assert.Nil(t, err)
+	plan := NewInsertPlan(log, database, query, node.(*sqlparser.Insert), route)
+	err = plan.Build()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(plan.Querys))
+}
+
+func TestInsertPlanFrozen(t *testing.T) {
+	querys := []string{
+		"insert into A(b, c, id) values(1, 2, 3)",
+		"update A set b=1 where id=1",
+		"delete from A where id=1",
+	}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableMConfig())
+	assert.Nil(t, err)
+	err = route.FreezeTable(database, "A")
+	assert.Nil(t, err)
+
+	want := "router.table[sbtest.A].is.frozen.for.resharding,please.retry.later"
+	for _, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+
+		var plan Plan
+		switch node := node.(type) {
+		case *sqlparser.Insert:
+			plan = NewInsertPlan(log, database, query, node, route)
+		case *sqlparser.Update:
+			plan = NewUpdatePlan(log, database, query, node, route)
+		case *sqlparser.Delete:
+			plan = NewDeletePlan(log, database, query, node, route)
+		}
+		err = plan.Build()
+		assert.Equal(t, want, err.Error(), query)
+	}
+
+	// Writable again.
+	route.UnfreezeTable(database, "A")
+	query := "insert into A(b, c, id) values(1, 2, 3)"
+	node, err := sqlparser.Parse(query)
+	assert.Nil(t, err)
+	plan := NewInsertPlan(log, database, query, node.(*sqlparser.Insert), route)
+	assert.Nil(t, plan.Build())
+}
+
+func TestInsertSelectPlan(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	// B has the same partitions as A.
+	confB := router.MockTableMConfig()
+	confB.Name = "B"
+	for i, part := range confB.Partitions {
+		part.Table = fmt.Sprintf("B%d", i+1)
+	}
+	err := route.AddForTest(database, router.MockTableMConfig(), confB, router.MockTableGConfig(), router.MockTableG1Config())
+	assert.Nil(t, err)
+
+	// Pushed down per partition.
+	{
+		querys := []string{
+			"insert into sbtest.A(b, c, id) select b, c, id from sbtest.B where id = 10",
+			"insert ignore into A(id, b) select B.id, G.b from B join G on B.b = G.b where B.id = 10",
+			"insert into sbtest.G select * from sbtest.G1 where a = 1",
+		}
+		wants := [][]string{
+			{"insert into sbtest.A6(b, c, id) select b, c, id from sbtest.B6 as B where id = 10"},
+			{"insert ignore into sbtest.A6(id, b) select B.id, G.b from sbtest.B6 as B join sbtest.G on B.b = G.b where B.id = 10"},
+			{"insert into sbtest.G select * from sbtest.G1 where a = 1", "insert into sbtest.G select * from sbtest.G1 where a = 1"},
+		}
+		for i, query := range querys {
+			node, err := sqlparser.Parse(query)
+			assert.Nil(t, err)
+			plan := NewInsertPlan(log, database, query, node.(*sqlparser.Insert), route)
+			err = plan.Build()
+			assert.Nil(t, err)
+			assert.Nil(t, plan.Select, query)
+			var got []string
+			for _, q := range plan.Querys {
+				got = append(got, q.Query)
+			}
+			assert.Equal(t, wants[i], got)
+		}
+
+		// Every partition of the source is inserted into the same range of the target.
+		query := "insert into A(id, b) select id, b from B where id > 10"
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewInsertPlan(log, database, query, node.(*sqlparser.Insert), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+		assert.Equal(t, 6, len(plan.Querys))
+		for i, q := range plan.Querys {
+			want := fmt.Sprintf("insert into sbtest.A%d(id, b) select id, b from sbtest.B%d as B where id > 10", i+1, i+1)
+			assert.Equal(t, want, q.Query)
+			assert.Equal(t, fmt.Sprintf("backend%d", i+1), q.Backend)
+		}
+	}
+
+	// The rows are routed by the proxy.
+	{
+		querys := []string{
+			// the shard key is in the other position.
+			"insert into sbtest.A(b, c, id) select id, b, c from sbtest.B",
+			// aggregate is merged in the proxy.
+			"insert into sbtest.A(id, b) select id, count(*) from sbtest.B group by id",
+			// the target is global, the source is sharded.
+			"insert into sbtest.G(a, b) select a, b from sbtest.A",
+			// subquery is materialized first.
+			"insert into sbtest.A(id, b) select id, b from sbtest.B where b in (select b from sbtest.G)",
+		}
+		for _, query := range querys {
+			node, err := sqlparser.Parse(query)
+			assert.Nil(t, err)
+			plan := NewInsertPlan(log, database, query, node.(*sqlparser.Insert), route)
+			err = plan.Build()
+			assert.Nil(t, err)
+			assert.NotNil(t, plan.Select, query)
+			assert.Equal(t, 0, len(plan.Querys))
+			assert.Contains(t, plan.JSON(), `"Select": {`)
+		}
+	}
+}
+
+func TestInsertSelectPlanRowQuerys(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableMConfig(), router.MockTableGConfig())
+	assert.Nil(t, err)
+
+	rows := [][]sqltypes.Value{
+		{sqltypes.MakeTrusted(querypb.Type_INT32, []byte("1")), sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("x"))},
+		{sqltypes.MakeTrusted(querypb.Type_INT32, []byte("65536")), sqltypes.NULL},
+		{sqltypes.MakeTrusted(querypb.Type_INT32, []byte("23")), sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("y"))},
+	}
+
+	// Sharded.
+	{
+		query := "insert into A(id, b) select b, id from G"
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewInsertPlan(log, database, query, node.(*sqlparser.Insert), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		querys, err := plan.RowQuerys(rows)
+		assert.Nil(t, err)
+		sort.Sort(xcontext.QueryTuples(querys))
+		assert.Equal(t, 2, len(querys))
+		assert.Equal(t, "insert into sbtest.A5(id, b) values (65536, null)", querys[0].Query)
+		assert.Equal(t, "insert into sbtest.A6(id, b) values (1, 'x'), (23, 'y')", querys[1].Query)
+
+		_, err = plan.RowQuerys([][]sqltypes.Value{rows[0][:1]})
+		assert.Equal(t, "column.count[2].doesn't.match.value.count[1]", err.Error())
+	}
+
+	// Global.
+	{
+		query := "insert into G select id, b from A"
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewInsertPlan(log, database, query, node.(*sqlparser.Insert), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		querys, err := plan.RowQuerys(rows)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(querys))
+		for _, q := range querys {
+			assert.Equal(t, "insert into sbtest.G values (1, 'x'), (65536, null), (23, 'y')", q.Query)
+		}
+	}
+}
+
+func TestInsertMoveShardKeyPlan(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableMConfig())
+	assert.Nil(t, err)
+
+	query := "insert into sbtest.A(id, b, c) values(1, 2, 3), (2, null, 4), (3, 5, 6) on duplicate key update id = values(id) + 99999998, c = c + 1"
+	node, err := sqlparser.Parse(query)
+	assert.Nil(t, err)
+	plan := NewInsertPlan(log, database, query, node.(*sqlparser.Insert), route)
+	err = plan.Build()
+	assert.Nil(t, err)
+	assert.True(t, plan.MoveShardKey)
+	assert.Equal(t, 0, len(plan.Querys))
+
+	// Read the unique keys.
+	reads, err := plan.MoveReads(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(reads))
+	assert.Equal(t, "select index_name, column_name from information_schema.statistics where table_schema = 'sbtest' and table_name = 'A1' and non_unique = 0 order by index_name != 'PRIMARY', index_name, seq_in_index", reads[0].Query)
+
+	// Read the rows conflicted.
+	index := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "index_name", Type: querypb.Type_VARCHAR},
+			{Name: "column_name", Type: querypb.Type_VARCHAR},
+		},
+		Rows: [][]sqltypes.Value{
+			{sqltypes.NewVarChar("PRIMARY"), sqltypes.NewVarChar("id")},
+			{sqltypes.NewVarChar("uk_b"), sqltypes.NewVarChar("b")},
+		},
+	}
+	results := []*sqltypes.Result{index}
+	reads, err = plan.MoveReads(results)
+	assert.Nil(t, err)
+	var got []string
+	for _, q := range reads {
+		got = append(got, q.Query)
+	}
+	want := []string{
+		"select 0, *, 1 + 99999998, c + 1 from sbtest.A6 where id = 1 or b = 2 limit 1 for update",
+		"select 1, *, 2 + 99999998, c + 1 from sbtest.A6 where id = 2 limit 1 for update",
+		"select 2, *, 3 + 99999998, c + 1 from sbtest.A6 where id = 3 or b = 5 limit 1 for update",
+	}
+	assert.Equal(t, want, got)
+
+	// The first row is conflicted, it's moved.
+	rows := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "0", Type: querypb.Type_INT64},
+			{Name: "id", Type: querypb.Type_INT32},
+			{Name: "b", Type: querypb.Type_INT32},
+			{Name: "c", Type: querypb.Type_INT32},
+			{Name: "1 + 99999998", Type: querypb.Type_INT64},
+			{Name: "c + 1", Type: querypb.Type_INT64},
+		},
+		Rows: [][]sqltypes.Value{
+			{sqltypes.NewInt64(0), sqltypes.NewInt32(1), sqltypes.NewInt32(2), sqltypes.NewInt32(3), sqltypes.NewInt64(99999999), sqltypes.NewInt64(4)},
+		},
+	}
+	results = append(results, rows)
+	reads, err = plan.MoveReads(results)
+	assert.Nil(t, err)
+	assert.Nil(t, reads)
+
+	querys, affected, err := plan.MoveQuerys(results)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(4), affected)
+	got = nil
+	for _, q := range querys {
+		got = append(got, q.Query)
+	}
+	want = []string{
+		"delete from sbtest.A6 where id = 1",
+		"insert into sbtest.A4(id, b, c) values (99999999, 2, 4)",
+		"insert into sbtest.A6(id, b, c) values (2, null, 4), (3, 5, 6)",
+	}
+	assert.Equal(t, want, got)
+
+	// The table has no unique key, all the rows are inserted.
+	{
+		results := []*sqltypes.Result{{Fields: index.Fields}}
+		reads, err := plan.MoveReads(results)
+		assert.Nil(t, err)
+		assert.Nil(t, reads)
+		querys, affected, err := plan.MoveQuerys(results)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(3), affected)
+		assert.Equal(t, 1, len(querys))
+	}
+
+	// The values() of the column not inserted.
+	{
+		query := "insert into sbtest.A(id, b) values(1, 2) on duplicate key update id = values(c)"
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewInsertPlan(log, database, query, node.(*sqlparser.Insert), route)
+		err = plan.Build()
+		assert.Equal(t, "unsupported: values(c).column.missing", err.Error())
+	}
+}
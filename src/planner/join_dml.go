@@ -0,0 +1,452 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package planner
+
+import (
+	"router"
+	"strings"
+	"xcontext"
+
+	"github.com/pkg/errors"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+// joinTarget is the table written by the multi-table DML.
+type joinTarget struct {
+	// name of the table in the statement, the alias or the table name.
+	name string
+	// table's info.
+	table *TableInfo
+	// keys are the columns of the table referred by the join conditions, the
+	// rows with the same key values as the joined row are the rows written.
+	keys []string
+	// filters are the where conditions which only refer to the table.
+	filters []sqlparser.Expr
+	// offset of the first key column in the row of the select.
+	offset int
+}
+
+// joinDML is the multi-table UPDATE or DELETE, such as:
+// 'update t1 join t2 on t1.a=t2.a set t1.b=t2.b where ...' or 'delete t1 from t1 join t2 on t1.a=t2.a where ...'.
+// If the tables are co-located, the statement is pushed down per partition. Otherwise the join is
+// evaluated by a select in the proxy, and every target is written by the key-based single table DML.
+type joinDML struct {
+	log      *xlog.Log
+	router   *router.Router
+	database string
+	// the FROM clause and where of the statement.
+	from  sqlparser.TableExprs
+	where *sqlparser.Where
+	// the plan node of the FROM clause, scanned from the copy of the FROM.
+	root PlanNode
+	// the where of the copy, which is pushed into the root.
+	rootWhere *sqlparser.Where
+	// referred tables of the FROM clause.
+	tables map[string]*TableInfo
+	// targets are the tables written.
+	targets []*joinTarget
+	// columns of the select, the keys of the targets come first.
+	columns []*sqlparser.ColName
+}
+
+// newJoinDML used to create joinDML.
+func newJoinDML(log *xlog.Log, r *router.Router, database string, from sqlparser.TableExprs, where *sqlparser.Where) (*joinDML, error) {
+	j := &joinDML{
+		log:      log,
+		router:   r,
+		database: database,
+		from:     from,
+		where:    where,
+	}
+	root, err := scanTableExprs(log, r, database, sqlparser.CloneTableExprs(from))
+	if err != nil {
+		return nil, err
+	}
+	j.root = root
+	j.tables = root.getReferredTables()
+	if where != nil {
+		j.rootWhere = sqlparser.NewWhere(sqlparser.WhereStr, sqlparser.CloneExpr(where.Expr))
+	}
+	return j, nil
+}
+
+// tableOf returns the name of the table which the column belongs to.
+func (j *joinDML) tableOf(col *sqlparser.ColName) (string, error) {
+	name := col.Qualifier.Name.String()
+	if name == "" {
+		if len(j.tables) != 1 {
+			return "", errors.Errorf("unsupported: unknown.column.'%s'.in.clause", col.Name.String())
+		}
+		name, _ = getOneTableInfo(j.tables)
+	}
+	return name, nil
+}
+
+// addTarget adds the table to the targets, the derived table can't be written.
+func (j *joinDML) addTarget(name string) (*joinTarget, error) {
+	for _, target := range j.targets {
+		if target.name == name {
+			return target, nil
+		}
+	}
+	table, ok := j.tables[name]
+	if !ok {
+		return nil, errors.Errorf("unsupported: unknown.table.'%s'.in.multi-table.DML", name)
+	}
+	if table.derived != nil {
+		return nil, errors.Errorf("unsupported: the.target.table.'%s'.is.not.updatable", name)
+	}
+	target := &joinTarget{name: name, table: table}
+	j.targets = append(j.targets, target)
+	return target, nil
+}
+
+// targetRefs returns the targets referred in the pushed down statement, the global
+// table has no alias, it's qualified by the database.
+func (j *joinDML) targetRefs() sqlparser.TableNames {
+	var refs sqlparser.TableNames
+	for _, target := range j.targets {
+		ref := sqlparser.TableName{Name: sqlparser.NewTableIdent(target.name)}
+		if target.table.alias == "" {
+			ref.Qualifier = sqlparser.NewTableIdent(target.table.database)
+		}
+		refs = append(refs, ref)
+	}
+	return refs
+}
+
+// pushDown used to build the querys which run the statement per partition, it returns false
+// if the tables aren't co-located, or a GLOBAL table is written with the sharded tables.
+func (j *joinDML) pushDown(format func(buf *sqlparser.TrackedBuffer, from sqlparser.TableExprs, where *sqlparser.Where)) ([]xcontext.QueryTuple, bool, error) {
+	var err error
+	root := j.root
+	if j.rootWhere != nil {
+		joins, filters, err := parserWhereOrJoinExprs(j.rootWhere.Expr, j.tables)
+		if err != nil {
+			return nil, false, err
+		}
+		if err = root.pushFilter(filters); err != nil {
+			return nil, false, err
+		}
+		root = root.pushEqualCmpr(joins)
+	}
+	if root, err = root.calcRoute(); err != nil {
+		return nil, false, err
+	}
+
+	mn, ok := root.(*MergeNode)
+	if !ok {
+		return nil, false, nil
+	}
+	if mn.shardCount > 0 {
+		for _, target := range j.targets {
+			if target.table.shardType == "GLOBAL" {
+				return nil, false, nil
+			}
+		}
+		return mn.routeQuerys(func(buf *sqlparser.TrackedBuffer) {
+			format(buf, mn.sel.From, mn.sel.Where)
+		}), true, nil
+	}
+
+	// All the tables are global, the statement is executed on all the backends.
+	table := j.targets[0].table
+	segments, err := j.router.Lookup(table.database, table.tableName, nil, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	for expr := range mn.filters {
+		mn.sel.AddWhere(expr)
+	}
+	buf := sqlparser.NewTrackedBuffer(nil)
+	format(buf, mn.sel.From, mn.sel.Where)
+	querys := make([]xcontext.QueryTuple, 0, len(segments))
+	for _, segment := range segments {
+		querys = append(querys, xcontext.QueryTuple{
+			Query:   buf.String(),
+			Backend: segment.Backend,
+			Range:   segment.Range.String(),
+		})
+	}
+	return querys, true, nil
+}
+
+// buildSelect used to build the plan of the select which evaluates the join, it returns the
+// keys of the targets, and the columns of the other tables referred by the exprs.
+// eg: 'update t1 join t2 on t1.a=t2.a set t1.b=t2.b where t1.c=1' selects 't1.a, t2.b',
+// then t1 is updated by 'update t1 set b=? where a=? and c=1' for every joined row.
+func (j *joinDML) buildSelect(exprs []sqlparser.Expr) (*SelectPlan, error) {
+	var ons []sqlparser.Expr
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch node := node.(type) {
+		case *sqlparser.Subquery:
+			return false, nil
+		case *sqlparser.JoinTableExpr:
+			if node.On != nil {
+				ons = append(ons, node.On)
+			}
+		}
+		return true, nil
+	}, j.from)
+	var conds []sqlparser.Expr
+	if j.where != nil {
+		conds = splitAndExpression(nil, j.where.Expr)
+	}
+
+	for _, target := range j.targets {
+		target.offset = len(j.columns)
+		for _, on := range ons {
+			if err := j.addKeys(target, on); err != nil {
+				return nil, err
+			}
+		}
+		for _, cond := range conds {
+			refers := getTbInExpr(cond)
+			switch {
+			case len(refers) == 1 && (refers[0] == target.name || refers[0] == ""):
+				target.filters = append(target.filters, stripQualifier(sqlparser.CloneExpr(cond), target.name))
+			case len(refers) > 1:
+				if err := j.addKeys(target, cond); err != nil {
+					return nil, err
+				}
+			}
+		}
+		for _, key := range target.keys {
+			j.columns = append(j.columns, &sqlparser.ColName{
+				Name:      sqlparser.NewColIdent(key),
+				Qualifier: sqlparser.TableName{Name: sqlparser.NewTableIdent(target.name)},
+			})
+		}
+	}
+
+	// The columns of the other tables referred by the exprs.
+	for _, expr := range exprs {
+		err := sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+			if col, ok := node.(*sqlparser.ColName); ok {
+				name, err := j.tableOf(col)
+				if err != nil {
+					return false, err
+				}
+				if !j.isTarget(name) && j.columnIndex(col) == -1 {
+					j.columns = append(j.columns, col)
+				}
+			}
+			return true, nil
+		}, expr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sel := &sqlparser.Select{From: sqlparser.CloneTableExprs(j.from)}
+	for _, col := range j.columns {
+		sel.SelectExprs = append(sel.SelectExprs, &sqlparser.AliasedExpr{Expr: col})
+	}
+	if len(sel.SelectExprs) == 0 {
+		sel.SelectExprs = append(sel.SelectExprs, &sqlparser.AliasedExpr{Expr: sqlparser.NewIntVal([]byte("1"))})
+	}
+	if j.where != nil {
+		sel.Where = sqlparser.NewWhere(sqlparser.WhereStr, sqlparser.CloneExpr(j.where.Expr))
+	}
+	plan := NewSelectPlan(j.log, j.database, sqlparser.String(sel), sel, j.router)
+	if err := plan.Build(); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// addKeys adds the columns of the target referred by the expr to the keys.
+func (j *joinDML) addKeys(target *joinTarget, expr sqlparser.Expr) error {
+	return sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if col, ok := node.(*sqlparser.ColName); ok {
+			name, err := j.tableOf(col)
+			if err != nil {
+				return false, err
+			}
+			if name != target.name {
+				return true, nil
+			}
+			key := col.Name.String()
+			for _, k := range target.keys {
+				if k == key {
+					return true, nil
+				}
+			}
+			target.keys = append(target.keys, key)
+		}
+		return true, nil
+	}, expr)
+}
+
+// isTarget returns true if the table is written.
+func (j *joinDML) isTarget(name string) bool {
+	for _, target := range j.targets {
+		if target.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// columnIndex returns the index of the column in the row of the select, -1 if not found.
+func (j *joinDML) columnIndex(col *sqlparser.ColName) int {
+	for i, c := range j.columns {
+		if c.Qualifier.Name.String() == col.Qualifier.Name.String() && c.Name.Equal(col.Name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// substitute used to rewrite the update exprs for the joined row, the columns of the
+// target are kept without qualifier, the columns of the other tables are replaced by the values.
+func (j *joinDML) substitute(target *joinTarget, exprs sqlparser.UpdateExprs, row []sqltypes.Value) sqlparser.UpdateExprs {
+	rewritten := make(sqlparser.UpdateExprs, 0, len(exprs))
+	for _, expr := range exprs {
+		update := &sqlparser.UpdateExpr{
+			Name: &sqlparser.ColName{Name: expr.Name.Name},
+			Expr: sqlparser.CloneExpr(expr.Expr),
+		}
+		var cols []*sqlparser.ColName
+		_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+			if col, ok := node.(*sqlparser.ColName); ok {
+				cols = append(cols, col)
+			}
+			return true, nil
+		}, update.Expr)
+		for _, col := range cols {
+			if idx := j.columnIndex(col); idx != -1 && col.Qualifier.Name.String() != target.name {
+				sqlparser.ReplaceExpr(update, col, valueToExpr(row[idx]))
+				continue
+			}
+			col.Qualifier = sqlparser.TableName{}
+		}
+		rewritten = append(rewritten, update)
+	}
+	return rewritten
+}
+
+// rowGroup is the joined rows written by one single table DML.
+type rowGroup struct {
+	// value shared by the rows, such as the update exprs.
+	value interface{}
+	// where of the DML, which matches the keys of the rows.
+	where *sqlparser.Where
+}
+
+// groupRows used to group the joined rows by the value, and build the where of every group.
+// The where of the single column key is an 'IN' list which can be routed per partition,
+// the composite key builds a where per row. The rows whose keys are all NULL are skipped,
+// they are the rows that the target has no matching row in the outer join.
+func (j *joinDML) groupRows(target *joinTarget, rows [][]sqltypes.Value, value func(row []sqltypes.Value) (interface{}, string)) []rowGroup {
+	type group struct {
+		value interface{}
+		seen  map[string]bool
+		keys  [][]sqltypes.Value
+	}
+	var groups []*group
+	index := make(map[string]*group)
+	for _, row := range rows {
+		keys := row[target.offset : target.offset+len(target.keys)]
+		nulls := 0
+		strs := make([]string, 0, len(keys))
+		for _, key := range keys {
+			if key.IsNull() {
+				nulls++
+			}
+			strs = append(strs, sqlparser.String(valueToExpr(key)))
+		}
+		if len(keys) > 0 && nulls == len(keys) {
+			continue
+		}
+
+		var v interface{}
+		var vkey string
+		if value != nil {
+			v, vkey = value(row)
+		}
+		g, ok := index[vkey]
+		if !ok {
+			g = &group{value: v, seen: make(map[string]bool)}
+			index[vkey] = g
+			groups = append(groups, g)
+		}
+		if key := strings.Join(strs, ","); !g.seen[key] {
+			g.seen[key] = true
+			g.keys = append(g.keys, keys)
+		}
+	}
+
+	var rgs []rowGroup
+	for _, g := range groups {
+		switch {
+		case len(target.keys) == 0:
+			rgs = append(rgs, rowGroup{value: g.value, where: target.where(nil)})
+		case len(target.keys) == 1:
+			col := &sqlparser.ColName{Name: sqlparser.NewColIdent(target.keys[0])}
+			var cond sqlparser.Expr
+			if len(g.keys) == 1 {
+				cond = &sqlparser.ComparisonExpr{Operator: sqlparser.EqualStr, Left: col, Right: valueToExpr(g.keys[0][0])}
+			} else {
+				var tuple sqlparser.ValTuple
+				for _, keys := range g.keys {
+					tuple = append(tuple, valueToExpr(keys[0]))
+				}
+				cond = &sqlparser.ComparisonExpr{Operator: sqlparser.InStr, Left: col, Right: tuple}
+			}
+			rgs = append(rgs, rowGroup{value: g.value, where: target.where([]sqlparser.Expr{cond})})
+		default:
+			for _, keys := range g.keys {
+				var conds []sqlparser.Expr
+				for i, key := range keys {
+					col := &sqlparser.ColName{Name: sqlparser.NewColIdent(target.keys[i])}
+					if key.IsNull() {
+						conds = append(conds, &sqlparser.IsExpr{Operator: sqlparser.IsNullStr, Expr: col})
+						continue
+					}
+					conds = append(conds, &sqlparser.ComparisonExpr{Operator: sqlparser.EqualStr, Left: col, Right: valueToExpr(key)})
+				}
+				rgs = append(rgs, rowGroup{value: g.value, where: target.where(conds)})
+			}
+		}
+	}
+	return rgs
+}
+
+// where used to build the where of the key conditions and the filters of the target.
+func (t *joinTarget) where(conds []sqlparser.Expr) *sqlparser.Where {
+	var expr sqlparser.Expr
+	for _, cond := range append(conds, t.filters...) {
+		if _, ok := cond.(*sqlparser.OrExpr); ok {
+			cond = &sqlparser.ParenExpr{Expr: cond}
+		}
+		if expr == nil {
+			expr = cond
+			continue
+		}
+		expr = &sqlparser.AndExpr{Left: expr, Right: cond}
+	}
+	if expr == nil {
+		return nil
+	}
+	return sqlparser.NewWhere(sqlparser.WhereStr, expr)
+}
+
+// stripQualifier removes the qualifier of the table's columns in the expr.
+func stripQualifier(expr sqlparser.Expr, table string) sqlparser.Expr {
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if col, ok := node.(*sqlparser.ColName); ok && col.Qualifier.Name.String() == table {
+			col.Qualifier = sqlparser.TableName{}
+		}
+		return true, nil
+	}, expr)
+	return expr
+}
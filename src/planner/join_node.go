@@ -9,12 +9,14 @@
 package planner
 
 import (
+	"evaluation"
 	"fmt"
 	"router"
 	"xcontext"
 
 	"github.com/pkg/errors"
 	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
 	"github.com/xelabs/go-mysqlstack/xlog"
 )
 
@@ -26,6 +28,11 @@ const (
 	Cartesian JoinStrategy = iota
 	// SortMerge Join.
 	SortMerge
+	// HashJoin builds the hash table on the smaller side, and probes the larger one.
+	HashJoin
+	// NestedLoop reads the left side first, then looks up the right side by the
+	// join key values of the left rows, the values are pushed as 'IN' filter in batches.
+	NestedLoop
 )
 
 // JoinKey is the column info in the on conditions.
@@ -47,6 +54,30 @@ type Comparison struct {
 	Exchange bool
 }
 
+// JoinExpr is the expression of the columns from both the left and right, computed after joining.
+type JoinExpr struct {
+	// Field is the name of the expression.
+	Field string
+	// Cols is the referred columns in the left and right results, same as the JoinNode.Cols.
+	Cols []int
+	eval evaluation.Evaluation
+}
+
+// Eval used to compute the expression by the left and right rows, rrow can be nil on left joins.
+func (e *JoinExpr) Eval(lrow, rrow []sqltypes.Value) (sqltypes.Value, error) {
+	row := make([]sqltypes.Value, len(e.Cols))
+	for i, index := range e.Cols {
+		if index < 0 {
+			row[i] = lrow[-index-1]
+			continue
+		}
+		if rrow != nil {
+			row[i] = rrow[index-1]
+		}
+	}
+	return e.eval.Eval(row)
+}
+
 // JoinNode cannot be pushed down.
 type JoinNode struct {
 	log *xlog.Log
@@ -69,7 +100,10 @@ type JoinNode struct {
 	// Cols defines which columns from left or right results used to build the return result.
 	// For results coming from left, the values go as -1, -2, etc. For right, they're 1, 2, etc.
 	// If Cols is {-1, -2, 1, 2}, it means the returned result is {Left0, Left1, Right0, Right1}.
+	// The value 0 means the column is computed by the expression in Exprs.
 	Cols []int `json:",omitempty"`
+	// Exprs is the select expressions cross the left and right, the key is the index in Cols.
+	Exprs map[int]*JoinExpr `json:",omitempty"`
 	// the returned result fields.
 	fields []selectTuple
 	// join on condition tuples.
@@ -78,6 +112,8 @@ type JoinNode struct {
 	LeftKeys, RightKeys []JoinKey
 	// eg: t1 join t2 on t1.a>t2.a, 't1.a>t2.a' parser into CmpFilter.
 	CmpFilter []Comparison
+	// eg: t1 join t2 on t1.a+t2.a>1, 't1.a+t2.a>1' parser into Filters.
+	Filters []*JoinExpr
 	// if Left is MergeNode and LeftKeys contain unique keys, LeftUnique will be true.
 	// used in sort merge join.
 	LeftUnique, RightUnique bool
@@ -105,6 +141,9 @@ type JoinNode struct {
 	// `t1.a` in LeftKeys, `t1.a=1` in tableFilter. in the map,
 	// key is 0(index is 0), value is tableFilter(`t1.a=1`).
 	keyFilters map[int][]filterTuple
+	// LookupKey is the index of the LeftKeys and RightKeys used by the NestedLoop,
+	// the right shard key is preferred to prune the partitions.
+	LookupKey int
 }
 
 // newJoinNode used to create JoinNode.
@@ -163,12 +202,16 @@ func (j *JoinNode) pushFilter(filters []filterTuple) error {
 			tb := filter.referTables[0]
 			tbInfo := j.referredTables[tb]
 			if filter.col == nil {
-				tbInfo.parent.setWhereFilter(filter.expr)
+				tbInfo.node().setWhereFilter(filter.expr)
 			} else {
 				j.tableFilter = append(j.tableFilter, filter)
-				if tbInfo.parent.index == -1 && filter.val != nil && tbInfo.shardKey != "" {
-					if nameMatch(filter.col, tb, tbInfo.shardKey) {
-						if tbInfo.parent.index, err = j.router.GetIndex(tbInfo.database, tbInfo.tableName, filter.val); err != nil {
+				if tbInfo.shardKey != "" && tbInfo.parent.index == -1 && filter.val != nil {
+					val, err := tbInfo.bindShardKey(filter.col.Name.String(), filter.val)
+					if err != nil {
+						return err
+					}
+					if val != nil {
+						if tbInfo.parent.index, err = j.router.GetIndex(tbInfo.database, tbInfo.tableName, val); err != nil {
 							return err
 						}
 					}
@@ -179,11 +222,11 @@ func (j *JoinNode) pushFilter(filters []filterTuple) error {
 			for _, tb := range filter.referTables {
 				tbInfo := j.referredTables[tb]
 				if parent == nil {
-					parent = tbInfo.parent
+					parent = tbInfo.node()
 					continue
 				}
-				if parent != tbInfo.parent {
-					parent = findLCA(j, parent, tbInfo.parent)
+				if parent != tbInfo.node() {
+					parent = findLCA(j, parent, tbInfo.node())
 				}
 			}
 			parent.setWhereFilter(filter.expr)
@@ -282,16 +325,17 @@ func (j *JoinNode) pushOtherJoin(idx *int) error {
 				for _, tb := range filter.referTables {
 					tbInfo := j.referredTables[tb]
 					if parent == nil {
-						parent = tbInfo.parent
+						parent = tbInfo.node()
 						continue
 					}
-					if parent != tbInfo.parent {
-						parent = findLCA(j.Right, parent, tbInfo.parent)
+					if parent != tbInfo.node() {
+						parent = findLCA(j.Right, parent, tbInfo.node())
 					}
 				}
-				if mn, ok := parent.(*MergeNode); ok {
-					mn.setWhereFilter(filter.expr)
-				} else {
+				switch node := parent.(type) {
+				case *MergeNode, *DerivedNode:
+					node.setWhereFilter(filter.expr)
+				default:
 					buf := sqlparser.NewTrackedBuffer(nil)
 					filter.expr.Format(buf)
 					return errors.Errorf("unsupported: on.clause.'%s'.in.cross-shard.join", buf.String())
@@ -310,7 +354,7 @@ func (j *JoinNode) pushEqualCmpr(joins []joinTuple) PlanNode {
 		var parent PlanNode
 		ltb := j.referredTables[joinFilter.referTables[0]]
 		rtb := j.referredTables[joinFilter.referTables[1]]
-		parent = findLCA(j, ltb.parent, rtb.parent)
+		parent = findLCA(j, ltb.node(), rtb.node())
 
 		switch node := parent.(type) {
 		case *MergeNode:
@@ -319,7 +363,8 @@ func (j *JoinNode) pushEqualCmpr(joins []joinTuple) PlanNode {
 			join, _ := checkJoinOn(node.Left, node.Right, joinFilter)
 			if lmn, ok := node.Left.(*MergeNode); ok {
 				if rmn, ok := node.Right.(*MergeNode); ok {
-					if isSameShard(lmn.referredTables, rmn.referredTables, join.left, join.right) {
+					joinOn := append(append([]joinTuple{}, node.joinOn...), join)
+					if isSameShard(lmn.referredTables, rmn.referredTables, joinOn) {
 						mn, _ := mergeRoutes(lmn, rmn, node.joinExpr, nil)
 						mn.setParent(node.parent)
 						mn.setParenthese(node.hasParen)
@@ -376,7 +421,7 @@ func (j *JoinNode) calcRoute() (PlanNode, error) {
 	for _, filter := range j.tableFilter {
 		if !j.buildKeyFilter(filter, false) {
 			tbInfo := j.referredTables[filter.referTables[0]]
-			tbInfo.parent.setWhereFilter(filter.expr)
+			tbInfo.node().setWhereFilter(filter.expr)
 		}
 	}
 	if j.Left, err = j.Left.calcRoute(); err != nil {
@@ -440,8 +485,10 @@ func (j *JoinNode) buildKeyFilter(filter filterTuple, isFind bool) bool {
 					rt := join.right.Qualifier.Name.String()
 					rc := join.right.Name.String()
 					tbInfo := j.referredTables[rt]
-					if tbInfo.parent.index == -1 && tbInfo.shardKey == rc {
-						tbInfo.parent.index, _ = j.router.GetIndex(tbInfo.database, tbInfo.tableName, filter.val)
+					if tbInfo.shardKey != "" && tbInfo.parent.index == -1 {
+						if val, _ := tbInfo.bindShardKey(rc, filter.val); val != nil {
+							tbInfo.parent.index, _ = j.router.GetIndex(tbInfo.database, tbInfo.tableName, val)
+						}
 					}
 				}
 				find = true
@@ -461,8 +508,10 @@ func (j *JoinNode) buildKeyFilter(filter filterTuple, isFind bool) bool {
 					lt := join.left.Qualifier.Name.String()
 					lc := join.left.Name.String()
 					tbInfo := j.referredTables[lt]
-					if tbInfo.parent.index == -1 && tbInfo.shardKey == lc {
-						tbInfo.parent.index, _ = j.router.GetIndex(tbInfo.database, tbInfo.tableName, filter.val)
+					if tbInfo.shardKey != "" && tbInfo.parent.index == -1 {
+						if val, _ := tbInfo.bindShardKey(lc, filter.val); val != nil {
+							tbInfo.parent.index, _ = j.router.GetIndex(tbInfo.database, tbInfo.tableName, val)
+						}
 					}
 				}
 				find = true
@@ -555,6 +604,8 @@ func (j *JoinNode) pushOtherFilters(filters []sqlparser.Expr, idx *int) error {
 				if ridx, err = j.pushOtherFilter(exp.Right, j.Right, right, idx); err != nil {
 					return err
 				}
+				j.CmpFilter = append(j.CmpFilter, Comparison{lidx, ridx, exp.Operator, exchange})
+				continue
 			} else if checkTbInNode(left, rtb) && checkTbInNode(right, ltb) {
 				if lidx, err = j.pushOtherFilter(exp.Right, j.Left, right, idx); err != nil {
 					return err
@@ -563,21 +614,94 @@ func (j *JoinNode) pushOtherFilters(filters []sqlparser.Expr, idx *int) error {
 					return err
 				}
 				exchange = true
-			} else {
-				buf := sqlparser.NewTrackedBuffer(nil)
-				exp.Format(buf)
-				return errors.Errorf("unsupported: clause.'%s'.in.cross-shard.join", buf.String())
+				j.CmpFilter = append(j.CmpFilter, Comparison{lidx, ridx, exp.Operator, exchange})
+				continue
 			}
-			j.CmpFilter = append(j.CmpFilter, Comparison{lidx, ridx, exp.Operator, exchange})
-		} else {
-			buf := sqlparser.NewTrackedBuffer(nil)
-			expr.Format(buf)
-			return errors.Errorf("unsupported: clause.'%s'.in.cross-shard.join", buf.String())
 		}
+		// The filter is computed by the columns of the both sides.
+		filter, err := j.buildJoinExpr(expr, sqlparser.String(expr))
+		if err != nil {
+			return err
+		}
+		j.Filters = append(j.Filters, filter)
 	}
 	return nil
 }
 
+// buildJoinExpr used to build the JoinExpr, the referred columns are pushed into the left and right.
+func (j *JoinNode) buildJoinExpr(expr sqlparser.Expr, field string) (*JoinExpr, error) {
+	joinExpr := &JoinExpr{Field: field}
+	refs := make(map[string]int)
+	ltb := j.Left.getReferredTables()
+	rtb := j.Right.getReferredTables()
+	err := sqlparser.Walk(func(node sqlparser.SQLNode) (kontinue bool, err error) {
+		switch node := node.(type) {
+		case *sqlparser.ColName:
+			key := sqlparser.String(node)
+			if _, ok := refs[key]; ok {
+				return false, nil
+			}
+			table := node.Qualifier.Name.String()
+			var col int
+			if _, ok := ltb[table]; ok {
+				index, err := j.pushColumn(node, j.Left)
+				if err != nil {
+					return false, err
+				}
+				col = -index - 1
+			} else if _, ok := rtb[table]; ok {
+				index, err := j.pushColumn(node, j.Right)
+				if err != nil {
+					return false, err
+				}
+				col = index + 1
+			} else {
+				return false, errors.Errorf("unsupported: unknown.column.'%s'.in.cross-shard.join", key)
+			}
+			refs[key] = len(joinExpr.Cols)
+			joinExpr.Cols = append(joinExpr.Cols, col)
+			return false, nil
+		case *sqlparser.Subquery:
+			return false, errors.Errorf("unsupported: subqueries.in.cross-shard.join")
+		}
+		return true, nil
+	}, expr)
+	if err != nil {
+		return nil, err
+	}
+
+	eval, err := evaluation.Build(expr, func(expr sqlparser.Expr) (int, bool) {
+		if col, ok := expr.(*sqlparser.ColName); ok {
+			index, ok := refs[sqlparser.String(col)]
+			return index, ok
+		}
+		return -1, false
+	})
+	if err != nil {
+		return nil, err
+	}
+	joinExpr.eval = eval
+	return joinExpr, nil
+}
+
+// pushColumn used to push the column into the node if it isn't in the select fields,
+// returns the index in the fields.
+func (j *JoinNode) pushColumn(col *sqlparser.ColName, node PlanNode) (int, error) {
+	field := col.Name.String()
+	table := col.Qualifier.Name.String()
+	for i, tuple := range node.getFields() {
+		if len(tuple.referTables) == 1 && table == tuple.referTables[0] && field == tuple.field {
+			return i, nil
+		}
+	}
+	tuple := selectTuple{
+		expr:        &sqlparser.AliasedExpr{Expr: col},
+		field:       field,
+		referTables: []string{table},
+	}
+	return node.pushSelectExpr(tuple)
+}
+
 // pushOtherFilter used to push otherFilter.
 func (j *JoinNode) pushOtherFilter(expr sqlparser.Expr, node PlanNode, tbs []string, idx *int) (int, error) {
 	var err error
@@ -626,6 +750,17 @@ func (j *JoinNode) pushSelectExpr(field selectTuple) (int, error) {
 			return -1, err
 		}
 		j.Cols = append(j.Cols, index+1)
+	} else if aliased, ok := field.expr.(*sqlparser.AliasedExpr); ok {
+		// The expression is computed by the columns of the both sides.
+		joinExpr, err := j.buildJoinExpr(aliased.Expr, field.field)
+		if err != nil {
+			return -1, err
+		}
+		if j.Exprs == nil {
+			j.Exprs = make(map[int]*JoinExpr)
+		}
+		j.Exprs[len(j.Cols)] = joinExpr
+		j.Cols = append(j.Cols, 0)
 	} else {
 		buf := sqlparser.NewTrackedBuffer(nil)
 		field.expr.Format(buf)
@@ -641,13 +776,13 @@ func (j *JoinNode) handleJoinOn() {
 	// push: select t1.a from t1 order by t1.a asc;
 	//       select t2.a from t2 order by t2.a asc;
 	_, lok := j.Left.(*MergeNode)
-	if !lok {
-		j.Left.(*JoinNode).handleJoinOn()
+	if jn, ok := j.Left.(*JoinNode); ok {
+		jn.handleJoinOn()
 	}
 
 	_, rok := j.Right.(*MergeNode)
-	if !rok {
-		j.Right.(*JoinNode).handleJoinOn()
+	if jn, ok := j.Right.(*JoinNode); ok {
+		jn.handleJoinOn()
 	}
 
 	for _, join := range j.joinOn {
@@ -699,22 +834,31 @@ func (j *JoinNode) buildOrderBy(col *sqlparser.ColName, node PlanNode) JoinKey {
 // pushHaving used to push having exprs.
 func (j *JoinNode) pushHaving(havings []filterTuple) error {
 	for _, filter := range havings {
+		if err := checkHavingAggregate(filter.expr); err != nil {
+			return err
+		}
 		if len(filter.referTables) == 0 {
-			j.Left.pushHaving([]filterTuple{filter})
-			j.Right.pushHaving([]filterTuple{filter})
+			if err := j.Left.pushHaving([]filterTuple{filter}); err != nil {
+				return err
+			}
+			if err := j.Right.pushHaving([]filterTuple{filter}); err != nil {
+				return err
+			}
 		} else if len(filter.referTables) == 1 {
 			tbInfo := j.referredTables[filter.referTables[0]]
-			tbInfo.parent.sel.AddHaving(filter.expr)
+			if err := tbInfo.node().pushHaving([]filterTuple{filter}); err != nil {
+				return err
+			}
 		} else {
 			var parent PlanNode
 			for _, tb := range filter.referTables {
 				tbInfo := j.referredTables[tb]
 				if parent == nil {
-					parent = tbInfo.parent
+					parent = tbInfo.node()
 					continue
 				}
-				if parent != tbInfo.parent {
-					parent = findLCA(j, parent, tbInfo.parent)
+				if parent != tbInfo.node() {
+					parent = findLCA(j, parent, tbInfo.node())
 				}
 			}
 			if mn, ok := parent.(*MergeNode); ok {
@@ -773,10 +917,16 @@ func (j *JoinNode) Children() *PlanTree {
 }
 
 // buildQuery used to build the QueryTuple.
-func (j *JoinNode) buildQuery() {
-	if len(j.LeftKeys) == 0 && len(j.CmpFilter) == 0 {
+func (j *JoinNode) buildQuery() error {
+	switch {
+	case len(j.LeftKeys) == 0 && len(j.CmpFilter) == 0 && len(j.Filters) == 0:
 		j.Strategy = Cartesian
-	} else {
+	case len(j.LeftKeys) > 0 && isSmallNode(j.Left) && !isSmallNode(j.Right) && isLookupNode(j.Right):
+		j.Strategy = NestedLoop
+		j.LookupKey = j.lookupKey()
+	case len(j.LeftKeys) > 0 && (isSmallNode(j.Left) || isSmallNode(j.Right)):
+		j.Strategy = HashJoin
+	default:
 		j.Strategy = SortMerge
 	}
 
@@ -788,10 +938,16 @@ func (j *JoinNode) buildQuery() {
 		for _, filter := range filters {
 			filter.col.Qualifier.Name = sqlparser.NewTableIdent(table)
 			filter.col.Name = sqlparser.NewColIdent(field)
+			if tbInfo.derived != nil {
+				tbInfo.derived.setWhereFilter(filter.expr)
+				continue
+			}
 			tbInfo.parent.filters[filter.expr] = 0
 		}
 	}
-	j.Left.buildQuery()
+	if err := j.Left.buildQuery(); err != nil {
+		return err
+	}
 
 	j.Right.setNoTableFilter(j.noTableFilter)
 	for i, filters := range j.keyFilters {
@@ -801,10 +957,62 @@ func (j *JoinNode) buildQuery() {
 		for _, filter := range filters {
 			filter.col.Qualifier.Name = sqlparser.NewTableIdent(table)
 			filter.col.Name = sqlparser.NewColIdent(field)
+			if tbInfo.derived != nil {
+				tbInfo.derived.setWhereFilter(filter.expr)
+				continue
+			}
 			tbInfo.parent.filters[filter.expr] = 0
 		}
 	}
-	j.Right.buildQuery()
+	return j.Right.buildQuery()
+}
+
+// isSmallNode returns true if the result of the node is expected to be small, the node
+// is a MergeNode which reads the GLOBAL tables or one partition, such as the shard key is
+// pinned by the filters. The filters on the other columns don't measure the size, the
+// sides of them are chosen by the cost if the statistics are set.
+func isSmallNode(node PlanNode) bool {
+	mn, ok := node.(*MergeNode)
+	if !ok {
+		return false
+	}
+	return mn.shardCount == 0 || mn.routeLen == 1
+}
+
+// isLookupNode returns true if the node can be looked up by the 'IN' filter of the join key,
+// the filter can't be pushed into the node which groups or limits the rows.
+func isLookupNode(node PlanNode) bool {
+	mn, ok := node.(*MergeNode)
+	if !ok {
+		return false
+	}
+	return len(mn.children.Plans()) == 0 && mn.sel.Limit == nil && len(mn.sel.GroupBy) == 0 && mn.sel.Having == nil
+}
+
+// lookupKey returns the index of the join key whose right column is the shard key, 0 if none.
+func (j *JoinNode) lookupKey() int {
+	for i, key := range j.RightKeys {
+		tbInfo := j.referredTables[key.Table]
+		if tbInfo.shardKey != "" && tbInfo.shardKey == key.Field {
+			return i
+		}
+	}
+	return 0
+}
+
+// LookupQuerys used to build the querys of the right node in the NestedLoop, the values of
+// the LookupKey read from the left rows are pushed as 'IN' filter.
+func (j *JoinNode) LookupQuerys(vals []sqltypes.Value) ([]xcontext.QueryTuple, error) {
+	mn, ok := j.Right.(*MergeNode)
+	if !ok {
+		return nil, errors.New("unsupported: lookup.join.right.node.must.be.merge.node")
+	}
+	key := j.RightKeys[j.LookupKey]
+	col := &sqlparser.ColName{
+		Name:      sqlparser.NewColIdent(key.Field),
+		Qualifier: sqlparser.TableName{Name: sqlparser.NewTableIdent(key.Table)},
+	}
+	return mn.lookupQuerys(col, vals)
 }
 
 // GetQuery used to get the Querys.
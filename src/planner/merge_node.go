@@ -15,6 +15,7 @@ import (
 	"xcontext"
 
 	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
 	"github.com/xelabs/go-mysqlstack/xlog"
 )
 
@@ -88,11 +89,36 @@ func (m *MergeNode) pushFilter(filters []filterTuple) error {
 		m.sel.AddWhere(filter.expr)
 		if len(filter.referTables) == 1 {
 			tbInfo := m.referredTables[filter.referTables[0]]
-			if tbInfo.shardType != "GLOBAL" && tbInfo.parent.index == -1 && filter.val != nil {
-				if nameMatch(filter.col, filter.referTables[0], tbInfo.shardKey) {
-					if tbInfo.parent.index, err = m.router.GetIndex(tbInfo.database, tbInfo.tableName, filter.val); err != nil {
+			if tbInfo.shardKey != "" && tbInfo.parent.index == -1 {
+				if filter.val != nil {
+					val, err := tbInfo.bindShardKey(filter.col.Name.String(), filter.val)
+					if err != nil {
 						return err
 					}
+					if val != nil {
+						if tbInfo.parent.index, err = m.router.GetIndex(tbInfo.database, tbInfo.tableName, val); err != nil {
+							return err
+						}
+					}
+					continue
+				}
+				if tbInfo.keyIn == nil {
+					expr, vals := getShardKeyValues(skipParenthesis(filter.expr), filter.referTables[0], tbInfo.shardKey)
+					if expr != nil && !replaceShardKeyOr(m.sel.Where, skipParenthesis(filter.expr), expr) {
+						expr = nil
+					}
+					if vals != nil {
+						if tbInfo.keyIn, err = newShardKeyIn(tbInfo.database, tbInfo.tableName, expr, vals, m.router); err != nil {
+							return err
+						}
+					}
+				}
+				start, end := getShardKeyBound(skipParenthesis(filter.expr), filter.referTables[0], tbInfo.shardKey)
+				if tbInfo.start, err = tighterBound(m.router, tbInfo.database, tbInfo.tableName, tbInfo.start, start, true); err != nil {
+					return err
+				}
+				if tbInfo.end, err = tighterBound(m.router, tbInfo.database, tbInfo.tableName, tbInfo.end, end, false); err != nil {
+					return err
 				}
 			}
 		}
@@ -143,7 +169,19 @@ func (m *MergeNode) calcRoute() (PlanNode, error) {
 		if tbInfo.shardType == "GLOBAL" {
 			continue
 		}
-		tbInfo.Segments, err = m.router.GetSegments(tbInfo.database, tbInfo.tableName, m.index)
+		// The 'IN' and range route only work when there's one shard table,
+		// otherwise the segments of the tables can't be aligned.
+		if m.index == -1 && m.shardCount == 1 && tbInfo.keyIn != nil {
+			tbInfo.Segments = tbInfo.keyIn.segments
+		} else if m.index == -1 && m.shardCount == 1 && (tbInfo.start != nil || tbInfo.end != nil) {
+			start, end := tbInfo.start, tbInfo.end
+			if start != nil && end != nil && start.Type != end.Type {
+				start, end = nil, nil
+			}
+			tbInfo.Segments, err = m.router.Lookup(tbInfo.database, tbInfo.tableName, start, end)
+		} else {
+			tbInfo.Segments, err = m.router.GetSegments(tbInfo.database, tbInfo.tableName, m.index)
+		}
 		if err != nil {
 			return m, err
 		}
@@ -162,13 +200,29 @@ func (m *MergeNode) pushSelectExprs(fields, groups []selectTuple, sel *sqlparser
 	m.sel.SelectExprs = sel.SelectExprs
 	m.sel.GroupBy = sel.GroupBy
 	m.sel.Distinct = sel.Distinct
+	var havings []sqlparser.Expr
+	if sel.Having != nil {
+		havings = splitAndExpression(nil, sel.Having.Expr)
+		hasAggregates = hasAggregates || hasAggregate(sel.Having.Expr)
+	}
 	if hasAggregates || len(groups) > 0 {
 		aggrPlan := NewAggregatePlan(m.log, m.sel.SelectExprs, fields, groups)
+		// The havings of the aggregated values are filtered after aggregating.
+		for _, having := range havings {
+			if having = skipParenthesis(having); aggrPlan.aggregated(having) {
+				aggrPlan.havings = append(aggrPlan.havings, having)
+			}
+		}
 		if err := aggrPlan.Build(); err != nil {
 			return err
 		}
 		m.children.Add(aggrPlan)
 		m.sel.SelectExprs = aggrPlan.ReWritten()
+		// The shards group by the argument of the distinct functions, so the values are distinct in every group.
+		if distinct := aggrPlan.Distinct(); distinct != nil {
+			m.sel.GroupBy = append(sqlparser.GroupBy{}, sel.GroupBy...)
+			m.sel.GroupBy = append(m.sel.GroupBy, distinct)
+		}
 	}
 	return nil
 }
@@ -182,19 +236,36 @@ func (m *MergeNode) pushSelectExpr(field selectTuple) (int, error) {
 
 // pushHaving used to push having exprs.
 func (m *MergeNode) pushHaving(havings []filterTuple) error {
+	aggrPlan := m.aggregatePlan()
 	for _, filter := range havings {
+		if aggrPlan != nil && aggrPlan.aggregated(filter.expr) {
+			continue
+		}
+		if err := checkHavingAggregate(filter.expr); err != nil {
+			return err
+		}
 		m.sel.AddHaving(filter.expr)
 	}
 	return nil
 }
 
+// aggregatePlan returns the AggregatePlan of the children, nil if there's none.
+func (m *MergeNode) aggregatePlan() *AggregatePlan {
+	for _, plan := range m.children.Plans() {
+		if aggrPlan, ok := plan.(*AggregatePlan); ok {
+			return aggrPlan
+		}
+	}
+	return nil
+}
+
 // pushOrderBy used to push the order by exprs.
 func (m *MergeNode) pushOrderBy(sel *sqlparser.Select, fields []selectTuple) error {
 	if len(sel.OrderBy) > 0 {
 		m.sel.OrderBy = sel.OrderBy
 	} else {
 		// group by implicitly contains order by.
-		for _, by := range m.sel.GroupBy {
+		for _, by := range sel.GroupBy {
 			m.sel.OrderBy = append(m.sel.OrderBy, &sqlparser.Order{
 				Expr:      by,
 				Direction: sqlparser.AscScr,
@@ -219,6 +290,10 @@ func (m *MergeNode) pushLimit(sel *sqlparser.Select) error {
 		return err
 	}
 	m.children.Add(limitPlan)
+	// The groups are filtered by the havings after aggregating, so the limit can't be pushed down.
+	if aggrPlan := m.aggregatePlan(); aggrPlan != nil && len(aggrPlan.havings) > 0 {
+		return nil
+	}
 	// Rewrite the limit clause.
 	m.sel.Limit = limitPlan.ReWritten()
 	return nil
@@ -236,15 +311,23 @@ func (m *MergeNode) Children() *PlanTree {
 }
 
 // buildQuery used to build the QueryTuple.
-func (m *MergeNode) buildQuery() {
-	var Range string
-	for expr := range m.filters {
-		m.sel.AddWhere(expr)
-	}
+func (m *MergeNode) buildQuery() error {
 	if len(m.sel.SelectExprs) == 0 {
 		m.sel.SelectExprs = append(m.sel.SelectExprs, &sqlparser.AliasedExpr{
 			Expr: sqlparser.NewIntVal([]byte("1"))})
 	}
+	m.Querys = append(m.Querys, m.routeQuerys(m.sel.Format)...)
+	return nil
+}
+
+// routeQuerys used to build the QueryTuple of every route, the statement
+// is formatted after the shard tables' name are rewritten.
+func (m *MergeNode) routeQuerys(format func(buf *sqlparser.TrackedBuffer)) []xcontext.QueryTuple {
+	var Range string
+	var querys []xcontext.QueryTuple
+	for expr := range m.filters {
+		m.sel.AddWhere(expr)
+	}
 	for i := 0; i < m.routeLen; i++ {
 		// Rewrite the shard table's name.
 		backend := m.backend
@@ -259,9 +342,10 @@ func (m *MergeNode) buildQuery() {
 			expr, _ := tbInfo.tableExpr.Expr.(sqlparser.TableName)
 			expr.Name = sqlparser.NewTableIdent(tbInfo.Segments[i].Table)
 			tbInfo.tableExpr.Expr = expr
+			tbInfo.keyIn.rewrite(tbInfo.Segments[i].Table)
 		}
 		buf := sqlparser.NewTrackedBuffer(nil)
-		m.sel.Format(buf)
+		format(buf)
 		rewritten := buf.String()
 
 		tuple := xcontext.QueryTuple{
@@ -269,8 +353,109 @@ func (m *MergeNode) buildQuery() {
 			Backend: backend,
 			Range:   Range,
 		}
-		m.Querys = append(m.Querys, tuple)
+		querys = append(querys, tuple)
+	}
+	for _, tbInfo := range m.referredTables {
+		tbInfo.keyIn.restore()
+	}
+	return querys
+}
+
+// lookupQuerys used to build the per-partition querys with the 'col IN (vals)' filter. They're
+// built at execution time, so the ast isn't changed, the filter is added to a copy of the select
+// and the shard tables' names are rewritten by the formatter. If the col is the shard key, only
+// the partitions of the values are queried. If there's no value or partition to query, the first
+// route is queried by 'IN (NULL)', which returns no rows but the fields. The rows are joined by
+// hash, so the 'order by' of the join keys is dropped.
+func (m *MergeNode) lookupQuerys(col *sqlparser.ColName, vals []sqltypes.Value) ([]xcontext.QueryTuple, error) {
+	var err error
+	var keyIn *shardKeyIn
+	var sqlVals []*sqlparser.SQLVal
+	tuple := make(sqlparser.ValTuple, 0, len(vals))
+	for _, v := range vals {
+		expr := valueToExpr(v)
+		if val, ok := expr.(*sqlparser.SQLVal); ok {
+			sqlVals = append(sqlVals, val)
+		}
+		tuple = append(tuple, expr)
+	}
+	in := &sqlparser.ComparisonExpr{
+		Operator: sqlparser.InStr,
+		Left:     col,
+		Right:    tuple,
+	}
+
+	tbInfo := m.referredTables[col.Qualifier.Name.String()]
+	if m.shardCount == 1 && tbInfo != nil && tbInfo.shardKey != "" && tbInfo.shardKey == col.Name.String() &&
+		len(sqlVals) > 0 && len(sqlVals) == len(vals) {
+		if keyIn, err = newShardKeyIn(tbInfo.database, tbInfo.tableName, in, sqlVals, m.router); err != nil {
+			return nil, err
+		}
+	}
+
+	sel := *m.sel
+	sel.OrderBy = nil
+	where := sqlparser.Expr(in)
+	if m.sel.Where != nil {
+		left := m.sel.Where.Expr
+		if _, ok := left.(*sqlparser.OrExpr); ok {
+			left = &sqlparser.ParenExpr{Expr: left}
+		}
+		where = &sqlparser.AndExpr{Left: left, Right: in}
+	}
+	sel.Where = sqlparser.NewWhere(sqlparser.WhereStr, where)
+
+	renames := make(map[*sqlparser.AliasedTableExpr]sqlparser.TableName)
+	formatter := func(buf *sqlparser.TrackedBuffer, node sqlparser.SQLNode) {
+		if expr, ok := node.(*sqlparser.AliasedTableExpr); ok {
+			if name, ok := renames[expr]; ok {
+				renamed := *expr
+				renamed.Expr = name
+				renamed.Format(buf)
+				return
+			}
+		}
+		node.Format(buf)
+	}
+	build := func(i int) xcontext.QueryTuple {
+		var Range string
+		backend := m.backend
+		for _, tbInfo := range m.referredTables {
+			if tbInfo.shardType == "GLOBAL" {
+				continue
+			}
+			if backend == "" {
+				backend = tbInfo.Segments[i].Backend
+			}
+			Range = tbInfo.Segments[i].Range.String()
+			name, _ := tbInfo.tableExpr.Expr.(sqlparser.TableName)
+			name.Name = sqlparser.NewTableIdent(tbInfo.Segments[i].Table)
+			renames[tbInfo.tableExpr] = name
+		}
+		buf := sqlparser.NewTrackedBuffer(formatter)
+		buf.Myprintf("%v", &sel)
+		return xcontext.QueryTuple{
+			Query:   buf.String(),
+			Backend: backend,
+			Range:   Range,
+		}
+	}
+
+	var querys []xcontext.QueryTuple
+	for i := 0; i < m.routeLen && len(tuple) > 0; i++ {
+		if keyIn != nil {
+			if _, ok := keyIn.vals[tbInfo.Segments[i].Table]; !ok {
+				continue
+			}
+			keyIn.rewrite(tbInfo.Segments[i].Table)
+		}
+		querys = append(querys, build(i))
+	}
+	if len(querys) == 0 {
+		in.Right = sqlparser.ValTuple{&sqlparser.NullVal{}}
+		querys = append(querys, build(0))
 	}
+	return querys, nil
 }
 
 // GetQuery used to get the Querys.
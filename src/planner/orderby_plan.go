@@ -10,6 +10,7 @@ package planner
 
 import (
 	"encoding/json"
+	"evaluation"
 
 	"github.com/pkg/errors"
 	"github.com/xelabs/go-mysqlstack/sqlparser"
@@ -36,6 +37,13 @@ type OrderBy struct {
 	Field     string
 	Table     string
 	Direction Direction
+	// eval is the evaluation of the order by expression, nil if it's a column.
+	eval evaluation.Evaluation
+}
+
+// Evaluation returns the evaluation of the order by expression, nil if it's a column.
+func (o OrderBy) Evaluation() evaluation.Evaluation {
+	return o.eval
 }
 
 // OrderByPlan represents order-by plan.
@@ -62,21 +70,22 @@ func NewOrderByPlan(log *xlog.Log, node *sqlparser.Select, tuples []selectTuple,
 // analyze used to check the 'order by' is at the support level.
 // Supports:
 // 1. sqlparser.ColName: 'select a from t order by a'
+// 2. the expression of the select fields: 'select a, b from t order by a+b'
 //
 // Unsupported(orderby field must be in select list):
 // 1. 'select a from t order by b'
 func (p *OrderByPlan) analyze() error {
 	order := p.node.OrderBy
 	for _, o := range order {
+		orderBy := OrderBy{}
+		switch o.Direction {
+		case "desc":
+			orderBy.Direction = DESC
+		case "asc":
+			orderBy.Direction = ASC
+		}
 		switch e := o.Expr.(type) {
 		case *sqlparser.ColName:
-			orderBy := OrderBy{}
-			switch o.Direction {
-			case "desc":
-				orderBy.Direction = DESC
-			case "asc":
-				orderBy.Direction = ASC
-			}
 			orderBy.Field = e.Name.String()
 			orderBy.Table = e.Qualifier.Name.String()
 			if orderBy.Table != "" {
@@ -89,13 +98,59 @@ func (p *OrderByPlan) analyze() error {
 				return errors.Errorf("unsupported: orderby[%+v].should.in.select.list", orderBy.Field)
 			}
 			p.OrderBys = append(p.OrderBys, orderBy)
-		default:
+		case *sqlparser.SQLVal:
 			return errors.Errorf("unsupported: orderby:%+v", o.Expr)
+		default:
+			for _, tuple := range p.tuples {
+				if tuple.field == "*" {
+					return errors.Errorf("unsupported: orderby[%s].and.'*'.select.exprs", sqlparser.String(e))
+				}
+			}
+			eval, err := evaluation.Build(e, p.resolve)
+			if err != nil {
+				return err
+			}
+			orderBy.Field = sqlparser.String(e)
+			orderBy.eval = eval
+			p.OrderBys = append(p.OrderBys, orderBy)
 		}
 	}
 	return nil
 }
 
+// resolve returns the index of the select field which is the expr, the column
+// is matched by the field name.
+func (p *OrderByPlan) resolve(expr sqlparser.Expr) (int, bool) {
+	key := sqlparser.String(expr)
+	for i, tuple := range p.tuples {
+		if aliased, ok := tuple.expr.(*sqlparser.AliasedExpr); ok && sqlparser.String(aliased.Expr) == key {
+			return i, true
+		}
+	}
+	if col, ok := expr.(*sqlparser.ColName); ok {
+		table := col.Qualifier.Name.String()
+		for i, tuple := range p.tuples {
+			if tuple.field != col.Name.String() {
+				continue
+			}
+			if table == "" || (len(tuple.referTables) == 1 && tuple.referTables[0] == table) {
+				return i, true
+			}
+		}
+	}
+	return -1, false
+}
+
+// HasExpr returns true if there's an order by expression.
+func (p *OrderByPlan) HasExpr() bool {
+	for _, orderBy := range p.OrderBys {
+		if orderBy.eval != nil {
+			return true
+		}
+	}
+	return false
+}
+
 // Build used to build distributed querys.
 func (p *OrderByPlan) Build() error {
 	return p.analyze()
@@ -27,6 +27,8 @@ func TestOrderByPlan(t *testing.T) {
 		"select * from A order by A.a",
 		"select a from A order by A.a",
 		"select A.a from A order by a",
+		"select a, b from A order by a+b desc, b",
+		"select a+b, sum(c) as s from A group by a+b order by a+b, s*2",
 	}
 
 	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
@@ -58,11 +60,15 @@ func TestOrderByPlanError(t *testing.T) {
 		"select a,b from A order by c",
 		"select a,b from A order by rand()",
 		"select A.* from A order by X.a",
+		"select a,b from A order by a+c",
+		"select * from A order by a+1",
 	}
 	results := []string{
 		"unsupported: orderby[c].should.in.select.list",
-		"unsupported: orderby:&{Qualifier: Name:rand Distinct:false Exprs:[]}",
+		"unsupported: expr.'rand()'.in.evaluation",
 		"unsupported: unknow.table.in.order.by.field[X.a]",
+		"unsupported: unknown.column.'c'.in.evaluation",
+		"unsupported: orderby[a + 1].and.'*'.select.exprs",
 	}
 
 	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
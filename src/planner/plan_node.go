@@ -32,7 +32,7 @@ type PlanNode interface {
 	pushLimit(sel *sqlparser.Select) error
 	pushMisc(sel *sqlparser.Select)
 	Children() *PlanTree
-	buildQuery()
+	buildQuery() error
 	GetQuery() []xcontext.QueryTuple
 }
 
@@ -8,7 +8,9 @@
 
 package planner
 
-import ()
+import (
+	"router"
+)
 
 // Plan interface.
 type Plan interface {
@@ -58,3 +60,53 @@ func (pt *PlanTree) Plans() []Plan {
 func (pt *PlanTree) Size() int {
 	return pt.size
 }
+
+// writeTable is the table written by the plan.
+type writeTable struct {
+	database string
+	table    string
+}
+
+// writeTables records the tables written by the DML plan. The freeze of the tables
+// is checked when the plan is built, and checked again by BeginWrite before the
+// querys are executed, the plan may be built before the table is frozen.
+type writeTables struct {
+	router *router.Router
+	tables []writeTable
+}
+
+// checkWritable checks the table is writable and records it.
+func (w *writeTables) checkWritable(route *router.Router, database string, table string) error {
+	if err := route.CheckWritable(database, table); err != nil {
+		return err
+	}
+	w.router = route
+	for _, t := range w.tables {
+		if t.database == database && t.table == table {
+			return nil
+		}
+	}
+	w.tables = append(w.tables, writeTable{database: database, table: table})
+	return nil
+}
+
+// BeginWrite used to re-check the freeze of the tables at execute time,
+// the tables can't be frozen until EndWrite is called when the txn finishes.
+func (w *writeTables) BeginWrite() error {
+	for i, t := range w.tables {
+		if err := w.router.BeginWrite(t.database, t.table); err != nil {
+			for _, begun := range w.tables[:i] {
+				w.router.EndWrite(begun.database, begun.table)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// EndWrite used to finish the writes started by BeginWrite.
+func (w *writeTables) EndWrite() {
+	for _, t := range w.tables {
+		w.router.EndWrite(t.database, t.table)
+	}
+}
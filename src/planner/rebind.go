@@ -0,0 +1,60 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package planner
+
+import (
+	"xcontext"
+)
+
+// RebindQuerys returns the querys of the plan which can be re-bound, false if the plan
+// isn't only the querys sent to the partitions. The plan must be a select pushed down
+// as a MergeNode, or a single-table delete or update which doesn't move the shard key.
+func RebindQuerys(plan Plan) ([]xcontext.QueryTuple, bool) {
+	switch plan := plan.(type) {
+	case *SelectPlan:
+		if m, ok := plan.Root.(*MergeNode); ok && len(plan.subqueries) == 0 {
+			return m.Querys, true
+		}
+	case *DeletePlan:
+		if len(plan.subqueries) == 0 && plan.join == nil && plan.Select == nil {
+			return plan.Querys, true
+		}
+	case *UpdatePlan:
+		if len(plan.subqueries) == 0 && plan.join == nil && plan.Select == nil && !plan.MoveShardKey {
+			return plan.Querys, true
+		}
+	}
+	return nil, false
+}
+
+// Rebind returns a copy of the plan whose raw query and querys are replaced, the plan
+// must be accepted by RebindQuerys. The plan isn't changed, so it can be shared by the
+// copies executed at the same time.
+func Rebind(plan Plan, query string, querys []xcontext.QueryTuple) Plan {
+	switch plan := plan.(type) {
+	case *SelectPlan:
+		root := *plan.Root.(*MergeNode)
+		root.Querys = querys
+		rebound := *plan
+		rebound.RawQuery = query
+		rebound.Root = &root
+		return &rebound
+	case *DeletePlan:
+		rebound := *plan
+		rebound.RawQuery = query
+		rebound.Querys = querys
+		return &rebound
+	case *UpdatePlan:
+		rebound := *plan
+		rebound.RawQuery = query
+		rebound.Querys = querys
+		return &rebound
+	}
+	return plan
+}
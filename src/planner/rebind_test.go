@@ -0,0 +1,83 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package planner
+
+import (
+	"testing"
+
+	"router"
+	"xcontext"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+func TestRebind(t *testing.T) {
+	tcases := []struct {
+		query string
+		ok    bool
+	}{
+		{"select id from A where a=1", true},
+		{"select b, count(*) from A where a=1 group by b order by b limit 1", true},
+		{"select A.id from A join B on A.a=B.a", false},
+		{"select id from A where a in (select a from B)", false},
+		{"delete from A where a=1", true},
+		{"delete A from A join B on A.id=B.id where B.a=1", false},
+		{"update A set b=1 where a=1", true},
+		{"update A set id=1 where a=1", false},
+		{"insert into A(id) values(1)", false},
+	}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableMConfig(), router.MockTableBConfig())
+	assert.Nil(t, err)
+	for _, tcase := range tcases {
+		node, err := sqlparser.Parse(tcase.query)
+		assert.Nil(t, err)
+
+		var plan Plan
+		switch node := node.(type) {
+		case *sqlparser.Select:
+			plan = NewSelectPlan(log, database, tcase.query, node, route)
+		case *sqlparser.Delete:
+			plan = NewDeletePlan(log, database, tcase.query, node, route)
+		case *sqlparser.Update:
+			plan = NewUpdatePlan(log, database, tcase.query, node, route)
+		case *sqlparser.Insert:
+			plan = NewInsertPlan(log, database, tcase.query, node, route)
+		}
+		err = plan.Build()
+		assert.Nil(t, err, tcase.query)
+
+		querys, ok := RebindQuerys(plan)
+		assert.Equal(t, tcase.ok, ok, tcase.query)
+		if !ok {
+			continue
+		}
+		json := plan.JSON()
+		rebound := make([]xcontext.QueryTuple, len(querys))
+		for i, query := range querys {
+			rebound[i] = xcontext.QueryTuple{Query: query.Query + " /* rebound */", Backend: query.Backend, Range: query.Range}
+		}
+		got := Rebind(plan, "rebound", rebound)
+		assert.Equal(t, plan.Type(), got.Type())
+		gotQuerys, ok := RebindQuerys(got)
+		assert.True(t, ok)
+		assert.Equal(t, rebound, gotQuerys)
+		assert.Contains(t, got.JSON(), "rebound")
+		// The origin isn't changed.
+		assert.Equal(t, json, plan.JSON())
+	}
+}
@@ -18,11 +18,13 @@ import (
 	"github.com/pkg/errors"
 	"github.com/xelabs/go-mysqlstack/sqlparser"
 	"github.com/xelabs/go-mysqlstack/sqlparser/depends/common"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
 	"github.com/xelabs/go-mysqlstack/xlog"
 )
 
 var (
-	_ Plan = &SelectPlan{}
+	_ Plan         = &SelectPlan{}
+	_ SubqueryPlan = &SelectPlan{}
 )
 
 // SelectPlan represents select plan.
@@ -32,8 +34,8 @@ type SelectPlan struct {
 	// router
 	router *router.Router
 
-	// select ast
-	node *sqlparser.Select
+	// select ast, the select or the union.
+	node sqlparser.SelectStatement
 
 	// database
 	database string
@@ -50,11 +52,17 @@ type SelectPlan struct {
 	// children plans in select(such as: orderby, limit or join).
 	children *PlanTree
 
+	// subqueries to materialize before building the Root.
+	subqueries []*Subquery
+
+	// stats used to choose the join strategies by the cost, nil means the default strategies.
+	stats Statistics
+
 	Root PlanNode
 }
 
 // NewSelectPlan used to create SelectPlan.
-func NewSelectPlan(log *xlog.Log, database string, query string, node *sqlparser.Select, router *router.Router) *SelectPlan {
+func NewSelectPlan(log *xlog.Log, database string, query string, node sqlparser.SelectStatement, router *router.Router) *SelectPlan {
 	return &SelectPlan{
 		log:      log,
 		node:     node,
@@ -66,96 +74,164 @@ func NewSelectPlan(log *xlog.Log, database string, query string, node *sqlparser
 	}
 }
 
-// analyze used to check the 'select' is at the support level, and get the db, table, etc..
+// analyze used to check the 'select' is at the support level, and get the subqueries.
 // Unsupports:
-// 1. subquery.
+// 1. correlated subquery.
 func (p *SelectPlan) analyze() error {
-	// Check subquery.
-	if hasSubquery(p.node) {
-		return errors.New("unsupported: subqueries.in.select")
+	var err error
+	node := p.node
+	if !hasSubquery(node) {
+		return nil
 	}
-	return nil
+
+	// Keep the field name of the select expr, the subquery will be substituted.
+	for _, sel := range unionArms(node) {
+		for _, expr := range sel.SelectExprs {
+			if aliased, ok := expr.(*sqlparser.AliasedExpr); ok && aliased.As.IsEmpty() && hasSubquery(aliased.Expr) {
+				aliased.As = sqlparser.NewColIdent(sqlparser.String(aliased.Expr))
+			}
+		}
+	}
+	p.subqueries, err = buildSubqueries(p.log, p.router, p.database, node)
+	return err
 }
 
 // Build used to build distributed querys.
-// For now, we don't support subquery in select.
+// If there are subqueries, the build is deferred until they are materialized.
 func (p *SelectPlan) Build() error {
-	var err error
-	log := p.log
-	node := p.node
+	if err := p.analyze(); err != nil {
+		return err
+	}
+	if len(p.subqueries) > 0 {
+		return nil
+	}
+	return p.build()
+}
 
-	// Check subquery.
-	if err = p.analyze(); err != nil {
+// Subqueries returns the subqueries not yet materialized.
+func (p *SelectPlan) Subqueries() []*Subquery {
+	return p.subqueries
+}
+
+// Materialize substitutes the results of the subqueries, then builds the plan.
+func (p *SelectPlan) Materialize(results []*sqltypes.Result) error {
+	if err := substituteSubqueries(p.node, p.subqueries, results); err != nil {
 		return err
 	}
-	if p.Root, err = scanTableExprs(log, p.router, p.database, node.From); err != nil {
+	p.subqueries = nil
+	return p.build()
+}
+
+// build used to build the plan tree.
+func (p *SelectPlan) build() error {
+	var err error
+	if p.Root, err = processSelectStatement(p.log, p.router, p.database, p.node); err != nil {
 		return err
 	}
+	if p.stats != nil {
+		model := &costModel{stats: p.stats}
+		model.chooseStrategy(p.Root)
+	}
+	return nil
+}
+
+// SetStatistics used to set the table statistics, the join strategies are chosen by the
+// estimated cost when the plan is built.
+func (p *SelectPlan) SetStatistics(stats Statistics) {
+	p.stats = stats
+}
+
+// Cost returns the estimated cost of the plan by the statistics, 0 if the plan isn't built.
+func (p *SelectPlan) Cost() float64 {
+	if p.Root == nil || p.stats == nil {
+		return 0
+	}
+	model := &costModel{stats: p.stats}
+	return model.cost(p.Root)
+}
+
+// processSelectStatement used to build the plan tree of the select or the union.
+func processSelectStatement(log *xlog.Log, router *router.Router, database string, node sqlparser.SelectStatement) (PlanNode, error) {
+	switch node := node.(type) {
+	case *sqlparser.Select:
+		return processSelect(log, router, database, node)
+	case *sqlparser.Union:
+		return processUnion(log, router, database, node)
+	case *sqlparser.ParenSelect:
+		return processSelectStatement(log, router, database, node.Select)
+	}
+	return nil, errors.Errorf("unsupported: select.statement.type[%T]", node)
+}
 
-	tbInfos := p.Root.getReferredTables()
+// processSelect used to build the plan tree of the select.
+func processSelect(log *xlog.Log, router *router.Router, database string, node *sqlparser.Select) (PlanNode, error) {
+	root, err := scanTableExprs(log, router, database, node.From)
+	if err != nil {
+		return nil, err
+	}
+
+	tbInfos := root.getReferredTables()
 	if node.Where != nil {
 		joins, filters, err := parserWhereOrJoinExprs(node.Where.Expr, tbInfos)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		if err = p.Root.pushFilter(filters); err != nil {
-			return err
+		if err = root.pushFilter(filters); err != nil {
+			return nil, err
 		}
-		p.Root = p.Root.pushEqualCmpr(joins)
+		root = root.pushEqualCmpr(joins)
 	}
-	if p.Root, err = p.Root.calcRoute(); err != nil {
-		return err
+	if root, err = root.calcRoute(); err != nil {
+		return nil, err
 	}
 
-	mn, ok := p.Root.(*MergeNode)
+	mn, ok := root.(*MergeNode)
 	if ok && mn.routeLen == 1 {
 		node.From = mn.sel.From
 		node.Where = mn.sel.Where
 		mn.sel = node
-		mn.buildQuery()
-		return nil
+		return mn, mn.buildQuery()
 	}
 
 	var groups []selectTuple
-	fields, hasAggregates, err := parserSelectExprs(node.SelectExprs, p.Root)
+	fields, hasAggregates, err := parserSelectExprs(node.SelectExprs, root)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if groups, err = checkGroupBy(node.GroupBy, fields, p.router, tbInfos); err != nil {
-		return err
+	if groups, err = checkGroupBy(node.GroupBy, fields, router, tbInfos); err != nil {
+		return nil, err
 	}
 
-	if groups, err = checkDistinct(node, groups, fields, p.router, tbInfos); err != nil {
-		return err
+	if groups, err = checkDistinct(node, groups, fields, router, tbInfos); err != nil {
+		return nil, err
 	}
 
-	if err = p.Root.pushSelectExprs(fields, groups, node, hasAggregates); err != nil {
-		return err
+	if err = root.pushSelectExprs(fields, groups, node, hasAggregates); err != nil {
+		return nil, err
 	}
 
 	if node.Having != nil {
 		havings, err := parserHaving(node.Having.Expr, tbInfos)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		if err = p.Root.pushHaving(havings); err != nil {
-			return err
+		if err = root.pushHaving(havings); err != nil {
+			return nil, err
 		}
 	}
 
-	if err = p.Root.pushOrderBy(node, fields); err != nil {
-		return err
+	if err = root.pushOrderBy(node, fields); err != nil {
+		return nil, err
 	}
 	// Limit SubPlan.
 	if node.Limit != nil {
-		if err = p.Root.pushLimit(node); err != nil {
-			return err
+		if err = root.pushLimit(node); err != nil {
+			return nil, err
 		}
 	}
-	p.Root.pushMisc(node)
-	p.Root.buildQuery()
-	return nil
+	root.pushMisc(node)
+	return root, root.buildQuery()
 }
 
 // Type returns the type of the plan.
@@ -172,16 +248,29 @@ func (p *SelectPlan) JSON() string {
 
 	type explain struct {
 		RawQuery    string                `json:",omitempty"`
+		Union       string                `json:",omitempty"`
 		Project     string                `json:",omitempty"`
 		Partitions  []xcontext.QueryTuple `json:",omitempty"`
 		Aggregate   []string              `json:",omitempty"`
 		GatherMerge []string              `json:",omitempty"`
 		HashGroupBy []string              `json:",omitempty"`
+		Having      []string              `json:",omitempty"`
 		Limit       *limit                `json:",omitempty"`
+		Subqueries  []json.RawMessage     `json:",omitempty"`
+	}
+
+	// The subqueries aren't materialized, the outer plan is unknown.
+	if p.Root == nil {
+		exp := &explain{RawQuery: p.RawQuery, Subqueries: subqueriesJSON(p.subqueries)}
+		bout, err := json.MarshalIndent(exp, "", "\t")
+		if err != nil {
+			return err.Error()
+		}
+		return common.BytesToString(bout)
 	}
 
 	// Project.
-	exprs := p.node.SelectExprs
+	exprs := unionArms(p.node)[0].SelectExprs
 	if m, ok := p.Root.(*MergeNode); ok {
 		exprs = m.sel.SelectExprs
 	}
@@ -193,6 +282,7 @@ func (p *SelectPlan) JSON() string {
 	var aggregate []string
 	var hashGroup []string
 	var gatherMerge []string
+	var having []string
 	var lim *limit
 	for _, sub := range p.Root.Children().Plans() {
 		switch sub.Type() {
@@ -204,6 +294,9 @@ func (p *SelectPlan) JSON() string {
 			for _, aggr := range plan.groupAggrs {
 				hashGroup = append(hashGroup, aggr.Field)
 			}
+			for _, expr := range plan.havings {
+				having = append(having, sqlparser.String(expr))
+			}
 		case PlanTypeOrderby:
 			plan := sub.(*OrderByPlan)
 			for _, order := range plan.OrderBys {
@@ -219,12 +312,23 @@ func (p *SelectPlan) JSON() string {
 		}
 	}
 
+	// Union.
+	var union string
+	if u, ok := p.Root.(*UnionNode); ok {
+		union = sqlparser.UnionAllStr
+		if u.Distinct {
+			union = sqlparser.UnionDistinctStr
+		}
+	}
+
 	exp := &explain{Project: project,
 		RawQuery:    p.RawQuery,
+		Union:       union,
 		Partitions:  p.Root.GetQuery(),
 		Aggregate:   aggregate,
 		GatherMerge: gatherMerge,
 		HashGroupBy: hashGroup,
+		Having:      having,
 		Limit:       lim,
 	}
 	bout, err := json.MarshalIndent(exp, "", "\t")
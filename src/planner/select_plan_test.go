@@ -15,7 +15,10 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
 	"github.com/xelabs/go-mysqlstack/xlog"
+
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
 )
 
 func TestSelectPlan(t *testing.T) {
@@ -78,32 +81,32 @@ func TestSelectPlan(t *testing.T) {
 	"Project": "id, sum(a) as A",
 	"Partitions": [
 		{
-			"Query": "select id, sum(a) as A from sbtest.A1 as A group by id having A \u003e 1000 order by id asc",
+			"Query": "select id, sum(a) as A from sbtest.A1 as A group by id order by id asc",
 			"Backend": "backend1",
 			"Range": "[0-32)"
 		},
 		{
-			"Query": "select id, sum(a) as A from sbtest.A2 as A group by id having A \u003e 1000 order by id asc",
+			"Query": "select id, sum(a) as A from sbtest.A2 as A group by id order by id asc",
 			"Backend": "backend2",
 			"Range": "[32-64)"
 		},
 		{
-			"Query": "select id, sum(a) as A from sbtest.A3 as A group by id having A \u003e 1000 order by id asc",
+			"Query": "select id, sum(a) as A from sbtest.A3 as A group by id order by id asc",
 			"Backend": "backend3",
 			"Range": "[64-96)"
 		},
 		{
-			"Query": "select id, sum(a) as A from sbtest.A4 as A group by id having A \u003e 1000 order by id asc",
+			"Query": "select id, sum(a) as A from sbtest.A4 as A group by id order by id asc",
 			"Backend": "backend4",
 			"Range": "[96-256)"
 		},
 		{
-			"Query": "select id, sum(a) as A from sbtest.A5 as A group by id having A \u003e 1000 order by id asc",
+			"Query": "select id, sum(a) as A from sbtest.A5 as A group by id order by id asc",
 			"Backend": "backend5",
 			"Range": "[256-512)"
 		},
 		{
-			"Query": "select id, sum(a) as A from sbtest.A6 as A group by id having A \u003e 1000 order by id asc",
+			"Query": "select id, sum(a) as A from sbtest.A6 as A group by id order by id asc",
 			"Backend": "backend6",
 			"Range": "[512-4096)"
 		}
@@ -113,6 +116,9 @@ func TestSelectPlan(t *testing.T) {
 	],
 	"GatherMerge": [
 		"id"
+	],
+	"Having": [
+		"A \u003e 1000"
 	]
 }`,
 		`{
@@ -279,32 +285,32 @@ func TestSelectPlanDatabaseIsNull(t *testing.T) {
 	"Project": "id, sum(a) as A",
 	"Partitions": [
 		{
-			"Query": "select id, sum(a) as A from sbtest.A1 as A group by id having A \u003e 1000 order by id asc",
+			"Query": "select id, sum(a) as A from sbtest.A1 as A group by id order by id asc",
 			"Backend": "backend1",
 			"Range": "[0-32)"
 		},
 		{
-			"Query": "select id, sum(a) as A from sbtest.A2 as A group by id having A \u003e 1000 order by id asc",
+			"Query": "select id, sum(a) as A from sbtest.A2 as A group by id order by id asc",
 			"Backend": "backend2",
 			"Range": "[32-64)"
 		},
 		{
-			"Query": "select id, sum(a) as A from sbtest.A3 as A group by id having A \u003e 1000 order by id asc",
+			"Query": "select id, sum(a) as A from sbtest.A3 as A group by id order by id asc",
 			"Backend": "backend3",
 			"Range": "[64-96)"
 		},
 		{
-			"Query": "select id, sum(a) as A from sbtest.A4 as A group by id having A \u003e 1000 order by id asc",
+			"Query": "select id, sum(a) as A from sbtest.A4 as A group by id order by id asc",
 			"Backend": "backend4",
 			"Range": "[96-256)"
 		},
 		{
-			"Query": "select id, sum(a) as A from sbtest.A5 as A group by id having A \u003e 1000 order by id asc",
+			"Query": "select id, sum(a) as A from sbtest.A5 as A group by id order by id asc",
 			"Backend": "backend5",
 			"Range": "[256-512)"
 		},
 		{
-			"Query": "select id, sum(a) as A from sbtest.A6 as A group by id having A \u003e 1000 order by id asc",
+			"Query": "select id, sum(a) as A from sbtest.A6 as A group by id order by id asc",
 			"Backend": "backend6",
 			"Range": "[512-4096)"
 		}
@@ -314,6 +320,9 @@ func TestSelectPlanDatabaseIsNull(t *testing.T) {
 	],
 	"GatherMerge": [
 		"id"
+	],
+	"Having": [
+		"A \u003e 1000"
 	]
 }`,
 	}
@@ -353,62 +362,44 @@ func TestSelectPlanDatabaseIsNull(t *testing.T) {
 
 func TestSelectUnsupportedPlan(t *testing.T) {
 	querys := []string{
-		"select * from A as A1 where id in (select id from B)",
+		"select * from A as A1 where id in (select id from B where B.a=A1.a)",
 		"select distinct(b) from A",
 		"select * from A join B on B.id=A.id",
 		"select id from A order by b",
 		"select id from A limit x",
-		"select age,count(*) from A group by age having count(*) >=2",
 		"select * from A where B.a >1",
 		"select count() from A",
-		"select round(avg(id)) from A",
-		"select id,group_concat(distinct name) from A group by id",
+		"select id,group_concat(name order by name) from A group by id",
 		"select next value for A",
 		"select A.*,(select b.str from b where A.id=B.id) str from A",
-		"select avg(id)*1000 from A",
 		"select avg(*) from A",
 		"select B.* from A",
 		"select * from A where a>1 having count(a) >3",
 		"select a,b from A group by B.a",
-		"select A.id,G.a as a, concat(B.str,G.str), 1 from A,B, A as G group by a",
 		"select *,avg(a) from A",
 		"select A.id from A join B on A.id=B.id right join G on G.id=A.id and A.a>B.a",
-		"select A.id from (A,B) left join G on A.id =G.id and A.a>B.a",
-		"select A.id from A join B on A.id=B.id right join G on G.id=A.id where concat(B.str,A.str) is null",
-		"select A.id from A join B on A.id >= B.id join G on G.id<=A.id where concat(B.str,A.str) is null",
-		"select A.id from A join B on A.id = B.id join G on G.id<=A.id+B.id",
-		"select A.id from A join B on A.id = B.id join G on A.id+B.id<=G.id",
-		"select A.id from G join (A,B) on A.id+B.id<=G.id",
-		"select A.id from G join (A,B) on G.id<=A.id+B.id",
+		"select sum(count(a)) from A",
+		"select a, sum(b)+rand() from A group by a",
 	}
 	results := []string{
-		"unsupported: subqueries.in.select",
+		"unsupported: correlated.subquery.column[A1.a]",
 		"unsupported: distinct",
 		"unsupported: '*'.expression.in.cross-shard.query",
 		"unsupported: orderby[b].should.in.select.list",
 		"unsupported: limit.offset.or.counts.must.be.IntVal",
-		"unsupported: expr[count(*)].in.having.clause",
 		"unsupported: unknown.table.'B'.in.clause",
 		"unsupported: invalid.use.of.group.function[count]",
-		"unsupported: 'round(avg(id))'.contain.aggregate.in.select.exprs",
-		"unsupported: group_concat.in.select.exprs",
+		"unsupported: order.by.in.group_concat",
 		"unsupported: nextval.in.select.exprs",
-		"unsupported: subqueries.in.select",
-		"unsupported: 'avg(id) * 1000'.contain.aggregate.in.select.exprs",
+		"unsupported: correlated.subquery.column[A.id]",
 		"unsupported: syntax.error.at.'avg(*)'",
 		"unsupported:  unknown.table.'B'.in.field.list",
-		"unsupported: expr[count(a)].in.having.clause",
+		"unsupported: exists.aggregate.and.'*'.select.exprs",
 		"unsupported: unknow.table.in.group.by.field[B.a]",
-		"unsupported: expr.'concat(B.str, G.str)'.in.cross-shard.join",
 		"unsupported: exists.aggregate.and.'*'.select.exprs",
 		"unsupported: on.clause.'A.a > B.a'.in.cross-shard.join",
-		"unsupported: expr.'A.a > B.a as tmpc_0'.in.cross-shard.join",
-		"unsupported: expr.'concat(B.str, A.str) as tmpo_0'.in.cross-shard.join",
-		"unsupported: clause.'concat(B.str, A.str) is null'.in.cross-shard.join",
-		"unsupported: expr.'A.id + B.id as tmpo_0'.in.cross-shard.join",
-		"unsupported: expr.'A.id + B.id as tmpo_0'.in.cross-shard.join",
-		"unsupported: expr.'A.id + B.id as tmpo_1'.in.cross-shard.join",
-		"unsupported: expr.'A.id + B.id as tmpo_1'.in.cross-shard.join",
+		"unsupported: invalid.use.of.group.function[sum]",
+		"unsupported: expr.'rand()'.in.evaluation",
 	}
 
 	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
@@ -449,6 +440,14 @@ func TestSelectSupportedPlan(t *testing.T) {
 		"select A.id from A left join B on A.id=B.id where B.str<=>null",
 		"select A.id from A left join B on A.id=B.id where null<=>B.str",
 		"select A.id from A join B on A.id >= B.id join G on G.id<=A.id",
+		"select A.id,G.a as a, concat(B.str,G.str), 1 from A,B, A as G group by a",
+		"select A.id from (A,B) left join G on A.id =G.id and A.a>B.a",
+		"select A.id from A join B on A.id=B.id right join G on G.id=A.id where concat(B.str,A.str) is null",
+		"select A.id from A join B on A.id >= B.id join G on G.id<=A.id where concat(B.str,A.str) is null",
+		"select A.id from A join B on A.id = B.id join G on G.id<=A.id+B.id",
+		"select A.id from A join B on A.id = B.id join G on A.id+B.id<=G.id",
+		"select A.id from G join (A,B) on A.id+B.id<=G.id",
+		"select A.id from G join (A,B) on G.id<=A.id+B.id",
 	}
 
 	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
@@ -654,6 +653,173 @@ func TestSelectPlanGlobal(t *testing.T) {
 	}
 }
 
+func TestSelectPlanRange(t *testing.T) {
+	querys := []string{
+		"select a, b from sbtest.R where id=150",
+		"select a, b from sbtest.R where id>150",
+		"select a, b from sbtest.R where id between 50 and 150 order by a",
+		"select a, b from sbtest.R where b>1",
+		"select R.a, G.b from R join G on R.a=G.a where R.id<100",
+		"select a, b from sbtest.R where id>150 and id>10",
+		"select R.a, G.b from R join G on R.a=G.a where R.id<90 and R.id<250",
+	}
+	want := []int{1, 2, 2, 3, 2, 2, 1}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableRConfig(), router.MockTableGConfig())
+	assert.Nil(t, err)
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+		assert.Equal(t, want[i], len(plan.Root.GetQuery()))
+	}
+}
+
+func TestSelectPlanRangeOutOfPartitions(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableRDateConfig())
+	assert.Nil(t, err)
+
+	// The reads and writes of the key beyond the last bound get the empty route.
+	{
+		query := "select a from sbtest.RD where dt='2019-03-01'"
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(plan.Root.GetQuery()))
+	}
+
+	{
+		query := "update sbtest.RD set a=1 where dt='2019-03-01'"
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewUpdatePlan(log, database, query, node.(*sqlparser.Update), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(plan.Querys))
+	}
+
+	{
+		query := "delete from sbtest.RD where dt='2019-03-01'"
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewDeletePlan(log, database, query, node.(*sqlparser.Delete), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(plan.Querys))
+	}
+
+	// The row can't be inserted.
+	{
+		query := "insert into sbtest.RD(dt, a) values('2019-03-01', 1)"
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewInsertPlan(log, database, query, node.(*sqlparser.Insert), route)
+		err = plan.Build()
+		want := "range.getindex.key[2019-03-01].has.no.partition"
+		assert.Equal(t, want, err.Error())
+	}
+}
+
+func TestSelectPlanIn(t *testing.T) {
+	querys := []string{
+		"select a from sbtest.L where id in (1, 'us', 2) order by a",
+		"select a from sbtest.L where id=1 or id='us'",
+		"select a from sbtest.L where id in (1, b)",
+		"select L.a from L join G on L.a=G.a where L.id in (3, 'jp')",
+	}
+	want := [][]string{
+		{
+			"select a from sbtest.L0 as L where id in (1, 2) order by a asc",
+			"select a from sbtest.L1 as L where id in ('us') order by a asc",
+		},
+		{
+			"select a from sbtest.L0 as L where (id in (1))",
+			"select a from sbtest.L1 as L where (id in ('us'))",
+		},
+		{
+			"select a from sbtest.L0 as L where id in (1, b)",
+			"select a from sbtest.L1 as L where id in (1, b)",
+			"select a from sbtest.L2 as L where id in (1, b)",
+		},
+		{
+			"select L.a from sbtest.L1 as L join sbtest.G on L.a = G.a where L.id in (3)",
+			"select L.a from sbtest.L2 as L join sbtest.G on L.a = G.a where L.id in ('jp')",
+		},
+	}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableLConfig(), router.MockTableGConfig())
+	assert.Nil(t, err)
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		sel := node.(*sqlparser.Select)
+		plan := NewSelectPlan(log, database, query, sel, route)
+		err = plan.Build()
+		assert.Nil(t, err)
+		var got []string
+		for _, q := range plan.Root.GetQuery() {
+			got = append(got, q.Query)
+		}
+		assert.Equal(t, want[i], got)
+	}
+}
+
+func TestSelectPlanCompositeKey(t *testing.T) {
+	querys := []string{
+		"select * from C where a=1 and b=2",
+		"select * from C where a=1",
+		"select C.c from C join C1 on C.a=C1.x and C.b=C1.y",
+		"select C.c from C, C1 where C.a=C1.x and C.b=C1.y",
+		"select C.c from C join C1 on C.a=C1.x",
+		"select C.c from C join C1 on C.a=C1.y and C.b=C1.x",
+		"select C.c from C join C1 on C.a=C1.x and C.b=C1.y where C.a=1 and C.b=2",
+	}
+	// MergeNode or JoinNode and the count of the querys.
+	merged := []bool{true, true, true, true, false, false, true}
+	want := []int{1, 2, 2, 2, 4, 4, 1}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableCConfig(), router.MockTableC1Config())
+	assert.Nil(t, err)
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+		_, ok := plan.Root.(*MergeNode)
+		assert.Equal(t, merged[i], ok)
+		assert.Equal(t, want[i], len(plan.Root.GetQuery()))
+	}
+}
+
 func TestSelectPlanJoin(t *testing.T) {
 	results := []string{
 		`{
@@ -762,6 +928,77 @@ func TestSelectPlanJoin(t *testing.T) {
 	}
 }
 
+func TestSelectPlanSingle(t *testing.T) {
+	results := []string{
+		`{
+	"RawQuery": "select S.a, S2.b from S join S2 on S.a = S2.a where S.id=1",
+	"Project": "S.a, S2.b",
+	"Partitions": [
+		{
+			"Query": "select S.a, S2.b from sbtest.S as S join sbtest.S2 as S2 on S.a = S2.a where S.id = 1",
+			"Backend": "backend1",
+			"Range": ""
+		}
+	]
+}`,
+		`{
+	"RawQuery": "select * from S, G where S.a=G.a",
+	"Project": "*",
+	"Partitions": [
+		{
+			"Query": "select * from sbtest.S as S, sbtest.G where S.a = G.a",
+			"Backend": "backend1",
+			"Range": ""
+		}
+	]
+}`,
+		`{
+	"RawQuery": "select S.a, S1.b from S join S1 on S.a = S1.a",
+	"Project": "S.a, S1.b",
+	"Partitions": [
+		{
+			"Query": "select S.a from sbtest.S as S order by S.a asc",
+			"Backend": "backend1",
+			"Range": ""
+		},
+		{
+			"Query": "select S1.b, S1.a from sbtest.S1 as S1 order by S1.a asc",
+			"Backend": "backend2",
+			"Range": ""
+		}
+	]
+}`,
+	}
+	querys := []string{
+		"select S.a, S2.b from S join S2 on S.a = S2.a where S.id=1",
+		"select * from S, G where S.a=G.a",
+		"select S.a, S1.b from S join S1 on S.a = S1.a",
+	}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	// S2 is on the same backend as S.
+	s2 := router.MockTableSConfig()
+	s2.Name = "S2"
+	s2.Partitions[0].Table = "S2"
+	err := route.AddForTest(database, router.MockTableGConfig(), router.MockTableSConfig(), router.MockTableS1Config(), s2)
+	assert.Nil(t, err)
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+		got := plan.JSON()
+		want := results[i]
+		assert.Equal(t, want, got)
+	}
+}
+
 func TestSelectPlanJoinErr(t *testing.T) {
 	querys := []string{
 		"select C.a, C.b from sbtest.C join sbtest.G on G.id = C.id where C.id=1",
@@ -801,3 +1038,93 @@ func TestSelectPlanJoinErr(t *testing.T) {
 		}
 	}
 }
+
+func TestSelectPlanJoinStrategy(t *testing.T) {
+	querys := []string{
+		"select A.a, B.a from A join B on A.a = B.a",
+		"select A.a, B.a from A join B on A.a = B.a where B.b = 1",
+		"select A.a, B.a from A join B on A.a = B.a and A.id = 1",
+		"select A.a, B.a from A join B on A.a = B.a where A.id = 1 and B.b = 1",
+		"select A.a, B.a from A join B on A.a > B.a where B.b = 1",
+		"select A.a, B.a from A, B",
+	}
+	wants := []JoinStrategy{SortMerge, SortMerge, NestedLoop, NestedLoop, SortMerge, Cartesian}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableMConfig(), router.MockTableBConfig())
+	assert.Nil(t, err)
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+		join, ok := plan.Root.(*JoinNode)
+		assert.True(t, ok, query)
+		assert.Equal(t, wants[i], join.Strategy, query)
+	}
+}
+
+func TestSelectPlanLookupQuerys(t *testing.T) {
+	querys := []string{
+		"select A.a, B.a from A join B on A.a = B.id where A.id = 1",
+		"select A.a, B.a from A join B on A.a = B.a and A.b = B.id where A.id = 1 and A.c = 2",
+		"select A.a, B.a from A join B on A.a = B.a where A.id = 1 and A.a = 2",
+	}
+	wants := [][]string{
+		{
+			"select B.a, B.id from sbtest.B1 as B where B.id in (1, 3)",
+			"select B.a, B.id from sbtest.B0 as B where B.id in (null)",
+		},
+		{
+			"select B.a, B.id from sbtest.B1 as B where B.id in (1, 3)",
+			"select B.a, B.id from sbtest.B0 as B where B.id in (null)",
+		},
+		{
+			"select B.a from sbtest.B0 as B where B.a = 2 and B.a in (1, 3)",
+			"select B.a from sbtest.B1 as B where B.a = 2 and B.a in (1, 3)",
+			"select B.a from sbtest.B0 as B where B.a = 2 and B.a in (null)",
+		},
+	}
+	vals := []sqltypes.Value{
+		sqltypes.MakeTrusted(querypb.Type_INT64, []byte("1")),
+		sqltypes.MakeTrusted(querypb.Type_INT64, []byte("3")),
+	}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableMConfig(), router.MockTableBConfig())
+	assert.Nil(t, err)
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+		join, ok := plan.Root.(*JoinNode)
+		assert.True(t, ok, query)
+		assert.Equal(t, NestedLoop, join.Strategy, query)
+
+		var got []string
+		tuples, err := join.LookupQuerys(vals)
+		assert.Nil(t, err)
+		for _, tuple := range tuples {
+			got = append(got, tuple.Query)
+		}
+		// No value is looked up on the first route.
+		tuples, err = join.LookupQuerys(nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(tuples))
+		got = append(got, tuples[0].Query)
+		assert.Equal(t, wants[i], got, query)
+	}
+}
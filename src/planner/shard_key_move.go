@@ -0,0 +1,179 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package planner
+
+import (
+	"fmt"
+	"strings"
+
+	"router"
+	"xcontext"
+
+	"github.com/pkg/errors"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+	"github.com/xelabs/go-mysqlstack/xlog"
+
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
+)
+
+// ShardKeyMove is implemented by the DML plans which update the shard key.
+// The rows can't be updated in place, they are read by the MoveReads first, then
+// the MoveQuerys delete them from the old partitions by their unique keys and insert
+// the updated rows into the new partitions. The reads and the MoveQuerys are executed
+// in one XA transaction, so the rows read are locked until they're moved.
+type ShardKeyMove interface {
+	// MoveReads returns the querys of the next read, the results of the previous
+	// reads are passed in. It returns nil if there is nothing more to read.
+	MoveReads(results []*sqltypes.Result) ([]xcontext.QueryTuple, error)
+
+	// MoveQuerys returns the querys which move the rows and the rows affected.
+	MoveQuerys(results []*sqltypes.Result) ([]xcontext.QueryTuple, uint64, error)
+}
+
+// moveValues returns the select exprs of the new values of the updated columns,
+// the 'default' is read as 'default(col)'.
+func moveValues(exprs sqlparser.UpdateExprs) sqlparser.SelectExprs {
+	values := make(sqlparser.SelectExprs, 0, len(exprs))
+	for _, expr := range exprs {
+		value := expr.Expr
+		if def, ok := value.(*sqlparser.Default); ok && def.ColName == "" {
+			value = &sqlparser.Default{ColName: expr.Name.Name.String()}
+		}
+		values = append(values, &sqlparser.AliasedExpr{Expr: value})
+	}
+	return values
+}
+
+// movedRows used to build the rows inserted into the new partitions. The fields and rows
+// are read by 'select *, <new values>', the updated columns are replaced by the new values.
+func movedRows(fields []*querypb.Field, rows [][]sqltypes.Value, exprs sqlparser.UpdateExprs) (sqlparser.Columns, sqlparser.Values, error) {
+	n := len(fields) - len(exprs)
+	if n <= 0 {
+		return nil, nil, errors.Errorf("unsupported: shard.key.move.fields[%d].doesn't.match.the.update[%d]", len(fields), len(exprs))
+	}
+	columns := make(sqlparser.Columns, 0, n)
+	for _, field := range fields[:n] {
+		columns = append(columns, sqlparser.NewColIdent(field.Name))
+	}
+	idxs := make([]int, len(exprs))
+	for i, expr := range exprs {
+		idxs[i] = -1
+		for j, column := range columns {
+			if column.Equal(expr.Name.Name) {
+				idxs[i] = j
+				break
+			}
+		}
+		if idxs[i] == -1 {
+			return nil, nil, errors.Errorf("unsupported: unknown.column.'%s'.in.field.list", expr.Name.Name.String())
+		}
+	}
+
+	vals := make(sqlparser.Values, 0, len(rows))
+	for _, row := range rows {
+		tuple := make(sqlparser.ValTuple, 0, n)
+		for _, v := range row[:n] {
+			tuple = append(tuple, valueToExpr(v))
+		}
+		for i, idx := range idxs {
+			tuple[idx] = valueToExpr(row[n+i])
+		}
+		vals = append(vals, tuple)
+	}
+	return columns, vals, nil
+}
+
+// moveInserts used to build the querys which insert the rows, the rows are routed
+// by the shard key in the same way as the 'insert ... values'.
+func moveInserts(log *xlog.Log, route *router.Router, database, table string, comments sqlparser.Comments,
+	columns sqlparser.Columns, rows sqlparser.Values) ([]xcontext.QueryTuple, error) {
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	node := &sqlparser.Insert{
+		Action:   sqlparser.InsertStr,
+		Comments: comments,
+		Table:    sqlparser.TableName{Name: sqlparser.NewTableIdent(table), Qualifier: sqlparser.NewTableIdent(database)},
+		Columns:  columns,
+		Rows:     rows,
+	}
+	plan := NewInsertPlan(log, database, sqlparser.String(node), node, route)
+	if err := plan.Build(); err != nil {
+		return nil, err
+	}
+	return plan.Querys, nil
+}
+
+// keyEquals used to build the 'col1 = val1 and col2 = val2' of the columns.
+func keyEquals(columns []string, vals []sqlparser.Expr) sqlparser.Expr {
+	var expr sqlparser.Expr
+	for i, column := range columns {
+		cond := &sqlparser.ComparisonExpr{
+			Operator: sqlparser.EqualStr,
+			Left:     &sqlparser.ColName{Name: sqlparser.NewColIdent(column)},
+			Right:    vals[i],
+		}
+		if expr == nil {
+			expr = cond
+			continue
+		}
+		expr = &sqlparser.AndExpr{Left: expr, Right: cond}
+	}
+	return expr
+}
+
+// uniqueKeysRead returns the query which reads the unique keys of the table, the primary
+// key is the first one.
+func uniqueKeysRead(route *router.Router, database, table string) (xcontext.QueryTuple, error) {
+	segments, err := route.Lookup(database, table, nil, nil)
+	if err != nil {
+		return xcontext.QueryTuple{}, err
+	}
+	segment := segments[0]
+	return xcontext.QueryTuple{
+		Query: fmt.Sprintf("select index_name, column_name from information_schema.statistics where table_schema = '%s' and table_name = '%s' and non_unique = 0 order by index_name != 'PRIMARY', index_name, seq_in_index",
+			database, segment.Table),
+		Backend: segment.Backend,
+		Range:   segment.Range.String(),
+	}, nil
+}
+
+// uniqueKeys returns the columns of the unique keys read by the uniqueKeysRead.
+func uniqueKeys(rs *sqltypes.Result) [][]string {
+	var keys [][]string
+	var last string
+	for _, row := range rs.Rows {
+		if name := row[0].String(); name != last || len(keys) == 0 {
+			keys = append(keys, nil)
+			last = name
+		}
+		keys[len(keys)-1] = append(keys[len(keys)-1], row[1].String())
+	}
+	return keys
+}
+
+// fieldsEquals used to build the where of the first unique key whose values of the row aren't NULL.
+func fieldsEquals(keys [][]string, fields []*querypb.Field, row []sqltypes.Value) (sqlparser.Expr, error) {
+	for _, key := range keys {
+		vals := make([]sqlparser.Expr, 0, len(key))
+		for _, column := range key {
+			for j, field := range fields {
+				if strings.EqualFold(field.Name, column) && !row[j].IsNull() {
+					vals = append(vals, valueToExpr(row[j]))
+					break
+				}
+			}
+		}
+		if len(vals) == len(key) {
+			return keyEquals(key, vals), nil
+		}
+	}
+	return nil, errors.New("unsupported: the.unique.key.of.the.row.moved.is.null")
+}
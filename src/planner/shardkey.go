@@ -0,0 +1,159 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package planner
+
+import (
+	"router"
+	"sort"
+
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+)
+
+// shardKeyIn used to route the shard key values of the 'IN' or 'OR' filter,
+// and rewrite the 'IN' list of the per-partition query.
+type shardKeyIn struct {
+	// the 'shardkey IN (...)' expr, nil if the filter is 'OR'.
+	expr *sqlparser.ComparisonExpr
+	// the original values of the 'IN' list.
+	origin sqlparser.Expr
+	// values grouped by the partition table.
+	vals map[string]sqlparser.ValTuple
+	// segments of the values, sorted by range.
+	segments []router.Segment
+}
+
+// newShardKeyIn groups the values by the partitions.
+func newShardKeyIn(database, table string, expr *sqlparser.ComparisonExpr, vals []*sqlparser.SQLVal, route *router.Router) (*shardKeyIn, error) {
+	in := &shardKeyIn{
+		expr: expr,
+		vals: make(map[string]sqlparser.ValTuple),
+	}
+	if expr != nil {
+		in.origin = expr.Right
+	}
+	for _, val := range vals {
+		segments, err := route.Lookup(database, table, val, val)
+		if err != nil {
+			return nil, err
+		}
+		segment := segments[0]
+		if _, ok := in.vals[segment.Table]; !ok {
+			in.segments = append(in.segments, segment)
+		}
+		in.vals[segment.Table] = append(in.vals[segment.Table], val)
+	}
+	sort.Sort(router.Segments(in.segments))
+	return in, nil
+}
+
+// rewrite keeps the values which belong to the partition table in the 'IN' list.
+// The rows in one partition only have the values routed to it, so it's always safe.
+func (in *shardKeyIn) rewrite(table string) {
+	if in == nil || in.expr == nil {
+		return
+	}
+	if vals, ok := in.vals[table]; ok {
+		in.expr.Right = vals
+		return
+	}
+	in.expr.Right = in.origin
+}
+
+// restore restores the original 'IN' list.
+func (in *shardKeyIn) restore() {
+	if in == nil || in.expr == nil {
+		return
+	}
+	in.expr.Right = in.origin
+}
+
+// getShardKeyValues used to get the shard key values from the filter,
+// such as: 'id in (1,2)', 'id=1 or id=2', returns nil if the filter can't be pruned.
+// The 'IN' expr is returned to rewrite the list of the per-partition query, the 'OR'
+// returns the equal 'IN' expr, the caller replaces the filter with it.
+func getShardKeyValues(filter sqlparser.Expr, table, shardkey string) (*sqlparser.ComparisonExpr, []*sqlparser.SQLVal) {
+	switch filter := filter.(type) {
+	case *sqlparser.ComparisonExpr:
+		if vals := getShardKeyInValues(filter, table, shardkey); vals != nil {
+			return filter, vals
+		}
+	case *sqlparser.OrExpr:
+		left, vals := getShardKeyOrValues(filter, table, shardkey)
+		if vals == nil {
+			return nil, nil
+		}
+		tuple := make(sqlparser.ValTuple, 0, len(vals))
+		for _, val := range vals {
+			tuple = append(tuple, val)
+		}
+		return &sqlparser.ComparisonExpr{Left: left, Operator: sqlparser.InStr, Right: tuple}, vals
+	}
+	return nil, nil
+}
+
+// replaceShardKeyOr replaces the 'OR' filter in the where with the equal 'IN' expr
+// returned by the getShardKeyValues, returns false if the filter isn't replaced.
+func replaceShardKeyOr(where *sqlparser.Where, filter sqlparser.Expr, in *sqlparser.ComparisonExpr) bool {
+	or, ok := filter.(*sqlparser.OrExpr)
+	if !ok {
+		return true
+	}
+	return sqlparser.ReplaceExpr(where, or, in)
+}
+
+// getShardKeyInValues returns the values of 'shardkey IN (...)'.
+func getShardKeyInValues(filter *sqlparser.ComparisonExpr, table, shardkey string) []*sqlparser.SQLVal {
+	if filter.Operator != sqlparser.InStr || !nameMatch(filter.Left, table, shardkey) {
+		return nil
+	}
+	tuple, ok := filter.Right.(sqlparser.ValTuple)
+	if !ok || len(tuple) == 0 {
+		return nil
+	}
+	vals := make([]*sqlparser.SQLVal, 0, len(tuple))
+	for _, expr := range tuple {
+		sqlval, ok := expr.(*sqlparser.SQLVal)
+		if !ok {
+			return nil
+		}
+		vals = append(vals, sqlval)
+	}
+	return vals
+}
+
+// getShardKeyOrValues returns the shard key column and the values of the ORs, every one
+// of them must be 'shardkey=val' or 'shardkey IN (...)', otherwise returns nil.
+func getShardKeyOrValues(expr sqlparser.Expr, table, shardkey string) (sqlparser.Expr, []*sqlparser.SQLVal) {
+	switch expr := skipParenthesis(expr).(type) {
+	case *sqlparser.OrExpr:
+		col, left := getShardKeyOrValues(expr.Left, table, shardkey)
+		if left == nil {
+			return nil, nil
+		}
+		_, right := getShardKeyOrValues(expr.Right, table, shardkey)
+		if right == nil {
+			return nil, nil
+		}
+		return col, append(left, right...)
+	case *sqlparser.ComparisonExpr:
+		switch expr.Operator {
+		case sqlparser.EqualStr:
+			if nameMatch(expr.Left, table, shardkey) {
+				if sqlval, ok := expr.Right.(*sqlparser.SQLVal); ok {
+					return expr.Left, []*sqlparser.SQLVal{sqlval}
+				}
+			}
+		case sqlparser.InStr:
+			if vals := getShardKeyInValues(expr, table, shardkey); vals != nil {
+				return expr.Left, vals
+			}
+		}
+	}
+	return nil, nil
+}
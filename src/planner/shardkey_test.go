@@ -0,0 +1,206 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package planner
+
+import (
+	"router"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+func TestGetShardKeyValues(t *testing.T) {
+	querys := []string{
+		"select * from A where id in (1, 2, 3)",
+		"select * from A where A.id in (1, 'x')",
+		"select * from A where id=1 or id=2",
+		"select * from A where id=1 or (id=2 or id in (3, 4))",
+		"select * from A where id in (1, a)",
+		"select * from A where id not in (1, 2)",
+		"select * from A where id=1 or b=2",
+		"select * from A where id=1 or id>2",
+		"select * from A where id=b or id=2",
+		"select * from A where B.id in (1, 2)",
+		"select * from A where id=1",
+	}
+	// The count of the values, -1 means can't be pruned.
+	want := []int{3, 2, 2, 4, -1, -1, -1, -1, -1, -1, -1}
+	// Whether the 'IN' expr is returned, the 'OR' returns the equal one.
+	wantIn := []bool{true, true, true, true, false, false, false, false, false, false, false}
+
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		expr, vals := getShardKeyValues(node.(*sqlparser.Select).Where.Expr, "A", "id")
+		if want[i] == -1 {
+			assert.Nil(t, vals, query)
+		} else {
+			assert.Equal(t, want[i], len(vals), query)
+		}
+		assert.Equal(t, wantIn[i], expr != nil, query)
+	}
+}
+
+func TestGetDMLRoutingIn(t *testing.T) {
+	querys := []string{
+		"select * from A where id in (1, 2, 3)",
+		"select * from A where id in (0, 1, 2)",
+		"select * from A where id=0 or id=1",
+		"select * from A where id in (0, 1) and id=1",
+		"select * from A where id in (0, b)",
+		"select * from L where id in (1, 2)",
+		"select * from L where id in (1, 3, 'jp')",
+		"select * from L where id='cn' or id='us'",
+		"select * from C where a in (1, 2) and b=1",
+	}
+	want := [][]string{
+		{"A6"},
+		{"A1", "A6"},
+		{"A1", "A6"},
+		{"A6"},
+		{"A1", "A2", "A3", "A4", "A5", "A6"},
+		{"L0"},
+		{"L0", "L1", "L2"},
+		{"L0", "L1"},
+		{"C0", "C1"},
+	}
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableMConfig(), router.MockTableLConfig(), router.MockTableCConfig())
+	assert.Nil(t, err)
+
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		sel := node.(*sqlparser.Select)
+		table := sqlparser.String(sel.From[0].(*sqlparser.AliasedTableExpr).Expr)
+		shardkey, err := route.ShardKey(database, table)
+		assert.Nil(t, err)
+		segments, _, err := getDMLRouting(database, table, shardkey, sel.Where, route)
+		assert.Nil(t, err)
+		var got []string
+		for _, segment := range segments {
+			got = append(got, segment.Table)
+		}
+		assert.Equal(t, want[i], got, query)
+	}
+}
+
+func TestShardKeyInRewrite(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableLConfig())
+	assert.Nil(t, err)
+
+	query := "select * from L where id in (1, 'us', 2, 'jp')"
+	node, err := sqlparser.Parse(query)
+	assert.Nil(t, err)
+	sel := node.(*sqlparser.Select)
+	_, in, err := getDMLRouting(database, "L", "id", sel.Where, route)
+	assert.Nil(t, err)
+	assert.NotNil(t, in)
+
+	want := map[string]string{
+		"L0": "select * from L where id in (1, 2)",
+		"L1": "select * from L where id in ('us')",
+		"L2": "select * from L where id in ('jp')",
+	}
+	for _, segment := range in.segments {
+		in.rewrite(segment.Table)
+		assert.Equal(t, want[segment.Table], sqlparser.String(sel))
+	}
+	in.restore()
+	assert.Equal(t, "select * from L where id in (1, 'us', 2, 'jp')", sqlparser.String(sel))
+
+	// The 'OR' is replaced by the 'IN'.
+	{
+		query := "select * from L where a=1 and (id=1 or id='us' or id in (2, 'jp'))"
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		sel := node.(*sqlparser.Select)
+		_, in, err := getDMLRouting(database, "L", "id", sel.Where, route)
+		assert.Nil(t, err)
+		assert.NotNil(t, in)
+
+		want := map[string]string{
+			"L0": "select * from L where a = 1 and (id in (1, 2))",
+			"L1": "select * from L where a = 1 and (id in ('us'))",
+			"L2": "select * from L where a = 1 and (id in ('jp'))",
+		}
+		for _, segment := range in.segments {
+			in.rewrite(segment.Table)
+			assert.Equal(t, want[segment.Table], sqlparser.String(sel))
+		}
+		in.restore()
+		assert.Equal(t, "select * from L where a = 1 and (id in (1, 'us', 2, 'jp'))", sqlparser.String(sel))
+	}
+
+	// Nil is safe.
+	{
+		var in *shardKeyIn
+		in.rewrite("L0")
+		in.restore()
+	}
+}
+
+func TestShardKeyInUnlisted(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	conf := router.MockTableLConfig()
+	conf.Partitions = conf.Partitions[:2]
+	err := route.AddForTest(database, conf)
+	assert.Nil(t, err)
+
+	// The unlisted key is in none of the partitions, the reads go to the empty route.
+	{
+		query := "select a from sbtest.L where id='jp'"
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(plan.Root.GetQuery()))
+	}
+
+	{
+		query := "delete from sbtest.L where id in (1, 'jp')"
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewDeletePlan(log, database, query, node.(*sqlparser.Delete), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(plan.Querys))
+		assert.Equal(t, "delete from sbtest.L0 where id in (1, 'jp')", plan.Querys[0].Query)
+	}
+
+	// The row can't be stored.
+	{
+		query := "insert into sbtest.L(id, a) values('jp', 1)"
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewInsertPlan(log, database, query, node.(*sqlparser.Insert), route)
+		err = plan.Build()
+		want := "list.getindex.key[jp].has.no.partition"
+		assert.Equal(t, want, err.Error())
+	}
+}
@@ -0,0 +1,271 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package planner
+
+import (
+	"encoding/json"
+
+	"router"
+
+	"github.com/pkg/errors"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+// SubqueryType is the type of the subquery.
+type SubqueryType int
+
+const (
+	// SubqueryIn is the 'expr [NOT] IN (SELECT ...)'.
+	SubqueryIn SubqueryType = iota
+	// SubqueryScalar is the '(SELECT ...)' returns one value.
+	SubqueryScalar
+	// SubqueryExists is the 'EXISTS (SELECT ...)'.
+	SubqueryExists
+)
+
+// Subquery represents the uncorrelated subquery in the statement,
+// it's executed as its own distributed plan before the outer one,
+// then the result is substituted into the outer statement.
+type Subquery struct {
+	Type SubqueryType
+
+	// Plan is the plan of the inner select.
+	Plan *SelectPlan
+
+	// expr is the expression replaced by the result, the IN comparison, the subquery or the EXISTS.
+	expr sqlparser.Expr
+}
+
+// SubqueryPlan is the plan which has the subqueries to materialize.
+type SubqueryPlan interface {
+	// Subqueries returns the subqueries not yet materialized.
+	Subqueries() []*Subquery
+
+	// Materialize substitutes the results of the subqueries in order, and builds the plan.
+	Materialize(results []*sqltypes.Result) error
+}
+
+// buildSubqueries finds the subqueries of the statement and builds their plans.
+// The subquery in the FROM clause is the derived table, it's planned by the DerivedNode.
+// The correlated subquery is unsupported.
+func buildSubqueries(log *xlog.Log, router *router.Router, database string, node sqlparser.SQLNode) ([]*Subquery, error) {
+	var subs []*Subquery
+	add := func(typ SubqueryType, expr sqlparser.Expr, subquery *sqlparser.Subquery) error {
+		sub, err := newSubquery(log, router, database, typ, expr, subquery)
+		if err != nil {
+			return err
+		}
+		subs = append(subs, sub)
+		return nil
+	}
+
+	var visit sqlparser.Visit
+	visit = func(node sqlparser.SQLNode) (kontinue bool, err error) {
+		switch node := node.(type) {
+		case *sqlparser.AliasedTableExpr:
+			if _, ok := node.Expr.(*sqlparser.Subquery); ok {
+				return false, nil
+			}
+		case *sqlparser.ComparisonExpr:
+			if node.Operator != sqlparser.InStr && node.Operator != sqlparser.NotInStr {
+				return true, nil
+			}
+			subquery, ok := node.Right.(*sqlparser.Subquery)
+			if !ok {
+				return true, nil
+			}
+			if err := sqlparser.Walk(visit, node.Left); err != nil {
+				return false, err
+			}
+			return false, add(SubqueryIn, node, subquery)
+		case *sqlparser.ExistsExpr:
+			return false, add(SubqueryExists, node, node.Subquery)
+		case *sqlparser.Subquery:
+			return false, add(SubqueryScalar, node, node)
+		}
+		return true, nil
+	}
+	if err := sqlparser.Walk(visit, node); err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// newSubquery checks the subquery is uncorrelated and builds its plan.
+func newSubquery(log *xlog.Log, router *router.Router, database string, typ SubqueryType, expr sqlparser.Expr, subquery *sqlparser.Subquery) (*Subquery, error) {
+	stmt := subquery.Select
+	for {
+		paren, ok := stmt.(*sqlparser.ParenSelect)
+		if !ok {
+			break
+		}
+		stmt = paren.Select
+	}
+	sel, ok := stmt.(*sqlparser.Select)
+	if !ok {
+		return nil, errors.New("unsupported: union.in.subquery")
+	}
+	if err := checkCorrelated(sel); err != nil {
+		return nil, err
+	}
+
+	// Only one row is needed by the EXISTS, two rows are enough to check the scalar.
+	if sel.Limit == nil {
+		switch typ {
+		case SubqueryExists:
+			sel.Limit = &sqlparser.Limit{Rowcount: sqlparser.NewIntVal([]byte("1"))}
+		case SubqueryScalar:
+			sel.Limit = &sqlparser.Limit{Rowcount: sqlparser.NewIntVal([]byte("2"))}
+		}
+	}
+
+	plan := NewSelectPlan(log, database, sqlparser.String(sel), sel, router)
+	if err := plan.Build(); err != nil {
+		return nil, err
+	}
+	return &Subquery{Type: typ, Plan: plan, expr: expr}, nil
+}
+
+// checkCorrelated returns error if the select refers to the column of the outer tables,
+// the column's qualifier must be one of the tables in its FROM clause.
+// The inner subqueries are checked when their own plans are built.
+func checkCorrelated(sel *sqlparser.Select) error {
+	tables := make(map[string]bool)
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (kontinue bool, err error) {
+		switch node := node.(type) {
+		case *sqlparser.AliasedTableExpr:
+			if !node.As.IsEmpty() {
+				tables[node.As.String()] = true
+			} else if name, ok := node.Expr.(sqlparser.TableName); ok {
+				tables[name.Name.String()] = true
+			}
+			return false, nil
+		case *sqlparser.Subquery:
+			return false, nil
+		}
+		return true, nil
+	}, sel.From)
+
+	return sqlparser.Walk(func(node sqlparser.SQLNode) (kontinue bool, err error) {
+		switch node := node.(type) {
+		case *sqlparser.ColName:
+			table := node.Qualifier.Name.String()
+			if table != "" && !tables[table] {
+				return false, errors.Errorf("unsupported: correlated.subquery.column[%s]", sqlparser.String(node))
+			}
+		case *sqlparser.Subquery:
+			return false, nil
+		}
+		return true, nil
+	}, sel.SelectExprs, sel.From, sel.Where, sel.GroupBy, sel.Having, sel.OrderBy)
+}
+
+// subqueriesJSON returns the plan info of the subqueries.
+func subqueriesJSON(subs []*Subquery) []json.RawMessage {
+	var infos []json.RawMessage
+	for _, sub := range subs {
+		infos = append(infos, json.RawMessage(sub.Plan.JSON()))
+	}
+	return infos
+}
+
+// substituteSubqueries replaces the subqueries in the node with the results.
+func substituteSubqueries(node sqlparser.SQLNode, subs []*Subquery, results []*sqltypes.Result) error {
+	if len(subs) != len(results) {
+		return errors.Errorf("subquery.results.count[%d].not.equal.to.subqueries[%d]", len(results), len(subs))
+	}
+	for i, sub := range subs {
+		if err := sub.substitute(node, results[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// substitute replaces the subquery in the node with the result.
+// IN: the subquery is replaced by the value list, the empty IN is false and the empty NOT IN is true.
+// Scalar: the subquery is replaced by the value, NULL if no row, error if more than one row.
+// EXISTS: the EXISTS is replaced by true or false.
+func (sub *Subquery) substitute(node sqlparser.SQLNode, qr *sqltypes.Result) error {
+	var to sqlparser.Expr
+	switch sub.Type {
+	case SubqueryIn:
+		cmp := sub.expr.(*sqlparser.ComparisonExpr)
+		columns := 1
+		if tuple, ok := cmp.Left.(sqlparser.ValTuple); ok {
+			columns = len(tuple)
+		}
+		if len(qr.Fields) != columns {
+			return errors.Errorf("subquery.operand.should.contain.%d.column(s)", columns)
+		}
+		if len(qr.Rows) == 0 {
+			to = sqlparser.BoolVal(cmp.Operator == sqlparser.NotInStr)
+			break
+		}
+
+		vals := make(sqlparser.ValTuple, 0, len(qr.Rows))
+		seen := make(map[string]bool, len(qr.Rows))
+		for _, row := range qr.Rows {
+			var val sqlparser.Expr
+			if columns == 1 {
+				val = valueToExpr(row[0])
+			} else {
+				tuple := make(sqlparser.ValTuple, 0, columns)
+				for _, v := range row {
+					tuple = append(tuple, valueToExpr(v))
+				}
+				val = tuple
+			}
+			key := sqlparser.String(val)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			vals = append(vals, val)
+		}
+		cmp.Right = vals
+		return nil
+	case SubqueryScalar:
+		if len(qr.Fields) != 1 {
+			return errors.New("subquery.operand.should.contain.1.column(s)")
+		}
+		switch len(qr.Rows) {
+		case 0:
+			to = &sqlparser.NullVal{}
+		case 1:
+			to = valueToExpr(qr.Rows[0][0])
+		default:
+			return errors.New("subquery.returns.more.than.1.row")
+		}
+	case SubqueryExists:
+		to = sqlparser.BoolVal(len(qr.Rows) > 0)
+	}
+
+	if !sqlparser.ReplaceExpr(node, sub.expr, to) {
+		return errors.Errorf("subquery[%s].cant.be.substituted", sqlparser.String(sub.expr))
+	}
+	return nil
+}
+
+// valueToExpr converts the value to the literal, the number keeps its type,
+// so that the shard key gets the same index as the literal in the query.
+func valueToExpr(v sqltypes.Value) sqlparser.Expr {
+	switch {
+	case v.IsNull():
+		return &sqlparser.NullVal{}
+	case v.IsIntegral():
+		return sqlparser.NewIntVal(v.Raw())
+	case v.IsFloat(), v.Type() == querypb.Type_DECIMAL:
+		return sqlparser.NewFloatVal(v.Raw())
+	}
+	return sqlparser.NewStrVal(v.Raw())
+}
@@ -0,0 +1,283 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package planner
+
+import (
+	"testing"
+
+	"router"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+func mockSubqueryResult(typ querypb.Type, rows ...[]string) *sqltypes.Result {
+	qr := &sqltypes.Result{}
+	if len(rows) > 0 {
+		for range rows[0] {
+			qr.Fields = append(qr.Fields, &querypb.Field{Name: "c", Type: typ})
+		}
+	} else {
+		qr.Fields = []*querypb.Field{{Name: "c", Type: typ}}
+	}
+	for _, row := range rows {
+		vals := make([]sqltypes.Value, 0, len(row))
+		for _, v := range row {
+			if v == "NULL" {
+				vals = append(vals, sqltypes.NULL)
+				continue
+			}
+			vals = append(vals, sqltypes.MakeTrusted(typ, []byte(v)))
+		}
+		qr.Rows = append(qr.Rows, vals)
+	}
+	return qr
+}
+
+func TestSubquerySelectPlan(t *testing.T) {
+	querys := []string{
+		"select * from A where id in (select id from B where a=1)",
+		"select * from A where id not in (select id from B)",
+		"select * from A where (id, a) in (select id, a from B)",
+		"select a, (select max(str) from B) from A where id=(select id from G where a=1)",
+		"select * from A where exists (select 1 from G where a=1)",
+		"select * from A where id in (select id from B where a in (select a from G))",
+	}
+	results := [][]*sqltypes.Result{
+		{mockSubqueryResult(querypb.Type_INT32, []string{"1"}, []string{"1"})},
+		{mockSubqueryResult(querypb.Type_INT32)},
+		{mockSubqueryResult(querypb.Type_INT32, []string{"1", "2"}, []string{"3", "NULL"})},
+		{mockSubqueryResult(querypb.Type_VARCHAR, []string{"x"}), mockSubqueryResult(querypb.Type_DECIMAL, []string{"1.50"})},
+		{mockSubqueryResult(querypb.Type_INT32)},
+		{mockSubqueryResult(querypb.Type_INT32, []string{"1"})},
+	}
+	wants := []string{
+		"select * from sbtest.A6 as A where id in (1)",
+		"select * from sbtest.A1 as A where true",
+		"select * from sbtest.A1 as A where (id, a) in ((1, 2), (3, null))",
+		"select a, 'x' as `(select max(str) from B)` from sbtest.A6 as A where id = 1.50",
+		"select * from sbtest.A1 as A where false",
+		"select * from sbtest.A6 as A where id in (1)",
+	}
+	parts := []int{1, 6, 6, 1, 6, 1}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+	err := route.AddForTest(database, router.MockTableMConfig(), router.MockTableBConfig(), router.MockTableGConfig())
+	assert.Nil(t, err)
+
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+		assert.Nil(t, plan.Root)
+		subs := plan.Subqueries()
+		assert.Equal(t, len(results[i]), len(subs))
+
+		// The inner subqueries are materialized first.
+		for _, sub := range subs {
+			inner := sub.Plan.Subqueries()
+			if len(inner) > 0 {
+				err = sub.Plan.Materialize([]*sqltypes.Result{mockSubqueryResult(querypb.Type_INT32, []string{"7"})})
+				assert.Nil(t, err)
+				assert.Equal(t, "select id from sbtest.B0 as B where a in (7)", sub.Plan.Root.GetQuery()[0].Query)
+			}
+			assert.NotNil(t, sub.Plan.Root)
+		}
+
+		err = plan.Materialize(results[i])
+		assert.Nil(t, err)
+		assert.Nil(t, plan.Subqueries())
+		got := plan.Root.GetQuery()
+		assert.Equal(t, parts[i], len(got), query)
+		assert.Equal(t, wants[i], got[0].Query)
+	}
+}
+
+func TestSubqueryLimit(t *testing.T) {
+	querys := []string{
+		"select * from A where exists (select a from B)",
+		"select * from A where a > (select a from B)",
+		"select * from A where a > (select a from B limit 1)",
+		"select * from A where a in (select a from B)",
+	}
+	wants := []string{
+		"select a from B limit 1",
+		"select a from B limit 2",
+		"select a from B limit 1",
+		"select a from B",
+	}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+	err := route.AddForTest(database, router.MockTableMConfig(), router.MockTableBConfig())
+	assert.Nil(t, err)
+
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+		assert.Equal(t, wants[i], plan.Subqueries()[0].Plan.RawQuery)
+	}
+}
+
+func TestSubqueryDMLPlan(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+	err := route.AddForTest(database, router.MockTableMConfig(), router.MockTableBConfig())
+	assert.Nil(t, err)
+
+	// update.
+	{
+		query := "update A set a=(select max(a) from B) where id in (select id from B where str='x')"
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewUpdatePlan(log, database, query, node.(*sqlparser.Update), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(plan.Querys))
+		assert.Equal(t, 2, len(plan.Subqueries()))
+
+		err = plan.Materialize([]*sqltypes.Result{
+			mockSubqueryResult(querypb.Type_INT32, []string{"10"}),
+			mockSubqueryResult(querypb.Type_INT32, []string{"1"}),
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(plan.Querys))
+		assert.Equal(t, "update sbtest.A6 set a = 10 where id in (1)", plan.Querys[0].Query)
+	}
+
+	// delete.
+	{
+		query := "delete from A where id in (select id from B)"
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewDeletePlan(log, database, query, node.(*sqlparser.Delete), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(plan.Querys))
+
+		err = plan.Materialize([]*sqltypes.Result{mockSubqueryResult(querypb.Type_INT32)})
+		assert.Nil(t, err)
+		assert.Equal(t, 6, len(plan.Querys))
+		assert.Equal(t, "delete from sbtest.A1 where false", plan.Querys[0].Query)
+	}
+}
+
+func TestSubqueryErrors(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+	err := route.AddForTest(database, router.MockTableMConfig(), router.MockTableBConfig())
+	assert.Nil(t, err)
+
+	// Build.
+	{
+		querys := []string{
+			"select * from A where id in (select B.id from B where B.a=A.a)",
+			"select * from A where exists (select 1 from B as X where X.a=B.a)",
+			"select * from A where id in (select id from B union select id from A)",
+			"select * from A where id in (select id from B where a in (select a from A as X where X.b=B.b))",
+			"select * from A where id in (select id from C)",
+		}
+		wants := []string{
+			"unsupported: correlated.subquery.column[A.a]",
+			"unsupported: correlated.subquery.column[B.a]",
+			"unsupported: union.in.subquery",
+			"unsupported: correlated.subquery.column[B.b]",
+			"Table 'C' doesn't exist (errno 1146) (sqlstate 42S02)",
+		}
+		for i, query := range querys {
+			node, err := sqlparser.Parse(query)
+			assert.Nil(t, err)
+			plan := NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+			err = plan.Build()
+			assert.Equal(t, wants[i], err.Error())
+		}
+	}
+
+	// Materialize.
+	{
+		querys := []string{
+			"select * from A where a = (select a from B)",
+			"select * from A where a = (select a, b from B)",
+			"select * from A where a in (select a, b from B)",
+			"select * from A where a in (select a from B)",
+		}
+		results := [][]*sqltypes.Result{
+			{mockSubqueryResult(querypb.Type_INT32, []string{"1"}, []string{"2"})},
+			{mockSubqueryResult(querypb.Type_INT32, []string{"1", "2"})},
+			{mockSubqueryResult(querypb.Type_INT32, []string{"1", "2"})},
+			{},
+		}
+		wants := []string{
+			"subquery.returns.more.than.1.row",
+			"subquery.operand.should.contain.1.column(s)",
+			"subquery.operand.should.contain.1.column(s)",
+			"subquery.results.count[0].not.equal.to.subqueries[1]",
+		}
+		for i, query := range querys {
+			node, err := sqlparser.Parse(query)
+			assert.Nil(t, err)
+			plan := NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+			err = plan.Build()
+			assert.Nil(t, err)
+			err = plan.Materialize(results[i])
+			assert.Equal(t, wants[i], err.Error())
+		}
+	}
+}
+
+func TestSubqueryJSON(t *testing.T) {
+	want := `{
+	"RawQuery": "select * from A where exists (select 1 from B where id=1)",
+	"Subqueries": [
+		{
+			"RawQuery": "select 1 from B where id = 1 limit 1",
+			"Project": "1",
+			"Partitions": [
+				{
+					"Query": "select 1 from sbtest.B1 as B where id = 1 limit 1",
+					"Backend": "backend2",
+					"Range": "[512-4096)"
+				}
+			]
+		}
+	]
+}`
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+	err := route.AddForTest(database, router.MockTableMConfig(), router.MockTableBConfig())
+	assert.Nil(t, err)
+
+	query := "select * from A where exists (select 1 from B where id=1)"
+	node, err := sqlparser.Parse(query)
+	assert.Nil(t, err)
+	plan := NewSelectPlan(log, database, query, node.(*sqlparser.Select), route)
+	err = plan.Build()
+	assert.Nil(t, err)
+	assert.Equal(t, want, plan.JSON())
+}
@@ -0,0 +1,272 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package planner
+
+import (
+	"router"
+	"xcontext"
+
+	"github.com/pkg/errors"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+// UnionNode represents the union of two selects which can't be pushed down together,
+// the arms are executed independently and the results are merged in the proxy.
+type UnionNode struct {
+	log *xlog.Log
+	// Left and Right are the plans of the arms.
+	Left, Right PlanNode
+	// Distinct is true for the 'union' and 'union distinct', the duplicate rows are removed.
+	Distinct bool
+	// referred tables' tableInfo map, always empty.
+	referredTables map[string]*TableInfo
+	// children plans of the union(such as: orderby, limit).
+	children *PlanTree
+}
+
+// newUnionNode used to create UnionNode.
+func newUnionNode(log *xlog.Log, left, right PlanNode, typ string) *UnionNode {
+	return &UnionNode{
+		log:            log,
+		Left:           left,
+		Right:          right,
+		Distinct:       typ != sqlparser.UnionAllStr,
+		referredTables: make(map[string]*TableInfo),
+		children:       NewPlanTree(),
+	}
+}
+
+// processUnion used to build the plan tree of the union.
+// Each arm is planned independently, if both arms are routed to the same backend,
+// the whole union is pushed down as one MergeNode.
+func processUnion(log *xlog.Log, router *router.Router, database string, node *sqlparser.Union) (PlanNode, error) {
+	if err := checkUnionColumns(node); err != nil {
+		return nil, err
+	}
+	// The field names of the union result come from the first select.
+	fields := unionFields(unionArms(node)[0])
+
+	left, err := processSelectStatement(log, router, database, node.Left)
+	if err != nil {
+		return nil, err
+	}
+	right, err := processSelectStatement(log, router, database, node.Right)
+	if err != nil {
+		return nil, err
+	}
+
+	if mn := mergeUnion(log, database, router, left, right, node); mn != nil {
+		return mn, nil
+	}
+
+	if node.Lock != "" {
+		return nil, errors.New("unsupported: lock.in.cross-shard.union")
+	}
+	u := newUnionNode(log, left, right, node.Type)
+	if err := u.pushUnionOrderBy(node, fields); err != nil {
+		return nil, err
+	}
+	if node.Limit != nil {
+		if err := u.pushLimit(&sqlparser.Select{Limit: node.Limit}); err != nil {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// mergeUnion returns the MergeNode of the whole union if both arms are routed to one backend, otherwise nil.
+// The arms' ast have been rewritten to the partition tables, so the union is formatted as it is.
+func mergeUnion(log *xlog.Log, database string, router *router.Router, left, right PlanNode, node *sqlparser.Union) *MergeNode {
+	lmn, ok := left.(*MergeNode)
+	if !ok || lmn.routeLen != 1 {
+		return nil
+	}
+	rmn, ok := right.(*MergeNode)
+	if !ok || rmn.routeLen != 1 {
+		return nil
+	}
+	// The global tables are on every backend.
+	backend, rng := lmn.backend, lmn.Querys[0].Range
+	if lmn.shardCount == 0 {
+		backend, rng = rmn.backend, rmn.Querys[0].Range
+	} else if rmn.shardCount != 0 && lmn.backend != rmn.backend {
+		return nil
+	}
+
+	mn := newMergeNode(log, database, router)
+	mn.sel = lmn.sel
+	mn.shardCount = lmn.shardCount + rmn.shardCount
+	mn.backend = backend
+	mn.routeLen = 1
+	mn.Querys = []xcontext.QueryTuple{{
+		Query:   sqlparser.String(node),
+		Backend: backend,
+		Range:   rng,
+	}}
+	return mn
+}
+
+// checkUnionColumns checks the arms of the union have the same number of columns,
+// the check is skipped if the number is unknown due to the '*'.
+func checkUnionColumns(node *sqlparser.Union) error {
+	count := -1
+	for _, sel := range unionArms(node) {
+		n := len(sel.SelectExprs)
+		for _, expr := range sel.SelectExprs {
+			if _, ok := expr.(*sqlparser.StarExpr); ok {
+				n = -1
+				break
+			}
+		}
+		if n == -1 {
+			continue
+		}
+		if count != -1 && count != n {
+			return errors.New("the.used.select.statements.have.a.different.number.of.columns")
+		}
+		count = n
+	}
+	return nil
+}
+
+// unionArms returns the selects of the statement from left to right.
+func unionArms(node sqlparser.SelectStatement) []*sqlparser.Select {
+	switch node := node.(type) {
+	case *sqlparser.Select:
+		return []*sqlparser.Select{node}
+	case *sqlparser.ParenSelect:
+		return unionArms(node.Select)
+	case *sqlparser.Union:
+		return append(unionArms(node.Left), unionArms(node.Right)...)
+	}
+	return nil
+}
+
+// unionFields returns the field names of the select, used to check the order by of the union.
+func unionFields(sel *sqlparser.Select) []selectTuple {
+	var tuples []selectTuple
+	for _, expr := range sel.SelectExprs {
+		switch expr := expr.(type) {
+		case *sqlparser.AliasedExpr:
+			field := expr.As.String()
+			if field == "" {
+				if col, ok := expr.Expr.(*sqlparser.ColName); ok {
+					field = col.Name.String()
+				} else {
+					field = sqlparser.String(expr.Expr)
+				}
+			}
+			tuples = append(tuples, selectTuple{expr: expr, field: field})
+		case *sqlparser.StarExpr:
+			tuples = append(tuples, selectTuple{expr: expr, field: "*"})
+		}
+	}
+	return tuples
+}
+
+// getReferredTables get the referredTables.
+func (u *UnionNode) getReferredTables() map[string]*TableInfo {
+	return u.referredTables
+}
+
+// getFields get the fields.
+func (u *UnionNode) getFields() []selectTuple {
+	return nil
+}
+
+// setParenthese set hasParen.
+func (u *UnionNode) setParenthese(hasParen bool) {}
+
+// pushFilter used to push the filters.
+func (u *UnionNode) pushFilter(filters []filterTuple) error {
+	return errors.New("unsupported: filter.on.union")
+}
+
+// setParent set the parent node.
+func (u *UnionNode) setParent(p PlanNode) {}
+
+// setWhereFilter used to push the where filters.
+func (u *UnionNode) setWhereFilter(filter sqlparser.Expr) {}
+
+// setNoTableFilter used to push the no table filters.
+func (u *UnionNode) setNoTableFilter(exprs []sqlparser.Expr) {}
+
+// pushEqualCmpr used to push the 'join' type filters.
+func (u *UnionNode) pushEqualCmpr(joins []joinTuple) PlanNode {
+	return u
+}
+
+// calcRoute used to calc the route.
+func (u *UnionNode) calcRoute() (PlanNode, error) {
+	return u, nil
+}
+
+// pushSelectExprs used to push the select fields.
+func (u *UnionNode) pushSelectExprs(fields, groups []selectTuple, sel *sqlparser.Select, hasAggregates bool) error {
+	return errors.New("unsupported: select.on.union")
+}
+
+// pushSelectExpr used to push the select field.
+func (u *UnionNode) pushSelectExpr(field selectTuple) (int, error) {
+	return -1, errors.New("unsupported: select.on.union")
+}
+
+// pushHaving used to push having exprs.
+func (u *UnionNode) pushHaving(havings []filterTuple) error {
+	return errors.New("unsupported: having.on.union")
+}
+
+// pushUnionOrderBy used to push the order by of the union, the order by
+// refers to the field names of the first select.
+func (u *UnionNode) pushUnionOrderBy(node *sqlparser.Union, fields []selectTuple) error {
+	if len(node.OrderBy) == 0 {
+		return nil
+	}
+	return u.pushOrderBy(&sqlparser.Select{OrderBy: node.OrderBy}, fields)
+}
+
+// pushOrderBy used to push the order by exprs.
+func (u *UnionNode) pushOrderBy(sel *sqlparser.Select, fields []selectTuple) error {
+	orderPlan := NewOrderByPlan(u.log, sel, fields, u.referredTables)
+	if err := orderPlan.Build(); err != nil {
+		return err
+	}
+	u.children.Add(orderPlan)
+	return nil
+}
+
+// pushLimit used to push limit.
+func (u *UnionNode) pushLimit(sel *sqlparser.Select) error {
+	limitPlan := NewLimitPlan(u.log, sel)
+	if err := limitPlan.Build(); err != nil {
+		return err
+	}
+	u.children.Add(limitPlan)
+	return nil
+}
+
+// pushMisc used tp push miscelleaneous constructs.
+func (u *UnionNode) pushMisc(sel *sqlparser.Select) {}
+
+// Children returns the children of the plan.
+func (u *UnionNode) Children() *PlanTree {
+	return u.children
+}
+
+// buildQuery used to build the QueryTuple, the arms have been built.
+func (u *UnionNode) buildQuery() error {
+	return nil
+}
+
+// GetQuery used to get the Querys.
+func (u *UnionNode) GetQuery() []xcontext.QueryTuple {
+	querys := append([]xcontext.QueryTuple{}, u.Left.GetQuery()...)
+	return append(querys, u.Right.GetQuery()...)
+}
@@ -0,0 +1,130 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package planner
+
+import (
+	"testing"
+
+	"router"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+func TestUnionNodePushDown(t *testing.T) {
+	querys := []string{
+		"select a from A where id=0 union select b from B where id=0",
+		"select a from A where id=1 union all select a from A where id=1 order by a limit 2",
+		"(select a from A where id=1 limit 1) union distinct select a from G",
+		"select a from A where id=0 union select a from A where id=0 union all select a from B where id=0",
+	}
+	wants := []string{
+		"select a from sbtest.A1 as A where id = 0 union select b from sbtest.B0 as B where id = 0",
+		"select a from sbtest.A6 as A where id = 1 union all select a from sbtest.A6 as A where id = 1 order by a asc limit 2",
+		"(select a from sbtest.A6 as A where id = 1 limit 1) union distinct select a from sbtest.G",
+		"select a from sbtest.A1 as A where id = 0 union select a from sbtest.A1 as A where id = 0 union all select a from sbtest.B0 as B where id = 0",
+	}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+	err := route.AddForTest(database, router.MockTableMConfig(), router.MockTableBConfig(), router.MockTableGConfig())
+	assert.Nil(t, err)
+
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewSelectPlan(log, database, query, node.(*sqlparser.Union), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		_, ok := plan.Root.(*MergeNode)
+		assert.True(t, ok)
+		got := plan.Root.GetQuery()
+		assert.Equal(t, 1, len(got))
+		assert.Equal(t, wants[i], got[0].Query)
+	}
+}
+
+func TestUnionNodePlan(t *testing.T) {
+	querys := []string{
+		"select a from A where id=1 union all select a from A where id=0",
+		"select a, b from A union select a, b from B where id=1 order by b desc limit 1, 2",
+		"select a from A where id=1 union select b from B union all select c from G",
+	}
+	wants := [][]string{
+		{"select a from sbtest.A6 as A where id = 1", "select a from sbtest.A1 as A where id = 0"},
+		{"select a, b from sbtest.A1 as A", "select a, b from sbtest.A2 as A", "select a, b from sbtest.A3 as A", "select a, b from sbtest.A4 as A", "select a, b from sbtest.A5 as A", "select a, b from sbtest.A6 as A", "select a, b from sbtest.B1 as B where id = 1"},
+		{"select a from sbtest.A6 as A where id = 1", "select b from sbtest.B0 as B", "select b from sbtest.B1 as B", "select c from sbtest.G"},
+	}
+	distincts := []bool{false, true, false}
+	children := []int{0, 2, 0}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+	err := route.AddForTest(database, router.MockTableMConfig(), router.MockTableBConfig(), router.MockTableGConfig())
+	assert.Nil(t, err)
+
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewSelectPlan(log, database, query, node.(*sqlparser.Union), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+
+		u, ok := plan.Root.(*UnionNode)
+		assert.True(t, ok)
+		assert.Equal(t, distincts[i], u.Distinct)
+		assert.Equal(t, children[i], len(u.Children().Plans()))
+		var got []string
+		for _, q := range plan.Root.GetQuery() {
+			got = append(got, q.Query)
+		}
+		assert.Equal(t, wants[i], got)
+	}
+}
+
+func TestUnionNodeError(t *testing.T) {
+	querys := []string{
+		"select a, b from A union select a from B",
+		"select a from A union select a from B order by A.a",
+		"select a from A union select a from B order by b",
+		"select a from A union select a from B limit x",
+		"select a from A union select a from B for update",
+		"select a from A union select a from C",
+	}
+	wants := []string{
+		"the.used.select.statements.have.a.different.number.of.columns",
+		"unsupported: unknow.table.in.order.by.field[A.a]",
+		"unsupported: orderby[b].should.in.select.list",
+		"unsupported: limit.offset.or.counts.must.be.IntVal",
+		"unsupported: lock.in.cross-shard.union",
+		"Table 'C' doesn't exist (errno 1146) (sqlstate 42S02)",
+	}
+
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+	err := route.AddForTest(database, router.MockTableMConfig(), router.MockTableBConfig(), router.MockTableGConfig())
+	assert.Nil(t, err)
+
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewSelectPlan(log, database, query, node.(*sqlparser.Union), route)
+		err = plan.Build()
+		assert.NotNil(t, err)
+		assert.Equal(t, wants[i], err.Error())
+	}
+}
@@ -10,6 +10,7 @@ package planner
 
 import (
 	"encoding/json"
+	"strconv"
 
 	"router"
 	"xcontext"
@@ -17,11 +18,14 @@ import (
 	"github.com/pkg/errors"
 	"github.com/xelabs/go-mysqlstack/sqlparser"
 	"github.com/xelabs/go-mysqlstack/sqlparser/depends/common"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
 	"github.com/xelabs/go-mysqlstack/xlog"
 )
 
 var (
-	_ Plan = &UpdatePlan{}
+	_ Plan         = &UpdatePlan{}
+	_ SubqueryPlan = &UpdatePlan{}
+	_ ShardKeyMove = &UpdatePlan{}
 )
 
 // UpdatePlan represents delete plan
@@ -48,6 +52,34 @@ type UpdatePlan struct {
 
 	// query and backend tuple
 	Querys []xcontext.QueryTuple
+
+	// subqueries to materialize before building the querys.
+	subqueries []*Subquery
+
+	// Select is the plan of the join if the multi-table update isn't pushed down.
+	Select *SelectPlan
+
+	// join is the multi-table update.
+	join *joinDML
+
+	// MoveShardKey is true if the update changes the shard key, the rows are
+	// moved by the MoveReads and MoveQuerys instead of the Querys.
+	MoveShardKey bool
+
+	// moveIndex reads the unique keys of the table, the rows read are deleted by them.
+	moveIndex xcontext.QueryTuple
+
+	// moveReads read the rows with the new values of the updated columns, every row
+	// is read with the index of its segment in the moveSegments.
+	moveReads    []xcontext.QueryTuple
+	moveSegments []router.Segment
+
+	// database and table updated.
+	moveDatabase string
+	moveTable    string
+
+	// tables written by the plan.
+	writeTables
 }
 
 // NewUpdatePlan used to create UpdatePlan
@@ -65,15 +97,16 @@ func NewUpdatePlan(log *xlog.Log, database string, query string, node *sqlparser
 
 // analyze used to analyze the 'update' is at the support level.
 func (p *UpdatePlan) analyze() error {
+	var err error
 	node := p.node
-	// analyze subquery.
-	if hasSubquery(p.node) {
-		return errors.New("unsupported: subqueries.in.update")
-	}
 	if node.Where == nil {
 		return errors.New("unsupported: missing.where.clause.in.DML")
 	}
-	return nil
+	// analyze subquery.
+	if hasSubquery(node) {
+		p.subqueries, err = buildSubqueries(p.log, p.router, p.database, node)
+	}
+	return err
 }
 
 // Build used to build distributed querys.
@@ -81,8 +114,33 @@ func (p *UpdatePlan) Build() error {
 	if err := p.analyze(); err != nil {
 		return err
 	}
+	if len(p.subqueries) > 0 {
+		return nil
+	}
+	return p.build()
+}
+
+// Subqueries returns the subqueries not yet materialized.
+func (p *UpdatePlan) Subqueries() []*Subquery {
+	return p.subqueries
+}
+
+// Materialize substitutes the results of the subqueries, then builds the querys.
+func (p *UpdatePlan) Materialize(results []*sqltypes.Result) error {
+	if err := substituteSubqueries(p.node, p.subqueries, results); err != nil {
+		return err
+	}
+	p.subqueries = nil
+	return p.build()
+}
 
+// build used to build the querys.
+func (p *UpdatePlan) build() error {
 	node := p.node
+	if len(node.TableExprs) > 0 {
+		return p.buildJoin()
+	}
+
 	// Database.
 	database := p.database
 	if !node.Table.Qualifier.IsEmpty() {
@@ -95,20 +153,226 @@ func (p *UpdatePlan) Build() error {
 	if err != nil {
 		return err
 	}
+	if err := p.checkWritable(p.router, database, table); err != nil {
+		return err
+	}
 
 	// analyze shardkey changing.
 	if isShardKeyChanging(node.Exprs, shardkey) {
-		return errors.New("unsupported: cannot.update.shard.key")
+		return p.buildMove(database, table, shardkey)
+	}
+
+	querys, err := buildUpdateQuerys(node, database, table, shardkey, p.router)
+	if err != nil {
+		return err
+	}
+	p.Querys = append(p.Querys, querys...)
+	return nil
+}
+
+// buildMove used to build the update which changes the shard key. The rows matched are read
+// with the new values of the updated columns by the moveReads, then the MoveQuerys delete
+// the rows read by their unique keys and insert the updated rows into their new partitions.
+func (p *UpdatePlan) buildMove(database, table, shardkey string) error {
+	node := p.node
+	if node.OrderBy != nil || node.Limit != nil {
+		return errors.New("unsupported: cannot.update.shard.key.with.order.by.or.limit")
 	}
 
 	// Get the routing segments info.
-	segments, err := getDMLRouting(database, table, shardkey, node.Where, p.router)
+	segments, in, err := getDMLRouting(database, table, shardkey, node.Where, p.router)
+	if err != nil {
+		return err
+	}
+
+	values := moveValues(node.Exprs)
+	for i, segment := range segments {
+		in.rewrite(segment.Table)
+		read := sqlparser.NewTrackedBuffer(nil)
+		read.Myprintf("select %v%s, *, %v from %s.%s%v for update", node.Comments, strconv.Itoa(i), values, database, segment.Table, node.Where)
+		p.moveReads = append(p.moveReads, xcontext.QueryTuple{
+			Query:   read.String(),
+			Backend: segment.Backend,
+			Range:   segment.Range.String(),
+		})
+	}
+	in.restore()
+
+	if p.moveIndex, err = uniqueKeysRead(p.router, database, table); err != nil {
+		return err
+	}
+	p.moveSegments = segments
+	p.MoveShardKey = true
+	p.moveDatabase = database
+	p.moveTable = table
+	return nil
+}
+
+// MoveReads returns the unique keys read first, then the reads of the rows whose shard key
+// is updated, such as: 'select 0, *, <new values> from t where ... for update'.
+func (p *UpdatePlan) MoveReads(results []*sqltypes.Result) ([]xcontext.QueryTuple, error) {
+	switch len(results) {
+	case 0:
+		return []xcontext.QueryTuple{p.moveIndex}, nil
+	case 1:
+		return p.moveReads, nil
+	}
+	return nil, nil
+}
+
+// MoveQuerys returns the deletes of the rows read by their unique keys and the inserts of the
+// updated rows, the rows matched after the reads aren't changed. The rows affected is the number
+// of the rows moved.
+func (p *UpdatePlan) MoveQuerys(results []*sqltypes.Result) ([]xcontext.QueryTuple, uint64, error) {
+	rs := results[1]
+	if len(rs.Rows) == 0 {
+		return nil, 0, nil
+	}
+	keys := uniqueKeys(results[0])
+	if len(keys) == 0 {
+		return nil, 0, errors.New("unsupported: cannot.update.shard.key.of.the.table.without.unique.key")
+	}
+	fields := rs.Fields[1:]
+	n := len(fields) - len(p.node.Exprs)
+	if n <= 0 {
+		return nil, 0, errors.Errorf("unsupported: shard.key.move.fields[%d].doesn't.match.the.update[%d]", len(fields), len(p.node.Exprs))
+	}
+
+	// The deletes of the rows read, grouped by their segments.
+	wheres := make([]sqlparser.Expr, len(p.moveSegments))
+	rows := make([][]sqltypes.Value, 0, len(rs.Rows))
+	for _, row := range rs.Rows {
+		i, err := strconv.Atoi(row[0].String())
+		if err != nil || i < 0 || i >= len(p.moveSegments) {
+			return nil, 0, errors.Errorf("shard.key.move.segment[%s].out.of.range", row[0].String())
+		}
+		row = row[1:]
+		where, err := fieldsEquals(keys, fields[:n], row[:n])
+		if err != nil {
+			return nil, 0, err
+		}
+		if wheres[i] != nil {
+			where = &sqlparser.OrExpr{Left: wheres[i], Right: where}
+		}
+		wheres[i] = where
+		rows = append(rows, row)
+	}
+	var querys []xcontext.QueryTuple
+	for i, where := range wheres {
+		if where == nil {
+			continue
+		}
+		segment := p.moveSegments[i]
+		buf := sqlparser.NewTrackedBuffer(nil)
+		buf.Myprintf("delete %vfrom %s.%s where %v", p.node.Comments, p.moveDatabase, segment.Table, where)
+		querys = append(querys, xcontext.QueryTuple{
+			Query:   buf.String(),
+			Backend: segment.Backend,
+			Range:   segment.Range.String(),
+		})
+	}
+
+	columns, vals, err := movedRows(fields, rows, p.node.Exprs)
+	if err != nil {
+		return nil, 0, err
+	}
+	inserts, err := moveInserts(p.log, p.router, p.moveDatabase, p.moveTable, p.node.Comments, columns, vals)
+	if err != nil {
+		return nil, 0, err
+	}
+	querys = append(querys, inserts...)
+	return querys, uint64(len(rs.Rows)), nil
+}
+
+// buildJoin used to build the multi-table update. The update is pushed down per partition
+// if the tables are co-located, otherwise the join is evaluated by the Select plan and
+// the updates are built by RowQuerys.
+func (p *UpdatePlan) buildJoin() error {
+	node := p.node
+	j, err := newJoinDML(p.log, p.router, p.database, node.TableExprs, node.Where)
+	if err != nil {
+		return err
+	}
+	for _, expr := range node.Exprs {
+		name, err := j.tableOf(expr.Name)
+		if err != nil {
+			return err
+		}
+		target, err := j.addTarget(name)
+		if err != nil {
+			return err
+		}
+		if isShardKeyColumn(expr.Name.Name.String(), target.table.shardKey) {
+			return errors.New("unsupported: cannot.update.shard.key")
+		}
+	}
+	for _, target := range j.targets {
+		if err := p.checkWritable(p.router, target.table.database, target.table.tableName); err != nil {
+			return err
+		}
+	}
+
+	querys, ok, err := j.pushDown(func(buf *sqlparser.TrackedBuffer, from sqlparser.TableExprs, where *sqlparser.Where) {
+		buf.Myprintf("update %v%v set %v%v", node.Comments, from, node.Exprs, where)
+	})
 	if err != nil {
 		return err
 	}
+	if ok {
+		p.Querys = append(p.Querys, querys...)
+		return nil
+	}
+
+	if len(j.targets) > 1 {
+		return errors.New("unsupported: multi-table.update.of.more.than.one.table.across.shards")
+	}
+	var refs []sqlparser.Expr
+	for _, expr := range node.Exprs {
+		refs = append(refs, expr.Expr)
+	}
+	p.join = j
+	p.Select, err = j.buildSelect(refs)
+	return err
+}
+
+// RowQuerys used to build the querys which update the target rows of the joined rows,
+// the rows are produced by the Select plan.
+func (p *UpdatePlan) RowQuerys(rows [][]sqltypes.Value) ([]xcontext.QueryTuple, error) {
+	var querys []xcontext.QueryTuple
+	node := p.node
+	target := p.join.targets[0]
+	groups := p.join.groupRows(target, rows, func(row []sqltypes.Value) (interface{}, string) {
+		exprs := p.join.substitute(target, node.Exprs, row)
+		return exprs, sqlparser.String(exprs)
+	})
+	for _, group := range groups {
+		update := &sqlparser.Update{
+			Comments: node.Comments,
+			Table:    sqlparser.TableName{Name: sqlparser.NewTableIdent(target.table.tableName), Qualifier: sqlparser.NewTableIdent(target.table.database)},
+			Exprs:    group.value.(sqlparser.UpdateExprs),
+			Where:    group.where,
+		}
+		qs, err := buildUpdateQuerys(update, target.table.database, target.table.tableName, target.table.shardKey, p.router)
+		if err != nil {
+			return nil, err
+		}
+		querys = append(querys, qs...)
+	}
+	return querys, nil
+}
+
+// buildUpdateQuerys used to build the querys of the single table update, routed by the where clause.
+func buildUpdateQuerys(node *sqlparser.Update, database, table, shardkey string, route *router.Router) ([]xcontext.QueryTuple, error) {
+	// Get the routing segments info.
+	segments, in, err := getDMLRouting(database, table, shardkey, node.Where, route)
+	if err != nil {
+		return nil, err
+	}
 
 	// Rewrite the query.
+	querys := make([]xcontext.QueryTuple, 0, len(segments))
 	for _, segment := range segments {
+		in.rewrite(segment.Table)
 		buf := sqlparser.NewTrackedBuffer(nil)
 		buf.Myprintf("update %v%s.%s set %v%v%v%v", node.Comments, database, segment.Table, node.Exprs, node.Where, node.OrderBy, node.Limit)
 		tuple := xcontext.QueryTuple{
@@ -116,9 +380,10 @@ func (p *UpdatePlan) Build() error {
 			Backend: segment.Backend,
 			Range:   segment.Range.String(),
 		}
-		p.Querys = append(p.Querys, tuple)
+		querys = append(querys, tuple)
 	}
-	return nil
+	in.restore()
+	return querys, nil
 }
 
 // Type returns the type of the plan.
@@ -131,6 +396,9 @@ func (p *UpdatePlan) JSON() string {
 	type explain struct {
 		RawQuery   string                `json:",omitempty"`
 		Partitions []xcontext.QueryTuple `json:",omitempty"`
+		Subqueries []json.RawMessage     `json:",omitempty"`
+		Select     json.RawMessage       `json:",omitempty"`
+		Reads      []xcontext.QueryTuple `json:",omitempty"`
 	}
 
 	// Partitions.
@@ -139,6 +407,13 @@ func (p *UpdatePlan) JSON() string {
 	exp := &explain{
 		RawQuery:   p.RawQuery,
 		Partitions: parts,
+		Subqueries: subqueriesJSON(p.subqueries),
+	}
+	if p.MoveShardKey {
+		exp.Reads = append([]xcontext.QueryTuple{p.moveIndex}, p.moveReads...)
+	}
+	if p.Select != nil {
+		exp.Select = json.RawMessage(p.Select.JSON())
 	}
 	bout, err := json.MarshalIndent(exp, "", "\t")
 	if err != nil {
@@ -9,12 +9,16 @@
 package planner
 
 import (
+	"fmt"
 	"router"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
 	"github.com/xelabs/go-mysqlstack/xlog"
+
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
 )
 
 func TestUpdatePlan(t *testing.T) {
@@ -42,31 +46,6 @@ func TestUpdatePlan(t *testing.T) {
 		`{
 	"RawQuery": "update sbtest.A set val = 1 where id in (1, 2)",
 	"Partitions": [
-		{
-			"Query": "update sbtest.A1 set val = 1 where id in (1, 2)",
-			"Backend": "backend1",
-			"Range": "[0-32)"
-		},
-		{
-			"Query": "update sbtest.A2 set val = 1 where id in (1, 2)",
-			"Backend": "backend2",
-			"Range": "[32-64)"
-		},
-		{
-			"Query": "update sbtest.A3 set val = 1 where id in (1, 2)",
-			"Backend": "backend3",
-			"Range": "[64-96)"
-		},
-		{
-			"Query": "update sbtest.A4 set val = 1 where id in (1, 2)",
-			"Backend": "backend4",
-			"Range": "[96-256)"
-		},
-		{
-			"Query": "update sbtest.A5 set val = 1 where id in (1, 2)",
-			"Backend": "backend5",
-			"Range": "[256-512)"
-		},
 		{
 			"Query": "update sbtest.A6 set val = 1 where id in (1, 2)",
 			"Backend": "backend6",
@@ -113,14 +92,14 @@ func TestUpdatePlan(t *testing.T) {
 func TestUpdateUnsupportedPlan(t *testing.T) {
 	querys := []string{
 		"update sbtest.A set a=3",
-		"update sbtest.A set id=3 where id=1",
-		"update sbtest.A set b=3 where id in (select id from t1)",
+		"update sbtest.A set id=3 where id=1 limit 1",
+		"update sbtest.A set b=3 where id in (select id from t1 where t1.a=A.a)",
 	}
 
 	results := []string{
 		"unsupported: missing.where.clause.in.DML",
-		"unsupported: cannot.update.shard.key",
-		"unsupported: subqueries.in.update",
+		"unsupported: cannot.update.shard.key.with.order.by.or.limit",
+		"unsupported: correlated.subquery.column[A.a]",
 	}
 
 	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
@@ -217,7 +196,8 @@ func TestUpdateShardKey(t *testing.T) {
 	// plan build
 	{
 		err := plan.Build()
-		assert.NotNil(t, err)
+		assert.Nil(t, err)
+		assert.True(t, plan.MoveShardKey)
 	}
 }
 
@@ -269,3 +249,311 @@ func TestUpdateDatabaseNotFound(t *testing.T) {
 		assert.NotNil(t, err)
 	}
 }
+
+func TestUpdateCompositeShardKey(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableCConfig())
+	assert.Nil(t, err)
+
+	querys := []string{
+		"update sbtest.C set c = 1 where a = 1 and b = 2",
+		"update sbtest.C set c = 1 where a = 1",
+		"update sbtest.C set b = 1 where a = 1",
+	}
+	wants := []int{1, 2, 0}
+	moves := []bool{false, false, true}
+	for i, query := range querys {
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewUpdatePlan(log, database, query, node.(*sqlparser.Update), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+		assert.Equal(t, moves[i], plan.MoveShardKey)
+		assert.Equal(t, wants[i], len(plan.Querys))
+	}
+	// The rows are read from the partitions routed by the where.
+	{
+		query := querys[2]
+		node, err := sqlparser.Parse(query)
+		assert.Nil(t, err)
+		plan := NewUpdatePlan(log, database, query, node.(*sqlparser.Update), route)
+		err = plan.Build()
+		assert.Nil(t, err)
+		reads, err := plan.MoveReads([]*sqltypes.Result{{}})
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(reads))
+	}
+}
+
+func TestUpdateJoinPlan(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	// B has the same partitions as A, D hasn't.
+	confB := router.MockTableMConfig()
+	confB.Name = "B"
+	for i, part := range confB.Partitions {
+		part.Table = fmt.Sprintf("B%d", i+1)
+	}
+	confD := router.MockTableBConfig()
+	confD.Name = "D"
+	for i, part := range confD.Partitions {
+		part.Table = fmt.Sprintf("D%d", i)
+	}
+	err := route.AddForTest(database, router.MockTableMConfig(), confB, confD, router.MockTableGConfig(), router.MockTableG1Config())
+	assert.Nil(t, err)
+
+	// Pushed down per partition.
+	{
+		querys := []string{
+			"update A join B on A.id = B.id set A.a = B.a where A.id = 1",
+			"update A, G set A.a = G.a where G.c = 1 and A.b = G.b",
+			"update G join G1 on G.id = G1.id set G.a = G1.a where G1.b = 1",
+		}
+		wants := [][]string{
+			{"update sbtest.A6 as A join sbtest.B6 as B on A.id = B.id set A.a = B.a where A.id = 1"},
+			{
+				"update sbtest.A1 as A, sbtest.G set A.a = G.a where G.c = 1 and A.b = G.b",
+				"update sbtest.A2 as A, sbtest.G set A.a = G.a where G.c = 1 and A.b = G.b",
+				"update sbtest.A3 as A, sbtest.G set A.a = G.a where G.c = 1 and A.b = G.b",
+				"update sbtest.A4 as A, sbtest.G set A.a = G.a where G.c = 1 and A.b = G.b",
+				"update sbtest.A5 as A, sbtest.G set A.a = G.a where G.c = 1 and A.b = G.b",
+				"update sbtest.A6 as A, sbtest.G set A.a = G.a where G.c = 1 and A.b = G.b",
+			},
+			{
+				"update sbtest.G join sbtest.G1 on G.id = G1.id set G.a = G1.a where G1.b = 1",
+				"update sbtest.G join sbtest.G1 on G.id = G1.id set G.a = G1.a where G1.b = 1",
+			},
+		}
+		for i, query := range querys {
+			node, err := sqlparser.Parse(query)
+			assert.Nil(t, err)
+			plan := NewUpdatePlan(log, database, query, node.(*sqlparser.Update), route)
+			err = plan.Build()
+			assert.Nil(t, err)
+			assert.Nil(t, plan.Select, query)
+			var got []string
+			for _, q := range plan.Querys {
+				got = append(got, q.Query)
+			}
+			assert.Equal(t, wants[i], got)
+		}
+	}
+
+	// The join is evaluated by the proxy.
+	{
+		querys := []string{
+			"update A join D on A.b = D.b set A.a = D.a + 1 where D.c = 1 and A.c > 2",
+			"update G join A on G.id = A.id set G.a = A.a where A.id = 1",
+		}
+		selects := []string{
+			"select A.b, D.a from A join D on A.b = D.b where D.c = 1 and A.c > 2",
+			"select G.id, A.a from G join A on G.id = A.id where A.id = 1",
+		}
+		rows := [][][]sqltypes.Value{
+			{
+				{sqltypes.NewInt64(1), sqltypes.NewInt64(10)},
+				{sqltypes.NewInt64(2), sqltypes.NewInt64(10)},
+				{sqltypes.NewInt64(1), sqltypes.NewInt64(10)},
+				{sqltypes.NULL, sqltypes.NewInt64(10)},
+				{sqltypes.NewInt64(3), sqltypes.NewVarChar("x")},
+			},
+			{
+				{sqltypes.NewInt64(1), sqltypes.NewInt64(5)},
+			},
+		}
+		wants := [][]string{
+			{
+				"update sbtest.A1 set a = 10 + 1 where b in (1, 2) and c > 2",
+				"update sbtest.A2 set a = 10 + 1 where b in (1, 2) and c > 2",
+				"update sbtest.A3 set a = 10 + 1 where b in (1, 2) and c > 2",
+				"update sbtest.A4 set a = 10 + 1 where b in (1, 2) and c > 2",
+				"update sbtest.A5 set a = 10 + 1 where b in (1, 2) and c > 2",
+				"update sbtest.A6 set a = 10 + 1 where b in (1, 2) and c > 2",
+				"update sbtest.A1 set a = 'x' + 1 where b = 3 and c > 2",
+				"update sbtest.A2 set a = 'x' + 1 where b = 3 and c > 2",
+				"update sbtest.A3 set a = 'x' + 1 where b = 3 and c > 2",
+				"update sbtest.A4 set a = 'x' + 1 where b = 3 and c > 2",
+				"update sbtest.A5 set a = 'x' + 1 where b = 3 and c > 2",
+				"update sbtest.A6 set a = 'x' + 1 where b = 3 and c > 2",
+			},
+			{
+				"update sbtest.G set a = 5 where id = 1",
+				"update sbtest.G set a = 5 where id = 1",
+			},
+		}
+		for i, query := range querys {
+			node, err := sqlparser.Parse(query)
+			assert.Nil(t, err)
+			plan := NewUpdatePlan(log, database, query, node.(*sqlparser.Update), route)
+			err = plan.Build()
+			assert.Nil(t, err)
+			assert.Equal(t, 0, len(plan.Querys))
+			assert.NotNil(t, plan.Select)
+			assert.Equal(t, selects[i], plan.Select.RawQuery)
+
+			querys, err := plan.RowQuerys(rows[i])
+			assert.Nil(t, err)
+			var got []string
+			for _, q := range querys {
+				got = append(got, q.Query)
+			}
+			assert.Equal(t, wants[i], got)
+		}
+	}
+
+	// Unsupported.
+	{
+		querys := []string{
+			"update A join D on A.b = D.b set A.a = 1",
+			"update A join D on A.b = D.b set A.id = 1 where D.c = 1",
+			"update A join D on A.b = D.b set A.a = 1, D.a = 2 where D.c = 1",
+			"update A join D on A.b = D.b set a = 1 where D.c = 1",
+			"update A join D on A.b = D.b set A.a = c where D.c = 1",
+			"update A join D on A.b = D.b set X.a = 1 where D.c = 1",
+			"update A join (select b from D) as t on A.b = t.b set t.b = 1 where A.c = 1",
+			"update A join E on A.b = E.b set A.a = 1 where E.c = 1",
+		}
+		wants := []string{
+			"unsupported: missing.where.clause.in.DML",
+			"unsupported: cannot.update.shard.key",
+			"unsupported: multi-table.update.of.more.than.one.table.across.shards",
+			"unsupported: unknown.column.'a'.in.clause",
+			"unsupported: unknown.column.'c'.in.clause",
+			"unsupported: unknown.table.'X'.in.multi-table.DML",
+			"unsupported: the.target.table.'t'.is.not.updatable",
+			"Table 'E' doesn't exist (errno 1146) (sqlstate 42S02)",
+		}
+		for i, query := range querys {
+			node, err := sqlparser.Parse(query)
+			assert.Nil(t, err)
+			plan := NewUpdatePlan(log, database, query, node.(*sqlparser.Update), route)
+			err = plan.Build()
+			assert.NotNil(t, err, query)
+			if err != nil {
+				assert.Equal(t, wants[i], err.Error(), query)
+			}
+		}
+	}
+}
+
+func TestUpdateMoveShardKeyPlan(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	database := "sbtest"
+
+	route, cleanup := router.MockNewRouter(log)
+	defer cleanup()
+
+	err := route.AddForTest(database, router.MockTableMConfig())
+	assert.Nil(t, err)
+
+	query := "update sbtest.A set id = 99999999, b = default, c = id where id in (1, 2) or b = 3"
+	node, err := sqlparser.Parse(query)
+	assert.Nil(t, err)
+	plan := NewUpdatePlan(log, database, query, node.(*sqlparser.Update), route)
+	err = plan.Build()
+	assert.Nil(t, err)
+	assert.True(t, plan.MoveShardKey)
+	assert.Equal(t, 0, len(plan.Querys))
+
+	// Read the unique keys.
+	reads, err := plan.MoveReads(nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(reads))
+	assert.Equal(t, "select index_name, column_name from information_schema.statistics where table_schema = 'sbtest' and table_name = 'A1' and non_unique = 0 order by index_name != 'PRIMARY', index_name, seq_in_index", reads[0].Query)
+
+	// Read the rows with the index of their segments.
+	index := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "index_name", Type: querypb.Type_VARCHAR},
+			{Name: "column_name", Type: querypb.Type_VARCHAR},
+		},
+		Rows: [][]sqltypes.Value{
+			{sqltypes.NewVarChar("PRIMARY"), sqltypes.NewVarChar("id")},
+		},
+	}
+	results := []*sqltypes.Result{index}
+	reads, err = plan.MoveReads(results)
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(reads))
+	assert.Equal(t, "select 0, *, 99999999, default(b), id from sbtest.A1 where id in (1, 2) or b = 3 for update", reads[0].Query)
+	assert.Equal(t, "select 5, *, 99999999, default(b), id from sbtest.A6 where id in (1, 2) or b = 3 for update", reads[5].Query)
+
+	rs := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "5", Type: querypb.Type_INT64},
+			{Name: "id", Type: querypb.Type_INT32},
+			{Name: "b", Type: querypb.Type_INT32},
+			{Name: "c", Type: querypb.Type_INT32},
+			{Name: "99999999", Type: querypb.Type_INT32},
+			{Name: "default(b)", Type: querypb.Type_INT32},
+			{Name: "id", Type: querypb.Type_INT32},
+		},
+		Rows: [][]sqltypes.Value{
+			{sqltypes.NewInt64(5), sqltypes.NewInt32(1), sqltypes.NewInt32(5), sqltypes.NewInt32(7), sqltypes.NewInt32(99999999), sqltypes.NULL, sqltypes.NewInt32(1)},
+			{sqltypes.NewInt64(5), sqltypes.NewInt32(2), sqltypes.NewInt32(3), sqltypes.NewInt32(7), sqltypes.NewInt32(99999999), sqltypes.NULL, sqltypes.NewInt32(2)},
+		},
+	}
+	results = append(results, rs)
+	reads, err = plan.MoveReads(results)
+	assert.Nil(t, err)
+	assert.Nil(t, reads)
+
+	// The rows read are deleted by the primary key.
+	querys, affected, err := plan.MoveQuerys(results)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(2), affected)
+	var got []string
+	for _, q := range querys {
+		got = append(got, q.Query)
+	}
+	want := []string{
+		"delete from sbtest.A6 where id = 1 or id = 2",
+		"insert into sbtest.A4(id, b, c) values (99999999, null, 1), (99999999, null, 2)",
+	}
+	assert.Equal(t, want, got)
+
+	// No rows matched.
+	querys, affected, err = plan.MoveQuerys([]*sqltypes.Result{index, {Fields: rs.Fields}})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), affected)
+	assert.Nil(t, querys)
+
+	// Errors.
+	{
+		tcases := []struct {
+			results []*sqltypes.Result
+			err     string
+		}{
+			{
+				[]*sqltypes.Result{{Fields: index.Fields}, rs},
+				"unsupported: cannot.update.shard.key.of.the.table.without.unique.key",
+			},
+			{
+				[]*sqltypes.Result{index, {Fields: rs.Fields[:4], Rows: [][]sqltypes.Value{rs.Rows[0][:4]}}},
+				"unsupported: shard.key.move.fields[3].doesn't.match.the.update[3]",
+			},
+			{
+				[]*sqltypes.Result{index, {Fields: rs.Fields, Rows: [][]sqltypes.Value{append([]sqltypes.Value{sqltypes.NewInt64(6)}, rs.Rows[0][1:]...)}}},
+				"shard.key.move.segment[6].out.of.range",
+			},
+			{
+				[]*sqltypes.Result{index, {Fields: rs.Fields, Rows: [][]sqltypes.Value{append([]sqltypes.Value{sqltypes.NewInt64(5), sqltypes.NULL}, rs.Rows[0][2:]...)}}},
+				"unsupported: the.unique.key.of.the.row.moved.is.null",
+			},
+		}
+		for _, tcase := range tcases {
+			_, _, err := plan.MoveQuerys(tcase.results)
+			assert.NotNil(t, err)
+			assert.Equal(t, tcase.err, err.Error())
+		}
+	}
+}
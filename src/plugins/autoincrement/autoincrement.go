@@ -61,6 +61,12 @@ func GetAutoIncrement(node *sqlparser.DDL) *config.AutoIncrement {
 func modifyForAutoinc(ins *sqlparser.Insert, autoinc *config.AutoIncrement, seq uint64) {
 	col := sqlparser.NewColIdent(autoinc.Column)
 
+	// The rows of the 'insert ... select' are unknown until the select is executed.
+	rows, ok := ins.Rows.(sqlparser.Values)
+	if !ok {
+		return
+	}
+
 	// Insert has autoinc column.
 	for _, column := range ins.Columns {
 		if col.Equal(column) {
@@ -73,7 +79,6 @@ func modifyForAutoinc(ins *sqlparser.Insert, autoinc *config.AutoIncrement, seq
 	ins.Columns = append(ins.Columns, col)
 
 	// 2. append vals to each row's end.
-	rows := ins.Rows.(sqlparser.Values)
 	for i := range rows {
 		seq++
 		rows[i] = append(rows[i], sqlparser.NewIntVal([]byte(strconv.FormatUint(seq, 10))))
@@ -0,0 +1,245 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"config"
+
+	"github.com/pkg/errors"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+)
+
+const (
+	// chunkSyncRows is the max rows of the driver source in one chunk.
+	chunkSyncRows = 1000
+)
+
+// chunkSync tuple.
+// It catches up the writes to the sources during the copying, the rows of the
+// sources and the targets are compared chunk by chunk in the primary key order,
+// the chunk with the different checksum is deleted from the targets and copied again.
+// The union of the sources rows must be same as the union of the targets rows.
+type chunkSync struct {
+	database string
+	table    string
+	shardkey string
+	sources  []*config.PartitionConfig
+	targets  []*config.PartitionConfig
+
+	// keys is the primary key columns.
+	keys []string
+	// checksum is the select expressions of the count and the checksum of the chunk.
+	checksum string
+}
+
+// newChunkSync creates the chunkSync, returns nil if the table has no primary key,
+// the rows can't be compared by chunks.
+func (r *Reshard) newChunkSync(database string, table string, shardkey string, sources []*config.PartitionConfig, targets []*config.PartitionConfig) (*chunkSync, error) {
+	source := sources[0]
+	qr, err := r.execute(source.Backend, fmt.Sprintf("SHOW KEYS FROM %s.%s WHERE Key_name = 'PRIMARY'", database, source.Table))
+	if err != nil {
+		return nil, err
+	}
+	keys, err := resultColumn(qr, "Column_name")
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	qr, err = r.execute(source.Backend, fmt.Sprintf("SHOW COLUMNS FROM %s.%s", database, source.Table))
+	if err != nil {
+		return nil, err
+	}
+	columns, err := resultColumn(qr, "Field")
+	if err != nil {
+		return nil, err
+	}
+	// CONCAT_WS skips the NULLs, the ISNULL flags tell the NULL from the empty string.
+	values := make([]string, 0, len(columns)*2)
+	for _, column := range columns {
+		values = append(values, fmt.Sprintf("`%s`", column))
+	}
+	for _, column := range columns {
+		values = append(values, fmt.Sprintf("ISNULL(`%s`)", column))
+	}
+	for i, key := range keys {
+		keys[i] = fmt.Sprintf("`%s`", key)
+	}
+	return &chunkSync{
+		database: database,
+		table:    table,
+		shardkey: shardkey,
+		sources:  sources,
+		targets:  targets,
+		keys:     keys,
+		checksum: fmt.Sprintf("COUNT(*), COALESCE(BIT_XOR(CRC32(CONCAT_WS('#', %s))), 0)", strings.Join(values, ", ")),
+	}, nil
+}
+
+// resultColumn returns the values of the column in the result.
+func resultColumn(qr *sqltypes.Result, name string) ([]string, error) {
+	idx := -1
+	for i, field := range qr.Fields {
+		if strings.EqualFold(field.Name, name) {
+			idx = i
+			break
+		}
+	}
+	if idx == -1 {
+		if len(qr.Rows) == 0 {
+			return nil, nil
+		}
+		return nil, errors.Errorf("chunk.sync.result.column[%s].missing", name)
+	}
+	values := make([]string, 0, len(qr.Rows))
+	for _, row := range qr.Rows {
+		values = append(values, row[idx].String())
+	}
+	return values, nil
+}
+
+// sync compares all the chunks and copies the different ones again.
+// Returns the number of the chunks copied.
+func (r *Reshard) sync(s *chunkSync) (int, error) {
+	var diffs int
+	var lower []sqltypes.Value
+	for {
+		select {
+		case <-r.done:
+			return diffs, errors.New("reshard.sync.canceled")
+		default:
+		}
+
+		upper, err := r.chunkUpper(s, lower)
+		if err != nil {
+			return diffs, err
+		}
+		where := s.where(lower, upper)
+		same, err := r.chunkSame(s, where)
+		if err != nil {
+			return diffs, err
+		}
+		if !same {
+			for _, target := range s.targets {
+				if _, err := r.execute(target.Backend, fmt.Sprintf("DELETE FROM %s.%s%s", s.database, target.Table, where)); err != nil {
+					return diffs, err
+				}
+			}
+			for _, source := range s.sources {
+				if err := r.copyPartition(s.database, s.table, s.shardkey, source, s.targets, where); err != nil {
+					return diffs, err
+				}
+			}
+			diffs++
+		}
+		// The last chunk is open to the max key.
+		if upper == nil {
+			return diffs, nil
+		}
+		lower = upper
+	}
+}
+
+// chunkUpper returns the primary key of the last row of the chunk which starts after the lower,
+// nil means the chunk is the last one.
+func (r *Reshard) chunkUpper(s *chunkSync, lower []sqltypes.Value) ([]sqltypes.Value, error) {
+	driver := s.sources[0]
+	keys := strings.Join(s.keys, ",")
+	query := fmt.Sprintf("SELECT %s FROM %s.%s%s ORDER BY %s LIMIT 1 OFFSET %d", keys, s.database, driver.Table, s.where(lower, nil), keys, chunkSyncRows-1)
+	qr, err := r.execute(driver.Backend, query)
+	if err != nil {
+		return nil, err
+	}
+	if len(qr.Rows) == 0 {
+		return nil, nil
+	}
+	if len(qr.Rows[0]) != len(s.keys) {
+		return nil, errors.Errorf("chunk.sync.table[%s.%s].keys.mismatch", s.database, driver.Table)
+	}
+	return qr.Rows[0], nil
+}
+
+// chunkSame returns true if the count and the checksum of the chunk in the sources
+// are same as them in the targets.
+func (r *Reshard) chunkSame(s *chunkSync, where string) (bool, error) {
+	sum := func(parts []*config.PartitionConfig) (uint64, uint64, error) {
+		var count, checksum uint64
+		for _, part := range parts {
+			qr, err := r.execute(part.Backend, fmt.Sprintf("SELECT %s FROM %s.%s%s", s.checksum, s.database, part.Table, where))
+			if err != nil {
+				return 0, 0, err
+			}
+			if len(qr.Rows) == 0 || len(qr.Rows[0]) < 2 {
+				return 0, 0, errors.Errorf("chunk.sync.checksum.table[%s.%s].result.is.null", s.database, part.Table)
+			}
+			c, err := qr.Rows[0][0].ParseUint64()
+			if err != nil {
+				return 0, 0, err
+			}
+			x, err := qr.Rows[0][1].ParseUint64()
+			if err != nil {
+				return 0, 0, err
+			}
+			count += c
+			checksum ^= x
+		}
+		return count, checksum, nil
+	}
+
+	count1, checksum1, err := sum(s.sources)
+	if err != nil {
+		return false, err
+	}
+	count2, checksum2, err := sum(s.targets)
+	if err != nil {
+		return false, err
+	}
+	return count1 == count2 && checksum1 == checksum2, nil
+}
+
+// where returns the WHERE clause of the primary key range (lower, upper], nil means unbounded.
+func (s *chunkSync) where(lower []sqltypes.Value, upper []sqltypes.Value) string {
+	var conds []string
+	if lower != nil {
+		conds = append(conds, s.compare(">", lower))
+	}
+	if upper != nil {
+		conds = append(conds, s.compare("<=", upper))
+	}
+	if len(conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conds, " AND ")
+}
+
+// compare returns the comparison of the primary key with the values,
+// the composite key is compared as a row.
+func (s *chunkSync) compare(op string, vals []sqltypes.Value) string {
+	buf := bytes.NewBuffer(make([]byte, 0, 64))
+	if len(vals) == 1 {
+		fmt.Fprintf(buf, "%s %s ", s.keys[0], op)
+		vals[0].EncodeSQL(buf)
+		return buf.String()
+	}
+	fmt.Fprintf(buf, "(%s) %s (", strings.Join(s.keys, ","), op)
+	for i, v := range vals {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		v.EncodeSQL(buf)
+	}
+	buf.WriteString(")")
+	return buf.String()
+}
@@ -10,17 +10,24 @@ package proxy
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"plugins/autoincrement"
 	"router"
 
+	"github.com/pkg/errors"
 	"github.com/xelabs/go-mysqlstack/driver"
 	"github.com/xelabs/go-mysqlstack/sqldb"
 	"github.com/xelabs/go-mysqlstack/sqlparser"
 	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
 )
 
+const (
+	// backendSizeQuery is used to get the data size of the backend in bytes.
+	backendSizeQuery = "SELECT SUM(data_length + index_length) FROM information_schema.tables"
+)
+
 var (
 	supportEngines = []string{
 		"innodb",
@@ -38,43 +45,66 @@ func CheckCreateTable(ddl *sqlparser.DDL) error {
 	}
 
 	// when shardtype is HASH,check shard key and UNIQUE/PRIMARY KEY constraint.
+	// The composite shard key has more than one column, such as 'a,b'.
 	if shardKey != "" {
-		shardKeyOK := false
+		keys := router.ShardKeys(shardKey)
 		constraintCheckOK := true
 		// shardKey check and constraint check in column definition
-		for _, col := range ddl.TableSpec.Columns {
-			colName := col.Name.String()
-			if colName == shardKey {
-				shardKeyOK = true
-			} else {
-				switch col.Type.KeyOpt {
-				case sqlparser.ColKeyUnique, sqlparser.ColKeyUniqueKey, sqlparser.ColKeyPrimary, sqlparser.ColKey:
-					constraintCheckOK = false
+		for _, key := range keys {
+			shardKeyOK := false
+			for _, col := range ddl.TableSpec.Columns {
+				if col.Name.String() == key {
+					shardKeyOK = true
+					break
 				}
 			}
+			if !shardKeyOK {
+				return fmt.Errorf("Sharding Key column '%s' doesn't exist in table", key)
+			}
 		}
-
-		if !shardKeyOK {
-			return fmt.Errorf("Sharding Key column '%s' doesn't exist in table", shardKey)
+		// The time partition key must be a date/time column.
+		switch strings.ToLower(ddl.PartitionType) {
+		case sqlparser.PartitionMonthStr, sqlparser.PartitionDayStr:
+			for _, col := range ddl.TableSpec.Columns {
+				if col.Name.String() != shardKey {
+					continue
+				}
+				switch strings.ToLower(col.Type.Type) {
+				case "date", "datetime", "timestamp":
+				default:
+					return fmt.Errorf("Sharding Key column '%s' must be DATE/DATETIME/TIMESTAMP for the time partition", shardKey)
+				}
+			}
+		}
+		for _, col := range ddl.TableSpec.Columns {
+			colName := col.Name.String()
+			if len(keys) == 1 && colName == keys[0] {
+				continue
+			}
+			switch col.Type.KeyOpt {
+			case sqlparser.ColKeyUnique, sqlparser.ColKeyUniqueKey, sqlparser.ColKeyPrimary, sqlparser.ColKey:
+				constraintCheckOK = false
+			}
 		}
 		if !constraintCheckOK {
 			return fmt.Errorf("The unique/primary constraint should be only defined on the sharding key column[%s]", shardKey)
 		}
 
-		// constraint check in index definition
+		// constraint check in index definition, the index must contain all the shard key columns.
 		for _, index := range ddl.TableSpec.Indexes {
-			constraintCheckOK = false
 			info := index.Info
 			if info.Unique || info.Primary {
-				for _, colIdx := range index.Columns {
-					colName := colIdx.Column.String()
-					if colName == shardKey {
-						constraintCheckOK = true
-						break
+				for _, key := range keys {
+					constraintCheckOK = false
+					for _, colIdx := range index.Columns {
+						if colIdx.Column.String() == key {
+							constraintCheckOK = true
+							break
+						}
+					}
+					if !constraintCheckOK {
+						return fmt.Errorf("The unique/primary constraint should be only defined on the sharding key column[%s]", shardKey)
 					}
-				}
-				if !constraintCheckOK {
-					return fmt.Errorf("The unique/primary constraint should be only defined on the sharding key column[%s]", shardKey)
 				}
 			}
 		}
@@ -116,11 +146,61 @@ func checkTableExists(database string, table string, router *router.Router) bool
 	return false
 }
 
+// smallestBackend returns the backend with the smallest data size.
+func (spanner *Spanner) smallestBackend(backends []string) (string, error) {
+	var min string
+	var minSize float64
+	for _, backend := range backends {
+		qr, err := spanner.ExecuteOnThisBackend(backend, backendSizeQuery)
+		if err != nil {
+			return "", err
+		}
+		var size float64
+		if len(qr.Rows) > 0 && !qr.Rows[0][0].IsNull() {
+			if size, err = strconv.ParseFloat(qr.Rows[0][0].String(), 64); err != nil {
+				return "", err
+			}
+		}
+		if min == "" || size < minSize {
+			min, minSize = backend, size
+		}
+	}
+	if min == "" {
+		return "", errors.New("spanner.ddl.backends.is.null")
+	}
+	return min, nil
+}
+
+// shardKeyTypes returns the column types of the shard key columns.
+func shardKeyTypes(ddl *sqlparser.DDL) []string {
+	var types []string
+	for _, key := range router.ShardKeys(ddl.PartitionName) {
+		for _, col := range ddl.TableSpec.Columns {
+			if col.Name.String() == key {
+				types = append(types, strings.ToLower(col.Type.Type))
+				break
+			}
+		}
+	}
+	return types
+}
+
+// createPartitionQuery rewrites the 'SHOW CREATE TABLE' result of the partition 'from'
+// to create the new partition 'to' with the same definition, such as:
+// CREATE TABLE `t_0000` (...) --> CREATE TABLE [IF NOT EXISTS] `db`.`t_0001` (...)
+func createPartitionQuery(create, db, from, to string, ifNotExists bool) string {
+	query := strings.Replace(create, fmt.Sprintf("`%s`", from), fmt.Sprintf("`%s`.`%s`", db, to), 1)
+	if !ifNotExists {
+		return query
+	}
+	return strings.Replace(query, "CREATE TABLE", "CREATE TABLE IF NOT EXISTS", 1)
+}
+
 // handleDDL used to handle the DDL command.
 // Here we need to deal with database.table grammar.
 // Supports:
 // 1. CREATE/DROP DATABASE
-// 2. CREATE/DROP TABLE ... PARTITION BY HASH(shardkey)
+// 2. CREATE/DROP TABLE ... PARTITION BY HASH|RANGE|LIST|MONTH|DAY(shardkey) (partition definitions)
 // 3. CREATE/DROP INDEX ON TABLE(columns...)
 // 4. ALTER TABLE .. ENGINE=xx
 // 5. ALTER TABLE .. ADD COLUMN (column definition)
@@ -131,6 +211,9 @@ func (spanner *Spanner) handleDDL(session *driver.Session, query string, node *s
 	route := spanner.router
 	scatter := spanner.scatter
 
+	// The cached plans may refer to the changed tables.
+	defer spanner.clearPlans()
+
 	ddl := node
 	database := session.Schema()
 	// Database operation.
@@ -192,7 +275,22 @@ func (spanner *Spanner) handleDDL(session *driver.Session, query string, node *s
 
 		// Create table.
 		extra := &router.Extra{
-			AutoIncrement: autoincrement.GetAutoIncrement(node),
+			AutoIncrement:    autoincrement.GetAutoIncrement(node),
+			PartitionType:    ddl.PartitionType,
+			PartitionOptions: ddl.PartitionOptions,
+			TableGroup:       ddl.TableGroup,
+			Backend:          ddl.PartitionBackend,
+			HashFunc:         ddl.HashFunc,
+			ShardKeyTypes:    shardKeyTypes(ddl),
+			Backfill:         ddl.PartitionBackfill,
+		}
+		// The SINGLE table is placed on the smallest backend if it's not chosen.
+		if strings.ToLower(ddl.PartitionType) == sqlparser.PartitionSingleStr && extra.Backend == "" {
+			backend, err := spanner.smallestBackend(backends)
+			if err != nil {
+				return nil, err
+			}
+			extra.Backend = backend
 		}
 		if err := route.CreateTable(database, table, shardKey, backends, extra); err != nil {
 			return nil, err
@@ -11,12 +11,14 @@ package proxy
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"testing"
 
 	"fakedb"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/xelabs/go-mysqlstack/driver"
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
 	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
 	"github.com/xelabs/go-mysqlstack/xlog"
 )
@@ -553,6 +555,10 @@ func TestProxyDDLCreateTable(t *testing.T) {
 		"create table t4(a int, b int)engine=tokudb PARTITION  BY hash(a)  ",
 		"create table t5(a int, b int) default charset=utf8  PARTITION  BY hash(a)  ",
 		"create table t6(a int, b int)engine=tokudb default charset=utf8  PARTITION  BY hash(a)  ",
+		"create table t7(a int, b int) partition by range(a) (partition backend0 values less than (10), partition backend1 values less than maxvalue)",
+		"create table t8(a int, b date) partition by range(b) (partition backend1 values less than ('2019-01-01'), partition backend2 values less than ('2019-02-01'))",
+		"create table t9(a int, b varchar(10)) partition by list(b) (partition backend0 values in ('cn', 'hk'), partition backend1 values in ('us'), partition backend2 default)",
+		"create table t10(a int, b varchar(10)) partition by list(b) (partition backend0 values in ('01'), partition backend1 values in ('1'))",
 	}
 
 	for _, query := range querys {
@@ -561,6 +567,18 @@ func TestProxyDDLCreateTable(t *testing.T) {
 		_, err = client.FetchAll(query, -1)
 		assert.Nil(t, err)
 	}
+
+	// The shard key column types.
+	{
+		route := proxy.Router()
+		conf, err := route.TableConfig("test", "t9")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"varchar"}, conf.ShardKeyTypes)
+
+		conf, err = route.TableConfig("test", "t1")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"int"}, conf.ShardKeyTypes)
+	}
 }
 
 func TestProxyDDLCreateTableError(t *testing.T) {
@@ -587,10 +605,16 @@ func TestProxyDDLCreateTableError(t *testing.T) {
 	querys := []string{
 		"create table t2(a int, partition int) PARTiITION BY hash(a)",
 		"create table dual(a int) partition by hash(a)",
+		"create table t3(a int, b int) partition by range(a)",
+		"create table t4(a int, b int) partition by range(a) (partition backendx values less than maxvalue)",
+		"create table t5(a int, b int) partition by list(a) (partition backend0 values in (1), partition backend1 values in (1))",
 	}
 	results := []string{
 		"You have an error in your SQL syntax; check the manual that corresponds to your MySQL server version for the right syntax to use, syntax error at position 33 near 'partition' (errno 1149) (sqlstate 42000)",
 		"spanner.ddl.check.create.table[dual].error:not support (errno 1105) (sqlstate HY000)",
+		"router.compute.range.partitions.is.null (errno 1105) (sqlstate HY000)",
+		"router.compute.range.backend[backendx].can.not.be.found (errno 1105) (sqlstate HY000)",
+		"list.partition[t5_0001].value[1].duplicate.with[t5_0000] (errno 1105) (sqlstate HY000)",
 	}
 
 	for i, query := range querys {
@@ -824,6 +848,225 @@ func TestProxyDDLShardKeyCheck(t *testing.T) {
 	}
 }
 
+func TestProxyDDLCompositeShardKeyCheck(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	fakedbs, proxy, cleanup := MockProxy(log)
+	defer cleanup()
+	address := proxy.Address()
+
+	// fakedbs.
+	{
+		fakedbs.AddQueryPattern("use .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("create .*", &sqltypes.Result{})
+	}
+
+	// create database.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "create database test"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	querys := []string{
+		"CREATE TABLE t1(a int, b int, c int, primary key(a, b)) PARTITION BY HASH(a, b)",
+		"CREATE TABLE t2(a int, b int, c int, unique key uk(c, b, a)) PARTITION BY HASH(a, `b`)",
+		"CREATE TABLE t3(a int, b int) PARTITION BY HASH(a, c)",
+		"CREATE TABLE t4(a int primary key, b int) PARTITION BY HASH(a, b)",
+		"CREATE TABLE t5(a int, b int, primary key(a)) PARTITION BY HASH(a, b)",
+		"CREATE TABLE t6(a int, b int) PARTITION BY RANGE(a, b) (PARTITION backend0 VALUES LESS THAN MAXVALUE)",
+	}
+
+	results := []string{
+		"",
+		"",
+		"Sharding Key column 'c' doesn't exist in table (errno 1105) (sqlstate HY000)",
+		"The unique/primary constraint should be only defined on the sharding key column[a,b] (errno 1105) (sqlstate HY000)",
+		"The unique/primary constraint should be only defined on the sharding key column[a,b] (errno 1105) (sqlstate HY000)",
+		"router.compute.range.shardkey[a,b].must.be.single.column (errno 1105) (sqlstate HY000)",
+	}
+
+	for i, query := range querys {
+		client, err := driver.NewConn("mock", "mock", address, "test", "utf8")
+		assert.Nil(t, err)
+		_, err = client.FetchAll(query, -1)
+		if results[i] == "" {
+			assert.Nil(t, err)
+		} else {
+			assert.Equal(t, results[i], err.Error())
+		}
+		client.Close()
+	}
+}
+
+func TestProxyDDLTimePartition(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	fakedbs, proxy, cleanup := MockProxy(log)
+	defer cleanup()
+	address := proxy.Address()
+
+	// fakedbs.
+	{
+		fakedbs.AddQueryPattern("use .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("create .*", &sqltypes.Result{})
+	}
+
+	// create database.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "create database test"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	querys := []string{
+		"CREATE TABLE t1(id int, dt datetime) PARTITION BY MONTH(dt)",
+		"CREATE TABLE t2(id int, dt date) PARTITION BY DAY(dt)",
+		"CREATE TABLE t3(id int, dt timestamp) PARTITION BY MONTH(dt)",
+		"CREATE TABLE t4(id int, dt varchar(20)) PARTITION BY MONTH(dt)",
+		"CREATE TABLE t5(id int, dt date) PARTITION BY DAY(dt, id)",
+	}
+
+	results := []string{
+		"",
+		"",
+		"",
+		"Sharding Key column 'dt' must be DATE/DATETIME/TIMESTAMP for the time partition (errno 1105) (sqlstate HY000)",
+		"router.compute.time.shardkey[dt,id].must.be.single.column (errno 1105) (sqlstate HY000)",
+	}
+
+	for i, query := range querys {
+		client, err := driver.NewConn("mock", "mock", address, "test", "utf8")
+		assert.Nil(t, err)
+		_, err = client.FetchAll(query, -1)
+		if results[i] == "" {
+			assert.Nil(t, err)
+		} else {
+			assert.Equal(t, results[i], err.Error())
+		}
+		client.Close()
+	}
+
+	route := proxy.Router()
+	segments, err := route.Lookup("test", "t2", nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 8, len(segments))
+}
+
+func TestProxyDDLTableGroup(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	fakedbs, proxy, cleanup := MockProxy(log)
+	defer cleanup()
+	address := proxy.Address()
+
+	// fakedbs.
+	{
+		fakedbs.AddQueryPattern("use .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("create .*", &sqltypes.Result{})
+	}
+
+	// create database.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "create database test"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	querys := []string{
+		"CREATE TABLE t1(id int, b int) PARTITION BY HASH(id) TABLEGROUP g1",
+		"CREATE TABLE t2(uid int, b int) PARTITION BY HASH(uid) TABLEGROUP = g1",
+		"CREATE TABLE t3(id int, b int) PARTITION BY HASH(id, b) TABLEGROUP g1",
+	}
+
+	results := []string{
+		"",
+		"",
+		"router.compute.tablegroup[g1].shardkey[id,b].columns.must.be.same.as[id] (errno 1105) (sqlstate HY000)",
+	}
+
+	for i, query := range querys {
+		client, err := driver.NewConn("mock", "mock", address, "test", "utf8")
+		assert.Nil(t, err)
+		_, err = client.FetchAll(query, -1)
+		if results[i] == "" {
+			assert.Nil(t, err)
+		} else {
+			assert.Equal(t, results[i], err.Error())
+		}
+		client.Close()
+	}
+
+	route := proxy.Router()
+	t1, err := route.TableConfig("test", "t1")
+	assert.Nil(t, err)
+	t2, err := route.TableConfig("test", "t2")
+	assert.Nil(t, err)
+	assert.Equal(t, "g1", t2.TableGroup)
+	assert.Equal(t, len(t1.Partitions), len(t2.Partitions))
+	for i, part := range t1.Partitions {
+		assert.Equal(t, part.Segment, t2.Partitions[i].Segment)
+		assert.Equal(t, part.Backend, t2.Partitions[i].Backend)
+	}
+}
+
+func TestProxyDDLHashFunc(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	fakedbs, proxy, cleanup := MockProxy(log)
+	defer cleanup()
+	address := proxy.Address()
+
+	// fakedbs.
+	{
+		fakedbs.AddQueryPattern("use .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("create .*", &sqltypes.Result{})
+	}
+
+	// create database.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "create database test"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	querys := []string{
+		"CREATE TABLE t1(id int, b int) PARTITION BY HASH(id) HASHFUNC crc32",
+		"CREATE TABLE t2(id int, b int) PARTITION BY HASH(id) HASHFUNC = md5",
+		"CREATE TABLE t3(id int, b int) PARTITION BY RANGE(id) (PARTITION backend1 VALUES LESS THAN (10)) HASHFUNC mod",
+	}
+
+	results := []string{
+		"",
+		"hash.func[md5].unsupported (errno 1105) (sqlstate HY000)",
+		"You have an error in your SQL syntax; check the manual that corresponds to your MySQL server version for the right syntax to use, syntax error at position 106 near 'HASHFUNC' (errno 1149) (sqlstate 42000)",
+	}
+
+	for i, query := range querys {
+		client, err := driver.NewConn("mock", "mock", address, "test", "utf8")
+		assert.Nil(t, err)
+		_, err = client.FetchAll(query, -1)
+		if results[i] == "" {
+			assert.Nil(t, err)
+		} else {
+			assert.Equal(t, results[i], err.Error())
+		}
+		client.Close()
+	}
+
+	route := proxy.Router()
+	t1, err := route.TableConfig("test", "t1")
+	assert.Nil(t, err)
+	assert.Equal(t, "crc32", t1.HashFunc)
+	// The hash function is the option of the HASH method only.
+	_, err = route.TableConfig("test", "t3")
+	assert.NotNil(t, err)
+}
+
 func TestProxyDDLAlterCharset(t *testing.T) {
 	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
 	fakedbs, proxy, cleanup := MockProxy(log)
@@ -893,3 +1136,94 @@ func TestProxyDDLUnknowDatabase236(t *testing.T) {
 	_, err = client.FetchAll(query, -1)
 	assert.Nil(t, err)
 }
+
+func TestProxyDDLSingle(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	fakedbs, proxy, cleanup := MockProxy(log)
+	defer cleanup()
+	address := proxy.Address()
+
+	mockSize := func(size string) *sqltypes.Result {
+		return &sqltypes.Result{
+			Fields: []*querypb.Field{
+				{
+					Name: "size",
+					Type: querypb.Type_DECIMAL,
+				},
+			},
+			Rows: [][]sqltypes.Value{
+				{
+					sqltypes.MakeTrusted(querypb.Type_DECIMAL, []byte(size)),
+				},
+			},
+		}
+	}
+
+	// fakedbs.
+	{
+		fakedbs.AddQueryPattern("use .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("create .*", &sqltypes.Result{})
+		fakedbs.AddQuerys(strings.ToLower(backendSizeQuery), mockSize("300"), mockSize("200"), mockSize("100"), mockSize("400"), mockSize("500"))
+	}
+
+	// create database.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "create database test"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	querys := []string{
+		"CREATE TABLE t1(id int, b int, primary key(b)) PARTITION BY SINGLE ON backend3",
+		"CREATE TABLE t2(id int, b int) PARTITION BY SINGLE",
+		"CREATE TABLE t3(id int, b int) PARTITION BY SINGLE ON backend9",
+	}
+
+	results := []string{
+		"",
+		"",
+		"router.compute.single.backend[backend9].cant.found (errno 1105) (sqlstate HY000)",
+	}
+
+	for i, query := range querys {
+		client, err := driver.NewConn("mock", "mock", address, "test", "utf8")
+		assert.Nil(t, err)
+		_, err = client.FetchAll(query, -1)
+		if results[i] == "" {
+			assert.Nil(t, err)
+		} else {
+			assert.Equal(t, results[i], err.Error())
+		}
+		client.Close()
+	}
+
+	route := proxy.Router()
+	tests := []struct {
+		table   string
+		backend string
+	}{
+		{"t1", "backend3"},
+		// The smallest backend.
+		{"t2", "backend2"},
+	}
+	for _, test := range tests {
+		segments, err := route.Lookup("test", test.table, nil, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(segments))
+		assert.Equal(t, test.table, segments[0].Table)
+		assert.Equal(t, test.backend, segments[0].Backend)
+	}
+}
+
+func TestCreatePartitionQuery(t *testing.T) {
+	create := "CREATE TABLE `t_201901` (\n  `dt` datetime DEFAULT NULL\n) ENGINE=InnoDB"
+	want := "CREATE TABLE IF NOT EXISTS `db`.`t_201902` (\n  `dt` datetime DEFAULT NULL\n) ENGINE=InnoDB"
+	got := createPartitionQuery(create, "db", "t_201901", "t_201902", true)
+	assert.Equal(t, want, got)
+
+	want = "CREATE TABLE `db`.`t_201902` (\n  `dt` datetime DEFAULT NULL\n) ENGINE=InnoDB"
+	got = createPartitionQuery(create, "db", "t_201901", "t_201902", false)
+	assert.Equal(t, want, got)
+}
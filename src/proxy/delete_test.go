@@ -15,8 +15,10 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/xelabs/go-mysqlstack/driver"
-	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
 	"github.com/xelabs/go-mysqlstack/xlog"
+
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
 )
 
 func TestProxyDelete(t *testing.T) {
@@ -59,3 +61,53 @@ func TestProxyDelete(t *testing.T) {
 		assert.Nil(t, err)
 	}
 }
+
+func TestProxyDeleteJoin(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	fakedbs, proxy, cleanup := MockProxy(log)
+	defer cleanup()
+	address := proxy.Address()
+	proxy.SetTwoPC(true)
+
+	ids := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "id", Type: querypb.Type_INT32},
+		},
+		Rows: [][]sqltypes.Value{
+			{sqltypes.MakeTrusted(querypb.Type_INT32, []byte("1"))},
+		},
+	}
+
+	// fakedbs.
+	{
+		fakedbs.AddQueryPattern("XA .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("create .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("select t1.id from test.t1_.*", ids)
+		fakedbs.AddQueryPattern("select t2.b from test.t2_.*", &sqltypes.Result{Fields: []*querypb.Field{{Name: "b", Type: querypb.Type_INT32}}, Rows: ids.Rows})
+		fakedbs.AddQueryPattern("delete from test.t2_.* where b = 1", &sqltypes.Result{RowsAffected: 1})
+	}
+
+	// create database and tables.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		querys := []string{
+			"create database test",
+			"create table test.t1(id int, b int) partition by hash(id)",
+			"create table test.t2(id int, b int) partition by hash(id)",
+		}
+		for _, query := range querys {
+			_, err = client.FetchAll(query, -1)
+			assert.Nil(t, err)
+		}
+	}
+
+	// The join isn't on the shard key, t2 is deleted by the joined rows.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "delete t2 from test.t1 join test.t2 on t1.id = t2.b where t1.id = 1"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+}
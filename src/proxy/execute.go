@@ -10,9 +10,7 @@ package proxy
 
 import (
 	"executor"
-	"optimizer"
 	"planner"
-	"xcontext"
 
 	"github.com/pkg/errors"
 	"github.com/xelabs/go-mysqlstack/driver"
@@ -23,13 +21,12 @@ import (
 // ExecuteMultiStmtsInTxn used to execute multiple statements in the transaction.
 func (spanner *Spanner) ExecuteMultiStmtsInTxn(session *driver.Session, database string, query string, node sqlparser.Statement) (*sqltypes.Result, error) {
 	log := spanner.log
-	router := spanner.router
 	sessions := spanner.sessions
 	txSession := sessions.getTxnSession(session)
 
 	sessions.MultiStmtTxnBinding(session, nil, node, query)
 
-	plans, err := optimizer.NewSimpleOptimizer(log, database, query, node, router).BuildPlanTree()
+	plans, err := spanner.newOptimizer(database, query, node).BuildPlanTree()
 	if err != nil {
 		return nil, err
 	}
@@ -48,7 +45,6 @@ func (spanner *Spanner) ExecuteMultiStmtsInTxn(session *driver.Session, database
 func (spanner *Spanner) ExecuteSingleStmtTxnTwoPC(session *driver.Session, database string, query string, node sqlparser.Statement) (*sqltypes.Result, error) {
 	log := spanner.log
 	conf := spanner.conf
-	router := spanner.router
 	scatter := spanner.scatter
 	sessions := spanner.sessions
 
@@ -63,6 +59,7 @@ func (spanner *Spanner) ExecuteSingleStmtTxnTwoPC(session *driver.Session, datab
 	// txn limits.
 	txn.SetTimeout(conf.Proxy.QueryTimeout)
 	txn.SetMaxResult(conf.Proxy.MaxResultSize)
+	txn.SetSpill(conf.Proxy.SpillDir, conf.Proxy.SpillMemorySize)
 
 	// binding.
 	sessions.TxnBinding(session, txn, node, query)
@@ -75,7 +72,7 @@ func (spanner *Spanner) ExecuteSingleStmtTxnTwoPC(session *driver.Session, datab
 	}
 
 	// Transaction execute.
-	plans, err := optimizer.NewSimpleOptimizer(log, database, query, node, router).BuildPlanTree()
+	plans, err := spanner.newOptimizer(database, query, node).BuildPlanTree()
 	if err != nil {
 		return nil, err
 	}
@@ -123,7 +120,6 @@ func (spanner *Spanner) ExecuteDDL(session *driver.Session, database string, que
 func (spanner *Spanner) executeWithTimeout(session *driver.Session, database string, query string, node sqlparser.Statement, timeout int) (*sqltypes.Result, error) {
 	log := spanner.log
 	conf := spanner.conf
-	router := spanner.router
 	scatter := spanner.scatter
 	sessions := spanner.sessions
 
@@ -138,12 +134,13 @@ func (spanner *Spanner) executeWithTimeout(session *driver.Session, database str
 	// txn limits.
 	txn.SetTimeout(timeout)
 	txn.SetMaxResult(conf.Proxy.MaxResultSize)
+	txn.SetSpill(conf.Proxy.SpillDir, conf.Proxy.SpillMemorySize)
 
 	// binding.
 	sessions.TxnBinding(session, txn, node, query)
 	defer sessions.TxnUnBinding(session)
 
-	plans, err := optimizer.NewSimpleOptimizer(log, database, query, node, router).BuildPlanTree()
+	plans, err := spanner.newOptimizer(database, query, node).BuildPlanTree()
 	if err != nil {
 		return nil, err
 	}
@@ -174,7 +171,7 @@ func (spanner *Spanner) ExecuteStreamFetch(session *driver.Session, database str
 	sessions.TxnBinding(session, txn, node, query)
 	defer sessions.TxnUnBinding(session)
 
-	selectNode, ok := node.(*sqlparser.Select)
+	selectNode, ok := node.(sqlparser.SelectStatement)
 	if !ok {
 		return errors.New("ExecuteStreamFetch.only.support.select")
 	}
@@ -183,15 +180,14 @@ func (spanner *Spanner) ExecuteStreamFetch(session *driver.Session, database str
 	if err := plan.Build(); err != nil {
 		return err
 	}
+	if err := executor.Materialize(log, plan, txn); err != nil {
+		return err
+	}
 	if _, ok := plan.Root.(*planner.MergeNode); !ok {
 		return errors.New("ExecuteStreamFetch.unsupport.cross-shard.join")
 	}
-	reqCtx := xcontext.NewRequestContext()
-	reqCtx.Mode = plan.ReqMode
-	reqCtx.Querys = plan.Root.GetQuery()
-	reqCtx.RawQuery = plan.RawQuery
 	streamBufferSize := spanner.conf.Proxy.StreamBufferSize
-	return txn.ExecuteStreamFetch(reqCtx, callback, streamBufferSize)
+	return executor.NewStreamExecutor(log, plan, txn).Execute(callback, streamBufferSize)
 }
 
 // ExecuteDML used to execute some DML querys to shards.
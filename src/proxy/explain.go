@@ -12,8 +12,6 @@ import (
 	"fmt"
 	"regexp"
 
-	"optimizer"
-
 	"github.com/pkg/errors"
 	"github.com/xelabs/go-mysqlstack/driver"
 	"github.com/xelabs/go-mysqlstack/sqldb"
@@ -26,7 +24,6 @@ import (
 func (spanner *Spanner) handleExplain(session *driver.Session, query string, node sqlparser.Statement) (*sqltypes.Result, error) {
 	log := spanner.log
 	database := session.Schema()
-	router := spanner.router
 	qr := &sqltypes.Result{}
 	qr.Fields = []*querypb.Field{
 		{Name: "EXPLAIN", Type: querypb.Type_VARCHAR},
@@ -53,6 +50,7 @@ func (spanner *Spanner) handleExplain(session *driver.Session, query string, nod
 	// see https://dev.mysql.com/doc/refman/5.7/en/explain.html
 	switch subNode.(type) {
 	case *sqlparser.Select:
+	case *sqlparser.Union:
 	case *sqlparser.Delete:
 	case *sqlparser.Insert:
 		autoincPlug := spanner.plugins.PlugAutoIncrement()
@@ -65,8 +63,7 @@ func (spanner *Spanner) handleExplain(session *driver.Session, query string, nod
 		return nil, sqldb.NewSQLError(sqldb.ER_SYNTAX_ERROR, "explain only supports SELECT/DELETE/INSERT/UPDATE")
 	}
 
-	simOptimizer := optimizer.NewSimpleOptimizer(log, database, cutQuery, subNode, router)
-	planTree, err := simOptimizer.BuildPlanTree()
+	planTree, err := spanner.newOptimizer(database, cutQuery, subNode).BuildPlanTree()
 	if err != nil {
 		log.Error("proxy.explain.error:%+v", err)
 		msg := fmt.Sprintf("unsupported: cannot.explain.the.query:%s", cutQuery)
@@ -9,10 +9,12 @@
 package proxy
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/xelabs/go-mysqlstack/driver"
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
 	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
 	"github.com/xelabs/go-mysqlstack/xlog"
 )
@@ -234,6 +236,70 @@ func TestProxyExplain(t *testing.T) {
 	}
 }
 
+func TestProxyExplainCostOptimizer(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	fakedbs, proxy, cleanup := MockProxy(log)
+	defer cleanup()
+	address := proxy.Address()
+
+	// fakedbs.
+	{
+		fakedbs.AddQueryPattern("use .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("create .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("SELECT TABLE_ROWS .*", &sqltypes.Result{
+			Fields: []*querypb.Field{
+				{Name: "TABLE_ROWS", Type: querypb.Type_UINT64},
+			},
+			Rows: [][]sqltypes.Value{
+				{sqltypes.MakeTrusted(querypb.Type_UINT64, []byte("1000"))},
+			},
+		})
+		fakedbs.AddQueryPattern("SELECT COLUMN_NAME, CARDINALITY .*", &sqltypes.Result{
+			Fields: []*querypb.Field{
+				{Name: "COLUMN_NAME", Type: querypb.Type_VARCHAR},
+				{Name: "CARDINALITY", Type: querypb.Type_UINT64},
+			},
+		})
+	}
+
+	// create database and tables.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		querys := []string{
+			"create database test",
+			"create table test.t1(id int, b int) partition by hash(id)",
+			"create table test.t2(id int, b int) partition by hash(id)",
+			"create table test.t3(a int, b int) partition by hash(a)",
+		}
+		for _, query := range querys {
+			_, err = client.FetchAll(query, -1)
+			assert.Nil(t, err)
+		}
+		client.Quit()
+	}
+
+	// t1 and t2 are joined in the backends by the cost optimizer.
+	query := "explain select t1.b, t2.b, t3.b from t1 join t3 on t1.b=t3.a join t2 on t2.id=t1.id"
+	for _, tcase := range []struct {
+		optimizer string
+		querys    int
+	}{
+		{"simple", 90},
+		{"cost", 60},
+	} {
+		err := proxy.SetOptimizer(tcase.optimizer)
+		assert.Nil(t, err)
+		client, err := driver.NewConn("mock", "mock", address, "test", "utf8")
+		assert.Nil(t, err)
+		qr, err := client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		got := string(qr.Rows[0][0].Raw())
+		assert.Equal(t, tcase.querys, strings.Count(got, `"Query"`), got)
+		client.Quit()
+	}
+}
+
 func TestProxyExplainError(t *testing.T) {
 	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
 	fakedbs, proxy, cleanup := MockProxy(log)
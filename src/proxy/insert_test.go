@@ -15,6 +15,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/xelabs/go-mysqlstack/driver"
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
 	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
 	"github.com/xelabs/go-mysqlstack/xlog"
 )
@@ -112,6 +113,72 @@ func TestProxyInsertQuerys(t *testing.T) {
 	}
 }
 
+func TestProxyInsertSelect(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	fakedbs, proxy, cleanup := MockProxy(log)
+	defer cleanup()
+	address := proxy.Address()
+	proxy.SetTwoPC(true)
+
+	rows := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "id", Type: querypb.Type_INT32},
+			{Name: "b", Type: querypb.Type_INT32},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("1")),
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("2")),
+			},
+		},
+	}
+
+	// fakedbs.
+	{
+		fakedbs.AddQueryPattern("XA .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("create .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("select id, b from test.g", rows)
+		fakedbs.AddQueryPattern("insert into test.t1_.* values .*", &sqltypes.Result{RowsAffected: 1})
+		fakedbs.AddQueryPattern("insert into test.t2_.* select .*", &sqltypes.Result{RowsAffected: 1})
+	}
+
+	// create database and tables.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		querys := []string{
+			"create database test",
+			"create table test.t1(id int, b int) partition by hash(id)",
+			"create table test.t2(id int, b int) partition by hash(id)",
+			"create table test.g(id int, b int)",
+		}
+		for _, query := range querys {
+			_, err = client.FetchAll(query, -1)
+			assert.Nil(t, err)
+		}
+	}
+
+	// The rows are routed by the proxy.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "insert into test.t1(id, b) select id, b from test.g"
+		qr, err := client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(1), qr.RowsAffected)
+	}
+
+	// The select is pushed down to the partition.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "insert into test.t2(id, b) select id, b from test.t1 where id = 1"
+		qr, err := client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(1), qr.RowsAffected)
+	}
+}
+
 func TestProxyLongTimeQuerys(t *testing.T) {
 	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
 	fakedbs, proxy, cleanup := MockProxy(log)
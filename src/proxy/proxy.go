@@ -104,7 +104,7 @@ func (p *Proxy) Start() {
 		log.Panic("proxy.plugins.init.panic:%+v", err)
 	}
 
-	spanner := NewSpanner(log, conf, iptable, router, scatter, sessions, audit, throttle, plugins, serverVersion)
+	spanner := NewSpanner(log, conf, iptable, router, scatter, syncer, sessions, audit, throttle, plugins, serverVersion)
 	if err := spanner.Init(); err != nil {
 		log.Panic("proxy.spanner.init.panic:%+v", err)
 	}
@@ -270,6 +270,18 @@ func (p *Proxy) SetThrottle(val int) {
 	p.throttle.Set(val)
 }
 
+// SetOptimizer used to set the optimizer, simple or cost.
+func (p *Proxy) SetOptimizer(optimizer string) error {
+	if err := config.CheckOptimizer(optimizer); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.log.Info("proxy.SetOptimizer:[%s->%s]", p.conf.Proxy.Optimizer, optimizer)
+	p.conf.Proxy.Optimizer = optimizer
+	return nil
+}
+
 // SetStreamBufferSize used to set the streamBufferSize.
 func (p *Proxy) SetStreamBufferSize(streamBufferSize int) {
 	p.mu.Lock()
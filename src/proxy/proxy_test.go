@@ -76,6 +76,16 @@ func TestProxy1(t *testing.T) {
 		assert.Equal(t, 100, proxy.throttle.Limits())
 	}
 
+	// SetOptimizer
+	{
+		err := proxy.SetOptimizer("cost")
+		assert.Nil(t, err)
+		assert.Equal(t, "cost", proxy.conf.Proxy.Optimizer)
+		err = proxy.SetOptimizer("rule")
+		assert.EqualError(t, err, "unsupported: optimizer[rule].must.be.simple.or.cost")
+		assert.Equal(t, "cost", proxy.conf.Proxy.Optimizer)
+	}
+
 	// SetReadOnly
 	{
 		assert.Equal(t, false, proxy.spanner.ReadOnly())
@@ -31,6 +31,82 @@ func returnQuery(qr *sqltypes.Result, callback func(qr *sqltypes.Result) error,
 	return nil
 }
 
+// bindNode used to substitute the bind variables of the ast by the values, the ast is same as
+// the one parsed from the query generated by the bind variables. Returns false if a value can't
+// be substituted, such as the expression value, the ast may be changed partly.
+func bindNode(node sqlparser.Statement, bindVariables map[string]*querypb.BindVariable) bool {
+	toExpr := func(v sqltypes.Value) sqlparser.Expr {
+		switch {
+		case v.IsNull():
+			return &sqlparser.NullVal{}
+		case v.IsIntegral():
+			return sqlparser.NewIntVal(v.Raw())
+		case v.IsFloat(), v.Type() == querypb.Type_DECIMAL:
+			return sqlparser.NewFloatVal(v.Raw())
+		case sqltypes.IsQuoted(v.Type()):
+			return sqlparser.NewStrVal(v.Raw())
+		}
+		return nil
+	}
+
+	ok := true
+	var nulls []*sqlparser.SQLVal
+	_ = sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		switch node := node.(type) {
+		case *sqlparser.SQLVal:
+			if node.Type != sqlparser.ValArg {
+				return true, nil
+			}
+			bv, _, err := sqlparser.FetchBindVar(string(node.Val), bindVariables)
+			if err != nil {
+				ok = false
+				return false, nil
+			}
+			v, err := sqltypes.BindVariableToValue(bv)
+			if err != nil {
+				ok = false
+				return false, nil
+			}
+			switch expr := toExpr(v).(type) {
+			case *sqlparser.SQLVal:
+				*node = *expr
+			case *sqlparser.NullVal:
+				nulls = append(nulls, node)
+			default:
+				ok = false
+			}
+		case *sqlparser.ComparisonExpr:
+			list, isList := node.Right.(sqlparser.ListArg)
+			if !isList {
+				return true, nil
+			}
+			bv, _, err := sqlparser.FetchBindVar(string(list), bindVariables)
+			if err != nil {
+				ok = false
+				return false, nil
+			}
+			tuple := make(sqlparser.ValTuple, 0, len(bv.Values))
+			for _, value := range bv.Values {
+				expr := toExpr(sqltypes.ProtoToValue(value))
+				if expr == nil {
+					ok = false
+					return false, nil
+				}
+				tuple = append(tuple, expr)
+			}
+			node.Right = tuple
+		}
+		return ok, nil
+	}, node)
+
+	for _, val := range nulls {
+		if !ok || !sqlparser.ReplaceExpr(node, val, &sqlparser.NullVal{}) {
+			return false
+		}
+	}
+	return ok
+}
+
 // ComQuery impl.
 // Supports statements are:
 // 1. DDL
@@ -79,11 +155,13 @@ func (spanner *Spanner) ComQuery(session *driver.Session, query string, bindVari
 			return sqldb.NewSQLError(sqldb.ER_SYNTAX_ERROR, err.Error())
 		}
 
-		// This sucks.
-		node, err = sqlparser.Parse(query)
-		if err != nil {
-			log.Error("query[%v].parser.error: %v", query, err)
-			return sqldb.NewSQLError(sqldb.ER_SYNTAX_ERROR, err.Error())
+		// The values are bound into the ast, the query is parsed again only if a value can't be.
+		if !bindNode(node, bindVariables) {
+			node, err = sqlparser.Parse(query)
+			if err != nil {
+				log.Error("query[%v].parser.error: %v", query, err)
+				return sqldb.NewSQLError(sqldb.ER_SYNTAX_ERROR, err.Error())
+			}
 		}
 	}
 
@@ -250,6 +328,20 @@ func (spanner *Spanner) ComQuery(session *driver.Session, query string, bindVari
 				return returnQuery(qr, callback, err)
 			}
 		}
+	case *sqlparser.Union:
+		txSession := spanner.sessions.getTxnSession(session)
+		if txSession.getStreamingFetchVar() {
+			if err = spanner.handleSelectStream(session, query, node, callback); err != nil {
+				log.Error("proxy.union.for.backup:[%s].error:%+v", xbase.TruncateQuery(query, 256), err)
+				return err
+			}
+			return nil
+		}
+		if qr, err = spanner.handleSelect(session, query, node); err != nil {
+			log.Error("proxy.union[%s].from.session[%v].error:%+v", query, session.ID(), err)
+		}
+		spanner.auditLog(session, R, xbase.SELECT, query, qr)
+		return returnQuery(qr, callback, err)
 	case *sqlparser.Kill:
 		if qr, err = spanner.handleKill(session, query, node); err != nil {
 			log.Error("proxy.kill[%s].from.session[%v].error:%+v", query, session.ID(), err)
@@ -296,7 +388,7 @@ func (spanner *Spanner) ComQuery(session *driver.Session, query string, bindVari
 // IsDML returns the DML query or not.
 func (spanner *Spanner) IsDML(node sqlparser.Statement) bool {
 	switch node.(type) {
-	case *sqlparser.Select, *sqlparser.Insert, *sqlparser.Delete, *sqlparser.Update:
+	case *sqlparser.Select, *sqlparser.Union, *sqlparser.Insert, *sqlparser.Delete, *sqlparser.Update:
 		return true
 	}
 	return false
@@ -335,7 +427,7 @@ func queryStat(node sqlparser.Statement, timeStart time.Time, slowQueryTime time
 		command = "Delete"
 	case *sqlparser.Update:
 		command = "Update"
-	case *sqlparser.Select:
+	case *sqlparser.Select, *sqlparser.Union:
 		command = "Select"
 	case *sqlparser.Kill:
 		command = "Kill"
@@ -10,10 +10,12 @@ package proxy
 
 import (
 	"errors"
+	"strconv"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/xelabs/go-mysqlstack/driver"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
 	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
 	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
 	"github.com/xelabs/go-mysqlstack/xlog"
@@ -380,6 +382,56 @@ func TestProxyQueryStmtPrepare(t *testing.T) {
 	}
 }
 
+func TestProxyBindNode(t *testing.T) {
+	bindVariables := map[string]*querypb.BindVariable{
+		"v1": sqltypes.Int64BindVariable(-1),
+		"v2": sqltypes.StringBindVariable("it's"),
+		"v3": sqltypes.Float64BindVariable(1.5),
+		"v4": sqltypes.ValueBindVariable(sqltypes.NULL),
+		"v5": {
+			Type: querypb.Type_TUPLE,
+			Values: []*querypb.Value{
+				{Type: querypb.Type_INT64, Value: []byte("1")},
+				{Type: querypb.Type_VARCHAR, Value: []byte("a")},
+			},
+		},
+		"v6": {Type: querypb.Type_EXPRESSION, Value: []byte("1+1")},
+	}
+	tcases := []struct {
+		query string
+		ok    bool
+	}{
+		{"select a from t where id = :v1 and b = :v2 and c > :v3", true},
+		{"select :v2, a from t where b <=> :v4 or b = :v4", true},
+		{"update t set a = :v4 where b in ::v5", true},
+		{"insert into t(a, b) values (:v1, :v2), (:v3, :v4)", true},
+		{"select a from t where b = :v6", false},
+		{"select a from t where b = :v7", false},
+		{"select a from t where b in ::v1", false},
+	}
+	for _, tcase := range tcases {
+		node, err := sqlparser.Parse(tcase.query)
+		assert.Nil(t, err)
+		ok := bindNode(node, bindVariables)
+		assert.Equal(t, tcase.ok, ok, tcase.query)
+		if !ok {
+			continue
+		}
+
+		query, err := sqlparser.NewParsedQuery(mustParse(t, tcase.query)).GenerateQuery(bindVariables, nil)
+		assert.Nil(t, err)
+		want := mustParse(t, query)
+		assert.Equal(t, sqlparser.String(want), sqlparser.String(node), tcase.query)
+		assert.Empty(t, sqlparser.GetBindvars(node), tcase.query)
+	}
+}
+
+func mustParse(t *testing.T, query string) sqlparser.Statement {
+	node, err := sqlparser.Parse(query)
+	assert.Nil(t, err)
+	return node
+}
+
 // Proxy with system database query.
 // Such as: select * from information_schema.
 func TestProxyQuerySystemDatabase(t *testing.T) {
@@ -549,3 +601,346 @@ func TestLongQuery2(t *testing.T) {
 		}
 	}
 }
+
+func TestProxyQuerySubquery(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	fakedbs, proxy, cleanup := MockProxy(log)
+	defer cleanup()
+	address := proxy.Address()
+
+	ids := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "id",
+				Type: querypb.Type_INT32,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("3")),
+			},
+		},
+	}
+	bs := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "b",
+				Type: querypb.Type_INT32,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("11")),
+			},
+		},
+	}
+
+	// fakedbs.
+	{
+		fakedbs.AddQueryPattern("use .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("create .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("select id from test.t2_[0-9]+ as t2 where b = 1", ids)
+		fakedbs.AddQueryPattern(`select b from test.t1_[0-9]+ as t1 where id in \(3\)`, bs)
+		fakedbs.AddQueryPattern(`update test.t1_[0-9]+ set b = 2 where id in \(3\)`, &sqltypes.Result{RowsAffected: 1})
+	}
+
+	// create database.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "create database test"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	// create test tables.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "test", "utf8")
+		assert.Nil(t, err)
+		for _, query := range []string{
+			"create table t1(id int, b int) partition by hash(id)",
+			"create table t2(id int, b int) partition by hash(id)",
+		} {
+			_, err = client.FetchAll(query, -1)
+			assert.Nil(t, err)
+		}
+		client.Quit()
+	}
+
+	client, err := driver.NewConn("mock", "mock", address, "test", "utf8")
+	assert.Nil(t, err)
+	defer client.Quit()
+
+	// The outer query goes to the partition of the id returned by the subquery.
+	{
+		query := "select b from t1 where id in (select id from t2 where b=1)"
+		qr, err := client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, int(qr.RowsAffected))
+	}
+
+	// Stream fetch.
+	{
+		query := "set @@SESSION.radon_streaming_fetch='ON'"
+		_, err := client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		query = "select b from t1 where id in (select id from t2 where b=1)"
+		qr, err := client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, int(qr.RowsAffected))
+		query = "set @@SESSION.radon_streaming_fetch='OFF'"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	// Update.
+	{
+		query := "update t1 set b=2 where id in (select id from t2 where b=1)"
+		qr, err := client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, int(qr.RowsAffected))
+	}
+
+	// Correlated.
+	{
+		query := "select b from t1 where id in (select id from t2 where t2.b=t1.b)"
+		_, err := client.FetchAll(query, -1)
+		want := "unsupported: correlated.subquery.column[t1.b] (errno 1105) (sqlstate HY000)"
+		assert.Equal(t, want, err.Error())
+	}
+}
+
+func TestProxyQueryDerived(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	fakedbs, proxy, cleanup := MockProxy(log)
+	defer cleanup()
+	address := proxy.Address()
+
+	rows := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "id",
+				Type: querypb.Type_INT32,
+			},
+			{
+				Name: "b",
+				Type: querypb.Type_INT32,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("3")),
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("11")),
+			},
+		},
+	}
+
+	// fakedbs.
+	{
+		fakedbs.AddQueryPattern("use .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("create .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("select id, b from test.t1_[0-9]+ as t1 where b > 10", rows)
+		fakedbs.AddQueryPattern("select id, b from test.t1_[0-9]+ as t1 where b > 5", rows)
+	}
+
+	// create database.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "create database test"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	// create test table.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "test", "utf8")
+		assert.Nil(t, err)
+		query := "create table t1(id int, b int) partition by hash(id)"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		client.Quit()
+	}
+
+	client, err := driver.NewConn("mock", "mock", address, "test", "utf8")
+	assert.Nil(t, err)
+	defer client.Quit()
+
+	// The filter is pushed into the derived table, the aggregation is done in the proxy.
+	{
+		tconf, err := proxy.Router().TableConfig("test", "t1")
+		assert.Nil(t, err)
+		query := "select t.b, count(*) as cnt from (select id, b from t1) as t where t.b>10 group by t.b"
+		qr, err := client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(qr.Rows))
+		assert.Equal(t, strconv.Itoa(len(tconf.Partitions)), qr.Rows[0][1].ToString())
+	}
+
+	// Having, the filter of the group column is pushed into the derived table,
+	// the aggregated one is filtered in the proxy.
+	{
+		tconf, err := proxy.Router().TableConfig("test", "t1")
+		assert.Nil(t, err)
+		query := "select t.b, count(*) as cnt from (select id, b from t1) as t group by t.b having t.b>5 and count(*)>1"
+		qr, err := client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(qr.Rows))
+		assert.Equal(t, strconv.Itoa(len(tconf.Partitions)), qr.Rows[0][1].ToString())
+
+		query = "select t.b, count(*) as cnt from (select id, b from t1) as t group by t.b having t.b>5 and count(*)>10000"
+		qr, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, len(qr.Rows))
+	}
+}
+
+func TestProxyQueryUnion(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	fakedbs, proxy, cleanup := MockProxy(log)
+	defer cleanup()
+	address := proxy.Address()
+
+	rows := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "b",
+				Type: querypb.Type_INT32,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("11")),
+			},
+		},
+	}
+
+	// fakedbs.
+	{
+		fakedbs.AddQueryPattern("use .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("create .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("select b from test.t1_[0-9]+ as t1", rows)
+		fakedbs.AddQueryPattern("select b from test.t1_[0-9]+ as t1 where id = 1 union all select b from test.t1_[0-9]+ as t1 where id = 1", rows)
+	}
+
+	// create database.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "create database test"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	// create test table.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "test", "utf8")
+		assert.Nil(t, err)
+		query := "create table t1(id int, b int) partition by hash(id)"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		client.Quit()
+	}
+
+	client, err := driver.NewConn("mock", "mock", address, "test", "utf8")
+	assert.Nil(t, err)
+	defer client.Quit()
+
+	tconf, err := proxy.Router().TableConfig("test", "t1")
+	assert.Nil(t, err)
+
+	// Union all.
+	{
+		query := "select b from t1 union all select b from t1"
+		qr, err := client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 2*len(tconf.Partitions), len(qr.Rows))
+	}
+
+	// Union removes the duplicate rows in the proxy.
+	{
+		query := "select b from t1 union select b from t1 order by b limit 10"
+		qr, err := client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(qr.Rows))
+	}
+
+	// Pushed down to one partition.
+	{
+		query := "select b from t1 where id=1 union all select b from t1 where id=1"
+		qr, err := client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(qr.Rows))
+	}
+
+	// Explain.
+	{
+		query := "explain select b from t1 union select b from t1"
+		qr, err := client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		assert.Contains(t, qr.Rows[0][0].ToString(), `"Union": "union distinct"`)
+	}
+}
+
+func TestProxyQueryPlanCache(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+
+	// The plan cache is disabled by default.
+	{
+		_, proxy, cleanup := MockProxy(log)
+		assert.Nil(t, proxy.Spanner().plans)
+		cleanup()
+	}
+
+	conf := MockDefaultConfig()
+	conf.Proxy.PlanCacheSize = 1024
+	fakedbs, proxy, cleanup := MockProxy1(log, conf)
+	defer cleanup()
+	address := proxy.Address()
+	plans := proxy.Spanner().plans
+
+	// fakedbs.
+	{
+		fakedbs.AddQueryPattern("create .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("select .*", &sqltypes.Result{})
+	}
+
+	client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+	assert.Nil(t, err)
+	defer client.Close()
+	for _, query := range []string{
+		"create database test",
+		"create table test.t1(id int, b int) partition by hash(id)",
+	} {
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 0, plans.Len())
+
+	// The queries which only differ in the literals reuse the plan.
+	for _, query := range []string{
+		"select b from test.t1 where b=1",
+		"select b from test.t1 where b=2",
+		"select b from test.t1 where b='x'",
+	} {
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, plans.Len())
+	}
+
+	// The shard key routes the query.
+	{
+		query := "select b from test.t1 where id=1 and b=1"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, plans.Len())
+	}
+
+	// DDL clears the plans.
+	{
+		query := "create table test.t2(id int, b int) partition by hash(id)"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, 0, plans.Len())
+	}
+}
@@ -0,0 +1,474 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"backend"
+	"config"
+	"router"
+	"xcontext"
+
+	"github.com/pkg/errors"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+const (
+	// reshardBatchRows is the max rows of one insert batch.
+	reshardBatchRows = 256
+	// reshardBatchBytes is the max bytes of one insert batch.
+	reshardBatchBytes = 1024 * 1024
+	// reshardCatchupRounds is the max rounds of the catch-up before the tables are frozen.
+	reshardCatchupRounds = 3
+	// reshardWaitWritesTimeout is the max time to wait for the open write txns of the frozen tables.
+	reshardWaitWritesTimeout = time.Minute
+)
+
+// Reshard tuple.
+// It moves the rows of the old hash partitions into the new ones online:
+// 1. create the new partition tables like the old one.
+// 2. copy the rows to the new partitions by the slot of the shard key, the writes are accepted.
+// 3. catch up the writes during the copying by the chunk checksums, see chunkSync.
+// 4. freeze the writes of the table, wait for the write txns to be done and sync the final delta.
+// 5. replace the partitions in the router atomically and unfreeze the table.
+// 6. drop the old partition tables.
+// The table must have the primary key, the writes are caught up by the chunks of the primary key.
+type Reshard struct {
+	log              *xlog.Log
+	router           *router.Router
+	scatter          *backend.Scatter
+	streamBufferSize int
+
+	// copied is the number of the rows copied.
+	copied uint64
+	// done is closed to cancel the copying, nil means never.
+	done chan bool
+	// step is called when the step is changed, nil means ignored.
+	step func(string)
+}
+
+// NewReshard creates the Reshard tuple.
+func NewReshard(log *xlog.Log, router *router.Router, scatter *backend.Scatter, streamBufferSize int) *Reshard {
+	return &Reshard{
+		log:              log,
+		router:           router,
+		scatter:          scatter,
+		streamBufferSize: streamBufferSize,
+	}
+}
+
+// reshardTable is the rule with the table config and the old partitions.
+type reshardTable struct {
+	rule    *router.ReshardRule
+	tconf   *config.TableConfig
+	sources []*config.PartitionConfig
+}
+
+// reshard replaces the partitions of the rules, the tables in the same table group are resharded together.
+func (r *Reshard) reshard(database string, rules []*router.ReshardRule) error {
+	log := r.log
+	route := r.router
+
+	tables := make([]*reshardTable, 0, len(rules))
+	for _, rule := range rules {
+		tconf, err := route.TableConfig(database, rule.Table)
+		if err != nil {
+			return err
+		}
+		sources := make([]*config.PartitionConfig, 0, len(rule.Olds))
+		for _, old := range rule.Olds {
+			for _, partition := range tconf.Partitions {
+				if partition.Table == old {
+					sources = append(sources, partition)
+				}
+			}
+		}
+		if len(sources) != len(rule.Olds) {
+			return errors.Errorf("reshard.cant.found.partitions%v", rule.Olds)
+		}
+		tables = append(tables, &reshardTable{rule: rule, tconf: tconf, sources: sources})
+	}
+
+	// The table without the primary key would be copied again under the commit lock, it's refused.
+	r.setStep(shiftStepCreate)
+	syncs := make([]*chunkSync, len(tables))
+	for i, t := range tables {
+		s, err := r.newChunkSync(database, t.rule.Table, t.tconf.ShardKey, t.sources, t.rule.News)
+		if err != nil {
+			return err
+		}
+		if s == nil {
+			return errors.Errorf("unsupported: reshard.table[%s.%s].has.no.primary.key", database, t.rule.Table)
+		}
+		syncs[i] = s
+	}
+
+	// news is the partitions created, only they're dropped if the reshard fails.
+	var news []*config.PartitionConfig
+	for _, t := range tables {
+		log.Warning("reshard.table[%s.%s].from%v.to%+v.start", database, t.rule.Table, t.rule.Olds, t.rule.News)
+
+		// 1. Create the new partitions.
+		r.setStep(shiftStepCreate)
+		if err := r.createPartitions(database, t.sources[0], t.rule.News); err != nil {
+			r.dropPartitions(database, news)
+			return err
+		}
+		news = append(news, t.rule.News...)
+
+		// 2. Copy the rows.
+		r.setStep(shiftStepCopy)
+		for _, source := range t.sources {
+			if err := r.copyPartition(database, t.rule.Table, t.tconf.ShardKey, source, t.rule.News, ""); err != nil {
+				log.Error("reshard.copy[%s.%s].error:%+v", database, source.Table, err)
+				r.dropPartitions(database, news)
+				return err
+			}
+		}
+	}
+
+	// 3. Catch up, 4. freeze and 5. switch.
+	if err := r.switchOver(database, tables, syncs, rules); err != nil {
+		log.Error("reshard.switch.over[%s].error:%+v", database, err)
+		r.dropPartitions(database, news)
+		return err
+	}
+
+	// 6. Drop the old partitions.
+	r.setStep(shiftStepDrop)
+	for _, t := range tables {
+		r.dropPartitions(database, t.sources)
+		log.Warning("reshard.table[%s.%s].from%v.done", database, t.rule.Table, t.rule.Olds)
+	}
+	return nil
+}
+
+// switchOver catches up the writes during the copying, then freezes the tables only
+// for the final delta and the router switch.
+func (r *Reshard) switchOver(database string, tables []*reshardTable, syncs []*chunkSync, rules []*router.ReshardRule) error {
+	log := r.log
+	route := r.router
+
+	// 3. Catch up, the rounds stop if no chunks differ.
+	r.setStep(shiftStepCatchup)
+	for round := 0; round < reshardCatchupRounds; round++ {
+		var diffs int
+		for _, s := range syncs {
+			n, err := r.sync(s)
+			if err != nil {
+				return err
+			}
+			diffs += n
+		}
+		log.Warning("reshard.catchup.round[%d].chunks[%d].synced", round, diffs)
+		if diffs == 0 {
+			break
+		}
+	}
+
+	// 4. Freeze and sync the final delta.
+	r.setStep(shiftStepBlock)
+	for _, t := range tables {
+		if err := route.FreezeTable(database, t.rule.Table); err != nil {
+			return err
+		}
+		defer route.UnfreezeTable(database, t.rule.Table)
+	}
+	for _, t := range tables {
+		if err := route.WaitWrites(database, t.rule.Table, reshardWaitWritesTimeout); err != nil {
+			return err
+		}
+	}
+	// The commit lock is held until the router is switched.
+	txnMgr := r.scatter.TxnManager()
+	txnMgr.CommitLock()
+	defer txnMgr.CommitUnlock()
+	for _, s := range syncs {
+		if _, err := r.sync(s); err != nil {
+			return err
+		}
+	}
+
+	// 5. Switch the router.
+	r.setStep(shiftStepSwitch)
+	return route.PartitionRuleReplace(database, rules)
+}
+
+// setStep used to report the step of the reshard.
+func (r *Reshard) setStep(step string) {
+	if r.step != nil {
+		r.step(step)
+	}
+}
+
+// createPartitions creates the new partitions like the source. The partition which already
+// exists fails the creation instead of being reused, it may be filled by another job.
+// The partitions created before the error are dropped.
+func (r *Reshard) createPartitions(database string, source *config.PartitionConfig, news []*config.PartitionConfig) error {
+	qr, err := r.execute(source.Backend, fmt.Sprintf("SHOW CREATE TABLE %s.%s", database, source.Table))
+	if err != nil {
+		return err
+	}
+	if len(qr.Rows) == 0 || len(qr.Rows[0]) < 2 {
+		return errors.Errorf("reshard.show.create.table[%s.%s].result.is.null", database, source.Table)
+	}
+	create := string(qr.Rows[0][1].Raw())
+
+	for i, part := range news {
+		query := createPartitionQuery(create, database, source.Table, part.Table, false)
+		if _, err := r.execute(part.Backend, query); err != nil {
+			r.dropPartitions(database, news[:i])
+			return err
+		}
+	}
+	return nil
+}
+
+// dropPartitions drops the partitions, the errors are only logged.
+func (r *Reshard) dropPartitions(database string, parts []*config.PartitionConfig) {
+	log := r.log
+	for _, part := range parts {
+		query := fmt.Sprintf("DROP TABLE IF EXISTS %s.%s", database, part.Table)
+		if _, err := r.execute(part.Backend, query); err != nil {
+			log.Error("reshard.drop.table[%s.%s].on[%s].error:%+v", database, part.Table, part.Backend, err)
+		}
+	}
+}
+
+// Copied returns the number of the rows copied.
+func (r *Reshard) Copied() uint64 {
+	return atomic.LoadUint64(&r.copied)
+}
+
+// copyTarget is the new partition and its slots [start, end).
+type copyTarget struct {
+	part  *config.PartitionConfig
+	start int
+	end   int
+	buf   *bytes.Buffer
+	rows  int
+}
+
+// copyPartition streams the rows of the source and inserts them into the targets by the slot.
+// If there is only one target, all the rows are inserted into it without routing.
+// The where limits the rows to be copied, empty means all.
+func (r *Reshard) copyPartition(database string, table string, shardkey string, source *config.PartitionConfig, news []*config.PartitionConfig, where string) error {
+	targets := make([]*copyTarget, 0, len(news))
+	for _, part := range news {
+		target := &copyTarget{part: part, buf: bytes.NewBuffer(make([]byte, 0, 4096))}
+		if len(news) > 1 {
+			segs := strings.Split(part.Segment, "-")
+			if len(segs) != 2 {
+				return errors.Errorf("reshard.partition[%s].segment[%s].malformed", part.Table, part.Segment)
+			}
+			start, err := strconv.Atoi(segs[0])
+			if err != nil {
+				return err
+			}
+			end, err := strconv.Atoi(segs[1])
+			if err != nil {
+				return err
+			}
+			target.start, target.end = start, end
+		}
+		targets = append(targets, target)
+	}
+
+	// The inserts use another transaction since the stream holds the connection.
+	txn, err := r.scatter.CreateTransaction()
+	if err != nil {
+		return err
+	}
+	defer txn.Finish()
+
+	var columns string
+	var keyIdxs []int
+	flush := func(target *copyTarget) error {
+		if target.rows == 0 {
+			return nil
+		}
+		query := fmt.Sprintf("INSERT INTO %s.%s%s VALUES %s", database, target.part.Table, columns, target.buf.String())
+		rows := target.rows
+		target.buf.Reset()
+		target.rows = 0
+		if _, err := txn.ExecuteOnThisBackend(target.part.Backend, query); err != nil {
+			return err
+		}
+		atomic.AddUint64(&r.copied, uint64(rows))
+		return nil
+	}
+
+	callback := func(qr *sqltypes.Result) error {
+		switch qr.State {
+		case sqltypes.RStateFields:
+			names := make([]string, 0, len(qr.Fields))
+			for _, field := range qr.Fields {
+				names = append(names, fmt.Sprintf("`%s`", field.Name))
+			}
+			columns = "(" + strings.Join(names, ",") + ")"
+			if len(targets) == 1 {
+				break
+			}
+			for _, key := range router.ShardKeys(shardkey) {
+				idx := -1
+				for i, field := range qr.Fields {
+					if strings.EqualFold(field.Name, key) {
+						idx = i
+						break
+					}
+				}
+				if idx == -1 {
+					return errors.Errorf("reshard.shardkey.column[%s].missing", key)
+				}
+				keyIdxs = append(keyIdxs, idx)
+			}
+		case sqltypes.RStateRows:
+			select {
+			case <-r.done:
+				return errors.New("reshard.copy.canceled")
+			default:
+			}
+			for _, row := range qr.Rows {
+				target, err := r.pickTarget(database, table, targets, keyIdxs, row)
+				if err != nil {
+					return err
+				}
+				if target.rows > 0 {
+					target.buf.WriteString(",")
+				}
+				target.buf.WriteString("(")
+				for i, v := range row {
+					if i > 0 {
+						target.buf.WriteString(",")
+					}
+					v.EncodeSQL(target.buf)
+				}
+				target.buf.WriteString(")")
+				target.rows++
+				if target.rows >= reshardBatchRows || target.buf.Len() >= reshardBatchBytes {
+					if err := flush(target); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	}
+
+	// Stream the rows from the source.
+	streamTxn, err := r.scatter.CreateTransaction()
+	if err != nil {
+		return err
+	}
+	defer streamTxn.Finish()
+
+	req := xcontext.NewRequestContext()
+	req.Mode = xcontext.ReqNormal
+	req.Querys = []xcontext.QueryTuple{
+		{
+			Query:   fmt.Sprintf("select * from %s.%s%s", database, source.Table, where),
+			Backend: source.Backend,
+		},
+	}
+	if err := streamTxn.ExecuteStreamFetch(req, callback, r.streamBufferSize); err != nil {
+		return err
+	}
+	for _, target := range targets {
+		if err := flush(target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pickTarget returns the target which the slot of the row belongs to.
+func (r *Reshard) pickTarget(database string, table string, targets []*copyTarget, keyIdxs []int, row []sqltypes.Value) (*copyTarget, error) {
+	if len(targets) == 1 {
+		return targets[0], nil
+	}
+
+	keyVals := make([]*sqlparser.SQLVal, 0, len(keyIdxs))
+	for _, idx := range keyIdxs {
+		keyVals = append(keyVals, reshardKeyValue(row[idx]))
+	}
+	types, err := r.router.ShardKeyTypes(database, table)
+	if err != nil {
+		return nil, err
+	}
+	keyVal, err := router.CompositeKey(keyVals, types)
+	if err != nil {
+		return nil, err
+	}
+	slot, err := r.router.GetIndex(database, table, keyVal)
+	if err != nil {
+		return nil, err
+	}
+	for _, target := range targets {
+		if slot >= target.start && slot < target.end {
+			return target, nil
+		}
+	}
+	return nil, errors.Errorf("reshard.slot[%d].of.the.row.is.out.of.the.new.partitions", slot)
+}
+
+// reshardKeyValue converts the shard key value of the row to the SQLVal,
+// the type is same as the value in the INSERT statement to get the same slot.
+func reshardKeyValue(v sqltypes.Value) *sqlparser.SQLVal {
+	switch {
+	case v.IsIntegral():
+		return sqlparser.NewIntVal(v.Raw())
+	case v.IsFloat(), v.Type() == querypb.Type_DECIMAL:
+		return sqlparser.NewFloatVal(v.Raw())
+	}
+	return sqlparser.NewStrVal(v.Raw())
+}
+
+// execute used to execute the query on the backend.
+func (r *Reshard) execute(backend string, query string) (*sqltypes.Result, error) {
+	txn, err := r.scatter.CreateTransaction()
+	if err != nil {
+		return nil, err
+	}
+	defer txn.Finish()
+	return txn.ExecuteOnThisBackend(backend, query)
+}
+
+// checkReshardPeers returns error if the proxy has peers. The freeze of the tables only
+// refuses the writes of this proxy, the writes through the peers would be lost in the switch.
+func (spanner *Spanner) checkReshardPeers() error {
+	if peers := spanner.syncer.Peers(); len(peers) > 1 {
+		return errors.Errorf("unsupported: reshard.with.peers%v", peers)
+	}
+	return nil
+}
+
+// PartitionSplit used to start a job to split the hash partition online, returns the job id.
+func (spanner *Spanner) PartitionSplit(database string, partitionTable string) (uint64, error) {
+	if err := spanner.checkReshardPeers(); err != nil {
+		return 0, err
+	}
+	return spanner.shiftJobs.StartSplit(database, partitionTable)
+}
+
+// PartitionMerge used to start a job to merge the two adjacent hash partitions online, returns the job id.
+func (spanner *Spanner) PartitionMerge(database string, partitionTable1 string, partitionTable2 string) (uint64, error) {
+	if err := spanner.checkReshardPeers(); err != nil {
+		return 0, err
+	}
+	return spanner.shiftJobs.StartMerge(database, partitionTable1, partitionTable2)
+}
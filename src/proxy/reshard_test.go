@@ -0,0 +1,479 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package proxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/driver"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+func mockReshardColumn(name string, values ...string) *sqltypes.Result {
+	qr := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: name,
+				Type: querypb.Type_VARCHAR,
+			},
+		},
+	}
+	for _, v := range values {
+		qr.Rows = append(qr.Rows, []sqltypes.Value{sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte(v))})
+	}
+	return qr
+}
+
+func mockReshardChecksum(count string, checksum string) *sqltypes.Result {
+	return &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "COUNT(*)",
+				Type: querypb.Type_INT64,
+			},
+			{
+				Name: "checksum",
+				Type: querypb.Type_UINT64,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_INT64, []byte(count)),
+				sqltypes.MakeTrusted(querypb.Type_UINT64, []byte(checksum)),
+			},
+		},
+	}
+}
+
+func mockReshardChecksumQuery(table string) string {
+	return fmt.Sprintf("SELECT COUNT(*), COALESCE(BIT_XOR(CRC32(CONCAT_WS('#', `id`, `b`, ISNULL(`id`), ISNULL(`b`)))), 0) FROM test.%s", table)
+}
+
+func TestReshardSplitMerge(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	fakedbs, proxy, cleanup := MockProxy(log)
+	defer cleanup()
+	address := proxy.Address()
+	route := proxy.Router()
+	spanner := proxy.Spanner()
+
+	r1 := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "Table",
+				Type: querypb.Type_VARCHAR,
+			},
+			{
+				Name: "Create Table",
+				Type: querypb.Type_VARCHAR,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("t1_0000")),
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("CREATE TABLE `t1_0000` (`id` int, `b` int) ENGINE=InnoDB")),
+			},
+		},
+	}
+
+	// fakedbs.
+	{
+		fakedbs.AddQueryPattern("create .*", &sqltypes.Result{})
+		fakedbs.AddQuery("SHOW CREATE TABLE test.t1_0000", r1)
+		fakedbs.AddQueryPattern("CREATE TABLE `test`.*", &sqltypes.Result{})
+	}
+
+	// create database.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "create database test"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	// create test table.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "create table test.t1(id int, b int) partition by hash(id)"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	tconf, err := route.TableConfig("test", "t1")
+	assert.Nil(t, err)
+	count := len(tconf.Partitions)
+	segs := strings.Split(tconf.Partitions[0].Segment, "-")
+	end, _ := strconv.Atoi(segs[1])
+	next := fmt.Sprintf("t1_%04d", count)
+	next1 := fmt.Sprintf("t1_%04d", count+1)
+
+	// Find the ids in the two halves of t1_0000.
+	var lower, upper int
+	for i := 1; lower == 0 || upper == 0; i++ {
+		slot, err := route.GetIndex("test", "t1", sqlparser.NewIntVal([]byte(strconv.Itoa(i))))
+		assert.Nil(t, err)
+		if slot < end/2 && lower == 0 {
+			lower = i
+		} else if slot >= end/2 && slot < end && upper == 0 {
+			upper = i
+		}
+	}
+	r2 := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "id",
+				Type: querypb.Type_INT32,
+			},
+			{
+				Name: "b",
+				Type: querypb.Type_VARCHAR,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte(strconv.Itoa(lower))),
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("x")),
+			},
+			{
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte(strconv.Itoa(upper))),
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("y")),
+			},
+		},
+	}
+
+	// Split, the first chunk compare differs and the chunk is copied again.
+	{
+		insert1 := fmt.Sprintf("INSERT INTO test.%s(`id`,`b`) VALUES (%d,'x')", next, lower)
+		insert2 := fmt.Sprintf("INSERT INTO test.%s(`id`,`b`) VALUES (%d,'y')", next1, upper)
+		fakedbs.AddQueryStream("select * from test.t1_0000", r2)
+		fakedbs.AddQuery(insert1, &sqltypes.Result{})
+		fakedbs.AddQuery(insert2, &sqltypes.Result{})
+		fakedbs.AddQuery("DROP TABLE IF EXISTS test.t1_0000", &sqltypes.Result{})
+		fakedbs.AddQuery("SHOW KEYS FROM test.t1_0000 WHERE Key_name = 'PRIMARY'", mockReshardColumn("Column_name", "id"))
+		fakedbs.AddQuery("SHOW COLUMNS FROM test.t1_0000", mockReshardColumn("Field", "id", "b"))
+		fakedbs.AddQuery("SELECT `id` FROM test.t1_0000 ORDER BY `id` LIMIT 1 OFFSET 999", &sqltypes.Result{})
+		fakedbs.AddQuerys(strings.ToLower(mockReshardChecksumQuery("t1_0000")), mockReshardChecksum("3", "3"), mockReshardChecksum("2", "3"), mockReshardChecksum("2", "3"))
+		fakedbs.AddQuery(mockReshardChecksumQuery(next), mockReshardChecksum("1", "1"))
+		fakedbs.AddQuery(mockReshardChecksumQuery(next1), mockReshardChecksum("1", "2"))
+		fakedbs.AddQueryPattern("DELETE FROM .*", &sqltypes.Result{})
+
+		id, err := spanner.PartitionSplit("test", "t1_0000")
+		assert.Nil(t, err)
+		job := waitShiftJob(t, spanner.ShiftJobs(), id)
+		assert.Equal(t, ShiftJobDone, job.State)
+		assert.Equal(t, ShiftJobSplit, job.Type)
+		assert.Equal(t, "t1", job.Table)
+		assert.Equal(t, "t1_0000", job.From)
+		assert.Equal(t, next+","+next1, job.To)
+		assert.Equal(t, uint64(4), job.Rows)
+		assert.Equal(t, 2, fakedbs.GetQueryCalledNum(insert1))
+		assert.Equal(t, 2, fakedbs.GetQueryCalledNum(insert2))
+		assert.Equal(t, 1, fakedbs.GetQueryCalledNum(fmt.Sprintf("DELETE FROM test.%s", next)))
+		assert.Equal(t, 3, fakedbs.GetQueryCalledNum(mockReshardChecksumQuery("t1_0000")))
+		assert.Equal(t, 1, fakedbs.GetQueryCalledNum("DROP TABLE IF EXISTS test.t1_0000"))
+
+		tconf, err := route.TableConfig("test", "t1")
+		assert.Nil(t, err)
+		assert.Equal(t, count+1, len(tconf.Partitions))
+		assert.Equal(t, fmt.Sprintf("0-%d", end/2), tconf.Partitions[count-1].Segment)
+		assert.Equal(t, fmt.Sprintf("%d-%d", end/2, end), tconf.Partitions[count].Segment)
+		assert.Nil(t, route.CheckWritable("test", "t1"))
+	}
+
+	// Merge.
+	{
+		merged := fmt.Sprintf("t1_%04d", count+2)
+		r3 := &sqltypes.Result{Fields: r2.Fields, Rows: r2.Rows[:1]}
+		r4 := &sqltypes.Result{Fields: r2.Fields, Rows: r2.Rows[1:]}
+		r5 := &sqltypes.Result{Fields: r1.Fields, Rows: [][]sqltypes.Value{{r1.Rows[0][0], sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte(fmt.Sprintf("CREATE TABLE `%s` (`id` int, `b` int) ENGINE=InnoDB", next)))}}}
+		fakedbs.AddQuery(fmt.Sprintf("SHOW CREATE TABLE test.%s", next), r5)
+		fakedbs.AddQueryStream(fmt.Sprintf("select * from test.%s", next), r3)
+		fakedbs.AddQueryStream(fmt.Sprintf("select * from test.%s", next1), r4)
+		fakedbs.AddQueryPattern("INSERT INTO .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("DROP TABLE IF EXISTS .*", &sqltypes.Result{})
+
+		// No primary key, the merge is refused before the new partition is created.
+		fakedbs.AddQuery(fmt.Sprintf("SHOW KEYS FROM test.%s WHERE Key_name = 'PRIMARY'", next), &sqltypes.Result{})
+		id, err := spanner.PartitionMerge("test", next1, next)
+		assert.Nil(t, err)
+		job := waitShiftJob(t, spanner.ShiftJobs(), id)
+		assert.Equal(t, ShiftJobFailed, job.State)
+		assert.Equal(t, "unsupported: reshard.table[test.t1].has.no.primary.key", job.Error)
+		assert.Equal(t, 0, fakedbs.GetQueryCalledNum(fmt.Sprintf("select * from test.%s", next)))
+
+		fakedbs.AddQuery(fmt.Sprintf("SHOW KEYS FROM test.%s WHERE Key_name = 'PRIMARY'", next), mockReshardColumn("Column_name", "id"))
+		fakedbs.AddQuery(fmt.Sprintf("SHOW COLUMNS FROM test.%s", next), mockReshardColumn("Field", "id", "b"))
+		fakedbs.AddQuery(fmt.Sprintf("SELECT `id` FROM test.%s ORDER BY `id` LIMIT 1 OFFSET 999", next), &sqltypes.Result{})
+		fakedbs.AddQuery(mockReshardChecksumQuery(merged), mockReshardChecksum("2", "3"))
+		id, err = spanner.PartitionMerge("test", next1, next)
+		assert.Nil(t, err)
+		job = waitShiftJob(t, spanner.ShiftJobs(), id)
+		assert.Equal(t, ShiftJobDone, job.State)
+		assert.Equal(t, ShiftJobMerge, job.Type)
+		assert.Equal(t, 1, fakedbs.GetQueryCalledNum(fmt.Sprintf("select * from test.%s", next)))
+		assert.Equal(t, 2, fakedbs.GetQueryCalledNum(mockReshardChecksumQuery(merged)))
+
+		tconf, err := route.TableConfig("test", "t1")
+		assert.Nil(t, err)
+		assert.Equal(t, count, len(tconf.Partitions))
+		assert.Equal(t, merged, tconf.Partitions[count-1].Table)
+		assert.Equal(t, fmt.Sprintf("0-%d", end), tconf.Partitions[count-1].Segment)
+	}
+}
+
+func TestReshardSplitError(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	fakedbs, proxy, cleanup := MockProxy(log)
+	defer cleanup()
+	address := proxy.Address()
+	route := proxy.Router()
+	spanner := proxy.Spanner()
+
+	// fakedbs.
+	{
+		fakedbs.AddQueryPattern("create .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("DROP TABLE IF EXISTS .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("SHOW KEYS FROM .*", mockReshardColumn("Column_name", "id"))
+		fakedbs.AddQueryPattern("SHOW COLUMNS FROM .*", mockReshardColumn("Field", "id", "b"))
+	}
+
+	// create database.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "create database test"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	// create test table.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "create table test.t1(id int, b int) partition by hash(id)"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	tconf, err := route.TableConfig("test", "t1")
+	assert.Nil(t, err)
+	count := len(tconf.Partitions)
+
+	// Show create table error.
+	{
+		fakedbs.AddQueryError("SHOW CREATE TABLE test.t1_0000", fmt.Errorf("mock.show.create.table.error"))
+		id, err := spanner.PartitionSplit("test", "t1_0000")
+		assert.Nil(t, err)
+		job := waitShiftJob(t, spanner.ShiftJobs(), id)
+		assert.Equal(t, ShiftJobFailed, job.State)
+		assert.Equal(t, shiftStepCreate, job.Step)
+		assert.Contains(t, job.Error, "mock.show.create.table.error")
+	}
+
+	// Copy error.
+	{
+		r1 := &sqltypes.Result{
+			Fields: []*querypb.Field{
+				{
+					Name: "Table",
+					Type: querypb.Type_VARCHAR,
+				},
+				{
+					Name: "Create Table",
+					Type: querypb.Type_VARCHAR,
+				},
+			},
+			Rows: [][]sqltypes.Value{
+				{
+					sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("t1_0001")),
+					sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("CREATE TABLE `t1_0001` (`id` int, `b` int) ENGINE=InnoDB")),
+				},
+			},
+		}
+		fakedbs.AddQuery("SHOW CREATE TABLE test.t1_0001", r1)
+		fakedbs.AddQueryPattern("CREATE TABLE `test`.*", &sqltypes.Result{})
+		fakedbs.AddQueryError("select * from test.t1_0001", fmt.Errorf("mock.select.error"))
+		id, err := spanner.PartitionSplit("test", "t1_0001")
+		assert.Nil(t, err)
+		job := waitShiftJob(t, spanner.ShiftJobs(), id)
+		assert.Equal(t, ShiftJobFailed, job.State)
+		assert.Equal(t, shiftStepCopy, job.Step)
+		assert.Contains(t, job.Error, "mock.select.error")
+	}
+
+	// Merge error.
+	{
+		_, err := spanner.PartitionMerge("test", "t1_0000", "t1_0002")
+		assert.Equal(t, "router.rule.merge.partitions[t1_0000,t1_0002].must.be.adjacent", err.Error())
+	}
+
+	// The freeze is local, the reshard with peers is refused.
+	{
+		err := proxy.Syncer().AddPeer("127.0.0.1:9999")
+		assert.Nil(t, err)
+		_, err = spanner.PartitionSplit("test", "t1_0000")
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), "unsupported: reshard.with.peers")
+		_, err = spanner.PartitionMerge("test", "t1_0000", "t1_0001")
+		assert.Contains(t, err.Error(), "unsupported: reshard.with.peers")
+		err = proxy.Syncer().RemovePeer("127.0.0.1:9999")
+		assert.Nil(t, err)
+	}
+
+	// The router is unchanged and writable.
+	tconf, err = route.TableConfig("test", "t1")
+	assert.Nil(t, err)
+	assert.Equal(t, count, len(tconf.Partitions))
+	assert.Nil(t, route.CheckWritable("test", "t1"))
+}
+
+func TestReshardJobLockCancel(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	fakedbs, proxy, cleanup := MockProxy(log)
+	defer cleanup()
+	address := proxy.Address()
+	route := proxy.Router()
+
+	r1 := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "Table",
+				Type: querypb.Type_VARCHAR,
+			},
+			{
+				Name: "Create Table",
+				Type: querypb.Type_VARCHAR,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("t1_0000")),
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("CREATE TABLE `t1_0000` (`id` int, `b` int) ENGINE=InnoDB")),
+			},
+		},
+	}
+	r2 := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "id",
+				Type: querypb.Type_INT32,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("1")),
+			},
+		},
+	}
+
+	// fakedbs.
+	{
+		fakedbs.AddQueryPattern("create .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("DROP TABLE IF EXISTS .*", &sqltypes.Result{})
+		fakedbs.AddQuery("SHOW CREATE TABLE test.t1_0000", r1)
+		fakedbs.AddQueryPattern("CREATE TABLE `test`.*", &sqltypes.Result{})
+		fakedbs.AddQueryDelay("select * from test.t1_0000", r2, 200)
+		fakedbs.AddQueryPattern("SHOW KEYS FROM .*", mockReshardColumn("Column_name", "id"))
+		fakedbs.AddQueryPattern("SHOW COLUMNS FROM .*", mockReshardColumn("Field", "id", "b"))
+	}
+
+	// create database.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "create database test"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	// create test table.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "create table test.t1(id int, b int) partition by hash(id)"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	tconf, err := route.TableConfig("test", "t1")
+	assert.Nil(t, err)
+	count := len(tconf.Partitions)
+
+	// The close cancels the running copy.
+	jobs := NewShiftJobs(log, route, proxy.Scatter(), 1024)
+	id, err := jobs.StartSplit("test", "t1_0000")
+	assert.Nil(t, err)
+	time.Sleep(time.Millisecond * 50)
+
+	// The table is locked by the running job.
+	{
+		want := fmt.Sprintf("shift.table[test.t1].is.locked.by.job[%d]", id)
+		_, err := jobs.StartSplit("test", "t1_0000")
+		assert.EqualError(t, err, want)
+		_, err = jobs.StartMerge("test", "t1_0001", "t1_0002")
+		assert.EqualError(t, err, want)
+		from := tconf.Partitions[1].Backend
+		to := "backend1"
+		if from == to {
+			to = "backend2"
+		}
+		_, err = jobs.Start("test", "t1_0001", from, to)
+		assert.EqualError(t, err, want)
+	}
+
+	jobs.Close()
+	job, ok := jobs.Job(id)
+	assert.True(t, ok)
+	assert.Equal(t, ShiftJobFailed, job.State)
+	assert.Equal(t, shiftStepCopy, job.Step)
+	assert.Contains(t, job.Error, "reshard.copy.canceled")
+
+	tconf, err = route.TableConfig("test", "t1")
+	assert.Nil(t, err)
+	assert.Equal(t, count, len(tconf.Partitions))
+
+	jobs = NewShiftJobs(log, route, proxy.Scatter(), 1024)
+	defer jobs.Close()
+
+	// The frozen table is refused.
+	{
+		err := route.FreezeTable("test", "t1")
+		assert.Nil(t, err)
+		_, err = jobs.StartSplit("test", "t1_0000")
+		assert.EqualError(t, err, "router.table[test.t1].is.frozen.for.resharding,please.retry.later")
+		route.UnfreezeTable("test", "t1")
+	}
+
+	// The existing partition isn't reused or dropped, only the created one is dropped.
+	{
+		next := fmt.Sprintf("t1_%04d", count)
+		next1 := fmt.Sprintf("t1_%04d", count+1)
+		drops := fakedbs.GetQueryCalledNum("DROP TABLE IF EXISTS test." + next)
+		drops1 := fakedbs.GetQueryCalledNum("DROP TABLE IF EXISTS test." + next1)
+		fakedbs.AddQueryErrorPattern("CREATE TABLE `test`.`"+next1+"`.*", fmt.Errorf("mock.table.exists"))
+		id, err := jobs.StartSplit("test", "t1_0000")
+		assert.Nil(t, err)
+		job := waitShiftJob(t, jobs, id)
+		assert.Equal(t, ShiftJobFailed, job.State)
+		assert.Equal(t, shiftStepCreate, job.Step)
+		assert.Contains(t, job.Error, "mock.table.exists")
+		assert.Equal(t, drops+1, fakedbs.GetQueryCalledNum("DROP TABLE IF EXISTS test."+next))
+		assert.Equal(t, drops1, fakedbs.GetQueryCalledNum("DROP TABLE IF EXISTS test."+next1))
+	}
+}
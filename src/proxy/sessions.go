@@ -61,7 +61,7 @@ func (ss *Sessions) txnAbort(txn backend.Transaction, node sqlparser.Statement)
 	// If the client closed, txn will be abort by backend.
 	if txn != nil && node != nil {
 		switch node.(type) {
-		case *sqlparser.Select, *sqlparser.DDL:
+		case *sqlparser.Select, *sqlparser.Union, *sqlparser.DDL:
 			if err := txn.Abort(); err != nil {
 				log.Error("proxy.session.txn.abort.error:%+v", err)
 				return
@@ -0,0 +1,535 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package proxy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"backend"
+	"config"
+	"router"
+
+	"github.com/pkg/errors"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+const (
+	// ShiftJobMigrate is the type of the job which moves the partition to another backend.
+	ShiftJobMigrate = "migrate"
+	// ShiftJobSplit is the type of the job which splits the hash partition.
+	ShiftJobSplit = "split"
+	// ShiftJobMerge is the type of the job which merges the adjacent hash partitions.
+	ShiftJobMerge = "merge"
+
+	// ShiftJobRunning is the state of the running job.
+	ShiftJobRunning = "running"
+	// ShiftJobDone is the state of the finished job.
+	ShiftJobDone = "done"
+	// ShiftJobFailed is the state of the failed job.
+	ShiftJobFailed = "failed"
+
+	shiftStepCreate   = "create"
+	shiftStepCopy     = "copy"
+	shiftStepBlock    = "block"
+	shiftStepVerify   = "verify"
+	shiftStepCatchup  = "catchup"
+	shiftStepSwitch   = "switch"
+	shiftStepDrop     = "drop"
+	shiftStepFinished = "finished"
+)
+
+// ShiftJob tuple.
+// For the migrate job, the Table is the partition table and the From/To are the backends.
+// For the split and merge jobs, the Table is the table and the From/To are the old and new partitions.
+type ShiftJob struct {
+	ID        uint64    `json:"id"`
+	Type      string    `json:"type"`
+	Database  string    `json:"database"`
+	Table     string    `json:"table"`
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	State     string    `json:"state"`
+	Step      string    `json:"step"`
+	Rows      uint64    `json:"rows"`
+	Error     string    `json:"error,omitempty"`
+	StartTime time.Time `json:"start-time"`
+	EndTime   time.Time `json:"end-time,omitempty"`
+
+	reshard *Reshard
+	// tables is the tables locked by the job.
+	tables []string
+}
+
+// ShiftJobs tuple.
+// It runs the migrate, split and merge jobs in the background, see Reshard for the split and merge.
+// The migrate job moves the partition table from one backend to another, the processes as:
+// 1. create the table on the to-backend and copy the rows.
+// 2. catch up the writes during the copying by the chunk checksums, see chunkSync.
+// 3. block the writes: freeze the table, wait for the write txns to be done and acquire the commit lock,
+// the table without the primary key is copied again before the commit lock if it differs.
+// 4. sync the final delta, verify the row counts and the checksums, shift the rule to the to-backend, then unblock the writes.
+// 5. drop the table on the from-backend, the GLOBAL table is kept.
+// The co-located partitions of the tables in the same table group are moved together.
+type ShiftJobs struct {
+	log              *xlog.Log
+	mu               sync.RWMutex
+	wg               sync.WaitGroup
+	done             chan bool
+	router           *router.Router
+	scatter          *backend.Scatter
+	streamBufferSize int
+	seq              uint64
+	jobs             map[uint64]*ShiftJob
+	// locks is the tables locked by the running jobs, the key is 'database.table' and the value is the job id.
+	// The rules are computed under the mu, so a job sees the router switched by the job before.
+	locks map[string]uint64
+}
+
+// NewShiftJobs creates the ShiftJobs tuple.
+func NewShiftJobs(log *xlog.Log, router *router.Router, scatter *backend.Scatter, streamBufferSize int) *ShiftJobs {
+	return &ShiftJobs{
+		log:              log,
+		done:             make(chan bool),
+		router:           router,
+		scatter:          scatter,
+		streamBufferSize: streamBufferSize,
+		jobs:             make(map[uint64]*ShiftJob),
+		locks:            make(map[string]uint64),
+	}
+}
+
+// Close used to cancel the running jobs and wait for them.
+func (sj *ShiftJobs) Close() {
+	close(sj.done)
+	sj.wg.Wait()
+}
+
+// Start used to start a job to shift the partition table from one backend to another.
+// Returns the job id.
+func (sj *ShiftJobs) Start(database string, partitionTable string, from string, to string) (uint64, error) {
+	if from == to {
+		return 0, errors.Errorf("shift.from[%s].cant.equal.to[%s]", from, to)
+	}
+
+	sj.mu.Lock()
+	defer sj.mu.Unlock()
+	parts, err := sj.router.PartitionRuleGroup(from, database, partitionTable)
+	if err != nil {
+		return 0, err
+	}
+	tconf, err := sj.router.TableConfig(database, parts[0].Table)
+	if err != nil {
+		return 0, err
+	}
+	for _, partition := range tconf.Partitions {
+		if partition.Backend == to && partition.Table == partitionTable {
+			return 0, errors.Errorf("shift.table[%s].already.exists.in.the.backend[%s]", partitionTable, to)
+		}
+	}
+	tables := make([]string, 0, len(parts))
+	for _, part := range parts {
+		tables = append(tables, part.Table)
+	}
+	if err := sj.checkLocks(database, tables); err != nil {
+		return 0, err
+	}
+
+	job := &ShiftJob{
+		Type:     ShiftJobMigrate,
+		Database: database,
+		Table:    partitionTable,
+		From:     from,
+		To:       to,
+	}
+	return sj.launch(job, tables, func(job *ShiftJob) error {
+		return sj.shift(job, parts)
+	}), nil
+}
+
+// StartSplit used to start a job to split the hash partition into two new partitions.
+// Returns the job id.
+func (sj *ShiftJobs) StartSplit(database string, partitionTable string) (uint64, error) {
+	sj.mu.Lock()
+	defer sj.mu.Unlock()
+	rules, err := sj.router.PartitionRuleSplit(database, partitionTable)
+	if err != nil {
+		return 0, err
+	}
+	return sj.startReshard(ShiftJobSplit, database, rules)
+}
+
+// StartMerge used to start a job to merge the two adjacent hash partitions into one new partition.
+// Returns the job id.
+func (sj *ShiftJobs) StartMerge(database string, partitionTable1 string, partitionTable2 string) (uint64, error) {
+	sj.mu.Lock()
+	defer sj.mu.Unlock()
+	rules, err := sj.router.PartitionRuleMerge(database, partitionTable1, partitionTable2)
+	if err != nil {
+		return 0, err
+	}
+	return sj.startReshard(ShiftJobMerge, database, rules)
+}
+
+// startReshard used to start the job which replaces the partitions of the rules. It needs Lock.
+func (sj *ShiftJobs) startReshard(typ string, database string, rules []*router.ReshardRule) (uint64, error) {
+	tables := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		tables = append(tables, rule.Table)
+	}
+	if err := sj.checkLocks(database, tables); err != nil {
+		return 0, err
+	}
+
+	news := make([]string, 0, len(rules[0].News))
+	for _, part := range rules[0].News {
+		news = append(news, part.Table)
+	}
+	job := &ShiftJob{
+		Type:     typ,
+		Database: database,
+		Table:    rules[0].Table,
+		From:     strings.Join(rules[0].Olds, ","),
+		To:       strings.Join(news, ","),
+	}
+	return sj.launch(job, tables, func(job *ShiftJob) error {
+		return job.reshard.reshard(database, rules)
+	}), nil
+}
+
+// checkLocks returns error if any of the tables is locked by a running job or frozen.
+// It needs Lock.
+func (sj *ShiftJobs) checkLocks(database string, tables []string) error {
+	for _, table := range tables {
+		if id, ok := sj.locks[database+"."+table]; ok {
+			return errors.Errorf("shift.table[%s.%s].is.locked.by.job[%d]", database, table, id)
+		}
+		if err := sj.router.CheckWritable(database, table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// launch used to add the job and run the fn in the background, the job can be canceled
+// by Close. The tables are locked until the job is finished. Returns the job id. It needs Lock.
+func (sj *ShiftJobs) launch(job *ShiftJob, tables []string, fn func(*ShiftJob) error) uint64 {
+	sj.seq++
+	job.ID = sj.seq
+	job.tables = tables
+	for _, table := range tables {
+		sj.locks[job.Database+"."+table] = job.ID
+	}
+	job.State = ShiftJobRunning
+	job.StartTime = time.Now()
+	job.reshard = NewReshard(sj.log, sj.router, sj.scatter, sj.streamBufferSize)
+	job.reshard.done = sj.done
+	job.reshard.step = func(step string) {
+		sj.setStep(job, step)
+	}
+	sj.jobs[job.ID] = job
+
+	sj.wg.Add(1)
+	go func(job *ShiftJob) {
+		defer sj.wg.Done()
+		sj.run(job, fn)
+	}(job)
+	return job.ID
+}
+
+// Jobs returns the snapshot of all the jobs, sorted by id.
+func (sj *ShiftJobs) Jobs() []ShiftJob {
+	sj.mu.RLock()
+	defer sj.mu.RUnlock()
+
+	jobs := make([]ShiftJob, 0, len(sj.jobs))
+	for _, job := range sj.jobs {
+		jobs = append(jobs, sj.snapshot(job))
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].ID < jobs[j].ID })
+	return jobs
+}
+
+// Job returns the snapshot of the job by id.
+func (sj *ShiftJobs) Job(id uint64) (ShiftJob, bool) {
+	sj.mu.RLock()
+	defer sj.mu.RUnlock()
+
+	job, ok := sj.jobs[id]
+	if !ok {
+		return ShiftJob{}, false
+	}
+	return sj.snapshot(job), true
+}
+
+// snapshot needs RLock.
+func (sj *ShiftJobs) snapshot(job *ShiftJob) ShiftJob {
+	snap := *job
+	snap.Rows = job.reshard.Copied()
+	snap.reshard = nil
+	snap.tables = nil
+	return snap
+}
+
+func (sj *ShiftJobs) setStep(job *ShiftJob, step string) {
+	sj.mu.Lock()
+	defer sj.mu.Unlock()
+	job.Step = step
+}
+
+func (sj *ShiftJobs) finish(job *ShiftJob, err error) {
+	sj.mu.Lock()
+	defer sj.mu.Unlock()
+	job.EndTime = time.Now()
+	for _, table := range job.tables {
+		delete(sj.locks, job.Database+"."+table)
+	}
+	if err != nil {
+		job.State = ShiftJobFailed
+		job.Error = err.Error()
+		return
+	}
+	job.State = ShiftJobDone
+	job.Step = shiftStepFinished
+}
+
+func (sj *ShiftJobs) run(job *ShiftJob, fn func(*ShiftJob) error) {
+	log := sj.log
+	log.Warning("shift.job[%d].%s.table[%s.%s].from[%s].to[%s].start", job.ID, job.Type, job.Database, job.Table, job.From, job.To)
+	err := fn(job)
+	if err != nil {
+		log.Error("shift.job[%d].step[%s].error:%+v", job.ID, job.Step, err)
+	} else {
+		log.Warning("shift.job[%d].done", job.ID)
+	}
+	sj.finish(job, err)
+}
+
+// shiftPair is the partition table to be moved from the source to the target.
+type shiftPair struct {
+	table    string
+	shardkey string
+	source   *config.PartitionConfig
+	target   *config.PartitionConfig
+}
+
+func (sj *ShiftJobs) shift(job *ShiftJob, parts []*router.GroupPartition) error {
+	reshard := job.reshard
+	database := job.Database
+	pairs := make([]*shiftPair, 0, len(parts))
+	targets := make([]*config.PartitionConfig, 0, len(parts))
+	for _, part := range parts {
+		pair := &shiftPair{
+			table:    part.Table,
+			shardkey: part.ShardKey,
+			source:   &config.PartitionConfig{Table: part.Partition.Table, Backend: job.From},
+			target:   &config.PartitionConfig{Table: part.Partition.Table, Backend: job.To},
+		}
+		pairs = append(pairs, pair)
+		targets = append(targets, pair.target)
+	}
+
+	// 1. Create the tables on the to-backend, they must be empty.
+	sj.setStep(job, shiftStepCreate)
+	for _, pair := range pairs {
+		if err := reshard.createPartitions(database, pair.source, []*config.PartitionConfig{pair.target}); err != nil {
+			return err
+		}
+		count, err := sj.count(reshard, database, pair.target)
+		if err != nil {
+			return err
+		}
+		if count != 0 {
+			return errors.Errorf("shift.table[%s.%s].on[%s].is.not.empty", database, pair.target.Table, job.To)
+		}
+	}
+
+	// 2. Copy the rows.
+	sj.setStep(job, shiftStepCopy)
+	for _, pair := range pairs {
+		if err := reshard.copyPartition(database, pair.table, pair.shardkey, pair.source, []*config.PartitionConfig{pair.target}, ""); err != nil {
+			reshard.dropPartitions(database, targets)
+			return err
+		}
+	}
+
+	// 3. Catch up, block the writes and switch.
+	if err := sj.switchOver(job, pairs); err != nil {
+		reshard.dropPartitions(database, targets)
+		return err
+	}
+
+	// 4. Drop the sources.
+	if parts[0].ShardType != "GLOBAL" {
+		sj.setStep(job, shiftStepDrop)
+		for _, pair := range pairs {
+			reshard.dropPartitions(database, []*config.PartitionConfig{pair.source})
+		}
+	}
+	return nil
+}
+
+// switchOver catches up the writes during the copying, then blocks the writes of the tables
+// only for the final delta, the verify and the rule shift.
+func (sj *ShiftJobs) switchOver(job *ShiftJob, pairs []*shiftPair) error {
+	log := sj.log
+	reshard := job.reshard
+	database := job.Database
+
+	syncs := make([]*chunkSync, len(pairs))
+	for i, pair := range pairs {
+		s, err := reshard.newChunkSync(database, pair.table, pair.shardkey, []*config.PartitionConfig{pair.source}, []*config.PartitionConfig{pair.target})
+		if err != nil {
+			return err
+		}
+		syncs[i] = s
+	}
+
+	// Catch up without blocking, the rounds stop if nothing differs.
+	sj.setStep(job, shiftStepCatchup)
+	for round := 0; round < reshardCatchupRounds; round++ {
+		var differs bool
+		for i, pair := range pairs {
+			differ, err := sj.catchup(job, pair, syncs[i])
+			if err != nil {
+				return err
+			}
+			differs = differs || differ
+		}
+		if !differs {
+			break
+		}
+	}
+
+	sj.setStep(job, shiftStepBlock)
+	for _, pair := range pairs {
+		if err := sj.router.FreezeTable(database, pair.table); err != nil {
+			return err
+		}
+		defer sj.router.UnfreezeTable(database, pair.table)
+	}
+	for _, pair := range pairs {
+		if err := sj.router.WaitWrites(database, pair.table, reshardWaitWritesTimeout); err != nil {
+			return err
+		}
+	}
+
+	// The final delta. The table without the primary key is copied again if it differs,
+	// its writes are frozen, so it's done before the commit lock which blocks all the tables.
+	sj.setStep(job, shiftStepCatchup)
+	for i, pair := range pairs {
+		if syncs[i] != nil {
+			continue
+		}
+		if _, err := sj.catchup(job, pair, nil); err != nil {
+			return err
+		}
+	}
+	txnMgr := sj.scatter.TxnManager()
+	txnMgr.CommitLock()
+	defer txnMgr.CommitUnlock()
+	for i, pair := range pairs {
+		if syncs[i] == nil {
+			continue
+		}
+		if _, err := sj.catchup(job, pair, syncs[i]); err != nil {
+			return err
+		}
+	}
+
+	// The writes are blocked, the source and the target must be same before the rule is shifted.
+	sj.setStep(job, shiftStepVerify)
+	for _, pair := range pairs {
+		if err := sj.verify(reshard, database, pair.source, pair.target); err != nil {
+			log.Error("shift.job[%d].table[%s].verify.error:%v", job.ID, pair.target.Table, err)
+			return err
+		}
+	}
+
+	sj.setStep(job, shiftStepSwitch)
+	return sj.router.PartitionRuleShift(job.From, job.To, database, job.Table)
+}
+
+// catchup syncs the writes of the source to the target, returns true if they differed.
+// The table without the primary key is verified as a whole and copied again if it differs.
+func (sj *ShiftJobs) catchup(job *ShiftJob, pair *shiftPair, s *chunkSync) (bool, error) {
+	log := sj.log
+	reshard := job.reshard
+	database := job.Database
+
+	if s != nil {
+		diffs, err := reshard.sync(s)
+		return diffs > 0, err
+	}
+	err := sj.verify(reshard, database, pair.source, pair.target)
+	if err == nil {
+		return false, nil
+	}
+	log.Warning("shift.job[%d].table[%s].verify.error:%v, copy.again...", job.ID, pair.target.Table, err)
+	if _, err := reshard.execute(pair.target.Backend, fmt.Sprintf("TRUNCATE TABLE %s.%s", database, pair.target.Table)); err != nil {
+		return false, err
+	}
+	if err := reshard.copyPartition(database, pair.table, pair.shardkey, pair.source, []*config.PartitionConfig{pair.target}, ""); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// verify checks the row counts and the checksums of the source and the target are same.
+func (sj *ShiftJobs) verify(reshard *Reshard, database string, source *config.PartitionConfig, target *config.PartitionConfig) error {
+	count1, err := sj.count(reshard, database, source)
+	if err != nil {
+		return err
+	}
+	count2, err := sj.count(reshard, database, target)
+	if err != nil {
+		return err
+	}
+	if count1 != count2 {
+		return errors.Errorf("shift.verify.rows.from[%d].to[%d].mismatch", count1, count2)
+	}
+
+	checksum1, err := sj.checksum(reshard, database, source)
+	if err != nil {
+		return err
+	}
+	checksum2, err := sj.checksum(reshard, database, target)
+	if err != nil {
+		return err
+	}
+	if checksum1 != checksum2 {
+		return errors.Errorf("shift.verify.checksum.from[%s].to[%s].mismatch", checksum1, checksum2)
+	}
+	return nil
+}
+
+func (sj *ShiftJobs) count(reshard *Reshard, database string, part *config.PartitionConfig) (uint64, error) {
+	qr, err := reshard.execute(part.Backend, fmt.Sprintf("SELECT COUNT(*) FROM %s.%s", database, part.Table))
+	if err != nil {
+		return 0, err
+	}
+	if len(qr.Rows) == 0 || len(qr.Rows[0]) == 0 {
+		return 0, errors.Errorf("shift.count.table[%s.%s].on[%s].result.is.null", database, part.Table, part.Backend)
+	}
+	return qr.Rows[0][0].ParseUint64()
+}
+
+func (sj *ShiftJobs) checksum(reshard *Reshard, database string, part *config.PartitionConfig) (string, error) {
+	qr, err := reshard.execute(part.Backend, fmt.Sprintf("CHECKSUM TABLE %s.%s", database, part.Table))
+	if err != nil {
+		return "", err
+	}
+	if len(qr.Rows) == 0 || len(qr.Rows[0]) < 2 {
+		return "", errors.Errorf("shift.checksum.table[%s.%s].on[%s].result.is.null", database, part.Table, part.Backend)
+	}
+	return qr.Rows[0][1].String(), nil
+}
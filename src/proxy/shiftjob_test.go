@@ -0,0 +1,348 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/driver"
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+func mockShiftCount(n string) *sqltypes.Result {
+	return &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "COUNT(*)",
+				Type: querypb.Type_INT64,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_INT64, []byte(n)),
+			},
+		},
+	}
+}
+
+func waitShiftJob(t *testing.T, jobs *ShiftJobs, id uint64) ShiftJob {
+	for i := 0; i < 500; i++ {
+		job, ok := jobs.Job(id)
+		assert.True(t, ok)
+		if job.State != ShiftJobRunning {
+			return job
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Fatalf("shift.job[%d].timeout", id)
+	return ShiftJob{}
+}
+
+func TestShiftJob(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	fakedbs, proxy, cleanup := MockProxy(log)
+	defer cleanup()
+	address := proxy.Address()
+	route := proxy.Router()
+	jobs := proxy.Spanner().ShiftJobs()
+
+	r1 := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "Table",
+				Type: querypb.Type_VARCHAR,
+			},
+			{
+				Name: "Create Table",
+				Type: querypb.Type_VARCHAR,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("t1_0000")),
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("CREATE TABLE `t1_0000` (`id` int, `b` int) ENGINE=InnoDB")),
+			},
+		},
+	}
+	r2 := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "id",
+				Type: querypb.Type_INT32,
+			},
+			{
+				Name: "b",
+				Type: querypb.Type_INT32,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("1")),
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("1")),
+			},
+			{
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("2")),
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("2")),
+			},
+		},
+	}
+	r3 := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "Table",
+				Type: querypb.Type_VARCHAR,
+			},
+			{
+				Name: "Checksum",
+				Type: querypb.Type_INT64,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("test.t1_0000")),
+				sqltypes.MakeTrusted(querypb.Type_INT64, []byte("1024")),
+			},
+		},
+	}
+
+	// fakedbs.
+	{
+		fakedbs.AddQueryPattern("create .*", &sqltypes.Result{})
+		fakedbs.AddQuery("SHOW CREATE TABLE test.t1_0000", r1)
+		fakedbs.AddQueryPattern("CREATE TABLE `test`.*", &sqltypes.Result{})
+		fakedbs.AddQueryStream("select * from test.t1_0000", r2)
+		fakedbs.AddQueryPattern("INSERT INTO .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("TRUNCATE TABLE .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("DROP TABLE IF EXISTS .*", &sqltypes.Result{})
+		fakedbs.AddQuery("CHECKSUM TABLE test.t1_0000", r3)
+		// No primary key, the tables are verified as a whole.
+		fakedbs.AddQueryPattern("SHOW KEYS FROM .*", &sqltypes.Result{})
+	}
+
+	// create database.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "create database test"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	// create test table.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "create table test.t1(id int, b int) partition by hash(id)"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	tconf, err := route.TableConfig("test", "t1")
+	assert.Nil(t, err)
+	from := tconf.Partitions[0].Backend
+	to := "backend1"
+	if from == to {
+		to = "backend2"
+	}
+
+	// Start errors.
+	{
+		_, err := jobs.Start("test", "t1_0000", from, from)
+		assert.NotNil(t, err)
+		_, err = jobs.Start("test", "t1_0000", to, from)
+		assert.NotNil(t, err)
+		_, err = jobs.Start("xx", "t1_0000", from, to)
+		assert.NotNil(t, err)
+	}
+
+	// Target is not empty.
+	{
+		fakedbs.AddQuerys("select count(*) from test.t1_0000", mockShiftCount("1"))
+		id, err := jobs.Start("test", "t1_0000", from, to)
+		assert.Nil(t, err)
+		job := waitShiftJob(t, jobs, id)
+		assert.Equal(t, ShiftJobFailed, job.State)
+		assert.Equal(t, "shift.table[test.t1_0000].on["+to+"].is.not.empty", job.Error)
+		assert.Equal(t, from, tconf.Partitions[0].Backend)
+	}
+
+	// Catch up.
+	{
+		fakedbs.AddQuerys("select count(*) from test.t1_0000", mockShiftCount("0"), mockShiftCount("2"), mockShiftCount("1"), mockShiftCount("2"), mockShiftCount("2"), mockShiftCount("2"), mockShiftCount("2"))
+		id, err := jobs.Start("test", "t1_0000", from, to)
+		assert.Nil(t, err)
+		job := waitShiftJob(t, jobs, id)
+		assert.Equal(t, ShiftJobDone, job.State)
+		assert.Equal(t, uint64(4), job.Rows)
+
+		tconf, err := route.TableConfig("test", "t1")
+		assert.Nil(t, err)
+		assert.Equal(t, to, tconf.Partitions[0].Backend)
+		assert.Nil(t, route.CheckWritable("test", "t1"))
+	}
+
+	// Shift back.
+	{
+		fakedbs.AddQuerys("select count(*) from test.t1_0000", mockShiftCount("0"), mockShiftCount("2"), mockShiftCount("2"), mockShiftCount("2"), mockShiftCount("2"))
+		id, err := jobs.Start("test", "t1_0000", to, from)
+		assert.Nil(t, err)
+		job := waitShiftJob(t, jobs, id)
+		assert.Equal(t, ShiftJobDone, job.State)
+		assert.Equal(t, uint64(2), job.Rows)
+
+		tconf, err := route.TableConfig("test", "t1")
+		assert.Nil(t, err)
+		assert.Equal(t, from, tconf.Partitions[0].Backend)
+		assert.Equal(t, 3, len(jobs.Jobs()))
+	}
+}
+
+func TestShiftJobTableGroup(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	fakedbs, proxy, cleanup := MockProxy(log)
+	defer cleanup()
+	address := proxy.Address()
+	route := proxy.Router()
+	jobs := proxy.Spanner().ShiftJobs()
+
+	r1 := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "Table",
+				Type: querypb.Type_VARCHAR,
+			},
+			{
+				Name: "Create Table",
+				Type: querypb.Type_VARCHAR,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("t")),
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("CREATE TABLE `t` (`id` int) ENGINE=InnoDB")),
+			},
+		},
+	}
+	r2 := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "id",
+				Type: querypb.Type_INT32,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("1")),
+			},
+		},
+	}
+	r3 := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "Table",
+				Type: querypb.Type_VARCHAR,
+			},
+			{
+				Name: "Checksum",
+				Type: querypb.Type_INT64,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("t")),
+				sqltypes.MakeTrusted(querypb.Type_INT64, []byte("1024")),
+			},
+		},
+	}
+
+	// fakedbs.
+	{
+		fakedbs.AddQueryPattern("create .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("SHOW CREATE TABLE .*", r1)
+		fakedbs.AddQueryPattern("CREATE TABLE `test`.*", &sqltypes.Result{})
+		fakedbs.AddQueryStream("select * from test.t1_0000", r2)
+		fakedbs.AddQueryStream("select * from test.t2_0000", r2)
+		fakedbs.AddQueryPattern("INSERT INTO .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("DROP TABLE IF EXISTS .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("CHECKSUM TABLE .*", r3)
+		fakedbs.AddQuerys("select count(*) from test.t1_0000", mockShiftCount("0"))
+		fakedbs.AddQuerys("select count(*) from test.t2_0000", mockShiftCount("0"))
+		// The tables are synced by the chunks of the primary key.
+		fakedbs.AddQueryPattern("SHOW KEYS FROM .*", mockReshardColumn("Column_name", "id"))
+		fakedbs.AddQueryPattern("SHOW COLUMNS FROM .*", mockReshardColumn("Field", "id"))
+		fakedbs.AddQueryPattern("SELECT `id` FROM .* LIMIT 1 OFFSET 999", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("SELECT COUNT\\(\\*\\), COALESCE.*", mockReshardChecksum("1", "7"))
+	}
+
+	// create database.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "create database test"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	// create test tables.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "create table test.t1(id int) partition by hash(id) tablegroup g1"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		query = "create table test.t2(id int) partition by hash(id) tablegroup g1"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	tconf, err := route.TableConfig("test", "t2")
+	assert.Nil(t, err)
+	from := tconf.Partitions[0].Backend
+	to := "backend1"
+	if from == to {
+		to = "backend2"
+	}
+
+	id, err := jobs.Start("test", "t2_0000", from, to)
+	assert.Nil(t, err)
+	job := waitShiftJob(t, jobs, id)
+	assert.Equal(t, ShiftJobDone, job.State)
+	assert.Equal(t, uint64(2), job.Rows)
+
+	for _, table := range []string{"t1", "t2"} {
+		tconf, err := route.TableConfig("test", table)
+		assert.Nil(t, err)
+		assert.Equal(t, to, tconf.Partitions[0].Backend)
+		assert.Nil(t, route.CheckWritable("test", table))
+	}
+	assert.Equal(t, 1, fakedbs.GetQueryCalledNum("DROP TABLE IF EXISTS test.t1_0000"))
+
+	// The rows mismatch after the final catchup, the rule isn't shifted.
+	{
+		fakedbs.AddQuerys("select count(*) from test.t1_0000", mockShiftCount("0"), mockShiftCount("1"), mockShiftCount("0"))
+		fakedbs.AddQuerys("select count(*) from test.t2_0000", mockShiftCount("0"), mockShiftCount("1"), mockShiftCount("0"))
+		id, err := jobs.Start("test", "t2_0000", to, from)
+		assert.Nil(t, err)
+		job := waitShiftJob(t, jobs, id)
+		assert.Equal(t, ShiftJobFailed, job.State)
+		assert.Equal(t, "verify", job.Step)
+		assert.Equal(t, "shift.verify.rows.from[1].to[0].mismatch", job.Error)
+
+		for _, table := range []string{"t1", "t2"} {
+			tconf, err := route.TableConfig("test", table)
+			assert.Nil(t, err)
+			assert.Equal(t, to, tconf.Partitions[0].Backend)
+			assert.Nil(t, route.CheckWritable("test", table))
+		}
+	}
+}
@@ -14,10 +14,12 @@ import (
 	"fmt"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"build"
+	"config"
 
 	"github.com/xelabs/go-mysqlstack/driver"
 	"github.com/xelabs/go-mysqlstack/sqldb"
@@ -210,13 +212,13 @@ func (spanner *Spanner) handleShowCreateTable(session *driver.Session, query str
 	var qr *sqltypes.Result
 	var err error
 
-	shardKey, err := router.ShardKey(database, table)
+	tconf, err := router.TableConfig(database, table)
 	if err != nil {
 		return nil, err
 	}
 
-	// If shardType is GLOBAL, send the query with database; if shardType is HASH, rewrite the query.
-	if shardKey == "" {
+	// If shardType is GLOBAL, send the query with database; otherwise rewrite the query.
+	if tconf.ShardType == "GLOBAL" {
 		// If the elapsed > pool.maxIdleTime, the new connection without database, add the database.
 		rewritten := fmt.Sprintf("SHOW CREATE TABLE %s.%s", database, table)
 		qr, err = spanner.ExecuteSingle(rewritten)
@@ -248,8 +250,7 @@ func (spanner *Spanner) handleShowCreateTable(session *driver.Session, query str
 		// Add partition info to the end of c2Val
 		c2Buf := common.NewBuffer(0)
 		c2Buf.WriteString(c2Val)
-		partInfo := fmt.Sprintf("\n/*!50100 PARTITION BY HASH (%s) */", shardKey)
-		c2Buf.WriteString(partInfo)
+		c2Buf.WriteString(partitionInfo(tconf))
 
 		qr.Rows[0][0] = sqltypes.MakeTrusted(c1.Type(), []byte(c1Val))
 		qr.Rows[0][1] = sqltypes.MakeTrusted(c2.Type(), c2Buf.Datas())
@@ -526,3 +527,50 @@ func (spanner *Spanner) handleShowVersions(session *driver.Session, query string
 func (spanner *Spanner) handleJDBCShows(session *driver.Session, query string, node sqlparser.Statement) (*sqltypes.Result, error) {
 	return spanner.ExecuteSingle(query)
 }
+
+// partitionInfo returns the partition clause of the 'SHOW CREATE TABLE'.
+func partitionInfo(tconf *config.TableConfig) string {
+	switch tconf.ShardType {
+	case "RANGE":
+		defs := make([]string, 0, len(tconf.Partitions))
+		for _, part := range tconf.Partitions {
+			bound := part.Segment
+			if strings.ToUpper(bound) == "MAXVALUE" {
+				bound = "MAXVALUE"
+			} else {
+				if _, err := strconv.ParseInt(bound, 10, 64); err != nil {
+					bound = fmt.Sprintf("'%s'", bound)
+				}
+				bound = fmt.Sprintf("(%s)", bound)
+			}
+			defs = append(defs, fmt.Sprintf("PARTITION %s VALUES LESS THAN %s", part.Backend, bound))
+		}
+		return fmt.Sprintf("\n/*!50100 PARTITION BY RANGE (%s)\n(%s) */", tconf.ShardKey, strings.Join(defs, ",\n "))
+	case "LIST":
+		defs := make([]string, 0, len(tconf.Partitions))
+		for _, part := range tconf.Partitions {
+			if strings.ToUpper(part.Segment) == "DEFAULT" {
+				defs = append(defs, fmt.Sprintf("PARTITION %s DEFAULT", part.Backend))
+				continue
+			}
+			values := make([]string, 0, len(part.Values))
+			for _, v := range part.Values {
+				if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+					v = fmt.Sprintf("'%s'", v)
+				}
+				values = append(values, v)
+			}
+			defs = append(defs, fmt.Sprintf("PARTITION %s VALUES IN (%s)", part.Backend, strings.Join(values, ",")))
+		}
+		return fmt.Sprintf("\n/*!50100 PARTITION BY LIST (%s)\n(%s) */", tconf.ShardKey, strings.Join(defs, ",\n "))
+	case "TIME":
+		return fmt.Sprintf("\n/*!50100 PARTITION BY %s (%s) */", tconf.Interval, tconf.ShardKey)
+	case "SINGLE":
+		return fmt.Sprintf("\n/*!50100 PARTITION BY SINGLE ON %s */", tconf.Partitions[0].Backend)
+	default:
+		if tconf.HashFunc != "" {
+			return fmt.Sprintf("\n/*!50100 PARTITION BY HASH (%s) HASHFUNC %s */", tconf.ShardKey, tconf.HashFunc)
+		}
+		return fmt.Sprintf("\n/*!50100 PARTITION BY HASH (%s) */", tconf.ShardKey)
+	}
+}
@@ -425,6 +425,101 @@ func TestProxyShowCreateTable(t *testing.T) {
 		},
 	}
 
+	r3 := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "table",
+				Type: querypb.Type_VARCHAR,
+			},
+			{
+				Name: "create table",
+				Type: querypb.Type_VARCHAR,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("r_t1_0000")),
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("create table r_t1_0000")),
+			},
+		},
+	}
+
+	r4 := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "table",
+				Type: querypb.Type_VARCHAR,
+			},
+			{
+				Name: "create table",
+				Type: querypb.Type_VARCHAR,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("l_t1_0000")),
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("create table l_t1_0000")),
+			},
+		},
+	}
+
+	r5 := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "table",
+				Type: querypb.Type_VARCHAR,
+			},
+			{
+				Name: "create table",
+				Type: querypb.Type_VARCHAR,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("tm_t1")),
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("create table tm_t1")),
+			},
+		},
+	}
+
+	r6 := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "table",
+				Type: querypb.Type_VARCHAR,
+			},
+			{
+				Name: "create table",
+				Type: querypb.Type_VARCHAR,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("s_t1")),
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("create table s_t1")),
+			},
+		},
+	}
+
+	r7 := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "table",
+				Type: querypb.Type_VARCHAR,
+			},
+			{
+				Name: "create table",
+				Type: querypb.Type_VARCHAR,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("h_t1_0000")),
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("create table h_t1_0000")),
+			},
+		},
+	}
+
 	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
 	fakedbs, proxy, cleanup := MockProxy(log)
 	defer cleanup()
@@ -440,6 +535,11 @@ func TestProxyShowCreateTable(t *testing.T) {
 		fakedbs.AddQuerys("show create table MYSQL.t1", r1)
 		fakedbs.AddQuerys("show create table xxx.t1", r1)
 		fakedbs.AddQuerys("show create table test.g_t1", r2)
+		fakedbs.AddQuerys("show create table test.r_t1_0000", r3)
+		fakedbs.AddQuerys("show create table test.l_t1_0000", r4)
+		fakedbs.AddQueryPattern("show create table test.tm_t1_.*", r5)
+		fakedbs.AddQuerys("show create table test.s_t1", r6)
+		fakedbs.AddQuerys("show create table test.h_t1_0000", r7)
 	}
 
 	// create database.
@@ -474,6 +574,121 @@ func TestProxyShowCreateTable(t *testing.T) {
 		assert.Equal(t, want, got)
 	}
 
+	// create test table with range.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "test", "utf8")
+		assert.Nil(t, err)
+		query := "create table r_t1(id int, b int) partition by range(id) (partition backend0 values less than (100), partition backend1 values less than maxvalue)"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		client.Quit()
+	}
+
+	// show create table which shardType is range.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		defer client.Close()
+		query := "show create table test.r_t1"
+		qr, err := client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		want := "[r_t1 create table r_t1\n/*!50100 PARTITION BY RANGE (id)\n(PARTITION backend0 VALUES LESS THAN (100),\n PARTITION backend1 VALUES LESS THAN MAXVALUE) */]"
+		got := fmt.Sprintf("%+v", qr.Rows[0])
+		assert.Equal(t, want, got)
+	}
+
+	// create test table with list.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "test", "utf8")
+		assert.Nil(t, err)
+		query := "create table l_t1(id int, b varchar(10)) partition by list(b) (partition backend0 values in ('cn', 1), partition backend1 default)"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		client.Quit()
+	}
+
+	// show create table which shardType is list.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		defer client.Close()
+		query := "show create table test.l_t1"
+		qr, err := client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		want := "[l_t1 create table l_t1\n/*!50100 PARTITION BY LIST (b)\n(PARTITION backend0 VALUES IN ('cn',1),\n PARTITION backend1 DEFAULT) */]"
+		got := fmt.Sprintf("%+v", qr.Rows[0])
+		assert.Equal(t, want, got)
+	}
+
+	// create test table with time.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "test", "utf8")
+		assert.Nil(t, err)
+		query := "create table tm_t1(id int, dt datetime) partition by month(dt)"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		client.Quit()
+	}
+
+	// show create table which shardType is time.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		defer client.Close()
+		query := "show create table test.tm_t1"
+		qr, err := client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		want := "[tm_t1 create table tm_t1\n/*!50100 PARTITION BY MONTH (dt) */]"
+		got := fmt.Sprintf("%+v", qr.Rows[0])
+		assert.Equal(t, want, got)
+	}
+
+	// create test table with single.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "test", "utf8")
+		assert.Nil(t, err)
+		query := "create table s_t1(id int, b int) partition by single on backend1"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		client.Quit()
+	}
+
+	// show create table which shardType is single.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		defer client.Close()
+		query := "show create table test.s_t1"
+		qr, err := client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		want := "[s_t1 create table s_t1\n/*!50100 PARTITION BY SINGLE ON backend1 */]"
+		got := fmt.Sprintf("%+v", qr.Rows[0])
+		assert.Equal(t, want, got)
+	}
+
+	// create test table with hash function.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "test", "utf8")
+		assert.Nil(t, err)
+		query := "create table h_t1(id int, b int) partition by hash(id) hashfunc crc32"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		client.Quit()
+	}
+
+	// show create table which hash function is not default.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		defer client.Close()
+		query := "show create table test.h_t1"
+		qr, err := client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		want := "[h_t1 create table h_t1\n/*!50100 PARTITION BY HASH (id) HASHFUNC crc32 */]"
+		got := fmt.Sprintf("%+v", qr.Rows[0])
+		assert.Equal(t, want, got)
+	}
+
 	// create test table with global.
 	{
 		client, err := driver.NewConn("mock", "mock", address, "test", "utf8")
@@ -9,16 +9,21 @@
 package proxy
 
 import (
+	"time"
+
 	"audit"
 	"backend"
 	"config"
 	"monitor"
+	"optimizer"
 	"plugins"
 	"router"
+	"syncer"
 	"xbase"
 	"xbase/sync2"
 
 	"github.com/xelabs/go-mysqlstack/driver"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
 	"github.com/xelabs/go-mysqlstack/xlog"
 )
 
@@ -29,31 +34,65 @@ type Spanner struct {
 	conf          *config.Config
 	router        *router.Router
 	scatter       *backend.Scatter
+	syncer        *syncer.Syncer
 	sessions      *Sessions
 	iptable       *IPTable
 	throttle      *xbase.Throttle
 	plugins       *plugins.Plugin
 	diskChecker   *DiskCheck
+	timeChecker   *TimePartitionCheck
+	shiftJobs     *ShiftJobs
 	manager       *Manager
+	stats         *optimizer.Statistics
+	plans         *optimizer.PlanCache
 	readonly      sync2.AtomicBool
 	serverVersion string
 }
 
 // NewSpanner creates a new spanner.
 func NewSpanner(log *xlog.Log, conf *config.Config,
-	iptable *IPTable, router *router.Router, scatter *backend.Scatter, sessions *Sessions, audit *audit.Audit, throttle *xbase.Throttle, plugins *plugins.Plugin, serverVersion string) *Spanner {
-	return &Spanner{
+	iptable *IPTable, router *router.Router, scatter *backend.Scatter, syncer *syncer.Syncer, sessions *Sessions, audit *audit.Audit, throttle *xbase.Throttle, plugins *plugins.Plugin, serverVersion string) *Spanner {
+	spanner := &Spanner{
 		log:           log,
 		conf:          conf,
 		audit:         audit,
 		iptable:       iptable,
 		router:        router,
 		scatter:       scatter,
+		syncer:        syncer,
 		sessions:      sessions,
 		throttle:      throttle,
 		plugins:       plugins,
 		serverVersion: serverVersion,
 	}
+	spanner.stats = optimizer.NewStatistics(log, router, spanner.ExecuteOnThisBackend, time.Duration(conf.Proxy.StatsTTL)*time.Second)
+	if conf.Proxy.PlanCacheSize > 0 {
+		spanner.plans = optimizer.NewPlanCache(conf.Proxy.PlanCacheSize)
+	}
+	return spanner
+}
+
+// newOptimizer returns the optimizer of the config, the cost optimizer or the simple one.
+// The plans are reused by the plan cache if it's enabled.
+func (spanner *Spanner) newOptimizer(database string, query string, node sqlparser.Statement) optimizer.Optimizer {
+	name := spanner.conf.Proxy.Optimizer
+	build := func(query string, node sqlparser.Statement) optimizer.Optimizer {
+		if name == "cost" {
+			return optimizer.NewCostOptimizer(spanner.log, database, query, node, spanner.router, spanner.stats)
+		}
+		return optimizer.NewSimpleOptimizer(spanner.log, database, query, node, spanner.router)
+	}
+	if spanner.plans == nil {
+		return build(query, node)
+	}
+	return optimizer.NewCachedOptimizer(spanner.log, database, query, node, spanner.router, spanner.plans, name, build)
+}
+
+// clearPlans used to remove the cached plans.
+func (spanner *Spanner) clearPlans() {
+	if spanner.plans != nil {
+		spanner.plans.Clear()
+	}
 }
 
 // Init used to init the async worker.
@@ -67,6 +106,13 @@ func (spanner *Spanner) Init() error {
 	}
 	spanner.diskChecker = diskChecker
 
+	timeChecker := NewTimePartitionCheck(log, spanner.router, spanner.scatter, spanner.syncer.IsOwner)
+	if err := timeChecker.Init(); err != nil {
+		return err
+	}
+	spanner.timeChecker = timeChecker
+	spanner.shiftJobs = NewShiftJobs(log, spanner.router, spanner.scatter, conf.Proxy.StreamBufferSize)
+
 	mgr := NewManager(log, spanner.sessions, conf.Proxy)
 	if err := mgr.Init(); err != nil {
 		return err
@@ -78,11 +124,18 @@ func (spanner *Spanner) Init() error {
 // Close used to close spanner.
 func (spanner *Spanner) Close() error {
 	spanner.diskChecker.Close()
+	spanner.timeChecker.Close()
+	spanner.shiftJobs.Close()
 	spanner.manager.Close()
 	spanner.log.Info("spanner.closed...")
 	return nil
 }
 
+// ShiftJobs returns the shift jobs.
+func (spanner *Spanner) ShiftJobs() *ShiftJobs {
+	return spanner.shiftJobs
+}
+
 // ReadOnly returns the readonly or not.
 func (spanner *Spanner) ReadOnly() bool {
 	return spanner.readonly.Get()
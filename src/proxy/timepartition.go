@@ -0,0 +1,147 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package proxy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"backend"
+	"router"
+
+	"github.com/pkg/errors"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+// TimePartitionCheck tuple.
+// It pre-creates the future partitions of the TIME tables on the backends,
+// then adds them to the router.
+type TimePartitionCheck struct {
+	log     *xlog.Log
+	router  *router.Router
+	scatter *backend.Scatter
+	// isOwner returns true if this node creates the partitions, the others sync the router from it,
+	// nil means always.
+	isOwner func() bool
+	done    chan bool
+	ticker  *time.Ticker
+	wg      sync.WaitGroup
+}
+
+// NewTimePartitionCheck creates the TimePartitionCheck tuple.
+func NewTimePartitionCheck(log *xlog.Log, router *router.Router, scatter *backend.Scatter, isOwner func() bool) *TimePartitionCheck {
+	return &TimePartitionCheck{
+		log:     log,
+		router:  router,
+		scatter: scatter,
+		isOwner: isOwner,
+		done:    make(chan bool),
+		ticker:  time.NewTicker(time.Duration(time.Minute * 10)), // 10 minutes.
+	}
+}
+
+// Init used to init the time partition check goroutine.
+func (tc *TimePartitionCheck) Init() error {
+	log := tc.log
+
+	tc.wg.Add(1)
+	go func(tc *TimePartitionCheck) {
+		defer tc.wg.Done()
+		tc.check()
+	}(tc)
+	log.Info("time.partition.check.init.done")
+	return nil
+}
+
+// Close used to close the time partition check goroutine.
+func (tc *TimePartitionCheck) Close() {
+	close(tc.done)
+	tc.wg.Wait()
+}
+
+func (tc *TimePartitionCheck) check() {
+	defer tc.ticker.Stop()
+	tc.doCheck(time.Now())
+	for {
+		select {
+		case <-tc.ticker.C:
+			tc.doCheck(time.Now())
+		case <-tc.done:
+			return
+		}
+	}
+}
+
+func (tc *TimePartitionCheck) doCheck(now time.Time) {
+	log := tc.log
+	route := tc.router
+	if tc.isOwner != nil && !tc.isOwner() {
+		return
+	}
+	for db, tables := range route.Tables() {
+		for _, table := range tables {
+			tconf, err := route.TableConfig(db, table)
+			if err != nil || tconf.ShardType != "TIME" {
+				continue
+			}
+			if err := tc.extend(db, table, now); err != nil {
+				log.Error("time.partition.check.extend[%s.%s].error:%+v", db, table, err)
+			}
+		}
+	}
+}
+
+// extend creates the ahead partitions like the last one, and adds them to the router.
+func (tc *TimePartitionCheck) extend(db, table string, now time.Time) error {
+	log := tc.log
+	route := tc.router
+
+	parts, err := route.AheadTimePartitions(db, table, now)
+	if err != nil {
+		return err
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+
+	tconf, err := route.TableConfig(db, table)
+	if err != nil {
+		return err
+	}
+	last := tconf.Partitions[len(tconf.Partitions)-1]
+	qr, err := tc.execute(last.Backend, fmt.Sprintf("SHOW CREATE TABLE %s.%s", db, last.Table))
+	if err != nil {
+		return err
+	}
+	if len(qr.Rows) == 0 || len(qr.Rows[0]) < 2 {
+		return errors.Errorf("time.partition.show.create.table[%s.%s].result.is.null", db, last.Table)
+	}
+	create := string(qr.Rows[0][1].Raw())
+
+	for _, part := range parts {
+		query := createPartitionQuery(create, db, last.Table, part.Table, true)
+		log.Info("time.partition.check.create[%s.%s].on[%s]", db, part.Table, part.Backend)
+		if _, err := tc.execute(part.Backend, query); err != nil {
+			return err
+		}
+	}
+	return route.AddTimePartitions(db, table, parts)
+}
+
+// execute used to execute the query on the backend.
+func (tc *TimePartitionCheck) execute(backend string, query string) (*sqltypes.Result, error) {
+	txn, err := tc.scatter.CreateTransaction()
+	if err != nil {
+		return nil, err
+	}
+	defer txn.Finish()
+	return txn.ExecuteOnThisBackend(backend, query)
+}
@@ -0,0 +1,128 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package proxy
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/driver"
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+func TestTimePartitionCheck(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	fakedbs, proxy, cleanup := MockProxy(log)
+	defer cleanup()
+	address := proxy.Address()
+
+	now := time.Now()
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	last := fmt.Sprintf("t1_%s", month.AddDate(0, 2, 0).Format("200601"))
+	r1 := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{
+				Name: "Table",
+				Type: querypb.Type_VARCHAR,
+			},
+			{
+				Name: "Create Table",
+				Type: querypb.Type_VARCHAR,
+			},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte(last)),
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte(fmt.Sprintf("CREATE TABLE `%s` (`dt` datetime) ENGINE=InnoDB", last))),
+			},
+		},
+	}
+
+	// fakedbs.
+	{
+		fakedbs.AddQueryPattern("use .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("create .*", &sqltypes.Result{})
+		fakedbs.AddQuery(fmt.Sprintf("SHOW CREATE TABLE test.%s", last), r1)
+		fakedbs.AddQueryPattern("CREATE TABLE IF NOT EXISTS .*", &sqltypes.Result{})
+	}
+
+	// create database.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "create database test"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	// create table.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "test", "utf8")
+		assert.Nil(t, err)
+		query := "create table t1(id int, dt datetime) partition by month(dt)"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+
+		// Backfill the past 6 months for the older data.
+		query = "create table t2(id int, dt datetime) partition by month(dt) backfill 6"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		client.Quit()
+	}
+
+	route := proxy.Router()
+	tc := NewTimePartitionCheck(log, route, proxy.Scatter(), nil)
+
+	{
+		segments, err := route.Lookup("test", "t2", nil, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 9, len(segments))
+		assert.Equal(t, fmt.Sprintf("t2_%s", month.AddDate(0, -6, 0).Format("200601")), segments[0].Table)
+	}
+
+	// Covered.
+	{
+		tc.doCheck(now)
+		segments, err := route.Lookup("test", "t1", nil, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(segments))
+	}
+
+	// The node isn't the owner, the partitions are created by the owner.
+	{
+		other := NewTimePartitionCheck(log, route, proxy.Scatter(), func() bool { return false })
+		other.doCheck(month.AddDate(0, 2, 0))
+		segments, err := route.Lookup("test", "t1", nil, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(segments))
+	}
+
+	// Extend 2 months.
+	{
+		tc.doCheck(month.AddDate(0, 2, 0))
+		segments, err := route.Lookup("test", "t1", nil, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 5, len(segments))
+		assert.Equal(t, fmt.Sprintf("t1_%s", month.AddDate(0, 4, 0).Format("200601")), segments[4].Table)
+	}
+
+	// Show create table failed.
+	{
+		fakedbs.AddQueryError(fmt.Sprintf("SHOW CREATE TABLE test.t1_%s", month.AddDate(0, 4, 0).Format("200601")), fmt.Errorf("mock.show.create.table.error"))
+		err := tc.extend("test", "t1", month.AddDate(0, 5, 0))
+		assert.NotNil(t, err)
+		segments, err := route.Lookup("test", "t1", nil, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 5, len(segments))
+	}
+}
@@ -15,8 +15,10 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/xelabs/go-mysqlstack/driver"
-	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
 	"github.com/xelabs/go-mysqlstack/xlog"
+
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
 )
 
 func TestProxyUpdate(t *testing.T) {
@@ -59,3 +61,68 @@ func TestProxyUpdate(t *testing.T) {
 		assert.Nil(t, err)
 	}
 }
+
+func TestProxyUpdateJoin(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	fakedbs, proxy, cleanup := MockProxy(log)
+	defer cleanup()
+	address := proxy.Address()
+	proxy.SetTwoPC(true)
+
+	rows := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "id", Type: querypb.Type_INT32},
+			{Name: "b", Type: querypb.Type_INT32},
+		},
+		Rows: [][]sqltypes.Value{
+			{
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("1")),
+				sqltypes.MakeTrusted(querypb.Type_INT32, []byte("2")),
+			},
+		},
+	}
+
+	// fakedbs.
+	{
+		fakedbs.AddQueryPattern("XA .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("create .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("select g.id, t1.b from test.g join test.t1_.* as t1 .*", rows)
+		fakedbs.AddQueryPattern("update test.g set b = 2 where id = 1", &sqltypes.Result{RowsAffected: 1})
+		fakedbs.AddQueryPattern("update test.t1_.* as t1 join test.g .*", &sqltypes.Result{RowsAffected: 1})
+	}
+
+	// create database and tables.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		querys := []string{
+			"create database test",
+			"create table test.t1(id int, b int) partition by hash(id)",
+			"create table test.g(id int, b int)",
+		}
+		for _, query := range querys {
+			_, err = client.FetchAll(query, -1)
+			assert.Nil(t, err)
+		}
+	}
+
+	// The global table is updated by the joined rows on all the 5 backends.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "update test.g join test.t1 on g.id = t1.id set g.b = t1.b where t1.id = 1"
+		qr, err := client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(5), qr.RowsAffected)
+	}
+
+	// Pushed down to the partition.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "update test.t1 join test.g on t1.b = g.b set t1.b = g.id where t1.id = 1"
+		qr, err := client.FetchAll(query, -1)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(1), qr.RowsAffected)
+	}
+}
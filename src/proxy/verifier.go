@@ -0,0 +1,234 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package proxy
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"router"
+
+	"github.com/pkg/errors"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+const (
+	// VerifyMissing is the issue that the partition table doesn't exist on the assigned backend.
+	VerifyMissing = "missing"
+	// VerifyOrphan is the issue that the partition table is left on the backend which it isn't assigned to.
+	VerifyOrphan = "orphan"
+	// VerifyColumnMismatch is the issue that the columns of the partition differ from the others.
+	VerifyColumnMismatch = "column-mismatch"
+)
+
+// partitionNameRegexp matches the partition table name generated by radon, such as t1_0001.
+var partitionNameRegexp = regexp.MustCompile(`^(.+)_[0-9]{4,}$`)
+
+// VerifyIssue tuple.
+type VerifyIssue struct {
+	Type      string `json:"type"`
+	Database  string `json:"database"`
+	Table     string `json:"table"`
+	Partition string `json:"partition"`
+	Backend   string `json:"backend"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+// VerifyReport tuple.
+type VerifyReport struct {
+	Tables     int            `json:"tables"`
+	Partitions int            `json:"partitions"`
+	Issues     []*VerifyIssue `json:"issues"`
+	StartTime  time.Time      `json:"start-time"`
+	EndTime    time.Time      `json:"end-time"`
+}
+
+// Verifier tuple.
+// It checks the router against the backends:
+// 1. the partition table exists on the assigned backend.
+// 2. no partition table is left on the other backends.
+// 3. the columns of all the partitions of a table are same.
+type Verifier struct {
+	log     *xlog.Log
+	router  *router.Router
+	spanner *Spanner
+}
+
+// NewVerifier creates the Verifier tuple.
+func NewVerifier(log *xlog.Log, router *router.Router, spanner *Spanner) *Verifier {
+	return &Verifier{
+		log:     log,
+		router:  router,
+		spanner: spanner,
+	}
+}
+
+// physicalTable is the table on the backend.
+type physicalTable struct {
+	database string
+	table    string
+}
+
+// Verify walks all the partitions of the tables in the database, the empty database means all.
+func (v *Verifier) Verify(database string) (*VerifyReport, error) {
+	log := v.log
+	route := v.router
+	report := &VerifyReport{Issues: make([]*VerifyIssue, 0, 8), StartTime: time.Now()}
+
+	all := route.Tables()
+	dbs := make([]string, 0, len(all))
+	for db := range all {
+		if database == "" || db == database {
+			dbs = append(dbs, db)
+		}
+	}
+	if database != "" && len(dbs) == 0 {
+		return nil, errors.Errorf("verify.database[%s].cant.found", database)
+	}
+	sort.Strings(dbs)
+
+	// The columns of the tables on each backend.
+	backends := v.spanner.scatter.Backends()
+	columns := make(map[string]map[physicalTable]string, len(backends))
+	for _, b := range backends {
+		cols, err := v.columns(b, dbs)
+		if err != nil {
+			log.Error("verify.backend[%s].fetch.columns.error:%+v", b, err)
+			return nil, err
+		}
+		columns[b] = cols
+	}
+
+	// owners is the logic table of the partition table, used to find the orphans.
+	owners := make(map[physicalTable]string)
+	assigned := make(map[string]map[physicalTable]bool, len(backends))
+	for _, b := range backends {
+		assigned[b] = make(map[physicalTable]bool)
+	}
+	for _, db := range dbs {
+		tables := all[db]
+		sort.Strings(tables)
+		for _, table := range tables {
+			tconf, err := route.TableConfig(db, table)
+			if err != nil {
+				return nil, err
+			}
+			report.Tables++
+
+			var want string
+			var wantPart string
+			for _, part := range tconf.Partitions {
+				report.Partitions++
+				phy := physicalTable{db, part.Table}
+				owners[phy] = table
+				if _, ok := assigned[part.Backend]; ok {
+					assigned[part.Backend][phy] = true
+				}
+
+				cols, ok := columns[part.Backend][phy]
+				if !ok {
+					report.add(VerifyMissing, db, table, part.Table, part.Backend, "")
+					continue
+				}
+				switch {
+				case wantPart == "":
+					want, wantPart = cols, part.Table
+				case cols != want:
+					detail := fmt.Sprintf("columns[%s].differ.from.%s[%s]", cols, wantPart, want)
+					report.add(VerifyColumnMismatch, db, table, part.Table, part.Backend, detail)
+				}
+			}
+		}
+	}
+
+	// Orphans.
+	for _, b := range backends {
+		phys := make([]physicalTable, 0, len(columns[b]))
+		for phy := range columns[b] {
+			phys = append(phys, phy)
+		}
+		sort.Slice(phys, func(i, j int) bool {
+			if phys[i].database != phys[j].database {
+				return phys[i].database < phys[j].database
+			}
+			return phys[i].table < phys[j].table
+		})
+		for _, phy := range phys {
+			if assigned[b][phy] {
+				continue
+			}
+			owner, ok := owners[phy]
+			if !ok {
+				// The table like t1_0032 left by the failed split is also an orphan,
+				// even if the logic table is dropped.
+				matches := partitionNameRegexp.FindStringSubmatch(phy.table)
+				if matches == nil {
+					continue
+				}
+				owner = matches[1]
+			}
+			report.add(VerifyOrphan, phy.database, owner, phy.table, b, "")
+		}
+	}
+	report.EndTime = time.Now()
+	log.Info("verify.done.tables[%d].partitions[%d].issues[%d]", report.Tables, report.Partitions, len(report.Issues))
+	return report, nil
+}
+
+// add appends the issue to the report.
+func (r *VerifyReport) add(typ, database, table, partition, backend, detail string) {
+	r.Issues = append(r.Issues, &VerifyIssue{
+		Type:      typ,
+		Database:  database,
+		Table:     table,
+		Partition: partition,
+		Backend:   backend,
+		Detail:    detail,
+	})
+}
+
+// columns returns the column definitions of the tables in the databases on the backend,
+// the definitions are joined as 'name type nullable, ...' in the ordinal order.
+func (v *Verifier) columns(backend string, dbs []string) (map[physicalTable]string, error) {
+	cols := make(map[physicalTable]string)
+	if len(dbs) == 0 {
+		return cols, nil
+	}
+
+	quoted := make([]string, 0, len(dbs))
+	for _, db := range dbs {
+		quoted = append(quoted, fmt.Sprintf("'%s'", db))
+	}
+	query := fmt.Sprintf("SELECT TABLE_SCHEMA, TABLE_NAME, COLUMN_NAME, COLUMN_TYPE, IS_NULLABLE FROM information_schema.COLUMNS WHERE TABLE_SCHEMA IN (%s) ORDER BY TABLE_SCHEMA, TABLE_NAME, ORDINAL_POSITION", strings.Join(quoted, ","))
+	qr, err := v.spanner.ExecuteOnThisBackend(backend, query)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range qr.Rows {
+		if len(row) < 5 {
+			return nil, errors.Errorf("verify.backend[%s].columns.result.malformed", backend)
+		}
+		phy := physicalTable{database: row[0].ToString(), table: row[1].ToString()}
+		def := fmt.Sprintf("%s %s %s", row[2].ToString(), row[3].ToString(), row[4].ToString())
+		if prev, ok := cols[phy]; ok {
+			def = prev + ", " + def
+		}
+		cols[phy] = def
+	}
+	return cols, nil
+}
+
+// Verify used to check the consistency between the router and the backends.
+func (spanner *Spanner) Verify(database string) (*VerifyReport, error) {
+	verifier := NewVerifier(spanner.log, spanner.router, spanner)
+	return verifier.Verify(database)
+}
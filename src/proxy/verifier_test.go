@@ -0,0 +1,146 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package proxy
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/driver"
+	querypb "github.com/xelabs/go-mysqlstack/sqlparser/depends/query"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/sqltypes"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+const verifyColumnsQuery = "select table_schema, table_name, column_name, column_type, is_nullable from information_schema.columns where table_schema in ('test') order by table_schema, table_name, ordinal_position"
+
+func mockVerifyColumnsResult(tables []string, typ string) *sqltypes.Result {
+	qr := &sqltypes.Result{
+		Fields: []*querypb.Field{
+			{Name: "TABLE_SCHEMA", Type: querypb.Type_VARCHAR},
+			{Name: "TABLE_NAME", Type: querypb.Type_VARCHAR},
+			{Name: "COLUMN_NAME", Type: querypb.Type_VARCHAR},
+			{Name: "COLUMN_TYPE", Type: querypb.Type_VARCHAR},
+			{Name: "IS_NULLABLE", Type: querypb.Type_VARCHAR},
+		},
+	}
+	for _, table := range tables {
+		for _, col := range []string{"id", "b"} {
+			qr.Rows = append(qr.Rows, []sqltypes.Value{
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("test")),
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte(table)),
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte(col)),
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte(typ)),
+				sqltypes.MakeTrusted(querypb.Type_VARCHAR, []byte("YES")),
+			})
+		}
+	}
+	return qr
+}
+
+func TestVerifier(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	fakedbs, proxy, cleanup := MockProxy(log)
+	defer cleanup()
+	address := proxy.Address()
+
+	// fakedbs.
+	{
+		fakedbs.AddQueryPattern("use .*", &sqltypes.Result{})
+		fakedbs.AddQueryPattern("create .*", &sqltypes.Result{})
+	}
+
+	// create database.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "", "utf8")
+		assert.Nil(t, err)
+		query := "create database test"
+		_, err = client.FetchAll(query, -1)
+		assert.Nil(t, err)
+	}
+
+	// create tables.
+	{
+		client, err := driver.NewConn("mock", "mock", address, "test", "utf8")
+		assert.Nil(t, err)
+		for _, query := range []string{
+			"create table t1(id int, b int) partition by hash(id)",
+			"create table g1(id int, b int)",
+		} {
+			_, err = client.FetchAll(query, -1)
+			assert.Nil(t, err)
+		}
+		client.Quit()
+	}
+
+	route := proxy.Router()
+	tconf, err := route.TableConfig("test", "t1")
+	assert.Nil(t, err)
+	backends := proxy.Scatter().Backends()
+	assigned := make(map[string][]string)
+	for _, part := range tconf.Partitions {
+		assigned[part.Backend] = append(assigned[part.Backend], part.Table)
+	}
+
+	// The backends are queried in order.
+	var missing, orphan, mismatch string
+	var results []*sqltypes.Result
+	for i, b := range backends {
+		tables := append([]string{"g1"}, assigned[b]...)
+		switch i {
+		case 0:
+			// One partition is missing.
+			missing = tables[1]
+			tables = append(tables[:1], tables[2:]...)
+		case 1:
+			// The partition of the other backend, the one left by split, the one of the dropped table and the unrelated one.
+			orphan = assigned[backends[0]][0]
+			tables = append(tables, orphan, "t1_0099", "t1_bak", "t9_0001", "other")
+		}
+		qr := mockVerifyColumnsResult(tables, "int(11)")
+		if i == 2 {
+			// The columns of one partition differ.
+			mismatch = tables[1]
+			qr.Rows = append(qr.Rows[:2], mockVerifyColumnsResult([]string{mismatch}, "bigint(20)").Rows...)
+			qr.Rows = append(qr.Rows, mockVerifyColumnsResult(tables[2:], "int(11)").Rows...)
+		}
+		results = append(results, qr)
+	}
+	fakedbs.AddQuerys(verifyColumnsQuery, results...)
+
+	// Verify.
+	{
+		report, err := proxy.Spanner().Verify("test")
+		assert.Nil(t, err)
+		assert.Equal(t, 2, report.Tables)
+		assert.Equal(t, len(tconf.Partitions)+len(backends), report.Partitions)
+
+		got := make([]string, 0, len(report.Issues))
+		for _, issue := range report.Issues {
+			got = append(got, strings.Join([]string{issue.Type, issue.Table, issue.Partition, issue.Backend}, ":"))
+		}
+		want := []string{
+			"missing:t1:" + missing + ":" + backends[0],
+			"column-mismatch:t1:" + mismatch + ":" + backends[2],
+			"orphan:t1:" + orphan + ":" + backends[1],
+			"orphan:t1:t1_0099:" + backends[1],
+			"orphan:t9:t9_0001:" + backends[1],
+		}
+		assert.Equal(t, want, got)
+		first := assigned[backends[0]][1]
+		assert.Equal(t, "columns[id bigint(20) YES, b bigint(20) YES].differ.from."+first+"[id int(11) YES, b int(11) YES]", report.Issues[1].Detail)
+	}
+
+	// Error.
+	{
+		_, err := proxy.Spanner().Verify("xx")
+		assert.Equal(t, "verify.database[xx].cant.found", err.Error())
+	}
+}
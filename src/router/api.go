@@ -9,6 +9,10 @@
 package router
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+
 	"config"
 
 	"github.com/pkg/errors"
@@ -52,7 +56,7 @@ func (r *Router) PartitionRuleShift(fromBackend string, toBackend string, databa
 	log := r.log
 
 	log.Warning("router.partition.rule.shift.from[%s].to[%s].database[%s].partitionTable[%s]", fromBackend, toBackend, database, partitionTable)
-	table, err := r.changeTheRuleBackend(fromBackend, toBackend, database, partitionTable)
+	tables, err := r.changeTheRuleBackend(fromBackend, toBackend, database, partitionTable)
 	if err != nil {
 		log.Error("router.partition.rule.shift.changeTheRuleBackend.error:%+v", err)
 		return err
@@ -60,9 +64,11 @@ func (r *Router) PartitionRuleShift(fromBackend string, toBackend string, databa
 	log.Warning("router.partition.rule.shift.change.the.rule.done")
 
 	log.Warning("router.partition.rule.shift.RefreshTable.prepare")
-	if err := r.RefreshTable(database, table); err != nil {
-		log.Panic("router.partition.rule.shift.RefreshTable.error:%+v", err)
-		return err
+	for _, table := range tables {
+		if err := r.RefreshTable(database, table); err != nil {
+			log.Panic("router.partition.rule.shift.RefreshTable.error:%+v", err)
+			return err
+		}
 	}
 	log.Warning("router.partition.rule.shift.RefreshTable.done")
 	return nil
@@ -70,10 +76,11 @@ func (r *Router) PartitionRuleShift(fromBackend string, toBackend string, databa
 
 //
 // 1. Find the table config and partition config.
-// 2. Change the backend.
+// 2. Change the backend, the co-located partitions of the table group are changed together.
 // 3. Write tableconfig to disk.
-func (r *Router) changeTheRuleBackend(fromBackend string, toBackend string, database string, partitionTable string) (string, error) {
-	var table string
+// Returns the tables changed.
+func (r *Router) changeTheRuleBackend(fromBackend string, toBackend string, database string, partitionTable string) ([]string, error) {
+	var table *Table
 	var tableConfig *config.TableConfig
 	var partitionConfig *config.PartitionConfig
 
@@ -82,12 +89,12 @@ func (r *Router) changeTheRuleBackend(fromBackend string, toBackend string, data
 	defer r.mu.RUnlock()
 
 	if fromBackend == toBackend {
-		return "", errors.Errorf("router.rule.change.from[%s].cant.equal.to[%s]", fromBackend, toBackend)
+		return nil, errors.Errorf("router.rule.change.from[%s].cant.equal.to[%s]", fromBackend, toBackend)
 	}
 
 	schema, ok := r.Schemas[database]
 	if !ok {
-		return "", errors.Errorf("router.rule.change.cant.found.database:%s", database)
+		return nil, errors.Errorf("router.rule.change.cant.found.database:%s", database)
 	}
 
 	// 1. Find the table config.
@@ -101,7 +108,7 @@ func (r *Router) changeTheRuleBackend(fromBackend string, toBackend string, data
 				log.Warning("router.rule[%s:%s].change.from[%s].to[%s].found:%+v", database, partitionTable, fromBackend, toBackend, partition)
 
 				found = true
-				table = v.Name
+				table = v
 				tableConfig = v.TableConfig
 				partitionConfig = partition
 				break
@@ -109,42 +116,342 @@ func (r *Router) changeTheRuleBackend(fromBackend string, toBackend string, data
 		}
 	}
 	if !found {
-		return "", errors.Errorf("router.rule.change.cant.found.backend[%s]+table:[%s]", fromBackend, partitionTable)
+		return nil, errors.Errorf("router.rule.change.cant.found.backend[%s]+table:[%s]", fromBackend, partitionTable)
 	}
 
 	// 2. Change the backend to to-backend.
 	if tableConfig.ShardType == "GLOBAL" {
 		for _, partition := range tableConfig.Partitions {
 			if partition.Backend == toBackend {
-				return "", errors.Errorf("the.table:[%s].already.exists.in.the.backend[%s]", partitionTable, toBackend)
+				return nil, errors.Errorf("the.table:[%s].already.exists.in.the.backend[%s]", partitionTable, toBackend)
 			}
 		}
 		partConf := &config.PartitionConfig{
-			Table:   table,
+			Table:   table.Name,
 			Backend: toBackend,
 		}
 		tableConfig.Partitions = append(tableConfig.Partitions, partConf)
-	} else {
-		partitionConfig.Backend = toBackend
-	}
 
-	// 3. Flush table config to disk.
-	if err := r.writeTableFrmData(database, table, tableConfig); err != nil {
-		// Memory config reset.
-		if tableConfig.ShardType == "GLOBAL" {
+		// 3. Flush table config to disk.
+		if err := r.writeTableFrmData(database, table.Name, tableConfig); err != nil {
+			// Memory config reset.
 			tableConfig.Partitions = append(tableConfig.Partitions[:(len(tableConfig.Partitions) - 1)])
-		} else {
-			partitionConfig.Backend = fromBackend
+			return nil, err
+		}
+	} else {
+		members := r.groupTables(database, table)
+		parts := make([]*config.PartitionConfig, 0, len(members))
+		for _, member := range members {
+			part, err := groupPartition(member, partitionConfig)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, part)
+		}
+		for _, part := range parts {
+			part.Backend = toBackend
+		}
+
+		// 3. Flush table configs to disk together.
+		names := make([]string, 0, len(members))
+		tconfs := make([]*config.TableConfig, 0, len(members))
+		for _, member := range members {
+			names = append(names, member.Name)
+			tconfs = append(tconfs, member.TableConfig)
+		}
+		if err := r.writeTablesFrmData(database, names, tconfs); err != nil {
+			// Memory config reset.
+			for _, part := range parts {
+				part.Backend = fromBackend
+			}
+			return nil, err
 		}
-		return "", err
 	}
 
+	// The backends of the partitions are changed in memory.
+	r.changed()
+
 	// 4. Update the version.
 	if err := config.UpdateVersion(r.metadir); err != nil {
 		log.Panicf("change.the.rule.table.update.version.error:%v", err)
-		return "", err
+		return nil, err
+	}
+
+	var tables []string
+	for _, member := range r.groupTables(database, table) {
+		tables = append(tables, member.Name)
+	}
+	return tables, nil
+}
+
+// findHashPartition returns the table and the partition config by the partition table name,
+// the table must be HASH.
+// Need RLock.
+func (r *Router) findHashPartition(database string, partitionTable string) (*Table, *config.PartitionConfig, error) {
+	schema, ok := r.Schemas[database]
+	if !ok {
+		return nil, nil, errors.Errorf("router.rule.cant.found.database:%s", database)
+	}
+	for _, table := range schema.Tables {
+		for _, partition := range table.TableConfig.Partitions {
+			if partition.Table == partitionTable {
+				if table.TableConfig.ShardType != methodTypeHash {
+					return nil, nil, errors.Errorf("router.rule.table[%s].shardtype[%s].must.be.HASH", table.Name, table.TableConfig.ShardType)
+				}
+				return table, partition, nil
+			}
+		}
+	}
+	return nil, nil, errors.Errorf("router.rule.cant.found.partition.table[%s]", partitionTable)
+}
+
+// parseHashSegment parses the hash segment 'start-end'.
+func parseHashSegment(segment string) (int, int, error) {
+	segs := strings.Split(segment, "-")
+	if len(segs) != 2 {
+		return 0, 0, errors.Errorf("router.rule.segment.malformed[%v]", segment)
 	}
-	return table, nil
+	start, err := strconv.Atoi(segs[0])
+	if err != nil {
+		return 0, 0, errors.Errorf("router.rule.segment.malformed[%v]", segment)
+	}
+	end, err := strconv.Atoi(segs[1])
+	if err != nil {
+		return 0, 0, errors.Errorf("router.rule.segment.malformed[%v]", segment)
+	}
+	return start, end, nil
+}
+
+// nextPartitionTables returns n new partition table names, such as 't_0064',
+// the number is greater than all the partitions of the table.
+func nextPartitionTables(tconf *config.TableConfig, n int) []string {
+	next := len(tconf.Partitions)
+	prefix := tconf.Name + "_"
+	for _, partition := range tconf.Partitions {
+		if strings.HasPrefix(partition.Table, prefix) {
+			if i, err := strconv.Atoi(strings.TrimPrefix(partition.Table, prefix)); err == nil && i >= next {
+				next = i + 1
+			}
+		}
+	}
+	names := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		names = append(names, fmt.Sprintf("%s%04d", prefix, next+i))
+	}
+	return names
+}
+
+// ReshardRule tuple.
+// The partitions Olds of the table are replaced by the News.
+type ReshardRule struct {
+	Table string
+	Olds  []string
+	News  []*config.PartitionConfig
+}
+
+// groupPartition returns the partition of the table with the same segment and backend,
+// it's used to find the co-located partitions in the table group.
+func groupPartition(table *Table, like *config.PartitionConfig) (*config.PartitionConfig, error) {
+	for _, partition := range table.TableConfig.Partitions {
+		if partition.Segment == like.Segment && partition.Backend == like.Backend {
+			return partition, nil
+		}
+	}
+	return nil, errors.Errorf("router.tablegroup[%s].table[%s].cant.found.the.partition.segment[%s].on[%s]", table.TableConfig.TableGroup, table.Name, like.Segment, like.Backend)
+}
+
+// PartitionRuleSplit returns the rules which split the slots of the partition in halves,
+// the new partitions are placed on the same backend as the old one.
+// The co-located partitions of the tables in the same table group are split together.
+// The router is not changed.
+func (r *Router) PartitionRuleSplit(database string, partitionTable string) ([]*ReshardRule, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	table, partition, err := r.findHashPartition(database, partitionTable)
+	if err != nil {
+		return nil, err
+	}
+	start, end, err := parseHashSegment(partition.Segment)
+	if err != nil {
+		return nil, err
+	}
+	if end-start < 2 {
+		return nil, errors.Errorf("router.rule.split.partition[%s].segment[%s].too.small", partitionTable, partition.Segment)
+	}
+	mid := start + (end-start)/2
+
+	var rules []*ReshardRule
+	for _, member := range r.groupTables(database, table) {
+		old, err := groupPartition(member, partition)
+		if err != nil {
+			return nil, err
+		}
+		names := nextPartitionTables(member.TableConfig, 2)
+		rules = append(rules, &ReshardRule{
+			Table: member.Name,
+			Olds:  []string{old.Table},
+			News: []*config.PartitionConfig{
+				{
+					Table:   names[0],
+					Segment: fmt.Sprintf("%d-%d", start, mid),
+					Backend: old.Backend,
+				},
+				{
+					Table:   names[1],
+					Segment: fmt.Sprintf("%d-%d", mid, end),
+					Backend: old.Backend,
+				},
+			},
+		})
+	}
+	return rules, nil
+}
+
+// PartitionRuleMerge returns the rules which merge the slots of the two adjacent partitions,
+// the new partition is placed on the backend of the lower one.
+// The co-located partitions of the tables in the same table group are merged together.
+// The router is not changed.
+func (r *Router) PartitionRuleMerge(database string, partitionTable1 string, partitionTable2 string) ([]*ReshardRule, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	table1, partition1, err := r.findHashPartition(database, partitionTable1)
+	if err != nil {
+		return nil, err
+	}
+	table2, partition2, err := r.findHashPartition(database, partitionTable2)
+	if err != nil {
+		return nil, err
+	}
+	if table1 != table2 || partition1 == partition2 {
+		return nil, errors.Errorf("router.rule.merge.partitions[%s,%s].must.belong.to.the.same.table", partitionTable1, partitionTable2)
+	}
+
+	start1, end1, err := parseHashSegment(partition1.Segment)
+	if err != nil {
+		return nil, err
+	}
+	start2, end2, err := parseHashSegment(partition2.Segment)
+	if err != nil {
+		return nil, err
+	}
+	if start2 < start1 {
+		partition1, partition2 = partition2, partition1
+		start1, end1, start2, end2 = start2, end2, start1, end1
+	}
+	if end1 != start2 {
+		return nil, errors.Errorf("router.rule.merge.partitions[%s,%s].must.be.adjacent", partitionTable1, partitionTable2)
+	}
+
+	var rules []*ReshardRule
+	for _, member := range r.groupTables(database, table1) {
+		old1, err := groupPartition(member, partition1)
+		if err != nil {
+			return nil, err
+		}
+		old2, err := groupPartition(member, partition2)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, &ReshardRule{
+			Table: member.Name,
+			Olds:  []string{old1.Table, old2.Table},
+			News: []*config.PartitionConfig{
+				{
+					Table:   nextPartitionTables(member.TableConfig, 1)[0],
+					Segment: fmt.Sprintf("%d-%d", start1, end2),
+					Backend: old1.Backend,
+				},
+			},
+		})
+	}
+	return rules, nil
+}
+
+// PartitionRuleReplace used to replace the partitions with the new ones atomically.
+// The processes as:
+// 1. build the new table configs and check the slots are all covered.
+// 2. flush the table configs to disk.
+// 3. switch the table configs and the partitions in memory.
+func (r *Router) PartitionRuleReplace(database string, rules []*ReshardRule) error {
+	log := r.log
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	schema, ok := r.Schemas[database]
+	if !ok {
+		return errors.Errorf("router.rule.cant.found.database:%s", database)
+	}
+
+	// 1. Build the new configs.
+	tables := make([]*Table, 0, len(rules))
+	confs := make([]*config.TableConfig, 0, len(rules))
+	hashs := make([]*Hash, 0, len(rules))
+	for _, rule := range rules {
+		log.Warning("router.partition.rule.replace.database[%s].table[%s].olds[%v]", database, rule.Table, rule.Olds)
+		tbl, ok := schema.Tables[rule.Table]
+		if !ok {
+			return errors.Errorf("router.rule.cant.found.table:%s", rule.Table)
+		}
+		if tbl.TableConfig.ShardType != methodTypeHash {
+			return errors.Errorf("router.rule.table[%s].shardtype[%s].must.be.HASH", rule.Table, tbl.TableConfig.ShardType)
+		}
+
+		tableConf := *tbl.TableConfig
+		tableConf.Partitions = make([]*config.PartitionConfig, 0, len(tbl.TableConfig.Partitions)+len(rule.News))
+		for _, partition := range tbl.TableConfig.Partitions {
+			replaced := false
+			for _, old := range rule.Olds {
+				if partition.Table == old {
+					replaced = true
+					break
+				}
+			}
+			if !replaced {
+				tableConf.Partitions = append(tableConf.Partitions, partition)
+			}
+		}
+		if len(tableConf.Partitions)+len(rule.Olds) != len(tbl.TableConfig.Partitions) {
+			return errors.Errorf("router.rule.replace.cant.found.partitions%v", rule.Olds)
+		}
+		tableConf.Partitions = append(tableConf.Partitions, rule.News...)
+
+		slots := tableConf.Slots
+		if slots == 0 {
+			slots = r.conf.Slots
+		}
+		hash := NewHash(log, slots, &tableConf)
+		if err := hash.Build(); err != nil {
+			log.Error("router.partition.rule.replace.build.error:%+v", err)
+			return err
+		}
+		tables = append(tables, tbl)
+		confs = append(confs, &tableConf)
+		hashs = append(hashs, hash)
+	}
+
+	// 2. Flush table configs to disk together.
+	names := make([]string, 0, len(tables))
+	for _, tbl := range tables {
+		names = append(names, tbl.Name)
+	}
+	if err := r.writeTablesFrmData(database, names, confs); err != nil {
+		return err
+	}
+	if err := config.UpdateVersion(r.metadir); err != nil {
+		log.Panicf("router.partition.rule.replace.update.version.error:%v", err)
+		return err
+	}
+
+	// 3. Switch.
+	for i, tbl := range tables {
+		tbl.TableConfig = confs[i]
+		tbl.Partition = hashs[i]
+	}
+	r.changed()
+	log.Warning("router.partition.rule.replace.done")
+	return nil
 }
 
 // ReLoad used to re-load the config files from disk to cache.
@@ -9,7 +9,11 @@
 package router
 
 import (
+	"path"
 	"testing"
+	"time"
+
+	"config"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/xelabs/go-mysqlstack/xlog"
@@ -58,8 +62,10 @@ func TestApiPartitionRuleShift(t *testing.T) {
 		to := "backend88"
 		database := "sbtest"
 		table := "A8"
+		version := router.Version()
 		err := router.PartitionRuleShift(from, to, database, table)
 		assert.Nil(t, err)
+		assert.True(t, router.Version() > version)
 		want := `{
 	"Schemas": {
 		"sbtest": {
@@ -353,3 +359,205 @@ func TestApiReLoad(t *testing.T) {
 	got := rules.Schemas[0].DB
 	assert.Equal(t, want, got)
 }
+
+func TestApiPartitionRuleSplitMerge(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	router, cleanup := MockNewRouter(log)
+	defer cleanup()
+
+	router.CreateDatabase("sbtest")
+	err := router.addTable("sbtest", MockTableAConfig())
+	assert.Nil(t, err)
+
+	// Split A8.
+	{
+		rules, err := router.PartitionRuleSplit("sbtest", "A8")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(rules))
+		assert.Equal(t, "A", rules[0].Table)
+		assert.Equal(t, []string{"A8"}, rules[0].Olds)
+		parts := rules[0].News
+		assert.Equal(t, 2, len(parts))
+		assert.Equal(t, &config.PartitionConfig{Table: "A_0004", Segment: "8-2052", Backend: "backend8"}, parts[0])
+		assert.Equal(t, &config.PartitionConfig{Table: "A_0005", Segment: "2052-4096", Backend: "backend8"}, parts[1])
+
+		err = router.PartitionRuleReplace("sbtest", rules)
+		assert.Nil(t, err)
+
+		segments, err := router.Lookup("sbtest", "A", nil, nil)
+		assert.Nil(t, err)
+		var got []string
+		for _, segment := range segments {
+			got = append(got, segment.Table)
+		}
+		assert.Equal(t, []string{"A0", "A2", "A4", "A_0004", "A_0005"}, got)
+
+		// The frm file is flushed.
+		tconf, err := router.readTableFrmData(path.Join(router.metadir, "sbtest", "A.json"))
+		assert.Nil(t, err)
+		assert.Equal(t, 5, len(tconf.Partitions))
+	}
+
+	// Merge A_0005 and A_0004.
+	{
+		rules, err := router.PartitionRuleMerge("sbtest", "A_0005", "A_0004")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(rules))
+		assert.Equal(t, "A", rules[0].Table)
+		assert.Equal(t, []string{"A_0004", "A_0005"}, rules[0].Olds)
+		assert.Equal(t, []*config.PartitionConfig{{Table: "A_0006", Segment: "8-4096", Backend: "backend8"}}, rules[0].News)
+
+		err = router.PartitionRuleReplace("sbtest", rules)
+		assert.Nil(t, err)
+
+		segments, err := router.Lookup("sbtest", "A", nil, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, "A_0006", segments[3].Table)
+		assert.Equal(t, 4, len(segments))
+	}
+}
+
+func TestApiPartitionRuleSplitMergeErrors(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	router, cleanup := MockNewRouter(log)
+	defer cleanup()
+
+	router.CreateDatabase("sbtest")
+	err := router.AddForTest("sbtest", MockTableAConfig(), MockTableLConfig())
+	assert.Nil(t, err)
+
+	// Split.
+	{
+		tests := []struct {
+			db    string
+			table string
+			err   string
+		}{
+			{"xx", "A8", "router.rule.cant.found.database:xx"},
+			{"sbtest", "A9", "router.rule.cant.found.partition.table[A9]"},
+			{"sbtest", "L0", "router.rule.table[L].shardtype[LIST].must.be.HASH"},
+		}
+		for _, test := range tests {
+			_, err := router.PartitionRuleSplit(test.db, test.table)
+			assert.Equal(t, test.err, err.Error())
+		}
+
+		// Split to the single slot.
+		rules, err := router.PartitionRuleSplit("sbtest", "A0")
+		assert.Nil(t, err)
+		err = router.PartitionRuleReplace("sbtest", rules)
+		assert.Nil(t, err)
+		_, err = router.PartitionRuleSplit("sbtest", rules[0].News[0].Table)
+		assert.Equal(t, "router.rule.split.partition[A_0004].segment[0-1].too.small", err.Error())
+	}
+
+	// Merge.
+	{
+		tests := []struct {
+			left  string
+			right string
+			err   string
+		}{
+			{"A9", "A2", "router.rule.cant.found.partition.table[A9]"},
+			{"A2", "L0", "router.rule.table[L].shardtype[LIST].must.be.HASH"},
+			{"A2", "A2", "router.rule.merge.partitions[A2,A2].must.belong.to.the.same.table"},
+			{"A2", "A8", "router.rule.merge.partitions[A2,A8].must.be.adjacent"},
+		}
+		for _, test := range tests {
+			_, err := router.PartitionRuleMerge("sbtest", test.left, test.right)
+			assert.Equal(t, test.err, err.Error())
+		}
+	}
+
+	// Replace.
+	{
+		tests := []struct {
+			table string
+			olds  []string
+			news  []*config.PartitionConfig
+			err   string
+		}{
+			{"B", []string{"A2"}, nil, "router.rule.cant.found.table:B"},
+			{"L", []string{"L0"}, nil, "router.rule.table[L].shardtype[LIST].must.be.HASH"},
+			{"A", []string{"A9"}, nil, "router.rule.replace.cant.found.partitions[A9]"},
+			{"A", []string{"A2"}, []*config.PartitionConfig{{Table: "A_0009", Segment: "2-3", Backend: "backend2"}}, "upper.bound.must.be[4096]"},
+		}
+		for _, test := range tests {
+			err := router.PartitionRuleReplace("sbtest", []*ReshardRule{{Table: test.table, Olds: test.olds, News: test.news}})
+			assert.NotNil(t, err)
+			assert.Contains(t, err.Error(), test.err)
+		}
+	}
+}
+
+func TestApiFreezeTable(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	router, cleanup := MockNewRouter(log)
+	defer cleanup()
+
+	err := router.CheckWritable("sbtest", "A")
+	assert.Nil(t, err)
+
+	err = router.FreezeTable("sbtest", "A")
+	assert.Nil(t, err)
+	err = router.FreezeTable("sbtest", "A")
+	assert.Equal(t, "router.table[sbtest.A].is.already.frozen", err.Error())
+	err = router.CheckWritable("sbtest", "A")
+	assert.Equal(t, "router.table[sbtest.A].is.frozen.for.resharding,please.retry.later", err.Error())
+	err = router.CheckWritable("sbtest", "B")
+	assert.Nil(t, err)
+
+	router.UnfreezeTable("sbtest", "A")
+	err = router.CheckWritable("sbtest", "A")
+	assert.Nil(t, err)
+}
+
+func TestApiWriteGuard(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	router, cleanup := MockNewRouter(log)
+	defer cleanup()
+
+	err := router.BeginWrite("sbtest", "A")
+	assert.Nil(t, err)
+	err = router.FreezeTable("sbtest", "A")
+	assert.Nil(t, err)
+	err = router.BeginWrite("sbtest", "A")
+	assert.Equal(t, "router.table[sbtest.A].is.frozen.for.resharding,please.retry.later", err.Error())
+
+	// The in-flight write blocks the wait until it's done.
+	done := make(chan bool)
+	go func() {
+		err := router.WaitWrites("sbtest", "A", time.Second*10)
+		assert.Nil(t, err)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("wait.writes.returned.before.the.write.done")
+	case <-time.After(time.Millisecond * 50):
+	}
+	router.EndWrite("sbtest", "A")
+	<-done
+
+	router.UnfreezeTable("sbtest", "A")
+	err = router.BeginWrite("sbtest", "A")
+	assert.Nil(t, err)
+
+	// The write txn is still open after the timeout.
+	err = router.WaitWrites("sbtest", "A", time.Millisecond*10)
+	assert.Equal(t, "router.table[sbtest.A].has.1.open.write.txns.after[10ms]", err.Error())
+	router.EndWrite("sbtest", "A")
+	err = router.WaitWrites("sbtest", "A", time.Millisecond*10)
+	assert.Nil(t, err)
+
+	// The writes of the other tables aren't blocked by the wait, nor by the router lock.
+	err = router.BeginWrite("sbtest", "A")
+	assert.Nil(t, err)
+	go router.WaitWrites("sbtest", "A", time.Second)
+	router.mu.Lock()
+	err = router.BeginWrite("sbtest", "B")
+	assert.Nil(t, err)
+	router.EndWrite("sbtest", "B")
+	router.mu.Unlock()
+	router.EndWrite("sbtest", "A")
+}
@@ -11,10 +11,13 @@ package router
 import (
 	"fmt"
 	"sort"
+	"strings"
+	"time"
 
 	"config"
 
 	"github.com/pkg/errors"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
 )
 
 // HashUniform used to uniform the hash slots to backends.
@@ -73,6 +76,153 @@ func (r *Router) HashUniform(table, shardkey string, backends []string) (*config
 	return tableConf, nil
 }
 
+// RangeUniform used to build the range partitions from the definitions.
+// The definition name is the backend which the partition placed on.
+func (r *Router) RangeUniform(table, shardkey string, backends []string, defs sqlparser.PartitionDefinitions) (*config.TableConfig, error) {
+	if table == "" {
+		return nil, errors.New("table.cant.be.null")
+	}
+	if shardkey == "" {
+		return nil, errors.New("shard.key.cant.be.null")
+	}
+	if len(defs) == 0 {
+		return nil, errors.New("router.compute.range.partitions.is.null")
+	}
+	if len(ShardKeys(shardkey)) > 1 {
+		return nil, errors.Errorf("router.compute.range.shardkey[%s].must.be.single.column", shardkey)
+	}
+
+	tableConf := &config.TableConfig{
+		Name:       table,
+		ShardKey:   shardkey,
+		ShardType:  methodTypeRange,
+		Partitions: make([]*config.PartitionConfig, 0, 16),
+	}
+
+	for i, def := range defs {
+		found := false
+		for _, backend := range backends {
+			if backend == def.Backend {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.Errorf("router.compute.range.backend[%s].can.not.be.found", def.Backend)
+		}
+
+		segment := rangeMaxValue
+		if def.LessThan != nil {
+			segment = string(def.LessThan.Val)
+		}
+		partConf := &config.PartitionConfig{
+			Table:   fmt.Sprintf("%s_%04d", table, i),
+			Segment: segment,
+			Backend: def.Backend,
+		}
+		tableConf.Partitions = append(tableConf.Partitions, partConf)
+	}
+	return tableConf, nil
+}
+
+// ListUniform used to build the list partitions from the definitions.
+// The definition name is the backend which the partition placed on.
+func (r *Router) ListUniform(table, shardkey string, backends []string, defs sqlparser.PartitionDefinitions) (*config.TableConfig, error) {
+	if table == "" {
+		return nil, errors.New("table.cant.be.null")
+	}
+	if shardkey == "" {
+		return nil, errors.New("shard.key.cant.be.null")
+	}
+	if len(defs) == 0 {
+		return nil, errors.New("router.compute.list.partitions.is.null")
+	}
+	if len(ShardKeys(shardkey)) > 1 {
+		return nil, errors.Errorf("router.compute.list.shardkey[%s].must.be.single.column", shardkey)
+	}
+
+	tableConf := &config.TableConfig{
+		Name:       table,
+		ShardKey:   shardkey,
+		ShardType:  methodTypeList,
+		Partitions: make([]*config.PartitionConfig, 0, 16),
+	}
+
+	for i, def := range defs {
+		found := false
+		for _, backend := range backends {
+			if backend == def.Backend {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, errors.Errorf("router.compute.list.backend[%s].can.not.be.found", def.Backend)
+		}
+
+		partConf := &config.PartitionConfig{
+			Table:   fmt.Sprintf("%s_%04d", table, i),
+			Backend: def.Backend,
+		}
+		if def.IsDefault {
+			partConf.Segment = listDefault
+		} else {
+			for _, val := range def.InValues {
+				partConf.Values = append(partConf.Values, string(val.Val))
+			}
+		}
+		tableConf.Partitions = append(tableConf.Partitions, partConf)
+	}
+	return tableConf, nil
+}
+
+// TimeUniform used to build the time partitions from the backfill periods before the current one
+// to the ahead ones, the partitions are placed on the backends in turn.
+func (r *Router) TimeUniform(table, shardkey string, backends []string, interval string, backfill int, now time.Time) (*config.TableConfig, error) {
+	if table == "" {
+		return nil, errors.New("table.cant.be.null")
+	}
+	if shardkey == "" {
+		return nil, errors.New("shard.key.cant.be.null")
+	}
+	nums := len(backends)
+	if nums == 0 {
+		return nil, errors.New("router.compute.backends.is.null")
+	}
+	if len(ShardKeys(shardkey)) > 1 {
+		return nil, errors.Errorf("router.compute.time.shardkey[%s].must.be.single.column", shardkey)
+	}
+	interval = strings.ToUpper(interval)
+	if !isTimeInterval(interval) {
+		return nil, errors.Errorf("router.compute.time.unsupported.interval:[%v]", interval)
+	}
+	if backfill < 0 || backfill > timeMaxBackfill {
+		return nil, errors.Errorf("router.compute.time.backfill[%d].must.be.in[0,%d]", backfill, timeMaxBackfill)
+	}
+
+	// sort backends.
+	sort.Strings(backends)
+	tableConf := &config.TableConfig{
+		Name:       table,
+		ShardKey:   shardkey,
+		ShardType:  methodTypeTime,
+		Interval:   interval,
+		Partitions: make([]*config.PartitionConfig, 0, 16),
+	}
+
+	period := timeAdd(timeTruncate(now, interval), interval, -backfill)
+	for i := 0; i <= backfill+timeAhead(interval); i++ {
+		partConf := &config.PartitionConfig{
+			Table:   fmt.Sprintf("%s_%s", table, timeTableSuffix(period, interval)),
+			Segment: period.Format(timeSegmentFormat),
+			Backend: backends[i%nums],
+		}
+		tableConf.Partitions = append(tableConf.Partitions, partConf)
+		period = timeNext(period, interval)
+	}
+	return tableConf, nil
+}
+
 // GlobalUniform used to uniform the global table to backends.
 func (r *Router) GlobalUniform(table string, backends []string) (*config.TableConfig, error) {
 	if table == "" {
@@ -99,3 +249,35 @@ func (r *Router) GlobalUniform(table string, backends []string) (*config.TableCo
 	}
 	return tableConf, nil
 }
+
+// SingleUniform used to place the single table on the backend.
+func (r *Router) SingleUniform(table string, backend string, backends []string) (*config.TableConfig, error) {
+	if table == "" {
+		return nil, errors.New("table.cant.be.null")
+	}
+	if backend == "" {
+		return nil, errors.New("router.compute.single.backend.cant.be.null")
+	}
+	found := false
+	for _, b := range backends {
+		if b == backend {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, errors.Errorf("router.compute.single.backend[%s].cant.found", backend)
+	}
+
+	return &config.TableConfig{
+		Name:      table,
+		ShardType: methodTypeSingle,
+		ShardKey:  "",
+		Partitions: []*config.PartitionConfig{
+			{
+				Table:   table,
+				Backend: backend,
+			},
+		},
+	}, nil
+}
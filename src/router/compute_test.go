@@ -11,10 +11,12 @@ package router
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"config"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
 	"github.com/xelabs/go-mysqlstack/xlog"
 )
 
@@ -304,3 +306,288 @@ func TestRouterComputeGlobal(t *testing.T) {
 	assert.Nil(t, err)
 	assert.Equal(t, want, got)
 }
+
+func TestRouterComputeRange(t *testing.T) {
+	datas := `{
+	"name": "t1",
+	"shardtype": "RANGE",
+	"shardkey": "id",
+	"partitions": [
+		{
+			"table": "t1_0000",
+			"segment": "100",
+			"backend": "192.168.0.1"
+		},
+		{
+			"table": "t1_0001",
+			"segment": "200",
+			"backend": "192.168.0.2"
+		},
+		{
+			"table": "t1_0002",
+			"segment": "MAXVALUE",
+			"backend": "192.168.0.1"
+		}
+	]
+}`
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	router, cleanup := MockNewRouter(log)
+	defer cleanup()
+	assert.NotNil(t, router)
+
+	backends := []string{
+		"192.168.0.1",
+		"192.168.0.2",
+	}
+	defs := sqlparser.PartitionDefinitions{
+		{Backend: "192.168.0.1", LessThan: sqlparser.NewIntVal([]byte("100"))},
+		{Backend: "192.168.0.2", LessThan: sqlparser.NewIntVal([]byte("200"))},
+		{Backend: "192.168.0.1"},
+	}
+	got, err := router.RangeUniform("t1", "id", backends, defs)
+	assert.Nil(t, err)
+	want, err := config.ReadTableConfig(datas)
+	assert.Nil(t, err)
+	assert.Equal(t, want, got)
+}
+
+func TestRouterComputeRangeError(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	router, cleanup := MockNewRouter(log)
+	defer cleanup()
+	assert.NotNil(t, router)
+
+	backends := []string{"192.168.0.1"}
+	defs := sqlparser.PartitionDefinitions{
+		{Backend: "192.168.0.2"},
+	}
+
+	// Table is null.
+	{
+		_, err := router.RangeUniform("", "id", backends, defs)
+		assert.NotNil(t, err)
+	}
+
+	// Shardkey is null.
+	{
+		_, err := router.RangeUniform("t1", "", backends, defs)
+		assert.NotNil(t, err)
+	}
+
+	// Definitions is null.
+	{
+		_, err := router.RangeUniform("t1", "id", backends, nil)
+		assert.Equal(t, "router.compute.range.partitions.is.null", err.Error())
+	}
+
+	// Composite shardkey.
+	{
+		_, err := router.RangeUniform("t1", "a,b", backends, defs)
+		assert.Equal(t, "router.compute.range.shardkey[a,b].must.be.single.column", err.Error())
+	}
+
+	// Backend can not be found.
+	{
+		_, err := router.RangeUniform("t1", "id", backends, defs)
+		assert.Equal(t, "router.compute.range.backend[192.168.0.2].can.not.be.found", err.Error())
+	}
+}
+
+func TestRouterComputeList(t *testing.T) {
+	datas := `{
+	"name": "t1",
+	"shardtype": "LIST",
+	"shardkey": "id",
+	"partitions": [
+		{
+			"table": "t1_0000",
+			"segment": "",
+			"backend": "192.168.0.1",
+			"values": ["1", "cn"]
+		},
+		{
+			"table": "t1_0001",
+			"segment": "DEFAULT",
+			"backend": "192.168.0.2"
+		}
+	]
+}`
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	router, cleanup := MockNewRouter(log)
+	defer cleanup()
+	assert.NotNil(t, router)
+
+	backends := []string{
+		"192.168.0.1",
+		"192.168.0.2",
+	}
+	defs := sqlparser.PartitionDefinitions{
+		{Backend: "192.168.0.1", InValues: []*sqlparser.SQLVal{sqlparser.NewIntVal([]byte("1")), sqlparser.NewStrVal([]byte("cn"))}},
+		{Backend: "192.168.0.2", IsDefault: true},
+	}
+	got, err := router.ListUniform("t1", "id", backends, defs)
+	assert.Nil(t, err)
+	want, err := config.ReadTableConfig(datas)
+	assert.Nil(t, err)
+	assert.Equal(t, want, got)
+}
+
+func TestRouterComputeListError(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	router, cleanup := MockNewRouter(log)
+	defer cleanup()
+	assert.NotNil(t, router)
+
+	backends := []string{"192.168.0.1"}
+	defs := sqlparser.PartitionDefinitions{
+		{Backend: "192.168.0.2", IsDefault: true},
+	}
+
+	// Table is null.
+	{
+		_, err := router.ListUniform("", "id", backends, defs)
+		assert.NotNil(t, err)
+	}
+
+	// Shardkey is null.
+	{
+		_, err := router.ListUniform("t1", "", backends, defs)
+		assert.NotNil(t, err)
+	}
+
+	// Definitions is null.
+	{
+		_, err := router.ListUniform("t1", "id", backends, nil)
+		assert.Equal(t, "router.compute.list.partitions.is.null", err.Error())
+	}
+
+	// Composite shardkey.
+	{
+		_, err := router.ListUniform("t1", "a,b", backends, defs)
+		assert.Equal(t, "router.compute.list.shardkey[a,b].must.be.single.column", err.Error())
+	}
+
+	// Backend can not be found.
+	{
+		_, err := router.ListUniform("t1", "id", backends, defs)
+		assert.Equal(t, "router.compute.list.backend[192.168.0.2].can.not.be.found", err.Error())
+	}
+}
+
+func TestRouterComputeTime(t *testing.T) {
+	datas := `{
+	"name": "t1",
+	"shardtype": "TIME",
+	"shardkey": "dt",
+	"interval": "MONTH",
+	"partitions": [
+		{
+			"table": "t1_201912",
+			"segment": "2019-12-01",
+			"backend": "192.168.0.1"
+		},
+		{
+			"table": "t1_202001",
+			"segment": "2020-01-01",
+			"backend": "192.168.0.2"
+		},
+		{
+			"table": "t1_202002",
+			"segment": "2020-02-01",
+			"backend": "192.168.0.1"
+		}
+	]
+}`
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	router, cleanup := MockNewRouter(log)
+	defer cleanup()
+	assert.NotNil(t, router)
+
+	backends := []string{
+		"192.168.0.2",
+		"192.168.0.1",
+	}
+	now := time.Date(2019, 12, 31, 23, 59, 59, 0, time.Local)
+	got, err := router.TimeUniform("t1", "dt", backends, "month", 0, now)
+	assert.Nil(t, err)
+	want, err := config.ReadTableConfig(datas)
+	assert.Nil(t, err)
+	assert.Equal(t, want, got)
+
+	// Day.
+	{
+		got, err := router.TimeUniform("t1", "dt", backends, "DAY", 0, now)
+		assert.Nil(t, err)
+		assert.Equal(t, timeDayAhead+1, len(got.Partitions))
+		assert.Equal(t, "t1_20191231", got.Partitions[0].Table)
+		assert.Equal(t, "t1_20200101", got.Partitions[1].Table)
+	}
+
+	// Backfill the past periods.
+	{
+		got, err := router.TimeUniform("t1", "dt", backends, "month", 12, now)
+		assert.Nil(t, err)
+		assert.Equal(t, 12+timeMonthAhead+1, len(got.Partitions))
+		assert.Equal(t, "t1_201812", got.Partitions[0].Table)
+		assert.Equal(t, "2018-12-01", got.Partitions[0].Segment)
+		assert.Equal(t, "t1_201912", got.Partitions[12].Table)
+
+		got, err = router.TimeUniform("t1", "dt", backends, "day", 31, now)
+		assert.Nil(t, err)
+		assert.Equal(t, "t1_20191130", got.Partitions[0].Table)
+		assert.Equal(t, "t1_20191231", got.Partitions[31].Table)
+	}
+}
+
+func TestRouterComputeTimeError(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	router, cleanup := MockNewRouter(log)
+	defer cleanup()
+	assert.NotNil(t, router)
+
+	backends := []string{"192.168.0.1"}
+	now := time.Now()
+
+	// Table is null.
+	{
+		_, err := router.TimeUniform("", "dt", backends, "MONTH", 0, now)
+		assert.NotNil(t, err)
+	}
+
+	// Shardkey is null.
+	{
+		_, err := router.TimeUniform("t1", "", backends, "MONTH", 0, now)
+		assert.NotNil(t, err)
+	}
+
+	// Backends is null.
+	{
+		_, err := router.TimeUniform("t1", "dt", nil, "MONTH", 0, now)
+		want := "router.compute.backends.is.null"
+		assert.Equal(t, want, err.Error())
+	}
+
+	// Composite shardkey.
+	{
+		_, err := router.TimeUniform("t1", "a,b", backends, "MONTH", 0, now)
+		want := "router.compute.time.shardkey[a,b].must.be.single.column"
+		assert.Equal(t, want, err.Error())
+	}
+
+	// Unsupported interval.
+	{
+		_, err := router.TimeUniform("t1", "dt", backends, "year", 0, now)
+		want := "router.compute.time.unsupported.interval:[YEAR]"
+		assert.Equal(t, want, err.Error())
+	}
+
+	// Backfill out of range.
+	{
+		_, err := router.TimeUniform("t1", "dt", backends, "MONTH", -1, now)
+		want := "router.compute.time.backfill[-1].must.be.in[0,1000]"
+		assert.Equal(t, want, err.Error())
+
+		_, err = router.TimeUniform("t1", "dt", backends, "DAY", timeMaxBackfill+1, now)
+		assert.NotNil(t, err)
+	}
+}
@@ -13,12 +13,21 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"strings"
+	"time"
 
 	"config"
 
 	"github.com/pkg/errors"
 )
 
+const (
+	// frmTmpSuffix is the suffix of the temporary frm file.
+	frmTmpSuffix = ".tmp"
+	// frmBakSuffix is the suffix of the backup frm file.
+	frmBakSuffix = ".bak"
+)
+
 // writeTableFrmData used to write table's json schema to file.
 // The file name is : [schema-dir]/[database]/[table].json.
 // If the [schema-dir]/[database] directoryis not exists, we will create it first.
@@ -38,6 +47,70 @@ func (r *Router) writeTableFrmData(db string, table string, tconf *config.TableC
 	return nil
 }
 
+// writeTablesFrmData used to write the json schemas of the tables together.
+// The configs are written to the temporary files first, then renamed to the frm files,
+// the replaced files are linked as the backups and restored if one rename fails,
+// so the frm files are all changed or none.
+func (r *Router) writeTablesFrmData(db string, tables []string, tconfs []*config.TableConfig) error {
+	log := r.log
+	dir := path.Join(r.metadir, db)
+
+	files := make([]string, len(tables))
+	for i, table := range tables {
+		if tconfs[i] == nil {
+			return errors.New("table.config..can't.be.nil")
+		}
+		files[i] = path.Join(dir, fmt.Sprintf("%s.json", table))
+	}
+	cleanup := func(suffix string) {
+		for _, file := range files {
+			os.Remove(file + suffix)
+		}
+	}
+
+	// 1. Write the temporary files.
+	for i, file := range files {
+		log.Info("frm.write.data[db:%s, table:%s, shardType:%s]", db, tables[i], tconfs[i].ShardType)
+		if err := config.WriteConfig(file+frmTmpSuffix, tconfs[i]); err != nil {
+			log.Error("frm.write.to.file[%v].error:%v", file+frmTmpSuffix, err)
+			cleanup(frmTmpSuffix)
+			return err
+		}
+	}
+
+	// 2. Backup the files to be replaced.
+	cleanup(frmBakSuffix)
+	for _, file := range files {
+		if err := os.Link(file, file+frmBakSuffix); err != nil && !os.IsNotExist(err) {
+			log.Error("frm.backup.file[%v].error:%v", file, err)
+			cleanup(frmTmpSuffix)
+			cleanup(frmBakSuffix)
+			return err
+		}
+	}
+
+	// 3. Rename, restore the renamed ones if error.
+	for i, file := range files {
+		if err := os.Rename(file+frmTmpSuffix, file); err != nil {
+			log.Error("frm.rename.file[%v].error:%v", file, err)
+			for _, renamed := range files[:i] {
+				if _, x := os.Stat(renamed + frmBakSuffix); os.IsNotExist(x) {
+					os.Remove(renamed)
+					continue
+				}
+				if x := os.Rename(renamed+frmBakSuffix, renamed); x != nil {
+					log.Error("frm.restore.file[%v].error:%v", renamed, x)
+				}
+			}
+			cleanup(frmTmpSuffix)
+			cleanup(frmBakSuffix)
+			return err
+		}
+	}
+	cleanup(frmBakSuffix)
+	return nil
+}
+
 // removeTableFrmData used to remove table json file.
 func (r *Router) removeTableFrmData(db string, table string) error {
 	log := r.log
@@ -162,10 +235,32 @@ func (r *Router) CreateTable(db, table, shardKey string, backends []string, extr
 	var tableConf *config.TableConfig
 	log := r.log
 	// Compute the shards config.
-	if shardKey == "" {
+	switch {
+	case extra != nil && strings.ToUpper(extra.PartitionType) == methodTypeSingle:
+		tableConf, err = r.SingleUniform(table, extra.Backend, backends)
+	case shardKey == "":
 		tableConf, err = r.GlobalUniform(table, backends)
-	} else {
-		tableConf, err = r.HashUniform(table, shardKey, backends)
+	case extra != nil && strings.ToUpper(extra.PartitionType) == methodTypeRange:
+		tableConf, err = r.RangeUniform(table, shardKey, backends, extra.PartitionOptions)
+	case extra != nil && strings.ToUpper(extra.PartitionType) == methodTypeList:
+		tableConf, err = r.ListUniform(table, shardKey, backends, extra.PartitionOptions)
+	case extra != nil && isTimeInterval(extra.PartitionType):
+		tableConf, err = r.TimeUniform(table, shardKey, backends, extra.PartitionType, extra.Backfill, time.Now())
+	case extra != nil && extra.TableGroup != "":
+		if leader := r.groupLeader(db, extra.TableGroup); leader == nil {
+			if tableConf, err = r.HashUniform(table, shardKey, backends); err == nil {
+				tableConf.TableGroup = extra.TableGroup
+				tableConf.CanonicalDate = true
+			}
+		} else if extra.HashFunc != "" && hashFuncName(extra.HashFunc) != hashFuncName(leader.HashFunc) {
+			err = errors.Errorf("router.compute.tablegroup[%s].hashfunc[%s].must.be.same.as[%s]", extra.TableGroup, extra.HashFunc, hashFuncName(leader.HashFunc))
+		} else {
+			tableConf, err = r.GroupUniform(table, shardKey, extra.TableGroup, leader)
+		}
+	default:
+		if tableConf, err = r.HashUniform(table, shardKey, backends); err == nil {
+			tableConf.CanonicalDate = true
+		}
 	}
 	if err != nil {
 		log.Error("frm.create.table[%s.%s].compute.error:%v", db, table, err)
@@ -175,6 +270,20 @@ func (r *Router) CreateTable(db, table, shardKey string, backends []string, extr
 	// Set extra.
 	if extra != nil {
 		tableConf.AutoIncrement = extra.AutoIncrement
+		if tableConf.ShardKey != "" {
+			tableConf.ShardKeyTypes = extra.ShardKeyTypes
+		}
+		if extra.HashFunc != "" {
+			if tableConf.ShardType != methodTypeHash {
+				err = errors.Errorf("router.table[%s.%s].shardtype[%s].cant.set.hashfunc", db, table, tableConf.ShardType)
+				log.Error("frm.create.table[%s.%s].hashfunc.error:%v", db, table, err)
+				return err
+			}
+			// The default jump is left empty.
+			if name := hashFuncName(extra.HashFunc); name != HashFuncJump {
+				tableConf.HashFunc = name
+			}
+		}
 	}
 
 	// add config to router.
@@ -216,6 +325,66 @@ func (r *Router) DropTable(db, table string) error {
 	return nil
 }
 
+// AheadTimePartitions returns the partitions need to be created in advance for the TIME table,
+// returns nil if the ahead periods are all covered.
+func (r *Router) AheadTimePartitions(db, table string, now time.Time) ([]*config.PartitionConfig, error) {
+	tbl, err := r.getTable(db, table)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tim, ok := tbl.Partition.(*Time)
+	if !ok {
+		return nil, errors.Errorf("router.table[%s.%s].is.not.time.partition", db, table)
+	}
+	return tim.aheadPartitions(table, now), nil
+}
+
+// AddTimePartitions used to append the partitions to the TIME table and flush the schema to disk.
+// Lock.
+func (r *Router) AddTimePartitions(db, table string, parts []*config.PartitionConfig) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	log := r.log
+	schema, ok := r.Schemas[db]
+	if !ok {
+		return errors.Errorf("router.can.not.find.db[%v]", db)
+	}
+	tbl, ok := schema.Tables[table]
+	if !ok {
+		return errors.Errorf("router.can.not.find.table[%v]", table)
+	}
+	if _, ok := tbl.Partition.(*Time); !ok {
+		return errors.Errorf("router.table[%s.%s].is.not.time.partition", db, table)
+	}
+
+	tableConf := *tbl.TableConfig
+	tableConf.Partitions = make([]*config.PartitionConfig, 0, len(tbl.TableConfig.Partitions)+len(parts))
+	tableConf.Partitions = append(tableConf.Partitions, tbl.TableConfig.Partitions...)
+	tableConf.Partitions = append(tableConf.Partitions, parts...)
+	tim := NewTime(log, &tableConf)
+	if err := tim.Build(); err != nil {
+		log.Error("frm.add.time.partitions[%s.%s].build.error:%v", db, table, err)
+		return err
+	}
+	tbl.TableConfig = &tableConf
+	tbl.Partition = tim
+	r.changed()
+
+	if err := r.writeTableFrmData(db, table, &tableConf); err != nil {
+		log.Error("frm.add.time.partitions[db:%v, table:%v].file.error:%+v", db, table, err)
+		return err
+	}
+	if err := config.UpdateVersion(r.metadir); err != nil {
+		log.Panicf("frm.add.time.partitions.update.version.error:%v", err)
+		return err
+	}
+	return nil
+}
+
 // RefreshTable used to re-update the table from file.
 // Lock.
 func (r *Router) RefreshTable(db, table string) error {
@@ -270,7 +439,8 @@ func (r *Router) LoadConfig() error {
 				return err
 			}
 			for _, subFile := range subFiles {
-				if !subFile.IsDir() {
+				// The temporary and the backup files are skipped.
+				if !subFile.IsDir() && strings.HasSuffix(subFile.Name(), ".json") {
 					jsons = append(jsons, path.Join(subdir, subFile.Name()))
 				}
 			}
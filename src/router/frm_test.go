@@ -10,11 +10,14 @@ package router
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
 	"github.com/xelabs/go-mysqlstack/xlog"
 )
 
@@ -75,6 +78,66 @@ func TestFrmTable(t *testing.T) {
 		assert.Nil(t, err)
 	}
 
+	// Add range table.
+	{
+		tmpRouter := router
+		backends := []string{"backend1", "backend2"}
+		extra := &Extra{
+			PartitionType: "range",
+			PartitionOptions: sqlparser.PartitionDefinitions{
+				{Backend: "backend1", LessThan: sqlparser.NewIntVal([]byte("100"))},
+				{Backend: "backend2"},
+			},
+		}
+		err := router.CreateTable("test", "t4", "id", backends, extra)
+		assert.Nil(t, err)
+		assert.True(t, checkFileExistsForTest(tmpRouter, "test", "t4"))
+
+		segments, err := router.Lookup("test", "t4", nil, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(segments))
+	}
+
+	// Add list table.
+	{
+		tmpRouter := router
+		backends := []string{"backend1", "backend2"}
+		extra := &Extra{
+			PartitionType: "list",
+			PartitionOptions: sqlparser.PartitionDefinitions{
+				{Backend: "backend1", InValues: []*sqlparser.SQLVal{sqlparser.NewStrVal([]byte("cn"))}},
+				{Backend: "backend2", IsDefault: true},
+			},
+		}
+		err := router.CreateTable("test", "t6", "region", backends, extra)
+		assert.Nil(t, err)
+		assert.True(t, checkFileExistsForTest(tmpRouter, "test", "t6"))
+
+		segments, err := router.Lookup("test", "t6", nil, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(segments))
+	}
+
+	// Add time table.
+	{
+		tmpRouter := router
+		backends := []string{"backend1", "backend2"}
+		err := router.CreateTable("test", "t7", "dt", backends, &Extra{PartitionType: "month"})
+		assert.Nil(t, err)
+		assert.True(t, checkFileExistsForTest(tmpRouter, "test", "t7"))
+
+		segments, err := router.Lookup("test", "t7", nil, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, timeMonthAhead+1, len(segments))
+	}
+
+	// Add range table without definitions.
+	{
+		backends := []string{"backend1", "backend2"}
+		err := router.CreateTable("test", "t5", "id", backends, &Extra{PartitionType: "range"})
+		assert.NotNil(t, err)
+	}
+
 	// Remove 2.
 	{
 		tmpRouter := router
@@ -213,12 +276,12 @@ func TestFrmLoad(t *testing.T) {
 		// load.
 		err := router1.LoadConfig()
 		assert.Nil(t, err)
-		assert.Equal(t, router, router1)
+		assert.Equal(t, router.Schemas, router1.Schemas)
 
 		// load again.
 		err = router1.LoadConfig()
 		assert.Nil(t, err)
-		assert.Equal(t, router, router1)
+		assert.Equal(t, router.Schemas, router1.Schemas)
 	}
 }
 
@@ -275,6 +338,68 @@ func TestFrmReadFileBroken(t *testing.T) {
 	}
 }
 
+func TestFrmTimePartitions(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	router, cleanup := MockNewRouter(log)
+	defer cleanup()
+	assert.NotNil(t, router)
+
+	err := router.CreateDatabase("test")
+	assert.Nil(t, err)
+	err = router.AddForTest("test", MockTableTConfig(), MockTableMConfig())
+	assert.Nil(t, err)
+
+	now := time.Date(2019, 4, 10, 0, 0, 0, 0, time.UTC)
+	parts, err := router.AheadTimePartitions("test", "T", now)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(parts))
+
+	version := router.Version()
+	err = router.AddTimePartitions("test", "T", parts)
+	assert.Nil(t, err)
+	assert.True(t, checkFileExistsForTest(router, "test", "T"))
+	assert.True(t, router.Version() > version)
+
+	segments, err := router.Lookup("test", "T", sqlparser.NewStrVal([]byte("2019-06-10")), sqlparser.NewStrVal([]byte("2019-06-10")))
+	assert.Nil(t, err)
+	assert.Equal(t, "T_201906", segments[0].Table)
+
+	parts, err = router.AheadTimePartitions("test", "T", now)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(parts))
+
+	// Reload from the file.
+	{
+		err := router.RefreshTable("test", "T")
+		assert.Nil(t, err)
+		segments, err := router.Lookup("test", "T", nil, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 5, len(segments))
+	}
+
+	// Errors.
+	{
+		_, err := router.AheadTimePartitions("test", "A", now)
+		want := "router.table[test.A].is.not.time.partition"
+		assert.Equal(t, want, err.Error())
+
+		err = router.AddTimePartitions("test", "A", parts)
+		assert.Equal(t, want, err.Error())
+
+		err = router.AddTimePartitions("xx", "T", parts)
+		assert.NotNil(t, err)
+
+		err = router.AddTimePartitions("test", "xx", parts)
+		assert.NotNil(t, err)
+
+		// Overlap with the exists.
+		conf := MockTableTConfig()
+		err = router.AddTimePartitions("test", "T", conf.Partitions[:1])
+		want = "time.partition.segment[2019-01-01].must.be.greater.than[2019-06-01]"
+		assert.Equal(t, want, err.Error())
+	}
+}
+
 func TestFrmAddTableForTest(t *testing.T) {
 	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
 	router, cleanup := MockNewRouter(log)
@@ -311,9 +436,71 @@ func TestFrmDatabaseNoTables(t *testing.T) {
 		// load.
 		err := router1.LoadConfig()
 		assert.Nil(t, err)
-		assert.Equal(t, router, router1)
+		assert.Equal(t, router.Schemas, router1.Schemas)
 	}
 
 	err := router.CreateDatabase("test2")
 	assert.NotNil(t, err)
 }
+
+func TestFrmWriteTablesTogether(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	router, cleanup := MockNewRouter(log)
+	defer cleanup()
+
+	err := router.CreateDatabase("test")
+	assert.Nil(t, err)
+	backends := []string{"backend1", "backend2"}
+	err = router.CreateTable("test", "t1", "id", backends, &Extra{TableGroup: "g1"})
+	assert.Nil(t, err)
+	err = router.CreateTable("test", "t2", "id", nil, &Extra{TableGroup: "g1"})
+	assert.Nil(t, err)
+
+	tconf, err := router.TableConfig("test", "t1")
+	assert.Nil(t, err)
+	partition := tconf.Partitions[0]
+	from := partition.Backend
+	to := "backend1"
+	if from == to {
+		to = "backend2"
+	}
+
+	// The temporary file of t2 can't be written, t1 is unchanged on disk and in memory.
+	{
+		tmp := path.Join(router.metadir, "test", "t2.json"+frmTmpSuffix)
+		err := os.MkdirAll(path.Join(tmp, "x"), os.ModePerm)
+		assert.Nil(t, err)
+		err = router.PartitionRuleShift(from, to, "test", partition.Table)
+		assert.NotNil(t, err)
+		os.RemoveAll(tmp)
+
+		conf, err := router.readTableFrmData(path.Join(router.metadir, "test", "t1.json"))
+		assert.Nil(t, err)
+		assert.Equal(t, from, conf.Partitions[0].Backend)
+		assert.Equal(t, from, partition.Backend)
+		_, err = os.Stat(path.Join(router.metadir, "test", "t1.json"+frmTmpSuffix))
+		assert.True(t, os.IsNotExist(err))
+	}
+
+	// Shift.
+	{
+		err := router.PartitionRuleShift(from, to, "test", partition.Table)
+		assert.Nil(t, err)
+		for _, table := range []string{"t1", "t2"} {
+			file := path.Join(router.metadir, "test", table+".json")
+			conf, err := router.readTableFrmData(file)
+			assert.Nil(t, err)
+			assert.Equal(t, to, conf.Partitions[0].Backend)
+			_, err = os.Stat(file + frmBakSuffix)
+			assert.True(t, os.IsNotExist(err))
+		}
+	}
+
+	// The leftover temporary files are skipped by the load.
+	{
+		err := ioutil.WriteFile(path.Join(router.metadir, "test", "t1.json"+frmTmpSuffix), []byte("broken"), 0644)
+		assert.Nil(t, err)
+		err = router.ReLoad()
+		assert.Nil(t, err)
+	}
+}
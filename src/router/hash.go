@@ -18,10 +18,8 @@ import (
 	"config"
 
 	"github.com/pkg/errors"
-	jump "github.com/renstrom/go-jump-consistent-hash"
 
 	"github.com/xelabs/go-mysqlstack/sqlparser"
-	"github.com/xelabs/go-mysqlstack/sqlparser/depends/common"
 	"github.com/xelabs/go-mysqlstack/xlog"
 )
 
@@ -56,6 +54,12 @@ type Hash struct {
 	// table config
 	conf *config.TableConfig
 
+	// hash function name, set by the TableConfig.HashFunc
+	hashFunc string
+
+	// canonicalDate is set by the TableConfig.CanonicalDate
+	canonicalDate bool
+
 	// Partition map
 	partitions map[int]Segment
 	Segments   []Segment `json:",omitempty"`
@@ -68,6 +72,7 @@ func NewHash(log *xlog.Log, slots int, conf *config.TableConfig) *Hash {
 		conf:       conf,
 		slots:      slots,
 		typ:        methodTypeHash,
+		hashFunc:   HashFuncJump,
 		partitions: make(map[int]Segment),
 		Segments:   make([]Segment, 0, 16),
 	}
@@ -78,6 +83,11 @@ func (h *Hash) Build() error {
 	var err error
 	var start, end int
 
+	if _, err = getHashFunc(h.conf.HashFunc); err != nil {
+		return err
+	}
+	h.hashFunc = hashFuncName(h.conf.HashFunc)
+	h.canonicalDate = h.conf.CanonicalDate
 	for _, part := range h.conf.Partitions {
 		segments := strings.Split(part.Segment, "-")
 		if len(segments) != 2 {
@@ -161,28 +171,17 @@ func (h *Hash) Type() MethodType {
 }
 
 // GetIndex returns index based on sqlval.
+// The key is normalized first, then hashed by the hash function of the table.
 func (h *Hash) GetIndex(sqlval *sqlparser.SQLVal) (int, error) {
-	idx := -1
-	valStr := common.BytesToString(sqlval.Val)
-	switch sqlval.Type {
-	case sqlparser.IntVal:
-		unsigned, err := strconv.ParseInt(valStr, 0, 64)
-		if err != nil {
-			return -1, errors.Errorf("hash.getindex.val.key.parser.uint64.error:[%v]", err)
-		}
-		idx = int(jump.Hash(uint64(unsigned), int32(h.slots)))
-	case sqlparser.FloatVal:
-		unsigned, err := strconv.ParseFloat(valStr, 64)
-		if err != nil {
-			return -1, errors.Errorf("hash.getindex.val.key.parser.float.error:[%v]", err)
-		}
-		idx = int(jump.Hash(uint64(unsigned), int32(h.slots)))
-	case sqlparser.StrVal:
-		idx = int(jump.HashString(valStr, int32(h.slots), jump.CRC64))
-	default:
-		return -1, errors.Errorf("hash.unsupported.key.type:[%v]", sqlval.Type)
+	fn, err := getHashFunc(h.hashFunc)
+	if err != nil {
+		return -1, err
+	}
+	key, err := newHashKey(sqlval, h.canonicalDate)
+	if err != nil {
+		return -1, err
 	}
-	return idx, nil
+	return fn(key, h.slots)
 }
 
 // GetSegments returns Segments based on index.
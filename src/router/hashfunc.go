@@ -0,0 +1,268 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package router
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	jump "github.com/renstrom/go-jump-consistent-hash"
+
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/common"
+)
+
+const (
+	// HashFuncJump is the default hash function, jump consistent hash over the uint64 for the numbers
+	// and over the CRC64 for the strings.
+	HashFuncJump = "jump"
+	// HashFuncCRC32 is 'crc32(key) % slots', the key is hashed as its text.
+	HashFuncCRC32 = "crc32"
+	// HashFuncMurmur3 is 'murmur3_32(key) % slots', the key is hashed as its text.
+	HashFuncMurmur3 = "murmur3"
+	// HashFuncMod is 'key % slots', the key must be an integer.
+	HashFuncMod = "mod"
+)
+
+// hashFunc returns the slot of the key in [0, slots).
+type hashFunc func(key *hashKey, slots int) (int, error)
+
+var hashFuncs = map[string]hashFunc{
+	"":              jumpHash,
+	HashFuncJump:    jumpHash,
+	HashFuncCRC32:   crc32Hash,
+	HashFuncMurmur3: murmur3Hash,
+	HashFuncMod:     modHash,
+}
+
+// getHashFunc returns the hash function by name, the empty name is the default jump.
+func getHashFunc(name string) (hashFunc, error) {
+	fn, ok := hashFuncs[strings.ToLower(name)]
+	if !ok {
+		return nil, errors.Errorf("hash.func[%s].unsupported", name)
+	}
+	return fn, nil
+}
+
+// hashFuncName returns the lower name of the hash function, the empty is the default jump.
+func hashFuncName(name string) string {
+	if name == "" {
+		return HashFuncJump
+	}
+	return strings.ToLower(name)
+}
+
+// hashKey is the shard key normalized from the SQLVal, the same value written in
+// different forms gets the same key, such as 1, 1.0 and 1.00 or '2019-1-1' and '2019-01-01'
+// if the table hashes the canonical dates.
+type hashKey struct {
+	typ sqlparser.ValType
+
+	// num is the uint64 of the IntVal, the negative is in two's complement,
+	// and the truncated value of the FloatVal.
+	num uint64
+
+	// text is the canonical text of the key.
+	text string
+}
+
+// newHashKey normalizes the key:
+// IntVal: int64, or uint64 for the unsigned BIGINT above the int64.
+// FloatVal: DECIMAL, the text has no redundant zeros, the integral value is same as the IntVal.
+// StrVal: the DATE/DATETIME in the non-canonical form is formatted as MySQL returns it if canonicalDate,
+// otherwise the text is kept as it is.
+func newHashKey(sqlval *sqlparser.SQLVal, canonicalDate bool) (*hashKey, error) {
+	valStr := common.BytesToString(sqlval.Val)
+	key := &hashKey{typ: sqlval.Type}
+	switch sqlval.Type {
+	case sqlparser.IntVal:
+		signed, err := strconv.ParseInt(valStr, 0, 64)
+		if err == nil {
+			key.num = uint64(signed)
+			key.text = strconv.FormatInt(signed, 10)
+			return key, nil
+		}
+		// The unsigned BIGINT above the int64 is out of range.
+		if numErr, ok := err.(*strconv.NumError); ok && numErr.Err == strconv.ErrRange {
+			if unsigned, uerr := strconv.ParseUint(valStr, 0, 64); uerr == nil {
+				key.num = unsigned
+				key.text = strconv.FormatUint(unsigned, 10)
+				return key, nil
+			}
+		}
+		return nil, errors.Errorf("hash.getindex.val.key.parser.uint64.error:[%v]", err)
+	case sqlparser.FloatVal:
+		float, err := strconv.ParseFloat(valStr, 64)
+		if err != nil {
+			return nil, errors.Errorf("hash.getindex.val.key.parser.float.error:[%v]", err)
+		}
+		key.num = uint64(float)
+		key.text = canonicalDecimal(valStr, float)
+	case sqlparser.StrVal:
+		key.text = valStr
+		if canonicalDate {
+			key.text = canonicalDateText(valStr)
+		}
+	default:
+		return nil, errors.Errorf("hash.unsupported.key.type:[%v]", sqlval.Type)
+	}
+	return key, nil
+}
+
+// canonicalDecimal trims the sign '+', the leading zeros of the integer part
+// and the trailing zeros of the fraction part, such as '+01.50' --> '1.5', '1.00' --> '1'.
+func canonicalDecimal(s string, float float64) string {
+	if strings.ContainsAny(s, "eE") {
+		return strconv.FormatFloat(float, 'f', -1, 64)
+	}
+
+	neg := false
+	switch {
+	case strings.HasPrefix(s, "-"):
+		neg = true
+		s = s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+	intPart, fracPart := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		intPart, fracPart = s[:i], s[i+1:]
+	}
+	intPart = strings.TrimLeft(intPart, "0")
+	fracPart = strings.TrimRight(fracPart, "0")
+	if intPart == "" {
+		intPart = "0"
+	}
+
+	text := intPart
+	if fracPart != "" {
+		text += "." + fracPart
+	}
+	if neg && text != "0" {
+		text = "-" + text
+	}
+	return text
+}
+
+// dateKeyFormats are the layouts of the DATE/DATETIME key in the non-canonical form,
+// the fractional seconds are accepted after the seconds.
+var dateKeyFormats = []string{
+	"2006-1-2",
+	"2006-1-2 15:4:5",
+	"2006-1-2T15:4:5",
+}
+
+// canonicalDateText formats the DATE/DATETIME string as MySQL returns it, such as
+// '2019-1-1' --> '2019-01-01', '2019-01-01T8:00:00' --> '2019-01-01 08:00:00',
+// the fractional seconds are kept as they are, the others are returned as they are.
+func canonicalDateText(s string) string {
+	// Fast path, the date starts with the year.
+	if len(s) < 8 || s[4] != '-' {
+		return s
+	}
+	for i, layout := range dateKeyFormats {
+		v, err := time.Parse(layout, s)
+		if err != nil {
+			continue
+		}
+		if i == 0 {
+			return v.Format("2006-01-02")
+		}
+		text := v.Format("2006-01-02 15:04:05")
+		if dot := strings.LastIndexByte(s, '.'); dot > 0 {
+			text += s[dot:]
+		}
+		return text
+	}
+	return s
+}
+
+// jumpHash is the jump consistent hash, the default hash function.
+func jumpHash(key *hashKey, slots int) (int, error) {
+	switch key.typ {
+	case sqlparser.IntVal, sqlparser.FloatVal:
+		return int(jump.Hash(key.num, int32(slots))), nil
+	}
+	return int(jump.HashString(key.text, int32(slots), jump.CRC64)), nil
+}
+
+// crc32Hash is the 'crc32(key) % slots' with the IEEE polynomial, same as the MySQL CRC32().
+func crc32Hash(key *hashKey, slots int) (int, error) {
+	return int(crc32.ChecksumIEEE([]byte(key.text)) % uint32(slots)), nil
+}
+
+// murmur3Hash is the 'murmur3_32(key) % slots' with seed 0.
+func murmur3Hash(key *hashKey, slots int) (int, error) {
+	return int(murmur3Sum32([]byte(key.text), 0) % uint32(slots)), nil
+}
+
+// modHash is the 'key % slots', the negative key gets the non-negative remainder.
+func modHash(key *hashKey, slots int) (int, error) {
+	if signed, err := strconv.ParseInt(key.text, 10, 64); err == nil {
+		mod := signed % int64(slots)
+		if mod < 0 {
+			mod += int64(slots)
+		}
+		return int(mod), nil
+	}
+	if unsigned, err := strconv.ParseUint(key.text, 10, 64); err == nil {
+		return int(unsigned % uint64(slots)), nil
+	}
+	return -1, errors.Errorf("hash.mod.key[%s].must.be.integer", key.text)
+}
+
+// murmur3Sum32 returns the MurmurHash3_x86_32 of the data.
+func murmur3Sum32(data []byte, seed uint32) uint32 {
+	const (
+		c1 = 0xcc9e2d51
+		c2 = 0x1b873593
+	)
+
+	h := seed
+	nblocks := len(data) / 4
+	for i := 0; i < nblocks; i++ {
+		k := binary.LittleEndian.Uint32(data[i*4:])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+
+	var k uint32
+	tail := data[nblocks*4:]
+	switch len(tail) {
+	case 3:
+		k ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(tail[0])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
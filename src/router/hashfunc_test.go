@@ -0,0 +1,250 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package router
+
+import (
+	"fmt"
+	"hash/crc32"
+	"testing"
+
+	"config"
+
+	jump "github.com/renstrom/go-jump-consistent-hash"
+	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+// mockHashFuncConfig returns the table with one partition per slot, the partition index is the slot.
+func mockHashFuncConfig(hashFunc string, slots int) *config.TableConfig {
+	conf := &config.TableConfig{
+		Name:      "A",
+		Slots:     slots,
+		ShardType: "HASH",
+		ShardKey:  "id",
+		HashFunc:  hashFunc,
+
+		CanonicalDate: true,
+	}
+	for i := 0; i < slots; i++ {
+		conf.Partitions = append(conf.Partitions, &config.PartitionConfig{
+			Table:   fmt.Sprintf("A%d", i),
+			Segment: fmt.Sprintf("%d-%d", i, i+1),
+			Backend: "backend0",
+		})
+	}
+	return conf
+}
+
+func TestHashFuncMurmur3(t *testing.T) {
+	assert.Equal(t, uint32(0), murmur3Sum32([]byte(""), 0))
+	assert.Equal(t, uint32(0x248bfa47), murmur3Sum32([]byte("hello"), 0))
+	assert.Equal(t, uint32(0x2e4ff723), murmur3Sum32([]byte("The quick brown fox jumps over the lazy dog"), 0))
+}
+
+func TestHashFuncCanonical(t *testing.T) {
+	decimals := []struct {
+		in  string
+		out string
+	}{
+		{"1.50", "1.5"},
+		{"+01.00", "1"},
+		{"-0.0", "0"},
+		{"-12.340", "-12.34"},
+		{".5", "0.5"},
+		{"1.5e2", "150"},
+	}
+	for _, d := range decimals {
+		key, err := newHashKey(sqlparser.NewFloatVal([]byte(d.in)), true)
+		assert.Nil(t, err)
+		assert.Equal(t, d.out, key.text, d.in)
+	}
+
+	dates := []struct {
+		in  string
+		out string
+	}{
+		{"2019-1-1", "2019-01-01"},
+		{"2019-01-01", "2019-01-01"},
+		{"2019-1-1 8:0:0", "2019-01-01 08:00:00"},
+		{"2019-01-01T08:00:00.500", "2019-01-01 08:00:00.500"},
+		{"2019-13-01", "2019-13-01"},
+		{"20190101", "20190101"},
+		{"shardkey", "shardkey"},
+	}
+	for _, d := range dates {
+		key, err := newHashKey(sqlparser.NewStrVal([]byte(d.in)), true)
+		assert.Nil(t, err)
+		assert.Equal(t, d.out, key.text, d.in)
+
+		// The tables created before hash the text as it is.
+		key, err = newHashKey(sqlparser.NewStrVal([]byte(d.in)), false)
+		assert.Nil(t, err)
+		assert.Equal(t, d.in, key.text, d.in)
+	}
+}
+
+func TestHashFuncGetIndex(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	slots := 16
+
+	// The same value in different forms gets the same slot for all functions.
+	for _, name := range []string{"", HashFuncJump, HashFuncCRC32, HashFuncMurmur3, HashFuncMod} {
+		hash := NewHash(log, slots, mockHashFuncConfig(name, slots))
+		err := hash.Build()
+		assert.Nil(t, err)
+
+		same := [][]*sqlparser.SQLVal{
+			{sqlparser.NewIntVal([]byte("1")), sqlparser.NewFloatVal([]byte("1.00"))},
+			{sqlparser.NewIntVal([]byte("0x10")), sqlparser.NewIntVal([]byte("16"))},
+			{sqlparser.NewIntVal([]byte("18446744073709551615")), sqlparser.NewIntVal([]byte("0xffffffffffffffff"))},
+		}
+		if name != HashFuncMod {
+			same = append(same,
+				[]*sqlparser.SQLVal{sqlparser.NewFloatVal([]byte("1.50")), sqlparser.NewFloatVal([]byte("1.5"))},
+				[]*sqlparser.SQLVal{sqlparser.NewStrVal([]byte("2019-1-1")), sqlparser.NewStrVal([]byte("2019-01-01"))},
+			)
+		}
+		for _, vals := range same {
+			want, err := hash.GetIndex(vals[0])
+			assert.Nil(t, err)
+			assert.True(t, want >= 0 && want < slots)
+			got, err := hash.GetIndex(vals[1])
+			assert.Nil(t, err)
+			assert.Equal(t, want, got, "%s:%s", name, vals[1].Val)
+		}
+	}
+
+	// crc32
+	{
+		hash := NewHash(log, slots, mockHashFuncConfig(HashFuncCRC32, slots))
+		err := hash.Build()
+		assert.Nil(t, err)
+		idx, err := hash.GetIndex(sqlparser.NewIntVal([]byte("123456789")))
+		assert.Nil(t, err)
+		assert.Equal(t, int(crc32.ChecksumIEEE([]byte("123456789"))%uint32(slots)), idx)
+	}
+
+	// murmur3
+	{
+		hash := NewHash(log, slots, mockHashFuncConfig(HashFuncMurmur3, slots))
+		err := hash.Build()
+		assert.Nil(t, err)
+		idx, err := hash.GetIndex(sqlparser.NewStrVal([]byte("hello")))
+		assert.Nil(t, err)
+		assert.Equal(t, 0x248bfa47%slots, idx)
+	}
+
+	// mod
+	{
+		hash := NewHash(log, slots, mockHashFuncConfig("MOD", slots))
+		err := hash.Build()
+		assert.Nil(t, err)
+		idx, err := hash.GetIndex(sqlparser.NewIntVal([]byte("33")))
+		assert.Nil(t, err)
+		assert.Equal(t, 1, idx)
+		idx, err = hash.GetIndex(sqlparser.NewIntVal([]byte("-1")))
+		assert.Nil(t, err)
+		assert.Equal(t, 15, idx)
+		idx, err = hash.GetIndex(sqlparser.NewIntVal([]byte("18446744073709551615")))
+		assert.Nil(t, err)
+		assert.Equal(t, 15, idx)
+		idx, err = hash.GetIndex(sqlparser.NewStrVal([]byte("17")))
+		assert.Nil(t, err)
+		assert.Equal(t, 1, idx)
+
+		_, err = hash.GetIndex(sqlparser.NewFloatVal([]byte("1.5")))
+		assert.Equal(t, "hash.mod.key[1.5].must.be.integer", err.Error())
+	}
+}
+
+func TestHashFuncLegacyDate(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	slots := 4096
+
+	// The table created before keeps the slot of the date text as it is.
+	conf := mockHashFuncConfig("", slots)
+	conf.CanonicalDate = false
+	hash := NewHash(log, slots, conf)
+	err := hash.Build()
+	assert.Nil(t, err)
+	for _, date := range []string{"2019-1-1", "2019-01-01T08:00:00"} {
+		idx, err := hash.GetIndex(sqlparser.NewStrVal([]byte(date)))
+		assert.Nil(t, err)
+		assert.Equal(t, int(jump.HashString(date, int32(slots), jump.CRC64)), idx)
+	}
+}
+
+func TestHashFuncErrors(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+
+	// Build.
+	{
+		hash := NewHash(log, 4, mockHashFuncConfig("md5", 4))
+		err := hash.Build()
+		assert.Equal(t, "hash.func[md5].unsupported", err.Error())
+	}
+
+	// GetIndex.
+	{
+		hash := NewHash(log, 4, mockHashFuncConfig(HashFuncCRC32, 4))
+		err := hash.Build()
+		assert.Nil(t, err)
+		_, err = hash.GetIndex(sqlparser.NewIntVal([]byte("18446744073709551616")))
+		assert.NotNil(t, err)
+		_, err = hash.GetIndex(sqlparser.NewHexVal([]byte("ff")))
+		assert.Equal(t, "hash.unsupported.key.type:[4]", err.Error())
+	}
+}
+
+func TestHashFuncCreateTable(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	router, cleanup := MockNewRouter(log)
+	defer cleanup()
+
+	router.CreateDatabase("test")
+	err := router.CreateTable("test", "t1", "id", []string{"backend1", "backend2"}, &Extra{HashFunc: "CRC32", TableGroup: "g1"})
+	assert.Nil(t, err)
+	err = router.CreateTable("test", "t2", "id", []string{"backend1", "backend2"}, &Extra{HashFunc: "jump"})
+	assert.Nil(t, err)
+
+	t1, err := router.TableConfig("test", "t1")
+	assert.Nil(t, err)
+	assert.Equal(t, HashFuncCRC32, t1.HashFunc)
+	t2, err := router.TableConfig("test", "t2")
+	assert.Nil(t, err)
+	assert.Equal(t, "", t2.HashFunc)
+
+	// The group member follows the leader.
+	err = router.CreateTable("test", "t3", "id", nil, &Extra{TableGroup: "g1"})
+	assert.Nil(t, err)
+	t3, err := router.TableConfig("test", "t3")
+	assert.Nil(t, err)
+	assert.Equal(t, HashFuncCRC32, t3.HashFunc)
+
+	// The new tables hash the canonical dates.
+	assert.True(t, t1.CanonicalDate)
+	assert.True(t, t2.CanonicalDate)
+	assert.True(t, t3.CanonicalDate)
+
+	// The key is hashed by the function of the table.
+	idx, err := router.GetIndex("test", "t1", sqlparser.NewIntVal([]byte("1")))
+	assert.Nil(t, err)
+	assert.Equal(t, int(crc32.ChecksumIEEE([]byte("1"))%uint32(t1.Slots)), idx)
+
+	// Errors.
+	{
+		err = router.CreateTable("test", "t4", "id", nil, &Extra{TableGroup: "g1", HashFunc: "murmur3"})
+		assert.Equal(t, "router.compute.tablegroup[g1].hashfunc[murmur3].must.be.same.as[crc32]", err.Error())
+		err = router.CreateTable("test", "t5", "", []string{"backend1"}, &Extra{HashFunc: "crc32"})
+		assert.Equal(t, "router.table[test.t5].shardtype[GLOBAL].cant.set.hashfunc", err.Error())
+		err = router.CreateTable("test", "t6", "id", []string{"backend1"}, &Extra{HashFunc: "md5"})
+		assert.Equal(t, "hash.func[md5].unsupported", err.Error())
+	}
+}
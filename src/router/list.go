@@ -0,0 +1,240 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package router
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"config"
+
+	"github.com/pkg/errors"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/common"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+const (
+	// listDefault is the segment of the list default partition.
+	listDefault = "DEFAULT"
+)
+
+// ListRange for Segment.Range.
+type ListRange struct {
+	idx    int
+	values []string
+}
+
+// String returns the value set, such as {1,2,3}.
+func (r *ListRange) String() string {
+	if r.values == nil {
+		return listDefault
+	}
+	return fmt.Sprintf("{%s}", strings.Join(r.values, ","))
+}
+
+// Less impl.
+func (r *ListRange) Less(b KeyRange) bool {
+	v := b.(*ListRange)
+	return r.idx < v.idx
+}
+
+// List tuple.
+// The PartitionConfig.Values is the shard key value set of the partition,
+// the partition whose Segment is DEFAULT holds the values not in any set.
+type List struct {
+	log *xlog.Log
+
+	// list method.
+	typ MethodType
+
+	// table config.
+	conf *config.TableConfig
+
+	// values map, key is the normalized value, value is the index of Segments.
+	values map[string]int
+
+	// index of the default partition, -1 if none.
+	defaultIdx int
+
+	// numeric is true if the shard key column is numeric(or the column type is unknown),
+	// the integer values are normalized, so '01' and 1 are the same key.
+	numeric bool
+
+	// Segments slice.
+	Segments []Segment `json:",omitempty"`
+}
+
+// NewList creates new list.
+func NewList(log *xlog.Log, conf *config.TableConfig) *List {
+	return &List{
+		log:        log,
+		conf:       conf,
+		typ:        methodTypeList,
+		values:     make(map[string]int),
+		defaultIdx: -1,
+		Segments:   make([]Segment, 0, 16),
+	}
+}
+
+// Build used to build the list segments from schema config.
+func (l *List) Build() error {
+	if l.conf == nil {
+		return errors.New("table.config..can't.be.nil")
+	}
+	if len(l.conf.Partitions) == 0 {
+		return errors.New("list.partitions.can't.be.null")
+	}
+
+	l.numeric = true
+	if len(l.conf.ShardKeyTypes) > 0 {
+		l.numeric = isNumericType(l.conf.ShardKeyTypes[0])
+	}
+	for i, part := range l.conf.Partitions {
+		var values []string
+		if strings.ToUpper(strings.TrimSpace(part.Segment)) == listDefault {
+			if l.defaultIdx != -1 {
+				return errors.Errorf("list.partition[%v].default.duplicate", part.Table)
+			}
+			l.defaultIdx = i
+		} else {
+			if len(part.Values) == 0 {
+				return errors.Errorf("list.partition[%v].values.can't.be.null", part.Table)
+			}
+			for _, v := range part.Values {
+				key := l.listKey(v)
+				if idx, ok := l.values[key]; ok {
+					return errors.Errorf("list.partition[%v].value[%v].duplicate.with[%v]", part.Table, v, l.conf.Partitions[idx].Table)
+				}
+				l.values[key] = i
+				values = append(values, v)
+			}
+		}
+
+		l.Segments = append(l.Segments, Segment{
+			Table:   part.Table,
+			Backend: part.Backend,
+			Range: &ListRange{
+				idx:    i,
+				values: values,
+			},
+		})
+	}
+	return nil
+}
+
+// isNumericType returns true if the column type is the integer, fixed-point or floating-point type.
+func isNumericType(typ string) bool {
+	switch strings.ToLower(typ) {
+	case "bit", "tinyint", "smallint", "mediumint", "int", "integer", "bigint",
+		"decimal", "numeric", "float", "double", "real":
+		return true
+	}
+	return false
+}
+
+// listKey normalizes the integer value of the numeric column, so '01' and 1 are the same key,
+// but they are different in the string column.
+func (l *List) listKey(v string) string {
+	if !l.numeric {
+		return v
+	}
+	if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return strconv.FormatInt(i, 10)
+	}
+	return v
+}
+
+// Lookup used to lookup partition(s).
+// List only handles the equal, others return all partitions.
+func (l *List) Lookup(start *sqlparser.SQLVal, end *sqlparser.SQLVal) ([]Segment, error) {
+	if start == nil || end == nil {
+		return l.Segments, nil
+	}
+	if start.Type == end.Type && bytes.Equal(start.Val, end.Val) {
+		idx, err := l.GetIndex(start)
+		if err != nil {
+			return nil, err
+		}
+		return []Segment{l.Segments[idx]}, nil
+	}
+	return l.Segments, nil
+}
+
+// Type returns the list type.
+func (l *List) Type() MethodType {
+	return l.typ
+}
+
+// GetIndex returns index based on sqlval.
+// The unlisted key without the default partition is stored in none of the partitions,
+// returns the first one(empty route) to make sure the reads still get a result set.
+func (l *List) GetIndex(sqlval *sqlparser.SQLVal) (int, error) {
+	idx, err := l.search(sqlval)
+	if err != nil {
+		return -1, err
+	}
+	if idx == -1 {
+		return 0, nil
+	}
+	return idx, nil
+}
+
+// locate returns the index of the partition to store the row with the key,
+// errors if the key is unlisted and there's no default partition.
+func (l *List) locate(sqlval *sqlparser.SQLVal) (int, error) {
+	idx, err := l.search(sqlval)
+	if err != nil {
+		return -1, err
+	}
+	if idx == -1 {
+		return -1, errors.Errorf("list.getindex.key[%s].has.no.partition", sqlval.Val)
+	}
+	return idx, nil
+}
+
+// search returns the index of the partition which lists the key, the default one
+// if the key is unlisted, returns -1 if there's no default partition.
+func (l *List) search(sqlval *sqlparser.SQLVal) (int, error) {
+	valStr := common.BytesToString(sqlval.Val)
+	key := valStr
+	switch sqlval.Type {
+	case sqlparser.IntVal, sqlparser.StrVal:
+		key = l.listKey(valStr)
+	case sqlparser.FloatVal:
+		if !l.numeric {
+			break
+		}
+		v, err := strconv.ParseFloat(valStr, 64)
+		if err != nil {
+			return -1, errors.Errorf("list.getindex.val.key.parser.float.error:[%v]", err)
+		}
+		if v == math.Trunc(v) {
+			key = strconv.FormatInt(int64(v), 10)
+		}
+	default:
+		return -1, errors.Errorf("list.unsupported.key.type:[%v]", sqlval.Type)
+	}
+
+	if idx, ok := l.values[key]; ok {
+		return idx, nil
+	}
+	return l.defaultIdx, nil
+}
+
+// GetSegments returns Segments based on index.
+func (l *List) GetSegments(index int) []Segment {
+	if index < 0 || index >= len(l.Segments) {
+		return l.Segments
+	}
+	return []Segment{l.Segments[index]}
+}
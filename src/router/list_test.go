@@ -0,0 +1,210 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package router
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+func TestList(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	list := NewList(log, MockTableLConfig())
+	err := list.Build()
+	assert.Nil(t, err)
+	assert.Equal(t, methodTypeList, string(list.Type()))
+	assert.Equal(t, 3, len(list.Segments))
+	assert.Equal(t, 2, list.defaultIdx)
+
+	want := []string{"{1,2,cn}", "{3,us}", "DEFAULT"}
+	for i, seg := range list.Segments {
+		assert.Equal(t, want[i], seg.Range.String())
+	}
+	assert.True(t, list.Segments[0].Range.Less(list.Segments[1].Range))
+	assert.False(t, list.Segments[2].Range.Less(list.Segments[1].Range))
+}
+
+func TestListBuildError(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+
+	// Nil config.
+	{
+		list := NewList(log, nil)
+		err := list.Build()
+		assert.NotNil(t, err)
+	}
+
+	// No partitions.
+	{
+		conf := MockTableLConfig()
+		conf.Partitions = nil
+		list := NewList(log, conf)
+		err := list.Build()
+		want := "list.partitions.can't.be.null"
+		assert.Equal(t, want, err.Error())
+	}
+
+	// No values.
+	{
+		conf := MockTableLConfig()
+		conf.Partitions[1].Values = nil
+		list := NewList(log, conf)
+		err := list.Build()
+		want := "list.partition[L1].values.can't.be.null"
+		assert.Equal(t, want, err.Error())
+	}
+
+	// Duplicate value.
+	{
+		conf := MockTableLConfig()
+		conf.Partitions[1].Values = []string{"01"}
+		list := NewList(log, conf)
+		err := list.Build()
+		want := "list.partition[L1].value[01].duplicate.with[L0]"
+		assert.Equal(t, want, err.Error())
+	}
+
+	// Duplicate default.
+	{
+		conf := MockTableLConfig()
+		conf.Partitions[1].Segment = "default"
+		list := NewList(log, conf)
+		err := list.Build()
+		want := "list.partition[L2].default.duplicate"
+		assert.Equal(t, want, err.Error())
+	}
+}
+
+func TestListGetIndex(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	list := NewList(log, MockTableLConfig())
+	err := list.Build()
+	assert.Nil(t, err)
+
+	tests := []struct {
+		val *sqlparser.SQLVal
+		idx int
+	}{
+		{sqlparser.NewIntVal([]byte("1")), 0},
+		{sqlparser.NewStrVal([]byte("02")), 0},
+		{sqlparser.NewStrVal([]byte("cn")), 0},
+		{sqlparser.NewFloatVal([]byte("3.0")), 1},
+		{sqlparser.NewStrVal([]byte("us")), 1},
+		{sqlparser.NewFloatVal([]byte("3.5")), 2},
+		{sqlparser.NewStrVal([]byte("uk")), 2},
+	}
+	for _, test := range tests {
+		idx, err := list.GetIndex(test.val)
+		assert.Nil(t, err)
+		assert.Equal(t, test.idx, idx)
+		assert.Equal(t, 1, len(list.GetSegments(idx)))
+	}
+	assert.Equal(t, 3, len(list.GetSegments(-1)))
+
+	// Errors.
+	{
+		_, err := list.GetIndex(sqlparser.NewHexVal([]byte("3f")))
+		want := "list.unsupported.key.type:[4]"
+		assert.Equal(t, want, err.Error())
+	}
+
+	// Without default partition.
+	{
+		conf := MockTableLConfig()
+		conf.Partitions = conf.Partitions[:2]
+		list := NewList(log, conf)
+		err := list.Build()
+		assert.Nil(t, err)
+
+		// The reads go to the empty route, the row can't be stored.
+		idx, err := list.GetIndex(sqlparser.NewStrVal([]byte("uk")))
+		assert.Nil(t, err)
+		assert.Equal(t, 0, idx)
+		_, err = list.locate(sqlparser.NewStrVal([]byte("uk")))
+		want := "list.getindex.key[uk].has.no.partition"
+		assert.Equal(t, want, err.Error())
+	}
+}
+
+func TestListColumnType(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+
+	// The string column, '01' and '1' are different keys.
+	{
+		conf := MockTableLConfig()
+		conf.ShardKeyTypes = []string{"varchar"}
+		conf.Partitions[1].Values = []string{"01", "us"}
+		list := NewList(log, conf)
+		err := list.Build()
+		assert.Nil(t, err)
+
+		tests := []struct {
+			val *sqlparser.SQLVal
+			idx int
+		}{
+			{sqlparser.NewStrVal([]byte("1")), 0},
+			{sqlparser.NewStrVal([]byte("01")), 1},
+			{sqlparser.NewIntVal([]byte("1")), 0},
+			{sqlparser.NewStrVal([]byte("001")), 2},
+			{sqlparser.NewFloatVal([]byte("1.0")), 2},
+		}
+		for _, test := range tests {
+			idx, err := list.GetIndex(test.val)
+			assert.Nil(t, err)
+			assert.Equal(t, test.idx, idx)
+		}
+	}
+
+	// The numeric column, '01' and 1 are the same key.
+	{
+		conf := MockTableLConfig()
+		conf.ShardKeyTypes = []string{"INT"}
+		conf.Partitions[1].Values = []string{"01"}
+		list := NewList(log, conf)
+		err := list.Build()
+		want := "list.partition[L1].value[01].duplicate.with[L0]"
+		assert.Equal(t, want, err.Error())
+	}
+}
+
+func TestListLookup(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	list := NewList(log, MockTableLConfig())
+	err := list.Build()
+	assert.Nil(t, err)
+
+	val := sqlparser.NewStrVal([]byte("us"))
+	{
+		segments, err := list.Lookup(val, val)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(segments))
+		assert.Equal(t, "L1", segments[0].Table)
+	}
+
+	{
+		segments, err := list.Lookup(val, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(segments))
+	}
+
+	{
+		segments, err := list.Lookup(sqlparser.NewIntVal([]byte("1")), sqlparser.NewIntVal([]byte("3")))
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(segments))
+	}
+
+	{
+		hex := sqlparser.NewHexVal([]byte("3f"))
+		_, err := list.Lookup(hex, hex)
+		assert.NotNil(t, err)
+	}
+}
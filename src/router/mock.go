@@ -128,6 +128,39 @@ func MockTableBConfig() *config.TableConfig {
 	return mock
 }
 
+// MockTableCConfig config, composite shardkey.
+func MockTableCConfig() *config.TableConfig {
+	mock := &config.TableConfig{
+		Name:       "C",
+		ShardType:  "HASH",
+		ShardKey:   "a,b",
+		Partitions: make([]*config.PartitionConfig, 0, 16),
+	}
+	S0512 := &config.PartitionConfig{
+		Table:   "C0",
+		Segment: "0-512",
+		Backend: "backend1",
+	}
+	S11024 := &config.PartitionConfig{
+		Table:   "C1",
+		Segment: "512-4096",
+		Backend: "backend2",
+	}
+
+	mock.Partitions = append(mock.Partitions, S0512, S11024)
+	return mock
+}
+
+// MockTableC1Config config, composite shardkey with the same shards as C.
+func MockTableC1Config() *config.TableConfig {
+	mock := MockTableCConfig()
+	mock.Name = "C1"
+	mock.ShardKey = "x,y"
+	mock.Partitions[0].Table = "C1_0"
+	mock.Partitions[1].Table = "C1_1"
+	return mock
+}
+
 // MockTableSegmentErr1Config config.
 func MockTableSegmentErr1Config() *config.TableConfig {
 	mock := &config.TableConfig{
@@ -331,6 +364,144 @@ func MockTableG1Config() *config.TableConfig {
 	return mock
 }
 
+// MockTableRConfig config, range shardtype.
+func MockTableRConfig() *config.TableConfig {
+	mock := &config.TableConfig{
+		Name:       "R",
+		ShardType:  "RANGE",
+		ShardKey:   "id",
+		Partitions: make([]*config.PartitionConfig, 0, 16),
+	}
+	S0 := &config.PartitionConfig{
+		Table:   "R0",
+		Segment: "100",
+		Backend: "backend0",
+	}
+	S1 := &config.PartitionConfig{
+		Table:   "R1",
+		Segment: "200",
+		Backend: "backend1",
+	}
+	S2 := &config.PartitionConfig{
+		Table:   "R2",
+		Segment: "MAXVALUE",
+		Backend: "backend2",
+	}
+	mock.Partitions = append(mock.Partitions, S0, S1, S2)
+	return mock
+}
+
+// MockTableRDateConfig config, range shardtype with date bounds.
+func MockTableRDateConfig() *config.TableConfig {
+	mock := &config.TableConfig{
+		Name:       "RD",
+		ShardType:  "RANGE",
+		ShardKey:   "dt",
+		Partitions: make([]*config.PartitionConfig, 0, 16),
+	}
+	S0 := &config.PartitionConfig{
+		Table:   "RD0",
+		Segment: "2019-01-01",
+		Backend: "backend0",
+	}
+	S1 := &config.PartitionConfig{
+		Table:   "RD1",
+		Segment: "2019-02-01",
+		Backend: "backend1",
+	}
+	mock.Partitions = append(mock.Partitions, S0, S1)
+	return mock
+}
+
+// MockTableLConfig config, list shardtype.
+func MockTableLConfig() *config.TableConfig {
+	mock := &config.TableConfig{
+		Name:       "L",
+		ShardType:  "LIST",
+		ShardKey:   "id",
+		Partitions: make([]*config.PartitionConfig, 0, 16),
+	}
+	S0 := &config.PartitionConfig{
+		Table:   "L0",
+		Backend: "backend0",
+		Values:  []string{"1", "2", "cn"},
+	}
+	S1 := &config.PartitionConfig{
+		Table:   "L1",
+		Backend: "backend1",
+		Values:  []string{"3", "us"},
+	}
+	S2 := &config.PartitionConfig{
+		Table:   "L2",
+		Segment: "DEFAULT",
+		Backend: "backend2",
+	}
+	mock.Partitions = append(mock.Partitions, S0, S1, S2)
+	return mock
+}
+
+// MockTableTConfig config, monthly time shardtype.
+func MockTableTConfig() *config.TableConfig {
+	mock := &config.TableConfig{
+		Name:       "T",
+		ShardType:  "TIME",
+		ShardKey:   "dt",
+		Interval:   "MONTH",
+		Partitions: make([]*config.PartitionConfig, 0, 16),
+	}
+	S0 := &config.PartitionConfig{
+		Table:   "T_201901",
+		Segment: "2019-01-01",
+		Backend: "backend0",
+	}
+	S1 := &config.PartitionConfig{
+		Table:   "T_201902",
+		Segment: "2019-02-01",
+		Backend: "backend1",
+	}
+	S2 := &config.PartitionConfig{
+		Table:   "T_201904",
+		Segment: "2019-04-01",
+		Backend: "backend0",
+	}
+	mock.Partitions = append(mock.Partitions, S0, S1, S2)
+	return mock
+}
+
+// MockTableSConfig config, single shardtype.
+func MockTableSConfig() *config.TableConfig {
+	mock := &config.TableConfig{
+		Name:       "S",
+		ShardType:  "SINGLE",
+		ShardKey:   "",
+		Partitions: make([]*config.PartitionConfig, 0, 1),
+	}
+	S0 := &config.PartitionConfig{
+		Table:   "S",
+		Segment: "",
+		Backend: "backend1",
+	}
+	mock.Partitions = append(mock.Partitions, S0)
+	return mock
+}
+
+// MockTableS1Config config, single shardtype.
+func MockTableS1Config() *config.TableConfig {
+	mock := &config.TableConfig{
+		Name:       "S1",
+		ShardType:  "SINGLE",
+		ShardKey:   "",
+		Partitions: make([]*config.PartitionConfig, 0, 1),
+	}
+	S0 := &config.PartitionConfig{
+		Table:   "S1",
+		Segment: "",
+		Backend: "backend2",
+	}
+	mock.Partitions = append(mock.Partitions, S0)
+	return mock
+}
+
 // mockTmpDir is only used for MockNewRouter()
 var (
 	log        = xlog.NewStdLog(xlog.Level(xlog.PANIC))
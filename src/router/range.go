@@ -0,0 +1,392 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package router
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"config"
+
+	"github.com/pkg/errors"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/common"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+const (
+	// rangeMaxValue is the upper bound of the last range partition.
+	rangeMaxValue = "MAXVALUE"
+)
+
+// RangeInterval tuple.
+// [Start, End), empty Start means MINVALUE and empty End means MAXVALUE.
+type RangeInterval struct {
+	Start string
+	End   string
+}
+
+// String returns start-end info.
+func (r *RangeInterval) String() string {
+	start, end := r.Start, r.End
+	if start == "" {
+		start = "MINVALUE"
+	}
+	if end == "" {
+		end = rangeMaxValue
+	}
+	return fmt.Sprintf("[%v-%v)", start, end)
+}
+
+// Less impl.
+func (r *RangeInterval) Less(b KeyRange) bool {
+	v := b.(*RangeInterval)
+	if r.Start == "" || v.Start == "" {
+		return r.Start == "" && v.Start != ""
+	}
+	x, xerr := strconv.ParseInt(r.Start, 10, 64)
+	y, yerr := strconv.ParseInt(v.Start, 10, 64)
+	if xerr == nil && yerr == nil {
+		return x < y
+	}
+	xt, xok := parseTimeKey(r.Start)
+	yt, yok := parseTimeKey(v.Start)
+	if xok && yok {
+		return xt.Before(yt)
+	}
+	return r.Start < v.Start
+}
+
+// rangeKind is the kind of the column which the bounds and keys are compared by.
+type rangeKind int
+
+const (
+	rangeString rangeKind = iota
+	rangeNumeric
+	rangeDate
+)
+
+// rangeKey is the bound or the key parsed by the rangeKind.
+type rangeKey struct {
+	num  *big.Rat
+	date time.Time
+	str  string
+}
+
+// isDateType returns true if the column type is the date or time type.
+func isDateType(typ string) bool {
+	switch strings.ToLower(typ) {
+	case "date", "datetime", "timestamp":
+		return true
+	}
+	return false
+}
+
+// parseNumber parses the number like MySQL casts the string to the number,
+// the longest numeric prefix is used(0 if there's none), and false is returned
+// if the whole string isn't a number.
+func parseNumber(s string) (*big.Rat, bool) {
+	end, digits := 0, false
+	if end < len(s) && (s[end] == '+' || s[end] == '-') {
+		end++
+	}
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end, digits = end+1, true
+	}
+	if end < len(s) && s[end] == '.' {
+		end++
+		for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+			end, digits = end+1, true
+		}
+	}
+	if !digits {
+		return new(big.Rat), false
+	}
+	if end < len(s) && (s[end] == 'e' || s[end] == 'E') {
+		exp := end + 1
+		if exp < len(s) && (s[exp] == '+' || s[exp] == '-') {
+			exp++
+		}
+		if exp < len(s) && s[exp] >= '0' && s[exp] <= '9' {
+			for exp < len(s) && s[exp] >= '0' && s[exp] <= '9' {
+				exp++
+			}
+			end = exp
+		}
+	}
+	v, ok := new(big.Rat).SetString(strings.TrimSuffix(s[:end], "."))
+	if !ok {
+		return new(big.Rat), false
+	}
+	return v, end == len(s)
+}
+
+// Range tuple.
+// The PartitionConfig.Segment is the exclusive upper bound of the partition,
+// the partitions must be in ascending order and only the last can be MAXVALUE.
+type Range struct {
+	log *xlog.Log
+
+	// range method.
+	typ MethodType
+
+	// table config.
+	conf *config.TableConfig
+
+	// kind is the kind of the shard key column. If the column type is unknown, the bounds
+	// are numbers if all of them are numbers, dates if all of them are dates, otherwise strings.
+	kind rangeKind
+
+	// upper bounds of the partitions, nil means MAXVALUE.
+	bounds []*rangeKey
+
+	// Segments slice.
+	Segments []Segment `json:",omitempty"`
+}
+
+// NewRange creates new range.
+func NewRange(log *xlog.Log, conf *config.TableConfig) *Range {
+	return &Range{
+		log:      log,
+		conf:     conf,
+		typ:      methodTypeRange,
+		Segments: make([]Segment, 0, 16),
+	}
+}
+
+// Build used to build the range segments from schema config.
+func (r *Range) Build() error {
+	if r.conf == nil {
+		return errors.New("table.config..can't.be.nil")
+	}
+	parts := r.conf.Partitions
+	if len(parts) == 0 {
+		return errors.New("range.partitions.can't.be.null")
+	}
+
+	numeric, date := true, true
+	for i, part := range parts {
+		bound := strings.TrimSpace(part.Segment)
+		if bound == "" {
+			return errors.Errorf("range.partition[%v].segment.can't.be.null", part.Table)
+		}
+		if strings.ToUpper(bound) == rangeMaxValue {
+			if i != len(parts)-1 {
+				return errors.Errorf("range.partition[%v].maxvalue.must.be.the.last", part.Table)
+			}
+			continue
+		}
+		if _, ok := parseNumber(bound); !ok {
+			numeric = false
+		}
+		if _, ok := parseTimeKey(bound); !ok {
+			date = false
+		}
+	}
+	switch types := r.conf.ShardKeyTypes; {
+	case len(types) > 0 && isNumericType(types[0]), len(types) == 0 && numeric:
+		r.kind = rangeNumeric
+	case len(types) > 0 && isDateType(types[0]), len(types) == 0 && date:
+		r.kind = rangeDate
+	default:
+		r.kind = rangeString
+	}
+
+	start := ""
+	r.bounds = make([]*rangeKey, len(parts))
+	for i, part := range parts {
+		end := strings.TrimSpace(part.Segment)
+		if strings.ToUpper(end) == rangeMaxValue {
+			end = ""
+		} else {
+			bound, ok := r.parseKey(end)
+			if !ok {
+				return errors.Errorf("range.partition[%v].segment[%v].invalid", part.Table, part.Segment)
+			}
+			r.bounds[i] = bound
+			if i > 0 && r.compare(r.bounds[i-1], bound) >= 0 {
+				return errors.Errorf("range.partition.segment[%v].must.be.greater.than[%v]", part.Segment, parts[i-1].Segment)
+			}
+		}
+
+		r.Segments = append(r.Segments, Segment{
+			Table:   part.Table,
+			Backend: part.Backend,
+			Range: &RangeInterval{
+				Start: start,
+				End:   end,
+			},
+		})
+		start = end
+	}
+	return nil
+}
+
+// parseKey returns the key of the string parsed by the kind, false if the string isn't valid
+// for the kind. The invalid string is cast like MySQL, a number by its numeric prefix or
+// the zero date.
+func (r *Range) parseKey(s string) (*rangeKey, bool) {
+	switch r.kind {
+	case rangeNumeric:
+		v, ok := parseNumber(strings.TrimSpace(s))
+		return &rangeKey{num: v}, ok
+	case rangeDate:
+		v, ok := parseTimeKey(strings.TrimSpace(s))
+		return &rangeKey{date: v}, ok
+	}
+	return &rangeKey{str: s}, true
+}
+
+// valueKey returns the key of the sqlval, false if the value isn't valid for the kind.
+func (r *Range) valueKey(sqlval *sqlparser.SQLVal) (*rangeKey, bool, error) {
+	switch sqlval.Type {
+	case sqlparser.IntVal, sqlparser.StrVal, sqlparser.FloatVal:
+		key, ok := r.parseKey(common.BytesToString(sqlval.Val))
+		return key, ok, nil
+	}
+	return nil, false, errors.Errorf("range.unsupported.key.type:[%v]", sqlval.Type)
+}
+
+// compare compares the keys x and y by the kind.
+func (r *Range) compare(x, y *rangeKey) int {
+	switch r.kind {
+	case rangeNumeric:
+		return x.num.Cmp(y.num)
+	case rangeDate:
+		switch {
+		case x.date.Before(y.date):
+			return -1
+		case x.date.After(y.date):
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(x.str, y.str)
+}
+
+// compareValue compares the sharding keys x and y by the kind, the invalid value is cast like MySQL.
+func (r *Range) compareValue(x, y *sqlparser.SQLVal) (int, error) {
+	kx, _, err := r.valueKey(x)
+	if err != nil {
+		return 0, err
+	}
+	ky, _, err := r.valueKey(y)
+	if err != nil {
+		return 0, err
+	}
+	return r.compare(kx, ky), nil
+}
+
+// search returns the first partition index whose upper bound is greater than the key,
+// returns len(Segments) if the key is out of all the partitions.
+func (r *Range) search(key *rangeKey) int {
+	return sort.Search(len(r.Segments), func(i int) bool {
+		return r.bounds[i] == nil || r.compare(key, r.bounds[i]) < 0
+	})
+}
+
+// searchValue returns the search index of the sqlval, the invalid value is cast like MySQL,
+// so the reads still get the route.
+func (r *Range) searchValue(sqlval *sqlparser.SQLVal) (int, error) {
+	key, _, err := r.valueKey(sqlval)
+	if err != nil {
+		return -1, err
+	}
+	return r.search(key), nil
+}
+
+// Lookup used to lookup partition(s) through the sharding-key range [start, end].
+// If start or end is nil, the interval is open on this side.
+func (r *Range) Lookup(start *sqlparser.SQLVal, end *sqlparser.SQLVal) ([]Segment, error) {
+	if start == nil && end == nil {
+		return r.Segments, nil
+	}
+
+	// Point lookup.
+	if start != nil && end != nil && start.Type == end.Type && bytes.Equal(start.Val, end.Val) {
+		idx, err := r.GetIndex(start)
+		if err != nil {
+			return nil, err
+		}
+		return []Segment{r.Segments[idx]}, nil
+	}
+
+	lo, hi := 0, len(r.Segments)-1
+	if start != nil {
+		idx, err := r.searchValue(start)
+		if err != nil {
+			return nil, err
+		}
+		lo = idx
+	}
+	if end != nil {
+		idx, err := r.searchValue(end)
+		if err != nil {
+			return nil, err
+		}
+		if idx < hi {
+			hi = idx
+		}
+	}
+	// None partition overlaps the interval, returns one of them
+	// to make sure the query still gets a result set.
+	if lo > hi {
+		return r.Segments[hi : hi+1], nil
+	}
+	return r.Segments[lo : hi+1], nil
+}
+
+// Type returns the range type.
+func (r *Range) Type() MethodType {
+	return r.typ
+}
+
+// GetIndex returns index based on sqlval.
+// The key beyond the last bound is stored in none of the partitions, returns the last
+// one(empty route) to make sure the reads and writes still get a result set.
+func (r *Range) GetIndex(sqlval *sqlparser.SQLVal) (int, error) {
+	idx, err := r.searchValue(sqlval)
+	if err != nil {
+		return -1, err
+	}
+	if idx == len(r.Segments) {
+		return idx - 1, nil
+	}
+	return idx, nil
+}
+
+// locate returns the index of the partition to store the row with the key,
+// errors if the key is invalid for the column or beyond the last bound.
+func (r *Range) locate(sqlval *sqlparser.SQLVal) (int, error) {
+	key, ok, err := r.valueKey(sqlval)
+	if err != nil {
+		return -1, err
+	}
+	if !ok {
+		return -1, errors.Errorf("range.getindex.key[%s].invalid", sqlval.Val)
+	}
+	idx := r.search(key)
+	if idx == len(r.Segments) {
+		return -1, errors.Errorf("range.getindex.key[%s].has.no.partition", sqlval.Val)
+	}
+	return idx, nil
+}
+
+// GetSegments returns Segments based on index.
+func (r *Range) GetSegments(index int) []Segment {
+	if index < 0 || index >= len(r.Segments) {
+		return r.Segments
+	}
+	return []Segment{r.Segments[index]}
+}
@@ -0,0 +1,314 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package router
+
+import (
+	"testing"
+
+	"config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+func TestRange(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	rng := NewRange(log, MockTableRConfig())
+	err := rng.Build()
+	assert.Nil(t, err)
+	assert.Equal(t, methodTypeRange, string(rng.Type()))
+	assert.Equal(t, rangeNumeric, rng.kind)
+	assert.Equal(t, 3, len(rng.Segments))
+
+	want := []string{"[MINVALUE-100)", "[100-200)", "[200-MAXVALUE)"}
+	for i, seg := range rng.Segments {
+		assert.Equal(t, want[i], seg.Range.String())
+	}
+	assert.True(t, rng.Segments[0].Range.Less(rng.Segments[1].Range))
+	assert.False(t, rng.Segments[2].Range.Less(rng.Segments[1].Range))
+}
+
+func TestRangeBuildError(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+
+	// Nil config.
+	{
+		rng := NewRange(log, nil)
+		err := rng.Build()
+		assert.NotNil(t, err)
+	}
+
+	// No partitions.
+	{
+		conf := MockTableRConfig()
+		conf.Partitions = nil
+		rng := NewRange(log, conf)
+		err := rng.Build()
+		want := "range.partitions.can't.be.null"
+		assert.Equal(t, want, err.Error())
+	}
+
+	// Empty segment.
+	{
+		conf := MockTableRConfig()
+		conf.Partitions[1].Segment = ""
+		rng := NewRange(log, conf)
+		err := rng.Build()
+		want := "range.partition[R1].segment.can't.be.null"
+		assert.Equal(t, want, err.Error())
+	}
+
+	// MAXVALUE not the last.
+	{
+		conf := MockTableRConfig()
+		conf.Partitions[0].Segment = "maxvalue"
+		rng := NewRange(log, conf)
+		err := rng.Build()
+		want := "range.partition[R0].maxvalue.must.be.the.last"
+		assert.Equal(t, want, err.Error())
+	}
+
+	// Not ascending.
+	{
+		conf := MockTableRConfig()
+		conf.Partitions[1].Segment = "100"
+		rng := NewRange(log, conf)
+		err := rng.Build()
+		want := "range.partition.segment[100].must.be.greater.than[100]"
+		assert.Equal(t, want, err.Error())
+	}
+}
+
+func TestRangeGetIndex(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	rng := NewRange(log, MockTableRConfig())
+	err := rng.Build()
+	assert.Nil(t, err)
+
+	tests := []struct {
+		val *sqlparser.SQLVal
+		idx int
+	}{
+		{sqlparser.NewIntVal([]byte("-1")), 0},
+		{sqlparser.NewIntVal([]byte("99")), 0},
+		{sqlparser.NewIntVal([]byte("100")), 1},
+		{sqlparser.NewFloatVal([]byte("199.99")), 1},
+		{sqlparser.NewStrVal([]byte("200")), 2},
+		{sqlparser.NewIntVal([]byte("9223372036854775807")), 2},
+		// The non-numeric literal is cast like MySQL.
+		{sqlparser.NewStrVal([]byte("xx")), 0},
+		{sqlparser.NewStrVal([]byte(" 150abc")), 1},
+		{sqlparser.NewStrVal([]byte("1.5e2")), 1},
+	}
+	for _, test := range tests {
+		idx, err := rng.GetIndex(test.val)
+		assert.Nil(t, err)
+		assert.Equal(t, test.idx, idx)
+		assert.Equal(t, 1, len(rng.GetSegments(idx)))
+	}
+	assert.Equal(t, 3, len(rng.GetSegments(-1)))
+
+	// Errors.
+	{
+		_, err := rng.GetIndex(sqlparser.NewHexVal([]byte("3f")))
+		want := "range.unsupported.key.type:[4]"
+		assert.Equal(t, want, err.Error())
+
+		// The row of the invalid key can't be stored.
+		_, err = rng.locate(sqlparser.NewStrVal([]byte("xx")))
+		want = "range.getindex.key[xx].invalid"
+		assert.Equal(t, want, err.Error())
+	}
+}
+
+func TestRangeLookup(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	rng := NewRange(log, MockTableRConfig())
+	err := rng.Build()
+	assert.Nil(t, err)
+
+	val := func(s string) *sqlparser.SQLVal {
+		return sqlparser.NewIntVal([]byte(s))
+	}
+	tests := []struct {
+		start  *sqlparser.SQLVal
+		end    *sqlparser.SQLVal
+		tables []string
+	}{
+		{nil, nil, []string{"R0", "R1", "R2"}},
+		{val("150"), val("150"), []string{"R1"}},
+		{val("50"), val("150"), []string{"R0", "R1"}},
+		{val("150"), nil, []string{"R1", "R2"}},
+		{nil, val("99"), []string{"R0"}},
+		{val("250"), val("10"), []string{"R0"}},
+	}
+	for _, test := range tests {
+		segments, err := rng.Lookup(test.start, test.end)
+		assert.Nil(t, err)
+		var got []string
+		for _, segment := range segments {
+			got = append(got, segment.Table)
+		}
+		assert.Equal(t, test.tables, got)
+	}
+
+	// The non-numeric literal is cast to 0.
+	{
+		segments, err := rng.Lookup(sqlparser.NewStrVal([]byte("xx")), nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, len(segments))
+
+		segments, err = rng.Lookup(nil, sqlparser.NewStrVal([]byte("xx")))
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(segments))
+	}
+
+	// Errors.
+	{
+		_, err := rng.Lookup(sqlparser.NewHexVal([]byte("3f")), nil)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestRangeDate(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	rng := NewRange(log, MockTableRDateConfig())
+	err := rng.Build()
+	assert.Nil(t, err)
+	assert.Equal(t, rangeDate, rng.kind)
+
+	{
+		idx, err := rng.GetIndex(sqlparser.NewStrVal([]byte("2019-01-15")))
+		assert.Nil(t, err)
+		assert.Equal(t, 1, idx)
+	}
+
+	// The key beyond the last bound goes to the empty route.
+	{
+		key := sqlparser.NewStrVal([]byte("2019-02-01"))
+		idx, err := rng.GetIndex(key)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, idx)
+
+		segments, err := rng.Lookup(key, key)
+		assert.Nil(t, err)
+		assert.Equal(t, "RD1", segments[0].Table)
+
+		_, err = rng.locate(key)
+		want := "range.getindex.key[2019-02-01].has.no.partition"
+		assert.Equal(t, want, err.Error())
+	}
+
+	// The dates are compared as dates, not strings.
+	{
+		tests := []struct {
+			val *sqlparser.SQLVal
+			idx int
+		}{
+			{sqlparser.NewStrVal([]byte("2018-12-31 23:59:59")), 0},
+			{sqlparser.NewIntVal([]byte("20190115")), 1},
+			{sqlparser.NewStrVal([]byte("2019-01-01T00:00:00")), 1},
+			// The invalid date is cast to the zero date.
+			{sqlparser.NewStrVal([]byte("xx")), 0},
+		}
+		for _, test := range tests {
+			idx, err := rng.GetIndex(test.val)
+			assert.Nil(t, err)
+			assert.Equal(t, test.idx, idx, string(test.val.Val))
+		}
+
+		_, err := rng.locate(sqlparser.NewStrVal([]byte("xx")))
+		want := "range.getindex.key[xx].invalid"
+		assert.Equal(t, want, err.Error())
+	}
+
+	{
+		conf := &config.TableConfig{
+			Name:      "RD",
+			ShardType: "RANGE",
+			ShardKey:  "dt",
+			Partitions: []*config.PartitionConfig{
+				{Table: "RD0", Segment: "2019-02-01", Backend: "backend0"},
+				{Table: "RD1", Segment: "2019-01-01", Backend: "backend1"},
+			},
+		}
+		rng := NewRange(log, conf)
+		err := rng.Build()
+		assert.NotNil(t, err)
+	}
+}
+
+func TestRangeColumnType(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+
+	// The string column compares the bounds as strings, though they are numbers.
+	{
+		conf := MockTableRConfig()
+		conf.ShardKeyTypes = []string{"varchar"}
+		rng := NewRange(log, conf)
+		err := rng.Build()
+		assert.Nil(t, err)
+		assert.Equal(t, rangeString, rng.kind)
+
+		idx, err := rng.GetIndex(sqlparser.NewStrVal([]byte("1500")))
+		assert.Nil(t, err)
+		assert.Equal(t, 1, idx)
+	}
+
+	// The decimal column.
+	{
+		conf := MockTableRConfig()
+		conf.ShardKeyTypes = []string{"decimal"}
+		conf.Partitions[0].Segment = "99.5"
+		rng := NewRange(log, conf)
+		err := rng.Build()
+		assert.Nil(t, err)
+		assert.Equal(t, rangeNumeric, rng.kind)
+
+		idx, err := rng.GetIndex(sqlparser.NewFloatVal([]byte("99.49")))
+		assert.Nil(t, err)
+		assert.Equal(t, 0, idx)
+		idx, err = rng.GetIndex(sqlparser.NewIntVal([]byte("99")))
+		assert.Nil(t, err)
+		assert.Equal(t, 0, idx)
+		idx, err = rng.GetIndex(sqlparser.NewStrVal([]byte("99.5")))
+		assert.Nil(t, err)
+		assert.Equal(t, 1, idx)
+	}
+
+	// The datetime column.
+	{
+		conf := MockTableRDateConfig()
+		conf.ShardKeyTypes = []string{"datetime"}
+		conf.Partitions[1].Segment = "2019-01-15 12:00:00"
+		rng := NewRange(log, conf)
+		err := rng.Build()
+		assert.Nil(t, err)
+		assert.Equal(t, rangeDate, rng.kind)
+
+		idx, err := rng.locate(sqlparser.NewIntVal([]byte("20190115115959")))
+		assert.Nil(t, err)
+		assert.Equal(t, 1, idx)
+		_, err = rng.locate(sqlparser.NewStrVal([]byte("2019-01-15 12:00:00")))
+		want := "range.getindex.key[2019-01-15 12:00:00].has.no.partition"
+		assert.Equal(t, want, err.Error())
+	}
+
+	// The bound is invalid for the column.
+	{
+		conf := MockTableRConfig()
+		conf.ShardKeyTypes = []string{"int"}
+		conf.Partitions[1].Segment = "2019-01-01"
+		rng := NewRange(log, conf)
+		err := rng.Build()
+		want := "range.partition[R1].segment[2019-01-01].invalid"
+		assert.Equal(t, want, err.Error())
+	}
+}
@@ -11,6 +11,8 @@ package router
 import (
 	"encoding/json"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"config"
 
@@ -23,6 +25,22 @@ import (
 // Extra -- router extra params.
 type Extra struct {
 	AutoIncrement *config.AutoIncrement
+
+	// PartitionType is the method in 'PARTITION BY', default is HASH.
+	// MONTH and DAY are the intervals of the TIME partition.
+	PartitionType string
+	// PartitionOptions is the partition definitions, used by RANGE and LIST.
+	PartitionOptions sqlparser.PartitionDefinitions
+	// TableGroup is the group which the HASH table is co-located with.
+	TableGroup string
+	// Backend is the backend which the SINGLE table is placed on.
+	Backend string
+	// HashFunc is the hash function of the HASH table.
+	HashFunc string
+	// ShardKeyTypes is the column types of the shard key columns.
+	ShardKeyTypes []string
+	// Backfill is the count of the past periods created for the TIME table.
+	Backfill int
 }
 
 // Table tuple.
@@ -53,6 +71,14 @@ type Router struct {
 	dbACL   *DatabaseACL
 	conf    *config.RouterConfig
 
+	// gates are the freezes and the in-flight writes of the tables, key is 'db.table'.
+	// They are guarded by their own locks, so the writes don't contend on the mu.
+	gatesMu sync.RWMutex
+	gates   map[string]*writeGate
+
+	// version is changed when the routes are changed.
+	version uint64
+
 	// schemas map, key is database name
 	Schemas map[string]*Schema `json:",omitempty"`
 }
@@ -64,13 +90,46 @@ func NewRouter(log *xlog.Log, metadir string, conf *config.RouterConfig) *Router
 		metadir: metadir,
 		conf:    conf,
 		dbACL:   NewDatabaseACL(),
+		gates:   make(map[string]*writeGate),
 		Schemas: make(map[string]*Schema),
 	}
 	return route
 }
 
+// writeGate is the freeze and the in-flight writes of a table.
+type writeGate struct {
+	mu sync.Mutex
+	// frozen is true if the writes are refused during the resharding.
+	frozen bool
+	// writing is the number of the in-flight writes.
+	writing int
+	// written is signaled when the in-flight writes are done.
+	written *sync.Cond
+}
+
+// gate returns the write gate of the table, it's created at the first use.
+func (r *Router) gate(database string, tableName string) *writeGate {
+	key := database + "." + tableName
+	r.gatesMu.RLock()
+	g, ok := r.gates[key]
+	r.gatesMu.RUnlock()
+	if ok {
+		return g
+	}
+
+	r.gatesMu.Lock()
+	defer r.gatesMu.Unlock()
+	if g, ok = r.gates[key]; !ok {
+		g = &writeGate{}
+		g.written = sync.NewCond(&g.mu)
+		r.gates[key] = g
+	}
+	return g
+}
+
 // addTable -- used to add a table router to schema map.
 func (r *Router) addTable(db string, tbl *config.TableConfig) error {
+	defer r.changed()
 	var ok bool
 	var schema *Schema
 	var table *Table
@@ -118,6 +177,30 @@ func (r *Router) addTable(db string, tbl *config.TableConfig) error {
 			return err
 		}
 		table.Partition = global
+	case methodTypeRange:
+		rang := NewRange(r.log, tbl)
+		if err := rang.Build(); err != nil {
+			return err
+		}
+		table.Partition = rang
+	case methodTypeList:
+		list := NewList(r.log, tbl)
+		if err := list.Build(); err != nil {
+			return err
+		}
+		table.Partition = list
+	case methodTypeTime:
+		tim := NewTime(r.log, tbl)
+		if err := tim.Build(); err != nil {
+			return err
+		}
+		table.Partition = tim
+	case methodTypeSingle:
+		single := NewSingle(r.log, tbl)
+		if err := single.Build(); err != nil {
+			return err
+		}
+		table.Partition = single
 	default:
 		return errors.Errorf("router.unsupport.shardtype:[%v]", tbl.ShardType)
 	}
@@ -126,6 +209,7 @@ func (r *Router) addTable(db string, tbl *config.TableConfig) error {
 
 // removeTable -- used to remvoe a table router from schema map.
 func (r *Router) removeTable(db string, table string) error {
+	defer r.changed()
 	var ok bool
 	var schema *Schema
 
@@ -143,6 +227,7 @@ func (r *Router) removeTable(db string, table string) error {
 }
 
 func (r *Router) addDatabase(db string) error {
+	defer r.changed()
 	if _, ok := r.Schemas[db]; !ok {
 		schema := &Schema{DB: db, Tables: make(map[string]*Table)}
 		r.Schemas[db] = schema
@@ -152,6 +237,7 @@ func (r *Router) addDatabase(db string) error {
 }
 
 func (r *Router) dropDatabase(db string) error {
+	defer r.changed()
 	if _, ok := r.Schemas[db]; !ok {
 		return errors.Errorf("router.can.not.find.db[%v]", db)
 	}
@@ -161,9 +247,21 @@ func (r *Router) dropDatabase(db string) error {
 
 // clear used to reset Schemas to new.
 func (r *Router) clear() {
+	defer r.changed()
 	r.Schemas = make(map[string]*Schema)
 }
 
+// changed used to change the version after the routes are changed.
+func (r *Router) changed() {
+	atomic.AddUint64(&r.version, 1)
+}
+
+// Version returns the version of the routes, it's changed when the databases,
+// tables or partitions are changed, the plans built by the routes are stale.
+func (r *Router) Version() uint64 {
+	return atomic.LoadUint64(&r.version)
+}
+
 // DatabaseACL used to check wheather the database is a system database.
 func (r *Router) DatabaseACL(database string) error {
 	if ok := r.dbACL.Allow(database); !ok {
@@ -217,6 +315,101 @@ func (r *Router) ShardKey(database string, tableName string) (string, error) {
 	return table.ShardKey, nil
 }
 
+// ShardKeyTypes used to lookup the column types of the shardkey, nil if the table has no types.
+func (r *Router) ShardKeyTypes(database string, tableName string) ([]string, error) {
+	table, err := r.getTable(database, tableName)
+	if err != nil {
+		return nil, err
+	}
+	return table.TableConfig.ShardKeyTypes, nil
+}
+
+// FreezeTable used to refuse the writes to the table, such as the partitions are resharding.
+func (r *Router) FreezeTable(database string, tableName string) error {
+	g := r.gate(database, tableName)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.frozen {
+		return errors.Errorf("router.table[%s.%s].is.already.frozen", database, tableName)
+	}
+	g.frozen = true
+	return nil
+}
+
+// UnfreezeTable used to accept the writes to the table again.
+func (r *Router) UnfreezeTable(database string, tableName string) {
+	g := r.gate(database, tableName)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.frozen = false
+}
+
+// CheckWritable returns error if the table is frozen.
+func (r *Router) CheckWritable(database string, tableName string) error {
+	g := r.gate(database, tableName)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.frozen {
+		return errors.Errorf("router.table[%s.%s].is.frozen.for.resharding,please.retry.later", database, tableName)
+	}
+	return nil
+}
+
+// BeginWrite used to re-check the freeze of the table before the write is executed,
+// the write is in-flight until EndWrite is called.
+func (r *Router) BeginWrite(database string, tableName string) error {
+	g := r.gate(database, tableName)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.frozen {
+		return errors.Errorf("router.table[%s.%s].is.frozen.for.resharding,please.retry.later", database, tableName)
+	}
+	g.writing++
+	return nil
+}
+
+// EndWrite used to finish the in-flight write started by BeginWrite.
+func (r *Router) EndWrite(database string, tableName string) {
+	g := r.gate(database, tableName)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.writing--; g.writing <= 0 {
+		g.writing = 0
+		g.written.Broadcast()
+	}
+}
+
+// WaitWrites used to wait for the in-flight writes of the table to be done,
+// it's called after the table is frozen so no new writes can start.
+// The write is done when its txn is committed or rolled back, returns error
+// if the txns are still open after the timeout.
+func (r *Router) WaitWrites(database string, tableName string, timeout time.Duration) error {
+	g := r.gate(database, tableName)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	var expired bool
+	timer := time.AfterFunc(timeout, func() {
+		g.mu.Lock()
+		expired = true
+		g.mu.Unlock()
+		g.written.Broadcast()
+	})
+	defer timer.Stop()
+
+	for g.writing > 0 {
+		if expired {
+			return errors.Errorf("router.table[%s.%s].has.%d.open.write.txns.after[%v]", database, tableName, g.writing, timeout)
+		}
+		g.written.Wait()
+	}
+	return nil
+}
+
 // TableConfig returns the config by database and tableName.
 func (r *Router) TableConfig(database string, tableName string) (*config.TableConfig, error) {
 	table, err := r.getTable(database, tableName)
@@ -265,6 +458,59 @@ func (r *Router) Lookup(database string, tableName string, startKey *sqlparser.S
 	return partInfos, nil
 }
 
+// locator is the partition which can't store the rows of all the keys, the rows
+// are located by it before the Lookup.
+type locator interface {
+	locate(sqlval *sqlparser.SQLVal) (int, error)
+}
+
+// LocateRow returns the partition to store the row with the sharding key.
+// Unlike the Lookup, it errors if none of the partitions can store the row.
+func (r *Router) LocateRow(database string, tableName string, key *sqlparser.SQLVal) (Segment, error) {
+	table, err := r.getTable(database, tableName)
+	if err != nil {
+		return Segment{}, err
+	}
+
+	if loc, ok := table.Partition.(locator); ok {
+		if _, err := loc.locate(key); err != nil {
+			r.log.Error("router.partition.locate.error:%+v", err)
+			return Segment{}, err
+		}
+	}
+	segments, err := table.Partition.Lookup(key, key)
+	if err != nil {
+		r.log.Error("router.partition.lookup.error:%+v", err)
+		return Segment{}, err
+	}
+	return segments[0], nil
+}
+
+// comparer is the partition whose sharding keys are ordered, the bounds of the
+// range filters are compared by it.
+type comparer interface {
+	compareValue(x, y *sqlparser.SQLVal) (int, error)
+}
+
+// CompareKey compares the sharding keys x and y by the partition of the table,
+// ok is false if the keys of the partition aren't ordered, such as the HASH.
+func (r *Router) CompareKey(database string, tableName string, x, y *sqlparser.SQLVal) (int, bool, error) {
+	table, err := r.getTable(database, tableName)
+	if err != nil {
+		return 0, false, err
+	}
+
+	cmp, ok := table.Partition.(comparer)
+	if !ok {
+		return 0, false, nil
+	}
+	res, err := cmp.compareValue(x, y)
+	if err != nil {
+		return 0, false, err
+	}
+	return res, true, nil
+}
+
 // Tables returns all the tables.
 func (r *Router) Tables() map[string][]string {
 	r.mu.RLock()
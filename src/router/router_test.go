@@ -23,6 +23,27 @@ func TestRouter(t *testing.T) {
 	assert.NotNil(t, router)
 }
 
+func TestRouterVersion(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	router, cleanup := MockNewRouter(log)
+	defer cleanup()
+
+	version := router.Version()
+	err := router.AddForTest("sbtest", MockTableMConfig())
+	assert.Nil(t, err)
+	assert.True(t, router.Version() > version)
+
+	version = router.Version()
+	strVal := sqlparser.NewStrVal([]byte("shardkey"))
+	_, err = router.Lookup("sbtest", "A", strVal, strVal)
+	assert.Nil(t, err)
+	assert.Equal(t, version, router.Version())
+
+	err = router.removeTable("sbtest", "A")
+	assert.Nil(t, err)
+	assert.True(t, router.Version() > version)
+}
+
 func TestRouterAdd(t *testing.T) {
 	results := []string{`{
 	"Schemas": {
@@ -218,6 +239,122 @@ func TestRouterLookup(t *testing.T) {
 	}
 }
 
+func TestRouterLocateRow(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	router, cleanup := MockNewRouter(log)
+	defer cleanup()
+	assert.NotNil(t, router)
+
+	err := router.addTable("sbtest", MockTableRDateConfig())
+	assert.Nil(t, err)
+
+	{
+		segment, err := router.LocateRow("sbtest", "RD", sqlparser.NewStrVal([]byte("2019-01-15")))
+		assert.Nil(t, err)
+		assert.Equal(t, "RD1", segment.Table)
+	}
+
+	// The row beyond the last bound can't be stored, but the reads go to the empty route.
+	{
+		key := sqlparser.NewStrVal([]byte("2019-02-01"))
+		_, err := router.LocateRow("sbtest", "RD", key)
+		want := "range.getindex.key[2019-02-01].has.no.partition"
+		assert.Equal(t, want, err.Error())
+
+		segments, err := router.Lookup("sbtest", "RD", key, key)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(segments))
+	}
+
+	// The unlisted key without the default partition.
+	{
+		conf := MockTableLConfig()
+		conf.Partitions = conf.Partitions[:2]
+		err := router.addTable("sbtest", conf)
+		assert.Nil(t, err)
+
+		key := sqlparser.NewStrVal([]byte("uk"))
+		_, err = router.LocateRow("sbtest", "L", key)
+		want := "list.getindex.key[uk].has.no.partition"
+		assert.Equal(t, want, err.Error())
+
+		segments, err := router.Lookup("sbtest", "L", key, key)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(segments))
+
+		segment, err := router.LocateRow("sbtest", "L", sqlparser.NewStrVal([]byte("us")))
+		assert.Nil(t, err)
+		assert.Equal(t, "L1", segment.Table)
+	}
+
+	// The key in the gap of the periods.
+	{
+		err := router.addTable("sbtest", MockTableTConfig())
+		assert.Nil(t, err)
+
+		key := sqlparser.NewStrVal([]byte("2019-03-01"))
+		_, err = router.LocateRow("sbtest", "T", key)
+		want := "time.getindex.key[2019-03-01].has.no.partition"
+		assert.Equal(t, want, err.Error())
+
+		segments, err := router.Lookup("sbtest", "T", key, key)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(segments))
+	}
+
+	{
+		_, err := router.LocateRow("sbtest", "xx", sqlparser.NewIntVal([]byte("1")))
+		assert.NotNil(t, err)
+	}
+}
+
+func TestRouterCompareKey(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	router, cleanup := MockNewRouter(log)
+	defer cleanup()
+	assert.NotNil(t, router)
+
+	err := router.addTable("sbtest", MockTableRConfig())
+	assert.Nil(t, err)
+	err = router.addTable("sbtest", MockTableTConfig())
+	assert.Nil(t, err)
+	err = router.addTable("sbtest", MockTableAConfig())
+	assert.Nil(t, err)
+
+	// The numeric keys are compared by the values.
+	{
+		cmp, ok, err := router.CompareKey("sbtest", "R", sqlparser.NewIntVal([]byte("9")), sqlparser.NewStrVal([]byte("10")))
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, -1, cmp)
+	}
+
+	{
+		cmp, ok, err := router.CompareKey("sbtest", "T", sqlparser.NewStrVal([]byte("2019-02-10")), sqlparser.NewStrVal([]byte("2019-01-10")))
+		assert.Nil(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, 1, cmp)
+	}
+
+	// The keys of the HASH aren't ordered.
+	{
+		_, ok, err := router.CompareKey("sbtest", "A", sqlparser.NewIntVal([]byte("1")), sqlparser.NewIntVal([]byte("2")))
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	}
+
+	{
+		_, _, err := router.CompareKey("sbtest", "T", sqlparser.NewStrVal([]byte("x")), sqlparser.NewStrVal([]byte("2019-01-10")))
+		want := "time.getindex.key[x].parser.time.error"
+		assert.Equal(t, want, err.Error())
+	}
+
+	{
+		_, _, err := router.CompareKey("sbtest", "xx", sqlparser.NewIntVal([]byte("1")), sqlparser.NewIntVal([]byte("2")))
+		assert.NotNil(t, err)
+	}
+}
+
 func TestRouterLookupError(t *testing.T) {
 	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
 	router, cleanup := MockNewRouter(log)
@@ -0,0 +1,133 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package router
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"config"
+
+	"github.com/pkg/errors"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/common"
+)
+
+const (
+	// shardKeySeparator separates the columns of the composite shard key, such as 'tenant_id,user_id'.
+	shardKeySeparator = ","
+)
+
+// ShardKeys splits the shard key into columns, returns nil if the shard key is null.
+func ShardKeys(shardkey string) []string {
+	if shardkey == "" {
+		return nil
+	}
+	keys := strings.Split(shardkey, shardKeySeparator)
+	for i, key := range keys {
+		keys[i] = strings.TrimSpace(key)
+	}
+	return keys
+}
+
+// CompositeKey combines the values of the shard key columns into one value to hash.
+// The single column key returns the value itself to keep compatible.
+// The types are the column types of the shard key, the value of the numeric column is
+// coerced to the canonical number, so 1, 1.0 and '1' are the same key. The tables created
+// before have no types, the values are combined as they are to keep the rows where they are.
+func CompositeKey(vals []*sqlparser.SQLVal, types []string) (*sqlparser.SQLVal, error) {
+	if len(vals) == 0 {
+		return nil, errors.New("router.composite.key.values.is.null")
+	}
+	if len(vals) == 1 {
+		return vals[0], nil
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, 64))
+	for i, val := range vals {
+		if val == nil {
+			return nil, errors.Errorf("router.composite.key.value[%d].is.null", i)
+		}
+		if i > 0 {
+			buf.WriteString(shardKeySeparator)
+		}
+		numeric := i < len(types) && isNumericType(types[i])
+		valStr := common.BytesToString(val.Val)
+		switch val.Type {
+		case sqlparser.IntVal:
+			v, err := strconv.ParseInt(valStr, 0, 64)
+			if err != nil {
+				return nil, errors.Errorf("router.composite.key.parser.int64.error:[%v]", err)
+			}
+			buf.WriteString(strconv.FormatInt(v, 10))
+		case sqlparser.FloatVal, sqlparser.StrVal:
+			if numeric {
+				text := strings.TrimSpace(valStr)
+				if v, err := strconv.ParseFloat(text, 64); err == nil {
+					valStr = canonicalDecimal(text, v)
+				}
+			}
+			buf.WriteString(valStr)
+		default:
+			return nil, errors.Errorf("router.composite.key.unsupported.type:[%v]", val.Type)
+		}
+	}
+	return sqlparser.NewStrVal(buf.Bytes()), nil
+}
+
+// keyClass returns the class of the column type which the keys are compared and normalized by,
+// the numeric, date or string.
+func keyClass(typ string) string {
+	switch {
+	case isNumericType(typ):
+		return "numeric"
+	case isDateType(typ):
+		return "date"
+	}
+	return "string"
+}
+
+// SameRouting returns true if the two tables route the same shard key values to the same
+// index of their partitions. Only the attributes which change the routing are compared:
+// the classes of the shard key types rather than the type names, the types of the single
+// column HASH key are not used, and the CanonicalDate only matters if the keys may be dates.
+func SameRouting(x, y *config.TableConfig) bool {
+	if x.ShardType != y.ShardType || hashFuncName(x.HashFunc) != hashFuncName(y.HashFunc) {
+		return false
+	}
+	if x.ShardType != methodTypeHash || len(ShardKeys(x.ShardKey)) > 1 {
+		if len(x.ShardKeyTypes) != len(y.ShardKeyTypes) {
+			return false
+		}
+		for i, typ := range x.ShardKeyTypes {
+			if keyClass(typ) != keyClass(y.ShardKeyTypes[i]) {
+				return false
+			}
+		}
+	}
+	if x.ShardType == methodTypeHash && x.CanonicalDate != y.CanonicalDate {
+		return !mayBeDate(x.ShardKeyTypes) && !mayBeDate(y.ShardKeyTypes)
+	}
+	return true
+}
+
+// mayBeDate returns true if any column of the types may hold the date strings,
+// the unknown types may.
+func mayBeDate(types []string) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, typ := range types {
+		if keyClass(typ) != "numeric" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package router
+
+import (
+	"testing"
+
+	"config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+func TestShardKeys(t *testing.T) {
+	assert.Nil(t, ShardKeys(""))
+	assert.Equal(t, []string{"id"}, ShardKeys("id"))
+	assert.Equal(t, []string{"tenant_id", "user_id"}, ShardKeys("tenant_id, user_id"))
+}
+
+func TestCompositeKey(t *testing.T) {
+	intVal := sqlparser.NewIntVal([]byte("0x10"))
+	strVal := sqlparser.NewStrVal([]byte("cn"))
+	floatVal := sqlparser.NewFloatVal([]byte("1.5"))
+
+	// Single column.
+	{
+		got, err := CompositeKey([]*sqlparser.SQLVal{intVal}, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, intVal, got)
+	}
+
+	// Composite columns.
+	{
+		got, err := CompositeKey([]*sqlparser.SQLVal{intVal, strVal, floatVal}, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, sqlparser.StrVal, got.Type)
+		assert.Equal(t, "16,cn,1.5", string(got.Val))
+	}
+
+	// The numeric columns are coerced to the canonical number.
+	{
+		types := []string{"int", "decimal", "varchar"}
+		want, err := CompositeKey([]*sqlparser.SQLVal{sqlparser.NewIntVal([]byte("1")), sqlparser.NewFloatVal([]byte("2.5")), strVal}, types)
+		assert.Nil(t, err)
+		assert.Equal(t, "1,2.5,cn", string(want.Val))
+
+		got, err := CompositeKey([]*sqlparser.SQLVal{sqlparser.NewFloatVal([]byte("1.0")), sqlparser.NewStrVal([]byte("02.50")), strVal}, types)
+		assert.Nil(t, err)
+		assert.Equal(t, want, got)
+
+		// The string column keeps the value.
+		got, err = CompositeKey([]*sqlparser.SQLVal{intVal, sqlparser.NewStrVal([]byte("1.0")), sqlparser.NewFloatVal([]byte("1.0"))}, types)
+		assert.Nil(t, err)
+		assert.Equal(t, "16,1,1.0", string(got.Val))
+	}
+
+	// Errors.
+	{
+		_, err := CompositeKey(nil, nil)
+		assert.Equal(t, "router.composite.key.values.is.null", err.Error())
+
+		_, err = CompositeKey([]*sqlparser.SQLVal{intVal, nil}, nil)
+		assert.Equal(t, "router.composite.key.value[1].is.null", err.Error())
+
+		_, err = CompositeKey([]*sqlparser.SQLVal{intVal, sqlparser.NewHexVal([]byte("3f"))}, nil)
+		assert.Equal(t, "router.composite.key.unsupported.type:[4]", err.Error())
+
+		_, err = CompositeKey([]*sqlparser.SQLVal{sqlparser.NewIntVal([]byte("x")), intVal}, nil)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestCompositeKeyLookup(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	router, cleanup := MockNewRouter(log)
+	defer cleanup()
+
+	err := router.AddForTest("sbtest", MockTableCConfig())
+	assert.Nil(t, err)
+
+	shardkey, err := router.ShardKey("sbtest", "C")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b"}, ShardKeys(shardkey))
+
+	// The same values always route to the same partition.
+	key1, err := CompositeKey([]*sqlparser.SQLVal{sqlparser.NewIntVal([]byte("1")), sqlparser.NewStrVal([]byte("cn"))}, nil)
+	assert.Nil(t, err)
+	key2, err := CompositeKey([]*sqlparser.SQLVal{sqlparser.NewStrVal([]byte("1")), sqlparser.NewStrVal([]byte("cn"))}, nil)
+	assert.Nil(t, err)
+	idx1, err := router.GetIndex("sbtest", "C", key1)
+	assert.Nil(t, err)
+	idx2, err := router.GetIndex("sbtest", "C", key2)
+	assert.Nil(t, err)
+	assert.Equal(t, idx1, idx2)
+}
+
+func TestSameRouting(t *testing.T) {
+	hash := func(key string, types []string, canonical bool) *config.TableConfig {
+		return &config.TableConfig{ShardType: "HASH", ShardKey: key, ShardKeyTypes: types, CanonicalDate: canonical}
+	}
+	rng := func(types []string) *config.TableConfig {
+		return &config.TableConfig{ShardType: "RANGE", ShardKey: "id", ShardKeyTypes: types}
+	}
+	tcases := []struct {
+		x, y *config.TableConfig
+		same bool
+	}{
+		{hash("id", []string{"int"}, false), hash("id", []string{"bigint"}, false), true},
+		// The types of the single column HASH key don't change the routing.
+		{hash("id", []string{"int"}, false), hash("id", []string{"varchar"}, false), true},
+		{hash("id", nil, false), hash("id", []string{"varchar"}, false), true},
+		{hash("a,b", []string{"int", "varchar"}, false), hash("a,b", []string{"bigint", "char"}, false), true},
+		{hash("a,b", []string{"int", "varchar"}, false), hash("a,b", []string{"varchar", "varchar"}, false), false},
+		// The CanonicalDate only matters if the keys may be dates.
+		{hash("id", []string{"int"}, true), hash("id", []string{"bigint"}, false), true},
+		{hash("id", []string{"date"}, true), hash("id", []string{"date"}, false), false},
+		{hash("id", nil, true), hash("id", []string{"int"}, false), false},
+		{&config.TableConfig{ShardType: "HASH", HashFunc: "JUMP"}, &config.TableConfig{ShardType: "HASH"}, true},
+		{&config.TableConfig{ShardType: "HASH", HashFunc: "crc32"}, &config.TableConfig{ShardType: "HASH"}, false},
+		{rng([]string{"int"}), rng([]string{"decimal"}), true},
+		{rng([]string{"date"}), rng([]string{"datetime"}), true},
+		{rng([]string{"date"}), rng([]string{"varchar"}), false},
+		{rng([]string{"int"}), rng(nil), false},
+		{rng(nil), hash("id", nil, false), false},
+	}
+	for i, tcase := range tcases {
+		assert.Equal(t, tcase.same, SameRouting(tcase.x, tcase.y), "%d", i)
+		assert.Equal(t, tcase.same, SameRouting(tcase.y, tcase.x), "%d", i)
+	}
+}
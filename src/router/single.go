@@ -0,0 +1,81 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package router
+
+import (
+	"config"
+
+	"github.com/pkg/errors"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+// Single for the unsharded table router, the table is placed on one backend.
+type Single struct {
+	log *xlog.Log
+
+	// single method.
+	typ MethodType
+
+	// table config.
+	conf *config.TableConfig
+
+	// Segments slice.
+	Segments []Segment `json:",omitempty"`
+}
+
+// NewSingle creates new single.
+func NewSingle(log *xlog.Log, conf *config.TableConfig) *Single {
+	return &Single{
+		log:      log,
+		conf:     conf,
+		typ:      methodTypeSingle,
+		Segments: make([]Segment, 0, 1),
+	}
+}
+
+// Build used to build Segments from schema config.
+func (s *Single) Build() error {
+	if s.conf == nil {
+		return errors.New("table.config..can't.be.nil")
+	}
+	if len(s.conf.Partitions) != 1 {
+		return errors.Errorf("single.partitions.must.be.one.but.got[%d]", len(s.conf.Partitions))
+	}
+	part := s.conf.Partitions[0]
+	s.Segments = append(s.Segments, Segment{
+		Table:   part.Table,
+		Backend: part.Backend,
+		Range: &GlobalRange{
+			str: "",
+		},
+	})
+	return nil
+}
+
+// Lookup used to lookup partition(s).
+// Single table always returns the only partition.
+func (s *Single) Lookup(start *sqlparser.SQLVal, end *sqlparser.SQLVal) ([]Segment, error) {
+	return s.Segments, nil
+}
+
+// Type returns the single type.
+func (s *Single) Type() MethodType {
+	return s.typ
+}
+
+// GetIndex returns index based on sqlval.
+func (s *Single) GetIndex(sqlval *sqlparser.SQLVal) (int, error) {
+	return 0, nil
+}
+
+// GetSegments returns Segments based on index.
+func (s *Single) GetSegments(index int) []Segment {
+	return s.Segments
+}
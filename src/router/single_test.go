@@ -0,0 +1,124 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package router
+
+import (
+	"testing"
+
+	"config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+func TestSingle(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	single := NewSingle(log, MockTableSConfig())
+	{
+		err := single.Build()
+		assert.Nil(t, err)
+		assert.Equal(t, string(single.Type()), methodTypeSingle)
+	}
+
+	{
+		parts, err := single.Lookup(nil, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(parts))
+		assert.Equal(t, "backend1", parts[0].Backend)
+
+		parts, err = single.Lookup(sqlparser.NewIntVal([]byte("1")), sqlparser.NewIntVal([]byte("1")))
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(parts))
+	}
+
+	{
+		idx, err := single.GetIndex(sqlparser.NewIntVal([]byte("1")))
+		assert.Nil(t, err)
+		assert.Equal(t, 0, idx)
+		assert.Equal(t, 1, len(single.GetSegments(-1)))
+		assert.Equal(t, 1, len(single.GetSegments(idx)))
+	}
+}
+
+func TestSingleError(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	{
+		single := NewSingle(log, nil)
+		err := single.Build()
+		assert.NotNil(t, err)
+	}
+
+	{
+		conf := MockTableSConfig()
+		conf.Partitions = append(conf.Partitions, &config.PartitionConfig{Table: "S", Backend: "backend2"})
+		single := NewSingle(log, conf)
+		err := single.Build()
+		assert.Equal(t, "single.partitions.must.be.one.but.got[2]", err.Error())
+	}
+}
+
+func TestRouterComputeSingle(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	router, cleanup := MockNewRouter(log)
+	defer cleanup()
+
+	backends := []string{"backend1", "backend2"}
+	got, err := router.SingleUniform("t1", "backend2", backends)
+	assert.Nil(t, err)
+	want := &config.TableConfig{
+		Name:       "t1",
+		ShardType:  "SINGLE",
+		Partitions: []*config.PartitionConfig{{Table: "t1", Backend: "backend2"}},
+	}
+	assert.Equal(t, want, got)
+
+	tests := []struct {
+		table   string
+		backend string
+		err     string
+	}{
+		{"", "backend1", "table.cant.be.null"},
+		{"t1", "", "router.compute.single.backend.cant.be.null"},
+		{"t1", "backend3", "router.compute.single.backend[backend3].cant.found"},
+	}
+	for _, test := range tests {
+		_, err := router.SingleUniform(test.table, test.backend, backends)
+		assert.Equal(t, test.err, err.Error())
+	}
+}
+
+func TestFrmTableSingle(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	router, cleanup := MockNewRouter(log)
+	defer cleanup()
+
+	router.CreateDatabase("test")
+	extra := &Extra{PartitionType: "single", Backend: "backend2"}
+	err := router.CreateTable("test", "t1", "", []string{"backend1", "backend2"}, extra)
+	assert.Nil(t, err)
+	assert.True(t, checkFileExistsForTest(router, "test", "t1"))
+
+	segments, err := router.Lookup("test", "t1", nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(segments))
+	assert.Equal(t, "t1", segments[0].Table)
+	assert.Equal(t, "backend2", segments[0].Backend)
+
+	shardKey, err := router.ShardKey("test", "t1")
+	assert.Nil(t, err)
+	assert.Equal(t, "", shardKey)
+
+	// Reload from the frm.
+	err = router.RefreshTable("test", "t1")
+	assert.Nil(t, err)
+	tconf, err := router.TableConfig("test", "t1")
+	assert.Nil(t, err)
+	assert.Equal(t, "SINGLE", tconf.ShardType)
+}
@@ -0,0 +1,147 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package router
+
+import (
+	"fmt"
+	"sort"
+
+	"config"
+
+	"github.com/pkg/errors"
+)
+
+// The tables in the same table group share the slots, the segments and the backends,
+// so the joins on the shard key between them can be pushed down to the partitions.
+// The first table of the group decides the placement, the later ones follow it,
+// and the shift, split and merge of the partition move the whole group together.
+
+// groupTables returns the table and the other tables in the same table group sorted by name,
+// the table not in a group returns only itself.
+// Need RLock.
+func (r *Router) groupTables(database string, table *Table) []*Table {
+	tables := []*Table{table}
+	group := table.TableConfig.TableGroup
+	if group == "" {
+		return tables
+	}
+	schema, ok := r.Schemas[database]
+	if !ok {
+		return tables
+	}
+
+	var others []*Table
+	for _, t := range schema.Tables {
+		if t != table && t.TableConfig.TableGroup == group {
+			others = append(others, t)
+		}
+	}
+	sort.Slice(others, func(i, j int) bool { return others[i].Name < others[j].Name })
+	return append(tables, others...)
+}
+
+// groupLeader returns the table config which the new table in the group follows,
+// all the tables in the group share the same placement, returns nil if the group has no table.
+// Need RLock.
+func (r *Router) groupLeader(database string, group string) *config.TableConfig {
+	schema, ok := r.Schemas[database]
+	if !ok {
+		return nil
+	}
+	var leader *config.TableConfig
+	for _, t := range schema.Tables {
+		if t.TableConfig.TableGroup == group && (leader == nil || t.Name < leader.Name) {
+			leader = t.TableConfig
+		}
+	}
+	return leader
+}
+
+// GroupUniform used to build the partitions which are co-located with the leader of the table group.
+// The partition tables are named by the order of the leader's partitions.
+func (r *Router) GroupUniform(table, shardkey, group string, leader *config.TableConfig) (*config.TableConfig, error) {
+	if table == "" {
+		return nil, errors.New("table.cant.be.null")
+	}
+	if shardkey == "" {
+		return nil, errors.New("shard.key.cant.be.null")
+	}
+	if leader.ShardType != methodTypeHash {
+		return nil, errors.Errorf("router.compute.tablegroup[%s].shardtype[%s].must.be.HASH", group, leader.ShardType)
+	}
+	if len(ShardKeys(shardkey)) != len(ShardKeys(leader.ShardKey)) {
+		return nil, errors.Errorf("router.compute.tablegroup[%s].shardkey[%s].columns.must.be.same.as[%s]", group, shardkey, leader.ShardKey)
+	}
+
+	tableConf := &config.TableConfig{
+		Name:          table,
+		Slots:         leader.Slots,
+		Blocks:        leader.Blocks,
+		ShardKey:      shardkey,
+		ShardType:     methodTypeHash,
+		TableGroup:    group,
+		HashFunc:      leader.HashFunc,
+		CanonicalDate: leader.CanonicalDate,
+		Partitions:    make([]*config.PartitionConfig, 0, len(leader.Partitions)),
+	}
+	for i, partition := range leader.Partitions {
+		tableConf.Partitions = append(tableConf.Partitions, &config.PartitionConfig{
+			Table:   fmt.Sprintf("%s_%04d", table, i),
+			Segment: partition.Segment,
+			Backend: partition.Backend,
+		})
+	}
+	return tableConf, nil
+}
+
+// GroupPartition tuple.
+type GroupPartition struct {
+	// Table is the logic table name.
+	Table string
+	// Partition is the partition config.
+	Partition *config.PartitionConfig
+	// ShardType is the shard type of the table.
+	ShardType string
+	// ShardKey is the shard key of the table.
+	ShardKey string
+}
+
+// PartitionRuleGroup returns the partition table on the backend and the co-located partitions
+// of the other tables in the same table group, the first is the partition itself.
+func (r *Router) PartitionRuleGroup(backend string, database string, partitionTable string) ([]*GroupPartition, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	schema, ok := r.Schemas[database]
+	if !ok {
+		return nil, errors.Errorf("router.rule.cant.found.database:%s", database)
+	}
+	for _, table := range schema.Tables {
+		for _, partition := range table.TableConfig.Partitions {
+			if partition.Backend != backend || partition.Table != partitionTable {
+				continue
+			}
+			var parts []*GroupPartition
+			for _, member := range r.groupTables(database, table) {
+				part, err := groupPartition(member, partition)
+				if err != nil {
+					return nil, err
+				}
+				parts = append(parts, &GroupPartition{
+					Table:     member.Name,
+					Partition: part,
+					ShardType: member.TableConfig.ShardType,
+					ShardKey:  member.TableConfig.ShardKey,
+				})
+			}
+			return parts, nil
+		}
+	}
+	return nil, errors.Errorf("router.rule.cant.found.backend[%s]+table:[%s]", backend, partitionTable)
+}
@@ -0,0 +1,165 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package router
+
+import (
+	"path"
+	"testing"
+
+	"config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+func checkGroupPlacementForTest(t *testing.T, lconf, rconf *config.TableConfig) {
+	assert.Equal(t, lconf.TableGroup, rconf.TableGroup)
+	assert.Equal(t, len(lconf.Partitions), len(rconf.Partitions))
+	for i, lpart := range lconf.Partitions {
+		assert.Equal(t, lpart.Segment, rconf.Partitions[i].Segment)
+		assert.Equal(t, lpart.Backend, rconf.Partitions[i].Backend)
+	}
+}
+
+func TestTableGroupCreate(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	router, cleanup := MockNewRouter(log)
+	defer cleanup()
+
+	router.CreateDatabase("test")
+	extra := &Extra{TableGroup: "g1"}
+	err := router.CreateTable("test", "t1", "id", []string{"backend1", "backend2"}, extra)
+	assert.Nil(t, err)
+	// The backends are ignored, the table follows the group.
+	err = router.CreateTable("test", "t2", "uid", []string{"backend3"}, extra)
+	assert.Nil(t, err)
+	err = router.CreateTable("test", "t3", "id", []string{"backend3"}, nil)
+	assert.Nil(t, err)
+
+	t1, err := router.TableConfig("test", "t1")
+	assert.Nil(t, err)
+	t2, err := router.TableConfig("test", "t2")
+	assert.Nil(t, err)
+	assert.Equal(t, "g1", t1.TableGroup)
+	assert.Equal(t, "uid", t2.ShardKey)
+	assert.Equal(t, "t2_0000", t2.Partitions[0].Table)
+	checkGroupPlacementForTest(t, t1, t2)
+
+	// The group is flushed.
+	tconf, err := router.readTableFrmData(path.Join(router.metadir, "test", "t2.json"))
+	assert.Nil(t, err)
+	assert.Equal(t, "g1", tconf.TableGroup)
+
+	// Group.
+	{
+		router.mu.RLock()
+		schema := router.Schemas["test"]
+		tables := router.groupTables("test", schema.Tables["t2"])
+		router.mu.RUnlock()
+		assert.Equal(t, 2, len(tables))
+		assert.Equal(t, "t2", tables[0].Name)
+		assert.Equal(t, "t1", tables[1].Name)
+
+		parts, err := router.PartitionRuleGroup(t1.Partitions[1].Backend, "test", t1.Partitions[1].Table)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(parts))
+		assert.Equal(t, "t1", parts[0].Table)
+		assert.Equal(t, t1.Partitions[1], parts[0].Partition)
+		assert.Equal(t, "t2", parts[1].Table)
+		assert.Equal(t, "uid", parts[1].ShardKey)
+		assert.Equal(t, t2.Partitions[1], parts[1].Partition)
+	}
+}
+
+func TestTableGroupErrors(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	router, cleanup := MockNewRouter(log)
+	defer cleanup()
+
+	router.CreateDatabase("test")
+	extra := &Extra{TableGroup: "g1"}
+	err := router.CreateTable("test", "t1", "id", []string{"backend1", "backend2"}, extra)
+	assert.Nil(t, err)
+
+	err = router.CreateTable("test", "t2", "id,name", []string{"backend1"}, extra)
+	assert.Equal(t, "router.compute.tablegroup[g1].shardkey[id,name].columns.must.be.same.as[id]", err.Error())
+
+	leader, err := router.TableConfig("test", "t1")
+	assert.Nil(t, err)
+	_, err = router.GroupUniform("t2", "id", "g1", &config.TableConfig{ShardType: "LIST"})
+	assert.Equal(t, "router.compute.tablegroup[g1].shardtype[LIST].must.be.HASH", err.Error())
+	_, err = router.GroupUniform("", "id", "g1", leader)
+	assert.Equal(t, "table.cant.be.null", err.Error())
+	_, err = router.GroupUniform("t2", "", "g1", leader)
+	assert.Equal(t, "shard.key.cant.be.null", err.Error())
+
+	_, err = router.PartitionRuleGroup("backend9", "test", "t1_0000")
+	assert.Equal(t, "router.rule.cant.found.backend[backend9]+table:[t1_0000]", err.Error())
+	_, err = router.PartitionRuleGroup("backend1", "xx", "t1_0000")
+	assert.Equal(t, "router.rule.cant.found.database:xx", err.Error())
+}
+
+func TestTableGroupReshard(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	router, cleanup := MockNewRouter(log)
+	defer cleanup()
+
+	router.CreateDatabase("test")
+	extra := &Extra{TableGroup: "g1"}
+	err := router.CreateTable("test", "t1", "id", []string{"backend1", "backend2"}, extra)
+	assert.Nil(t, err)
+	err = router.CreateTable("test", "t2", "id", nil, extra)
+	assert.Nil(t, err)
+
+	// Split.
+	{
+		rules, err := router.PartitionRuleSplit("test", "t2_0000")
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(rules))
+		assert.Equal(t, "t2", rules[0].Table)
+		assert.Equal(t, []string{"t2_0000"}, rules[0].Olds)
+		assert.Equal(t, "t1", rules[1].Table)
+		assert.Equal(t, []string{"t1_0000"}, rules[1].Olds)
+		err = router.PartitionRuleReplace("test", rules)
+		assert.Nil(t, err)
+	}
+
+	// Merge.
+	{
+		t1, err := router.TableConfig("test", "t1")
+		assert.Nil(t, err)
+		n := len(t1.Partitions)
+		rules, err := router.PartitionRuleMerge("test", t1.Partitions[n-2].Table, t1.Partitions[n-1].Table)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(rules))
+		err = router.PartitionRuleReplace("test", rules)
+		assert.Nil(t, err)
+	}
+
+	// Shift.
+	{
+		t1, err := router.TableConfig("test", "t1")
+		assert.Nil(t, err)
+		part := t1.Partitions[0]
+		err = router.PartitionRuleShift(part.Backend, "backend3", "test", part.Table)
+		assert.Nil(t, err)
+	}
+
+	t1, err := router.TableConfig("test", "t1")
+	assert.Nil(t, err)
+	t2, err := router.TableConfig("test", "t2")
+	assert.Nil(t, err)
+	assert.Equal(t, "backend3", t2.Partitions[0].Backend)
+	checkGroupPlacementForTest(t, t1, t2)
+
+	// The frm files are flushed.
+	t2, err = router.readTableFrmData(path.Join(router.metadir, "test", "t2.json"))
+	assert.Nil(t, err)
+	checkGroupPlacementForTest(t, t1, t2)
+}
@@ -0,0 +1,342 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package router
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"config"
+
+	"github.com/pkg/errors"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/sqlparser/depends/common"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+const (
+	// timeIntervalMonth is the monthly time partition.
+	timeIntervalMonth = "MONTH"
+	// timeIntervalDay is the daily time partition.
+	timeIntervalDay = "DAY"
+
+	// timeSegmentFormat is the format of the segment, which is the start of the period.
+	timeSegmentFormat = "2006-01-02"
+
+	// timeMonthAhead is the number of the future monthly partitions created in advance.
+	timeMonthAhead = 2
+	// timeDayAhead is the number of the future daily partitions created in advance.
+	timeDayAhead = 7
+	// timeMaxBackfill is the max number of the past partitions created for the older data.
+	timeMaxBackfill = 1000
+)
+
+// timeKeyFormats are the layouts of the DATE/DATETIME value.
+var timeKeyFormats = []string{
+	"2006-01-02",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04:05.999999",
+	"2006-01-02T15:04:05",
+	"20060102",
+	"20060102150405",
+}
+
+// Time tuple.
+// The PartitionConfig.Segment is the start date of the period, such as '2019-01-01',
+// the partition holds the rows in [start, start+interval), the segments must be in ascending order.
+type Time struct {
+	log *xlog.Log
+
+	// time method.
+	typ MethodType
+
+	// table config.
+	conf *config.TableConfig
+
+	// interval, MONTH or DAY.
+	interval string
+
+	// starts of the periods.
+	starts []time.Time
+
+	// Segments slice.
+	Segments []Segment `json:",omitempty"`
+}
+
+// NewTime creates new time.
+func NewTime(log *xlog.Log, conf *config.TableConfig) *Time {
+	return &Time{
+		log:      log,
+		conf:     conf,
+		typ:      methodTypeTime,
+		Segments: make([]Segment, 0, 16),
+	}
+}
+
+// isTimeInterval returns true if the interval is supported.
+func isTimeInterval(interval string) bool {
+	switch strings.ToUpper(interval) {
+	case timeIntervalMonth, timeIntervalDay:
+		return true
+	}
+	return false
+}
+
+// timeTruncate returns the start of the period which the t belongs to.
+func timeTruncate(t time.Time, interval string) time.Time {
+	if interval == timeIntervalMonth {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// timeNext returns the start of the next period.
+func timeNext(t time.Time, interval string) time.Time {
+	if interval == timeIntervalMonth {
+		return t.AddDate(0, 1, 0)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+// timeAdd returns the start of the period n periods after t, n can be negative.
+func timeAdd(t time.Time, interval string, n int) time.Time {
+	if interval == timeIntervalMonth {
+		return t.AddDate(0, n, 0)
+	}
+	return t.AddDate(0, 0, n)
+}
+
+// timeAhead returns the number of the future partitions created in advance.
+func timeAhead(interval string) int {
+	if interval == timeIntervalMonth {
+		return timeMonthAhead
+	}
+	return timeDayAhead
+}
+
+// timeTableSuffix returns the partition table suffix of the period, such as 201901 or 20190101.
+func timeTableSuffix(t time.Time, interval string) string {
+	if interval == timeIntervalMonth {
+		return t.Format("200601")
+	}
+	return t.Format("20060102")
+}
+
+// Build used to build the time segments from schema config.
+func (t *Time) Build() error {
+	if t.conf == nil {
+		return errors.New("table.config..can't.be.nil")
+	}
+	if len(t.conf.Partitions) == 0 {
+		return errors.New("time.partitions.can't.be.null")
+	}
+	t.interval = strings.ToUpper(t.conf.Interval)
+	if !isTimeInterval(t.interval) {
+		return errors.Errorf("time.unsupported.interval:[%v]", t.conf.Interval)
+	}
+
+	for i, part := range t.conf.Partitions {
+		start, err := time.Parse(timeSegmentFormat, strings.TrimSpace(part.Segment))
+		if err != nil {
+			return errors.Errorf("time.partition[%v].segment[%v].invalid", part.Table, part.Segment)
+		}
+		if !start.Equal(timeTruncate(start, t.interval)) {
+			return errors.Errorf("time.partition[%v].segment[%v].must.be.the.start.of.%v", part.Table, part.Segment, strings.ToLower(t.interval))
+		}
+		if i > 0 && !start.After(t.starts[i-1]) {
+			return errors.Errorf("time.partition.segment[%v].must.be.greater.than[%v]", part.Segment, t.conf.Partitions[i-1].Segment)
+		}
+		t.starts = append(t.starts, start)
+		t.Segments = append(t.Segments, Segment{
+			Table:   part.Table,
+			Backend: part.Backend,
+			Range: &RangeInterval{
+				Start: start.Format(timeSegmentFormat),
+				End:   timeNext(start, t.interval).Format(timeSegmentFormat),
+			},
+		})
+	}
+	return nil
+}
+
+// parseTimeKey parses the DATE/DATETIME value by the timeKeyFormats.
+func parseTimeKey(s string) (time.Time, bool) {
+	for _, layout := range timeKeyFormats {
+		if v, err := time.Parse(layout, s); err == nil {
+			return v, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parse returns the start of the period which the key belongs to.
+func (t *Time) parse(sqlval *sqlparser.SQLVal) (time.Time, error) {
+	switch sqlval.Type {
+	case sqlparser.IntVal, sqlparser.StrVal:
+		valStr := strings.TrimSpace(common.BytesToString(sqlval.Val))
+		if v, ok := parseTimeKey(valStr); ok {
+			return timeTruncate(v, t.interval), nil
+		}
+		return time.Time{}, errors.Errorf("time.getindex.key[%s].parser.time.error", valStr)
+	}
+	return time.Time{}, errors.Errorf("time.unsupported.key.type:[%v]", sqlval.Type)
+}
+
+// compareValue compares the sharding keys x and y by the periods of them.
+func (t *Time) compareValue(x, y *sqlparser.SQLVal) (int, error) {
+	px, err := t.parse(x)
+	if err != nil {
+		return 0, err
+	}
+	py, err := t.parse(y)
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case px.Before(py):
+		return -1, nil
+	case px.After(py):
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// search returns the first partition index whose start is not less than the period.
+func (t *Time) search(period time.Time) int {
+	return sort.Search(len(t.starts), func(i int) bool {
+		return !t.starts[i].Before(period)
+	})
+}
+
+// Lookup used to lookup partition(s) through the sharding-key range [start, end].
+// If start or end is nil, the interval is open on this side.
+func (t *Time) Lookup(start *sqlparser.SQLVal, end *sqlparser.SQLVal) ([]Segment, error) {
+	if start == nil && end == nil {
+		return t.Segments, nil
+	}
+
+	// Point lookup.
+	if start != nil && end != nil && start.Type == end.Type && bytes.Equal(start.Val, end.Val) {
+		idx, err := t.GetIndex(start)
+		if err != nil {
+			return nil, err
+		}
+		return []Segment{t.Segments[idx]}, nil
+	}
+
+	lo, hi := 0, len(t.Segments)-1
+	if start != nil {
+		period, err := t.parse(start)
+		if err != nil {
+			return nil, err
+		}
+		lo = t.search(period)
+	}
+	if end != nil {
+		period, err := t.parse(end)
+		if err != nil {
+			return nil, err
+		}
+		if idx := t.search(timeNext(period, t.interval)) - 1; idx < hi {
+			hi = idx
+		}
+	}
+	// None partition overlaps the interval, returns one of them
+	// to make sure the query still gets a result set.
+	if lo > hi {
+		if hi < 0 {
+			hi = 0
+		}
+		return t.Segments[hi : hi+1], nil
+	}
+	return t.Segments[lo : hi+1], nil
+}
+
+// Type returns the time type.
+func (t *Time) Type() MethodType {
+	return t.typ
+}
+
+// GetIndex returns index based on sqlval.
+// The key before the first period, in the gap or after the last period is stored in none
+// of the partitions, returns the nearest one(empty route) to make sure the reads still get
+// a result set.
+func (t *Time) GetIndex(sqlval *sqlparser.SQLVal) (int, error) {
+	period, err := t.parse(sqlval)
+	if err != nil {
+		return -1, err
+	}
+	idx := t.search(period)
+	if idx == len(t.starts) {
+		return idx - 1, nil
+	}
+	return idx, nil
+}
+
+// locate returns the index of the partition to store the row with the key,
+// errors if none of the partitions holds the period of the key.
+func (t *Time) locate(sqlval *sqlparser.SQLVal) (int, error) {
+	period, err := t.parse(sqlval)
+	if err != nil {
+		return -1, err
+	}
+	idx := t.search(period)
+	if idx == len(t.starts) || !t.starts[idx].Equal(period) {
+		return -1, errors.Errorf("time.getindex.key[%s].has.no.partition", sqlval.Val)
+	}
+	return idx, nil
+}
+
+// GetSegments returns Segments based on index.
+func (t *Time) GetSegments(index int) []Segment {
+	if index < 0 || index >= len(t.Segments) {
+		return t.Segments
+	}
+	return []Segment{t.Segments[index]}
+}
+
+// aheadPartitions returns the partitions need to be created to cover the periods
+// from now to the ahead ones, the backends are used in turn after the last partition.
+func (t *Time) aheadPartitions(table string, now time.Time) []*config.PartitionConfig {
+	var backends []string
+	seen := make(map[string]bool)
+	for _, part := range t.conf.Partitions {
+		if !seen[part.Backend] {
+			seen[part.Backend] = true
+			backends = append(backends, part.Backend)
+		}
+	}
+	last := t.conf.Partitions[len(t.conf.Partitions)-1]
+	next := 0
+	for i, backend := range backends {
+		if backend == last.Backend {
+			next = i + 1
+		}
+	}
+
+	var parts []*config.PartitionConfig
+	period := timeTruncate(now, t.interval)
+	for i := 0; i <= timeAhead(t.interval); i++ {
+		if !period.After(t.starts[len(t.starts)-1]) {
+			period = timeNext(period, t.interval)
+			continue
+		}
+		parts = append(parts, &config.PartitionConfig{
+			Table:   fmt.Sprintf("%s_%s", table, timeTableSuffix(period, t.interval)),
+			Segment: period.Format(timeSegmentFormat),
+			Backend: backends[next%len(backends)],
+		})
+		next++
+		period = timeNext(period, t.interval)
+	}
+	return parts
+}
@@ -0,0 +1,238 @@
+/*
+ * Radon
+ *
+ * Copyright 2019 The Radon Authors.
+ * Code is licensed under the GPLv3.
+ *
+ */
+
+package router
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/xelabs/go-mysqlstack/sqlparser"
+	"github.com/xelabs/go-mysqlstack/xlog"
+)
+
+func TestTime(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	tim := NewTime(log, MockTableTConfig())
+	err := tim.Build()
+	assert.Nil(t, err)
+	assert.Equal(t, methodTypeTime, string(tim.Type()))
+	assert.Equal(t, 3, len(tim.Segments))
+
+	want := []string{"[2019-01-01-2019-02-01)", "[2019-02-01-2019-03-01)", "[2019-04-01-2019-05-01)"}
+	for i, seg := range tim.Segments {
+		assert.Equal(t, want[i], seg.Range.String())
+	}
+	assert.True(t, tim.Segments[0].Range.Less(tim.Segments[1].Range))
+	assert.False(t, tim.Segments[2].Range.Less(tim.Segments[1].Range))
+}
+
+func TestTimeBuildError(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+
+	// Nil config.
+	{
+		tim := NewTime(log, nil)
+		err := tim.Build()
+		assert.NotNil(t, err)
+	}
+
+	// No partitions.
+	{
+		conf := MockTableTConfig()
+		conf.Partitions = nil
+		tim := NewTime(log, conf)
+		err := tim.Build()
+		want := "time.partitions.can't.be.null"
+		assert.Equal(t, want, err.Error())
+	}
+
+	// Unsupported interval.
+	{
+		conf := MockTableTConfig()
+		conf.Interval = "YEAR"
+		tim := NewTime(log, conf)
+		err := tim.Build()
+		want := "time.unsupported.interval:[YEAR]"
+		assert.Equal(t, want, err.Error())
+	}
+
+	// Invalid segment.
+	{
+		conf := MockTableTConfig()
+		conf.Partitions[1].Segment = "2019-02"
+		tim := NewTime(log, conf)
+		err := tim.Build()
+		want := "time.partition[T_201902].segment[2019-02].invalid"
+		assert.Equal(t, want, err.Error())
+	}
+
+	// Not the start of the month.
+	{
+		conf := MockTableTConfig()
+		conf.Partitions[1].Segment = "2019-02-02"
+		tim := NewTime(log, conf)
+		err := tim.Build()
+		want := "time.partition[T_201902].segment[2019-02-02].must.be.the.start.of.month"
+		assert.Equal(t, want, err.Error())
+	}
+
+	// Not ascending.
+	{
+		conf := MockTableTConfig()
+		conf.Partitions[1].Segment = "2019-01-01"
+		tim := NewTime(log, conf)
+		err := tim.Build()
+		want := "time.partition.segment[2019-01-01].must.be.greater.than[2019-01-01]"
+		assert.Equal(t, want, err.Error())
+	}
+}
+
+func TestTimeGetIndex(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	tim := NewTime(log, MockTableTConfig())
+	err := tim.Build()
+	assert.Nil(t, err)
+
+	tests := []struct {
+		val *sqlparser.SQLVal
+		idx int
+	}{
+		{sqlparser.NewStrVal([]byte("2019-01-01")), 0},
+		{sqlparser.NewStrVal([]byte("2019-01-31 23:59:59")), 0},
+		{sqlparser.NewStrVal([]byte("2019-02-15 10:00:00.123")), 1},
+		{sqlparser.NewStrVal([]byte("2019-04-30T08:00:00")), 2},
+		{sqlparser.NewIntVal([]byte("20190201")), 1},
+		{sqlparser.NewIntVal([]byte("20190401120000")), 2},
+	}
+	for _, test := range tests {
+		idx, err := tim.GetIndex(test.val)
+		assert.Nil(t, err)
+		assert.Equal(t, test.idx, idx)
+		assert.Equal(t, 1, len(tim.GetSegments(idx)))
+	}
+	assert.Equal(t, 3, len(tim.GetSegments(-1)))
+
+	// The key out of the periods goes to the empty route, but the row can't be stored.
+	for _, test := range []struct {
+		key string
+		idx int
+	}{
+		{"2018-12-31", 0},
+		{"2019-03-01", 2},
+		{"2019-05-01", 2},
+	} {
+		idx, err := tim.GetIndex(sqlparser.NewStrVal([]byte(test.key)))
+		assert.Nil(t, err)
+		assert.Equal(t, test.idx, idx)
+
+		_, err = tim.locate(sqlparser.NewStrVal([]byte(test.key)))
+		want := "time.getindex.key[" + test.key + "].has.no.partition"
+		assert.Equal(t, want, err.Error())
+	}
+
+	// Errors.
+	{
+		_, err := tim.GetIndex(sqlparser.NewStrVal([]byte("xx")))
+		want := "time.getindex.key[xx].parser.time.error"
+		assert.Equal(t, want, err.Error())
+
+		_, err = tim.GetIndex(sqlparser.NewFloatVal([]byte("1.1")))
+		want = "time.unsupported.key.type:[2]"
+		assert.Equal(t, want, err.Error())
+	}
+}
+
+func TestTimeLookup(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	tim := NewTime(log, MockTableTConfig())
+	err := tim.Build()
+	assert.Nil(t, err)
+
+	val := func(s string) *sqlparser.SQLVal {
+		return sqlparser.NewStrVal([]byte(s))
+	}
+	tests := []struct {
+		start  *sqlparser.SQLVal
+		end    *sqlparser.SQLVal
+		tables []string
+	}{
+		{nil, nil, []string{"T_201901", "T_201902", "T_201904"}},
+		{val("2019-02-10"), val("2019-02-10"), []string{"T_201902"}},
+		{val("2019-01-10"), val("2019-02-10"), []string{"T_201901", "T_201902"}},
+		{val("2019-02-10"), nil, []string{"T_201902", "T_201904"}},
+		{nil, val("2019-03-31"), []string{"T_201901", "T_201902"}},
+		{val("2019-03-01"), val("2019-03-31"), []string{"T_201902"}},
+		{nil, val("2018-12-31"), []string{"T_201901"}},
+		{val("2019-06-01"), nil, []string{"T_201904"}},
+		// The point in the gap gets the empty route.
+		{val("2019-03-01"), val("2019-03-01"), []string{"T_201904"}},
+	}
+	for _, test := range tests {
+		segments, err := tim.Lookup(test.start, test.end)
+		assert.Nil(t, err)
+		var got []string
+		for _, segment := range segments {
+			got = append(got, segment.Table)
+		}
+		assert.Equal(t, test.tables, got)
+	}
+
+	// Errors.
+	{
+		_, err := tim.Lookup(val("xx"), nil)
+		assert.NotNil(t, err)
+
+		_, err = tim.Lookup(nil, val("xx"))
+		assert.NotNil(t, err)
+	}
+}
+
+func TestTimeDay(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	conf := MockTableTConfig()
+	conf.Interval = "day"
+	conf.Partitions[0].Segment = "2019-01-30"
+	conf.Partitions[1].Segment = "2019-01-31"
+	conf.Partitions[2].Segment = "2019-02-01"
+	tim := NewTime(log, conf)
+	err := tim.Build()
+	assert.Nil(t, err)
+	assert.Equal(t, "[2019-01-31-2019-02-01)", tim.Segments[1].Range.String())
+
+	idx, err := tim.GetIndex(sqlparser.NewStrVal([]byte("2019-02-01 00:00:01")))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, idx)
+}
+
+func TestTimeAheadPartitions(t *testing.T) {
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	tim := NewTime(log, MockTableTConfig())
+	err := tim.Build()
+	assert.Nil(t, err)
+
+	// Covered.
+	{
+		now := time.Date(2019, 2, 10, 0, 0, 0, 0, time.UTC)
+		parts := tim.aheadPartitions("T", now)
+		assert.Equal(t, 0, len(parts))
+	}
+
+	// The next months.
+	{
+		now := time.Date(2019, 4, 10, 0, 0, 0, 0, time.UTC)
+		parts := tim.aheadPartitions("T", now)
+		assert.Equal(t, 2, len(parts))
+		assert.Equal(t, "T_201905", parts[0].Table)
+		assert.Equal(t, "2019-05-01", parts[0].Segment)
+		assert.Equal(t, "backend1", parts[0].Backend)
+		assert.Equal(t, "T_201906", parts[1].Table)
+		assert.Equal(t, "backend0", parts[1].Backend)
+	}
+}
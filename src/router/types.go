@@ -15,4 +15,8 @@ const (
 	// methodTypeHash type.
 	methodTypeHash   = "HASH"
 	methodTypeGlobal = "GLOBAL"
+	methodTypeRange  = "RANGE"
+	methodTypeList   = "LIST"
+	methodTypeTime   = "TIME"
+	methodTypeSingle = "SINGLE"
 )
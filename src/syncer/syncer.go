@@ -19,6 +19,7 @@ import (
 	"config"
 	"router"
 	"xbase"
+	"xbase/sync2"
 
 	"github.com/xelabs/go-mysqlstack/xlog"
 )
@@ -34,6 +35,9 @@ type Syncer struct {
 	ticker  *time.Ticker
 	router  *router.Router
 	scatter *backend.Scatter
+
+	// owner is the smallest of the alive peers in the last check.
+	owner sync2.AtomicString
 }
 
 // NewSyncer creates the new syncer.
@@ -103,6 +107,23 @@ func (s *Syncer) Peers() []string {
 	return s.peer.Clone()
 }
 
+// IsOwner returns true if this node is the owner to run the cluster-wide jobs, such as
+// the time partition check, the others sync the metadata from it.
+// The owner is the smallest of the alive peers, before the first check it's the smallest of all.
+func (s *Syncer) IsOwner() bool {
+	self := s.peer.self
+	owner := s.owner.Get()
+	if owner == "" {
+		owner = self
+		for _, peer := range s.peer.Clone() {
+			if peer < owner {
+				owner = peer
+			}
+		}
+	}
+	return owner == self
+}
+
 // RLock used to acquire the lock of syncer.
 func (s *Syncer) RLock() {
 	s.mu.RLock()
@@ -119,6 +140,8 @@ func (s *Syncer) check() {
 	maxPeer := ""
 	self := s.peer.self
 	peers := s.peer.Clone()
+	owner := self
+	defer func() { s.owner.Set(owner) }()
 	for _, peer := range peers {
 		if peer != self {
 			versionURL := "http://" + path.Join(peer, versionRestURL)
@@ -127,6 +150,9 @@ func (s *Syncer) check() {
 				log.Error("syncer.check.version.get[%s].error:%+v", peerVerStr, err)
 				continue
 			}
+			if peer < owner {
+				owner = peer
+			}
 
 			version := &config.Version{}
 			if err := json.Unmarshal([]byte(peerVerStr), version); err != nil {
@@ -27,6 +27,34 @@ func TestSyncer(t *testing.T) {
 	defer cleanup()
 }
 
+func TestSyncerIsOwner(t *testing.T) {
+	defer leaktest.Check(t)()
+	defer testRemoveMetadir()
+	log := xlog.NewStdLog(xlog.Level(xlog.PANIC))
+	syncers, cleanup := mockSyncer(log, 3)
+	assert.NotNil(t, syncers)
+	defer cleanup()
+
+	// Only the smallest peer is the owner, before and after the check.
+	for i := 0; i < 2; i++ {
+		assert.True(t, syncers[0].IsOwner())
+		assert.False(t, syncers[1].IsOwner())
+		assert.False(t, syncers[2].IsOwner())
+		time.Sleep(time.Second)
+	}
+
+	// The dead peer isn't the owner.
+	{
+		dead := "127.0.0.1:1"
+		err := syncers[1].AddPeer(dead)
+		assert.Nil(t, err)
+		syncers[1].check()
+		assert.Equal(t, "127.0.0.1:8081", syncers[1].owner.Get())
+		err = syncers[1].RemovePeer(dead)
+		assert.Nil(t, err)
+	}
+}
+
 func TestSyncerLock(t *testing.T) {
 	defer leaktest.Check(t)()
 	defer testRemoveMetadir()